@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	agentCPUSet   string
+	agentNUMANode int
+)
+
+// numaNodePath is swapped by tests.
+var numaNodePath = "/sys/devices/system/node"
+
+func checkAgentAffinityFlags() error {
+	if agentCPUSet != "" {
+		if _, err := parseCPUList(agentCPUSet); err != nil {
+			return fmt.Errorf("invalid --agent-cpuset: %w", err)
+		}
+	}
+
+	if agentNUMANode < -1 {
+		return fmt.Errorf("--agent-numa-node must not be negative")
+	}
+
+	return nil
+}
+
+// parseCPUList parses the kernel cpu list format, e.g. "0-3,8,10-11".
+func parseCPUList(list string) ([]int, error) {
+	seen := map[int]bool{}
+
+	for _, part := range strings.Split(strings.TrimSpace(list), ",") {
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(from)
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid cpu %q", part)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(to); err != nil || last < first {
+				return nil, fmt.Errorf("invalid cpu range %q", part)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			seen[cpu] = true
+		}
+	}
+
+	if len(seen) == 0 {
+		return nil, fmt.Errorf("no cpus in %q", list)
+	}
+
+	cpus := make([]int, 0, len(seen))
+	for cpu := range seen {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+
+	return cpus, nil
+}
+
+func formatCPUList(cpus []int) string {
+	parts := make([]string, 0, len(cpus))
+	for _, cpu := range cpus {
+		parts = append(parts, strconv.Itoa(cpu))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func numaNodeCPUs(node int) ([]int, error) {
+	data, err := os.ReadFile(filepath.Join(numaNodePath, "node"+strconv.Itoa(node), "cpulist"))
+	if err != nil {
+		return nil, fmt.Errorf("numa node %d not found: %w", node, err)
+	}
+
+	return parseCPUList(string(data))
+}
+
+// agentCPUs returns the cpus the agent is pinned to: the --agent-cpuset
+// cpus, limited to those of --agent-numa-node when both are set. Nil means
+// no pinning.
+func agentCPUs() ([]int, error) {
+	var cpus []int
+
+	if agentCPUSet != "" {
+		var err error
+		if cpus, err = parseCPUList(agentCPUSet); err != nil {
+			return nil, err
+		}
+	}
+
+	if agentNUMANode < 0 {
+		return cpus, nil
+	}
+
+	nodeCPUs, err := numaNodeCPUs(agentNUMANode)
+	if err != nil {
+		return nil, err
+	}
+
+	if cpus == nil {
+		return nodeCPUs, nil
+	}
+
+	onNode := map[int]bool{}
+	for _, cpu := range nodeCPUs {
+		onNode[cpu] = true
+	}
+
+	var both []int
+	for _, cpu := range cpus {
+		if onNode[cpu] {
+			both = append(both, cpu)
+		}
+	}
+
+	if len(both) == 0 {
+		return nil, fmt.Errorf("--agent-cpuset %s has no cpu on numa node %d", agentCPUSet, agentNUMANode)
+	}
+
+	return both, nil
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// agentCommand wraps the agent in numactl for --agent-numa-node, so its
+// memory is bound to the node as well. numactl sets the cpus too, pinned
+// reports that no affinity needs to be applied after the start.
+func agentCommand(agentPath string) (cmd *exec.Cmd, pinned bool, err error) {
+	if agentNUMANode < 0 {
+		return exec.Command(agentPath), false, nil
+	}
+
+	numactl, err := exec.LookPath("numactl")
+	if err != nil {
+		warn("agent.no_numactl", "node", agentNUMANode)
+		return exec.Command(agentPath), false, nil
+	}
+
+	cpus, err := agentCPUs()
+	if err != nil {
+		return nil, false, err
+	}
+
+	return exec.Command(numactl, "--membind="+strconv.Itoa(agentNUMANode), "--physcpubind="+formatCPUList(cpus), agentPath), true, nil
+}
+
+// pinAgentProcess sets the cpu affinity of a started agent, children it
+// forks later inherit it.
+func pinAgentProcess(proc *os.Process) error {
+	cpus, err := agentCPUs()
+	if err != nil || cpus == nil {
+		return err
+	}
+
+	var set unix.CPUSet
+	set.Zero()
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+
+	if err := unix.SchedSetaffinity(proc.Pid, &set); err != nil {
+		return fmt.Errorf("set cpu affinity failed: %w", err)
+	}
+
+	logVerbose("agent pinned to cpus %s", formatCPUList(cpus))
+
+	return nil
+}
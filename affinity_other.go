@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+func agentCommand(agentPath string) (*exec.Cmd, bool, error) {
+	return exec.Command(agentPath), false, nil
+}
+
+func pinAgentProcess(_ *os.Process) error {
+	if agentCPUSet != "" || agentNUMANode >= 0 {
+		warn("agent.pin_linux")
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCPUList(t *testing.T) {
+	cpus, err := parseCPUList("8,0-3,2,10-11\n")
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 8, 10, 11}, cpus)
+	assert.Equal(t, "0,1,2,3,8,10,11", formatCPUList(cpus))
+
+	for _, invalid := range []string{"", "a", "3-1", "-1", "0-"} {
+		_, err := parseCPUList(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestAgentCPUs(t *testing.T) {
+	defer func(cpuset string, node int, path string) {
+		agentCPUSet, agentNUMANode, numaNodePath = cpuset, node, path
+	}(agentCPUSet, agentNUMANode, numaNodePath)
+
+	numaNodePath = t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(numaNodePath, "node1"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(numaNodePath, "node1", "cpulist"), []byte("4-7\n"), 0644))
+
+	agentCPUSet, agentNUMANode = "", -1
+	cpus, err := agentCPUs()
+	require.NoError(t, err)
+	assert.Nil(t, cpus)
+
+	agentCPUSet = "0-1"
+	cpus, err = agentCPUs()
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1}, cpus)
+
+	agentCPUSet, agentNUMANode = "", 1
+	cpus, err = agentCPUs()
+	require.NoError(t, err)
+	assert.Equal(t, []int{4, 5, 6, 7}, cpus)
+
+	agentCPUSet = "2-5"
+	cpus, err = agentCPUs()
+	require.NoError(t, err)
+	assert.Equal(t, []int{4, 5}, cpus)
+
+	agentCPUSet = "0-1"
+	_, err = agentCPUs()
+	assert.ErrorContains(t, err, "has no cpu on numa node 1")
+
+	agentNUMANode = 3
+	_, err = agentCPUs()
+	assert.ErrorContains(t, err, "numa node 3 not found")
+}
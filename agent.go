@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	superviseMinBackoff = time.Second
+	superviseMaxBackoff = 5 * time.Minute
+	// superviseStableRun resets the backoff once the agent stayed up this long
+	superviseStableRun = time.Minute
+)
+
+var (
+	agentMemoryLimit int64
+	agentCPURate     int
+	superviseAgentOn bool
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "manage the background agent",
+}
+
+var agentStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "start the agent in background",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := prepareAgentCommand(); err != nil {
+			exitWithError(err)
+		}
+		var err error
+		if superviseAgentOn && shouldDetach() {
+			err = startDetachedSupervisor(cmd.Flags())
+		} else if superviseAgentOn {
+			err = superviseAgent(context.Background())
+		} else if err = runAgent(); err == nil {
+			err = installWatchdog()
+		}
+		if err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+var agentStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "stop the background agent and its children",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := prepareAgentCommand(); err != nil {
+			exitWithError(err)
+		}
+		if err := stopAgent(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	agentCmd.PersistentFlags().StringVar(&distbuildPath, "distbuild-path", "", "distbuild binaries path")
+	_ = agentCmd.MarkPersistentFlagRequired("distbuild-path")
+
+	addAgentLimitFlags(agentStartCmd)
+	addAgentLogFlags(agentStartCmd)
+	addAgentPortFlag(agentStartCmd)
+	addFirewallFlags(agentStartCmd)
+	addSuperviseFlag(agentStartCmd)
+	addAgentLabelFlags(agentStartCmd)
+	addAgentEnvFlags(agentStartCmd)
+	addWatchdogFlags(agentStartCmd)
+
+	agentCmd.AddCommand(agentStartCmd)
+	agentCmd.AddCommand(agentStopCmd)
+	agentCmd.AddCommand(agentUpgradeCmd)
+	agentCmd.AddCommand(agentHealthCmd)
+}
+
+func addAgentLimitFlags(cmd *cobra.Command) {
+	cmd.Flags().Int64Var(&agentMemoryLimit, "agent-memory-limit", 0, "agent memory limit in MB (windows only)")
+	cmd.Flags().IntVar(&agentCPURate, "agent-cpu-rate", 0, "agent cpu rate limit in percent (windows only)")
+	cmd.Flags().StringVar(&agentCPUSet, "agent-cpuset", "", "pin the agent to these cpus, e.g. 0-7,16 (linux only)")
+	cmd.Flags().IntVar(&agentNUMANode, "agent-numa-node", -1, "pin the agent cpus and memory to this numa node, memory binding needs numactl (linux only)")
+}
+
+func addSuperviseFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&superviseAgentOn, "supervise", false, "stay resident and restart the agent when it exits")
+	addDetachFlag(cmd)
+	addHealthFlag(cmd)
+}
+
+// prepareAgentCommand validates the agent flags and loads the env files so
+// agent subcommands see the same configuration as a full bootstrap run.
+func prepareAgentCommand() error {
+	if err := checkAgentFlags(); err != nil {
+		return withCode(errCodeUsage, err)
+	}
+
+	if err := loadEnvFiles(envFile, envFiles); err != nil {
+		return fmt.Errorf("load .env failed: %w", err)
+	}
+
+	return nil
+}
+
+func checkAgentFlags() error {
+	var err error
+
+	distbuildPath, err = expandPath(distbuildPath)
+	if err != nil {
+		return fmt.Errorf("failed to expand path: %w", err)
+	}
+
+	if err := checkWatchdogFlags(); err != nil {
+		return err
+	}
+
+	if err := checkDetachFlags(); err != nil {
+		return err
+	}
+
+	if err := checkHealthFlags(); err != nil {
+		return err
+	}
+
+	return checkAgentLimits()
+}
+
+func checkAgentLimits() error {
+	if agentCPURate < 0 || agentCPURate > 100 {
+		return fmt.Errorf("--agent-cpu-rate must be between 0 and 100")
+	}
+
+	if agentMemoryLimit < 0 {
+		return fmt.Errorf("--agent-memory-limit must not be negative")
+	}
+
+	if err := checkAgentPortFlag(); err != nil {
+		return err
+	}
+
+	if err := checkAgentAffinityFlags(); err != nil {
+		return err
+	}
+
+	if err := checkAgentEnvFlags(); err != nil {
+		return err
+	}
+
+	return checkAgentLogFlags()
+}
+
+func agentBinaryName() string {
+	if runtime.GOOS == "windows" {
+		return "agent.exe"
+	}
+
+	return "agent"
+}
+
+func agentBinaryPath() string {
+	return filepath.Join(distbuildPath, "boong", "bin", agentBinaryName())
+}
+
+func hasSystemd() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+
+	_, err := os.Stat("/run/systemd/system")
+
+	return err == nil
+}
+
+func runAgent() error {
+	logFile, logPath, err := openAgentLog()
+	if err != nil {
+		return err
+	}
+
+	defer func(logFile *os.File) {
+		_ = logFile.Close()
+	}(logFile)
+
+	state, err := loadAgentState()
+	if err != nil {
+		return err
+	}
+
+	port, err := resolveAgentPort(state.Port)
+	if err != nil {
+		return err
+	}
+
+	cmd, err := startAgentProcess(logFile, agentBinaryPath(), port)
+	if err != nil {
+		return err
+	}
+
+	printMsg("agent.started", "pid", cmd.Process.Pid, "port", port, "log", logPath)
+
+	openAgentEvents()
+	defer closeAgentEvents()
+	reportAgentEvent(agentEventInfo, "agent started (pid %d, port %d)", cmd.Process.Pid, port)
+
+	if err := saveAgentState(&agentState{PID: cmd.Process.Pid, Port: port}); err != nil {
+		return err
+	}
+
+	checkAgentFirewall(port)
+
+	return cmd.Process.Release()
+}
+
+// agentRunning reports an agent that already runs the installed binary, a
+// converged host keeps it rather than starting another.
+func agentRunning(binaryChanged bool) (bool, error) {
+	pid := runningAgentPid()
+	if pid == 0 || binaryChanged {
+		return false, nil
+	}
+
+	state, err := loadAgentState()
+	if err != nil {
+		return false, err
+	}
+
+	printMsg("agent.running", "pid", pid, "port", state.Port)
+
+	return true, nil
+}
+
+// ensureAgentRunning starts the agent unless one already runs the installed
+// binary.
+func ensureAgentRunning(binaryChanged bool) error {
+	if running, err := agentRunning(binaryChanged); err != nil || running {
+		return err
+	}
+
+	if err := runAgent(); err != nil {
+		return err
+	}
+
+	noteChange("start agent")
+
+	return nil
+}
+
+// superviseAgent keeps the agent running in the foreground, restarting it
+// with exponential backoff until bootstrap is interrupted.
+func superviseAgent(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logFile, logPath, err := openAgentLog()
+	if err != nil {
+		return err
+	}
+
+	// The log is reopened when it rotates between restarts
+	defer func() {
+		_ = logFile.Close()
+	}()
+
+	openAgentEvents()
+	defer closeAgentEvents()
+
+	printMsg("agent.supervising", "log", logPath)
+
+	state, err := loadAgentState()
+	if err != nil {
+		return err
+	}
+
+	stopHealth, err := serveHealth("supervise")
+	if err != nil {
+		return err
+	}
+	defer stopHealth()
+	restarts := 0
+
+	backoff := superviseMinBackoff
+	// The firewall is checked again only when the agent moved ports
+	firewallPort := 0
+
+	for {
+		if logFile, err = reopenAgentLog(logFile); err != nil {
+			return err
+		}
+
+		cmd, err := startSupervisedAgent(logFile, state)
+		if err != nil {
+			logAgentEvent(logFile, agentEventError, "agent start failed: %v", err)
+			setHealth("backoff", false, map[string]any{"restarts": restarts, "error": err.Error(), "backoff": backoff.String()})
+		} else {
+			logAgentEvent(logFile, agentEventInfo, "agent started (pid %d, port %d)", cmd.Process.Pid, state.Port)
+			setHealth("running", true, map[string]any{"pid": cmd.Process.Pid, "port": state.Port, "restarts": restarts})
+			if state.Port != firewallPort {
+				checkAgentFirewall(state.Port)
+				firewallPort = state.Port
+			}
+			started := time.Now()
+			exited := make(chan error, 1)
+			go func() {
+				exited <- cmd.Wait()
+			}()
+			watchCtx, stopWatch := context.WithCancel(ctx)
+			if supervisedWatchdog() {
+				go watchAgentHealth(watchCtx, logFile, cmd.Process, state.Port, started)
+			}
+			select {
+			case <-ctx.Done():
+				stopWatch()
+				_ = terminateAgentProcess(cmd.Process, state.Port)
+				<-exited
+				logAgentEvent(logFile, agentEventInfo, "supervisor stopped")
+				return nil
+			case err := <-exited:
+				stopWatch()
+				if time.Since(started) >= superviseStableRun {
+					backoff = superviseMinBackoff
+				}
+				level := agentEventWarning
+				if err != nil {
+					level = agentEventError
+				}
+				logAgentEvent(logFile, level, "agent exited (%v), restarting in %s", err, backoff)
+				details := map[string]any{"restarts": restarts, "backoff": backoff.String()}
+				if err != nil {
+					details["error"] = err.Error()
+				}
+				setHealth("backoff", false, details)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			logAgentEvent(logFile, agentEventInfo, "supervisor stopped")
+			return nil
+		case <-time.After(backoff):
+		}
+
+		backoff = nextBackoff(backoff)
+		restarts++
+	}
+}
+
+// startSupervisedAgent checks the port before every (re)start, with
+// --agent-port auto the agent may move to another port, which is recorded.
+func startSupervisedAgent(logFile *os.File, state *agentState) (*exec.Cmd, error) {
+	port, err := resolveAgentPort(state.Port)
+	if err != nil {
+		return nil, err
+	}
+
+	if port != state.Port {
+		state.Port = port
+		if err := saveAgentState(&agentState{Port: port}); err != nil {
+			return nil, err
+		}
+	}
+
+	return startAgentProcess(logFile, agentBinaryPath(), port)
+}
+
+func nextBackoff(current time.Duration) time.Duration {
+	next := current * 2
+	if next > superviseMaxBackoff {
+		return superviseMaxBackoff
+	}
+
+	return next
+}
+
+func logSupervisorEvent(w io.Writer, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Println(msg)
+	_, _ = fmt.Fprintf(w, "%s supervisor: %s\n", time.Now().Format(time.RFC3339), msg)
+}
+
+func openAgentLog() (*os.File, string, error) {
+	logPath := stateFile(distbuildPath, "agent.log")
+	if err := createDirs(filepath.Dir(logPath)); err != nil {
+		return nil, "", fmt.Errorf("create state directory failed: %w", err)
+	}
+
+	if err := rotateAgentLog(logPath); err != nil {
+		warn("agent.rotate_log", "err", err)
+	}
+
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, policyFileMode(0644))
+	if err != nil {
+		return nil, "", fmt.Errorf("open agent log failed: %w", err)
+	}
+
+	return logFile, logPath, nil
+}
+
+func startAgentProcess(logFile *os.File, agentPath string, port int) (*exec.Cmd, error) {
+	if _, err := os.Stat(agentPath); err != nil {
+		return nil, fmt.Errorf("agent binary not found: %w", err)
+	}
+
+	labels, err := agentLabelString()
+	if err != nil {
+		return nil, err
+	}
+
+	logVerbose("agent labels: %s", labels)
+
+	cmd, pinned, err := agentCommand(agentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.Env = agentEnvironment(os.Environ(),
+		agentLabelsEnv+"="+labels,
+		agentPortEnv+"="+strconv.Itoa(port),
+	)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	configureAgentProcess(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start agent failed: %w", err)
+	}
+
+	if !pinned {
+		if err := pinAgentProcess(cmd.Process); err != nil {
+			_ = cmd.Process.Kill()
+			return nil, err
+		}
+	}
+
+	if err := attachAgentProcess(cmd.Process, port); err != nil {
+		_ = cmd.Process.Kill()
+		return nil, fmt.Errorf("attach agent process failed: %w", err)
+	}
+
+	if err := writeAgentPidfile(cmd.Process.Pid); err != nil {
+		warn("agent.pidfile", "err", err)
+	}
+
+	return cmd, nil
+}
+
+func stopAgent() error {
+	ports, err := agentPorts()
+	if err != nil {
+		return err
+	}
+
+	// The pidfile finds an agent whatever binary it runs from, the binary
+	// paths catch agents started before it existed
+	stopped := false
+	if pid := runningAgentPid(); pid > 0 {
+		state, err := loadAgentState()
+		if err != nil {
+			return err
+		}
+		if proc, err := os.FindProcess(pid); err == nil {
+			stopped = terminateAgentProcess(proc, state.Port) == nil
+		}
+	}
+
+	err = stopAgentProcesses([]string{agentBinaryPath(), upgradeBinaryPath()}, ports)
+	if err != nil && !(stopped && errors.Is(err, errAgentNotRunning)) {
+		return fmt.Errorf("stop agent failed: %w", err)
+	}
+
+	removeAgentPidfile()
+
+	printMsg("agent.stopped")
+
+	return nil
+}
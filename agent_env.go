@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultAgentEnv is what the agent inherits from bootstrap, everything
+// else, credentials in particular, stays out of the long-lived process.
+// Entries may be globs. AGENT_ENV_ALLOWLIST adds to it.
+var defaultAgentEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "LANG", "LC_*", "TZ", "TMPDIR",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+	"SCHEDULER_ENDPOINTS", "CCACHE_*", "REMOTE_CACHE_ENDPOINT", "DISTBUILD_*", "AGENT_WORK_DIR",
+	// Needed by any process on windows
+	"SYSTEMROOT", "SYSTEMDRIVE", "WINDIR", "COMSPEC", "PATHEXT", "TEMP", "TMP",
+	"USERPROFILE", "APPDATA", "LOCALAPPDATA", "PROGRAMDATA", "NUMBER_OF_PROCESSORS", "PROCESSOR_ARCHITECTURE",
+}
+
+var agentExtraEnv []string
+
+func addAgentEnvFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&agentExtraEnv, "agent-env", nil, "extra KEY=VALUE for the agent environment (repeatable)")
+}
+
+func checkAgentEnvFlags() error {
+	for _, entry := range agentExtraEnv {
+		if key, _, ok := strings.Cut(entry, "="); !ok || strings.TrimSpace(key) == "" {
+			return fmt.Errorf("invalid --agent-env %q, want KEY=VALUE", entry)
+		}
+	}
+
+	return nil
+}
+
+func agentEnvAllowlist() []string {
+	allowlist := append([]string(nil), defaultAgentEnv...)
+
+	for _, entry := range strings.Split(os.Getenv("AGENT_ENV_ALLOWLIST"), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			allowlist = append(allowlist, entry)
+		}
+	}
+
+	return allowlist
+}
+
+func allowedAgentEnv(key string, allowlist []string) bool {
+	// Environment names are case insensitive on windows
+	if runtime.GOOS == "windows" {
+		key = strings.ToUpper(key)
+	}
+
+	for _, pattern := range allowlist {
+		if runtime.GOOS == "windows" {
+			pattern = strings.ToUpper(pattern)
+		}
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// agentEnvironment builds the agent environment from environ: the allowed
+// variables, then the --agent-env ones, then the vars bootstrap hands the
+// agent itself. Later entries win.
+func agentEnvironment(environ []string, injected ...string) []string {
+	allowlist := agentEnvAllowlist()
+
+	var env []string
+	for _, entry := range environ {
+		if key, _, ok := strings.Cut(entry, "="); ok && allowedAgentEnv(key, allowlist) {
+			env = append(env, entry)
+		}
+	}
+
+	env = append(env, agentExtraEnv...)
+	env = append(env, injected...)
+
+	return dedupEnv(env)
+}
+
+// dedupEnv keeps the last value of every key.
+func dedupEnv(env []string) []string {
+	values := map[string]string{}
+	for _, entry := range env {
+		key, value, _ := strings.Cut(entry, "=")
+		values[key] = value
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	deduped := make([]string, 0, len(keys))
+	for _, key := range keys {
+		deduped = append(deduped, key+"="+values[key])
+	}
+
+	return deduped
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgentEnvironment(t *testing.T) {
+	defer func(extra []string) {
+		agentExtraEnv = extra
+	}(agentExtraEnv)
+
+	t.Setenv("AGENT_ENV_ALLOWLIST", "GOMAXPROCS, BUILD_*")
+	agentExtraEnv = []string{"TZ=UTC", "AGENT_MODE=fast"}
+
+	environ := []string{
+		"PATH=/usr/bin",
+		"AUTH_USER=ci",
+		"AUTH_PASS=secret",
+		"CLIENT_KEY=/etc/key.pem",
+		"LC_ALL=C",
+		"TZ=Europe/Berlin",
+		"GOMAXPROCS=8",
+		"BUILD_ID=42",
+		"DISTBUILD_AGENT_PORT=1",
+	}
+
+	env := agentEnvironment(environ, "DISTBUILD_AGENT_PORT=9527")
+
+	assert.Equal(t, []string{
+		"AGENT_MODE=fast",
+		"BUILD_ID=42",
+		"DISTBUILD_AGENT_PORT=9527",
+		"GOMAXPROCS=8",
+		"LC_ALL=C",
+		"PATH=/usr/bin",
+		"TZ=UTC",
+	}, env)
+}
+
+func TestCheckAgentEnvFlags(t *testing.T) {
+	defer func(extra []string) {
+		agentExtraEnv = extra
+	}(agentExtraEnv)
+
+	agentExtraEnv = []string{"A=1", "B="}
+	assert.NoError(t, checkAgentEnvFlags())
+
+	agentExtraEnv = []string{"A"}
+	assert.Error(t, checkAgentEnvFlags())
+
+	agentExtraEnv = []string{"=1"}
+	assert.Error(t, checkAgentEnvFlags())
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+const agentEventSource = "distbuild-agent"
+
+var (
+	agentLogMaxSizeMB int64
+	agentLogKeep      int
+	agentEventLogOn   bool
+)
+
+// agentEventLevel maps lifecycle events onto event log severities.
+type agentEventLevel int
+
+const (
+	agentEventInfo agentEventLevel = iota
+	agentEventWarning
+	agentEventError
+)
+
+// agentEventSink receives agent lifecycle events besides the file log, the
+// Windows Event Log implements it.
+type agentEventSink interface {
+	report(level agentEventLevel, msg string) error
+	Close() error
+}
+
+var agentEvents agentEventSink
+
+func addAgentLogFlags(cmd *cobra.Command) {
+	cmd.Flags().Int64Var(&agentLogMaxSizeMB, "agent-log-max-size", 50, "rotate the agent log once it reaches this many MB (0 disables)")
+	cmd.Flags().IntVar(&agentLogKeep, "agent-log-keep", 3, "rotated agent logs to keep")
+	cmd.Flags().BoolVar(&agentEventLogOn, "event-log", false, "also report agent start, crash and restart to the Windows Event Log")
+}
+
+func checkAgentLogFlags() error {
+	if agentLogMaxSizeMB < 0 {
+		return fmt.Errorf("--agent-log-max-size must not be negative")
+	}
+
+	if agentLogKeep < 0 {
+		return fmt.Errorf("--agent-log-keep must not be negative")
+	}
+
+	return nil
+}
+
+// rotateAgentLog shifts agent.log to agent.log.1 and so on once it reached
+// the size limit, dropping the oldest. The agent holds its log open while
+// it runs, so logs rotate when an agent is (re)started.
+func rotateAgentLog(logPath string) error {
+	if agentLogMaxSizeMB == 0 {
+		return nil
+	}
+
+	info, err := os.Stat(logPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() < agentLogMaxSizeMB*1024*1024 {
+		return nil
+	}
+
+	if agentLogKeep == 0 {
+		return os.Remove(logPath)
+	}
+
+	_ = os.Remove(logPath + "." + strconv.Itoa(agentLogKeep))
+	for i := agentLogKeep - 1; i >= 1; i-- {
+		from := logPath + "." + strconv.Itoa(i)
+		if err := os.Rename(from, logPath+"."+strconv.Itoa(i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return os.Rename(logPath, logPath+".1")
+}
+
+// openAgentEvents connects the event sink selected by --event-log. An
+// unavailable event log is reported once, the file log still works.
+func openAgentEvents() {
+	if !agentEventLogOn || agentEvents != nil {
+		return
+	}
+
+	sink, err := openEventLog(agentEventSource)
+	if err != nil {
+		warn("agent.event_log", "err", err)
+		return
+	}
+
+	agentEvents = sink
+}
+
+func closeAgentEvents() {
+	if agentEvents != nil {
+		_ = agentEvents.Close()
+		agentEvents = nil
+	}
+}
+
+// logAgentEvent writes a lifecycle event to the agent log and the event
+// sink.
+func logAgentEvent(w io.Writer, level agentEventLevel, format string, args ...interface{}) {
+	logSupervisorEvent(w, format, args...)
+	reportAgentEvent(level, format, args...)
+}
+
+// reportAgentEvent writes a lifecycle event to the event sink only.
+func reportAgentEvent(level agentEventLevel, format string, args ...interface{}) {
+	if agentEvents == nil {
+		return
+	}
+
+	if err := agentEvents.report(level, fmt.Sprintf(format, args...)); err != nil {
+		logVerbose("write event log failed: %v", err)
+	}
+}
+
+// reopenAgentLog rotates a log that grew too large between agent restarts
+// and returns the file to log to from now on.
+func reopenAgentLog(logFile *os.File) (*os.File, error) {
+	info, err := logFile.Stat()
+	if err != nil || agentLogMaxSizeMB == 0 || info.Size() < agentLogMaxSizeMB*1024*1024 {
+		return logFile, nil
+	}
+
+	_ = logFile.Close()
+
+	reopened, _, err := openAgentLog()
+
+	return reopened, err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateAgentLog(t *testing.T) {
+	defer func(size int64, keep int) {
+		agentLogMaxSizeMB, agentLogKeep = size, keep
+	}(agentLogMaxSizeMB, agentLogKeep)
+
+	agentLogMaxSizeMB, agentLogKeep = 1, 2
+
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "agent.log")
+	big := bytes.Repeat([]byte("x"), 1024*1024)
+
+	// Below the limit nothing moves
+	require.NoError(t, os.WriteFile(logPath, []byte("small"), 0644))
+	require.NoError(t, rotateAgentLog(logPath))
+	assert.FileExists(t, logPath)
+	assert.NoFileExists(t, logPath+".1")
+
+	for _, generation := range []string{"a", "b", "c"} {
+		require.NoError(t, os.WriteFile(logPath, append([]byte(generation), big...), 0644))
+		require.NoError(t, rotateAgentLog(logPath))
+		assert.NoFileExists(t, logPath)
+	}
+
+	first, err := os.ReadFile(logPath + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, byte('c'), first[0])
+
+	second, err := os.ReadFile(logPath + ".2")
+	require.NoError(t, err)
+	assert.Equal(t, byte('b'), second[0])
+
+	assert.NoFileExists(t, logPath+".3")
+}
+
+type fakeEventSink struct {
+	levels   []agentEventLevel
+	messages []string
+}
+
+func (f *fakeEventSink) report(level agentEventLevel, msg string) error {
+	f.levels = append(f.levels, level)
+	f.messages = append(f.messages, msg)
+	return nil
+}
+
+func (f *fakeEventSink) Close() error {
+	return nil
+}
+
+func TestLogAgentEvent(t *testing.T) {
+	defer func(sink agentEventSink) {
+		agentEvents = sink
+	}(agentEvents)
+
+	sink := &fakeEventSink{}
+	agentEvents = sink
+
+	var log bytes.Buffer
+	captureStdout(t, func() {
+		logAgentEvent(&log, agentEventError, "agent exited (%v), restarting in %s", "exit status 2", "1s")
+	})
+
+	assert.Contains(t, log.String(), "agent exited (exit status 2), restarting in 1s")
+	assert.Equal(t, []agentEventLevel{agentEventError}, sink.levels)
+	assert.Equal(t, []string{"agent exited (exit status 2), restarting in 1s"}, sink.messages)
+}
+
+func TestOpenAgentEventsDisabled(t *testing.T) {
+	defer func(on bool) {
+		agentEventLogOn = on
+		closeAgentEvents()
+	}(agentEventLogOn)
+
+	agentEventLogOn = false
+	openAgentEvents()
+	assert.Nil(t, agentEvents)
+}
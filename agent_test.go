@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAgentLimits(t *testing.T) {
+	defer func(rate int, limit int64) {
+		agentCPURate, agentMemoryLimit = rate, limit
+	}(agentCPURate, agentMemoryLimit)
+
+	agentCPURate, agentMemoryLimit = 50, 1024
+	assert.NoError(t, checkAgentLimits())
+
+	agentCPURate = 101
+	assert.Error(t, checkAgentLimits())
+
+	agentCPURate, agentMemoryLimit = 0, -1
+	assert.Error(t, checkAgentLimits())
+}
+
+func TestNextBackoff(t *testing.T) {
+	assert.Equal(t, 2*time.Second, nextBackoff(time.Second))
+	assert.Equal(t, superviseMaxBackoff, nextBackoff(4*time.Minute))
+	assert.Equal(t, superviseMaxBackoff, nextBackoff(superviseMaxBackoff))
+}
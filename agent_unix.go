@@ -0,0 +1,100 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// configureAgentProcess starts the agent in a new session without a
+// controlling terminal, so the hangup of the ssh session that ran bootstrap
+// does not reach it. As session leader its process group is its pid and it
+// can still be stopped together with its children.
+func configureAgentProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+func attachAgentProcess(_ *os.Process, _ int) error {
+	if agentMemoryLimit > 0 || agentCPURate > 0 {
+		warn("agent.limits")
+	}
+
+	return nil
+}
+
+// stopAgentProcesses terminates the process groups of agents started from
+// any of the given binaries. Ports only name job objects on windows.
+func stopAgentProcesses(agentPaths []string, _ []int) error {
+	quoted := make([]string, 0, len(agentPaths))
+	for _, path := range agentPaths {
+		quoted = append(quoted, regexp.QuoteMeta(path))
+	}
+
+	pattern := "^(" + strings.Join(quoted, "|") + ")$"
+
+	output, err := exec.Command("pgrep", "-f", pattern).Output()
+	if err != nil {
+		// pgrep exits with 1 when nothing matched
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return errAgentNotRunning
+		}
+		return err
+	}
+
+	for _, field := range strings.Fields(string(output)) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			continue
+		}
+		pgid, err := syscall.Getpgid(pid)
+		if err != nil {
+			continue
+		}
+		if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
+			return fmt.Errorf("kill process group %d failed: %w", pgid, err)
+		}
+	}
+
+	return nil
+}
+
+func terminateAgentProcess(proc *os.Process, _ int) error {
+	return syscall.Kill(-proc.Pid, syscall.SIGTERM)
+}
+
+// processAlive reports whether pid exists, also when it belongs to another
+// user and cannot be signalled.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// processExecutable returns the binary pid runs, from /proc on linux and
+// from ps elsewhere.
+func processExecutable(pid int) (string, error) {
+	exe, err := os.Readlink("/proc/" + strconv.Itoa(pid) + "/exe")
+	if err == nil {
+		return strings.TrimSuffix(exe, " (deleted)"), nil
+	}
+
+	output, err := exec.Command("ps", "-o", "comm=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return "", err
+	}
+
+	exe = strings.TrimSpace(string(output))
+	if !filepath.IsAbs(exe) {
+		return "", fmt.Errorf("no path for pid %d", pid)
+	}
+
+	return exe, nil
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	agentPortEnv       = "DISTBUILD_AGENT_PORT"
+	defaultAgentPort   = 9527
+	defaultHealthPath  = "/healthz"
+	agentDrainPath     = "/drain"
+	agentReadyInterval = 500 * time.Millisecond
+	agentStableCheck   = 10 * time.Second
+)
+
+var (
+	upgradePort       int
+	readyTimeout      time.Duration
+	upgradeToPrevious bool
+)
+
+var agentUpgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "upgrade the agent with a health-checked handover",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := prepareAgentCommand(); err != nil {
+			exitWithError(err)
+		}
+		if err := upgradeAgent(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+var agentHealthCmd = &cobra.Command{
+	Use:   "health",
+	Short: "check that the running agent answers its health endpoint",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := prepareAgentCommand(); err != nil {
+			exitWithError(err)
+		}
+		port, err := runningAgentPort()
+		if err != nil {
+			exitWithError(err)
+		}
+		if err := checkAgentHealth(&http.Client{Timeout: 5 * time.Second}, port); err != nil {
+			exitWithError(explainAgentFailure(fmt.Errorf("agent on port %d unhealthy: %w", port, err)))
+		}
+		printMsg("agent.healthy", "port", port)
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	agentUpgradeCmd.Flags().IntVar(&upgradePort, "upgrade-port", 0, "port for the new agent (default: alternate between AGENT_PORT and AGENT_PORT+1)")
+	addAgentLogFlags(agentUpgradeCmd)
+	addGatekeeperFlags(agentUpgradeCmd)
+	addAgentEnvFlags(agentUpgradeCmd)
+	addSchedulerConfigFlags(agentUpgradeCmd)
+	agentUpgradeCmd.Flags().DurationVar(&readyTimeout, "ready-timeout", 60*time.Second, "time to wait for the new agent to report ready")
+	agentUpgradeCmd.Flags().BoolVar(&upgradeToPrevious, "previous", false, "hand over to the agent the last upgrade replaced instead of AGENT_BIN")
+}
+
+// agentState records how the running agent was started, so an upgrade knows
+// which process to hand over from and which port is taken.
+type agentState struct {
+	PID  int `json:"pid,omitempty"`
+	Port int `json:"port"`
+}
+
+const agentStateFileName = "agent.state.json"
+
+func agentStatePath() string {
+	return existingStateFile(distbuildPath, agentStateFileName)
+}
+
+// runningAgentPort returns the port of the running agent, a systemd managed
+// agent listens on AGENT_PORT.
+func runningAgentPort() (int, error) {
+	state, err := loadAgentState()
+	if err != nil {
+		return 0, err
+	}
+
+	return state.Port, nil
+}
+
+func upgradeBinaryPath() string {
+	name := agentBinaryName()
+	ext := filepath.Ext(name)
+
+	return filepath.Join(distbuildPath, "boong", "bin", strings.TrimSuffix(name, ext)+".new"+ext)
+}
+
+func previousBinaryPath() string {
+	name := agentBinaryName()
+	ext := filepath.Ext(name)
+
+	return filepath.Join(distbuildPath, "boong", "bin", strings.TrimSuffix(name, ext)+".old"+ext)
+}
+
+func basePort() (int, error) {
+	value, ok := os.LookupEnv("AGENT_PORT")
+	if !ok || value == "" {
+		return defaultAgentPort, nil
+	}
+
+	port, err := strconv.Atoi(value)
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, withCode(errCodeUsage, fmt.Errorf("invalid AGENT_PORT %q", value))
+	}
+
+	return port, nil
+}
+
+func loadAgentState() (*agentState, error) {
+	data, err := os.ReadFile(agentStatePath())
+	if errors.Is(err, fs.ErrNotExist) {
+		port, err := basePort()
+		if err != nil {
+			return nil, err
+		}
+		return &agentState{Port: port}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read agent state failed: %w", err)
+	}
+
+	var state agentState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parse agent state failed: %w", err)
+	}
+
+	return &state, nil
+}
+
+func saveAgentState(state *agentState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode agent state failed: %w", err)
+	}
+
+	return writeStateFile(distbuildPath, agentStateFileName, append(data, '\n'))
+}
+
+// agentPorts lists every port an agent may be listening on, the recorded one
+// and both halves of the upgrade pair.
+func agentPorts() ([]int, error) {
+	base, err := basePort()
+	if err != nil {
+		return nil, err
+	}
+
+	ports := []int{base, base + 1}
+
+	state, err := loadAgentState()
+	if err == nil && state.Port != base && state.Port != base+1 {
+		ports = append(ports, state.Port)
+	}
+
+	return ports, nil
+}
+
+// alternatePort picks the port for the new agent: the explicit flag, or the
+// other half of the AGENT_PORT/AGENT_PORT+1 pair.
+func alternatePort(current, base, override int) int {
+	if override > 0 {
+		return override
+	}
+
+	if current == base {
+		return base + 1
+	}
+
+	return base
+}
+
+func agentHealthURL(port int) string {
+	path := os.Getenv("AGENT_HEALTH_PATH")
+	if path == "" {
+		path = defaultHealthPath
+	}
+
+	return "http://127.0.0.1:" + strconv.Itoa(port) + path
+}
+
+func checkAgentHealth(client *http.Client, port int) error {
+	resp, err := client.Get(agentHealthURL(port))
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError(resp)
+	}
+
+	return nil
+}
+
+// waitAgentReady polls the health endpoint until it answers 200 or the
+// timeout expires.
+func waitAgentReady(port int, timeout time.Duration) error {
+	client := &http.Client{Timeout: 2 * time.Second}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		err := checkAgentHealth(client, port)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("agent on port %d not ready after %s: %w", port, timeout, err)
+		}
+		time.Sleep(agentReadyInterval)
+	}
+}
+
+// drainAgent asks the agent to stop accepting work and finish what it has.
+// Agents without a drain endpoint are simply stopped.
+func drainAgent(port int) {
+	client := &http.Client{Timeout: readyTimeout}
+
+	resp, err := client.Post("http://127.0.0.1:"+strconv.Itoa(port)+agentDrainPath, "", nil)
+	if err != nil {
+		logVerbose("drain agent on port %d failed: %v", port, err)
+		return
+	}
+	_ = resp.Body.Close()
+}
+
+func upgradeAgent() error {
+	if agentManagedBySystemd() {
+		return withCode(errCodeUsage, fmt.Errorf("agent is managed by systemd, rerun bootstrap with --deploy-agent and restart distbuild.service"))
+	}
+
+	if err := setupHTTPClient(); err != nil {
+		return fmt.Errorf("setup http client failed: %w", err)
+	}
+
+	if err := resolveSecrets(); err != nil {
+		return err
+	}
+
+	if err := pullSchedulerConfig(); err != nil {
+		return fmt.Errorf("pull scheduler config failed: %w", err)
+	}
+
+	agentBin := artifactURL("AGENT_BIN")
+	if agentBin == "" && !upgradeToPrevious {
+		return withCode(errCodeUsage, fmt.Errorf("environment variable AGENT_BIN not set"))
+	}
+
+	state, err := loadAgentState()
+	if err != nil {
+		return err
+	}
+
+	base, err := basePort()
+	if err != nil {
+		return err
+	}
+
+	oldPort := state.Port
+	newPort := alternatePort(oldPort, base, upgradePort)
+	if newPort == oldPort {
+		return withCode(errCodeUsage, fmt.Errorf("upgrade port %d is the port of the running agent", newPort))
+	}
+
+	agentPath := agentBinaryPath()
+	newPath := upgradeBinaryPath()
+
+	if upgradeToPrevious {
+		if err := stagePreviousAgent(newPath); err != nil {
+			return err
+		}
+	} else if err := downloadBinary("agent", agentBin, newPath); err != nil {
+		return fmt.Errorf("download agent binary failed: %w", err)
+	}
+
+	logFile, _, err := openAgentLog()
+	if err != nil {
+		return err
+	}
+	defer func(logFile *os.File) {
+		_ = logFile.Close()
+	}(logFile)
+
+	newCmd, err := startAgentProcess(logFile, newPath, newPort)
+	if err != nil {
+		_ = os.Remove(newPath)
+		return err
+	}
+
+	printMsg("upgrade.waiting", "pid", newCmd.Process.Pid, "port", newPort)
+
+	if err := waitAgentReady(newPort, readyTimeout); err != nil {
+		_ = terminateAgentProcess(newCmd.Process, newPort)
+		_, _ = newCmd.Process.Wait()
+		_ = os.Remove(newPath)
+		restoreAgentPidfile(state.PID)
+		return fmt.Errorf("upgrade rolled back, old agent kept running: %w", err)
+	}
+
+	drainAgent(oldPort)
+	if err := stopOldAgent(state, agentPath); err != nil {
+		warn("upgrade.stop_old", "err", err)
+	}
+
+	if err := swapAgentBinaries(agentPath, newPath); err != nil {
+		return err
+	}
+
+	if err := saveAgentState(&agentState{PID: newCmd.Process.Pid, Port: newPort}); err != nil {
+		return err
+	}
+
+	// The new agent keeps running from the renamed file until it restarts;
+	// one more check after the handover catches agents that crash right away
+	time.Sleep(agentStableCheck)
+	if err := checkAgentHealth(&http.Client{Timeout: 2 * time.Second}, newPort); err != nil {
+		if rbErr := rollbackAgent(logFile, newCmd, newPort, oldPort); rbErr != nil {
+			return fmt.Errorf("new agent unhealthy (%v) and rollback failed: %w", err, rbErr)
+		}
+		return fmt.Errorf("new agent unhealthy, rolled back to previous agent: %w", err)
+	}
+
+	if err := newCmd.Process.Release(); err != nil {
+		return err
+	}
+
+	if err := recordAgentUpgrade(agentBin, agentPath); err != nil {
+		warn("upgrade.manifest", "err", err)
+	}
+
+	printMsg("upgrade.done", "pid", newCmd.Process.Pid, "port", newPort)
+
+	return nil
+}
+
+// stagePreviousAgent copies the agent the last upgrade replaced to newPath.
+// The handover then moves the running agent aside, so that a second
+// --previous undoes the first.
+func stagePreviousAgent(newPath string) error {
+	data, err := os.ReadFile(previousBinaryPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return withCode(errCodeUsage, fmt.Errorf("no previous agent to hand over to in %s", filepath.Dir(newPath)))
+	}
+	if err != nil {
+		return withCode(errCodeDisk, fmt.Errorf("read previous agent failed: %w", err))
+	}
+
+	if err := os.WriteFile(newPath, data, 0755); err != nil {
+		return withCode(errCodeDisk, fmt.Errorf("stage previous agent failed: %w", err))
+	}
+
+	return nil
+}
+
+// recordAgentUpgrade records the agent now running and keeps the one it
+// replaced as previous, like slotted components.
+func recordAgentUpgrade(agentBin, agentPath string) error {
+	manifest, err := loadManifest()
+	if err != nil {
+		return err
+	}
+	before := manifest.Components["agent"]
+
+	if upgradeToPrevious {
+		if before == nil || before.Previous == nil {
+			return nil
+		}
+		previous := before.Previous
+		before.Previous = nil
+		previous.Previous = before
+		manifest.Components["agent"] = previous
+		return saveManifest(manifest)
+	}
+
+	if err := recordComponent("agent", agentBin, agentPath); err != nil {
+		return err
+	}
+	if before == nil {
+		return nil
+	}
+
+	if manifest, err = loadManifest(); err != nil {
+		return err
+	}
+	current := manifest.Components["agent"]
+	if current.SHA256 == before.SHA256 {
+		current.Previous = before.Previous
+	} else {
+		before.Previous = nil
+		current.Previous = before
+	}
+
+	return saveManifest(manifest)
+}
+
+// stopOldAgent stops the agent being replaced, by pid when it was recorded
+// and by binary path otherwise.
+func stopOldAgent(state *agentState, agentPath string) error {
+	if state.PID > 0 && staleAgentPid(state.PID) == "" {
+		if proc, err := os.FindProcess(state.PID); err == nil {
+			if err := terminateAgentProcess(proc, state.Port); err == nil {
+				return nil
+			}
+		}
+	}
+
+	return stopAgentProcesses([]string{agentPath}, []int{state.Port})
+}
+
+// swapAgentBinaries moves the running binary aside and puts the new one in
+// its place. Windows cannot replace a running executable, so the old agent
+// must be stopped before this is called.
+func swapAgentBinaries(agentPath, newPath string) error {
+	oldPath := previousBinaryPath()
+
+	if err := os.Rename(agentPath, oldPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("move old agent binary failed: %w", err)
+	}
+
+	if err := os.Rename(newPath, agentPath); err != nil {
+		_ = os.Rename(oldPath, agentPath)
+		return fmt.Errorf("install new agent binary failed: %w", err)
+	}
+
+	return nil
+}
+
+func rollbackAgent(logFile *os.File, newCmd *exec.Cmd, newPort, oldPort int) error {
+	agentPath := agentBinaryPath()
+
+	if err := terminateAgentProcess(newCmd.Process, newPort); err != nil {
+		logVerbose("stop new agent failed: %v", err)
+	}
+	_ = newCmd.Wait()
+
+	if runtime.GOOS == "windows" {
+		// Give the job a moment to release the executable
+		time.Sleep(time.Second)
+	}
+
+	if err := os.Rename(previousBinaryPath(), agentPath); err != nil {
+		return fmt.Errorf("restore old agent binary failed: %w", err)
+	}
+
+	cmd, err := startAgentProcess(logFile, agentPath, oldPort)
+	if err != nil {
+		return err
+	}
+
+	if err := saveAgentState(&agentState{PID: cmd.Process.Pid, Port: oldPort}); err != nil {
+		return err
+	}
+
+	return cmd.Process.Release()
+}
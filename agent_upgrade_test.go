@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlternatePort(t *testing.T) {
+	assert.Equal(t, 9528, alternatePort(9527, 9527, 0))
+	assert.Equal(t, 9527, alternatePort(9528, 9527, 0))
+	assert.Equal(t, 9527, alternatePort(12000, 9527, 0))
+	assert.Equal(t, 12000, alternatePort(9527, 9527, 12000))
+}
+
+func TestAgentState(t *testing.T) {
+	defer func(path string) {
+		distbuildPath = path
+	}(distbuildPath)
+
+	distbuildPath = t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(distbuildPath, "boong"), 0755))
+	t.Setenv("AGENT_PORT", "10000")
+
+	state, err := loadAgentState()
+	assert.NoError(t, err)
+	assert.Equal(t, &agentState{Port: 10000}, state)
+
+	assert.NoError(t, saveAgentState(&agentState{PID: 42, Port: 10001}))
+	state, err = loadAgentState()
+	assert.NoError(t, err)
+	assert.Equal(t, &agentState{PID: 42, Port: 10001}, state)
+
+	t.Setenv("AGENT_PORT", "not-a-port")
+	_, err = basePort()
+	assert.Equal(t, errCodeUsage, errorCodeOf(err))
+}
+
+func TestWaitAgentReady(t *testing.T) {
+	ready := time.Now().Add(time.Second)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, defaultHealthPath, r.URL.Path)
+		if time.Now().Before(ready) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	_, portStr, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	assert.Error(t, waitAgentReady(port, 0))
+	assert.NoError(t, waitAgentReady(port, 5*time.Second))
+}
+
+func TestRecordAgentUpgrade(t *testing.T) {
+	defer func(path string, previous bool) {
+		distbuildPath, upgradeToPrevious = path, previous
+	}(distbuildPath, upgradeToPrevious)
+	distbuildPath, upgradeToPrevious = t.TempDir(), false
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	agentPath := filepath.Join(distbuildPath, "agent")
+	install := func(content string) {
+		assert.NoError(t, os.WriteFile(agentPath, []byte(content), 0755))
+		assert.NoError(t, recordAgentUpgrade(server.URL+"/agent-"+content, agentPath))
+	}
+	current := func() *manifestComponent {
+		manifest, err := loadManifest()
+		assert.NoError(t, err)
+		return manifest.Components["agent"]
+	}
+
+	install("v1")
+	assert.Nil(t, current().Previous)
+
+	install("v2")
+	assert.Equal(t, server.URL+"/agent-v2", current().URL)
+	assert.Equal(t, server.URL+"/agent-v1", current().Previous.URL)
+
+	// Reinstalling the same agent keeps the rollback target
+	install("v2")
+	assert.Equal(t, server.URL+"/agent-v1", current().Previous.URL)
+
+	// --previous swaps them, a second one undoes it
+	upgradeToPrevious = true
+	assert.NoError(t, recordAgentUpgrade("", agentPath))
+	assert.Equal(t, server.URL+"/agent-v1", current().URL)
+	assert.Equal(t, server.URL+"/agent-v2", current().Previous.URL)
+	assert.NoError(t, recordAgentUpgrade("", agentPath))
+	assert.Equal(t, server.URL+"/agent-v2", current().URL)
+}
+
+func TestStagePreviousAgent(t *testing.T) {
+	defer func(path string) {
+		distbuildPath = path
+	}(distbuildPath)
+	distbuildPath = t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Dir(previousBinaryPath()), 0755))
+
+	err := stagePreviousAgent(upgradeBinaryPath())
+	assert.Equal(t, errCodeUsage, errorCodeOf(err))
+
+	assert.NoError(t, os.WriteFile(previousBinaryPath(), []byte("v1"), 0755))
+	assert.NoError(t, stagePreviousAgent(upgradeBinaryPath()))
+	data, err := os.ReadFile(upgradeBinaryPath())
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(data))
+	assert.FileExists(t, previousBinaryPath())
+}
@@ -0,0 +1,230 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procOpenJobObjectW = windows.NewLazySystemDLL("kernel32.dll").NewProc("OpenJobObjectW")
+
+const (
+	jobObjectTerminate             = 0x0008
+	jobObjectCPURateControlEnable  = 0x1
+	jobObjectCPURateControlHardCap = 0x4
+
+	// stillActive is the exit code of a process that has not exited
+	stillActive = 259
+)
+
+type jobObjectCPURateControlInformation struct {
+	ControlFlags uint32
+	CPURate      uint32
+}
+
+// configureAgentProcess detaches the agent from the console so it keeps
+// running without a window after bootstrap exits.
+func configureAgentProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: windows.CREATE_NEW_PROCESS_GROUP | windows.CREATE_NO_WINDOW,
+		HideWindow:    true,
+	}
+}
+
+// agentJobName derives a job name per agent port, so an agent being
+// upgraded and its replacement can be stopped independently.
+func agentJobName(port int) string {
+	return `Local\distbuild-agent-` + strconv.Itoa(port)
+}
+
+// attachAgentProcess assigns the agent to a named job object. The job is
+// not configured to kill on close, so the agent outlives bootstrap, while
+// every child it spawns stays in the job and can be stopped as a whole. A
+// named object goes away with its last handle, the agent is handed one so
+// that later runs can still open the job by name.
+func attachAgentProcess(proc *os.Process, port int) error {
+	name, err := windows.UTF16PtrFromString(agentJobName(port))
+	if err != nil {
+		return err
+	}
+
+	job, err := windows.CreateJobObject(nil, name)
+	if err != nil {
+		return fmt.Errorf("create job object failed: %w", err)
+	}
+
+	defer func(job windows.Handle) {
+		_ = windows.CloseHandle(job)
+	}(job)
+
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{}
+	info.BasicLimitInformation.LimitFlags = windows.JOB_OBJECT_LIMIT_BREAKAWAY_OK
+	if agentMemoryLimit > 0 {
+		info.BasicLimitInformation.LimitFlags |= windows.JOB_OBJECT_LIMIT_JOB_MEMORY
+		info.JobMemoryLimit = uintptr(agentMemoryLimit * 1024 * 1024)
+	}
+
+	if _, err := windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info))); err != nil {
+		return fmt.Errorf("set job limits failed: %w", err)
+	}
+
+	if agentCPURate > 0 {
+		// CPU rate is expressed in 1/100 of a percent
+		rate := jobObjectCPURateControlInformation{
+			ControlFlags: jobObjectCPURateControlEnable | jobObjectCPURateControlHardCap,
+			CPURate:      uint32(agentCPURate * 100),
+		}
+		if _, err := windows.SetInformationJobObject(job, windows.JobObjectCpuRateControlInformation,
+			uintptr(unsafe.Pointer(&rate)), uint32(unsafe.Sizeof(rate))); err != nil {
+			return fmt.Errorf("set job cpu rate failed: %w", err)
+		}
+	}
+
+	handle, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE|windows.PROCESS_DUP_HANDLE, false, uint32(proc.Pid))
+	if err != nil {
+		return fmt.Errorf("open agent process failed: %w", err)
+	}
+
+	defer func(handle windows.Handle) {
+		_ = windows.CloseHandle(handle)
+	}(handle)
+
+	if err := windows.AssignProcessToJobObject(job, handle); err != nil {
+		return fmt.Errorf("assign job object failed: %w", err)
+	}
+
+	var agentJob windows.Handle
+	if err := windows.DuplicateHandle(windows.CurrentProcess(), job, handle, &agentJob,
+		0, false, windows.DUPLICATE_SAME_ACCESS); err != nil {
+		return fmt.Errorf("hand job object to agent failed: %w", err)
+	}
+
+	return nil
+}
+
+// stopAgentProcesses terminates the jobs of the agents on ports. Only when
+// no job could be terminated, the agents recorded in the pidfile and agent
+// state are killed with their process tree, if they run one of the given
+// binaries.
+func stopAgentProcesses(agentPaths []string, ports []int) error {
+	stopped := false
+
+	for _, port := range ports {
+		if err := terminateAgentJob(port); err == nil {
+			stopped = true
+		}
+	}
+
+	if stopped {
+		return nil
+	}
+
+	for _, pid := range recordedAgentPids() {
+		exe, err := processExecutable(pid)
+		if err != nil || !slices.ContainsFunc(agentPaths, func(path string) bool { return strings.EqualFold(path, exe) }) {
+			continue
+		}
+		if err := killProcessTree(pid); err == nil {
+			stopped = true
+		}
+	}
+
+	if !stopped {
+		return errAgentNotRunning
+	}
+
+	return nil
+}
+
+// recordedAgentPids returns the pids of the pidfile and the agent state,
+// the latter also names an agent left running by a failed upgrade.
+func recordedAgentPids() []int {
+	var pids []int
+
+	if pid, err := readAgentPidfile(); err == nil && pid > 0 {
+		pids = append(pids, pid)
+	}
+
+	if state, err := loadAgentState(); err == nil && state.PID > 0 && !slices.Contains(pids, state.PID) {
+		pids = append(pids, state.PID)
+	}
+
+	return pids
+}
+
+func killProcessTree(pid int) error {
+	return exec.Command("taskkill", "/F", "/T", "/PID", strconv.Itoa(pid)).Run()
+}
+
+func terminateAgentJob(port int) error {
+	name, err := windows.UTF16PtrFromString(agentJobName(port))
+	if err != nil {
+		return err
+	}
+
+	r, _, err := procOpenJobObjectW.Call(uintptr(jobObjectTerminate), 0, uintptr(unsafe.Pointer(name)))
+	if r == 0 {
+		return err
+	}
+
+	job := windows.Handle(r)
+	defer func(job windows.Handle) {
+		_ = windows.CloseHandle(job)
+	}(job)
+
+	return windows.TerminateJobObject(job, 1)
+}
+
+func terminateAgentProcess(proc *os.Process, port int) error {
+	if err := terminateAgentJob(port); err == nil {
+		return nil
+	}
+
+	return killProcessTree(proc.Pid)
+}
+
+func processAlive(pid int) bool {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return errors.Is(err, windows.ERROR_ACCESS_DENIED)
+	}
+	defer func(handle windows.Handle) {
+		_ = windows.CloseHandle(handle)
+	}(handle)
+
+	var code uint32
+	if err := windows.GetExitCodeProcess(handle, &code); err != nil {
+		return true
+	}
+
+	return code == stillActive
+}
+
+func processExecutable(pid int) (string, error) {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return "", err
+	}
+	defer func(handle windows.Handle) {
+		_ = windows.CloseHandle(handle)
+	}(handle)
+
+	buf := make([]uint16, windows.MAX_LONG_PATH)
+	size := uint32(len(buf))
+	if err := windows.QueryFullProcessImageName(handle, 0, &buf[0], &size); err != nil {
+		return "", err
+	}
+
+	return windows.UTF16ToString(buf[:size]), nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const backupSuffix = ".distbuild.bak"
+
+var integrateAOSP bool
+
+// integrateWorkspace wires the installed distbuild components into the AOSP
+// build. Every step is idempotent and keeps a backup of the original file.
+func integrateWorkspace() error {
+	distninja := filepath.Join(distbuildPath, "boong", "bin", "distninja")
+
+	changed, err := applyManagedBlock(filepath.Join(aospPath, "buildspec.mk"), "distbuild", []string{
+		"export NINJA := " + distninja,
+		"export DISTBUILD_PATH := " + distbuildPath,
+		"export DISTBUILD_CHECKOUT := " + filepath.Join(aospPath, "build", "distbuild"),
+	})
+	if err != nil {
+		return fmt.Errorf("update buildspec.mk failed: %w", err)
+	}
+
+	if changed {
+		printMsg("aosp.buildspec", "backup", "buildspec.mk"+backupSuffix)
+	}
+
+	return nil
+}
+
+// applyManagedBlock inserts or replaces the named bootstrap managed block in
+// path and reports whether the file changed.
+func applyManagedBlock(path, name string, lines []string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	content := string(data)
+	updated := replaceManagedBlock(content, name, lines)
+
+	if updated == content {
+		return false, nil
+	}
+
+	if err == nil {
+		if _, statErr := os.Stat(path + backupSuffix); errors.Is(statErr, os.ErrNotExist) {
+			if err := os.WriteFile(path+backupSuffix, data, policyFileMode(0644)); err != nil {
+				return false, fmt.Errorf("backup failed: %w", err)
+			}
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(updated), policyFileMode(0644)); err != nil {
+		return false, err
+	}
+
+	noteChange("write " + path)
+
+	return true, nil
+}
+
+func managedBlockMarkers(name string) (string, string) {
+	return "# BEGIN " + name + " (managed by bootstrap)", "# END " + name
+}
+
+// replaceManagedBlock replaces the named block in content, or appends it.
+// The markers match whole lines only, the END of one block is a prefix of
+// others. A block whose END line was lost ends before the next managed
+// block, so that one survives.
+func replaceManagedBlock(content, name string, lines []string) string {
+	beginMarker, endMarker := managedBlockMarkers(name)
+	block := strings.Join(append(append([]string{beginMarker}, lines...), endMarker), "\n")
+
+	existing := strings.SplitAfter(content, "\n")
+	for begin, line := range existing {
+		if strings.TrimRight(line, "\r\n") != beginMarker {
+			continue
+		}
+
+		end := len(existing)
+		for i := begin + 1; i < len(existing); i++ {
+			line := strings.TrimRight(existing[i], "\r\n")
+			if line == endMarker {
+				end = i + 1
+				break
+			}
+			if isManagedBegin(line) {
+				end = i
+				break
+			}
+		}
+
+		if strings.HasSuffix(strings.Join(existing[begin:end], ""), "\n") {
+			block += "\n"
+		}
+
+		return strings.Join(existing[:begin], "") + block + strings.Join(existing[end:], "")
+	}
+
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+
+	return content + block + "\n"
+}
+
+func isManagedBegin(line string) bool {
+	return strings.HasPrefix(line, "# BEGIN ") && strings.HasSuffix(line, " (managed by bootstrap)")
+}
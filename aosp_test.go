@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyManagedBlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buildspec.mk")
+	assert.NoError(t, os.WriteFile(path, []byte("TARGET_PRODUCT := aosp_arm64"), 0644))
+
+	changed, err := applyManagedBlock(path, "distbuild", []string{"export NINJA := /a"})
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = applyManagedBlock(path, "distbuild", []string{"export NINJA := /a"})
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	changed, err = applyManagedBlock(path, "distbuild", []string{"export NINJA := /b"})
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = applyManagedBlock(path, "cache", []string{"export USE_CCACHE := 1"})
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "TARGET_PRODUCT := aosp_arm64\n"+
+		"# BEGIN distbuild (managed by bootstrap)\nexport NINJA := /b\n# END distbuild\n"+
+		"# BEGIN cache (managed by bootstrap)\nexport USE_CCACHE := 1\n# END cache\n", string(data))
+
+	backup, _ := os.ReadFile(path + backupSuffix)
+	assert.Equal(t, "TARGET_PRODUCT := aosp_arm64", string(backup))
+}
+
+func TestReplaceManagedBlockPrefixNames(t *testing.T) {
+	cache := "# BEGIN distbuild cache (managed by bootstrap)\nexport USE_CCACHE := 1\n# END distbuild cache\n"
+	content := "# BEGIN distbuild (managed by bootstrap)\nexport NINJA := /a\n# END distbuild\n" + cache
+
+	assert.Equal(t, "# BEGIN distbuild (managed by bootstrap)\nexport NINJA := /b\n# END distbuild\n"+cache,
+		replaceManagedBlock(content, "distbuild", []string{"export NINJA := /b"}))
+
+	// Without its END line the block stops at the cache block
+	content = "TARGET_PRODUCT := aosp_arm64\n# BEGIN distbuild (managed by bootstrap)\nexport NINJA := /a\n" + cache
+	assert.Equal(t, "TARGET_PRODUCT := aosp_arm64\n# BEGIN distbuild (managed by bootstrap)\nexport NINJA := /b\n# END distbuild\n"+cache,
+		replaceManagedBlock(content, "distbuild", []string{"export NINJA := /b"}))
+
+	assert.Equal(t, content, replaceManagedBlock(content, "distbuild cache", []string{"export USE_CCACHE := 1"}))
+}
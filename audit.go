@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	auditLogName = "audit.log"
+
+	auditOK     = "ok"
+	auditFailed = "failed"
+)
+
+var (
+	auditMu     sync.Mutex
+	auditWarned bool
+)
+
+// auditEntry is one line of the audit log of privileged and destructive
+// actions.
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Target  string    `json:"target"`
+	User    string    `json:"user"`
+	EUID    int       `json:"euid"`
+	PID     int       `json:"pid"`
+	Outcome string    `json:"outcome"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func auditLogPath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, auditLogName), nil
+}
+
+// audited runs a privileged or destructive action and appends its outcome
+// to the audit log. An unwritable audit log is reported, it does not stop
+// the run.
+func audited(action, target string, fn func() error) error {
+	err := fn()
+	recordAudit(action, target, err)
+
+	return err
+}
+
+// recordAudit appends the outcome of an action that already ran.
+func recordAudit(action, target string, err error) {
+	recordAction("audit", action, map[string]string{"target": target}, nil, err)
+
+	entry := auditEntry{
+		Time:    time.Now().UTC(),
+		Action:  action,
+		Target:  target,
+		EUID:    os.Geteuid(),
+		PID:     os.Getpid(),
+		Outcome: auditOK,
+	}
+	if usr, userErr := invokingUser(); userErr == nil {
+		entry.User = usr.Username
+	}
+	if err != nil {
+		entry.Outcome, entry.Error = auditFailed, err.Error()
+	}
+
+	if auditErr := appendAudit(entry); auditErr != nil {
+		auditMu.Lock()
+		if !auditWarned {
+			auditWarned = true
+			warn("audit.write", "err", auditErr)
+		}
+		auditMu.Unlock()
+	}
+}
+
+// appendAudit writes entry as a single JSON line, appends of one write are
+// not interleaved between concurrent bootstrap processes.
+func appendAudit(entry auditEntry) error {
+	path, err := auditLogPath()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	if err := createDirs(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	_, err = f.Write(append(data, '\n'))
+
+	return err
+}
+
+// removeAll is os.RemoveAll behind the safety checks and with an audit
+// record, paths that do not exist are not recorded.
+func removeAll(path string) error {
+	if _, err := os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err := guardRemove(path); err != nil {
+		recordAudit("remove", path, err)
+		return err
+	}
+
+	return audited("remove", path, func() error {
+		return os.RemoveAll(path)
+	})
+}
+
+// runPrivileged runs a sudo command with an audit record.
+func runPrivileged(cmd *exec.Cmd) error {
+	return audited("sudo", strings.Join(cmd.Args[1:], " "), cmd.Run)
+}
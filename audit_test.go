@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readAuditLog(t *testing.T) []auditEntry {
+	t.Helper()
+
+	path, err := auditLogPath()
+	assert.NoError(t, err)
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	assert.NoError(t, err)
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry auditEntry
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	defer func(dir, distbuild string) {
+		stateDirFlag = dir
+		distbuildPath = distbuild
+	}(stateDirFlag, distbuildPath)
+	stateDirFlag = t.TempDir()
+
+	dir := t.TempDir()
+	distbuildPath = dir
+	checkout := filepath.Join(dir, "distbuild")
+	assert.NoError(t, os.MkdirAll(filepath.Join(checkout, ".git"), 0755))
+
+	assert.NoError(t, removeAll(checkout))
+	assert.NoDirExists(t, checkout)
+
+	// Nothing to remove, nothing to record
+	assert.NoError(t, removeAll(filepath.Join(dir, "missing")))
+
+	if _, err := exec.LookPath("false"); err == nil {
+		assert.Error(t, runPrivileged(exec.Command("false", "--flag")))
+	}
+
+	entries := readAuditLog(t)
+	if assert.NotEmpty(t, entries) {
+		assert.Equal(t, "remove", entries[0].Action)
+		assert.Equal(t, checkout, entries[0].Target)
+		assert.Equal(t, auditOK, entries[0].Outcome)
+		assert.Equal(t, os.Getpid(), entries[0].PID)
+		assert.NotEmpty(t, entries[0].User)
+		assert.False(t, entries[0].Time.IsZero())
+	}
+	if len(entries) == 2 {
+		assert.Equal(t, "sudo", entries[1].Action)
+		assert.Equal(t, "--flag", entries[1].Target)
+		assert.Equal(t, auditFailed, entries[1].Outcome)
+		assert.NotEmpty(t, entries[1].Error)
+	}
+
+	// The log is appended to, never rewritten
+	recordAudit("install-service", "/etc/systemd/system/distbuild.service", nil)
+	assert.Len(t, readAuditLog(t), len(entries)+1)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// blockIndexSuffix names the block hash manifest published next to a large
+// artifact. It lists the SHA-256 of every fixed size block of the published
+// bytes, so a local copy can be checked block by block.
+const blockIndexSuffix = ".blocks.json"
+
+// blockRepairSuffix names the copy a block download writes into. It is kept
+// when the download is interrupted, the next run fetches only the blocks
+// still missing.
+const blockRepairSuffix = ".blocks"
+
+var errNoBlockIndex = errors.New("no block index")
+
+type blockIndex struct {
+	Size      int64    `json:"size"`
+	BlockSize int64    `json:"block_size"`
+	Blocks    []string `json:"blocks"`
+}
+
+func (b *blockIndex) validate() error {
+	if b.Size <= 0 || b.BlockSize <= 0 {
+		return fmt.Errorf("invalid size %d or block size %d", b.Size, b.BlockSize)
+	}
+
+	if want := (b.Size + b.BlockSize - 1) / b.BlockSize; int64(len(b.Blocks)) != want {
+		return fmt.Errorf("%d block hashes for %d blocks", len(b.Blocks), want)
+	}
+
+	return nil
+}
+
+// blockRange returns the byte range of block i.
+func (b *blockIndex) blockRange(i int) (int64, int64) {
+	start := int64(i) * b.BlockSize
+
+	return start, min(start+b.BlockSize, b.Size) - 1
+}
+
+func fetchBlockIndex(artifactURL string) (*blockIndex, error) {
+	data, err := fetchBytes(artifactURL + blockIndexSuffix)
+	if err != nil {
+		return nil, errNoBlockIndex
+	}
+
+	// An unusable index only loses the repair, the artifact is fetched whole
+	var index blockIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		logVerbose("ignoring block index of %s: %v", redactURL(artifactURL), err)
+		return nil, errNoBlockIndex
+	}
+
+	if err := index.validate(); err != nil {
+		logVerbose("ignoring block index of %s: %v", redactURL(artifactURL), err)
+		return nil, errNoBlockIndex
+	}
+
+	return &index, nil
+}
+
+// downloadBlocks fetches an artifact of size bytes that is served with
+// range requests and publishes a block index. It writes into the repair
+// copy and re-fetches only the blocks that are missing or do not match the
+// index. An uncompressed artifact is repaired from the installed file as
+// well, so a corrupted binary costs its bad blocks instead of the whole
+// download.
+func downloadBlocks(ctx context.Context, artifactURL, validator, filePath, format string, size int64) (int64, error) {
+	index, err := fetchBlockIndex(artifactURL)
+	if err != nil {
+		return 0, err
+	}
+
+	if index.Size != size {
+		logVerbose("block index of %s is for %d bytes, the artifact has %d", redactURL(artifactURL), index.Size, size)
+		return 0, errNoBlockIndex
+	}
+
+	name := filepath.Base(filePath)
+	repairPath := filePath + blockRepairSuffix
+
+	if _, err := os.Stat(repairPath); err != nil && format == "" {
+		if installedCurrent(artifactURL, filePath, index) {
+			logVerbose("%s is current, nothing to repair", name)
+			return 0, os.Chmod(filePath, policyFileMode(0755))
+		}
+		if err := seedRepairCopy(filePath, repairPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, fmt.Errorf("copy %s failed: %w", name, err)
+		}
+	}
+
+	f, err := os.OpenFile(repairPath, os.O_CREATE|os.O_RDWR, policyFileMode(0644))
+	if err != nil {
+		return 0, fmt.Errorf("create file failed: %v [%s]", err, name)
+	}
+
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	if err := f.Truncate(index.Size); err != nil {
+		return 0, fmt.Errorf("allocate file failed: %v [%s]", err, name)
+	}
+
+	bad, err := badBlocks(f, index)
+	if err != nil {
+		return 0, fmt.Errorf("check blocks failed: %v [%s]", err, name)
+	}
+
+	fetched, err := fetchBlocks(ctx, artifactURL, validator, f, index, bad)
+	if err != nil {
+		return fetched, fmt.Errorf("download failed: %w [%s]", err, name)
+	}
+
+	if err := f.Close(); err != nil {
+		return fetched, fmt.Errorf("write file failed: %v [%s]", err, name)
+	}
+
+	if len(bad) < len(index.Blocks) {
+		printMsg("blocks.repaired", "file", name, "blocks", len(bad), "total", len(index.Blocks), "size", formatBytes(fetched))
+	}
+
+	if err := verifyFileChecksum(artifactURL, repairPath, repairPath); err != nil {
+		return fetched, err
+	}
+
+	if format != "" {
+		if err := timed(timingExtract, name, func() error {
+			return decompressFile(format, repairPath, filePath)
+		}); err != nil {
+			return fetched, fmt.Errorf("decompress failed: %v [%s]", err, name)
+		}
+		_ = os.Remove(repairPath)
+		return fetched, nil
+	}
+
+	if err := os.Chmod(repairPath, policyFileMode(0755)); err != nil {
+		return fetched, fmt.Errorf("chmod failed: %v [%s]", err, name)
+	}
+
+	if err := os.Rename(repairPath, filePath); err != nil {
+		return fetched, fmt.Errorf("rename failed: %v [%s]", err, name)
+	}
+
+	return fetched, nil
+}
+
+// installedCurrent tells whether the uncompressed file at filePath is the
+// published artifact: it matches the checksum manifest, or without one
+// every block of the index.
+func installedCurrent(artifactURL, filePath string, index *blockIndex) bool {
+	info, err := os.Stat(filePath)
+	if err != nil || info.Size() != index.Size {
+		return false
+	}
+
+	manifest, err := loadChecksums()
+	if err != nil {
+		return false
+	}
+	if manifest != nil {
+		sum, err := fileSHA256(filePath)
+		return err == nil && verifyChecksum(artifactURL, sum) == nil
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	bad, err := badBlocks(f, index)
+
+	return err == nil && len(bad) == 0
+}
+
+func seedRepairCopy(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer func(in *os.File) {
+		_ = in.Close()
+	}(in)
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// badBlocks returns the blocks of f that do not match the index.
+func badBlocks(f *os.File, index *blockIndex) ([]int, error) {
+	var bad []int
+
+	buf := make([]byte, index.BlockSize)
+	for i, want := range index.Blocks {
+		start, end := index.blockRange(i)
+		block := buf[:end-start+1]
+		if _, err := f.ReadAt(block, start); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if sum := sha256.Sum256(block); hex.EncodeToString(sum[:]) != want {
+			bad = append(bad, i)
+		}
+	}
+
+	return bad, nil
+}
+
+// fetchBlocks downloads the given blocks in parallel and checks each one
+// against the index. Blocks written before a failure stay in f.
+func fetchBlocks(ctx context.Context, artifactURL, validator string, f *os.File, index *blockIndex, blocks []int) (int64, error) {
+	var total int64
+	for _, i := range blocks {
+		start, end := index.blockRange(i)
+		total += end - start + 1
+	}
+
+	if activeTransfers != nil {
+		activeTransfers.addTotal(total)
+	}
+
+	var (
+		mu      sync.Mutex
+		fetched int64
+		wg      sync.WaitGroup
+	)
+
+	work := make(chan int)
+	errs := make(chan error, max(downloadConcurrency, 1))
+
+	for range max(downloadConcurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if err := fetchBlock(ctx, artifactURL, validator, f, index, i); err != nil {
+					errs <- err
+					return
+				}
+				start, end := index.blockRange(i)
+				mu.Lock()
+				fetched += end - start + 1
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var firstErr error
+
+	for _, i := range blocks {
+		if firstErr != nil {
+			break
+		}
+		select {
+		case work <- i:
+		case firstErr = <-errs:
+		}
+	}
+
+	close(work)
+	wg.Wait()
+	close(errs)
+
+	if firstErr == nil {
+		firstErr = <-errs
+	}
+
+	return fetched, firstErr
+}
+
+func fetchBlock(ctx context.Context, artifactURL, validator string, f *os.File, index *blockIndex, i int) error {
+	start, end := index.blockRange(i)
+	if err := fetchRange(ctx, artifactURL, validator, f, start, end); err != nil {
+		return err
+	}
+
+	block := make([]byte, end-start+1)
+	if _, err := f.ReadAt(block, start); err != nil {
+		return err
+	}
+
+	if sum := sha256.Sum256(block); hex.EncodeToString(sum[:]) != index.Blocks[i] {
+		return withCode(errCodeVerification, fmt.Errorf("block %d checksum mismatch", i))
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testBlockIndex(content []byte, blockSize int64) blockIndex {
+	index := blockIndex{Size: int64(len(content)), BlockSize: blockSize}
+	for start := int64(0); start < index.Size; start += blockSize {
+		sum := sha256.Sum256(content[start:min(start+blockSize, index.Size)])
+		index.Blocks = append(index.Blocks, hex.EncodeToString(sum[:]))
+	}
+
+	return index
+}
+
+func TestBlockIndexValidate(t *testing.T) {
+	index := testBlockIndex(bytes.Repeat([]byte("x"), 10), 4)
+	assert.NoError(t, index.validate())
+	start, end := index.blockRange(2)
+	assert.Equal(t, []int64{8, 9}, []int64{start, end})
+
+	index.Blocks = index.Blocks[:2]
+	assert.ErrorContains(t, index.validate(), "2 block hashes for 3 blocks")
+
+	assert.Error(t, (&blockIndex{Size: 10}).validate())
+}
+
+func TestDownloadBlocksRepair(t *testing.T) {
+	defer func(concurrency int, quiet bool) {
+		downloadConcurrency, noProgress = concurrency, quiet
+	}(downloadConcurrency, noProgress)
+	downloadConcurrency, noProgress = 2, true
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	index, err := json.Marshal(testBlockIndex(content, 4096))
+	assert.NoError(t, err)
+
+	var fetched atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/toolchain.tar" + blockIndexSuffix:
+			_, _ = w.Write(index)
+		case "/toolchain.tar":
+			if r.Header.Get("Range") != "" {
+				fetched.Add(1)
+			}
+			w.Header().Set("ETag", `"v1"`)
+			http.ServeContent(w, r, "toolchain.tar", time.Time{}, bytes.NewReader(content))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	artifact := server.URL + "/toolchain.tar"
+	path := filepath.Join(t.TempDir(), "toolchain.tar")
+
+	assert.NoError(t, downloadFile(artifact, path))
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, content, data)
+	assert.Equal(t, int64(16), fetched.Load())
+	assert.NoFileExists(t, path+blockRepairSuffix)
+
+	// A current copy is neither copied nor fetched
+	fetched.Store(0)
+	before, err := os.Stat(path)
+	assert.NoError(t, err)
+	out := captureStdout(t, func() { assert.NoError(t, downloadFile(artifact, path)) })
+	assert.Empty(t, out)
+	after, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(before, after))
+	assert.Equal(t, int64(0), fetched.Load())
+
+	// A corrupted copy costs its bad blocks only
+	fetched.Store(0)
+	data[5000], data[60000] = 'x', 'x'
+	assert.NoError(t, os.WriteFile(path, data, 0755))
+	out = captureStdout(t, func() { assert.NoError(t, downloadFile(artifact, path)) })
+	assert.Contains(t, out, msg("blocks.repaired", "file", "toolchain.tar", "blocks", 2, "total", 16, "size", formatBytes(8192)))
+	data, _ = os.ReadFile(path)
+	assert.Equal(t, content, data)
+	assert.Equal(t, int64(2), fetched.Load())
+
+	// An interrupted download resumes from the blocks it has
+	fetched.Store(0)
+	assert.NoError(t, os.Remove(path))
+	assert.NoError(t, os.WriteFile(path+blockRepairSuffix, content[:40000], 0644))
+	assert.NoError(t, downloadFile(artifact, path))
+	data, _ = os.ReadFile(path)
+	assert.Equal(t, content, data)
+	assert.Equal(t, int64(7), fetched.Load())
+}
+
+func TestDownloadBlocksCompressed(t *testing.T) {
+	defer func(quiet bool) {
+		noProgress = quiet
+	}(noProgress)
+	noProgress = true
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, _ = gw.Write(content)
+	assert.NoError(t, gw.Close())
+
+	index, err := json.Marshal(testBlockIndex(gz.Bytes(), 1024))
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/toolchain.gz" + blockIndexSuffix:
+			_, _ = w.Write(index)
+		case "/toolchain.gz":
+			w.Header().Set("ETag", `"v1"`)
+			http.ServeContent(w, r, "toolchain.gz", time.Time{}, bytes.NewReader(gz.Bytes()))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "toolchain")
+	assert.NoError(t, downloadFile(server.URL+"/toolchain.gz", path))
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, content, data)
+	assert.NoFileExists(t, path+blockRepairSuffix)
+}
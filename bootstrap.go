@@ -5,17 +5,16 @@ import (
 	"bytes"
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"io/fs"
 	"os"
 	"os/exec"
-	"os/user"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
-	"github.com/schollz/progressbar/v3"
 	"github.com/spf13/cobra"
 )
 
@@ -35,21 +34,56 @@ var (
 	distbuildPath    string
 	deployAgent      bool
 	enableToolchains bool
+	envFiles         []string
+	verbose          bool
+	noProgress       bool
+	outputFormat     string
 )
 
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// jsonOutput keeps the real stdout for machine-readable results, human
+// output is moved to stderr in json mode.
+var jsonOutput = os.Stdout
+
 var rootCmd = &cobra.Command{
 	Use:     "bootstrap",
 	Short:   "boong bootstrap",
 	Version: BuildTime + "-" + CommitID,
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := checkFlags(); err != nil {
-			_, _ = fmt.Fprintln(os.Stderr, "Error:", err.Error())
-			os.Exit(1)
+			exitWithError(withCode(errCodeUsage, err))
+		}
+		ctx, cancel := runContext()
+		defer cancel()
+		start := time.Now()
+		startRecording()
+		err := run(ctx)
+		changes := recordedChanges()
+		if reportErr := writeTimingReport(start); reportErr != nil {
+			printMsg("warning", "text", reportErr)
+		}
+		if chownErr := handOverToInvoker(); chownErr != nil {
+			printMsg("warning", "text", chownErr)
+		}
+		if err != nil {
+			err = timeoutError(ctx, err)
+		}
+		if recordErr := writeSession(start, err); recordErr != nil {
+			printMsg("warning", "text", recordErr)
+		}
+		collectFailureDiagnostics(start, err)
+		sendNotification(newNotification("bootstrap", start, err))
+		if err != nil {
+			exitWithError(err)
 		}
-		ctx := context.Background()
-		if err := run(ctx); err != nil {
-			_, _ = fmt.Fprintln(os.Stderr, "Error:", err.Error())
-			os.Exit(1)
+		reportChanges(changes)
+		if outputFormat == outputJSON {
+			changed := len(changes) > 0
+			writeResult(runResult{Status: "ok", Changed: &changed, Changes: changes})
 		}
 	},
 }
@@ -60,10 +94,82 @@ func init() {
 	rootCmd.Flags().StringVar(&distbuildPath, "distbuild-path", "", "distbuild binaries path")
 	rootCmd.Flags().BoolVar(&deployAgent, "deploy-agent", false, "deploy agent service")
 	rootCmd.Flags().BoolVar(&enableToolchains, "enable-toolchains", false, "download prebuilt toolchains")
+	rootCmd.PersistentFlags().StringArrayVar(&envFiles, "env-file", nil, "additional env file layered over embedded defaults (repeatable)")
+	rootCmd.PersistentFlags().StringVar(&gitBinFlag, "git-bin", "", "git executable to run (default GIT_BIN or git from PATH)")
 
-	_ = rootCmd.MarkFlagRequired("distbuild-path")
 	rootCmd.MarkFlagsMutuallyExclusive("aosp-path", "deploy-agent")
 
+	addAgentLimitFlags(rootCmd)
+	addAgentLogFlags(rootCmd)
+	addAgentPortFlag(rootCmd)
+	addFirewallFlags(rootCmd)
+	addGatekeeperFlags(rootCmd)
+	addDependencyFlags(rootCmd)
+	addFilesystemFlags(rootCmd)
+	addSuperviseFlag(rootCmd)
+	addWatchdogFlags(rootCmd)
+	addTLSFlags(rootCmd)
+	addChecksumFlags(rootCmd)
+	addDialFlags(rootCmd)
+	addRequestHeaderFlags(rootCmd)
+	addChunkFlags(rootCmd)
+	addAgentLabelFlags(rootCmd)
+	addAgentEnvFlags(rootCmd)
+	addRepoFlags(rootCmd)
+	addInstallScopeFlags(rootCmd)
+	addPlatformFlags(rootCmd)
+	addArtifactVariantFlags(rootCmd)
+	addPreflightFlags(rootCmd)
+	addRemoteExecFlags(rootCmd)
+	addGitReferenceFlags(rootCmd)
+	addTimeoutFlags(rootCmd)
+	addTimingFlags(rootCmd)
+	addRecordFlags(rootCmd)
+	addDiagnosticsFlags(rootCmd)
+	addPrivilegeFlags(rootCmd)
+	addStateDirFlags(rootCmd)
+	addSafetyFlags(rootCmd)
+	addPromptFlags(rootCmd)
+	addPermissionFlags(rootCmd)
+	addLanguageFlag(rootCmd)
+	addPluginFlags(rootCmd)
+	addWebhookFlags(rootCmd)
+	addSchedulerConfigFlags(rootCmd)
+	rootCmd.Flags().StringVar(&compatMatrixSource, "compat-matrix", "", "compatibility matrix file or url (default embedded)")
+	rootCmd.Flags().StringVar(&toolchainManifestSource, "toolchain-manifest", "", "toolchain manifest file or url (default embedded)")
+	rootCmd.Flags().StringVar(&toolchainHostOS, "toolchain-host-os", "", "host os of the toolchains to provision: linux, darwin, windows (default current os)")
+	rootCmd.Flags().BoolVar(&strictCompat, "strict-compat", false, "fail instead of warn on compatibility mismatches")
+	rootCmd.Flags().BoolVar(&enableCache, "enable-cache", false, "configure compiler caching for the workspace")
+	rootCmd.Flags().BoolVar(&integrateAOSP, "integrate-aosp", false, "wire distbuild into the AOSP build (buildspec.mk)")
+	rootCmd.PersistentFlags().BoolVar(&noProgress, "no-progress", false, "disable progress bars, print plain status lines")
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "output", outputText, "output format (text, json, csv for fleet status)")
+	rootCmd.PersistentFlags().BoolVar(&verbose, "verbose", false, "print verbose output")
+
+	rootCmd.AddCommand(agentCmd)
+	rootCmd.AddCommand(applyCmd)
+	rootCmd.AddCommand(completionCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(deployCmd)
+	rootCmd.AddCommand(diagnoseCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(envCmd)
+	rootCmd.AddCommand(fleetCmd)
+	rootCmd.AddCommand(linksCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(packCmd)
+	rootCmd.AddCommand(packageCmd)
+	rootCmd.AddCommand(replayCmd)
+	rootCmd.AddCommand(rollbackCmd)
+	rootCmd.AddCommand(sbomCmd)
+	rootCmd.AddCommand(serverCmd)
+	rootCmd.AddCommand(snapshotCmd)
+	rootCmd.AddCommand(toolchainCmd)
+	rootCmd.AddCommand(verifyCmd)
+	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(workspaceCmd)
+
+	registerCompletions(rootCmd)
+
 	rootCmd.Root().CompletionOptions.DisableDefaultCmd = true
 }
 
@@ -73,58 +179,328 @@ func main() {
 	}
 }
 
-func run(_ context.Context) error {
-	if err := loadEnvFile(envFile); err != nil {
+func run(ctx context.Context) error {
+	runCtx = ctx
+
+	if err := loadPlugins(); err != nil {
+		return err
+	}
+
+	if err := runPluginPhases("start"); err != nil {
+		return err
+	}
+
+	if err := loadEnvFiles(envFile, envFiles); err != nil {
 		return fmt.Errorf("load .env failed: %w", err)
 	}
 
+	if err := setupHTTPClient(); err != nil {
+		return fmt.Errorf("setup http client failed: %w", err)
+	}
+
+	if err := resolveSecrets(); err != nil {
+		return err
+	}
+
+	// An unsupported release fails before the host is changed
+	var release *compatRelease
+	if aospPath != "" {
+		var err error
+		if release, err = checkCompatibility(); err != nil {
+			return fmt.Errorf("compatibility check failed: %w", err)
+		}
+	}
+
+	if err := pullSchedulerConfig(); err != nil {
+		return fmt.Errorf("pull scheduler config failed: %w", err)
+	}
+
+	if err := runPluginPhases("setup"); err != nil {
+		return err
+	}
+
+	if !skipFSCheck {
+		if err := checkWorkFilesystems(); err != nil {
+			return fmt.Errorf("filesystem check failed: %w", err)
+		}
+	}
+
+	if checkDeps || installDeps {
+		if err := ensureDependencies(); err != nil {
+			return fmt.Errorf("dependency check failed: %w", err)
+		}
+	}
+
+	if aospPath != "" {
+		if err := checkRemoteExec(); err != nil {
+			return err
+		}
+	}
+
+	if err := checkGit(); err != nil {
+		return err
+	}
+
+	if err := runPluginPhases("checks"); err != nil {
+		return err
+	}
+
 	if err := cloneDistbuildRepo(); err != nil {
 		return fmt.Errorf("git clone failed: %w", err)
 	}
 
+	if err := runPluginPhases("clone"); err != nil {
+		return err
+	}
+
+	if err := recordInstallScope(); err != nil {
+		return fmt.Errorf("record install scope failed: %w", err)
+	}
+
 	if err := downloadResources(); err != nil {
 		return fmt.Errorf("download resources failed: %w", err)
 	}
 
+	if err := runPluginPhases("download"); err != nil {
+		return err
+	}
+
+	if aospPath != "" {
+		if err := checkDistbuildCompatibility(release); err != nil {
+			return fmt.Errorf("compatibility check failed: %w", err)
+		}
+	}
+
+	if integrateAOSP && aospPath != "" {
+		if err := integrateWorkspace(); err != nil {
+			return fmt.Errorf("integrate aosp failed: %w", err)
+		}
+	}
+
+	if enableCache && aospPath != "" {
+		if err := configureCache(); err != nil {
+			return fmt.Errorf("configure cache failed: %w", err)
+		}
+	}
+
+	if aospPath != "" {
+		if err := disableRemoteExec(); err != nil {
+			return fmt.Errorf("disable remote execution failed: %w", err)
+		}
+		if err := writeSetupScripts(); err != nil {
+			return fmt.Errorf("write setup scripts failed: %w", err)
+		}
+	}
+
+	if err := runPluginPhases("aosp"); err != nil {
+		return err
+	}
+
+	agentChanged := false
 	if deployAgent {
-		if err := downloadAgent(); err != nil {
+		if !skipPreflight {
+			if err := preflightScheduler(); err != nil {
+				return err
+			}
+		}
+		var err error
+		if agentChanged, err = downloadAgent(); err != nil {
 			return fmt.Errorf("download agent failed: %w", err)
 		}
-		if err := installAgentService(); err != nil {
-			return fmt.Errorf("install agent service failed: %w", err)
+		switch {
+		case superviseAgentOn:
+			// The supervisor stays resident, it is started after every other phase
+		case hasSystemd():
+			if agentPortFlag != "" {
+				warn("agent.port_systemd")
+			}
+			changed, err := installAgentService()
+			if err != nil {
+				return fmt.Errorf("install agent service failed: %w", err)
+			}
+			if changed {
+				fmt.Println()
+				printMsg("service.installed")
+				printMsg("service.status")
+				fmt.Println()
+			}
+		default:
+			if err := ensureAgentRunning(agentChanged); err != nil {
+				return fmt.Errorf("run agent failed: %w", err)
+			}
+		}
+		if err := installWatchdog(); err != nil {
+			return fmt.Errorf("install watchdog failed: %w", err)
 		}
-		fmt.Println()
-		fmt.Println("agent service installed and started successfully!")
-		fmt.Println("check status: sudo systemctl status distbuild.service")
-		fmt.Println()
+	}
+
+	if err := runPluginPhases("agent"); err != nil {
+		return err
 	}
 
 	if enableToolchains {
 		if err := downloadToolchains(); err != nil {
 			return fmt.Errorf("download toolchains failed: %w", err)
 		}
+		if err := checkToolchainCompatibility(release); err != nil {
+			return fmt.Errorf("compatibility check failed: %w", err)
+		}
+	}
+
+	if err := runPluginPhases("toolchains"); err != nil {
+		return err
+	}
+
+	if aospPath != "" {
+		if err := registerWorkspace(); err != nil {
+			warn("workspace.register", "err", err)
+		}
+	}
+
+	if err := runPluginPhases("workspace"); err != nil {
+		return err
+	}
+
+	if deployAgent && superviseAgentOn && shouldDetach() {
+		running, err := agentRunning(agentChanged)
+		if err != nil {
+			return fmt.Errorf("supervise agent failed: %w", err)
+		}
+		if !running {
+			if err := startDetachedSupervisor(bootstrapFlags); err != nil {
+				return fmt.Errorf("supervise agent failed: %w", err)
+			}
+			noteChange("start supervisor")
+		}
+	} else if deployAgent && superviseAgentOn {
+		if err := superviseAgent(ctx); err != nil {
+			return fmt.Errorf("supervise agent failed: %w", err)
+		}
 	}
 
 	return nil
 }
 
-func installAgentService() error {
+// installAgentService installs the agent binary, unit and env file and
+// reports whether any changed. The service is restarted on a change and
+// otherwise only started or enabled when it is not.
+func installAgentService() (changed bool, err error) {
 	servicePath := "/etc/systemd/system/distbuild.service"
 	agentSource := filepath.Join(distbuildPath, "boong", "bin", "agent")
 	agentTarget := "/usr/local/bin/distbuild-agent"
 
-	bar, done, _ := runProgress("installing agent service...")
-	defer func() { _ = stopProgress(bar, done) }()
+	s := beginStep(msg("step.install_service"))
+	defer func() {
+		if changed || err != nil {
+			recordAudit("install-service", servicePath, err)
+		}
+		_ = s.end(err)
+	}()
 
-	if err := exec.Command("sudo", "mkdir", "-p", "/usr/local/bin").Run(); err != nil {
-		return fmt.Errorf("create bin directory failed: %w", err)
+	source, err := fileSHA256(agentSource)
+	if err != nil {
+		return false, fmt.Errorf("read agent failed: %w", err)
+	}
+
+	if target, err := fileSHA256(agentTarget); err != nil || target != source {
+		if err := runPrivileged(exec.Command("sudo", "mkdir", "-p", "/usr/local/bin")); err != nil {
+			return false, fmt.Errorf("create bin directory failed: %w", err)
+		}
+		// Copied, so the next run compares the download with it
+		if err := runPrivileged(exec.Command("sudo", "install", "-m", "0755", agentSource, agentTarget)); err != nil {
+			return false, fmt.Errorf("install agent failed: %w", err)
+		}
+		noteChange("install " + agentTarget)
+		changed = true
+	}
+
+	unitChanged, err := installFileWithSudo(agentServiceFile, servicePath)
+	if err != nil {
+		return false, fmt.Errorf("install service file failed: %w", err)
+	}
+
+	agentEnv, err := agentEnvFileContent()
+	if err != nil {
+		return false, err
+	}
+
+	envChanged, err := installFileWithSudo(agentEnv, agentEnvFilePath)
+	if err != nil {
+		return false, fmt.Errorf("install agent env file failed: %w", err)
+	}
+
+	changed = changed || unitChanged || envChanged
+
+	var commands [][]string
+	switch {
+	case changed:
+		if err := relabelAgentFiles(agentTarget, servicePath, agentEnvFilePath); err != nil {
+			return false, err
+		}
+		commands = [][]string{{"daemon-reload"}, {"enable", "distbuild.service"}, {"restart", "distbuild.service"}}
+	default:
+		if exec.Command("systemctl", "is-enabled", "--quiet", "distbuild.service").Run() != nil {
+			commands = append(commands, []string{"enable", "distbuild.service"})
+		}
+		if exec.Command("systemctl", "is-active", "--quiet", "distbuild.service").Run() != nil {
+			commands = append(commands, []string{"start", "distbuild.service"})
+		}
+	}
+
+	for _, args := range commands {
+		var output bytes.Buffer
+		cmd := exec.Command("sudo", append([]string{"systemctl"}, args...)...)
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		if err := runPrivileged(cmd); err != nil {
+			return false, fmt.Errorf("command failed [%s]: %w\n%s",
+				strings.Join(cmd.Args, " "), err, output.String())
+		}
+		noteChange("systemctl " + strings.Join(args, " "))
+		changed = true
+	}
+
+	if port, err := basePort(); err == nil {
+		checkAgentFirewall(port)
+	}
+
+	return changed, nil
+}
+
+func logVerbose(format string, args ...interface{}) {
+	if verbose {
+		_, _ = fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
+// installFileWithSudo installs content to target as root and reports
+// whether target changed.
+func installFileWithSudo(content, target string) (bool, error) {
+	if current, err := readPrivileged(target); err == nil && string(current) == content {
+		return false, nil
 	}
 
-	if err := exec.Command("sudo", "mv", agentSource, agentTarget).Run(); err != nil {
-		return fmt.Errorf("move agent failed: %w", err)
+	if err := moveFileWithSudo(content, target); err != nil {
+		return false, err
+	}
+
+	noteChange("write " + target)
+
+	return true, nil
+}
+
+// readPrivileged reads a file that may only be readable by root.
+func readPrivileged(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if !errors.Is(err, fs.ErrPermission) {
+		return data, err
 	}
 
-	tempFile, err := os.CreateTemp("", "distbuild-service-*.service")
+	return exec.Command("sudo", "-n", "cat", path).Output()
+}
+
+func moveFileWithSudo(content, target string) error {
+	tempFile, err := os.CreateTemp("", "distbuild-*"+filepath.Ext(target))
 	if err != nil {
 		return fmt.Errorf("create temp file failed: %w", err)
 	}
@@ -133,30 +509,21 @@ func installAgentService() error {
 		_ = os.Remove(name)
 	}(tempFile.Name())
 
-	if _, err := tempFile.WriteString(agentServiceFile); err != nil {
-		return fmt.Errorf("write service file failed: %w", err)
-	}
-
-	defer func(tempFile *os.File) {
+	if _, err := tempFile.WriteString(content); err != nil {
 		_ = tempFile.Close()
-	}(tempFile)
+		return fmt.Errorf("write temp file failed: %w", err)
+	}
 
-	moveCmd := exec.Command("sudo", "mv", tempFile.Name(), servicePath)
-	if err := moveCmd.Run(); err != nil {
-		return fmt.Errorf("install service file failed: %w", err)
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("close temp file failed: %w", err)
 	}
 
-	commands := []*exec.Cmd{
-		exec.Command("sudo", "systemctl", "daemon-reload"),
-		exec.Command("sudo", "systemctl", "enable", "distbuild.service"),
-		exec.Command("sudo", "systemctl", "start", "distbuild.service"),
+	if err := runPrivileged(exec.Command("sudo", "mkdir", "-p", filepath.Dir(target))); err != nil {
+		return fmt.Errorf("create directory failed: %w", err)
 	}
 
-	for _, cmd := range commands {
-		if output, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("command failed [%s]: %w\n%s",
-				strings.Join(cmd.Args, " "), err, string(output))
-		}
+	if err := runPrivileged(exec.Command("sudo", "mv", tempFile.Name(), target)); err != nil {
+		return fmt.Errorf("move file failed: %w", err)
 	}
 
 	return nil
@@ -165,48 +532,159 @@ func installAgentService() error {
 func checkFlags() error {
 	var err error
 
+	switch outputFormat {
+	case outputText:
+	case outputJSON:
+		jsonOutput = os.Stdout
+		os.Stdout = os.Stderr
+	default:
+		return fmt.Errorf("unsupported output format %q", outputFormat)
+	}
+
+	if err := checkWebhookFlags(); err != nil {
+		return err
+	}
+
+	if err := checkWatchdogFlags(); err != nil {
+		return err
+	}
+
+	if err := checkDetachFlags(); err != nil {
+		return err
+	}
+
+	if err := checkHealthFlags(); err != nil {
+		return err
+	}
+
+	if err := checkRemoteExecFlags(); err != nil {
+		return err
+	}
+
+	if err := checkArtifactVariantFlags(); err != nil {
+		return err
+	}
+
+	if err := checkAgentLimits(); err != nil {
+		return err
+	}
+
+	if err := checkTimeout(); err != nil {
+		return err
+	}
+
 	if aospPath == "" && !deployAgent {
 		return fmt.Errorf("--aosp-path or --deploy-agent flag is required")
 	}
 
-	aospPath, err = expandTildeIfPresent(aospPath)
+	if err := checkInstallScopeFlags(); err != nil {
+		return err
+	}
+
+	aospPath, err = expandPath(aospPath)
+	if err != nil {
+		return fmt.Errorf("failed to expand path: %w", err)
+	}
+
+	distbuildPath, err = expandPath(distbuildPath)
 	if err != nil {
-		return fmt.Errorf("failed to expand tilde: %w", err)
+		return fmt.Errorf("failed to expand path: %w", err)
+	}
+
+	if err := checkToolchainHostOS(); err != nil {
+		return err
+	}
+
+	if err := checkPlatform(); err != nil {
+		return err
+	}
+
+	if err := checkPrivileges(); err != nil {
+		return err
 	}
 
-	distbuildPath, err = expandTildeIfPresent(distbuildPath)
+	return checkGitReference()
+}
+
+type envEntry struct {
+	Key    string
+	Value  string
+	Source string
+	Line   int
+}
+
+func loadEnvFiles(embedded string, paths []string) error {
+	entries, err := layerEnvFiles(embedded, paths)
 	if err != nil {
-		return fmt.Errorf("failed to expand tilde: %w", err)
+		return err
+	}
+
+	for key, entry := range entries {
+		if _, ok := os.LookupEnv(key); !ok {
+			if err := os.Setenv(key, entry.Value); err != nil {
+				return err
+			}
+			envFileKeys[key] = true
+		}
 	}
 
 	return nil
 }
 
-func expandTildeIfPresent(path string) (string, error) {
-	if !strings.Contains(path, "~") {
-		return path, nil
+func layerEnvFiles(embedded string, paths []string) (map[string]envEntry, error) {
+	entries := map[string]envEntry{}
+
+	for _, entry := range parseEnvEntries(".env", embedded) {
+		entries[entry.Key] = entry
+	}
+
+	for _, entry := range buildDefaultEntries() {
+		entries[entry.Key] = entry
+	}
+
+	// A packed executable carries its own defaults
+	for _, entry := range parseEnvEntries("payload", payloadEnv()) {
+		entries[entry.Key] = entry
 	}
 
-	if strings.HasPrefix(path, "~") {
-		usr, err := user.Current()
+	for _, path := range paths {
+		path, err := expandPath(path)
 		if err != nil {
-			return "", err
+			return nil, fmt.Errorf("failed to expand path: %w", err)
 		}
-		if path == "~" {
-			return usr.HomeDir, nil
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read env file failed: %w", err)
 		}
-		if strings.HasPrefix(path, "~/") {
-			return filepath.Join(usr.HomeDir, path[2:]), nil
+		// Later files win over earlier ones and the embedded defaults
+		for _, entry := range parseEnvEntries(path, string(content)) {
+			entries[entry.Key] = entry
 		}
 	}
 
-	return path, nil
+	deriveArtifactURLs(entries)
+
+	return entries, nil
+}
+
+func parseEnv(content string) map[string]string {
+	vars := map[string]string{}
+
+	for _, entry := range parseEnvEntries("", content) {
+		vars[entry.Key] = entry.Value
+	}
+
+	return vars
 }
 
-func loadEnvFile(content string) error {
+func parseEnvEntries(source, content string) []envEntry {
+	var entries []envEntry
+
 	scanner := bufio.NewScanner(strings.NewReader(content))
+	lineNum := 0
 
 	for scanner.Scan() {
+		lineNum++
 		line := scanner.Text()
 		// Skip comments or empty lines
 		if line == "" || strings.HasPrefix(line, "#") {
@@ -218,168 +696,286 @@ func loadEnvFile(content string) error {
 			continue
 		}
 
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-
-		if _, ok := os.LookupEnv(key); !ok {
-			if err := os.Setenv(key, value); err != nil {
-				return err
-			}
-		}
+		entries = append(entries, envEntry{
+			Key:    strings.TrimSpace(parts[0]),
+			Value:  strings.TrimSpace(parts[1]),
+			Source: source,
+			Line:   lineNum,
+		})
 	}
 
-	return nil
+	return entries
 }
 
 func cloneDistbuildRepo() error {
-	targetPath := filepath.Join(aospPath, "build", "distbuild")
+	if useRepoTool {
+		return syncDistbuildRepo()
+	}
 
-	if err := os.RemoveAll(targetPath); err != nil {
-		return fmt.Errorf("failed to remove existing distbuild directory: %w", err)
+	host, layout, repos, err := distbuildRepos()
+	if err != nil {
+		return err
 	}
 
-	if err := os.MkdirAll(targetPath, 0755); err != nil {
-		return fmt.Errorf("create directory failed: %w", err)
+	rootPath := filepath.Join(aospPath, filepath.FromSlash(layout.Root))
+
+	if slices.IndexFunc(repos, func(r layoutRepo) bool { return !checkoutCurrent(host, r) }) < 0 {
+		logVerbose("distbuild checkout is up to date")
+		return nil
 	}
 
-	host, exists := os.LookupEnv("REPO_HOST")
-	if !exists || host == "" {
-		return fmt.Errorf("environment variable REPO_HOST not set")
+	if err := confirmRemoval("distbuild checkout", rootPath); err != nil {
+		return err
+	}
+
+	if err := removeAll(rootPath); err != nil {
+		return fmt.Errorf("failed to remove existing distbuild directory: %w", err)
+	}
+
+	if err := createDirs(rootPath); err != nil {
+		return fmt.Errorf("create directory failed: %w", err)
 	}
 
-	repo, exists := os.LookupEnv("DISTBUILD_REPO")
-	if !exists || repo == "" {
-		repo, exists = os.LookupEnv("WRAPPER_REPO")
-		if !exists || repo == "" {
-			return fmt.Errorf("environment variable DISTBUILD_REPO or WRAPPER_REPO not set")
+	// Repos nested in another are cloned after it, in layout order
+	for _, r := range repos {
+		if err := cloneLayoutRepo(host, r); err != nil {
+			return err
 		}
-		targetPath = filepath.Join(targetPath, "boong")
-		_ = os.MkdirAll(targetPath, 0755)
-		targetPath = filepath.Join(targetPath, "wrapper")
 	}
 
-	bar, done, _ := runProgress("clone repo...")
-	defer func(bar *progressbar.ProgressBar, done chan bool) {
-		_ = stopProgress(bar, done)
-	}(bar, done)
+	return nil
+}
+
+func cloneLayoutRepo(host string, r layoutRepo) error {
+	targetPath := filepath.Join(aospPath, r.Path)
+	_ = createDirs(filepath.Dir(targetPath))
 
-	cmd := exec.Command("git", "clone", fmt.Sprintf("%s/%s", host, repo), targetPath)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	s := beginStep(msg("step.clone", "repo", r.Repo))
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("%v\n%s", err, stderr.String())
+	args := append([]string{"clone"}, referenceArgs(r.Repo)...)
+	if r.Ref != "" {
+		args = append(args, "--branch", r.Ref)
 	}
 
-	return nil
+	if err := runGitProgress(s, r.Name+" clone", append(args, fmt.Sprintf("%s/%s", host, r.Repo), targetPath)...); err != nil {
+		// Do not leave a partial checkout behind
+		_ = removeAll(targetPath)
+		return s.end(err)
+	}
+
+	noteChange("clone " + r.Repo)
+
+	return s.end(nil)
 }
 
-func downloadAgent() error {
-	binDir := filepath.Join(distbuildPath, "boong", "bin")
-	if err := os.MkdirAll(binDir, 0755); err != nil {
-		return fmt.Errorf("create bin directory failed: %w", err)
+// checkoutCurrent reports whether the checkout of r is a clean clone of
+// its repo at the commit the ref points at, so recloning would change
+// nothing.
+func checkoutCurrent(host string, r layoutRepo) bool {
+	targetPath := filepath.Join(aospPath, r.Path)
+	repoURL := fmt.Sprintf("%s/%s", host, r.Repo)
+
+	origin, err := gitOutput("-C", targetPath, "remote", "get-url", "origin")
+	if err != nil || strings.TrimSpace(string(origin)) != repoURL {
+		return false
 	}
 
-	agentBin, exists := os.LookupEnv("AGENT_BIN")
-	if !exists || agentBin == "" {
-		fmt.Println("warning: environment variable AGENT_BIN not set")
+	status, err := gitOutput("-C", targetPath, "status", "--porcelain", "--untracked-files=no")
+	if err != nil || len(bytes.TrimSpace(status)) > 0 {
+		return false
+	}
+
+	head, err := resolveRevision(targetPath)
+	if err != nil {
+		return false
+	}
+
+	ref := r.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	return slices.Contains(remoteRevisions(repoURL, ref), head)
+}
+
+// remoteRevisions lists the commits the remote refs matching ref point at,
+// peeled tags included.
+func remoteRevisions(repoURL, ref string) []string {
+	out, err := gitOutput("ls-remote", repoURL, ref, ref+"^{}")
+	if err != nil {
 		return nil
 	}
 
-	bar, done, _ := runProgress("download agent...")
-	defer func(bar *progressbar.ProgressBar, done chan bool) {
-		_ = stopProgress(bar, done)
-	}(bar, done)
+	var revisions []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			revisions = append(revisions, fields[0])
+		}
+	}
 
-	return downloadFile(
-		agentBin,
-		filepath.Join(binDir, "agent"),
-	)
+	return revisions
 }
 
-func downloadResources() error {
+func downloadAgent() (bool, error) {
 	binDir := filepath.Join(distbuildPath, "boong", "bin")
-	if err := os.MkdirAll(binDir, 0755); err != nil {
-		return fmt.Errorf("create bin directory failed: %w", err)
+	if err := createDirs(binDir); err != nil {
+		return false, fmt.Errorf("create bin directory failed: %w", err)
 	}
 
-	proxyBin, exists := os.LookupEnv("PROXY_BIN")
-	if exists && proxyBin != "" {
-		bar, done, _ := runProgress("download proxy...")
-		defer func(bar *progressbar.ProgressBar, done chan bool) {
-			_ = stopProgress(bar, done)
-		}(bar, done)
-		if err := downloadFile(proxyBin, filepath.Join(binDir, "proxy")); err != nil {
-			return fmt.Errorf("download proxy binary failed: %w", err)
-		}
-		if err := createSymlinks("proxy"); err != nil {
-			return fmt.Errorf("create symlinks failed: %w", err)
-		}
-	} else {
-		fmt.Println("warning: environment variable PROXY_BIN not set")
+	agentBin := artifactURL("AGENT_BIN")
+	if agentBin == "" {
+		agentBin = payloadURL("agent")
+	}
+	if agentBin == "" {
+		warn("env.unset", "name", "AGENT_BIN")
+		return false, nil
 	}
 
-	distninjaBin, exists := os.LookupEnv("DISTNINJA_BIN")
-	if exists && distninjaBin != "" {
-		bar, done, _ := runProgress("download distninja...")
-		defer func(bar *progressbar.ProgressBar, done chan bool) {
-			_ = stopProgress(bar, done)
-		}(bar, done)
-		if err := downloadFile(distninjaBin, filepath.Join(binDir, "distninja")); err != nil {
-			return fmt.Errorf("download distninja binary failed: %w", err)
-		}
-		if err := createSymlinks("distninja"); err != nil {
-			return fmt.Errorf("create symlinks failed: %w", err)
-		}
-	} else {
-		fmt.Println("warning: environment variable DISTNINJA_BIN not set")
+	unlock, err := lockBinaries(binDir, "agent")
+	if err != nil {
+		return false, err
 	}
+	defer unlock()
 
-	return nil
+	agentPath := filepath.Join(binDir, agentBinaryName())
+	if publishedBinary(agentBin, agentPath) {
+		logVerbose("agent is up to date")
+		return false, recordComponent("agent", agentBin, agentPath)
+	}
+
+	s := beginStep(msg("step.download_agent"))
+
+	changed, err := installAgentBinary(agentBin, agentPath)
+	if err != nil {
+		return false, s.end(err)
+	}
+
+	if err := recordComponent("agent", agentBin, agentPath); err != nil {
+		return false, s.end(err)
+	}
+
+	return changed, s.end(smokeTestBinary("agent", "AGENT_BIN", agentPath))
 }
 
-func downloadFile(url, filePath string) error {
-	req, err := http.NewRequest("GET", url, nil)
+// installAgentBinary downloads the agent next to the installed one, which
+// seeds it for a delta update, and replaces the installed agent only when
+// the download differs from it.
+func installAgentBinary(artifactURL, agentPath string) (bool, error) {
+	staging := stagingPath(agentPath)
+	_ = os.Remove(staging)
+
+	defer func() {
+		_ = os.Remove(staging)
+	}()
+
+	if data, err := os.ReadFile(agentPath); err == nil {
+		if err := writeFileAtomic(staging, data, 0755); err != nil {
+			return false, fmt.Errorf("stage agent failed: %w", err)
+		}
+	}
+
+	if err := downloadBinary("agent", artifactURL, staging); err != nil {
+		return false, err
+	}
+
+	sum, err := fileSHA256(staging)
 	if err != nil {
-		return fmt.Errorf("create request failed: %v [%s]", err, filepath.Base(filePath))
+		return false, err
+	}
+
+	if installed, err := fileSHA256(agentPath); err == nil && installed == sum {
+		return false, nil
+	}
+
+	if err := os.Rename(staging, agentPath); err != nil {
+		return false, fmt.Errorf("install agent failed: %w", err)
 	}
 
-	username := os.Getenv("AUTH_USER")
-	password := os.Getenv("AUTH_PASS")
+	noteChange("install " + agentPath)
+
+	return true, nil
+}
 
-	if username != "" && password != "" {
-		req.SetBasicAuth(username, password)
+func downloadResources() error {
+	binDir := filepath.Join(distbuildPath, "boong", "bin")
+	if err := createDirs(binDir); err != nil {
+		return fmt.Errorf("create bin directory failed: %w", err)
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	unlock, err := lockBinaries(binDir, "proxy", "distninja")
 	if err != nil {
-		return fmt.Errorf("download failed: %v [%s]", err, filepath.Base(filePath))
+		return err
 	}
+	defer unlock()
 
-	defer func(Body io.ReadCloser) {
-		_ = Body.Close()
-	}(resp.Body)
+	var artifacts, current []artifact
+
+	for _, r := range []struct {
+		name string
+		env  string
+	}{
+		{name: "proxy", env: "PROXY_BIN"},
+		{name: "distninja", env: "DISTNINJA_BIN"},
+	} {
+		url := artifactURL(r.env)
+		if url == "" {
+			url = payloadURL(r.name)
+		}
+		if url == "" {
+			warn("env.unset", "name", r.env)
+			continue
+		}
+		link := filepath.Join(binDir, r.name)
+		if _, err := os.Readlink(link); err == nil && publishedBinary(url, link) {
+			logVerbose("%s is up to date", r.name)
+			current = append(current, artifact{name: r.name, url: url, path: link})
+			continue
+		}
+		staging, err := stageSlot(r.name, link)
+		if err != nil {
+			return fmt.Errorf("stage %s failed: %w", r.name, err)
+		}
+		artifacts = append(artifacts, artifact{name: r.name, url: url, path: staging})
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status code %d [%s]", resp.StatusCode, filepath.Base(filePath))
+	if err := downloadArtifacts(artifacts); err != nil {
+		return err
 	}
 
-	out, err := os.Create(filePath)
+	manifest, err := loadManifest()
 	if err != nil {
-		return fmt.Errorf("create file failed: %v [%s]", err, filepath.Base(filePath))
+		return err
 	}
 
-	defer func(out *os.File) {
-		_ = out.Close()
-	}(out)
+	artifacts = append(artifacts, current...)
 
-	if _, err = io.Copy(out, resp.Body); err != nil {
-		return fmt.Errorf("write file failed: %v [%s]", err, filepath.Base(filePath))
+	for _, a := range artifacts {
+		link := filepath.Join(binDir, a.name)
+		slot, err := os.Readlink(link)
+		if a.path != link {
+			slot, err = installSlot(a.name, a.path, link)
+		}
+		if err != nil {
+			return err
+		}
+		if err := recordComponent(a.name, a.url, link); err != nil {
+			return fmt.Errorf("record %s failed: %w", a.name, err)
+		}
+		if err := recordSlot(a.name, slot, manifest.Components[a.name]); err != nil {
+			return fmt.Errorf("record %s slot failed: %w", a.name, err)
+		}
+		if !linkHostBinaries() {
+			continue
+		}
+		if err := timed(timingLink, a.name, func() error { return createSymlinks(a.name) }); err != nil {
+			return fmt.Errorf("create symlinks failed: %w", err)
+		}
 	}
 
-	if err := os.Chmod(filePath, 0755); err != nil {
-		return fmt.Errorf("chmod failed: %v [%s]", err, filepath.Base(filePath))
+	for _, a := range artifacts {
+		if err := smokeTestBinary(a.name, strings.ToUpper(a.name)+"_BIN", filepath.Join(binDir, a.name)); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -387,15 +983,22 @@ func downloadFile(url, filePath string) error {
 
 func createSymlinks(name string) error {
 	source := filepath.Join(distbuildPath, "boong", "bin", name)
-	target := filepath.Join("/usr/local/bin", name)
+	target := filepath.Join(hostLinkDir, name)
+
+	// A shim installed by `links install --shim` runs the same binary
+	if current, err := os.Readlink(target); err == nil && current == source || shimTarget(target) == source {
+		return nil
+	}
 
 	cmd := exec.Command("sudo", "ln", "-sf", source, target)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("create symlink failed: %v [%s]", err, filepath.Base(name))
+	if err := runPrivileged(cmd); err != nil {
+		return withCode(errCodePermission, fmt.Errorf("create symlink failed: %v [%s]", err, filepath.Base(name)))
 	}
 
+	noteChange("link " + target)
+
 	return nil
 }
 
@@ -405,91 +1008,75 @@ func downloadToolchains() error {
 		return fmt.Errorf("environment variable REPO_HOST not set")
 	}
 
-	toolchains := []struct {
-		name string
-		repo string
-		path string
-	}{
-		{
-			name: "clang",
-			repo: fmt.Sprintf("%s/platform/prebuilts/clang/host/linux-x86", host),
-			path: filepath.Join(distbuildPath, "prebuilts/clang/host/linux-x86"),
-		},
-		{
-			name: "gcc",
-			repo: fmt.Sprintf("%s/platform/prebuilts/gcc/linux-x86/host/x86_64-linux-glibc2.17-4.8", host),
-			path: filepath.Join(distbuildPath, "prebuilts/gcc/linux-x86/host/x86_64-linux-glibc2.17-4.8"),
-		},
-	}
-
-	for _, tc := range toolchains {
-		if err := cloneToolchain(tc.repo, tc.path, tc.name); err != nil {
+	manifest, err := loadToolchainManifest(toolchainManifestSource)
+	if err != nil {
+		return err
+	}
+
+	specs := manifest.forHost(toolchainHostOS)
+	if len(specs) == 0 {
+		return fmt.Errorf("toolchain manifest has no toolchains for %s", toolchainHostOS)
+	}
+
+	for _, tc := range specs {
+		repo := host + "/" + tc.Repo
+		path, err := installToolchain(repo, tc)
+		if err != nil {
 			return err
 		}
+		if err := recordToolchain(tc.key(), repo, path); err != nil {
+			return fmt.Errorf("record %s failed: %w", tc.Name, err)
+		}
 	}
 
 	return nil
 }
 
-func cloneToolchain(repo, path, name string) error {
-	if err := os.RemoveAll(path); err != nil {
+func cloneToolchain(repo, path string, tc toolchainSpec) (err error) {
+	name := tc.Name
+
+	if err := removeAll(path); err != nil {
 		return fmt.Errorf("failed to remove existing %s directory: %w", name, err)
 	}
 
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+	if err := createDirs(filepath.Dir(path)); err != nil {
 		return fmt.Errorf("create directory for %s failed: %w", name, err)
 	}
 
-	bar, done, _ := runProgress(fmt.Sprintf("clone %s...", name))
-	defer func(bar *progressbar.ProgressBar, done chan bool) {
-		_ = stopProgress(bar, done)
-	}(bar, done)
+	s := beginStep(msg("step.clone", "repo", name))
+	defer func() { _ = s.end(err) }()
 
-	cmd := exec.Command("git", "clone", repo, "-b", "master", "--depth", "1", path)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	sparse := len(tc.Sparse) > 0 && hasGitFeature(gitSparseCommand)
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("%s clone failed: %v\n%s", name, err, stderr.String())
+	args := append([]string{"clone"}, referenceArgs(tc.Repo)...)
+	args = append(args, repo, "-b", tc.Branch, "--depth", "1")
+	if sparse {
+		args = append(args, "--no-checkout")
+		// Only fetch the blobs of the sparse paths, on checkout
+		if hasGitFeature(gitPartialClone) {
+			args = append(args, "--filter=blob:none")
+		}
 	}
 
-	return nil
-}
+	if err := runGitProgress(s, name+" clone", append(args, path)...); err != nil {
+		return err
+	}
 
-func runProgress(description string) (*progressbar.ProgressBar, chan bool, error) {
-	bar := progressbar.NewOptions(-1,
-		progressbar.OptionSetDescription(description),
-		progressbar.OptionSetTheme(progressbar.Theme{
-			Saucer:        "=",
-			SaucerHead:    ">",
-			SaucerPadding: "",
-			BarStart:      "[",
-			BarEnd:        "]",
-		}),
-		progressbar.OptionOnCompletion(func() {
-			fmt.Println("")
-		}),
-	)
-
-	done := make(chan bool)
-
-	go func() {
-		for {
-			select {
-			case <-done:
-				return
-			default:
-				_ = bar.Add(1)
-				time.Sleep(100 * time.Millisecond)
-			}
+	if sparse {
+		logVerbose("%s sparse checkout: %s", name, strings.Join(tc.Sparse, " "))
+		if err := runGit(name+" sparse-checkout", append([]string{"-C", path}, sparseCheckoutArgs(tc.Sparse)...)...); err != nil {
+			return err
 		}
-	}()
-
-	return bar, done, nil
-}
+		if err := runGit(name+" checkout", "-C", path, "checkout", tc.Branch); err != nil {
+			return err
+		}
+	}
 
-func stopProgress(bar *progressbar.ProgressBar, done chan bool) error {
-	done <- true
+	if err := verifyToolchainRevision(path, tc); err != nil {
+		// Never leave unverified compiler binaries behind
+		_ = removeAll(path)
+		return err
+	}
 
-	return bar.Finish()
+	return fetchLFSObjects(path, name)
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -9,3 +11,31 @@ import (
 func TestBootstrap(t *testing.T) {
 	assert.Equal(t, nil, nil)
 }
+
+func TestParseEnv(t *testing.T) {
+	vars := parseEnv("# comment\n\nREPO_HOST = host\nINVALID\nAUTH_PASS=a=b\n")
+	assert.Equal(t, map[string]string{"REPO_HOST": "host", "AUTH_PASS": "a=b"}, vars)
+}
+
+func TestLoadEnvFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.env")
+	second := filepath.Join(dir, "second.env")
+	assert.NoError(t, os.WriteFile(first, []byte("BOOTSTRAP_TEST_A=first\nBOOTSTRAP_TEST_B=first\n"), 0600))
+	assert.NoError(t, os.WriteFile(second, []byte("BOOTSTRAP_TEST_B=second\n"), 0600))
+
+	t.Setenv("BOOTSTRAP_TEST_A", "")
+	t.Setenv("BOOTSTRAP_TEST_B", "")
+	t.Setenv("BOOTSTRAP_TEST_C", "")
+	_ = os.Unsetenv("BOOTSTRAP_TEST_A")
+	_ = os.Unsetenv("BOOTSTRAP_TEST_B")
+	_ = os.Unsetenv("BOOTSTRAP_TEST_C")
+
+	err := loadEnvFiles("BOOTSTRAP_TEST_A=embedded\nBOOTSTRAP_TEST_C=embedded\n", []string{first, second})
+	assert.NoError(t, err)
+	assert.Equal(t, "first", os.Getenv("BOOTSTRAP_TEST_A"))
+	assert.Equal(t, "second", os.Getenv("BOOTSTRAP_TEST_B"))
+	assert.Equal(t, "embedded", os.Getenv("BOOTSTRAP_TEST_C"))
+
+	assert.Error(t, loadEnvFiles("", []string{filepath.Join(dir, "missing.env")}))
+}
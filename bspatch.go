@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"fmt"
+	"io"
+)
+
+const bsdiffMagic = "BSDIFF40"
+
+// bspatch applies a BSDIFF40 patch to old and returns the new content. The
+// header comes from the mirror, a new size above maxSize is refused before
+// anything is allocated.
+func bspatch(old, patch []byte, maxSize int64) ([]byte, error) {
+	if len(patch) < 32 || string(patch[:8]) != bsdiffMagic {
+		return nil, fmt.Errorf("invalid bsdiff header")
+	}
+
+	ctrlLen := offtin(patch[8:16])
+	diffLen := offtin(patch[16:24])
+	newSize := offtin(patch[24:32])
+
+	// Compared one at a time, the sum of the lengths may overflow
+	body := int64(len(patch)) - 32
+	if ctrlLen < 0 || ctrlLen > body || diffLen < 0 || diffLen > body-ctrlLen {
+		return nil, fmt.Errorf("corrupt bsdiff header")
+	}
+
+	if newSize < 0 || newSize > maxSize {
+		return nil, fmt.Errorf("corrupt bsdiff header, new size %d exceeds %d", newSize, maxSize)
+	}
+
+	ctrl := bzip2.NewReader(bytes.NewReader(patch[32 : 32+ctrlLen]))
+	diff := bzip2.NewReader(bytes.NewReader(patch[32+ctrlLen : 32+ctrlLen+diffLen]))
+	extra := bzip2.NewReader(bytes.NewReader(patch[32+ctrlLen+diffLen:]))
+
+	out := make([]byte, newSize)
+	buf := make([]byte, 8)
+
+	var oldPos, newPos int64
+
+	for newPos < newSize {
+		var triple [3]int64
+		for i := range triple {
+			if _, err := io.ReadFull(ctrl, buf); err != nil {
+				return nil, fmt.Errorf("read control block failed: %w", err)
+			}
+			triple[i] = offtin(buf)
+		}
+
+		if triple[0] < 0 || triple[0] > newSize-newPos {
+			return nil, fmt.Errorf("corrupt bsdiff control block")
+		}
+
+		if _, err := io.ReadFull(diff, out[newPos:newPos+triple[0]]); err != nil {
+			return nil, fmt.Errorf("read diff block failed: %w", err)
+		}
+
+		for i := int64(0); i < triple[0]; i++ {
+			if oldPos+i >= 0 && oldPos+i < int64(len(old)) {
+				out[newPos+i] += old[oldPos+i]
+			}
+		}
+
+		newPos += triple[0]
+		oldPos += triple[0]
+
+		if triple[1] < 0 || triple[1] > newSize-newPos {
+			return nil, fmt.Errorf("corrupt bsdiff control block")
+		}
+
+		if _, err := io.ReadFull(extra, out[newPos:newPos+triple[1]]); err != nil {
+			return nil, fmt.Errorf("read extra block failed: %w", err)
+		}
+
+		newPos += triple[1]
+		oldPos += triple[2]
+	}
+
+	return out, nil
+}
+
+// offtin decodes the sign-magnitude little-endian integers used by bsdiff.
+func offtin(buf []byte) int64 {
+	y := int64(buf[7] & 0x7f)
+
+	for i := 6; i >= 0; i-- {
+		y = y*256 + int64(buf[i])
+	}
+
+	if buf[7]&0x80 != 0 {
+		y = -y
+	}
+
+	return y
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const (
+	cacheModeCcache = "ccache"
+	cacheModeRemote = "remote"
+)
+
+var enableCache bool
+
+// configureCache sets up compiler caching for the workspace, either local
+// ccache or the distbuild remote cache, based on CACHE_MODE.
+func configureCache() error {
+	mode := os.Getenv("CACHE_MODE")
+	if mode == "" {
+		mode = cacheModeCcache
+	}
+
+	var lines []string
+
+	switch mode {
+	case cacheModeCcache:
+		ccacheLines, err := configureCcache()
+		if err != nil {
+			return err
+		}
+		lines = ccacheLines
+	case cacheModeRemote:
+		endpoint := os.Getenv("REMOTE_CACHE_ENDPOINT")
+		if endpoint == "" {
+			return fmt.Errorf("environment variable REMOTE_CACHE_ENDPOINT not set")
+		}
+		if err := validateBinaryURL(endpoint); err != nil {
+			return fmt.Errorf("invalid REMOTE_CACHE_ENDPOINT: %w", err)
+		}
+		lines = []string{"export DISTBUILD_CACHE_ENDPOINT := " + endpoint}
+	default:
+		return fmt.Errorf("unsupported CACHE_MODE %q", mode)
+	}
+
+	changed, err := applyManagedBlock(filepath.Join(aospPath, "buildspec.mk"), "distbuild cache", lines)
+	if err != nil {
+		return fmt.Errorf("update buildspec.mk failed: %w", err)
+	}
+
+	if changed {
+		printMsg("cache.configured", "mode", mode)
+	}
+
+	return nil
+}
+
+// ccacheSettings finds the ccache binary and the cache directory of the
+// workspace.
+func ccacheSettings() (string, string, error) {
+	ccache, err := exec.LookPath("ccache")
+	if err != nil {
+		// AOSP checkouts up to Android 11 ship a prebuilt ccache
+		ccache = filepath.Join(aospPath, "prebuilts", "misc", "linux-x86", "ccache", "ccache")
+		if _, statErr := os.Stat(ccache); statErr != nil {
+			return "", "", fmt.Errorf("ccache not found in PATH or prebuilts")
+		}
+	}
+
+	cacheDir := os.Getenv("CCACHE_DIR")
+	if cacheDir == "" {
+		cacheDir = filepath.Join(aospPath, ".ccache")
+	}
+
+	cacheDir, err = expandPath(cacheDir)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to expand path: %w", err)
+	}
+
+	return ccache, cacheDir, nil
+}
+
+func configureCcache() ([]string, error) {
+	ccache, cacheDir, err := ccacheSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := createDirs(cacheDir); err != nil {
+		return nil, fmt.Errorf("create ccache directory failed: %w", err)
+	}
+
+	if maxSize := os.Getenv("CCACHE_MAXSIZE"); maxSize != "" {
+		cmd := exec.Command(ccache, "-M", maxSize)
+		cmd.Env = append(os.Environ(), "CCACHE_DIR="+cacheDir)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("set ccache max size failed: %v\n%s", err, stderr.String())
+		}
+	}
+
+	return []string{
+		"export USE_CCACHE := 1",
+		"export CCACHE_EXEC := " + ccache,
+		"export CCACHE_DIR := " + cacheDir,
+	}, nil
+}
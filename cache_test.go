@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigureRemoteCache(t *testing.T) {
+	defer func(path string) {
+		aospPath = path
+	}(aospPath)
+	aospPath = t.TempDir()
+
+	t.Setenv("CACHE_MODE", cacheModeRemote)
+	t.Setenv("REMOTE_CACHE_ENDPOINT", "https://cache.example.com")
+	assert.NoError(t, configureCache())
+
+	data, err := os.ReadFile(filepath.Join(aospPath, "buildspec.mk"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "export DISTBUILD_CACHE_ENDPOINT := https://cache.example.com")
+
+	t.Setenv("CACHE_MODE", "bogus")
+	assert.Error(t, configureCache())
+}
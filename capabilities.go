@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// The agent registers itself with the scheduler and sends the labels of
+// agentLabelsEnv along. Bootstrap makes no registration call of its own.
+const (
+	agentLabelsEnv   = "DISTBUILD_AGENT_LABELS"
+	agentEnvFilePath = "/etc/distbuild/agent.env"
+)
+
+var agentLabels []string
+
+var compilerNames = []string{"gcc", "g++", "clang", "clang++"}
+
+type hostCapabilities struct {
+	CPUs      int
+	MemoryMB  int64
+	DiskType  string
+	Compilers []string
+	KVM       bool
+}
+
+func addAgentLabelFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&agentLabels, "agent-label", nil, "extra agent label key=value, overrides probed labels (repeatable)")
+}
+
+func probeHostCapabilities(path string) hostCapabilities {
+	caps := hostCapabilities{
+		CPUs:     runtime.NumCPU(),
+		MemoryMB: hostMemoryMB(),
+		DiskType: diskType(path),
+		KVM:      hasKVM(),
+	}
+
+	for _, name := range compilerNames {
+		if _, err := exec.LookPath(name); err == nil {
+			caps.Compilers = append(caps.Compilers, name)
+		}
+	}
+
+	return caps
+}
+
+func (c hostCapabilities) labels() map[string]string {
+	labels := map[string]string{
+		"os":        runtime.GOOS,
+		"arch":      runtime.GOARCH,
+		"cpus":      strconv.Itoa(c.CPUs),
+		"disk":      c.DiskType,
+		"kvm":       strconv.FormatBool(c.KVM),
+		"compilers": strings.Join(c.Compilers, "+"),
+	}
+
+	if c.MemoryMB > 0 {
+		labels["memory_mb"] = strconv.FormatInt(c.MemoryMB, 10)
+	}
+
+	return labels
+}
+
+// agentLabelString merges probed labels with --agent-label overrides into
+// the sorted key=value list handed to the agent.
+func agentLabelString() (string, error) {
+	labels := probeHostCapabilities(distbuildPath).labels()
+
+	for _, label := range agentLabels {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return "", fmt.Errorf("invalid agent label %q, expected key=value", label)
+		}
+		labels[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return formatLabels(labels), nil
+}
+
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+labels[key])
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func agentEnvFileContent() (string, error) {
+	labels, err := agentLabelString()
+	if err != nil {
+		return "", err
+	}
+
+	content := fmt.Sprintf("%s=%s\n", agentLabelsEnv, labels)
+	for _, entry := range agentExtraEnv {
+		content += entry + "\n"
+	}
+
+	return content, nil
+}
+
+func hasKVM() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+
+	f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+
+	_ = f.Close()
+
+	return true
+}
@@ -0,0 +1,72 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func hostMemoryMB() int64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb / 1024
+		}
+	}
+
+	return 0
+}
+
+// diskType reports whether the block device holding path is rotational.
+func diskType(path string) string {
+	var st syscall.Stat_t
+
+	for {
+		if err := syscall.Stat(path, &st); err == nil {
+			break
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "unknown"
+		}
+		path = parent
+	}
+
+	major := (st.Dev >> 8) & 0xfff
+	minor := (st.Dev & 0xff) | ((st.Dev >> 12) & 0xfff00)
+	dev := fmt.Sprintf("/sys/dev/block/%d:%d", major, minor)
+
+	// Partitions carry the queue attributes on their parent device
+	for _, candidate := range []string{dev + "/queue/rotational", dev + "/../queue/rotational"} {
+		data, err := os.ReadFile(candidate)
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(data)) == "0" {
+			return "ssd"
+		}
+		return "hdd"
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+func hostMemoryMB() int64 {
+	return 0
+}
+
+func diskType(_ string) string {
+	return "unknown"
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatLabels(t *testing.T) {
+	assert.Equal(t, "a=1,b=2", formatLabels(map[string]string{"b": "2", "a": "1"}))
+}
+
+func TestAgentLabelString(t *testing.T) {
+	defer func(labels []string, path string) {
+		agentLabels, distbuildPath = labels, path
+	}(agentLabels, distbuildPath)
+
+	distbuildPath = t.TempDir()
+	agentLabels = []string{"rack=a", "cpus=1"}
+
+	labels, err := agentLabelString()
+	assert.NoError(t, err)
+	assert.Contains(t, strings.Split(labels, ","), "rack=a")
+	assert.Contains(t, strings.Split(labels, ","), "cpus=1")
+
+	agentLabels = []string{"broken"}
+	_, err = agentLabelString()
+	assert.Error(t, err)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"slices"
+	"sync"
+)
+
+// changeLog collects what a run changed on the host. Every phase skips
+// work that is already in place, so a run over a converged host records
+// nothing and reports no changes.
+var changeLog struct {
+	mu      sync.Mutex
+	changes []string
+}
+
+// noteChange records that the run changed what, once.
+func noteChange(what string) {
+	changeLog.mu.Lock()
+	defer changeLog.mu.Unlock()
+
+	if !slices.Contains(changeLog.changes, what) {
+		changeLog.changes = append(changeLog.changes, what)
+	}
+}
+
+func recordedChanges() []string {
+	changeLog.mu.Lock()
+	defer changeLog.mu.Unlock()
+
+	return slices.Clone(changeLog.changes)
+}
+
+func resetChanges() {
+	changeLog.mu.Lock()
+	defer changeLog.mu.Unlock()
+
+	changeLog.changes = nil
+}
+
+// reportChanges tells whether the run changed the host, configuration
+// management keys its changed state off this.
+func reportChanges(changes []string) {
+	if len(changes) == 0 {
+		printMsg("run.no_changes")
+		return
+	}
+
+	printMsg("run.changes", "count", len(changes))
+	for _, c := range changes {
+		logVerbose("changed: %s", c)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFileAtomicUnchanged(t *testing.T) {
+	resetChanges()
+	defer resetChanges()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config")
+
+	assert.NoError(t, writeFileAtomic(path, []byte("a=1\n"), 0644))
+	assert.Equal(t, []string{"write " + path}, recordedChanges())
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	assert.NoError(t, os.Chtimes(path, old, old))
+	resetChanges()
+
+	// Identical content is not rewritten
+	assert.NoError(t, writeFileAtomic(path, []byte("a=1\n"), 0644))
+	assert.Empty(t, recordedChanges())
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, old, info.ModTime())
+
+	assert.NoError(t, writeFileAtomic(path, []byte("a=2\n"), 0644))
+	assert.Equal(t, []string{"write " + path}, recordedChanges())
+
+	// Staged downloads are scratch files
+	resetChanges()
+	assert.NoError(t, writeFileAtomic(stagingPath(filepath.Join(dir, "proxy")), []byte("proxy"), 0755))
+	assert.Empty(t, recordedChanges())
+}
+
+func TestNoteChangeOnce(t *testing.T) {
+	resetChanges()
+	defer resetChanges()
+
+	noteChange("start agent")
+	noteChange("clone distbuild")
+	noteChange("start agent")
+
+	assert.Equal(t, []string{"start agent", "clone distbuild"}, recordedChanges())
+}
+
+func TestReportChanges(t *testing.T) {
+	out := captureStdout(t, func() { reportChanges(nil) })
+	assert.Contains(t, out, msg("run.no_changes"))
+
+	out = captureStdout(t, func() { reportChanges([]string{"start agent", "clone distbuild"}) })
+	assert.Contains(t, out, msg("run.changes", "count", 2))
+}
+
+func TestPublishedBinary(t *testing.T) {
+	resetChecksums()
+	defer resetChecksums()
+
+	current := sha256Hex([]byte("hello there!"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/agent"+patchIndexSuffix {
+			_, _ = fmt.Fprintf(w, `{"target_sha256":%q}`, current)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "agent")
+	assert.False(t, publishedBinary(server.URL+"/agent", path))
+
+	assert.NoError(t, os.WriteFile(path, []byte("hello world"), 0755))
+	assert.False(t, publishedBinary(server.URL+"/agent", path))
+
+	assert.NoError(t, os.WriteFile(path, []byte("hello there!"), 0755))
+	assert.True(t, publishedBinary(server.URL+"/agent", path))
+
+	// Without a patch index the artifact has to be fetched to tell
+	assert.False(t, publishedBinary(server.URL+"/proxy", path))
+}
+
+func TestInstallAgentBinaryUnchanged(t *testing.T) {
+	resetChanges()
+	defer resetChanges()
+	defer resetChecksums()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/agent" {
+			_, _ = w.Write([]byte("agent v2"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "agent")
+	assert.NoError(t, os.WriteFile(path, []byte("agent v1"), 0755))
+
+	changed, err := installAgentBinary(server.URL+"/agent", path)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "agent v2", string(data))
+	assert.Equal(t, []string{"install " + path}, recordedChanges())
+
+	resetChanges()
+	changed, err = installAgentBinary(server.URL+"/agent", path)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Empty(t, recordedChanges())
+	assert.NoFileExists(t, stagingPath(path))
+}
+
+func TestInstallToolchainUnchanged(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	resetChanges()
+	defer resetChanges()
+
+	src := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "clang"), []byte("clang"), 0644))
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "master"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		assert.NoError(t, exec.Command("git", append([]string{"-C", src}, args...)...).Run())
+	}
+
+	defer func(path string) {
+		distbuildPath = path
+	}(distbuildPath)
+	distbuildPath = t.TempDir()
+
+	tc := toolchainSpec{Name: "clang", Branch: "master", Path: "prebuilts/clang"}
+	first, err := installToolchain("file://"+src, tc)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"link " + filepath.Join(distbuildPath, tc.Path)}, recordedChanges())
+
+	// The branch head is installed and linked, nothing is cloned again
+	resetChanges()
+	second, err := installToolchain("file://"+src, tc)
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.Empty(t, recordedChanges())
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+// checksumSignatureSuffix names the detached signature published next to
+// the checksum manifest.
+const checksumSignatureSuffix = ".sig"
+
+var errNoChecksumEntry = errors.New("no checksum entry")
+
+// allowUnsignedChecksums trusts a checksum manifest without a signature,
+// for mirrors that publish none.
+var allowUnsignedChecksums bool
+
+func addChecksumFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVar(&allowUnsignedChecksums, "allow-unsigned-checksums", false,
+		"trust CHECKSUMS_URL without CHECKSUMS_PUBLIC_KEY, the run is audited (env ALLOW_UNSIGNED_CHECKSUMS)")
+}
+
+// unsignedChecksumsAllowed tells whether the flag or ALLOW_UNSIGNED_CHECKSUMS
+// opts out of the manifest signature.
+func unsignedChecksumsAllowed() (bool, error) {
+	if allowUnsignedChecksums {
+		return true, nil
+	}
+
+	value := os.Getenv("ALLOW_UNSIGNED_CHECKSUMS")
+	if value == "" {
+		return false, nil
+	}
+
+	allowed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, withCode(errCodeUsage, fmt.Errorf("invalid ALLOW_UNSIGNED_CHECKSUMS: %w", err))
+	}
+
+	return allowed, nil
+}
+
+// checksumManifest maps the file names of a SHA256SUMS file to their sums.
+type checksumManifest map[string]string
+
+var (
+	checksumsMu     sync.Mutex
+	checksumsLoaded bool
+	checksums       checksumManifest
+)
+
+// loadChecksums fetches and verifies the CHECKSUMS_URL manifest once per
+// run. Without CHECKSUMS_URL downloads are not verified, a manifest without
+// CHECKSUMS_PUBLIC_KEY is refused unless unsigned manifests are allowed.
+func loadChecksums() (checksumManifest, error) {
+	checksumsMu.Lock()
+	defer checksumsMu.Unlock()
+
+	if checksumsLoaded {
+		return checksums, nil
+	}
+
+	manifestURL := os.Getenv("CHECKSUMS_URL")
+	if manifestURL == "" {
+		checksumsLoaded = true
+		return nil, nil
+	}
+
+	keyPath := os.Getenv("CHECKSUMS_PUBLIC_KEY")
+	if keyPath == "" {
+		allowed, err := unsignedChecksumsAllowed()
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, withCode(errCodeVerification, fmt.Errorf(
+				"CHECKSUMS_PUBLIC_KEY not set, refusing the unsigned checksum manifest, pass --allow-unsigned-checksums to trust it"))
+		}
+	}
+
+	data, err := fetchBytes(manifestURL)
+	if err != nil {
+		return nil, fmt.Errorf("download checksum manifest failed: %w", err)
+	}
+
+	if keyPath != "" {
+		if err := verifyChecksumSignature(manifestURL, keyPath, data); err != nil {
+			return nil, err
+		}
+		logVerbose("checksum manifest signature verified")
+	} else {
+		warn("checksums.unsigned")
+		recordAudit("allow-unsigned-checksums", redactURL(manifestURL), nil)
+	}
+
+	manifest, err := parseChecksums(data)
+	if err != nil {
+		return nil, withCode(errCodeVerification, fmt.Errorf("parse checksum manifest failed: %w", err))
+	}
+
+	checksums, checksumsLoaded = manifest, true
+
+	return checksums, nil
+}
+
+// parseChecksums reads the `sha256sum` output format, "<hex>  <name>" with
+// a "*" before the name of files hashed in binary mode.
+func parseChecksums(data []byte) (checksumManifest, error) {
+	manifest := checksumManifest{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sum, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("line %d: missing file name", lineNum)
+		}
+		if _, err := hex.DecodeString(sum); err != nil || len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("line %d: invalid sha256 %q", lineNum, sum)
+		}
+
+		name = strings.TrimPrefix(strings.TrimLeft(name, " *"), "./")
+		manifest[name] = strings.ToLower(sum)
+	}
+
+	return manifest, scanner.Err()
+}
+
+// lookup finds the entry of an artifact. Entries may carry directories, an
+// entry matches when the artifact path ends with it.
+func (m checksumManifest) lookup(artifactURL string) (string, string, bool) {
+	p := artifactURL
+	if u, err := url.Parse(artifactURL); err == nil && u.Path != "" {
+		p = u.Path
+	}
+
+	if sum, ok := m[path.Base(p)]; ok {
+		return path.Base(p), sum, true
+	}
+
+	for name, sum := range m {
+		if strings.HasSuffix(p, "/"+name) {
+			return name, sum, true
+		}
+	}
+
+	return "", "", false
+}
+
+// verifyChecksum compares the sha256 of the downloaded bytes of an artifact
+// with its manifest entry.
+func verifyChecksum(artifactURL, sum string) error {
+	manifest, err := loadChecksums()
+	if err != nil || manifest == nil {
+		return err
+	}
+
+	name, want, ok := manifest.lookup(artifactURL)
+	if !ok {
+		return withCode(errCodeVerification, fmt.Errorf("%w for %s in checksum manifest", errNoChecksumEntry, artifactURL))
+	}
+
+	if sum != want {
+		return withCode(errCodeVerification, fmt.Errorf("checksum mismatch for %s: got %s, want %s", name, sum, want))
+	}
+
+	logVerbose("%s matches checksum manifest", name)
+
+	return nil
+}
+
+func verifyChecksumSignature(manifestURL, keyPath string, data []byte) error {
+	keyPath, err := expandPath(keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to expand path: %w", err)
+	}
+
+	keyData, err := os.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("read checksum public key failed: %w", err)
+	}
+
+	signature, err := fetchBytes(manifestURL + checksumSignatureSuffix)
+	if err != nil {
+		return withCode(errCodeVerification, fmt.Errorf("download checksum manifest signature failed: %w", err))
+	}
+
+	if err := verifySignature(keyData, data, signature); err != nil {
+		return withCode(errCodeVerification, fmt.Errorf("checksum manifest signature invalid: %w", err))
+	}
+
+	return nil
+}
+
+// verifySignature checks a detached signature over data with a PEM public
+// key. Signatures are accepted raw or base64 encoded, as `openssl dgst
+// -sign` and `cosign sign-blob` write them.
+func verifySignature(keyPEM, data, signature []byte) error {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return fmt.Errorf("public key is not PEM encoded")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("parse public key failed: %w", err)
+	}
+
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature))); err == nil {
+		signature = decoded
+	}
+
+	digest := sha256.Sum256(data)
+
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, data, signature) {
+			return fmt.Errorf("ed25519 verification failed")
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return fmt.Errorf("ecdsa verification failed")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+
+	return nil
+}
+
+// verifyFileChecksum verifies the downloaded file sumPath and removes
+// filePath when it does not match.
+func verifyFileChecksum(artifactURL, sumPath, filePath string) error {
+	sum, err := fileSHA256(sumPath)
+	if err != nil {
+		return err
+	}
+
+	if err := verifyChecksum(artifactURL, sum); err != nil {
+		_ = os.Remove(filePath)
+		return err
+	}
+
+	return nil
+}
+
+func fileSHA256(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func resetChecksums() {
+	checksumsMu.Lock()
+	defer checksumsMu.Unlock()
+
+	checksums, checksumsLoaded = nil, false
+}
+
+func TestParseChecksums(t *testing.T) {
+	sum := sha256Hex([]byte("agent"))
+
+	manifest, err := parseChecksums([]byte(fmt.Sprintf("# release 1.2\n%s  agent\n%s *./linux/proxy\n", sum, sum)))
+	assert.NoError(t, err)
+	assert.Equal(t, checksumManifest{"agent": sum, "linux/proxy": sum}, manifest)
+
+	name, _, ok := manifest.lookup("https://artifacts/release/linux/proxy?token=x")
+	assert.True(t, ok)
+	assert.Equal(t, "linux/proxy", name)
+
+	_, _, ok = manifest.lookup("https://artifacts/release/distninja")
+	assert.False(t, ok)
+
+	_, err = parseChecksums([]byte("deadbeef  agent\n"))
+	assert.Error(t, err)
+}
+
+func TestDownloadVerifiesSignedChecksums(t *testing.T) {
+	defer func(quiet bool) {
+		noProgress = quiet
+	}(noProgress)
+	noProgress = true
+	defer resetChecksums()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "release.pub")
+	assert.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644))
+
+	sums := []byte(fmt.Sprintf("%s  agent\n%s  proxy\n", sha256Hex([]byte("agent")), sha256Hex([]byte("other"))))
+	signature := sums
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/SHA256SUMS":
+			_, _ = w.Write(sums)
+		case "/SHA256SUMS.sig":
+			_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, signature))))
+		case "/agent", "/proxy", "/distninja":
+			_, _ = w.Write([]byte("agent"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("CHECKSUMS_URL", server.URL+"/SHA256SUMS")
+	t.Setenv("CHECKSUMS_PUBLIC_KEY", keyPath)
+
+	assert.NoError(t, downloadFile(server.URL+"/agent", filepath.Join(dir, "agent")))
+
+	// Wrong content and unlisted artifacts are rejected and removed
+	err = downloadFile(server.URL+"/proxy", filepath.Join(dir, "proxy"))
+	assert.Equal(t, errCodeVerification, errorCodeOf(err))
+	assert.NoFileExists(t, filepath.Join(dir, "proxy"))
+
+	err = downloadFile(server.URL+"/distninja", filepath.Join(dir, "distninja"))
+	assert.ErrorIs(t, err, errNoChecksumEntry)
+	assert.NoFileExists(t, filepath.Join(dir, "distninja"))
+
+	// A manifest that does not match its signature is not trusted
+	resetChecksums()
+	signature = []byte("tampered")
+	err = downloadFile(server.URL+"/agent", filepath.Join(dir, "agent"))
+	assert.Equal(t, errCodeVerification, errorCodeOf(err))
+	assert.Contains(t, err.Error(), "signature invalid")
+}
+
+func TestUnsignedChecksumsRefused(t *testing.T) {
+	defer func(state string, allow bool) {
+		stateDirFlag, allowUnsignedChecksums = state, allow
+	}(stateDirFlag, allowUnsignedChecksums)
+	defer resetChecksums()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "%s  agent\n", sha256Hex([]byte("agent")))
+	}))
+	defer server.Close()
+
+	stateDirFlag, allowUnsignedChecksums = t.TempDir(), false
+	t.Setenv("CHECKSUMS_URL", server.URL+"/SHA256SUMS")
+	t.Setenv("CHECKSUMS_PUBLIC_KEY", "")
+	t.Setenv("ALLOW_UNSIGNED_CHECKSUMS", "")
+
+	resetChecksums()
+	_, err := loadChecksums()
+	assert.Equal(t, errCodeVerification, errorCodeOf(err))
+	assert.Contains(t, err.Error(), "--allow-unsigned-checksums")
+
+	t.Setenv("ALLOW_UNSIGNED_CHECKSUMS", "maybe")
+	_, err = loadChecksums()
+	assert.Equal(t, errCodeUsage, errorCodeOf(err))
+
+	// The opt-out trusts the manifest and leaves an audit entry
+	t.Setenv("ALLOW_UNSIGNED_CHECKSUMS", "true")
+	captureStdout(t, func() {
+		manifest, err := loadChecksums()
+		assert.NoError(t, err)
+		assert.Contains(t, manifest, "agent")
+	})
+
+	entries := readAuditLog(t)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "allow-unsigned-checksums", entries[0].Action)
+		assert.Equal(t, server.URL+"/SHA256SUMS", entries[0].Target)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	chunkSizeMB         int64
+	chunkThresholdMB    int64
+	downloadConcurrency int
+)
+
+func addChunkFlags(cmd *cobra.Command) {
+	cmd.Flags().Int64Var(&chunkSizeMB, "chunk-size", 16, "chunk size in MB for ranged downloads")
+	cmd.Flags().Int64Var(&chunkThresholdMB, "chunk-threshold", 64, "artifacts at least this many MB are downloaded in chunks")
+	cmd.Flags().IntVar(&downloadConcurrency, "download-concurrency", 4, "parallel chunks per artifact, 1 disables chunking")
+}
+
+// errRangeChanged reports a range response that is not a slice of the
+// probed artifact, as when it was republished during the download.
+var errRangeChanged = errors.New("artifact changed during ranged download")
+
+// probeRanges returns the artifact size, the validator that ties ranges to
+// this version of it and whether the server accepts byte range requests.
+// The validator is the strong ETag, else the Last-Modified date. Without
+// one, ranges could be spliced from two versions, so none are used.
+func probeRanges(ctx context.Context, url string) (int64, string, bool) {
+	req, err := newGetRequest(ctx, url)
+	if err != nil {
+		return 0, "", false
+	}
+
+	req.Method = http.MethodHead
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, "", false
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Accept-Ranges") != "bytes" {
+		return 0, "", false
+	}
+
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+
+	if validator == "" {
+		logVerbose("%s has no ETag or Last-Modified, not using range requests", redactURL(url))
+		return resp.ContentLength, "", false
+	}
+
+	return resp.ContentLength, validator, resp.ContentLength > 0
+}
+
+func shouldDownloadChunked(size int64, ranged bool) bool {
+	return ranged && downloadConcurrency > 1 && chunkSizeMB > 0 && size >= chunkThresholdMB*1024*1024
+}
+
+// downloadChunked fetches size bytes of url as parallel ranged requests and
+// writes them into place, replacing filePath only once every chunk landed.
+func downloadChunked(ctx context.Context, url, validator, filePath string, size int64) error {
+	name := filepath.Base(filePath)
+
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), "."+name+"-*")
+	if err != nil {
+		return fmt.Errorf("create file failed: %v [%s]", err, name)
+	}
+
+	defer func(name string) {
+		_ = os.Remove(name)
+	}(tmp.Name())
+
+	defer func(tmp *os.File) {
+		_ = tmp.Close()
+	}(tmp)
+
+	if err := tmp.Truncate(size); err != nil {
+		return fmt.Errorf("allocate file failed: %v [%s]", err, name)
+	}
+
+	if activeTransfers != nil {
+		activeTransfers.addTotal(size)
+	}
+
+	chunkSize := chunkSizeMB * 1024 * 1024
+	offsets := make(chan int64)
+	errs := make(chan error, downloadConcurrency)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < downloadConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range offsets {
+				end := start + chunkSize - 1
+				if end >= size {
+					end = size - 1
+				}
+				if err := fetchRange(ctx, url, validator, tmp, start, end); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	var firstErr error
+
+	for start := int64(0); start < size && firstErr == nil; start += chunkSize {
+		select {
+		case offsets <- start:
+		case firstErr = <-errs:
+		}
+	}
+
+	close(offsets)
+	wg.Wait()
+	close(errs)
+
+	if firstErr == nil {
+		firstErr = <-errs
+	}
+
+	if firstErr != nil {
+		return fmt.Errorf("download failed: %w [%s]", firstErr, name)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write file failed: %v [%s]", err, name)
+	}
+
+	if err := os.Chmod(tmp.Name(), policyFileMode(0755)); err != nil {
+		return fmt.Errorf("chmod failed: %v [%s]", err, name)
+	}
+
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return fmt.Errorf("rename failed: %v [%s]", err, name)
+	}
+
+	return nil
+}
+
+// fetchRange writes bytes start to end of url into out. The request is
+// conditional on validator, a server that no longer has that version
+// answers with the whole artifact, which fails with errRangeChanged.
+func fetchRange(ctx context.Context, url, validator string, out io.WriterAt, start, end int64) error {
+	req, err := newGetRequest(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10))
+	if validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode == http.StatusOK {
+		return fmt.Errorf("%w: range request returned the whole artifact", errRangeChanged)
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("range request returned %w", newStatusError(resp))
+	}
+
+	if !matchContentRange(resp.Header.Get("Content-Range"), start, end) {
+		return fmt.Errorf("%w: requested bytes %d-%d, got %q", errRangeChanged, start, end, resp.Header.Get("Content-Range"))
+	}
+
+	body := io.LimitReader(resp.Body, end-start+1)
+	if activeTransfers != nil {
+		body = &countingReader{r: body, p: activeTransfers}
+	}
+
+	written, err := io.Copy(io.NewOffsetWriter(out, start), body)
+	if err != nil {
+		return err
+	}
+
+	if written != end-start+1 {
+		return fmt.Errorf("short chunk at offset %d: got %d bytes", start, written)
+	}
+
+	return nil
+}
+
+// matchContentRange tells whether a Content-Range header covers exactly
+// bytes start to end.
+func matchContentRange(header string, start, end int64) bool {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return false
+	}
+
+	spec, _, ok = strings.Cut(spec, "/")
+	if !ok {
+		return false
+	}
+
+	return spec == strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10)
+}
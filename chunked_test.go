@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadChunked(t *testing.T) {
+	defer func(size, threshold int64, concurrency int) {
+		chunkSizeMB, chunkThresholdMB, downloadConcurrency = size, threshold, concurrency
+	}(chunkSizeMB, chunkThresholdMB, downloadConcurrency)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 256*1024+3)
+	var rangeRequests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			rangeRequests.Add(1)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "distninja", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	chunkSizeMB, chunkThresholdMB, downloadConcurrency = 1, 1, 4
+
+	size, validator, ranged := probeRanges(runCtx, server.URL)
+	assert.True(t, ranged)
+	assert.Equal(t, `"v1"`, validator)
+	assert.Equal(t, int64(len(content)), size)
+	assert.True(t, shouldDownloadChunked(size, ranged))
+
+	path := filepath.Join(t.TempDir(), "distninja")
+	assert.NoError(t, downloadFile(server.URL, path))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+	assert.Equal(t, int32(5), rangeRequests.Load())
+
+	downloadConcurrency = 1
+	assert.False(t, shouldDownloadChunked(size, ranged))
+}
+
+func TestDownloadChunkedRepublished(t *testing.T) {
+	defer func(size, threshold int64, concurrency int) {
+		chunkSizeMB, chunkThresholdMB, downloadConcurrency = size, threshold, concurrency
+	}(chunkSizeMB, chunkThresholdMB, downloadConcurrency)
+
+	old := bytes.Repeat([]byte("0123456789abcdef"), 256*1024)
+	current := bytes.Repeat([]byte("fedcba9876543210"), 256*1024)
+	var republished atomic.Bool
+
+	// The artifact is republished once the first chunk was served
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, etag := old, `"v1"`
+		if republished.Load() {
+			content, etag = current, `"v2"`
+		}
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "distninja", time.Time{}, bytes.NewReader(content))
+		if r.Header.Get("Range") != "" {
+			republished.Store(true)
+		}
+	}))
+	defer server.Close()
+
+	chunkSizeMB, chunkThresholdMB, downloadConcurrency = 1, 1, 2
+
+	path := filepath.Join(t.TempDir(), "distninja")
+	assert.NoError(t, downloadFile(server.URL, path))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, current, data)
+}
+
+func TestProbeRangesValidator(t *testing.T) {
+	var etag, modified string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", "4")
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		if modified != "" {
+			w.Header().Set("Last-Modified", modified)
+		}
+	}))
+	defer server.Close()
+
+	_, _, ranged := probeRanges(runCtx, server.URL)
+	assert.False(t, ranged)
+
+	etag, modified = `W/"v1"`, "Wed, 14 Oct 2026 10:00:00 GMT"
+	_, validator, ranged := probeRanges(runCtx, server.URL)
+	assert.True(t, ranged)
+	assert.Equal(t, modified, validator)
+
+	etag = `W/"v1"`
+	modified = ""
+	_, _, ranged = probeRanges(runCtx, server.URL)
+	assert.False(t, ranged)
+}
+
+func TestMatchContentRange(t *testing.T) {
+	assert.True(t, matchContentRange("bytes 0-1023/4096", 0, 1023))
+	assert.False(t, matchContentRange("bytes 0-4095/4096", 0, 1023))
+	assert.False(t, matchContentRange("bytes 1024-2047/4096", 0, 1023))
+	assert.False(t, matchContentRange("", 0, 1023))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//go:embed compat.json
+var embeddedCompatMatrix []byte
+
+var (
+	compatMatrixSource string
+	strictCompat       bool
+)
+
+type compatMatrix struct {
+	Releases []compatRelease `json:"releases"`
+}
+
+type compatRelease struct {
+	SDK          int    `json:"sdk"`
+	Name         string `json:"name"`
+	Supported    bool   `json:"supported"`
+	Reason       string `json:"reason,omitempty"`
+	MinDistbuild string `json:"min_distbuild,omitempty"`
+	Clang        string `json:"clang,omitempty"`
+	JDK          string `json:"jdk,omitempty"`
+}
+
+func loadCompatMatrix(source string) (*compatMatrix, error) {
+	data := embeddedCompatMatrix
+
+	switch {
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		fetched, err := fetchBytes(source)
+		if err != nil {
+			return nil, fmt.Errorf("fetch compat matrix failed: %w", err)
+		}
+		data = fetched
+	case source != "":
+		path, err := expandPath(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand path: %w", err)
+		}
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("read compat matrix failed: %w", err)
+		}
+	}
+
+	matrix := &compatMatrix{}
+	if err := json.Unmarshal(data, matrix); err != nil {
+		return nil, fmt.Errorf("parse compat matrix failed: %w", err)
+	}
+
+	return matrix, nil
+}
+
+func (m *compatMatrix) lookup(sdk int) *compatRelease {
+	for i := range m.Releases {
+		if m.Releases[i].SDK == sdk {
+			return &m.Releases[i]
+		}
+	}
+
+	return nil
+}
+
+// checkCompatibility compares the workspace release with the support matrix.
+// Unsupported releases always fail, mismatches fail only in strict mode. It
+// reads the AOSP checkout only, so it runs before anything is installed.
+func checkCompatibility() (*compatRelease, error) {
+	sdk, err := detectPlatformSDK(aospPath)
+	if err != nil {
+		return nil, warnOrFail(fmt.Sprintf("detect aosp release failed: %v", err))
+	}
+
+	matrix, err := loadCompatMatrix(compatMatrixSource)
+	if err != nil {
+		return nil, err
+	}
+
+	release := matrix.lookup(sdk)
+	if release == nil {
+		return nil, warnOrFail(fmt.Sprintf("aosp sdk %d is not in the compatibility matrix", sdk))
+	}
+
+	if !release.Supported {
+		msg := fmt.Sprintf("aosp %s (sdk %d) is not supported", release.Name, sdk)
+		if release.Reason != "" {
+			msg += ": " + release.Reason
+		}
+		return nil, fmt.Errorf("%s", msg)
+	}
+
+	logVerbose("aosp %s (sdk %d) is supported", release.Name, sdk)
+
+	return release, nil
+}
+
+// checkDistbuildCompatibility checks the distbuild checkout against the
+// minimum version the release needs, once it is cloned.
+func checkDistbuildCompatibility(release *compatRelease) error {
+	if release == nil || release.MinDistbuild == "" {
+		return nil
+	}
+
+	version, err := distbuildCheckoutVersion()
+	if err != nil {
+		return warnOrFail(fmt.Sprintf("detect distbuild version failed: %v", err))
+	}
+
+	if compareVersions(version, release.MinDistbuild) < 0 {
+		return warnOrFail(fmt.Sprintf("aosp %s requires distbuild %s or newer, found %s", release.Name, release.MinDistbuild, version))
+	}
+
+	return nil
+}
+
+func checkToolchainCompatibility(release *compatRelease) error {
+	if release == nil {
+		return nil
+	}
+
+	hostDir := toolchainHostDir(toolchainHostOS)
+
+	if release.Clang != "" {
+		clangPath := filepath.Join(distbuildPath, "prebuilts/clang/host", hostDir, release.Clang)
+		if _, err := os.Stat(clangPath); err != nil {
+			return warnOrFail(fmt.Sprintf("aosp %s requires %s, not found in toolchains", release.Name, release.Clang))
+		}
+	}
+
+	if release.JDK != "" {
+		jdkPath := filepath.Join(distbuildPath, "prebuilts/jdk", release.JDK, hostDir)
+		if _, err := os.Stat(jdkPath); err != nil {
+			return warnOrFail(fmt.Sprintf("aosp %s requires %s, not found in toolchains", release.Name, release.JDK))
+		}
+	}
+
+	return nil
+}
+
+func warnOrFail(msg string) error {
+	if strictCompat {
+		return fmt.Errorf("%s", msg)
+	}
+
+	printMsg("warning", "text", msg)
+
+	return nil
+}
+
+func detectPlatformSDK(root string) (int, error) {
+	path := filepath.Join(root, "build", "make", "core", "version_defaults.mk")
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "PLATFORM_SDK_VERSION" && (fields[1] == ":=" || fields[1] == "=") {
+			return strconv.Atoi(fields[2])
+		}
+	}
+
+	return 0, fmt.Errorf("PLATFORM_SDK_VERSION not found in %s", path)
+}
+
+func distbuildCheckoutVersion() (string, error) {
+	_, _, subPath, err := distbuildRepoSource()
+	if err != nil {
+		return "", err
+	}
+
+	out, err := gitOutput("-C", filepath.Join(aospPath, subPath), "describe", "--tags", "--abbrev=0")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// compareVersions compares dotted numeric versions, ignoring a leading "v".
+func compareVersions(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
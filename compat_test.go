@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, 0, compareVersions("v1.2.0", "1.2"))
+	assert.Equal(t, -1, compareVersions("1.2.3", "1.10"))
+	assert.Equal(t, 1, compareVersions("2.0", "1.99.1"))
+}
+
+func TestDetectPlatformSDK(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "build", "make", "core")
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "version_defaults.mk"),
+		[]byte("ifndef PLATFORM_SDK_VERSION\n  PLATFORM_SDK_VERSION := 34\nendif\n"), 0644))
+
+	sdk, err := detectPlatformSDK(root)
+	assert.NoError(t, err)
+	assert.Equal(t, 34, sdk)
+}
+
+func TestEmbeddedCompatMatrix(t *testing.T) {
+	matrix, err := loadCompatMatrix("")
+	assert.NoError(t, err)
+	assert.NotNil(t, matrix.lookup(34))
+	assert.Nil(t, matrix.lookup(1))
+}
+
+func TestCheckToolchainCompatibility(t *testing.T) {
+	defer func(path, hostOS string, strict bool) {
+		distbuildPath, toolchainHostOS, strictCompat = path, hostOS, strict
+	}(distbuildPath, toolchainHostOS, strictCompat)
+
+	distbuildPath = t.TempDir()
+	toolchainHostOS = "linux"
+	strictCompat = true
+
+	release := &compatRelease{Name: "android15", JDK: "jdk21"}
+	assert.Error(t, checkToolchainCompatibility(release))
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(distbuildPath, "prebuilts", "jdk", "jdk21", "linux-x86"), 0755))
+	assert.NoError(t, checkToolchainCompatibility(release))
+}
+
+func TestCheckCompatibilityBeforeInstall(t *testing.T) {
+	defer func(aosp, source string, strict bool) {
+		aospPath, compatMatrixSource, strictCompat = aosp, source, strict
+	}(aospPath, compatMatrixSource, strictCompat)
+
+	aospPath, strictCompat = t.TempDir(), true
+	dir := filepath.Join(aospPath, "build", "make", "core")
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "version_defaults.mk"), []byte("PLATFORM_SDK_VERSION := 28\n"), 0644))
+
+	compatMatrixSource = filepath.Join(t.TempDir(), "compat.json")
+	assert.NoError(t, os.WriteFile(compatMatrixSource, []byte(`{"releases":[
+		{"sdk":28,"name":"android9","supported":false,"reason":"no soong"},
+		{"sdk":34,"name":"android14","supported":true,"min_distbuild":"2.0"}]}`), 0644))
+
+	// Decided from the AOSP checkout alone, distbuild is not cloned yet
+	_, err := checkCompatibility()
+	assert.ErrorContains(t, err, "aosp android9 (sdk 28) is not supported: no soong")
+	assert.NoDirExists(t, filepath.Join(aospPath, "build", "distbuild"))
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "version_defaults.mk"), []byte("PLATFORM_SDK_VERSION := 34\n"), 0644))
+	release, err := checkCompatibility()
+	assert.NoError(t, err)
+	assert.Equal(t, "android14", release.Name)
+
+	// The distbuild version is checked once the checkout exists
+	assert.Error(t, checkDistbuildCompatibility(release))
+	assert.NoError(t, checkDistbuildCompatibility(&compatRelease{Name: "android14"}))
+}
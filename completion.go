@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+var completionCmd = &cobra.Command{
+	Use:       "completion [bash|zsh|fish|powershell]",
+	Short:     "print the shell completion script",
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: completionShells,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := generateCompletion(os.Stdout, args[0]); err != nil {
+			exitWithError(withCode(errCodeUsage, err))
+		}
+	},
+}
+
+var completionInstallCmd = &cobra.Command{
+	Use:       "install [bash|zsh|fish|powershell]",
+	Short:     "install the shell completion script for the current user",
+	Args:      cobra.MaximumNArgs(1),
+	ValidArgs: completionShells,
+	Run: func(cmd *cobra.Command, args []string) {
+		shell := detectShell()
+		if len(args) > 0 {
+			shell = args[0]
+		}
+		if err := installCompletion(shell); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	completionCmd.AddCommand(completionInstallCmd)
+}
+
+// registerCompletions wires the dynamic completion values of root flags.
+func registerCompletions(cmd *cobra.Command) {
+	_ = cmd.RegisterFlagCompletionFunc("output", fixedCompletion(outputText, outputJSON))
+	_ = cmd.RegisterFlagCompletionFunc("toolchain-host-os", fixedCompletion(toolchainHostOSes...))
+	_ = cmd.RegisterFlagCompletionFunc("distbuild-path", registeredDistbuildPaths)
+	_ = cmd.RegisterFlagCompletionFunc("aosp-path", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	})
+
+	workspaceUpdateCmd.ValidArgsFunction = registeredWorkspaces
+	workspaceRemoveCmd.ValidArgsFunction = registeredWorkspaces
+}
+
+func fixedCompletion(values ...string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return values, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func registeredWorkspaces(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	registry, err := loadRegistry()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	paths := make([]string, 0, len(registry.Workspaces))
+	for _, ws := range registry.Workspaces {
+		paths = append(paths, ws.Path)
+	}
+
+	return paths, cobra.ShellCompDirectiveNoFileComp
+}
+
+func registeredDistbuildPaths(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	registry, err := loadRegistry()
+	if err != nil || len(registry.Workspaces) == 0 {
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	}
+
+	seen := map[string]bool{}
+	var paths []string
+	for _, ws := range registry.Workspaces {
+		if !seen[ws.DistbuildPath] {
+			seen[ws.DistbuildPath] = true
+			paths = append(paths, ws.DistbuildPath)
+		}
+	}
+
+	return paths, cobra.ShellCompDirectiveDefault
+}
+
+func generateCompletion(w io.Writer, shell string) error {
+	switch shell {
+	case "bash":
+		return rootCmd.GenBashCompletionV2(w, true)
+	case "zsh":
+		return rootCmd.GenZshCompletion(w)
+	case "fish":
+		return rootCmd.GenFishCompletion(w, true)
+	case "powershell":
+		return rootCmd.GenPowerShellCompletionWithDesc(w)
+	default:
+		return fmt.Errorf("unsupported shell %q, expected one of %s", shell, strings.Join(completionShells, ", "))
+	}
+}
+
+func detectShell() string {
+	if shell := filepath.Base(os.Getenv("SHELL")); shell != "." && shell != "" {
+		return strings.TrimSuffix(shell, ".exe")
+	}
+
+	if runtime.GOOS == "windows" {
+		return "powershell"
+	}
+
+	return "bash"
+}
+
+// completionTarget returns where shell picks up completion scripts for the
+// current user, and a profile file that must source it when the shell has
+// no autoload directory.
+func completionTarget(shell, home string) (script, profile string, err error) {
+	name := rootCmd.Name()
+
+	switch shell {
+	case "bash":
+		dataHome := os.Getenv("XDG_DATA_HOME")
+		if dataHome == "" {
+			dataHome = filepath.Join(home, ".local", "share")
+		}
+		return filepath.Join(dataHome, "bash-completion", "completions", name), "", nil
+	case "zsh":
+		// oh-my-zsh keeps $ZSH/completions in fpath
+		if omz := os.Getenv("ZSH"); omz != "" {
+			return filepath.Join(omz, "completions", "_"+name), "", nil
+		}
+		zdot := os.Getenv("ZDOTDIR")
+		if zdot == "" {
+			zdot = home
+		}
+		return filepath.Join(zdot, ".zfunc", "_"+name), filepath.Join(zdot, ".zshrc"), nil
+	case "fish":
+		configHome := os.Getenv("XDG_CONFIG_HOME")
+		if configHome == "" {
+			configHome = filepath.Join(home, ".config")
+		}
+		return filepath.Join(configHome, "fish", "completions", name+".fish"), "", nil
+	case "powershell":
+		dir := filepath.Join(home, ".config", "powershell")
+		if runtime.GOOS == "windows" {
+			dir = filepath.Join(home, "Documents", "PowerShell")
+		}
+		return filepath.Join(dir, "Completions", name+".ps1"), filepath.Join(dir, "Microsoft.PowerShell_profile.ps1"), nil
+	default:
+		return "", "", withCode(errCodeUsage, fmt.Errorf("unsupported shell %q, expected one of %s", shell, strings.Join(completionShells, ", ")))
+	}
+}
+
+// profileLine is appended to the shell profile to load the script.
+func profileLine(shell, script string) string {
+	if shell == "zsh" {
+		return "fpath=(" + filepath.Dir(script) + " $fpath); autoload -Uz compinit && compinit"
+	}
+
+	return ". '" + script + "'"
+}
+
+func installCompletion(shell string) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	script, profile, err := completionTarget(shell, home)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := generateCompletion(&buf, shell); err != nil {
+		return withCode(errCodeUsage, err)
+	}
+
+	if err := createDirs(filepath.Dir(script)); err != nil {
+		return fmt.Errorf("create completion directory failed: %w", err)
+	}
+
+	if err := writeFileAtomic(script, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("write completion script failed: %w", err)
+	}
+
+	printMsg("completion.installed", "shell", shell, "path", script)
+
+	if profile != "" {
+		added, err := appendProfileLine(profile, profileLine(shell, script))
+		if err != nil {
+			return fmt.Errorf("update %s failed: %w", profile, err)
+		}
+		if added {
+			printMsg("completion.profile", "profile", profile)
+		}
+	}
+
+	switch shell {
+	case "fish":
+		// fish autoloads completions on the next prompt
+	case "zsh":
+		// Drop the compinit cache so the new function is picked up
+		zdot := os.Getenv("ZDOTDIR")
+		if zdot == "" {
+			zdot = home
+		}
+		matches, _ := filepath.Glob(filepath.Join(zdot, ".zcompdump*"))
+		for _, match := range matches {
+			_ = os.Remove(match)
+		}
+		printMsg("completion.restart", "command", "exec zsh")
+	case "bash":
+		printMsg("completion.restart", "command", "source "+script)
+	case "powershell":
+		printMsg("completion.restart", "command", ". '"+script+"'")
+	}
+
+	return nil
+}
+
+// appendProfileLine adds line to the profile unless it is already there.
+func appendProfileLine(profile, line string) (bool, error) {
+	content, err := os.ReadFile(profile)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if strings.Contains(string(content), line) {
+		return false, nil
+	}
+
+	if err := createDirs(filepath.Dir(profile)); err != nil {
+		return false, err
+	}
+
+	f, err := os.OpenFile(profile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return false, err
+	}
+
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	prefix := ""
+	if len(content) > 0 && !strings.HasSuffix(string(content), "\n") {
+		prefix = "\n"
+	}
+
+	_, err = fmt.Fprintf(f, "%s# %s shell completion\n%s\n", prefix, rootCmd.Name(), line)
+
+	return true, err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompletionTarget(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("ZSH", "")
+	t.Setenv("ZDOTDIR", "")
+
+	script, profile, err := completionTarget("bash", "/home/u")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("/home/u", ".local", "share", "bash-completion", "completions", "bootstrap"), script)
+	assert.Empty(t, profile)
+
+	script, profile, err = completionTarget("zsh", "/home/u")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("/home/u", ".zfunc", "_bootstrap"), script)
+	assert.Equal(t, filepath.Join("/home/u", ".zshrc"), profile)
+
+	t.Setenv("ZSH", "/home/u/.oh-my-zsh")
+	script, profile, err = completionTarget("zsh", "/home/u")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("/home/u/.oh-my-zsh", "completions", "_bootstrap"), script)
+	assert.Empty(t, profile)
+
+	_, _, err = completionTarget("tcsh", "/home/u")
+	assert.Equal(t, errCodeUsage, errorCodeOf(err))
+}
+
+func TestAppendProfileLine(t *testing.T) {
+	profile := filepath.Join(t.TempDir(), ".zshrc")
+	assert.NoError(t, os.WriteFile(profile, []byte("export EDITOR=vim"), 0644))
+
+	added, err := appendProfileLine(profile, "fpath=(/x $fpath)")
+	assert.NoError(t, err)
+	assert.True(t, added)
+
+	added, err = appendProfileLine(profile, "fpath=(/x $fpath)")
+	assert.NoError(t, err)
+	assert.False(t, added)
+
+	content, _ := os.ReadFile(profile)
+	assert.Equal(t, "export EDITOR=vim\n# bootstrap shell completion\nfpath=(/x $fpath)\n", string(content))
+}
+
+func TestGenerateCompletion(t *testing.T) {
+	for _, shell := range completionShells {
+		var buf bytes.Buffer
+		assert.NoError(t, generateCompletion(&buf, shell), shell)
+		assert.NotEmpty(t, buf.String(), shell)
+	}
+
+	assert.Error(t, generateCompletion(&bytes.Buffer{}, "tcsh"))
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"distbuild/boong/bootstrap/download"
+	"github.com/spf13/cobra"
+)
+
+var (
+	probeConfig bool
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "manage bootstrap configuration",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "validate configuration and environment",
+	Run: func(cmd *cobra.Command, args []string) {
+		errs := validateConfig(envFile, envFiles, probeConfig)
+		failed := false
+		for _, e := range errs {
+			if e.Warning {
+				_, _ = fmt.Fprintln(os.Stderr, msg("warning", "text", e.Error()))
+			} else {
+				_, _ = fmt.Fprintln(os.Stderr, msg("error", "text", e.Error()))
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+		printMsg("config.valid")
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	configValidateCmd.Flags().BoolVar(&probeConfig, "probe", false, "probe connectivity of configured endpoints")
+
+	configCmd.AddCommand(configValidateCmd)
+}
+
+var scpLikeRepoHost = regexp.MustCompile(`^[\w.-]+@[\w.-]+:`)
+
+var binaryKeys = []string{"AGENT_BIN", "DISTNINJA_BIN", "PROXY_BIN"}
+
+type configError struct {
+	Source  string
+	Line    int
+	Key     string
+	Message string
+	Warning bool
+}
+
+func (e configError) Error() string {
+	var prefix string
+
+	switch {
+	case e.Source != "" && e.Line > 0:
+		prefix = fmt.Sprintf("%s:%d: ", e.Source, e.Line)
+	case e.Source != "":
+		prefix = e.Source + ": "
+	}
+
+	if e.Key != "" {
+		prefix += e.Key + ": "
+	}
+
+	return prefix + e.Message
+}
+
+func validateConfig(embedded string, paths []string, probe bool) []configError {
+	errs := validateEnvSyntax(".env", embedded)
+
+	for _, path := range paths {
+		expanded, err := expandPath(path)
+		if err != nil {
+			errs = append(errs, configError{Source: path, Message: err.Error()})
+			continue
+		}
+		content, err := os.ReadFile(expanded)
+		if err != nil {
+			errs = append(errs, configError{Source: path, Key: "--env-file", Message: "file not readable: " + err.Error()})
+			continue
+		}
+		errs = append(errs, validateEnvSyntax(expanded, string(content))...)
+	}
+
+	entries, err := layerEnvFiles(embedded, paths)
+	if err != nil {
+		return errs
+	}
+
+	// The process environment wins over every env file
+	for key := range entries {
+		if value, ok := os.LookupEnv(key); ok {
+			entries[key] = envEntry{Key: key, Value: value, Source: "environment"}
+		}
+	}
+	for _, key := range append([]string{"REPO_HOST", "DISTBUILD_REPO", "WRAPPER_REPO", "AUTH_USER", "AUTH_PASS", "CLIENT_CERT", "CLIENT_KEY", "HOSTS_ALLOW", "HOSTS_DENY"}, binaryKeys...) {
+		if _, ok := entries[key]; !ok {
+			if value, ok := os.LookupEnv(key); ok {
+				entries[key] = envEntry{Key: key, Value: value, Source: "environment"}
+			}
+		}
+	}
+
+	errs = append(errs, validateEnvEntries(entries)...)
+
+	if probe {
+		errs = append(errs, probeEnvEntries(entries)...)
+	}
+
+	return errs
+}
+
+func validateEnvSyntax(source, content string) []configError {
+	var errs []configError
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			errs = append(errs, configError{Source: source, Line: lineNum, Message: "malformed line, expected KEY=VALUE"})
+			continue
+		}
+		if strings.TrimSpace(parts[0]) == "" {
+			errs = append(errs, configError{Source: source, Line: lineNum, Message: "empty key"})
+		}
+	}
+
+	return errs
+}
+
+func validateEnvEntries(entries map[string]envEntry) []configError {
+	var errs []configError
+
+	if entry, ok := entries["REPO_HOST"]; !ok || entry.Value == "" {
+		errs = append(errs, configError{Key: "REPO_HOST", Message: "required key not set"})
+	} else if err := validateRepoHost(entry.Value); err != nil {
+		errs = append(errs, entryError(entry, err.Error(), false))
+	}
+
+	if entries["DISTBUILD_REPO"].Value == "" && entries["WRAPPER_REPO"].Value == "" {
+		errs = append(errs, configError{Key: "DISTBUILD_REPO", Message: "DISTBUILD_REPO or WRAPPER_REPO is required"})
+	}
+
+	for _, key := range binaryKeys {
+		entry, ok := entries[key]
+		if !ok || entry.Value == "" {
+			errs = append(errs, configError{Key: key, Message: "not set, component will be skipped", Warning: true})
+			continue
+		}
+		if err := validateBinaryURL(entry.Value); err != nil {
+			errs = append(errs, entryError(entry, err.Error(), false))
+		}
+	}
+
+	// Endpoints the host policy denies fail at the first request
+	policy, err := resolveHostPolicy(func(key string) string { return entries[key].Value })
+	if err != nil {
+		errs = append(errs, configError{Key: "HOSTS_ALLOW", Message: err.Error()})
+	} else {
+		for _, key := range append([]string{"REPO_HOST"}, binaryKeys...) {
+			if entry := entries[key]; entry.Value != "" {
+				if err := policy.checkURL(entry.Value); err != nil {
+					errs = append(errs, entryError(entry, err.Error(), false))
+				}
+			}
+		}
+	}
+
+	if (entries["AUTH_USER"].Value == "") != (entries["AUTH_PASS"].Value == "") {
+		errs = append(errs, configError{Key: "AUTH_USER", Message: "AUTH_USER and AUTH_PASS must be set together", Warning: true})
+	}
+
+	for _, key := range []string{"CLIENT_CERT", "CLIENT_KEY"} {
+		entry, ok := entries[key]
+		if !ok || entry.Value == "" {
+			continue
+		}
+		path, err := expandPath(entry.Value)
+		if err != nil {
+			errs = append(errs, entryError(entry, err.Error(), false))
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, entryError(entry, "file not found: "+path, false))
+		}
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Key < errs[j].Key
+	})
+
+	return errs
+}
+
+func entryError(entry envEntry, message string, warning bool) configError {
+	return configError{
+		Source:  entry.Source,
+		Line:    entry.Line,
+		Key:     entry.Key,
+		Message: message,
+		Warning: warning,
+	}
+}
+
+func validateRepoHost(value string) error {
+	if scpLikeRepoHost.MatchString(value) {
+		return nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "ssh", "git":
+		if u.Host == "" {
+			return fmt.Errorf("missing host in %q", value)
+		}
+	case "file":
+	default:
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	return nil
+}
+
+func validateBinaryURL(value string) error {
+	if !strings.Contains(value, "://") && filepath.IsAbs(value) {
+		return nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+
+	if u.Scheme == "" || !download.Supported(value) {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	if u.Host == "" && u.Scheme != "file" {
+		return fmt.Errorf("missing host in %q", value)
+	}
+
+	return nil
+}
+
+func probeEnvEntries(entries map[string]envEntry) []configError {
+	var errs []configError
+
+	if entry, ok := entries["REPO_HOST"]; ok && entry.Value != "" {
+		if err := probeRepoHost(entry.Value); err != nil {
+			errs = append(errs, entryError(entry, "probe failed: "+err.Error(), false))
+		}
+	}
+
+	if err := setupHTTPClient(); err != nil {
+		return append(errs, configError{Key: "CLIENT_CERT", Message: err.Error()})
+	}
+
+	client := &http.Client{Transport: httpClient.Transport, Timeout: 10 * time.Second, CheckRedirect: checkRedirect}
+
+	user, err := resolveSecretValue(entries["AUTH_USER"].Value)
+	if err != nil {
+		return append(errs, entryError(entries["AUTH_USER"], err.Error(), false))
+	}
+
+	pass, err := resolveSecretValue(entries["AUTH_PASS"].Value)
+	if err != nil {
+		return append(errs, entryError(entries["AUTH_PASS"], err.Error(), false))
+	}
+
+	for _, key := range binaryKeys {
+		entry, ok := entries[key]
+		if !ok || validateBinaryURL(entry.Value) != nil || !isHTTPURL(entry.Value) {
+			continue
+		}
+		if err := probeURL(client, entry.Value, user, pass); err != nil {
+			errs = append(errs, entryError(entry, "probe failed: "+err.Error(), false))
+		}
+	}
+
+	return errs
+}
+
+func probeRepoHost(value string) error {
+	var host string
+
+	if scpLikeRepoHost.MatchString(value) {
+		host = net.JoinHostPort(strings.SplitN(strings.SplitN(value, "@", 2)[1], ":", 2)[0], "22")
+	} else {
+		u, err := url.Parse(value)
+		if err != nil || u.Host == "" {
+			return nil
+		}
+		port := u.Port()
+		if port == "" {
+			port = map[string]string{"http": "80", "https": "443", "ssh": "22", "git": "9418"}[u.Scheme]
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := dialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+func probeURL(client *http.Client, value, username, password string) error {
+	req, err := http.NewRequest(http.MethodHead, value, nil)
+	if err != nil {
+		return err
+	}
+
+	if username != "" && password != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return newStatusError(resp)
+	}
+
+	return nil
+}
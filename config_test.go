@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateEnvSyntax(t *testing.T) {
+	errs := validateEnvSyntax("test.env", "# comment\nREPO_HOST=host\nBROKEN\n=value\n")
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "test.env:3: malformed line, expected KEY=VALUE", errs[0].Error())
+	assert.Equal(t, 4, errs[1].Line)
+}
+
+func TestValidateEnvEntries(t *testing.T) {
+	errs := validateEnvEntries(map[string]envEntry{
+		"REPO_HOST":      {Key: "REPO_HOST", Value: "ftp://host", Source: ".env", Line: 1},
+		"DISTBUILD_REPO": {Key: "DISTBUILD_REPO", Value: "repo"},
+		"AGENT_BIN":      {Key: "AGENT_BIN", Value: "https://host/agent"},
+		"DISTNINJA_BIN":  {Key: "DISTNINJA_BIN", Value: "host/distninja", Source: ".env", Line: 5},
+	})
+
+	var failed []string
+	for _, e := range errs {
+		if !e.Warning {
+			failed = append(failed, e.Error())
+		}
+	}
+
+	assert.Equal(t, []string{
+		`.env:5: DISTNINJA_BIN: unsupported scheme ""`,
+		`.env:1: REPO_HOST: unsupported scheme "ftp"`,
+	}, failed)
+}
+
+func TestValidateEnvEntriesHostPolicy(t *testing.T) {
+	errs := validateEnvEntries(map[string]envEntry{
+		"REPO_HOST":      {Key: "REPO_HOST", Value: "git@github.com:", Source: ".env", Line: 1},
+		"DISTBUILD_REPO": {Key: "DISTBUILD_REPO", Value: "repo"},
+		"AGENT_BIN":      {Key: "AGENT_BIN", Value: "https://mirror.corp.example/agent"},
+		"HOSTS_ALLOW":    {Key: "HOSTS_ALLOW", Value: "*.corp.example"},
+	})
+
+	var failed []string
+	for _, e := range errs {
+		if !e.Warning {
+			failed = append(failed, e.Error())
+		}
+	}
+
+	assert.Equal(t, []string{`.env:1: REPO_HOST: host github.com is not allowed by HOSTS_ALLOW/HOSTS_DENY`}, failed)
+}
+
+func TestValidateRepoHost(t *testing.T) {
+	assert.NoError(t, validateRepoHost("https://android.googlesource.com"))
+	assert.NoError(t, validateRepoHost("git@example.com:"))
+	assert.NoError(t, validateRepoHost("ssh://git@example.com:29418"))
+	assert.Error(t, validateRepoHost("https://"))
+	assert.Error(t, validateRepoHost("example.com"))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// compressionFormat detects single-file compression from the artifact URL.
+func compressionFormat(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	switch path.Ext(u.Path) {
+	case ".zst":
+		return "zstd"
+	case ".xz":
+		return "xz"
+	case ".gz":
+		return "gzip"
+	default:
+		return ""
+	}
+}
+
+// decompressReader wraps r with a streaming decoder for format. The returned
+// close function releases decoder resources.
+func decompressReader(format string, r io.Reader) (io.Reader, func(), error) {
+	switch format {
+	case "":
+		return r, func() {}, nil
+	case "zstd":
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return dec, dec.Close, nil
+	case "xz":
+		dec, err := xz.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return dec, func() {}, nil
+	case "gzip":
+		dec, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return dec, func() { _ = dec.Close() }, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported compression %q", format)
+	}
+}
+
+// decompressFile decodes src into dst and removes src afterwards.
+func decompressFile(format, src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer func(name string) {
+		_ = os.Remove(name)
+	}(src)
+
+	defer func(in *os.File) {
+		_ = in.Close()
+	}(in)
+
+	reader, closeReader, err := decompressReader(format, in)
+	if err != nil {
+		return err
+	}
+
+	defer closeReader()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	defer func(out *os.File) {
+		_ = out.Close()
+	}(out)
+
+	if _, err := io.Copy(out, reader); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+
+	return os.Chmod(dst, policyFileMode(0755))
+}
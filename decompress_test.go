@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/ulikunitz/xz"
+)
+
+func TestCompressionFormat(t *testing.T) {
+	assert.Equal(t, "zstd", compressionFormat("https://host/agent.zst?token=1"))
+	assert.Equal(t, "xz", compressionFormat("https://host/agent.xz"))
+	assert.Equal(t, "gzip", compressionFormat("https://host/agent.gz"))
+	assert.Equal(t, "", compressionFormat("https://host/agent"))
+}
+
+func TestDownloadCompressed(t *testing.T) {
+	content := []byte("distninja binary")
+
+	var gz, zst, xzData bytes.Buffer
+
+	gw := gzip.NewWriter(&gz)
+	_, _ = gw.Write(content)
+	_ = gw.Close()
+
+	zw, _ := zstd.NewWriter(&zst)
+	_, _ = zw.Write(content)
+	_ = zw.Close()
+
+	xw, _ := xz.NewWriter(&xzData)
+	_, _ = xw.Write(content)
+	_ = xw.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/distninja.gz":
+			_, _ = w.Write(gz.Bytes())
+		case "/distninja.zst":
+			_, _ = w.Write(zst.Bytes())
+		case "/distninja.xz":
+			_, _ = w.Write(xzData.Bytes())
+		}
+	}))
+	defer server.Close()
+
+	for _, ext := range []string{".gz", ".zst", ".xz"} {
+		path := filepath.Join(t.TempDir(), "distninja")
+		assert.NoError(t, downloadFile(server.URL+"/distninja"+ext, path))
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, content, data, ext)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// Site flavored builds set their defaults with the linker instead of
+// editing the embedded .env:
+//
+//	go build -ldflags "-X main.DefaultRepoHost=https://git.example.com \
+//	  -X main.DefaultChannel=stable \
+//	  -X main.DefaultArtifactBaseURL=https://artifacts.example.com/distbuild"
+//
+// They replace the embedded values and give way to a packed payload, env
+// files and the environment, like any setting of the embedded .env.
+var (
+	DefaultRepoHost        string
+	DefaultChannel         string
+	DefaultArtifactBaseURL string
+)
+
+const buildDefaultsSource = "build"
+
+// artifactNames are the file names of the binaries under the artifact
+// base url.
+var artifactNames = map[string]string{
+	"AGENT_BIN":     "agent",
+	"DISTNINJA_BIN": "distninja",
+	"PROXY_BIN":     "proxy",
+}
+
+func buildDefaultEntries() []envEntry {
+	var entries []envEntry
+
+	for key, value := range map[string]string{
+		"REPO_HOST":         DefaultRepoHost,
+		"CHANNEL":           DefaultChannel,
+		"ARTIFACT_BASE_URL": DefaultArtifactBaseURL,
+	} {
+		if value != "" {
+			entries = append(entries, envEntry{Key: key, Value: value, Source: buildDefaultsSource})
+		}
+	}
+
+	return entries
+}
+
+// deriveArtifactURLs points the binaries that are not configured beyond the
+// defaults at <ARTIFACT_BASE_URL>[/<CHANNEL>]/<name>. Both settings may be
+// overridden at run time like any other.
+func deriveArtifactURLs(entries map[string]envEntry) {
+	base := effectiveSetting(entries, "ARTIFACT_BASE_URL")
+	if base == "" {
+		return
+	}
+
+	prefix := strings.TrimSuffix(base, "/")
+	if channel := effectiveSetting(entries, "CHANNEL"); channel != "" {
+		prefix += "/" + channel
+	}
+
+	for key, name := range artifactNames {
+		if entry, ok := entries[key]; ok && entry.Source != ".env" && entry.Source != buildDefaultsSource {
+			continue
+		}
+		entries[key] = envEntry{Key: key, Value: prefix + "/" + name, Source: buildDefaultsSource}
+	}
+}
+
+// effectiveSetting is the value a setting ends up with, the environment
+// wins over the env files.
+func effectiveSetting(entries map[string]envEntry, key string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return entries[key].Value
+}
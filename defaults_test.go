@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildDefaults(t *testing.T) {
+	defer func(host, channel, base string) {
+		DefaultRepoHost, DefaultChannel, DefaultArtifactBaseURL = host, channel, base
+	}(DefaultRepoHost, DefaultChannel, DefaultArtifactBaseURL)
+	DefaultRepoHost = "https://git.example.com"
+	DefaultChannel = "stable"
+	DefaultArtifactBaseURL = "https://artifacts.example.com/distbuild/"
+
+	embedded := "REPO_HOST = your_host\nAGENT_BIN = your_bin\nPROXY_BIN = your_bin\nCHANNEL =\nARTIFACT_BASE_URL =\n"
+
+	entries, err := layerEnvFiles(embedded, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://git.example.com", entries["REPO_HOST"].Value)
+	assert.Equal(t, "https://artifacts.example.com/distbuild/stable/agent", entries["AGENT_BIN"].Value)
+	assert.Equal(t, "https://artifacts.example.com/distbuild/stable/distninja", entries["DISTNINJA_BIN"].Value)
+
+	// Env files override the defaults and the binaries they name
+	override := filepath.Join(t.TempDir(), "site.env")
+	assert.NoError(t, os.WriteFile(override, []byte("CHANNEL = beta\nPROXY_BIN = https://mirror/proxy\n"), 0644))
+
+	entries, err = layerEnvFiles(embedded, []string{override})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://artifacts.example.com/distbuild/beta/agent", entries["AGENT_BIN"].Value)
+	assert.Equal(t, "https://mirror/proxy", entries["PROXY_BIN"].Value)
+
+	// And so does the environment
+	t.Setenv("ARTIFACT_BASE_URL", "https://local")
+	t.Setenv("CHANNEL", "")
+
+	entries, err = layerEnvFiles(embedded, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://local/agent", entries["AGENT_BIN"].Value)
+}
+
+func TestNoBuildDefaults(t *testing.T) {
+	entries, err := layerEnvFiles("AGENT_BIN = your_bin\n", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "your_bin", entries["AGENT_BIN"].Value)
+	assert.NotContains(t, entries, "PROXY_BIN")
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// patchIndexSuffix names the index published next to a full artifact that
+// describes the patch from the previous release to the current one.
+const patchIndexSuffix = ".patch.json"
+
+// Patch formats of the index. A bsdiff patch is BSDIFF40 with bzip2
+// streams, a zstd patch is a zstd frame made with --patch-from against the
+// previous release. An index without a format is told apart by the magic.
+const (
+	patchFormatBsdiff = "bsdiff"
+	patchFormatZstd   = "zstd"
+)
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// maxPatchedSize bounds the binary a patch may produce when its index does
+// not publish the target size.
+const maxPatchedSize = 1 << 30
+
+var errNoDelta = errors.New("no applicable delta")
+
+type patchIndex struct {
+	BaseSHA256   string `json:"base_sha256"`
+	TargetSHA256 string `json:"target_sha256"`
+	TargetSize   int64  `json:"target_size,omitempty"`
+	Patch        string `json:"patch"`
+	Format       string `json:"format,omitempty"`
+}
+
+// downloadBinary updates filePath from a patch when the installed binary
+// matches the published patch base, and falls back to a full download. name
+// is the component, filePath is often a staging file named after it.
+func downloadBinary(name, artifactURL, filePath string) error {
+	if err := fetchBinary(artifactURL, filePath); err != nil {
+		return err
+	}
+
+	if err := verifySigstore(name, artifactURL, filePath); err != nil {
+		_ = os.Remove(filePath)
+		return err
+	}
+
+	return prepareBinaryLaunch(filePath)
+}
+
+func fetchBinary(artifactURL, filePath string) error {
+	if isPayloadURL(artifactURL) {
+		return downloadFile(artifactURL, filePath)
+	}
+
+	err := applyDeltaUpdate(artifactURL, filePath)
+	if err == nil {
+		err = verifyPatchedChecksum(artifactURL, filePath)
+	}
+	if err == nil {
+		return nil
+	}
+
+	if !errors.Is(err, errNoDelta) {
+		warn("delta.fallback", "err", err, "file", filepath.Base(filePath))
+	}
+
+	err = downloadFile(artifactURL, filePath)
+	if fallback, ok := payloadFallback(err, filePath); ok {
+		warn("delta.embedded", "err", err, "file", filepath.Base(filePath))
+		return downloadFile(fallback, filePath)
+	}
+
+	return err
+}
+
+func applyDeltaUpdate(artifactURL, filePath string) error {
+	old, err := os.ReadFile(filePath)
+	if err != nil {
+		return errNoDelta
+	}
+
+	baseSum := sha256Hex(old)
+
+	data, err := fetchBytes(artifactURL + patchIndexSuffix)
+	if err != nil {
+		return errNoDelta
+	}
+
+	var index patchIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return fmt.Errorf("parse patch index failed: %w", err)
+	}
+
+	if index.TargetSHA256 == baseSum {
+		return nil
+	}
+
+	if index.BaseSHA256 != baseSum || index.Patch == "" {
+		return errNoDelta
+	}
+
+	if index.Format != "" && index.Format != patchFormatBsdiff && index.Format != patchFormatZstd {
+		logVerbose("ignoring patch of %s in unsupported format %q", redactURL(artifactURL), index.Format)
+		return errNoDelta
+	}
+
+	patchURL, err := resolveURL(artifactURL, index.Patch)
+	if err != nil {
+		return fmt.Errorf("resolve patch url failed: %w", err)
+	}
+
+	patch, err := fetchBytes(patchURL)
+	if err != nil {
+		return fmt.Errorf("download patch failed: %w", err)
+	}
+
+	maxSize := int64(maxPatchedSize)
+	if index.TargetSize > 0 {
+		maxSize = index.TargetSize
+	}
+
+	updated, err := applyPatch(index.Format, old, patch, maxSize)
+	if err != nil {
+		return fmt.Errorf("apply patch failed: %w", err)
+	}
+
+	if sum := sha256Hex(updated); sum != index.TargetSHA256 {
+		return withCode(errCodeVerification, fmt.Errorf("patched binary checksum mismatch: got %s, want %s", sum, index.TargetSHA256))
+	}
+
+	return writeFileAtomic(filePath, updated, 0755)
+}
+
+func applyPatch(format string, old, patch []byte, maxSize int64) ([]byte, error) {
+	if format == patchFormatZstd || format == "" && bytes.HasPrefix(patch, zstdMagic) {
+		return zstdPatch(old, patch, maxSize)
+	}
+
+	return bspatch(old, patch, maxSize)
+}
+
+// zstdPatch decodes a zstd --patch-from patch with old as the reference
+// and refuses output above maxSize.
+func zstdPatch(old, patch []byte, maxSize int64) ([]byte, error) {
+	dec, err := zstd.NewReader(bytes.NewReader(patch),
+		zstd.WithDecoderDictRaw(0, old),
+		zstd.WithDecoderMaxWindow(max(uint64(len(old))+uint64(maxSize), zstd.MinWindowSize)),
+		zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+
+	defer dec.Close()
+
+	out, err := io.ReadAll(io.LimitReader(dec, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("corrupt zstd patch: %w", err)
+	}
+
+	if int64(len(out)) > maxSize {
+		return nil, fmt.Errorf("zstd patch output exceeds %d bytes", maxSize)
+	}
+
+	return out, nil
+}
+
+// verifyPatchedChecksum checks a patched binary against the checksum
+// manifest. The patched file is uncompressed, so only an entry for the
+// uncompressed name applies, without one the full artifact is downloaded.
+func verifyPatchedChecksum(artifactURL, filePath string) error {
+	manifest, err := loadChecksums()
+	if err != nil || manifest == nil {
+		return err
+	}
+
+	if compressionFormat(artifactURL) != "" {
+		if u, err := url.Parse(artifactURL); err == nil {
+			u.Path = strings.TrimSuffix(u.Path, path.Ext(u.Path))
+			artifactURL = u.String()
+		}
+	}
+
+	if _, _, ok := manifest.lookup(artifactURL); !ok {
+		return errNoDelta
+	}
+
+	return verifyFileChecksum(artifactURL, filePath, filePath)
+}
+
+func resolveURL(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+
+	return b.ResolveReference(r).String(), nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// writeFileAtomic replaces filePath with data. A file that already has the
+// content and mode is left alone, anything else written counts as a change
+// of the run, except the scratch files downloads are staged in.
+func writeFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	if unchangedFile(filePath, data, policyFileMode(perm)) {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+"-*")
+	if err != nil {
+		return err
+	}
+
+	defer func(name string) {
+		_ = os.Remove(name)
+	}(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), policyFileMode(perm)); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return err
+	}
+
+	if !strings.HasSuffix(filePath, stagingSuffix) {
+		noteChange("write " + filePath)
+	}
+
+	return nil
+}
+
+func unchangedFile(filePath string, data []byte, mode os.FileMode) bool {
+	info, err := os.Stat(filePath)
+	if err != nil || !info.Mode().IsRegular() || info.Mode().Perm() != mode.Perm() || info.Size() != int64(len(data)) {
+		return false
+	}
+
+	current, err := os.ReadFile(filePath)
+
+	return err == nil && bytes.Equal(current, data)
+}
+
+// publishedBinary reports whether the binary at filePath is the one the
+// artifact currently publishes, going by the checksum manifest or else the
+// patch index. Without either it cannot tell and the artifact is fetched.
+func publishedBinary(artifactURL, filePath string) bool {
+	if isPayloadURL(artifactURL) {
+		return false
+	}
+
+	sum, err := fileSHA256(filePath)
+	if err != nil {
+		return false
+	}
+
+	if manifest, err := loadChecksums(); err == nil && manifest != nil && compressionFormat(artifactURL) == "" {
+		if _, want, ok := manifest.lookup(artifactURL); ok {
+			return sum == want
+		}
+	}
+
+	data, err := fetchBytes(artifactURL + patchIndexSuffix)
+	if err != nil {
+		return false
+	}
+
+	var index patchIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return false
+	}
+
+	return index.TargetSHA256 == sum
+}
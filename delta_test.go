@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// "hello world" -> "hello there!"
+const testPatchHex = "42534449464634302a000000000000002d000000000000000c00000000000000" +
+	"425a68393141592653596cea4cd7000005400068082000219a68334d1da544e2ee48a70a120d9d499ae0" +
+	"425a68393141592653598467a1700000004000f10008222000221ea6108600350e5be2ee48a70a12108cf42e00" +
+	"425a68393141592653592d15eb1c00000010002000200021184682ee48a70a1205a2bd6380"
+
+// testZstdPatchHex is zstd --patch-from of "the quick brown fox jumps over
+// the lazy cat" against the same sentence ending in "dog".
+const testZstdPatchHex = "28b52ffd242b4d00001863617401005da98065dc8814"
+
+const (
+	testZstdOld = "the quick brown fox jumps over the lazy dog"
+	testZstdNew = "the quick brown fox jumps over the lazy cat"
+)
+
+func TestBspatch(t *testing.T) {
+	patch, err := hex.DecodeString(testPatchHex)
+	assert.NoError(t, err)
+
+	out, err := bspatch([]byte("hello world"), patch, 12)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello there!", string(out))
+
+	_, err = bspatch([]byte("hello world"), []byte("BSDIFF4"), 12)
+	assert.Error(t, err)
+
+	// The expected size is a cap, a patch growing past it is refused
+	_, err = bspatch([]byte("hello world"), patch, 11)
+	assert.ErrorContains(t, err, "exceeds 11")
+}
+
+func testPatchHeader(ctrlLen, diffLen, newSize uint64) []byte {
+	header := []byte(bsdiffMagic)
+	header = binary.LittleEndian.AppendUint64(header, ctrlLen)
+	header = binary.LittleEndian.AppendUint64(header, diffLen)
+	return binary.LittleEndian.AppendUint64(header, newSize)
+}
+
+func TestBspatchCorruptHeader(t *testing.T) {
+	patch, _ := hex.DecodeString(testPatchHex)
+
+	for name, header := range map[string][]byte{
+		"truncated":           patch[:31],
+		"ctrl past end":       testPatchHeader(64, 0, 12),
+		"diff past end":       testPatchHeader(0, 64, 12),
+		"overflowing lengths": testPatchHeader(1<<62, 1<<62, 12),
+		"negative length":     testPatchHeader(1<<63|1, 0, 12),
+		"oversized":           testPatchHeader(0, 0, 1<<62),
+		"max size":            testPatchHeader(0, 0, 1<<63-1),
+	} {
+		_, err := bspatch([]byte("hello world"), header, maxPatchedSize)
+		assert.ErrorContains(t, err, "bsdiff header", name)
+	}
+
+	// Control blocks copying past the new size, bzip2 of the triples
+	// {1<<63-1, 0, 0} and {0, 1<<63-1, 0}
+	for _, ctrlHex := range []string{
+		"425a6839314159265359730ace110000054080c80400008000a0002183419a0d193567177245385090730ace11",
+		"425a68393141592653599aca2bc4000000c080cc0000008000a00030cd00c34054f45ab38bb9229c28484d6515e200",
+	} {
+		ctrl, _ := hex.DecodeString(ctrlHex)
+		patch := append(testPatchHeader(uint64(len(ctrl)), 0, 12), ctrl...)
+		_, err := bspatch([]byte("hello world"), patch, 12)
+		assert.ErrorContains(t, err, "corrupt bsdiff control block")
+	}
+}
+
+func FuzzBspatch(f *testing.F) {
+	patch, _ := hex.DecodeString(testPatchHex)
+	f.Add(patch)
+	f.Add(testPatchHeader(1<<62, 1<<62, 12))
+
+	f.Fuzz(func(t *testing.T, patch []byte) {
+		out, err := bspatch([]byte("hello world"), patch, 64)
+		if err == nil && len(out) > 64 {
+			t.Fatalf("patched %d bytes, over the cap", len(out))
+		}
+	})
+}
+
+func TestDownloadBinaryDelta(t *testing.T) {
+	patch, _ := hex.DecodeString(testPatchHex)
+	oldSum := sha256Hex([]byte("hello world"))
+	newSum := sha256Hex([]byte("hello there!"))
+
+	fullDownloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/agent" + patchIndexSuffix:
+			_, _ = fmt.Fprintf(w, `{"base_sha256":%q,"target_sha256":%q,"target_size":12,"patch":"agent.bsdiff"}`, oldSum, newSum)
+		case "/agent.bsdiff":
+			_, _ = w.Write(patch)
+		case "/agent":
+			if r.Method == http.MethodGet {
+				fullDownloads++
+			}
+			_, _ = w.Write([]byte("hello there!"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "agent")
+
+	// Matching base is patched in place
+	assert.NoError(t, os.WriteFile(path, []byte("hello world"), 0755))
+	assert.NoError(t, downloadBinary("agent", server.URL+"/agent", path))
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "hello there!", string(data))
+	assert.Equal(t, 0, fullDownloads)
+
+	// Unknown base falls back to a full download
+	assert.NoError(t, os.WriteFile(path, []byte("something else"), 0755))
+	assert.NoError(t, downloadBinary("agent", server.URL+"/agent", path))
+	data, _ = os.ReadFile(path)
+	assert.Equal(t, "hello there!", string(data))
+	assert.Equal(t, 1, fullDownloads)
+}
+
+func TestZstdPatch(t *testing.T) {
+	patch, err := hex.DecodeString(testZstdPatchHex)
+	assert.NoError(t, err)
+
+	out, err := applyPatch("", []byte(testZstdOld), patch, int64(len(testZstdNew)))
+	assert.NoError(t, err)
+	assert.Equal(t, testZstdNew, string(out))
+
+	// The patch needs its reference, another base decodes to garbage
+	out, err = zstdPatch([]byte("something else entirely"), patch, maxPatchedSize)
+	assert.False(t, err == nil && string(out) == testZstdNew)
+
+	_, err = zstdPatch([]byte(testZstdOld), patch, 10)
+	assert.ErrorContains(t, err, "exceeds 10 bytes")
+
+	_, err = zstdPatch([]byte(testZstdOld), patch[:10], maxPatchedSize)
+	assert.Error(t, err)
+}
+
+func TestDownloadBinaryPatchFormat(t *testing.T) {
+	patch, _ := hex.DecodeString(testZstdPatchHex)
+	oldSum := sha256Hex([]byte(testZstdOld))
+	newSum := sha256Hex([]byte(testZstdNew))
+
+	format := "zstd"
+	fullDownloads, patchDownloads := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/agent" + patchIndexSuffix:
+			_, _ = fmt.Fprintf(w, `{"base_sha256":%q,"target_sha256":%q,"patch":"agent.zst","format":%q}`, oldSum, newSum, format)
+		case "/agent.zst":
+			patchDownloads++
+			_, _ = w.Write(patch)
+		case "/agent":
+			if r.Method == http.MethodGet {
+				fullDownloads++
+			}
+			_, _ = w.Write([]byte(testZstdNew))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "agent")
+
+	assert.NoError(t, os.WriteFile(path, []byte(testZstdOld), 0755))
+	assert.NoError(t, downloadBinary("agent", server.URL+"/agent", path))
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, testZstdNew, string(data))
+	assert.Equal(t, []int{0, 1}, []int{fullDownloads, patchDownloads})
+
+	// An unknown format is not fetched, the artifact is downloaded whole
+	format = "xdelta3"
+	assert.NoError(t, os.WriteFile(path, []byte(testZstdOld), 0755))
+	assert.NoError(t, downloadBinary("agent", server.URL+"/agent", path))
+	data, _ = os.ReadFile(path)
+	assert.Equal(t, testZstdNew, string(data))
+	assert.Equal(t, []int{1, 1}, []int{fullDownloads, patchDownloads})
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const defaultRemoteDir = ".distbuild-bootstrap"
+
+var (
+	deployBinaries  []string
+	deployRemoteDir string
+	deploySudo      bool
+)
+
+var deployCmd = &cobra.Command{
+	Use:   "deploy",
+	Short: "provision remote build hosts over SSH",
+}
+
+var deployPushCmd = &cobra.Command{
+	Use:   "push [host...] [-- bootstrap flags]",
+	Short: "copy bootstrap and its config to hosts and run it there",
+	Long: "Copy a bootstrap executable matching the OS and architecture of each host,\n" +
+		"together with the --env-file files, over SSH and run it with the flags after\n" +
+		"'--'. The hosts need nothing but sshd. Without host names every inventory\n" +
+		"host matching --limit is provisioned.",
+	Run: func(cmd *cobra.Command, args []string) {
+		names, remoteArgs := args, []string(nil)
+		if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+			names, remoteArgs = args[:dash], args[dash:]
+		}
+		binaries, err := parseDeployBinaries(deployBinaries)
+		if err != nil {
+			exitWithError(withCode(errCodeUsage, err))
+		}
+		inv, err := loadInventory(inventoryPath)
+		if err != nil {
+			exitWithError(err)
+		}
+		hosts, err := selectHosts(inv.Hosts, names)
+		if err != nil {
+			exitWithError(withCode(errCodeUsage, err))
+		}
+		if hosts, err = filterHosts(hosts, inventoryLimit); err != nil {
+			exitWithError(err)
+		}
+		ctx, cancel := runContext()
+		defer cancel()
+		for _, h := range hosts {
+			if err := pushBootstrap(ctx, h, binaries, remoteArgs); err != nil {
+				exitWithError(timeoutError(ctx, fmt.Errorf("deploy %s failed: %w", h.Name, err)))
+			}
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	addInventoryFlags(deployCmd)
+
+	deployPushCmd.Flags().StringArrayVar(&deployBinaries, "binary", nil,
+		"bootstrap executable for other platforms as os/arch=path, e.g. linux/arm64=./bootstrap-arm64 (repeatable)")
+	deployPushCmd.Flags().StringVar(&deployRemoteDir, "remote-dir", defaultRemoteDir, "directory on the host for bootstrap and its config, relative to the login directory")
+	deployPushCmd.Flags().BoolVar(&deploySudo, "sudo", false, "run bootstrap on the host with sudo")
+	addTimeoutFlags(deployPushCmd)
+
+	deployCmd.AddCommand(deployPushCmd)
+}
+
+// parseDeployBinaries maps os/arch to executables, the running executable
+// serves its own platform.
+func parseDeployBinaries(specs []string) (map[string]string, error) {
+	binaries := map[string]string{}
+
+	if exe, err := os.Executable(); err == nil {
+		binaries[runtime.GOOS+"/"+runtime.GOARCH] = exe
+	}
+
+	for _, spec := range specs {
+		platform, file, ok := strings.Cut(spec, "=")
+		if !ok || !strings.Contains(platform, "/") || file == "" {
+			return nil, fmt.Errorf("invalid --binary %q, want os/arch=path", spec)
+		}
+		file, err := expandPath(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand path: %w", err)
+		}
+		binaries[platform] = file
+	}
+
+	return binaries, nil
+}
+
+// selectHosts returns the named inventory hosts, all of them without names.
+func selectHosts(hosts []inventoryHost, names []string) ([]inventoryHost, error) {
+	if len(names) == 0 {
+		return hosts, nil
+	}
+
+	byName := map[string]inventoryHost{}
+	for _, h := range hosts {
+		byName[h.Name] = h
+	}
+
+	selected := make([]inventoryHost, 0, len(names))
+	for _, name := range names {
+		h, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("host %s is not in the inventory", name)
+		}
+		selected = append(selected, h)
+	}
+
+	return selected, nil
+}
+
+var unamePlatforms = map[string]string{
+	"Linux":   "linux",
+	"Darwin":  "darwin",
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"i686":    "386",
+	"i386":    "386",
+	"armv7l":  "arm",
+}
+
+// remotePlatform asks the host for its os/arch in GOOS/GOARCH naming.
+func remotePlatform(ctx context.Context, h inventoryHost) (string, error) {
+	output, err := runRemote(ctx, h, "uname", "-sm")
+	if err != nil {
+		return "", fmt.Errorf("detect platform failed: %w\n%s", err, strings.TrimSpace(output))
+	}
+
+	fields := strings.Fields(output)
+	if len(fields) != 2 || unamePlatforms[fields[0]] == "" || unamePlatforms[fields[1]] == "" {
+		return "", fmt.Errorf("unsupported platform %q", strings.TrimSpace(output))
+	}
+
+	return unamePlatforms[fields[0]] + "/" + unamePlatforms[fields[1]], nil
+}
+
+// uploadFile streams a local file to the host through the ssh session, so
+// no sftp subsystem or scp is needed. The file is renamed into place once
+// complete.
+func uploadFile(ctx context.Context, h inventoryHost, localPath, remotePath string, mode os.FileMode) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	tmp := remotePath + ".tmp"
+	script := fmt.Sprintf("mkdir -p %s && cat > %s && chmod %s %s && mv -f %s %s",
+		shellQuote(path.Dir(remotePath)), shellQuote(tmp), strconv.FormatUint(uint64(mode.Perm()), 8),
+		shellQuote(tmp), shellQuote(tmp), shellQuote(remotePath))
+
+	cmd := remoteCommand(ctx, h, script)
+	cmd.Stdin = f
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("upload %s failed: %w\n%s", path.Base(remotePath), remoteError(h, err), strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// pushBootstrap provisions one host: detect its platform, upload bootstrap
+// and the env files, then run it with the output streamed back.
+func pushBootstrap(ctx context.Context, h inventoryHost, binaries map[string]string, remoteArgs []string) error {
+	platform, err := remotePlatform(ctx, h)
+	if err != nil {
+		return err
+	}
+
+	binary, ok := binaries[platform]
+	if !ok {
+		return withCode(errCodeUsage, fmt.Errorf("no bootstrap executable for %s, pass --binary %s=path", platform, platform))
+	}
+
+	s := beginStep(msg("step.upload", "host", h.Name))
+
+	remoteBinary := path.Join(deployRemoteDir, "bootstrap")
+	if err := uploadFile(ctx, h, binary, remoteBinary, 0755); err != nil {
+		return s.end(err)
+	}
+
+	var command []string
+	if deploySudo {
+		command = append(command, "sudo")
+	}
+	// The pin goes after sudo, which would drop it from the environment
+	if h.AgentBin != "" {
+		command = append(command, "env", "AGENT_BIN="+h.AgentBin)
+	}
+	command = append(command, remoteBinary)
+
+	for i, envPath := range envFiles {
+		local, err := expandPath(envPath)
+		if err != nil {
+			return s.end(fmt.Errorf("failed to expand path: %w", err))
+		}
+		remote := path.Join(deployRemoteDir, fmt.Sprintf("env-%d.env", i+1))
+		if err := uploadFile(ctx, h, local, remote, 0600); err != nil {
+			return s.end(err)
+		}
+		command = append(command, "--env-file", remote)
+	}
+
+	if err := s.end(nil); err != nil {
+		return err
+	}
+
+	if h.DistbuildPath != "" {
+		command = append(command, "--distbuild-path", h.DistbuildPath)
+	}
+	command = append(command, remoteArgs...)
+
+	printMsg("deploy.running", "host", h.Name, "platform", platform)
+
+	cmd := remoteCommand(ctx, h, quoteCommand(command...))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("remote bootstrap failed: %w", remoteError(h, err))
+	}
+
+	return nil
+}
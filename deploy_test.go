@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSSH runs the remote script in a local shell, uname reports uname.
+func fakeSSH(t *testing.T, uname string) {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+
+	dir := t.TempDir()
+	script := "#!/bin/sh\nwhile [ \"$1\" != \"--\" ]; do shift; done\nshift\n" +
+		"uname() { echo '" + uname + "'; }\neval \"$1\"\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0755))
+
+	binary := sshBinary
+	t.Cleanup(func() {
+		sshBinary = binary
+	})
+	sshBinary = filepath.Join(dir, "ssh")
+}
+
+func TestRemotePlatform(t *testing.T) {
+	fakeSSH(t, "Linux aarch64")
+
+	platform, err := remotePlatform(context.Background(), inventoryHost{Name: "a", Address: "a", Port: 22})
+	assert.NoError(t, err)
+	assert.Equal(t, "linux/arm64", platform)
+}
+
+func TestPushBootstrap(t *testing.T) {
+	fakeSSH(t, "Linux x86_64")
+
+	defer func(dir string, sudo bool, files []string) {
+		deployRemoteDir, deploySudo, envFiles = dir, sudo, files
+	}(deployRemoteDir, deploySudo, envFiles)
+
+	local := t.TempDir()
+	remote := filepath.Join(t.TempDir(), "bootstrap-dir")
+
+	// The stand-in bootstrap records its arguments and the agent it got
+	binary := filepath.Join(local, "bootstrap")
+	assert.NoError(t, os.WriteFile(binary, []byte("#!/bin/sh\necho \"$@\" > \"$(dirname \"$0\")/args\"\necho \"$AGENT_BIN\" > \"$(dirname \"$0\")/agent\"\n"), 0644))
+	env := filepath.Join(local, "site.env")
+	assert.NoError(t, os.WriteFile(env, []byte("REPO_HOST=https://example.com\n"), 0644))
+
+	deployRemoteDir, deploySudo, envFiles = remote, false, []string{env}
+
+	h := inventoryHost{Name: "build-01", Address: "build-01", Port: 22, DistbuildPath: "/opt/my distbuild"}
+
+	err := pushBootstrap(context.Background(), h, map[string]string{"linux/arm64": binary}, nil)
+	assert.Equal(t, errCodeUsage, errorCodeOf(err))
+	assert.Contains(t, err.Error(), "--binary linux/amd64=path")
+
+	captureStdout(t, func() {
+		assert.NoError(t, pushBootstrap(context.Background(), h, map[string]string{"linux/amd64": binary}, []string{"--deploy-agent"}))
+	})
+
+	info, err := os.Stat(filepath.Join(remote, "bootstrap"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	uploaded, err := os.ReadFile(filepath.Join(remote, "env-1.env"))
+	assert.NoError(t, err)
+	assert.Equal(t, "REPO_HOST=https://example.com\n", string(uploaded))
+
+	args, err := os.ReadFile(filepath.Join(remote, "args"))
+	assert.NoError(t, err)
+	assert.Equal(t, "--env-file "+filepath.Join(remote, "env-1.env")+" --distbuild-path /opt/my distbuild --deploy-agent\n", string(args))
+
+	h.AgentBin = "https://example.com/agent-v3"
+	captureStdout(t, func() {
+		assert.NoError(t, pushBootstrap(context.Background(), h, map[string]string{"linux/amd64": binary}, []string{"--deploy-agent"}))
+	})
+	agent, err := os.ReadFile(filepath.Join(remote, "agent"))
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/agent-v3\n", string(agent))
+}
+
+func TestSelectHosts(t *testing.T) {
+	hosts := fleetHosts("a", "b", "c")
+
+	selected, err := selectHosts(hosts, nil)
+	assert.NoError(t, err)
+	assert.Len(t, selected, 3)
+
+	selected, err = selectHosts(hosts, []string{"c", "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, fleetHosts("c", "a"), selected)
+
+	_, err = selectHosts(hosts, []string{"d"})
+	assert.Error(t, err)
+}
+
+func TestParseDeployBinaries(t *testing.T) {
+	binaries, err := parseDeployBinaries([]string{"linux/arm64=/tmp/bootstrap-arm64"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/bootstrap-arm64", binaries["linux/arm64"])
+	assert.NotEmpty(t, binaries[runtime.GOOS+"/"+runtime.GOARCH])
+
+	_, err = parseDeployBinaries([]string{"linux=/tmp/bootstrap"})
+	assert.Error(t, err)
+}
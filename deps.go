@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultDependencies are the host packages the build agent needs, override
+// them with AGENT_DEPENDENCIES.
+const defaultDependencies = "git,ncurses,zlib,python3"
+
+var (
+	checkDeps   bool
+	installDeps bool
+)
+
+// osReleasePath is swapped by tests.
+var osReleasePath = "/etc/os-release"
+
+// packageManager knows how to query and install the packages of a distro
+// family. Dependencies without an entry in names are taken as package names.
+type packageManager struct {
+	name      string
+	installed func(pkg string) bool
+	install   []string
+	names     map[string]string
+}
+
+var packageManagers = map[string]packageManager{
+	"apt": {
+		name:      "apt",
+		installed: dpkgInstalled,
+		install:   []string{"apt-get", "install", "-y"},
+		names:     map[string]string{"ncurses": "libncurses-dev", "zlib": "zlib1g-dev"},
+	},
+	"dnf": {
+		name:      "dnf",
+		installed: rpmInstalled,
+		install:   []string{"dnf", "install", "-y"},
+		names:     map[string]string{"ncurses": "ncurses-devel", "zlib": "zlib-devel"},
+	},
+	"zypper": {
+		name:      "zypper",
+		installed: rpmInstalled,
+		install:   []string{"zypper", "--non-interactive", "install"},
+		names:     map[string]string{"ncurses": "ncurses-devel", "zlib": "zlib-devel"},
+	},
+}
+
+// distroFamilies maps os-release ids onto package managers.
+var distroFamilies = map[string]string{
+	"debian":    "apt",
+	"ubuntu":    "apt",
+	"fedora":    "dnf",
+	"rhel":      "dnf",
+	"centos":    "dnf",
+	"rocky":     "dnf",
+	"almalinux": "dnf",
+	"suse":      "zypper",
+	"opensuse":  "zypper",
+	"sles":      "zypper",
+}
+
+func addDependencyFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&checkDeps, "check-deps", false, "report host packages the build agent needs that are missing (env AGENT_DEPENDENCIES)")
+	cmd.Flags().BoolVar(&installDeps, "install-deps", false, "install missing host packages with the distro package manager")
+	cmd.MarkFlagsMutuallyExclusive("check-deps", "install-deps")
+}
+
+// detectPackageManager reads the distro id from os-release, falling back to
+// ID_LIKE for derivatives.
+func detectPackageManager() (packageManager, string, error) {
+	f, err := os.Open(osReleasePath)
+	if err != nil {
+		return packageManager{}, "", fmt.Errorf("detect distro failed: %w", err)
+	}
+
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	release := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(scanner.Text(), "="); ok {
+			release[key] = strings.Trim(value, `"'`)
+		}
+	}
+
+	ids := append([]string{release["ID"]}, strings.Fields(release["ID_LIKE"])...)
+	for _, id := range ids {
+		if family, ok := distroFamilies[id]; ok {
+			return packageManagers[family], release["ID"], nil
+		}
+	}
+
+	return packageManager{}, release["ID"], fmt.Errorf("unsupported distro %q", release["ID"])
+}
+
+// dependencyPackages resolves AGENT_DEPENDENCIES into package names of pm.
+func dependencyPackages(pm packageManager) []string {
+	value := os.Getenv("AGENT_DEPENDENCIES")
+	if strings.TrimSpace(value) == "" {
+		value = defaultDependencies
+	}
+
+	var packages []string
+	for _, dep := range strings.Split(value, ",") {
+		dep = strings.TrimSpace(dep)
+		if dep == "" {
+			continue
+		}
+		if pkg, ok := pm.names[dep]; ok {
+			dep = pkg
+		}
+		packages = append(packages, dep)
+	}
+
+	return packages
+}
+
+func dpkgInstalled(pkg string) bool {
+	output, err := exec.Command("dpkg-query", "-W", "-f=${Status}", pkg).Output()
+
+	return err == nil && strings.Contains(string(output), "install ok installed")
+}
+
+func rpmInstalled(pkg string) bool {
+	return exec.Command("rpm", "-q", "--whatprovides", pkg).Run() == nil
+}
+
+// ensureDependencies reports the missing host packages and with
+// --install-deps installs them.
+func ensureDependencies() error {
+	pm, distro, err := detectPackageManager()
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, pkg := range dependencyPackages(pm) {
+		if !pm.installed(pkg) {
+			missing = append(missing, pkg)
+		}
+	}
+
+	if len(missing) == 0 {
+		logVerbose("all dependencies installed (%s)", distro)
+		return nil
+	}
+
+	installCommand := append(append([]string{"sudo"}, pm.install...), missing...)
+
+	if !installDeps {
+		return fmt.Errorf("missing packages on %s: %s, install them with: %s",
+			distro, strings.Join(missing, ", "), strings.Join(installCommand, " "))
+	}
+
+	s := beginStep(msg("step.install", "packages", strings.Join(missing, ", ")))
+
+	cmd := exec.CommandContext(runCtx, installCommand[0], installCommand[1:]...)
+	var output bytes.Buffer
+	cmd.Stdout = commandStderr(&output)
+	cmd.Stderr = cmd.Stdout
+	if err := runPrivileged(cmd); err != nil {
+		return s.end(fmt.Errorf("%s failed: %w\n%s", pm.name, err, output.String()))
+	}
+
+	noteChange("install " + strings.Join(missing, " "))
+
+	return s.end(nil)
+}
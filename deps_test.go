@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeOSRelease(t *testing.T, content string) {
+	path := filepath.Join(t.TempDir(), "os-release")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	old := osReleasePath
+	osReleasePath = path
+	t.Cleanup(func() { osReleasePath = old })
+}
+
+func TestDetectPackageManager(t *testing.T) {
+	tests := []struct {
+		release string
+		want    string
+	}{
+		{"ID=debian\n", "apt"},
+		{"ID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\n", "dnf"},
+		{"ID=linuxmint\nID_LIKE=\"ubuntu debian\"\n", "apt"},
+		{"ID=\"opensuse-leap\"\nID_LIKE=\"suse opensuse\"\n", "zypper"},
+	}
+
+	for _, tt := range tests {
+		writeOSRelease(t, tt.release)
+		pm, _, err := detectPackageManager()
+		require.NoError(t, err, tt.release)
+		assert.Equal(t, tt.want, pm.name, tt.release)
+	}
+
+	writeOSRelease(t, "ID=alpine\n")
+	_, _, err := detectPackageManager()
+	assert.ErrorContains(t, err, `unsupported distro "alpine"`)
+}
+
+func TestDependencyPackages(t *testing.T) {
+	t.Setenv("AGENT_DEPENDENCIES", "")
+	assert.Equal(t, []string{"git", "libncurses-dev", "zlib1g-dev", "python3"}, dependencyPackages(packageManagers["apt"]))
+
+	t.Setenv("AGENT_DEPENDENCIES", "zlib, rsync")
+	assert.Equal(t, []string{"zlib-devel", "rsync"}, dependencyPackages(packageManagers["dnf"]))
+}
+
+func TestEnsureDependenciesReportsMissing(t *testing.T) {
+	writeOSRelease(t, "ID=debian\n")
+	t.Setenv("AGENT_DEPENDENCIES", "git,zlib,python3")
+
+	apt := packageManagers["apt"]
+	defer func() { packageManagers["apt"] = apt }()
+
+	fake := apt
+	fake.installed = func(pkg string) bool { return pkg == "git" }
+	packageManagers["apt"] = fake
+
+	err := ensureDependencies()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing packages on debian: zlib1g-dev, python3")
+	assert.Contains(t, err.Error(), "sudo apt-get install -y zlib1g-dev python3")
+
+	fake.installed = func(string) bool { return true }
+	packageManagers["apt"] = fake
+	assert.NoError(t, ensureDependencies())
+}
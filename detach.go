@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const (
+	detachAuto   = "auto"
+	detachAlways = "always"
+	detachNever  = "never"
+
+	// detachedEnv marks the re-executed supervisor so it does not detach again
+	detachedEnv = "DISTBUILD_DETACHED"
+)
+
+var (
+	detachMode = detachAuto
+
+	// The commands are captured in init as they refer back to this file
+	bootstrapFlags *pflag.FlagSet
+	supervisorCmd  *cobra.Command
+)
+
+// nolint:gochecknoinits
+func init() {
+	bootstrapFlags = rootCmd.Flags()
+	supervisorCmd = agentStartCmd
+}
+
+func addDetachFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&detachMode, "detach", detachAuto, "re-exec the --supervise loop detached from the terminal: auto (when run from a terminal), always or never")
+}
+
+func checkDetachFlags() error {
+	switch detachMode {
+	case detachAuto, detachAlways, detachNever:
+		return nil
+	default:
+		return fmt.Errorf("invalid --detach %q, want %s, %s or %s", detachMode, detachAuto, detachAlways, detachNever)
+	}
+}
+
+// shouldDetach tells whether the supervisor has to move into the background
+// before it stays resident, so closing the ssh session that started it does
+// not take the agent down.
+func shouldDetach() bool {
+	if os.Getenv(detachedEnv) != "" {
+		return false
+	}
+
+	switch detachMode {
+	case detachAlways:
+		return true
+	case detachNever:
+		return false
+	default:
+		return stdinIsTerminal()
+	}
+}
+
+// detachedSupervisorArgs turns the flags given on the command line into an
+// `agent start --supervise` invocation, dropping the ones that only matter
+// before the agent is deployed.
+func detachedSupervisorArgs(flags *pflag.FlagSet) []string {
+	args := []string{"agent", "start", "--supervise"}
+
+	flags.Visit(func(f *pflag.Flag) {
+		if f.Name == "supervise" || f.Name == "detach" || supervisorCmd.Flag(f.Name) == nil {
+			return
+		}
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			for _, value := range slice.GetSlice() {
+				args = append(args, "--"+f.Name+"="+value)
+			}
+			return
+		}
+		args = append(args, "--"+f.Name+"="+f.Value.String())
+	})
+
+	return args
+}
+
+// startDetachedSupervisor re-executes bootstrap as a supervisor in its own
+// session with stdin on /dev/null and its output in supervisor.log.
+func startDetachedSupervisor(flags *pflag.FlagSet) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locate bootstrap executable failed: %w", err)
+	}
+
+	logPath := stateFile(distbuildPath, "supervisor.log")
+	if err := createDirs(filepath.Dir(logPath)); err != nil {
+		return fmt.Errorf("create state directory failed: %w", err)
+	}
+
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, policyFileMode(0644))
+	if err != nil {
+		return fmt.Errorf("open supervisor log failed: %w", err)
+	}
+	defer logFile.Close()
+
+	supervisor := exec.Command(exe, detachedSupervisorArgs(flags)...)
+	supervisor.Env = append(os.Environ(), detachedEnv+"=1")
+	// A nil Stdin is /dev/null
+	supervisor.Stdout = logFile
+	supervisor.Stderr = logFile
+	configureAgentProcess(supervisor)
+
+	if err := supervisor.Start(); err != nil {
+		return fmt.Errorf("start detached supervisor failed: %w", err)
+	}
+
+	printMsg("agent.detached", "pid", supervisor.Process.Pid, "log", logPath)
+
+	return supervisor.Process.Release()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDetachFlags(t *testing.T) {
+	defer func(mode string) { detachMode = mode }(detachMode)
+
+	for _, mode := range []string{detachAuto, detachAlways, detachNever} {
+		detachMode = mode
+		assert.NoError(t, checkDetachFlags())
+	}
+
+	detachMode = "sometimes"
+	assert.ErrorContains(t, checkDetachFlags(), "invalid --detach")
+}
+
+func TestShouldDetach(t *testing.T) {
+	defer func(mode string) { detachMode = mode }(detachMode)
+
+	t.Setenv(detachedEnv, "")
+
+	detachMode = detachAlways
+	assert.True(t, shouldDetach())
+
+	detachMode = detachNever
+	assert.False(t, shouldDetach())
+
+	// Tests do not run on a terminal
+	detachMode = detachAuto
+	assert.False(t, shouldDetach())
+
+	t.Setenv(detachedEnv, "1")
+	detachMode = detachAlways
+	assert.False(t, shouldDetach())
+}
+
+func TestDetachedSupervisorArgs(t *testing.T) {
+	flags := pflag.NewFlagSet("bootstrap", pflag.ContinueOnError)
+	flags.String("distbuild-path", "", "")
+	flags.String("aosp-path", "", "")
+	flags.Int("agent-port", 0, "")
+	flags.StringArray("agent-label", nil, "")
+	flags.Bool("supervise", false, "")
+	flags.String("detach", "", "")
+	flags.String("env-file", "", "")
+
+	require.NoError(t, flags.Parse([]string{
+		"--distbuild-path", "/opt/distbuild",
+		"--aosp-path", "/src/aosp",
+		"--agent-port", "9000",
+		"--agent-label", "pool=fast",
+		"--agent-label", "rack=b2",
+		"--supervise",
+		"--detach", "always",
+		"--env-file", "site.env",
+	}))
+
+	assert.Equal(t, []string{
+		"agent", "start", "--supervise",
+		"--agent-label=pool=fast",
+		"--agent-label=rack=b2",
+		"--agent-port=9000",
+		"--distbuild-path=/opt/distbuild",
+		"--env-file=site.env",
+	}, detachedSupervisorArgs(flags))
+}
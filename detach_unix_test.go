@@ -0,0 +1,51 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestConfigureAgentProcessDetaches(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "stdin")
+
+	// The child reports where its stdin points, then waits to be stopped
+	cmd := exec.Command("sh", "-c", `readlink /proc/self/fd/0 > "$1" 2>/dev/null || echo unknown > "$1"; sleep 30`, "sh", out)
+	configureAgentProcess(cmd)
+	require.NoError(t, cmd.Start())
+	defer func() {
+		_ = terminateAgentProcess(cmd.Process, 0)
+		_ = cmd.Wait()
+	}()
+
+	sid, err := unix.Getsid(cmd.Process.Pid)
+	require.NoError(t, err)
+	assert.Equal(t, cmd.Process.Pid, sid)
+
+	own, err := unix.Getsid(0)
+	require.NoError(t, err)
+	assert.NotEqual(t, own, sid)
+
+	pgid, err := syscall.Getpgid(cmd.Process.Pid)
+	require.NoError(t, err)
+	assert.Equal(t, cmd.Process.Pid, pgid)
+
+	assert.Eventually(t, func() bool {
+		data, err := os.ReadFile(out)
+		if err != nil || len(data) == 0 {
+			return false
+		}
+		stdin := strings.TrimSpace(string(data))
+		return stdin == os.DevNull || stdin == "unknown"
+	}, 5*time.Second, 20*time.Millisecond)
+}
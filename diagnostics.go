@@ -0,0 +1,233 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	diagnosticsDir = "diagnostics"
+
+	// diagnosticsLogLines is how much of each log a bundle keeps
+	diagnosticsLogLines = 500
+
+	redactedValue = "<redacted>"
+)
+
+var (
+	collectDiagnostics bool
+	diagnoseOutput     string
+	diagnoseSession    string
+)
+
+var diagnoseCmd = &cobra.Command{
+	Use:   "diagnose",
+	Short: "collect logs, manifest, settings and system info into a bundle for a support ticket",
+	Long: "Collect the agent and supervisor log tails, the audit log, the install manifest,\n" +
+		"the settings with secrets redacted and system info into a tarball. Runs with\n" +
+		"--collect-diagnostics write the same bundle on failure, together with the\n" +
+		"actions of the run and what git printed.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		if distbuildPath, err = expandPath(distbuildPath); err != nil {
+			exitWithError(withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err)))
+		}
+
+		var sess *session
+		if diagnoseSession != "" {
+			if sess, err = loadSession(diagnoseSession); err != nil {
+				exitWithError(err)
+			}
+		}
+
+		if _, err := writeDiagnostics(diagnoseOutput, sess); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	diagnoseCmd.Flags().StringVar(&distbuildPath, "distbuild-path", "", "distbuild binaries path")
+	diagnoseCmd.Flags().StringVar(&diagnoseOutput, "output-file", "", "write the bundle to this file (default: the state dir)")
+	diagnoseCmd.Flags().StringVar(&diagnoseSession, "session", "", "session recorded with --record to add to the bundle")
+	_ = diagnoseCmd.MarkFlagRequired("distbuild-path")
+}
+
+func addDiagnosticsFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&collectDiagnostics, "collect-diagnostics", false, "on failure, write a diagnostic bundle to attach to a support ticket")
+}
+
+// collectFailureDiagnostics writes the bundle of a failed run. The bundle
+// is best effort, the run already failed for another reason.
+func collectFailureDiagnostics(start time.Time, runErr error) {
+	if !collectDiagnostics || runErr == nil {
+		return
+	}
+
+	sess, err := recordedSession(start, runErr)
+	if err != nil {
+		warn("diagnostics.partial", "err", err)
+	}
+
+	if _, err := writeDiagnostics("", sess); err != nil {
+		printMsg("warning", "text", err)
+	}
+}
+
+// writeDiagnostics bundles what support needs to look into an install and
+// returns where the bundle went.
+func writeDiagnostics(output string, sess *session) (string, error) {
+	if output == "" {
+		output = stateFile(distbuildPath, filepath.Join(diagnosticsDir, "diagnostics-"+time.Now().Format("20060102-150405")+".tar.gz"))
+	}
+	output, err := expandPath(output)
+	if err != nil {
+		return "", withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err))
+	}
+
+	files := map[string][]byte{
+		"system.txt": systemInfo(),
+	}
+
+	if sess != nil {
+		if data, err := json.MarshalIndent(sess, "", "  "); err == nil {
+			files["session.json"] = data
+		}
+		if sess.Error != nil {
+			files["error.txt"] = []byte(sess.Error.Message + "\n" + sess.Error.Hint)
+		}
+	}
+
+	if entries, err := runConfig(); err != nil {
+		warn("diagnostics.partial", "err", err)
+	} else {
+		files["config.env"] = []byte(snapshotEnv(redactedConfig(entries)))
+	}
+
+	if data, err := os.ReadFile(manifestPath()); err == nil {
+		files["manifest.json"] = data
+	}
+
+	logs := map[string]string{
+		"agent.log":      existingStateFile(distbuildPath, "agent.log"),
+		"supervisor.log": existingStateFile(distbuildPath, "supervisor.log"),
+	}
+	if path, err := auditLogPath(); err == nil {
+		logs[auditLogName] = path
+	}
+	for name, path := range logs {
+		if data, err := os.ReadFile(path); err == nil {
+			files[name] = tailLines(data, diagnosticsLogLines)
+		}
+	}
+
+	if err := createDirs(filepath.Dir(output)); err != nil {
+		return "", withCode(errCodeDisk, fmt.Errorf("create diagnostics directory failed: %w", err))
+	}
+	// Logs and settings stay readable to the owner only
+	if err := writeFileAtomic(output, tarGzip(files), 0600); err != nil {
+		return "", withCode(errCodeDisk, fmt.Errorf("write diagnostics failed: %w", err))
+	}
+
+	printMsg("diagnostics.written", "path", output)
+
+	return output, nil
+}
+
+// redactedConfig keeps the keys of secrets so support can tell they were
+// set, the values are dropped.
+func redactedConfig(entries map[string]envEntry) map[string]string {
+	config := shareableConfig(entries)
+	for key, entry := range entries {
+		if _, ok := config[key]; !ok {
+			config[key] = ""
+			if entry.Value != "" {
+				config[key] = redactedValue
+			}
+		}
+	}
+
+	return config
+}
+
+func systemInfo() []byte {
+	var b strings.Builder
+
+	hostname, _ := os.Hostname()
+	fmt.Fprintf(&b, "bootstrap: %s-%s\n", BuildTime, CommitID)
+	fmt.Fprintf(&b, "platform: %s\n", platformName(runtime.GOOS, runtime.GOARCH, hostLibc()))
+	if version := glibcVersion(); version != "" {
+		fmt.Fprintf(&b, "glibc: %s\n", version)
+	}
+	fmt.Fprintf(&b, "hostname: %s\n", hostname)
+	fmt.Fprintf(&b, "cpus: %d\n", runtime.NumCPU())
+	fmt.Fprintf(&b, "euid: %d\n", os.Geteuid())
+	fmt.Fprintf(&b, "distbuild path: %s\n", distbuildPath)
+	if dir, err := stateDir(); err == nil {
+		fmt.Fprintf(&b, "state dir: %s\n", dir)
+	}
+
+	gitVer, err := gitVersion()
+	if err != nil {
+		gitVer = err.Error()
+	}
+	fmt.Fprintf(&b, "git: %s (%s)\n", gitVer, gitBinary())
+
+	if runtime.GOOS != "windows" {
+		if out, err := exec.Command("uname", "-a").Output(); err == nil {
+			fmt.Fprintf(&b, "uname: %s\n", strings.TrimSpace(string(out)))
+		}
+	}
+
+	return []byte(b.String())
+}
+
+func tailLines(data []byte, n int) []byte {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return bytes.Join(lines, nil)
+}
+
+func tarGzip(files map[string][]byte) []byte {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	for _, name := range names {
+		data := files[name]
+		// Writes to a bytes.Buffer do not fail
+		_ = tw.WriteHeader(&tar.Header{Name: "diagnostics/" + name, Mode: 0600, Size: int64(len(data)), ModTime: now})
+		_, _ = tw.Write(data)
+	}
+	_ = tw.Close()
+	_ = gz.Close()
+
+	return buf.Bytes()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readBundle(t *testing.T, path string) map[string]string {
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	assert.NoError(t, err)
+	tr := tar.NewReader(gz)
+
+	files := map[string]string{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		content, err := io.ReadAll(tr)
+		assert.NoError(t, err)
+		files[strings.TrimPrefix(header.Name, "diagnostics/")] = string(content)
+	}
+
+	return files
+}
+
+func TestWriteDiagnostics(t *testing.T) {
+	defer func(distbuild, state string, envs []string) {
+		distbuildPath, stateDirFlag, envFiles = distbuild, state, envs
+	}(distbuildPath, stateDirFlag, envFiles)
+	distbuildPath, stateDirFlag, envFiles = t.TempDir(), t.TempDir(), nil
+
+	t.Setenv("AUTH_PASS", "hunter2")
+	t.Setenv("DISTBUILD_REPO", "distbuild-fork")
+
+	var log strings.Builder
+	for i := 0; i < diagnosticsLogLines+10; i++ {
+		log.WriteString("line\n")
+	}
+	log.WriteString("agent crashed\n")
+	logPath := stateFile(distbuildPath, "agent.log")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(logPath), 0755))
+	assert.NoError(t, os.WriteFile(logPath, []byte(log.String()), 0644))
+
+	output := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	sess := &session{Version: sessionVersion, Error: &resultError{Message: "clone failed", Hint: "check REPO_HOST"}}
+	stdout := captureStdout(t, func() {
+		path, err := writeDiagnostics(output, sess)
+		assert.NoError(t, err)
+		assert.Equal(t, output, path)
+	})
+	assert.Contains(t, stdout, "diagnostic bundle written to "+output)
+
+	files := readBundle(t, output)
+	assert.Contains(t, files, "system.txt")
+	assert.Contains(t, files, "session.json")
+	assert.Contains(t, files["error.txt"], "clone failed")
+	assert.Contains(t, files["config.env"], "DISTBUILD_REPO = distbuild-fork")
+	assert.Contains(t, files["config.env"], "AUTH_PASS = "+redactedValue)
+	assert.NotContains(t, files["config.env"], "hunter2")
+	assert.Equal(t, diagnosticsLogLines, strings.Count(files["agent.log"], "\n"))
+	assert.True(t, strings.HasSuffix(files["agent.log"], "agent crashed\n"))
+}
+
+func TestCollectFailureDiagnostics(t *testing.T) {
+	defer func(distbuild, state string, collect bool) {
+		distbuildPath, stateDirFlag, collectDiagnostics, recording = distbuild, state, collect, nil
+	}(distbuildPath, stateDirFlag, collectDiagnostics)
+	distbuildPath, stateDirFlag = t.TempDir(), t.TempDir()
+
+	// Successful runs and runs without the flag leave no bundle
+	collectFailureDiagnostics(time.Now(), errors.New("boom"))
+	collectDiagnostics = true
+	collectFailureDiagnostics(time.Now(), nil)
+	assert.NoDirExists(t, filepath.Dir(stateFile(distbuildPath, filepath.Join(diagnosticsDir, "x"))))
+
+	startRecording()
+	recordAction("git", "clone distbuild", nil, map[string]string{"stderr": "fatal: repository not found"}, errors.New("exit status 128"))
+	captureStdout(t, func() {
+		collectFailureDiagnostics(time.Now(), withCode(errCodeGit, errors.New("clone distbuild failed")))
+	})
+
+	bundles, err := filepath.Glob(stateFile(distbuildPath, filepath.Join(diagnosticsDir, "*.tar.gz")))
+	assert.NoError(t, err)
+	assert.Len(t, bundles, 1)
+
+	files := readBundle(t, bundles[0])
+	assert.Contains(t, files["session.json"], "fatal: repository not found")
+	assert.Contains(t, files["error.txt"], "clone distbuild failed")
+}
+
+func TestTailLines(t *testing.T) {
+	assert.Equal(t, "b\nc\n", string(tailLines([]byte("a\nb\nc\n"), 2)))
+	assert.Equal(t, "b\nc", string(tailLines([]byte("a\nb\nc"), 2)))
+	assert.Equal(t, "a\n", string(tailLines([]byte("a\n"), 2)))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	preferIPv4 bool
+	preferIPv6 bool
+)
+
+// dialer races IPv4 and IPv6 (Happy Eyeballs) when no family is preferred.
+var dialer = &net.Dialer{
+	Timeout:       30 * time.Second,
+	KeepAlive:     30 * time.Second,
+	FallbackDelay: 300 * time.Millisecond,
+}
+
+func addDialFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVar(&preferIPv4, "prefer-ipv4", false, "try IPv4 before IPv6")
+	cmd.PersistentFlags().BoolVar(&preferIPv6, "prefer-ipv6", false, "try IPv6 before IPv4")
+	cmd.MarkFlagsMutuallyExclusive("prefer-ipv4", "prefer-ipv6")
+}
+
+func dialNetworks(network string) []string {
+	if network != "tcp" {
+		return []string{network}
+	}
+
+	switch {
+	case preferIPv4:
+		return []string{"tcp4", "tcp6"}
+	case preferIPv6:
+		return []string{"tcp6", "tcp4"}
+	default:
+		return []string{network}
+	}
+}
+
+func dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var errs []error
+
+	for _, nw := range dialNetworks(network) {
+		conn, err := dialer.DialContext(ctx, nw, address)
+		if err == nil {
+			logVerbose("connected to %s via %s (%s)", address, addressFamily(conn.RemoteAddr()), conn.RemoteAddr())
+			return conn, nil
+		}
+		errs = append(errs, describeDialError(nw, address, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+func describeDialError(network, address string, err error) error {
+	family := map[string]string{"tcp4": "ipv4", "tcp6": "ipv6"}[network]
+	if family == "" {
+		family = "ipv4/ipv6"
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		switch {
+		case dnsErr.IsNotFound:
+			return fmt.Errorf("dns lookup of %s found no %s address: %w", dnsErr.Name, family, err)
+		case dnsErr.IsTimeout:
+			return fmt.Errorf("dns lookup of %s timed out: %w", dnsErr.Name, err)
+		default:
+			return fmt.Errorf("dns lookup of %s failed: %w", dnsErr.Name, err)
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("connect to %s over %s timed out: %w", address, family, err)
+	}
+
+	return fmt.Errorf("connect to %s over %s failed: %w", address, family, err)
+}
+
+func addressFamily(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return "unknown"
+	}
+
+	if tcpAddr.IP.To4() != nil {
+		return "ipv4"
+	}
+
+	return "ipv6"
+}
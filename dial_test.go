@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialNetworks(t *testing.T) {
+	defer func(v4, v6 bool) {
+		preferIPv4, preferIPv6 = v4, v6
+	}(preferIPv4, preferIPv6)
+
+	preferIPv4, preferIPv6 = false, false
+	assert.Equal(t, []string{"tcp"}, dialNetworks("tcp"))
+
+	preferIPv6 = true
+	assert.Equal(t, []string{"tcp6", "tcp4"}, dialNetworks("tcp"))
+	assert.Equal(t, []string{"udp"}, dialNetworks("udp"))
+}
+
+func TestDialContext(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	conn, err := dialContext(context.Background(), "tcp", listener.Addr().String())
+	assert.NoError(t, err)
+	assert.Equal(t, "ipv4", addressFamily(conn.RemoteAddr()))
+	_ = conn.Close()
+
+	_, err = dialContext(context.Background(), "tcp", "host.invalid:80")
+	assert.ErrorContains(t, err, "dns lookup of host.invalid")
+}
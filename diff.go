@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// Kinds of differences between the installed state and a release set.
+const (
+	diffVersion = "version"
+	diffDigest  = "digest"
+	diffMissing = "missing"
+	diffExtra   = "extra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "show what apply would change to install a release set",
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		if distbuildPath, err = expandPath(distbuildPath); err != nil {
+			exitWithError(withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err)))
+		}
+		if err := checkToolchainHostOS(); err != nil {
+			exitWithError(withCode(errCodeUsage, err))
+		}
+		if err := printReleaseSetDiff(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	diffCmd.Flags().StringVar(&distbuildPath, "distbuild-path", "", "distbuild binaries path")
+	diffCmd.Flags().StringVar(&releaseSetName, "set", "", "name of the release set to compare with")
+	diffCmd.Flags().StringVar(&releaseSetSource, "release-sets", "", "url or directory the release sets are published under (default RELEASE_SETS_URL)")
+	diffCmd.Flags().StringVar(&toolchainHostOS, "toolchain-host-os", "", "host os of the toolchains to compare: linux, darwin, windows (default current os)")
+	addSchedulerConfigFlags(diffCmd)
+
+	_ = diffCmd.MarkFlagRequired("distbuild-path")
+	_ = diffCmd.MarkFlagRequired("set")
+}
+
+// setDifference is one component or toolchain that apply would change.
+// Installed and Wanted are versions, or digests and revisions when no
+// version is known.
+type setDifference struct {
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	Installed string `json:"installed,omitempty"`
+	Wanted    string `json:"wanted,omitempty"`
+}
+
+type setDiff struct {
+	Set         string          `json:"set"`
+	Installed   string          `json:"installed_set,omitempty"`
+	Differences []setDifference `json:"differences"`
+}
+
+// diffReleaseSet compares the manifest with a release set. Toolchains of
+// other hosts are left out, apply would not install them either.
+func diffReleaseSet(manifest *installManifest, set *releaseSet) *setDiff {
+	diff := &setDiff{Set: set.Name, Installed: manifest.ReleaseSet, Differences: []setDifference{}}
+
+	for _, name := range releaseSetComponents {
+		want := set.Components[name]
+		c := manifest.Components[name]
+		switch {
+		case c == nil:
+			diff.add(name, diffMissing, "", versionOr(want.Version, want.SHA256))
+		case c.SHA256 == want.SHA256:
+		case c.Version != "" && want.Version != "" && c.Version != want.Version:
+			diff.add(name, diffVersion, c.Version, want.Version)
+		default:
+			diff.add(name, diffDigest, shortHash(c.SHA256), shortHash(want.SHA256))
+		}
+	}
+
+	for name, c := range manifest.Components {
+		if _, ok := set.Components[name]; !ok {
+			diff.add(name, diffExtra, versionOr(c.Version, c.SHA256), "")
+		}
+	}
+
+	wanted := map[string]bool{}
+	for _, tc := range set.Toolchains {
+		if tc.HostOS != "" && tc.HostOS != toolchainHostOS {
+			continue
+		}
+		wanted[tc.key()] = true
+
+		installed := manifest.Toolchains[tc.key()]
+		switch {
+		case installed == nil:
+			diff.add(tc.key(), diffMissing, "", shortHash(tc.Commit))
+		case installed.Revision != tc.Commit:
+			diff.add(tc.key(), diffVersion, shortHash(installed.Revision), shortHash(tc.Commit))
+		}
+	}
+
+	for key, tc := range manifest.Toolchains {
+		if !wanted[key] {
+			diff.add(key, diffExtra, shortHash(tc.Revision), "")
+		}
+	}
+
+	sort.SliceStable(diff.Differences, func(i, j int) bool {
+		return diff.Differences[i].Name < diff.Differences[j].Name
+	})
+
+	return diff
+}
+
+func (d *setDiff) add(name, kind, installed, wanted string) {
+	d.Differences = append(d.Differences, setDifference{Name: name, Kind: kind, Installed: installed, Wanted: wanted})
+}
+
+func versionOr(version, sum string) string {
+	if version != "" {
+		return version
+	}
+
+	return shortHash(sum)
+}
+
+func printReleaseSetDiff() error {
+	set, err := prepareReleaseSet()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := loadManifest()
+	if err != nil {
+		return fmt.Errorf("load manifest failed: %w", err)
+	}
+
+	diff := diffReleaseSet(manifest, set)
+
+	if outputFormat == outputJSON {
+		data, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(diff.Differences) == 0 {
+		printMsg("diff.none", "set", set.Name)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, msg("diff.header"))
+	for _, d := range diff.Differences {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Name, msg("diff."+d.Kind), dashIfEmpty(d.Installed), dashIfEmpty(d.Wanted))
+	}
+
+	return w.Flush()
+}
+
+func dashIfEmpty(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
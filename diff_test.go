@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffReleaseSet(t *testing.T) {
+	defer func(hostOS string) {
+		toolchainHostOS = hostOS
+	}(toolchainHostOS)
+	toolchainHostOS = "linux"
+
+	set := testReleaseSet("https://artifacts")
+	set.Components["agent"] = releaseSetComponent{URL: "https://artifacts/agent", Version: "1.4.0", SHA256: sha256Hex([]byte("agent"))}
+	set.Toolchains = append(set.Toolchains, toolchainSpec{Name: "clang", HostOS: "darwin", Repo: "toolchains/clang", Path: "prebuilts/clang-darwin", Commit: set.Toolchains[0].Commit})
+
+	manifest := &installManifest{
+		ReleaseSet: "2024.05",
+		Components: map[string]*manifestComponent{
+			"agent":  {Name: "agent", Version: "1.3.2", SHA256: sha256Hex([]byte("agent 1.3.2"))},
+			"proxy":  {Name: "proxy", SHA256: sha256Hex([]byte("proxy 2"))},
+			"helper": {Name: "helper", Version: "0.1", SHA256: sha256Hex([]byte("helper"))},
+		},
+		Toolchains: map[string]*manifestToolchain{
+			"clang": {Name: "clang", Revision: set.Toolchains[0].Commit},
+			"rust":  {Name: "rust", Revision: sha256Hex([]byte("rust"))[:40]},
+		},
+	}
+
+	diff := diffReleaseSet(manifest, set)
+	assert.Equal(t, "2024.06", diff.Set)
+	assert.Equal(t, "2024.05", diff.Installed)
+	assert.Equal(t, []setDifference{
+		{Name: "agent", Kind: diffVersion, Installed: "1.3.2", Wanted: "1.4.0"},
+		{Name: "distninja", Kind: diffMissing, Wanted: shortHash(sha256Hex([]byte("distninja")))},
+		{Name: "helper", Kind: diffExtra, Installed: "0.1"},
+		{Name: "proxy", Kind: diffDigest, Installed: shortHash(sha256Hex([]byte("proxy 2"))), Wanted: shortHash(sha256Hex([]byte("proxy")))},
+		{Name: "rust", Kind: diffExtra, Installed: shortHash(sha256Hex([]byte("rust")))},
+	}, diff.Differences)
+
+	// The installed state of a set has nothing to apply
+	manifest.Components = map[string]*manifestComponent{}
+	for name, c := range set.Components {
+		manifest.Components[name] = &manifestComponent{Name: name, SHA256: c.SHA256}
+	}
+	delete(manifest.Toolchains, "rust")
+	assert.Empty(t, diffReleaseSet(manifest, set).Differences)
+}
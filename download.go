@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"distbuild/boong/bootstrap/download"
+	"github.com/spf13/cobra"
+)
+
+var (
+	clientCert         string
+	clientKey          string
+	insecureSkipVerify bool
+)
+
+// httpClient is shared by every download, setupHTTPClient replaces it once
+// the TLS and dial settings from flags and env files are known.
+var httpClient = &http.Client{}
+
+func addTLSFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&clientCert, "client-cert", "", "client certificate for mutual TLS (env CLIENT_CERT)")
+	cmd.PersistentFlags().StringVar(&clientKey, "client-key", "", "client private key for mutual TLS (env CLIENT_KEY)")
+	cmd.PersistentFlags().BoolVar(&insecureSkipVerify, "insecure-skip-verify", false,
+		"skip server certificate verification (env INSECURE_SKIP_VERIFY)")
+}
+
+func setupHTTPClient() error {
+	certFile := clientCert
+	if certFile == "" {
+		certFile = os.Getenv("CLIENT_CERT")
+	}
+
+	keyFile := clientKey
+	if keyFile == "" {
+		keyFile = os.Getenv("CLIENT_KEY")
+	}
+
+	skipVerify := insecureSkipVerify
+	if !skipVerify {
+		if value, ok := os.LookupEnv("INSECURE_SKIP_VERIFY"); ok && value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid INSECURE_SKIP_VERIFY: %w", err)
+			}
+			skipVerify = parsed
+		}
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return fmt.Errorf("client certificate and key must be set together")
+	}
+
+	// nolint:gosec
+	tlsConfig := &tls.Config{InsecureSkipVerify: skipVerify}
+
+	if certFile != "" {
+		var err error
+		if certFile, err = expandPath(certFile); err != nil {
+			return fmt.Errorf("failed to expand path: %w", err)
+		}
+		if keyFile, err = expandPath(keyFile); err != nil {
+			return fmt.Errorf("failed to expand path: %w", err)
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return withCode(errCodeAuth, fmt.Errorf("load client certificate failed: %w", err))
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if skipVerify {
+		warn("tls.insecure")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.DialContext = dialContext
+
+	headers, err := newHeaderTransport(transport)
+	if err != nil {
+		return err
+	}
+	httpClient = &http.Client{Transport: headers, CheckRedirect: checkRedirect}
+
+	return nil
+}
+
+func newGetRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	authorizeRequest(req)
+
+	return req, nil
+}
+
+func authorizeRequest(req *http.Request) {
+	username := os.Getenv("AUTH_USER")
+	password := os.Getenv("AUTH_PASS")
+
+	if username != "" && password != "" {
+		req.SetBasicAuth(username, password)
+	}
+}
+
+// sourceConfig hands the shared client and credentials to the artifact
+// source backends.
+func sourceConfig() download.Config {
+	return download.Config{Client: httpClient, Authorize: authorizeRequest}
+}
+
+func isHTTPURL(rawURL string) bool {
+	return strings.HasPrefix(rawURL, "http://") || strings.HasPrefix(rawURL, "https://")
+}
+
+func downloadFile(url, filePath string) (err error) {
+	if isPayloadURL(url) {
+		return extractPayloadBinary(url, filePath)
+	}
+
+	trace := newDownloadTrace(filepath.Base(filePath), url)
+	defer func() {
+		trace.finish(err)
+	}()
+	ctx := trace.context(runCtx)
+
+	format := compressionFormat(url)
+
+	// Fail before the transfer when the manifest is unusable
+	if _, err := loadChecksums(); err != nil {
+		return err
+	}
+
+	if isHTTPURL(url) {
+		size, validator, ranged := probeRanges(ctx, url)
+		if ranged {
+			fetched, err := downloadBlocks(ctx, url, validator, filePath, format, size)
+			trace.bytes.Add(fetched)
+			if errors.Is(err, errRangeChanged) {
+				logVerbose("%s changed during the download, fetching it whole: %v", filepath.Base(filePath), err)
+				ranged = false
+			} else if !errors.Is(err, errNoBlockIndex) {
+				return err
+			}
+		}
+		if shouldDownloadChunked(size, ranged) {
+			logVerbose("downloading %s in chunks (%d bytes)", filepath.Base(filePath), size)
+			err := downloadFileChunked(ctx, trace, url, validator, filePath, format, size)
+			if !errors.Is(err, errRangeChanged) {
+				return err
+			}
+			logVerbose("%s changed during the download, fetching it whole: %v", filepath.Base(filePath), err)
+		}
+	}
+
+	src, size, err := download.Open(ctx, url, sourceConfig())
+	if err != nil {
+		return fmt.Errorf("download failed: %w [%s]", err, filepath.Base(filePath))
+	}
+
+	defer func(src io.ReadCloser) {
+		_ = src.Close()
+	}(src)
+
+	out, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("create file failed: %w [%s]", err, filepath.Base(filePath))
+	}
+
+	defer func(out *os.File) {
+		_ = out.Close()
+	}(out)
+
+	// The manifest lists the sums of the published, possibly compressed bytes
+	hash := sha256.New()
+	raw := io.TeeReader(trace.reader(trackTransfer(src, size)), hash)
+
+	body, closeBody, err := decompressReader(format, raw)
+	if err != nil {
+		return fmt.Errorf("decompress failed: %v [%s]", err, filepath.Base(filePath))
+	}
+
+	defer closeBody()
+
+	if _, err = io.Copy(out, body); err == nil {
+		_, err = io.Copy(io.Discard, raw)
+	}
+	if err != nil {
+		_ = out.Close()
+		_ = os.Remove(filePath)
+		return fmt.Errorf("write file failed: %w [%s]", err, filepath.Base(filePath))
+	}
+
+	if err := verifyChecksum(url, hex.EncodeToString(hash.Sum(nil))); err != nil {
+		_ = out.Close()
+		_ = os.Remove(filePath)
+		return err
+	}
+
+	if err := os.Chmod(filePath, policyFileMode(0755)); err != nil {
+		return fmt.Errorf("chmod failed: %w [%s]", err, filepath.Base(filePath))
+	}
+
+	return nil
+}
+
+// downloadFileChunked fetches an artifact of size bytes in parallel ranges
+// and verifies it, decompressing it into filePath when it is compressed.
+func downloadFileChunked(ctx context.Context, trace *downloadTrace, url, validator, filePath, format string, size int64) error {
+	if format == "" {
+		if err := downloadChunked(ctx, url, validator, filePath, size); err != nil {
+			return err
+		}
+		trace.bytes.Add(size)
+		return verifyFileChecksum(url, filePath, filePath)
+	}
+
+	partPath := filePath + ".part"
+	if err := downloadChunked(ctx, url, validator, partPath, size); err != nil {
+		return err
+	}
+	trace.bytes.Add(size)
+
+	if err := verifyFileChecksum(url, partPath, partPath); err != nil {
+		return err
+	}
+
+	if err := timed(timingExtract, filepath.Base(filePath), func() error {
+		return decompressFile(format, partPath, filePath)
+	}); err != nil {
+		return fmt.Errorf("decompress failed: %v [%s]", err, filepath.Base(filePath))
+	}
+
+	return nil
+}
+
+func fetchBytes(rawURL string) ([]byte, error) {
+	// Embedded binaries come without SBOMs, patches or checksums
+	if isPayloadURL(rawURL) {
+		return nil, fmt.Errorf("not published for embedded artifact %s", rawURL)
+	}
+
+	src, _, err := download.Open(runCtx, rawURL, sourceConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(src io.ReadCloser) {
+		_ = src.Close()
+	}(src)
+
+	return io.ReadAll(src)
+}
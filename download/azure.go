@@ -0,0 +1,50 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const azureAPIVersion = "2021-08-06"
+
+// azureSource reads azblob://account/container/blob. Access is granted by
+// a SAS token from AZURE_STORAGE_SAS_TOKEN, without one the container must
+// allow anonymous reads.
+type azureSource struct {
+	client *http.Client
+}
+
+func newAzureSource(cfg Config) Source {
+	return &azureSource{client: cfg.client()}
+}
+
+func (s *azureSource) Open(ctx context.Context, rawURL string) (io.ReadCloser, int64, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	if u.Host == "" || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, 0, fmt.Errorf("azure url must be azblob://account/container/blob: %s", rawURL)
+	}
+
+	endpoint := os.Getenv("AZURE_STORAGE_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://" + u.Host + ".blob.core.windows.net"
+	}
+
+	target := strings.TrimSuffix(endpoint, "/") + "/" + parts[0] + "/" + parts[1]
+	if sas := strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"); sas != "" {
+		target += "?" + sas
+	}
+
+	return get(ctx, s.client, target, func(req *http.Request) {
+		req.Header.Set("X-Ms-Version", azureAPIVersion)
+	})
+}
@@ -0,0 +1,140 @@
+package download
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// registryCredentials are the credentials docker stores for a registry.
+// IdentityToken replaces the password for registries using OAuth2.
+type registryCredentials struct {
+	Username      string
+	Password      string
+	IdentityToken string
+}
+
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredHelpers map[string]string `json:"credHelpers"`
+	CredsStore  string            `json:"credsStore"`
+}
+
+func dockerConfigPath() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+// dockerCredentials looks up a registry in the docker config the same way
+// `docker login` stored it: a per-registry credential helper first, then
+// the default credential store, then inline auths. A missing config means
+// anonymous access.
+func dockerCredentials(registry string) (registryCredentials, error) {
+	configPath, err := dockerConfigPath()
+	if err != nil {
+		return registryCredentials{}, nil
+	}
+
+	data, err := os.ReadFile(configPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return registryCredentials{}, nil
+	}
+	if err != nil {
+		return registryCredentials{}, fmt.Errorf("read docker config failed: %w", err)
+	}
+
+	var config dockerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return registryCredentials{}, fmt.Errorf("parse docker config failed: %w", err)
+	}
+
+	if helper := config.CredHelpers[registry]; helper != "" {
+		return credentialHelper(helper, registry)
+	}
+
+	for _, key := range registryKeys(registry) {
+		entry, ok := config.Auths[key]
+		if !ok {
+			continue
+		}
+		creds := registryCredentials{IdentityToken: entry.IdentityToken}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return registryCredentials{}, fmt.Errorf("decode auth for %s failed: %w", key, err)
+			}
+			creds.Username, creds.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		if creds.Username != "" || creds.IdentityToken != "" {
+			return creds, nil
+		}
+	}
+
+	if config.CredsStore != "" {
+		return credentialHelper(config.CredsStore, registry)
+	}
+
+	return registryCredentials{}, nil
+}
+
+// registryKeys lists the spellings docker uses as auths keys for a host.
+func registryKeys(registry string) []string {
+	keys := []string{registry, "https://" + registry, "http://" + registry}
+	if registry == "docker.io" || registry == "registry-1.docker.io" {
+		keys = append(keys, "https://index.docker.io/v1/")
+	}
+
+	return keys
+}
+
+// credentialHelper runs docker-credential-<helper> get. Helpers answer with
+// exit status 1 and "credentials not found" for unknown registries, which
+// falls back to anonymous access.
+func credentialHelper(helper, registry string) (registryCredentials, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(registry)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stdout.String()+stderr.String(), "credentials not found") {
+			return registryCredentials{}, nil
+		}
+		return registryCredentials{}, fmt.Errorf("docker-credential-%s failed: %v: %s", helper, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var out struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return registryCredentials{}, fmt.Errorf("parse docker-credential-%s output failed: %w", helper, err)
+	}
+
+	// "<token>" marks the secret as an identity token
+	if out.Username == "<token>" {
+		return registryCredentials{IdentityToken: out.Secret}, nil
+	}
+
+	return registryCredentials{Username: out.Username, Password: out.Secret}, nil
+}
@@ -0,0 +1,72 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+type fileSource struct{}
+
+func newFileSource(Config) Source {
+	return fileSource{}
+}
+
+func (fileSource) Open(_ context.Context, rawURL string) (io.ReadCloser, int64, error) {
+	path, err := filePath(rawURL)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, 0, err
+	}
+
+	if info.IsDir() {
+		_ = file.Close()
+		return nil, 0, fmt.Errorf("%s is a directory", path)
+	}
+
+	return file, info.Size(), nil
+}
+
+// filePath maps file:///abs/path, file://host/share/path and plain paths to
+// a local path.
+func filePath(rawURL string) (string, error) {
+	if !strings.Contains(rawURL, "://") {
+		return rawURL, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	path := u.Path
+	if runtime.GOOS == "windows" {
+		switch {
+		case u.Host != "" && u.Host != "localhost":
+			// UNC path
+			path = `\\` + u.Host + filepath.FromSlash(path)
+		case len(path) > 2 && path[0] == '/' && path[2] == ':':
+			// file:///C:/dir
+			path = filepath.FromSlash(path[1:])
+		}
+	} else if u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf("remote file host %q not supported", u.Host)
+	}
+
+	return path, nil
+}
@@ -0,0 +1,49 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// gcsSource reads gs://bucket/object through the XML API. An access token
+// from GOOGLE_OAUTH_ACCESS_TOKEN is sent as bearer token, without one the
+// object must be public.
+type gcsSource struct {
+	client *http.Client
+}
+
+func newGCSSource(cfg Config) Source {
+	return &gcsSource{client: cfg.client()}
+}
+
+func (s *gcsSource) Open(ctx context.Context, rawURL string) (io.ReadCloser, int64, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	object := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || object == "" {
+		return nil, 0, fmt.Errorf("gcs url must be gs://bucket/object: %s", rawURL)
+	}
+
+	endpoint := os.Getenv("STORAGE_EMULATOR_HOST")
+	if endpoint == "" {
+		endpoint = gcsEndpoint
+	}
+
+	target := strings.TrimSuffix(endpoint, "/") + "/" + u.Host + "/" + object
+
+	return get(ctx, s.client, target, func(req *http.Request) {
+		if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	})
+}
@@ -0,0 +1,20 @@
+package download
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+type httpSource struct {
+	client    *http.Client
+	authorize func(req *http.Request)
+}
+
+func newHTTPSource(cfg Config) Source {
+	return &httpSource{client: cfg.client(), authorize: cfg.Authorize}
+}
+
+func (s *httpSource) Open(ctx context.Context, rawURL string) (io.ReadCloser, int64, error) {
+	return get(ctx, s.client, rawURL, s.authorize)
+}
@@ -0,0 +1,416 @@
+package download
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"runtime"
+	"strings"
+)
+
+const (
+	mediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	// annotationTitle names the file a layer was pushed from, ORAS sets it
+	annotationTitle = "org.opencontainers.image.title"
+)
+
+// ErrDigestMismatch is returned when pulled content does not match the
+// digest it was pinned to.
+var ErrDigestMismatch = errors.New("digest mismatch")
+
+// ociReference is a parsed oci://registry/repository[:tag][@digest][#file].
+type ociReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+	// File selects a layer by its title when the artifact has several
+	File string
+}
+
+func (r ociReference) manifestRef() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+
+	return r.Tag
+}
+
+func parseOCIReference(rawURL string) (ociReference, error) {
+	rest := strings.TrimPrefix(rawURL, "oci://")
+
+	var ref ociReference
+	rest, ref.File, _ = strings.Cut(rest, "#")
+
+	slash := strings.Index(rest, "/")
+	if slash <= 0 || slash == len(rest)-1 {
+		return ociReference{}, fmt.Errorf("oci url must be oci://registry/repository[:tag][@digest]: %s", rawURL)
+	}
+
+	ref.Registry = rest[:slash]
+	repository := rest[slash+1:]
+
+	if at := strings.Index(repository, "@"); at >= 0 {
+		ref.Digest = repository[at+1:]
+		repository = repository[:at]
+		if _, _, err := splitDigest(ref.Digest); err != nil {
+			return ociReference{}, err
+		}
+	}
+
+	// A colon after the last slash separates the tag, earlier ones are ports
+	if colon := strings.LastIndex(repository, ":"); colon > strings.LastIndex(repository, "/") {
+		ref.Tag = repository[colon+1:]
+		repository = repository[:colon]
+	}
+
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+
+	ref.Repository = repository
+
+	return ref, nil
+}
+
+func splitDigest(digest string) (string, string, error) {
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	if !ok || algorithm != "sha256" || len(encoded) != sha256.Size*2 {
+		return "", "", fmt.Errorf("invalid digest %q, expected sha256:<64 hex>", digest)
+	}
+
+	return algorithm, encoded, nil
+}
+
+type ociPlatform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []ociDescriptor `json:"layers"`
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+// ociSource pulls a file from an OCI artifact the way `oras pull` does: it
+// resolves the manifest, following an index to the current platform, picks
+// the layer by its title and streams the blob, verifying every digest.
+type ociSource struct {
+	client *http.Client
+	// tokens caches bearer tokens per registry and scope
+	tokens map[string]string
+	// credentials resolves docker credentials for a registry host
+	credentials func(registry string) (registryCredentials, error)
+}
+
+func newOCISource(cfg Config) Source {
+	return &ociSource{client: cfg.client(), tokens: map[string]string{}, credentials: dockerCredentials}
+}
+
+func (s *ociSource) Open(ctx context.Context, rawURL string) (io.ReadCloser, int64, error) {
+	ref, err := parseOCIReference(rawURL)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	manifest, err := s.fetchManifest(ctx, ref, ref.manifestRef(), ref.Digest)
+	if err != nil {
+		return nil, 0, fmt.Errorf("fetch manifest failed: %w", err)
+	}
+
+	if len(manifest.Manifests) > 0 {
+		desc, err := selectPlatform(manifest.Manifests, runtime.GOOS, runtime.GOARCH)
+		if err != nil {
+			return nil, 0, err
+		}
+		if manifest, err = s.fetchManifest(ctx, ref, desc.Digest, desc.Digest); err != nil {
+			return nil, 0, fmt.Errorf("fetch platform manifest failed: %w", err)
+		}
+	}
+
+	layer, err := selectLayer(manifest.Layers, ref)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	body, _, err := s.get(ctx, ref, "/blobs/"+layer.Digest, "")
+	if err != nil {
+		return nil, 0, fmt.Errorf("fetch blob %s failed: %w", layer.Digest, err)
+	}
+
+	return newDigestReader(body, layer.Digest), layer.Size, nil
+}
+
+// selectLayer picks the layer named by the #file fragment, the only layer,
+// or the layer titled like the last repository segment.
+func selectLayer(layers []ociDescriptor, ref ociReference) (ociDescriptor, error) {
+	if ref.File == "" && len(layers) == 1 {
+		return layers[0], nil
+	}
+
+	want := ref.File
+	if want == "" {
+		want = path.Base(ref.Repository)
+	}
+
+	var titles []string
+	for _, layer := range layers {
+		title := layer.Annotations[annotationTitle]
+		if title == want {
+			return layer, nil
+		}
+		titles = append(titles, title)
+	}
+
+	return ociDescriptor{}, fmt.Errorf("artifact has no file %q (files: %s), select one with #<file>", want, strings.Join(titles, ", "))
+}
+
+func selectPlatform(manifests []ociDescriptor, goos, goarch string) (ociDescriptor, error) {
+	for _, desc := range manifests {
+		if desc.Platform != nil && desc.Platform.OS == goos && desc.Platform.Architecture == goarch {
+			return desc, nil
+		}
+	}
+
+	return ociDescriptor{}, fmt.Errorf("index has no manifest for %s/%s", goos, goarch)
+}
+
+// fetchManifest reads a manifest and, when pinned, checks it against digest.
+func (s *ociSource) fetchManifest(ctx context.Context, ref ociReference, reference, digest string) (*ociManifest, error) {
+	accept := strings.Join([]string{mediaTypeOCIManifest, mediaTypeOCIIndex, mediaTypeDockerManifest, mediaTypeDockerList}, ", ")
+
+	body, _, err := s.get(ctx, ref, "/manifests/"+reference, accept)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(body)
+
+	data, err := io.ReadAll(io.LimitReader(body, 4<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if digest != "" {
+		sum := sha256.Sum256(data)
+		if got := "sha256:" + hex.EncodeToString(sum[:]); got != digest {
+			return nil, fmt.Errorf("manifest %w: expected %s, got %s", ErrDigestMismatch, digest, got)
+		}
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("parse manifest failed: %w", err)
+	}
+
+	return &manifest, nil
+}
+
+// get requests a registry API path, answering an auth challenge once.
+func (s *ociSource) get(ctx context.Context, ref ociReference, apiPath, accept string) (io.ReadCloser, int64, error) {
+	target := "https://" + ref.Registry + "/v2/" + ref.Repository + apiPath
+	scope := "repository:" + ref.Repository + ":pull"
+	cacheKey := ref.Registry + " " + scope
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if auth := s.tokens[cacheKey]; auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			return resp.Body, resp.ContentLength, nil
+		}
+
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return nil, 0, NewStatusError(resp)
+		}
+
+		auth, err := s.authorize(ctx, ref.Registry, resp.Header.Get("Www-Authenticate"), scope)
+		if err != nil {
+			return nil, 0, fmt.Errorf("registry auth failed: %w", err)
+		}
+		s.tokens[cacheKey] = auth
+	}
+}
+
+// authorize answers a registry challenge and returns the Authorization
+// header value for the following requests.
+func (s *ociSource) authorize(ctx context.Context, registry, challenge, scope string) (string, error) {
+	creds, err := s.credentials(registry)
+	if err != nil {
+		return "", err
+	}
+
+	scheme, _, _ := strings.Cut(challenge, " ")
+	if strings.EqualFold(scheme, "basic") {
+		if creds.Username == "" {
+			return "", fmt.Errorf("registry %s requires credentials, run docker login", registry)
+		}
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(creds.Username, creds.Password)
+		return req.Header.Get("Authorization"), nil
+	}
+
+	params := parseChallenge(challenge)
+
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("unsupported challenge %q", challenge)
+	}
+
+	if params["scope"] != "" {
+		scope = params["scope"]
+	}
+
+	token, err := s.fetchToken(ctx, realm, params["service"], scope, creds)
+	if err != nil {
+		return "", err
+	}
+
+	return "Bearer " + token, nil
+}
+
+// fetchToken implements the distribution token flow: an identity token is
+// exchanged with a POST, basic credentials or anonymous access use a GET.
+func (s *ociSource) fetchToken(ctx context.Context, realm, service, scope string, creds registryCredentials) (string, error) {
+	var req *http.Request
+	var err error
+
+	if creds.IdentityToken != "" {
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {creds.IdentityToken},
+			"service":       {service},
+			"scope":         {scope},
+			"client_id":     {"bootstrap"},
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm, strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		query := url.Values{"scope": {scope}}
+		if service != "" {
+			query.Set("service", service)
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, realm+"?"+query.Encode(), nil)
+		if err == nil && creds.Username != "" {
+			req.SetBasicAuth(creds.Username, creds.Password)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return "", NewStatusError(resp)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("parse token failed: %w", err)
+	}
+
+	if token.Token != "" {
+		return token.Token, nil
+	}
+
+	return token.AccessToken, nil
+}
+
+// parseChallenge splits `Bearer realm="...",service="..."` into its params.
+func parseChallenge(challenge string) map[string]string {
+	params := map[string]string{}
+
+	scheme, rest, ok := strings.Cut(challenge, " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return params
+	}
+
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, ", "), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+
+	return params
+}
+
+// digestReader hashes a blob while it streams and fails the final read when
+// the content does not match the descriptor digest.
+type digestReader struct {
+	io.ReadCloser
+	hash   hash.Hash
+	digest string
+}
+
+func newDigestReader(body io.ReadCloser, digest string) io.ReadCloser {
+	return &digestReader{ReadCloser: body, hash: sha256.New(), digest: digest}
+}
+
+func (r *digestReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+
+	if errors.Is(err, io.EOF) {
+		if got := "sha256:" + hex.EncodeToString(r.hash.Sum(nil)); got != r.digest {
+			return n, fmt.Errorf("blob %w: expected %s, got %s", ErrDigestMismatch, r.digest, got)
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,162 @@
+package download
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestParseOCIReference(t *testing.T) {
+	ref, err := parseOCIReference("oci://registry:5000/team/agent:v1.2#agent.zst")
+	assert.NoError(t, err)
+	assert.Equal(t, ociReference{Registry: "registry:5000", Repository: "team/agent", Tag: "v1.2", File: "agent.zst"}, ref)
+
+	digest := digestOf([]byte("manifest"))
+	ref, err = parseOCIReference("oci://ghcr.io/team/agent:v1@" + digest)
+	assert.NoError(t, err)
+	assert.Equal(t, digest, ref.manifestRef())
+
+	ref, err = parseOCIReference("oci://ghcr.io/team/agent")
+	assert.NoError(t, err)
+	assert.Equal(t, "latest", ref.Tag)
+
+	_, err = parseOCIReference("oci://ghcr.io")
+	assert.Error(t, err)
+
+	_, err = parseOCIReference("oci://ghcr.io/team/agent@sha256:abc")
+	assert.Error(t, err)
+}
+
+func TestParseChallenge(t *testing.T) {
+	params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry",scope="repository:a:pull"`)
+	assert.Equal(t, map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry",
+		"scope":   "repository:a:pull",
+	}, params)
+
+	assert.Empty(t, parseChallenge(`Basic realm="x"`))
+}
+
+func TestSelectLayer(t *testing.T) {
+	layers := []ociDescriptor{
+		{Digest: "a", Annotations: map[string]string{annotationTitle: "agent"}},
+		{Digest: "b", Annotations: map[string]string{annotationTitle: "agent.sbom.json"}},
+	}
+
+	layer, err := selectLayer(layers, ociReference{Repository: "team/agent"})
+	assert.NoError(t, err)
+	assert.Equal(t, "a", layer.Digest)
+
+	layer, err = selectLayer(layers, ociReference{Repository: "team/agent", File: "agent.sbom.json"})
+	assert.NoError(t, err)
+	assert.Equal(t, "b", layer.Digest)
+
+	_, err = selectLayer(layers, ociReference{Repository: "team/tools"})
+	assert.ErrorContains(t, err, "files: agent, agent.sbom.json")
+}
+
+func TestDockerCredentials(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	creds, err := dockerCredentials("registry.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, registryCredentials{}, creds)
+
+	config := `{"auths":{"https://registry.example.com":{"auth":"` + base64.StdEncoding.EncodeToString([]byte("user:pass")) + `"}}}`
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600))
+
+	creds, err = dockerCredentials("registry.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, registryCredentials{Username: "user", Password: "pass"}, creds)
+}
+
+func TestOCISource(t *testing.T) {
+	blob := []byte("agent")
+	manifest, _ := json.Marshal(ociManifest{
+		MediaType: mediaTypeOCIManifest,
+		Layers: []ociDescriptor{{
+			Digest:      digestOf(blob),
+			Size:        int64(len(blob)),
+			Annotations: map[string]string{annotationTitle: "agent"},
+		}},
+	})
+
+	var ts *httptest.Server
+	ts = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"token": "secret"})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("Www-Authenticate", `Bearer realm="`+ts.URL+`/token",service="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/team/agent/manifests/v1", "/v2/team/agent/manifests/" + digestOf(manifest):
+			_, _ = w.Write(manifest)
+		case "/v2/team/agent/blobs/" + digestOf(blob):
+			_, _ = w.Write(blob)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	registry := strings.TrimPrefix(ts.URL, "https://")
+	source := &ociSource{
+		client: ts.Client(),
+		tokens: map[string]string{},
+		credentials: func(string) (registryCredentials, error) {
+			return registryCredentials{Username: "user", Password: "pass"}, nil
+		},
+	}
+
+	for _, rawURL := range []string{
+		"oci://" + registry + "/team/agent:v1",
+		"oci://" + registry + "/team/agent@" + digestOf(manifest),
+	} {
+		body, size, err := source.Open(context.Background(), rawURL)
+		assert.NoError(t, err)
+		data, err := io.ReadAll(body)
+		assert.NoError(t, err)
+		_ = body.Close()
+		assert.Equal(t, "agent", string(data))
+		assert.Equal(t, int64(5), size)
+	}
+
+	_, _, err := source.Open(context.Background(), "oci://"+registry+"/team/agent@"+digestOf([]byte("other")))
+	assert.Error(t, err)
+
+	_, _, err = source.Open(context.Background(), "oci://"+registry+"/team/agent:missing")
+	assert.Error(t, err)
+}
+
+func TestDigestReader(t *testing.T) {
+	reader := newDigestReader(io.NopCloser(strings.NewReader("agent")), digestOf([]byte("other")))
+	_, err := io.ReadAll(reader)
+	assert.True(t, errors.Is(err, ErrDigestMismatch))
+}
@@ -0,0 +1,152 @@
+// Package download resolves artifact URLs to the backend that serves them.
+// Every backend is a Source selected by URL scheme, so callers can stream an
+// artifact the same way whether it lives on a web server, in object storage,
+// on local disk or in an OCI registry.
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Source opens artifacts served by one kind of backend.
+type Source interface {
+	// Open returns the artifact body and its size, -1 when unknown.
+	Open(ctx context.Context, rawURL string) (io.ReadCloser, int64, error)
+}
+
+// Config carries the settings shared by every backend.
+type Config struct {
+	// Client performs all network requests, it carries the TLS and dial
+	// settings of the caller.
+	Client *http.Client
+	// Authorize adds credentials to plain HTTP(S) requests.
+	Authorize func(req *http.Request)
+}
+
+func (c Config) client() *http.Client {
+	if c.Client == nil {
+		return http.DefaultClient
+	}
+
+	return c.Client
+}
+
+// RequestIDHeader carries the id the server logs a request under.
+const RequestIDHeader = "X-Request-ID"
+
+// StatusError reports an unexpected HTTP status from a backend.
+type StatusError struct {
+	StatusCode int
+	// RequestID is the X-Request-ID the request was sent with, if any, to
+	// find the failure in the server logs.
+	RequestID string
+}
+
+// NewStatusError reports the status of resp.
+func NewStatusError(resp *http.Response) *StatusError {
+	e := &StatusError{StatusCode: resp.StatusCode}
+	if resp.Request != nil {
+		e.RequestID = resp.Request.Header.Get(RequestIDHeader)
+	}
+
+	return e
+}
+
+func (e *StatusError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("status code %d (request id %s)", e.StatusCode, e.RequestID)
+	}
+
+	return fmt.Sprintf("status code %d", e.StatusCode)
+}
+
+var factories = map[string]func(Config) Source{
+	"http":   newHTTPSource,
+	"https":  newHTTPSource,
+	"file":   newFileSource,
+	"s3":     newS3Source,
+	"gs":     newGCSSource,
+	"azblob": newAzureSource,
+	"oci":    newOCISource,
+}
+
+// Schemes lists the URL schemes with a registered backend.
+func Schemes() []string {
+	schemes := make([]string, 0, len(factories))
+	for scheme := range factories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+
+	return schemes
+}
+
+// Supported reports whether rawURL has a backend. URLs without a scheme are
+// local file paths.
+func Supported(rawURL string) bool {
+	_, ok := factories[scheme(rawURL)]
+	return ok
+}
+
+// New returns the Source for the scheme of rawURL.
+func New(rawURL string, cfg Config) (Source, error) {
+	factory, ok := factories[scheme(rawURL)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported scheme %q", scheme(rawURL))
+	}
+
+	return factory(cfg), nil
+}
+
+// Open is a shortcut for New followed by Source.Open.
+func Open(ctx context.Context, rawURL string, cfg Config) (io.ReadCloser, int64, error) {
+	source, err := New(rawURL, cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return source.Open(ctx, rawURL)
+}
+
+func scheme(rawURL string) string {
+	if !strings.Contains(rawURL, "://") {
+		return "file"
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Scheme)
+}
+
+// get performs a GET and turns any status other than 200 into a StatusError.
+func get(ctx context.Context, client *http.Client, target string, prepare func(req *http.Request)) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if prepare != nil {
+		prepare(req)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, 0, NewStatusError(resp)
+	}
+
+	return resp.Body, resp.ContentLength, nil
+}
@@ -0,0 +1,74 @@
+package download
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	for _, rawURL := range []string{
+		"https://host/agent",
+		"s3://bucket/agent",
+		"gs://bucket/agent",
+		"azblob://account/container/agent",
+		"oci://registry/repo:tag",
+		"file:///opt/agent",
+		"/opt/agent",
+	} {
+		assert.True(t, Supported(rawURL), rawURL)
+		_, err := New(rawURL, Config{})
+		assert.NoError(t, err, rawURL)
+	}
+
+	assert.False(t, Supported("ftp://host/agent"))
+	_, err := New("ftp://host/agent", Config{})
+	assert.EqualError(t, err, `unsupported scheme "ftp"`)
+}
+
+func TestHTTPSource(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("agent"))
+	}))
+	defer ts.Close()
+
+	_, _, err := Open(context.Background(), ts.URL, Config{})
+	assert.Equal(t, &StatusError{StatusCode: http.StatusUnauthorized}, err)
+
+	body, size, err := Open(context.Background(), ts.URL, Config{Authorize: func(req *http.Request) {
+		req.SetBasicAuth("user", "pass")
+	}})
+	assert.NoError(t, err)
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(body)
+
+	data, _ := io.ReadAll(body)
+	assert.Equal(t, "agent", string(data))
+	assert.Equal(t, int64(5), size)
+}
+
+func TestFileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent")
+	assert.NoError(t, os.WriteFile(path, []byte("agent"), 0644))
+
+	for _, rawURL := range []string{path, "file://" + filepath.ToSlash(path)} {
+		body, size, err := Open(context.Background(), rawURL, Config{})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), size)
+		_ = body.Close()
+	}
+
+	_, _, err := Open(context.Background(), filepath.Dir(path), Config{})
+	assert.Error(t, err)
+}
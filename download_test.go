@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetupHTTPClient(t *testing.T) {
+	defer func(cert, key string, skip bool, client *http.Client) {
+		clientCert, clientKey, insecureSkipVerify, httpClient = cert, key, skip, client
+	}(clientCert, clientKey, insecureSkipVerify, httpClient)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	clientCert, clientKey = "cert.pem", ""
+	assert.Error(t, setupHTTPClient())
+
+	clientCert, clientKey = "missing.pem", "missing.key"
+	assert.Error(t, setupHTTPClient())
+
+	clientCert, clientKey = "", ""
+	assert.NoError(t, setupHTTPClient())
+	_, err := fetchBytes(server.URL)
+	assert.Error(t, err)
+
+	insecureSkipVerify = true
+	assert.NoError(t, setupHTTPClient())
+	data, err := fetchBytes(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(data))
+}
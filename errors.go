@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+
+	"distbuild/boong/bootstrap/download"
+)
+
+type errorCode string
+
+const (
+	errCodeUnknown      errorCode = "unknown"
+	errCodeUsage        errorCode = "usage"
+	errCodeNetwork      errorCode = "network"
+	errCodeAuth         errorCode = "auth"
+	errCodeDisk         errorCode = "disk"
+	errCodeGit          errorCode = "git"
+	errCodeVerification errorCode = "verification"
+	errCodePermission   errorCode = "permission"
+	errCodeTimeout      errorCode = "timeout"
+)
+
+var exitCodes = map[errorCode]int{
+	errCodeUnknown:      1,
+	errCodeUsage:        2,
+	errCodeNetwork:      3,
+	errCodeAuth:         4,
+	errCodeDisk:         5,
+	errCodeGit:          6,
+	errCodeVerification: 7,
+	errCodePermission:   8,
+	errCodeTimeout:      9,
+}
+
+// codedError tags an error with its category, the outermost code wins.
+type codedError struct {
+	code errorCode
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+func withCode(code errorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &codedError{code: code, err: err}
+}
+
+// hintedError carries a remediation hint shown below the error.
+type hintedError struct {
+	err  error
+	hint string
+}
+
+func (e *hintedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *hintedError) Unwrap() error {
+	return e.err
+}
+
+func withHint(err error, hint string) error {
+	if err == nil {
+		return nil
+	}
+
+	return &hintedError{err: err, hint: hint}
+}
+
+// hintOf returns the innermost hint of err.
+func hintOf(err error) string {
+	var hinted *hintedError
+	if errors.As(err, &hinted) {
+		return hinted.hint
+	}
+
+	return ""
+}
+
+type httpStatusError = download.StatusError
+
+func newStatusError(resp *http.Response) *httpStatusError {
+	return download.NewStatusError(resp)
+}
+
+// errorCodeOf returns the explicit code of err or classifies it by the
+// underlying error types.
+func errorCodeOf(err error) errorCode {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		if statusErr.StatusCode == http.StatusUnauthorized || statusErr.StatusCode == http.StatusForbidden {
+			return errCodeAuth
+		}
+		return errCodeNetwork
+	}
+
+	if errors.Is(err, download.ErrDigestMismatch) {
+		return errCodeVerification
+	}
+
+	if errors.Is(err, fs.ErrPermission) {
+		return errCodePermission
+	}
+
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) || errors.Is(err, syscall.EROFS) {
+		return errCodeDisk
+	}
+
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	var urlErr *url.Error
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) || errors.As(err, &urlErr) {
+		return errCodeNetwork
+	}
+
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return errCodeDisk
+	}
+
+	return errCodeUnknown
+}
+
+func exitCodeOf(err error) int {
+	return exitCodes[errorCodeOf(err)]
+}
+
+type runResult struct {
+	Status    string            `json:"status"`
+	Error     *resultError      `json:"error,omitempty"`
+	Downloads []downloadMetrics `json:"downloads,omitempty"`
+	Changed   *bool             `json:"changed,omitempty"`
+	Changes   []string          `json:"changes,omitempty"`
+}
+
+type resultError struct {
+	Code     errorCode `json:"code"`
+	Message  string    `json:"message"`
+	Hint     string    `json:"hint,omitempty"`
+	ExitCode int       `json:"exit_code"`
+}
+
+// exitWithError reports err in the selected output format and exits with
+// the code of its category.
+func exitWithError(err error) {
+	code := errorCodeOf(err)
+
+	if outputFormat == outputJSON {
+		writeResult(runResult{
+			Status: "error",
+			Error:  &resultError{Code: code, Message: err.Error(), Hint: hintOf(err), ExitCode: exitCodes[code]},
+		})
+	} else {
+		_, _ = fmt.Fprintln(os.Stderr, msg("error", "text", err.Error()))
+		if hint := hintOf(err); hint != "" {
+			_, _ = fmt.Fprintln(os.Stderr, hint)
+		}
+	}
+
+	os.Exit(exitCodes[code])
+}
+
+func writeResult(result runResult) {
+	result.Downloads = downloads.all()
+	data, _ := json.Marshal(result)
+	_, _ = fmt.Fprintln(jsonOutput, string(data))
+}
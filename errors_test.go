@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorCodeOf(t *testing.T) {
+	assert.Equal(t, errCodeUnknown, errorCodeOf(errors.New("boom")))
+	assert.Equal(t, errCodeGit, errorCodeOf(fmt.Errorf("git clone failed: %w", withCode(errCodeGit, errors.New("exit 128")))))
+	assert.Equal(t, errCodeAuth, errorCodeOf(fmt.Errorf("download failed with %w", &httpStatusError{StatusCode: 401})))
+	assert.Equal(t, errCodeNetwork, errorCodeOf(&httpStatusError{StatusCode: 503}))
+	assert.Equal(t, errCodeNetwork, errorCodeOf(&net.DNSError{Err: "no such host", Name: "host"}))
+	assert.Equal(t, errCodePermission, errorCodeOf(&os.PathError{Op: "open", Path: "/x", Err: os.ErrPermission}))
+	assert.Equal(t, errCodeDisk, errorCodeOf(&os.PathError{Op: "open", Path: "/x", Err: os.ErrNotExist}))
+	assert.Equal(t, 7, exitCodeOf(withCode(errCodeVerification, errors.New("mismatch"))))
+}
+
+func TestHintOf(t *testing.T) {
+	assert.Empty(t, hintOf(errors.New("boom")))
+
+	err := fmt.Errorf("clone failed: %w", withCode(errCodeAuth, withHint(errors.New("exit 128"), "hint: check the credentials")))
+	assert.Equal(t, "hint: check the credentials", hintOf(err))
+	assert.Equal(t, errCodeAuth, errorCodeOf(err))
+	assert.Equal(t, "clone failed: exit 128", err.Error())
+}
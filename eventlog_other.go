@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+)
+
+func openEventLog(_ string) (agentEventSink, error) {
+	return nil, errors.New("the event log is only available on windows")
+}
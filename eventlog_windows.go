@@ -0,0 +1,49 @@
+//go:build windows
+
+package main
+
+import (
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// Event ids of the lifecycle events, EventCreate sources accept 1 to 1000.
+const (
+	eventIDInfo    = 1
+	eventIDWarning = 2
+	eventIDError   = 3
+)
+
+type windowsEventLog struct {
+	log *eventlog.Log
+}
+
+// openEventLog registers source in the Application log and opens it.
+// Registering needs administrator rights once, an unregistered source still
+// logs, only without message formatting in the event viewer.
+func openEventLog(source string) (agentEventSink, error) {
+	if err := eventlog.InstallAsEventCreate(source, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		logVerbose("register event source %s failed: %v", source, err)
+	}
+
+	log, err := eventlog.Open(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &windowsEventLog{log: log}, nil
+}
+
+func (w *windowsEventLog) report(level agentEventLevel, msg string) error {
+	switch level {
+	case agentEventError:
+		return w.log.Error(eventIDError, msg)
+	case agentEventWarning:
+		return w.log.Warning(eventIDWarning, msg)
+	default:
+		return w.log.Info(eventIDInfo, msg)
+	}
+}
+
+func (w *windowsEventLog) Close() error {
+	return w.log.Close()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var openFirewall bool
+
+func addFirewallFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&openFirewall, "open-firewall", false, "open the agent port in firewalld, ufw or iptables when they filter it")
+}
+
+// firewallCommand runs a firewall query and returns its output, tests
+// replace it. Queries run through sudo -n so that they never prompt.
+var firewallCommand = func(args ...string) (string, error) {
+	if !isRoot() {
+		args = append([]string{"sudo", "-n"}, args...)
+	}
+
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return "", err
+	}
+
+	output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+
+	return string(output), err
+}
+
+// hostFirewall is one of the firewall frontends the agent port may be
+// filtered by.
+type hostFirewall struct {
+	name    string
+	active  func() bool
+	allowed func(port int) bool
+	open    func(port int) [][]string
+}
+
+var hostFirewalls = []hostFirewall{
+	{
+		name: "firewalld",
+		active: func() bool {
+			output, err := firewallCommand("firewall-cmd", "--state")
+			return err == nil && strings.TrimSpace(output) == "running"
+		},
+		allowed: func(port int) bool {
+			_, err := firewallCommand("firewall-cmd", "--query-port="+strconv.Itoa(port)+"/tcp")
+			return err == nil
+		},
+		open: func(port int) [][]string {
+			return [][]string{
+				{"firewall-cmd", "--permanent", "--add-port=" + strconv.Itoa(port) + "/tcp"},
+				{"firewall-cmd", "--reload"},
+			}
+		},
+	},
+	{
+		name: "ufw",
+		active: func() bool {
+			output, err := firewallCommand("ufw", "status")
+			return err == nil && strings.Contains(output, "Status: active")
+		},
+		allowed: func(port int) bool {
+			output, _ := firewallCommand("ufw", "status")
+			for _, line := range strings.Split(output, "\n") {
+				fields := strings.Fields(line)
+				if len(fields) >= 2 && (fields[0] == strconv.Itoa(port) || fields[0] == strconv.Itoa(port)+"/tcp") && fields[1] == "ALLOW" {
+					return true
+				}
+			}
+			return false
+		},
+		open: func(port int) [][]string {
+			return [][]string{{"ufw", "allow", strconv.Itoa(port) + "/tcp"}}
+		},
+	},
+	{
+		name: "iptables",
+		// Only a chain that drops by default or has drop rules filters ports
+		active: func() bool {
+			output, err := firewallCommand("iptables", "-S", "INPUT")
+			return err == nil && (strings.Contains(output, "-P INPUT DROP") ||
+				strings.Contains(output, "-j DROP") || strings.Contains(output, "-j REJECT"))
+		},
+		allowed: func(port int) bool {
+			_, err := firewallCommand("iptables", "-C", "INPUT", "-p", "tcp", "--dport", strconv.Itoa(port), "-j", "ACCEPT")
+			return err == nil
+		},
+		open: func(port int) [][]string {
+			return [][]string{{"iptables", "-I", "INPUT", "-p", "tcp", "--dport", strconv.Itoa(port), "-j", "ACCEPT"}}
+		},
+	},
+}
+
+// checkAgentFirewall looks for a local firewall filtering the agent port,
+// which leaves a healthy agent unreachable for the scheduler. The port is
+// opened with --open-firewall, otherwise the commands to do so are printed.
+// Problems are only warned about, the agent itself runs fine.
+func checkAgentFirewall(port int) {
+	for _, fw := range hostFirewalls {
+		if !fw.active() {
+			continue
+		}
+		if fw.allowed(port) {
+			logVerbose("%s allows agent port %d", fw.name, port)
+			return
+		}
+
+		commands := fw.open(port)
+
+		if !openFirewall {
+			lines := make([]string, 0, len(commands))
+			for _, command := range commands {
+				lines = append(lines, "  sudo "+strings.Join(command, " "))
+			}
+			warn("firewall.filtered", "firewall", fw.name, "port", port, "commands", strings.Join(lines, "\n"))
+			return
+		}
+
+		for _, command := range commands {
+			cmd := exec.Command("sudo", command...)
+			var output bytes.Buffer
+			cmd.Stdout = &output
+			cmd.Stderr = &output
+			if err := runPrivileged(cmd); err != nil {
+				warn("firewall.failed", "port", port, "firewall", fw.name, "err", err, "output", output.String())
+				return
+			}
+		}
+
+		noteChange("open " + fw.name + " port " + strconv.Itoa(port))
+		printMsg("firewall.opened", "port", port, "firewall", fw.name)
+		return
+	}
+}
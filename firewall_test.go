@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAgentFirewall(t *testing.T) {
+	defer func(command func(args ...string) (string, error), open bool) {
+		firewallCommand, openFirewall = command, open
+	}(firewallCommand, openFirewall)
+
+	var ufwRules string
+	firewallCommand = func(args ...string) (string, error) {
+		switch strings.Join(args, " ") {
+		case "ufw status":
+			return "Status: active\n\nTo                         Action      From\n--                         ------      ----\n22/tcp                     ALLOW       Anywhere\n" + ufwRules, nil
+		}
+		return "", errors.New("not found")
+	}
+	openFirewall = false
+
+	output := captureStdout(t, func() {
+		checkAgentFirewall(9527)
+	})
+	assert.Contains(t, output, "warning: ufw filters agent port 9527")
+	assert.Contains(t, output, "sudo ufw allow 9527/tcp")
+
+	ufwRules = "9527/tcp                   ALLOW       Anywhere\n"
+	output = captureStdout(t, func() {
+		checkAgentFirewall(9527)
+	})
+	assert.Empty(t, output)
+}
+
+func TestCheckAgentFirewallWithoutFirewall(t *testing.T) {
+	defer func(command func(args ...string) (string, error)) {
+		firewallCommand = command
+	}(firewallCommand)
+
+	firewallCommand = func(args ...string) (string, error) {
+		if args[0] == "iptables" && args[1] == "-S" {
+			return "-P INPUT ACCEPT\n", nil
+		}
+		return "", errors.New("not found")
+	}
+
+	assert.Empty(t, captureStdout(t, func() {
+		checkAgentFirewall(9527)
+	}))
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// fleetVerifyTimeout bounds a single --verify-url request.
+const fleetVerifyTimeout = time.Minute
+
+var (
+	fleetBatchSize   int
+	fleetMaxFailures int
+	fleetAgentBin    string
+	fleetRollbackTo  string
+	fleetHealthDelay time.Duration
+	fleetCanary      int
+	fleetVerifyCmd   string
+	fleetVerifyURL   string
+	// fleetFailed are the hosts that failed the last rollout
+	fleetFailed []string
+)
+
+var fleetCmd = &cobra.Command{
+	Use:   "fleet",
+	Short: "operate on every build host of an inventory over SSH",
+}
+
+var fleetUpgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "upgrade agents across the inventory in health-checked waves",
+	Long: "Upgrade the agents of the inventory hosts in batches. Each host runs\n" +
+		"'bootstrap agent upgrade', then every host of the batch must pass\n" +
+		"'bootstrap agent health' before the next batch starts. Once more than\n" +
+		"--max-failures hosts failed the rollout halts, with --rollback-to the hosts\n" +
+		"upgraded so far are moved back to that agent. With --canary the first hosts\n" +
+		"form a canary batch that must also pass --verify-command and --verify-url,\n" +
+		"a failing canary is moved back to its previous agent before any other\n" +
+		"host is touched.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := checkFleetFlags(); err != nil {
+			exitWithError(withCode(errCodeUsage, err))
+		}
+		if err := loadEnvFiles(envFile, envFiles); err != nil {
+			exitWithError(fmt.Errorf("load .env failed: %w", err))
+		}
+		if err := setupHTTPClient(); err != nil {
+			exitWithError(fmt.Errorf("setup http client failed: %w", err))
+		}
+		inv, err := loadInventory(inventoryPath)
+		if err != nil {
+			exitWithError(err)
+		}
+		hosts, err := filterHosts(inv.Hosts, inventoryLimit)
+		if err != nil {
+			exitWithError(err)
+		}
+		stopHealth, err := serveHealth("fleet")
+		if err != nil {
+			exitWithError(err)
+		}
+		defer stopHealth()
+		ctx, cancel := runContext()
+		defer cancel()
+		start := time.Now()
+		err = upgradeFleet(ctx, hosts)
+		if err != nil {
+			err = timeoutError(ctx, err)
+		}
+		n := newNotification("fleet upgrade", start, err)
+		for _, h := range hosts {
+			n.Hosts = append(n.Hosts, h.Name)
+		}
+		n.Failed = fleetFailed
+		sendNotification(n)
+		if err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	addInventoryFlags(fleetCmd)
+
+	fleetUpgradeCmd.Flags().IntVar(&fleetBatchSize, "batch-size", 1, "hosts upgraded at the same time")
+	fleetUpgradeCmd.Flags().IntVar(&fleetMaxFailures, "max-failures", 0, "failed hosts tolerated before the rollout halts")
+	fleetUpgradeCmd.Flags().StringVar(&fleetAgentBin, "agent-bin", "", "agent artifact to upgrade hosts without an inventory pin to (default AGENT_BIN of each host)")
+	fleetUpgradeCmd.Flags().StringVar(&fleetRollbackTo, "rollback-to", "", "agent artifact to move upgraded hosts back to when the rollout halts")
+	fleetUpgradeCmd.Flags().DurationVar(&fleetHealthDelay, "health-delay", 0, "wait this long after a batch before the health check")
+	fleetUpgradeCmd.Flags().IntVar(&fleetCanary, "canary", 0, "upgrade and verify this many hosts first, roll them back to their previous agent if any fails")
+	fleetUpgradeCmd.Flags().StringVar(&fleetVerifyCmd, "verify-command", "", "shell command run on each upgraded host after the health check, e.g. a sample remote compile")
+	fleetUpgradeCmd.Flags().StringVar(&fleetVerifyURL, "verify-url", "", "url that must answer 2xx for each upgraded host, {host} is replaced by its address")
+	addHealthFlag(fleetUpgradeCmd)
+	addTimeoutFlags(fleetUpgradeCmd)
+
+	fleetCmd.AddCommand(fleetUpgradeCmd)
+}
+
+func checkFleetFlags() error {
+	if fleetBatchSize < 1 {
+		return fmt.Errorf("--batch-size must be at least 1")
+	}
+
+	if fleetMaxFailures < 0 {
+		return fmt.Errorf("--max-failures must not be negative")
+	}
+
+	if fleetCanary < 0 {
+		return fmt.Errorf("--canary must not be negative")
+	}
+
+	if err := checkWebhookFlags(); err != nil {
+		return err
+	}
+
+	if err := checkHealthFlags(); err != nil {
+		return err
+	}
+
+	return checkTimeout()
+}
+
+// fleetAgentCommand builds the bootstrap invocation for a host, agentBin
+// overrides the AGENT_BIN of its env files. The override goes right before
+// the executable so that a sudo prefix does not drop it.
+func fleetAgentCommand(h inventoryHost, agentBin string, args ...string) []string {
+	fields := strings.Fields(h.Bootstrap)
+
+	command := append([]string(nil), fields[:len(fields)-1]...)
+	if agentBin != "" {
+		command = append(command, "env", "AGENT_BIN="+agentBin)
+	}
+	command = append(command, fields[len(fields)-1])
+	command = append(command, args...)
+	if h.DistbuildPath != "" {
+		command = append(command, "--distbuild-path", h.DistbuildPath)
+	}
+
+	return command
+}
+
+// upgradeFleetHost and checkFleetHost run the agent subcommands on a host,
+// tests replace them.
+var (
+	upgradeFleetHost = func(ctx context.Context, h inventoryHost, agentBin string) error {
+		output, err := runRemote(ctx, h, fleetAgentCommand(h, agentBin, "agent", "upgrade")...)
+		if err != nil {
+			return fmt.Errorf("%w\n%s", err, strings.TrimSpace(output))
+		}
+		return nil
+	}
+	checkFleetHost = func(ctx context.Context, h inventoryHost) error {
+		output, err := runRemote(ctx, h, fleetAgentCommand(h, "", "agent", "health")...)
+		if err != nil {
+			return fmt.Errorf("%w\n%s", err, strings.TrimSpace(output))
+		}
+		return nil
+	}
+	previousFleetHost = func(ctx context.Context, h inventoryHost) error {
+		output, err := runRemote(ctx, h, fleetAgentCommand(h, "", "agent", "upgrade", "--previous")...)
+		if err != nil {
+			return fmt.Errorf("%w\n%s", err, strings.TrimSpace(output))
+		}
+		return nil
+	}
+	verifyFleetHost = verifyHost
+)
+
+type fleetResult struct {
+	host inventoryHost
+	// upgraded is also set for hosts that failed the health check, they
+	// run the new agent and are rolled back too.
+	upgraded bool
+	err      error
+}
+
+func upgradeFleet(ctx context.Context, hosts []inventoryHost) error {
+	if len(hosts) == 0 {
+		return withCode(errCodeUsage, fmt.Errorf("inventory has no hosts"))
+	}
+
+	var upgraded []inventoryHost
+	healthy, failures := 0, 0
+	fleetFailed = nil
+	plan := fleetBatches(hosts)
+	batches := len(plan)
+
+	for _, h := range hosts {
+		if h.AgentBin != "" {
+			printMsg("fleet.pinned", "host", h.Name, "agent", redactURL(h.AgentBin))
+		}
+	}
+
+	for i, batch := range plan {
+		canary := i == 0 && fleetCanary > 0
+
+		names := make([]string, 0, len(batch))
+		for _, h := range batch {
+			names = append(names, h.Name)
+		}
+		msgID := "fleet.batch"
+		if canary {
+			msgID = "fleet.canary"
+		}
+		printMsg(msgID, "batch", i+1, "batches", batches, "hosts", strings.Join(names, ", "))
+		setHealth("upgrading", true, fleetHealth(i+1, batches, healthy, failures, len(hosts)))
+
+		results := upgradeFleetBatch(ctx, batch)
+		if canary {
+			if err := checkCanary(ctx, results); err != nil {
+				setHealth("halted", false, fleetHealth(i+1, batches, 0, len(fleetFailed), len(hosts)))
+				return err
+			}
+		}
+
+		for _, result := range results {
+			if result.upgraded {
+				upgraded = append(upgraded, result.host)
+			}
+			if result.err != nil {
+				failures++
+				fleetFailed = append(fleetFailed, result.host.Name)
+				fmt.Printf("✗ %s: %v\n", result.host.Name, result.err)
+				continue
+			}
+			healthy++
+			fmt.Printf("✓ %s\n", result.host.Name)
+		}
+
+		if failures > fleetMaxFailures {
+			setHealth("halted", false, fleetHealth(i+1, batches, healthy, failures, len(hosts)))
+			err := fmt.Errorf("rollout halted after %d failed host(s), %d of %d upgraded", failures, healthy, len(hosts))
+			if fleetRollbackTo != "" {
+				if rbErr := rollbackFleet(ctx, upgraded); rbErr != nil {
+					return fmt.Errorf("%w, rollback failed: %v", err, rbErr)
+				}
+				return fmt.Errorf("%w, rolled back to %s", err, fleetRollbackTo)
+			}
+			return err
+		}
+	}
+
+	printMsg("fleet.summary", "healthy", healthy, "total", len(hosts), "failed", failures)
+	setHealth("done", true, fleetHealth(batches, batches, healthy, failures, len(hosts)))
+
+	return nil
+}
+
+func fleetHealth(batch, batches, healthy, failures, total int) map[string]any {
+	return map[string]any{"batch": batch, "batches": batches, "healthy": healthy, "failed": failures, "hosts": total}
+}
+
+// fleetHostAgent is the agent a host is upgraded to, inventory pins win
+// over --agent-bin.
+func fleetHostAgent(h inventoryHost) string {
+	if h.AgentBin != "" {
+		return h.AgentBin
+	}
+
+	return fleetAgentBin
+}
+
+// upgradeFleetBatch upgrades the hosts of a batch in parallel and health
+// checks those that upgraded.
+func upgradeFleetBatch(ctx context.Context, batch []inventoryHost) []fleetResult {
+	results := make([]fleetResult, len(batch))
+
+	var wg sync.WaitGroup
+
+	for i, h := range batch {
+		wg.Add(1)
+		go func(i int, h inventoryHost) {
+			defer wg.Done()
+			results[i] = fleetResult{host: h}
+			if err := upgradeFleetHost(ctx, h, fleetHostAgent(h)); err != nil {
+				results[i].err = fmt.Errorf("upgrade failed: %w", err)
+				return
+			}
+			results[i].upgraded = true
+		}(i, h)
+	}
+
+	wg.Wait()
+
+	if fleetHealthDelay > 0 {
+		select {
+		case <-time.After(fleetHealthDelay):
+		case <-ctx.Done():
+		}
+	}
+
+	for i := range results {
+		if results[i].err != nil {
+			continue
+		}
+		if err := checkFleetHost(ctx, results[i].host); err != nil {
+			results[i].err = fmt.Errorf("health check failed: %w", err)
+			continue
+		}
+		if err := verifyFleetHost(ctx, results[i].host); err != nil {
+			results[i].err = fmt.Errorf("verification failed: %w", err)
+		}
+	}
+
+	return results
+}
+
+// fleetBatches splits the hosts into the batches of the rollout, the first
+// --canary hosts go first on their own.
+func fleetBatches(hosts []inventoryHost) [][]inventoryHost {
+	var batches [][]inventoryHost
+
+	rest := hosts
+	if fleetCanary > 0 {
+		n := min(fleetCanary, len(hosts))
+		batches = append(batches, hosts[:n])
+		rest = hosts[n:]
+	}
+
+	for i := 0; i < len(rest); i += fleetBatchSize {
+		batches = append(batches, rest[i:min(i+fleetBatchSize, len(rest))])
+	}
+
+	return batches
+}
+
+// checkCanary halts the rollout when a canary failed, whatever
+// --max-failures tolerates, and moves the upgraded canaries back to the
+// agent they ran before.
+func checkCanary(ctx context.Context, results []fleetResult) error {
+	var upgraded []inventoryHost
+	for _, result := range results {
+		if result.err != nil {
+			fleetFailed = append(fleetFailed, result.host.Name)
+			fmt.Printf("✗ %s: %v\n", result.host.Name, result.err)
+		}
+		if result.upgraded {
+			upgraded = append(upgraded, result.host)
+		}
+	}
+	if len(fleetFailed) == 0 {
+		return nil
+	}
+
+	err := fmt.Errorf("canary failed on %s, no other host was upgraded", strings.Join(fleetFailed, ", "))
+
+	var notRolledBack []string
+	for _, h := range upgraded {
+		if rbErr := previousFleetHost(ctx, h); rbErr != nil {
+			printMsg("fleet.rollback_failed", "host", h.Name, "err", rbErr)
+			notRolledBack = append(notRolledBack, h.Name)
+			continue
+		}
+		printMsg("fleet.rollback", "host", h.Name)
+	}
+	if len(notRolledBack) > 0 {
+		return fmt.Errorf("%w, %d canary host(s) not rolled back: %s", err, len(notRolledBack), strings.Join(notRolledBack, ", "))
+	}
+
+	return fmt.Errorf("%w, canaries rolled back to their previous agent", err)
+}
+
+// verifyHost runs the post-upgrade verification of a host, its own
+// verify_command and verify_url win over the flags.
+func verifyHost(ctx context.Context, h inventoryHost) error {
+	command := h.VerifyCommand
+	if command == "" {
+		command = fleetVerifyCmd
+	}
+	if command != "" {
+		cmd := remoteCommand(ctx, h, command)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s: %w\n%s", command, remoteError(h, err), strings.TrimSpace(string(output)))
+		}
+	}
+
+	verifyURL := h.VerifyURL
+	if verifyURL == "" {
+		verifyURL = fleetVerifyURL
+	}
+	if verifyURL != "" {
+		verifyURL = strings.ReplaceAll(verifyURL, "{host}", h.Address)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, verifyURL, nil)
+		if err != nil {
+			return withCode(errCodeUsage, fmt.Errorf("invalid verify url: %w", err))
+		}
+		client := &http.Client{Transport: httpClient.Transport, Timeout: fleetVerifyTimeout, CheckRedirect: checkRedirect}
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("%s: %w", redactURL(verifyURL), err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("%s: %w", redactURL(verifyURL), newStatusError(resp))
+		}
+	}
+
+	return nil
+}
+
+// rollbackFleet moves every upgraded host back to --rollback-to, all hosts
+// are attempted before the errors are reported.
+func rollbackFleet(ctx context.Context, hosts []inventoryHost) error {
+	var failed []string
+
+	for _, h := range hosts {
+		if err := upgradeFleetHost(ctx, h, fleetRollbackTo); err != nil {
+			printMsg("fleet.rollback_failed", "host", h.Name, "err", err)
+			failed = append(failed, h.Name)
+			continue
+		}
+		printMsg("fleet.rollback", "host", h.Name)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d host(s) not rolled back: %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fakeFleet(t *testing.T, broken map[string]bool) *[]string {
+	t.Helper()
+
+	upgrade, check := upgradeFleetHost, checkFleetHost
+	t.Cleanup(func() {
+		upgradeFleetHost, checkFleetHost = upgrade, check
+	})
+
+	var mu sync.Mutex
+	var calls []string
+
+	upgradeFleetHost = func(ctx context.Context, h inventoryHost, agentBin string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, h.Name+"="+agentBin)
+		return nil
+	}
+	checkFleetHost = func(ctx context.Context, h inventoryHost) error {
+		if broken[h.Name] {
+			return errors.New("unhealthy")
+		}
+		return nil
+	}
+
+	return &calls
+}
+
+func fleetHosts(names ...string) []inventoryHost {
+	hosts := make([]inventoryHost, 0, len(names))
+	for _, name := range names {
+		hosts = append(hosts, inventoryHost{Name: name, Address: name})
+	}
+
+	return hosts
+}
+
+func TestUpgradeFleet(t *testing.T) {
+	defer func(size, failures int, agentBin, rollback string) {
+		fleetBatchSize, fleetMaxFailures, fleetAgentBin, fleetRollbackTo = size, failures, agentBin, rollback
+	}(fleetBatchSize, fleetMaxFailures, fleetAgentBin, fleetRollbackTo)
+
+	fleetBatchSize, fleetMaxFailures, fleetAgentBin, fleetRollbackTo = 2, 0, "v2", ""
+
+	calls := fakeFleet(t, nil)
+	captureStdout(t, func() {
+		assert.NoError(t, upgradeFleet(context.Background(), fleetHosts("a", "b", "c")))
+	})
+	assert.ElementsMatch(t, []string{"a=v2", "b=v2", "c=v2"}, *calls)
+
+	// A failing host in the second batch stops the rollout before the third
+	calls = fakeFleet(t, map[string]bool{"c": true})
+	output := captureStdout(t, func() {
+		err := upgradeFleet(context.Background(), fleetHosts("a", "b", "c", "d", "e", "f"))
+		assert.ErrorContains(t, err, "rollout halted after 1 failed host(s), 3 of 6 upgraded")
+	})
+	assert.ElementsMatch(t, []string{"a=v2", "b=v2", "c=v2", "d=v2"}, *calls)
+	assert.Contains(t, output, "✗ c: health check failed")
+
+	// Tolerated failures keep going
+	fleetMaxFailures = 1
+	calls = fakeFleet(t, map[string]bool{"c": true})
+	captureStdout(t, func() {
+		assert.NoError(t, upgradeFleet(context.Background(), fleetHosts("a", "b", "c", "d")))
+	})
+	assert.Len(t, *calls, 4)
+	assert.Equal(t, []string{"c"}, fleetFailed)
+
+	// Rollback covers the unhealthy hosts too
+	fleetMaxFailures, fleetRollbackTo = 0, "v1"
+	calls = fakeFleet(t, map[string]bool{"b": true})
+	captureStdout(t, func() {
+		err := upgradeFleet(context.Background(), fleetHosts("a", "b", "c"))
+		assert.ErrorContains(t, err, "rolled back to v1")
+	})
+	assert.ElementsMatch(t, []string{"a=v2", "b=v2", "a=v1", "b=v1"}, *calls)
+}
+
+func TestUpgradeFleetPinned(t *testing.T) {
+	defer func(size int, agentBin string) {
+		fleetBatchSize, fleetAgentBin = size, agentBin
+	}(fleetBatchSize, fleetAgentBin)
+
+	fleetBatchSize, fleetAgentBin = 3, "v2"
+
+	hosts := fleetHosts("canary", "a", "b")
+	hosts[0].AgentBin = "v3"
+
+	calls := fakeFleet(t, nil)
+	output := captureStdout(t, func() {
+		assert.NoError(t, upgradeFleet(context.Background(), hosts))
+	})
+	assert.ElementsMatch(t, []string{"canary=v3", "a=v2", "b=v2"}, *calls)
+	assert.Contains(t, output, "canary is pinned to agent v3")
+}
+
+func TestFleetBatches(t *testing.T) {
+	defer func(size, canary int) {
+		fleetBatchSize, fleetCanary = size, canary
+	}(fleetBatchSize, fleetCanary)
+
+	fleetBatchSize, fleetCanary = 2, 1
+	batches := fleetBatches(fleetHosts("a", "b", "c", "d"))
+	assert.Equal(t, [][]inventoryHost{fleetHosts("a"), fleetHosts("b", "c"), fleetHosts("d")}, batches)
+
+	fleetCanary = 5
+	assert.Equal(t, [][]inventoryHost{fleetHosts("a", "b")}, fleetBatches(fleetHosts("a", "b")))
+}
+
+func TestUpgradeFleetCanary(t *testing.T) {
+	defer func(size, failures, canary int, agentBin string) {
+		fleetBatchSize, fleetMaxFailures, fleetCanary, fleetAgentBin = size, failures, canary, agentBin
+	}(fleetBatchSize, fleetMaxFailures, fleetCanary, fleetAgentBin)
+	previous, verify := previousFleetHost, verifyFleetHost
+	defer func() {
+		previousFleetHost, verifyFleetHost = previous, verify
+	}()
+
+	// Failures are tolerated, but not on canaries
+	fleetBatchSize, fleetMaxFailures, fleetCanary, fleetAgentBin = 2, 3, 2, "v2"
+
+	calls := fakeFleet(t, nil)
+	var rolledBack []string
+	previousFleetHost = func(ctx context.Context, h inventoryHost) error {
+		rolledBack = append(rolledBack, h.Name)
+		return nil
+	}
+	verifyFleetHost = func(ctx context.Context, h inventoryHost) error {
+		if h.Name == "b" {
+			return errors.New("sample compile failed")
+		}
+		return nil
+	}
+
+	output := captureStdout(t, func() {
+		err := upgradeFleet(context.Background(), fleetHosts("a", "b", "c", "d"))
+		assert.ErrorContains(t, err, "canary failed on b, no other host was upgraded, canaries rolled back")
+	})
+	assert.ElementsMatch(t, []string{"a=v2", "b=v2"}, *calls)
+	assert.ElementsMatch(t, []string{"a", "b"}, rolledBack)
+	assert.Equal(t, []string{"b"}, fleetFailed)
+	assert.Contains(t, output, "canary batch 1/2: a, b")
+	assert.Contains(t, output, "✗ b: verification failed: sample compile failed")
+
+	// Passing canaries let the rollout go on
+	verifyFleetHost = func(ctx context.Context, h inventoryHost) error {
+		return nil
+	}
+	calls, rolledBack = fakeFleet(t, nil), nil
+	captureStdout(t, func() {
+		assert.NoError(t, upgradeFleet(context.Background(), fleetHosts("a", "b", "c", "d")))
+	})
+	assert.Len(t, *calls, 4)
+	assert.Empty(t, rolledBack)
+}
+
+func TestVerifyHost(t *testing.T) {
+	defer func(command, url string) {
+		fleetVerifyCmd, fleetVerifyURL = command, url
+	}(fleetVerifyCmd, fleetVerifyURL)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("host") != "build-01" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	fleetVerifyCmd, fleetVerifyURL = "", server.URL+"/check?host={host}"
+	assert.NoError(t, verifyHost(context.Background(), inventoryHost{Name: "a", Address: "build-01"}))
+
+	err := verifyHost(context.Background(), inventoryHost{Name: "b", Address: "build-02"})
+	assert.ErrorContains(t, err, "502")
+
+	// The host's own url wins
+	h := inventoryHost{Name: "b", Address: "build-02", VerifyURL: server.URL + "/check?host=build-01"}
+	assert.NoError(t, verifyHost(context.Background(), h))
+
+	fakeSSH(t, "Linux x86_64")
+	fleetVerifyCmd, fleetVerifyURL = "echo compiling; exit 3", ""
+	err = verifyHost(context.Background(), inventoryHost{Name: "a", Address: "build-01"})
+	assert.ErrorContains(t, err, "compiling")
+}
+
+func TestFleetAgentCommand(t *testing.T) {
+	h := inventoryHost{Bootstrap: "sudo /usr/local/bin/bootstrap", DistbuildPath: "/opt/distbuild"}
+
+	assert.Equal(t,
+		[]string{"sudo", "env", "AGENT_BIN=https://example.com/agent", "/usr/local/bin/bootstrap", "agent", "upgrade", "--distbuild-path", "/opt/distbuild"},
+		fleetAgentCommand(h, "https://example.com/agent", "agent", "upgrade"))
+	assert.Equal(t,
+		[]string{"sudo", "/usr/local/bin/bootstrap", "agent", "health", "--distbuild-path", "/opt/distbuild"},
+		fleetAgentCommand(h, "", "agent", "health"))
+	assert.Equal(t,
+		[]string{"env", "AGENT_BIN=v2", "bootstrap", "agent", "upgrade"},
+		fleetAgentCommand(inventoryHost{Bootstrap: defaultRemoteCommand}, "v2", "agent", "upgrade"))
+}
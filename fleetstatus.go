@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputCSV = "csv"
+
+	// fleetStatusParallel bounds the hosts queried at the same time
+	fleetStatusParallel = 16
+)
+
+var fleetStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "collect agent version, uptime, heartbeat, free disk and toolchains of every host",
+	Long: "Run 'bootstrap agent status --output json' on the inventory hosts and print\n" +
+		"the results as a table, or with --output json or csv for a CMDB or capacity\n" +
+		"dashboard. Unreachable hosts are listed with their error.",
+	Run: func(cmd *cobra.Command, args []string) {
+		switch outputFormat {
+		case outputText, outputJSON, outputCSV:
+		default:
+			exitWithError(withCode(errCodeUsage, fmt.Errorf("unsupported output format %q, use text, json or csv", outputFormat)))
+		}
+		if err := checkTimeout(); err != nil {
+			exitWithError(withCode(errCodeUsage, err))
+		}
+		inv, err := loadInventory(inventoryPath)
+		if err != nil {
+			exitWithError(err)
+		}
+		hosts, err := filterHosts(inv.Hosts, inventoryLimit)
+		if err != nil {
+			exitWithError(err)
+		}
+		ctx, cancel := runContext()
+		defer cancel()
+		if err := writeFleetStatus(os.Stdout, collectFleetStatus(ctx, hosts)); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	addTimeoutFlags(fleetStatusCmd)
+
+	fleetCmd.AddCommand(fleetStatusCmd)
+}
+
+// fleetHostStatus is a row of `fleet status`.
+type fleetHostStatus struct {
+	Host    string   `json:"host"`
+	Address string   `json:"address"`
+	Groups  []string `json:"groups,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	*hostStatus
+}
+
+// hostStatusOf asks a host for its status, tests replace it.
+var hostStatusOf = func(ctx context.Context, h inventoryHost) (*hostStatus, error) {
+	output, err := runRemote(ctx, h, fleetAgentCommand(h, "", "agent", "status", "--output", "json")...)
+	if err != nil {
+		return nil, fmt.Errorf("%w\n%s", err, strings.TrimSpace(output))
+	}
+
+	return parseHostStatus(output)
+}
+
+// parseHostStatus takes the status from the last JSON line, ssh merges the
+// warnings bootstrap prints into the same output.
+func parseHostStatus(output string) (*hostStatus, error) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		status := &hostStatus{}
+		if err := json.Unmarshal([]byte(line), status); err != nil {
+			return nil, fmt.Errorf("parse host status failed: %w", err)
+		}
+		return status, nil
+	}
+
+	return nil, fmt.Errorf("no status in output: %s", strings.TrimSpace(output))
+}
+
+func collectFleetStatus(ctx context.Context, hosts []inventoryHost) []fleetHostStatus {
+	rows := make([]fleetHostStatus, len(hosts))
+	sem := make(chan struct{}, fleetStatusParallel)
+
+	var wg sync.WaitGroup
+
+	for i, h := range hosts {
+		wg.Add(1)
+		go func(i int, h inventoryHost) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() {
+				<-sem
+			}()
+
+			rows[i] = fleetHostStatus{Host: h.Name, Address: h.Address, Groups: h.Groups}
+			status, err := hostStatusOf(ctx, h)
+			if err != nil {
+				rows[i].Error = err.Error()
+				return
+			}
+			rows[i].hostStatus = status
+		}(i, h)
+	}
+
+	wg.Wait()
+
+	return rows
+}
+
+func writeFleetStatus(w io.Writer, rows []fleetHostStatus) error {
+	switch outputFormat {
+	case outputJSON:
+		data, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case outputCSV:
+		return writeFleetStatusCSV(w, rows)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "HOST\tAGENT\tUPTIME\tLAST HEARTBEAT\tDISK FREE\tTOOLCHAINS")
+	for _, row := range rows {
+		if row.hostStatus == nil {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t\t\t\t\n", row.Host, "unreachable: "+strings.SplitN(row.Error, "\n", 2)[0])
+			continue
+		}
+		agent := "down"
+		if row.Running {
+			agent = row.AgentVersion
+			if agent == "" {
+				agent = "unknown"
+			}
+			agent += " (pid " + strconv.Itoa(row.PID) + ")"
+			if !row.Healthy {
+				agent += " unhealthy"
+			}
+		}
+		uptime := ""
+		if row.Running {
+			uptime = formatElapsed(time.Duration(row.UptimeSeconds) * time.Second)
+		}
+		heartbeat := ""
+		if row.LastHeartbeat != nil {
+			heartbeat = row.LastHeartbeat.Local().Format(time.DateTime)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", row.Host, agent, uptime, heartbeat,
+			formatBytes(int64(row.DiskFreeBytes)), formatToolchains(row.Toolchains))
+	}
+
+	return tw.Flush()
+}
+
+// writeFleetStatusCSV writes a column per toolchain any host has, so that
+// every row has the same columns.
+func writeFleetStatusCSV(w io.Writer, rows []fleetHostStatus) error {
+	toolchains := map[string]bool{}
+	for _, row := range rows {
+		if row.hostStatus != nil {
+			for name := range row.Toolchains {
+				toolchains[name] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(toolchains))
+	for name := range toolchains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	header := []string{"host", "address", "groups", "error", "bootstrap", "running", "healthy", "pid", "port",
+		"agent_version", "started_at", "uptime_seconds", "last_heartbeat", "disk_free_bytes"}
+	for _, name := range names {
+		header = append(header, "toolchain:"+name)
+	}
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	_ = cw.Write(header)
+
+	for _, row := range rows {
+		record := []string{row.Host, row.Address, strings.Join(row.Groups, " "), row.Error}
+		if row.hostStatus == nil {
+			record = append(record, make([]string, len(header)-len(record))...)
+			_ = cw.Write(record)
+			continue
+		}
+		record = append(record, row.Bootstrap, strconv.FormatBool(row.Running), strconv.FormatBool(row.Healthy),
+			formatOptionalInt(int64(row.PID)), formatOptionalInt(int64(row.Port)), row.AgentVersion,
+			formatOptionalTime(row.StartedAt), formatOptionalInt(row.UptimeSeconds), formatOptionalTime(row.LastHeartbeat),
+			strconv.FormatUint(row.DiskFreeBytes, 10))
+		for _, name := range names {
+			record = append(record, row.Toolchains[name])
+		}
+		_ = cw.Write(record)
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	_, err := w.Write(buf.Bytes())
+
+	return err
+}
+
+func formatToolchains(toolchains map[string]string) string {
+	parts := make([]string, 0, len(toolchains))
+	for name, revision := range toolchains {
+		parts = append(parts, name+"@"+shortHash(revision))
+	}
+	sort.Strings(parts)
+
+	return strings.Join(parts, " ")
+}
+
+func formatOptionalInt(n int64) string {
+	if n == 0 {
+		return ""
+	}
+
+	return strconv.FormatInt(n, 10)
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHostStatus(t *testing.T) {
+	status, err := parseHostStatus("⚠ stale agent pidfile\n{\"bootstrap\":\"b-c\",\"running\":true,\"pid\":7}\n")
+	assert.NoError(t, err)
+	assert.Equal(t, 7, status.PID)
+
+	_, err = parseHostStatus("bootstrap: command not found\n")
+	assert.ErrorContains(t, err, "no status in output")
+}
+
+func TestFleetStatus(t *testing.T) {
+	defer func(format string) {
+		outputFormat = format
+	}(outputFormat)
+
+	statusOf := hostStatusOf
+	defer func() {
+		hostStatusOf = statusOf
+	}()
+
+	heartbeat := time.Date(2026, 10, 15, 8, 0, 0, 0, time.UTC)
+	hostStatusOf = func(ctx context.Context, h inventoryHost) (*hostStatus, error) {
+		switch h.Name {
+		case "a":
+			return &hostStatus{Running: true, Healthy: true, PID: 10, AgentVersion: "v2", UptimeSeconds: 3600,
+				LastHeartbeat: &heartbeat, DiskFreeBytes: 1 << 30, Toolchains: map[string]string{"clang": "abc"}}, nil
+		case "b":
+			return &hostStatus{DiskFreeBytes: 1 << 20, Toolchains: map[string]string{"rust": "def"}}, nil
+		}
+		return nil, errors.New("ssh to c failed")
+	}
+
+	rows := collectFleetStatus(context.Background(), fleetHosts("a", "b", "c"))
+
+	var buf bytes.Buffer
+	outputFormat = outputCSV
+	assert.NoError(t, writeFleetStatus(&buf, rows))
+	records, err := csv.NewReader(&buf).ReadAll()
+	assert.NoError(t, err)
+	if assert.Len(t, records, 4) {
+		header := records[0]
+		assert.Equal(t, []string{"toolchain:clang", "toolchain:rust"}, header[len(header)-2:])
+		for _, record := range records {
+			assert.Len(t, record, len(header))
+		}
+		assert.Equal(t, "true", records[1][5])
+		assert.Equal(t, "v2", records[1][9])
+		assert.Equal(t, "2026-10-15T08:00:00Z", records[1][12])
+		assert.Equal(t, "abc", records[1][14])
+		assert.Equal(t, "def", records[2][15])
+		assert.Equal(t, "ssh to c failed", records[3][3])
+	}
+
+	buf.Reset()
+	outputFormat = outputJSON
+	assert.NoError(t, writeFleetStatus(&buf, rows))
+	var parsed []map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &parsed))
+	assert.Equal(t, "a", parsed[0]["host"])
+	assert.Equal(t, float64(3600), parsed[0]["uptime_seconds"])
+	assert.Equal(t, "ssh to c failed", parsed[2]["error"])
+
+	buf.Reset()
+	outputFormat = outputText
+	assert.NoError(t, writeFleetStatus(&buf, rows))
+	assert.Contains(t, buf.String(), "v2 (pid 10)")
+	assert.Contains(t, buf.String(), "clang@abc")
+	assert.Contains(t, buf.String(), "unreachable: ssh to c failed")
+}
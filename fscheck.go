@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// minFreeInodes is what a distbuild tree with its caches needs at least,
+// running out of inodes looks like a full disk with space left.
+const minFreeInodes = 100000
+
+var (
+	skipFSCheck    bool
+	allowNetworkFS bool
+)
+
+// filesystemInfo is what statFilesystem could learn about the filesystem
+// holding a path, Known is false where it is not supported.
+type filesystemInfo struct {
+	Known       bool
+	Type        string
+	Network     bool
+	NoExec      bool
+	ReadOnly    bool
+	TotalInodes uint64
+	FreeInodes  uint64
+	FreeBytes   uint64
+}
+
+// filesystemStat is swapped by tests.
+var filesystemStat = statFilesystem
+
+func addFilesystemFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&skipFSCheck, "skip-fs-check", false, "skip checking the filesystems of the distbuild and agent work directories")
+	cmd.Flags().BoolVar(&allowNetworkFS, "allow-network-fs", false, "allow the distbuild and agent work directories on network filesystems")
+}
+
+// workDirs lists the directories the agent executes from and works in,
+// AGENT_WORK_DIR is optional.
+func workDirs() []string {
+	dirs := []string{distbuildPath}
+
+	if dir := os.Getenv("AGENT_WORK_DIR"); dir != "" {
+		if expanded, err := expandPath(dir); err == nil {
+			dirs = append(dirs, expanded)
+		}
+	}
+
+	return dirs
+}
+
+// checkWorkFilesystems fails early when a work directory sits on a
+// filesystem distbuild cannot run on, instead of the agent failing in odd
+// ways later.
+func checkWorkFilesystems() error {
+	var errs []error
+
+	for _, dir := range workDirs() {
+		if err := createDirs(dir); err != nil {
+			return fmt.Errorf("create %s failed: %w", dir, err)
+		}
+		if err := checkFilesystem(dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return withCode(errCodeDisk, errors.Join(errs...))
+	}
+
+	return nil
+}
+
+func checkFilesystem(dir string) error {
+	var problems []string
+
+	info := filesystemStat(dir)
+	if info.Known {
+		if info.ReadOnly {
+			problems = append(problems, "it is mounted read-only")
+		}
+		if info.NoExec {
+			problems = append(problems, "it is mounted noexec, the agent and toolchains cannot run from it")
+		}
+		if info.Network && !allowNetworkFS {
+			problems = append(problems, fmt.Sprintf("it is a network filesystem (%s), pass --allow-network-fs to use it anyway", info.Type))
+		}
+		// Filesystems without a fixed inode table report none
+		if info.TotalInodes > 0 && info.FreeInodes < minFreeInodes {
+			problems = append(problems, fmt.Sprintf("only %d free inodes are left, %d are needed", info.FreeInodes, minFreeInodes))
+		}
+	}
+
+	if !info.ReadOnly {
+		if err := checkHardlinks(dir); err != nil {
+			problems = append(problems, fmt.Sprintf("it does not support hardlinks: %v", err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s is unusable: %s", dir, strings.Join(problems, ", "))
+	}
+
+	logVerbose("filesystem of %s: %s, %d free inodes", dir, info.Type, info.FreeInodes)
+
+	return nil
+}
+
+// checkHardlinks links a probe file, compiler caches and toolchain stores
+// deduplicate with hardlinks.
+func checkHardlinks(dir string) error {
+	probe, err := os.CreateTemp(dir, ".fscheck-*")
+	if err != nil {
+		return err
+	}
+
+	_ = probe.Close()
+
+	defer func(name string) {
+		_ = os.Remove(name)
+	}(probe.Name())
+
+	link := probe.Name() + ".link"
+	if err := os.Link(probe.Name(), link); err != nil {
+		return err
+	}
+
+	return os.Remove(link)
+}
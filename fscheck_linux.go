@@ -0,0 +1,52 @@
+//go:build linux
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+var filesystemTypes = map[uint32]string{
+	unix.NFS_SUPER_MAGIC:       "nfs",
+	unix.SMB_SUPER_MAGIC:       "smb",
+	unix.SMB2_SUPER_MAGIC:      "smb2",
+	unix.CIFS_SUPER_MAGIC:      "cifs",
+	unix.AFS_SUPER_MAGIC:       "afs",
+	unix.CEPH_SUPER_MAGIC:      "ceph",
+	unix.V9FS_MAGIC:            "9p",
+	unix.FUSE_SUPER_MAGIC:      "fuse",
+	unix.EXT4_SUPER_MAGIC:      "ext4",
+	unix.XFS_SUPER_MAGIC:       "xfs",
+	unix.BTRFS_SUPER_MAGIC:     "btrfs",
+	unix.TMPFS_MAGIC:           "tmpfs",
+	unix.OVERLAYFS_SUPER_MAGIC: "overlay",
+}
+
+// networkFilesystems are shared over the network, fuse is counted in since
+// it mostly backs sshfs and similar.
+var networkFilesystems = map[string]bool{
+	"nfs": true, "smb": true, "smb2": true, "cifs": true, "afs": true, "ceph": true, "9p": true, "fuse": true,
+}
+
+func statFilesystem(path string) filesystemInfo {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return filesystemInfo{}
+	}
+
+	name, ok := filesystemTypes[uint32(st.Type)]
+	if !ok {
+		name = "unknown"
+	}
+
+	return filesystemInfo{
+		Known:       true,
+		Type:        name,
+		Network:     networkFilesystems[name],
+		NoExec:      st.Flags&unix.ST_NOEXEC != 0,
+		ReadOnly:    st.Flags&unix.ST_RDONLY != 0,
+		TotalInodes: st.Files,
+		FreeInodes:  st.Ffree,
+		FreeBytes:   st.Bavail * uint64(st.Bsize),
+	}
+}
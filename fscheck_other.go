@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+func statFilesystem(_ string) filesystemInfo {
+	return filesystemInfo{Type: "unknown"}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckWorkFilesystems(t *testing.T) {
+	defer func(path string) {
+		distbuildPath = path
+	}(distbuildPath)
+
+	distbuildPath = filepath.Join(t.TempDir(), "distbuild")
+	workDir := filepath.Join(t.TempDir(), "work")
+	t.Setenv("AGENT_WORK_DIR", workDir)
+
+	require.NoError(t, checkWorkFilesystems())
+	assert.DirExists(t, distbuildPath)
+	assert.DirExists(t, workDir)
+}
+
+func TestCheckFilesystemProblems(t *testing.T) {
+	defer func(stat func(string) filesystemInfo, allow bool) {
+		filesystemStat, allowNetworkFS = stat, allow
+	}(filesystemStat, allowNetworkFS)
+
+	dir := t.TempDir()
+	filesystemStat = func(string) filesystemInfo {
+		return filesystemInfo{Known: true, Type: "nfs", Network: true, NoExec: true, TotalInodes: 1000, FreeInodes: 10}
+	}
+
+	err := checkFilesystem(dir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "mounted noexec")
+	assert.Contains(t, err.Error(), "network filesystem (nfs)")
+	assert.Contains(t, err.Error(), "only 10 free inodes")
+
+	allowNetworkFS = true
+	filesystemStat = func(string) filesystemInfo {
+		return filesystemInfo{Known: true, Type: "nfs", Network: true}
+	}
+	assert.NoError(t, checkFilesystem(dir))
+}
@@ -0,0 +1,33 @@
+//go:build darwin
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+
+	"golang.org/x/sys/unix"
+)
+
+func clearQuarantine(filePath string) error {
+	err := unix.Removexattr(filePath, quarantineAttr)
+	if errors.Is(err, unix.ENOATTR) {
+		return nil
+	}
+
+	return err
+}
+
+func codesignAdHoc(filePath string) error {
+	cmd := exec.CommandContext(runCtx, "codesign", "--force", "--sign", "-", filePath)
+	var stderr bytes.Buffer
+	cmd.Stderr = commandStderr(&stderr)
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v\n%s", err, stderr.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !darwin
+
+package main
+
+func clearQuarantine(_ string) error {
+	return nil
+}
+
+func codesignAdHoc(_ string) error {
+	return nil
+}
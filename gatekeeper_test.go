@@ -0,0 +1,19 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrepareBinaryLaunchWithoutQuarantine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"), 0755))
+
+	// A binary without the attribute is left as is
+	assert.NoError(t, prepareBinaryLaunch(path))
+	assert.FileExists(t, path)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// gitWaitDelay bounds the wait for the output of a killed git, helpers that
+// inherited its stderr may outlive it.
+const gitWaitDelay = 10 * time.Second
+
+// gitTimeout bounds every git subprocess, so a dead mirror or a prompt
+// nobody answers cannot stall a run that has no --timeout.
+var gitTimeout time.Duration
+
+// gitContext is the context of one git subprocess.
+func gitContext() (context.Context, context.CancelFunc) {
+	if gitTimeout == 0 {
+		return context.WithCancel(runCtx)
+	}
+
+	return context.WithTimeout(runCtx, gitTimeout)
+}
+
+// gitEnvironment keeps git from waiting for terminal input behind the
+// spinner: https credentials come from a helper or fail, ssh runs in batch
+// mode. A GIT_ASKPASS or ssh command set by the user answers without a
+// terminal and is kept.
+func gitEnvironment(environ []string) []string {
+	env := append(slices.Clone(environ), "GIT_TERMINAL_PROMPT=0")
+
+	if os.Getenv("GIT_SSH_COMMAND") == "" && os.Getenv("GIT_SSH") == "" {
+		env = append(env, "GIT_SSH_COMMAND=ssh -o BatchMode=yes")
+	}
+
+	return env
+}
+
+func gitCommand(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, gitBinary(), args...)
+	cmd.WaitDelay = gitWaitDelay
+	cmd.Env = gitEnvironment(os.Environ())
+	configureGitProcess(cmd)
+
+	return cmd
+}
+
+// gitTimeoutError tags a git subprocess killed by --git-timeout. The end of
+// the whole run is left to timeoutError.
+func gitTimeoutError(ctx context.Context, what string, err error) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) || runCtx.Err() != nil {
+		return err
+	}
+
+	return withCode(errCodeTimeout, fmt.Errorf("%s did not finish within --git-timeout %s: %w", what, gitTimeout, err))
+}
+
+// gitOutput runs a git query and returns its stdout.
+func gitOutput(args ...string) ([]byte, error) {
+	if err := checkGitHosts(args); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := gitContext()
+	defer cancel()
+
+	out, err := gitCommand(ctx, args...).Output()
+	err = gitTimeoutError(ctx, "git "+strings.Join(args, " "), err)
+	recordAction("git", args[0], gitInputs(args), map[string]string{"stdout": strings.TrimSpace(string(out))}, err)
+
+	return out, err
+}
+
+func runGit(what string, args ...string) (err error) {
+	var stderr bytes.Buffer
+	defer func() {
+		recordAction("git", what, gitInputs(args), gitOutputs(&stderr), err)
+	}()
+
+	if err := checkGitHosts(args); err != nil {
+		return fmt.Errorf("%s failed: %w", what, err)
+	}
+
+	ctx, cancel := gitContext()
+	defer cancel()
+
+	cmd := gitCommand(ctx, args...)
+	cmd.Stderr = commandStderr(&stderr)
+
+	if err := cmd.Run(); err != nil {
+		if timeoutErr := gitTimeoutError(ctx, what, err); timeoutErr != err {
+			return timeoutErr
+		}
+		return gitFailure(what, err, stderr.String())
+	}
+
+	return nil
+}
+
+// gitAuthFailures map what git and ssh print when credentials are missing
+// or rejected to the hint shown with the error.
+var gitAuthFailures = []struct {
+	marker string
+	hint   string
+}{
+	{"terminal prompts disabled", "git.auth_https"},
+	{"could not read Username", "git.auth_https"},
+	{"Authentication failed", "git.auth_https"},
+	{"HTTP Basic: Access denied", "git.auth_https"},
+	{"The requested URL returned error: 401", "git.auth_https"},
+	{"The requested URL returned error: 403", "git.auth_https"},
+	{"Permission denied (publickey", "git.auth_ssh"},
+	{"Host key verification failed", "git.host_key"},
+}
+
+// gitFailure is the error of a failed git command, an authentication
+// failure is told apart from other git errors and carries a remediation hint.
+func gitFailure(what string, err error, stderr string) error {
+	for _, failure := range gitAuthFailures {
+		if strings.Contains(stderr, failure.marker) {
+			return withCode(errCodeAuth, withHint(
+				fmt.Errorf("%s failed, git could not authenticate: %v\n%s", what, err, stderr),
+				msg(failure.hint, "host", os.Getenv("REPO_HOST"))))
+		}
+	}
+
+	return withCode(errCodeGit, fmt.Errorf("%s failed: %v\n%s", what, err, stderr))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitOutput(t *testing.T) {
+	out, err := gitOutput("--version")
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), "git version")
+}
+
+func TestGitEnvironment(t *testing.T) {
+	t.Setenv("GIT_SSH_COMMAND", "")
+	t.Setenv("GIT_SSH", "")
+
+	env := gitEnvironment([]string{"HOME=/home/builder"})
+	assert.Equal(t, []string{"HOME=/home/builder", "GIT_TERMINAL_PROMPT=0", "GIT_SSH_COMMAND=ssh -o BatchMode=yes"}, env)
+
+	t.Setenv("GIT_SSH_COMMAND", "ssh -i ~/.ssh/build")
+	assert.Equal(t, []string{"GIT_TERMINAL_PROMPT=0"}, gitEnvironment(nil))
+}
+
+func TestGitFailure(t *testing.T) {
+	t.Setenv("REPO_HOST", "https://git.example.com")
+	exitErr := errors.New("exit status 128")
+
+	err := gitFailure("distbuild clone", exitErr, "fatal: could not read Username for 'https://git.example.com': terminal prompts disabled\n")
+	assert.Equal(t, errCodeAuth, errorCodeOf(err))
+	assert.ErrorContains(t, err, "distbuild clone failed, git could not authenticate")
+	assert.Equal(t, "hint: git got no credentials for https://git.example.com, configure a git credential helper or ~/.netrc for it", hintOf(err))
+
+	err = gitFailure("clang clone", exitErr, "git@git.example.com: Permission denied (publickey).\n")
+	assert.Equal(t, errCodeAuth, errorCodeOf(err))
+	assert.Contains(t, hintOf(err), "ssh-agent")
+
+	err = gitFailure("clang clone", exitErr, "fatal: repository not found\n")
+	assert.Equal(t, errCodeGit, errorCodeOf(err))
+	assert.Empty(t, hintOf(err))
+}
+
+func TestRunGitDoesNotPrompt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	// A clone that needs credentials fails instead of waiting for input
+	err := runGit("clone", "-c", "credential.helper=", "clone", server.URL+"/distbuild.git", filepath.Join(t.TempDir(), "distbuild"))
+	assert.Equal(t, errCodeAuth, errorCodeOf(err))
+	assert.NotEmpty(t, hintOf(err))
+}
@@ -0,0 +1,36 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunGitTimeout(t *testing.T) {
+	defer func(timeout time.Duration, ctx context.Context) {
+		gitTimeout, runCtx = timeout, ctx
+	}(gitTimeout, runCtx)
+
+	runCtx = context.Background()
+	gitTimeout = 200 * time.Millisecond
+
+	// A shell alias stands in for a clone stuck on a dead mirror
+	started := time.Now()
+	err := runGit("hang", "-c", "alias.hang=!sleep 5", "hang")
+	assert.Less(t, time.Since(started), 4*time.Second)
+	assert.ErrorContains(t, err, "hang did not finish within --git-timeout 200ms")
+	assert.Equal(t, errCodeTimeout, errorCodeOf(err))
+
+	// The end of the whole run is not blamed on --git-timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	runCtx = ctx
+	gitTimeout = 0
+	err = runGit("hang", "-c", "alias.hang=!sleep 5", "hang")
+	assert.ErrorContains(t, err, "hang failed")
+	assert.Equal(t, errCodeGit, errorCodeOf(err))
+}
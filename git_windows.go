@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "os/exec"
+
+// configureGitProcess keeps the default of killing git alone, its helpers
+// exit once their pipes close.
+func configureGitProcess(_ *exec.Cmd) {}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gitProgressLine matches the progress git prints with --progress, like
+// "Receiving objects:  45% (450/1000), 12.00 MiB | 3.00 MiB/s".
+var gitProgressLine = regexp.MustCompile(`^(?:remote: )?([A-Za-z ]+):\s+(\d+)% \((\d+)/(\d+)\)(?:, ([^,]*?))?(?:, done\.)?\s*$`)
+
+// gitProgress turns the stderr of `git clone --progress` into the status of
+// a step. Progress lines are redrawn with \r, everything else is kept for
+// the error message.
+type gitProgress struct {
+	mu      sync.Mutex
+	step    *step
+	stderr  *bytes.Buffer
+	pending []byte
+
+	phase   string
+	started time.Time
+	now     func() time.Time
+}
+
+func newGitProgress(s *step, stderr *bytes.Buffer) *gitProgress {
+	return &gitProgress{step: s, stderr: stderr, now: time.Now}
+}
+
+func (p *gitProgress) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending = append(p.pending, data...)
+	for {
+		i := bytes.IndexAny(p.pending, "\r\n")
+		if i < 0 {
+			break
+		}
+		p.line(string(p.pending[:i]))
+		p.pending = p.pending[i+1:]
+	}
+
+	return len(data), nil
+}
+
+// Close keeps a last line without terminator.
+func (p *gitProgress) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.pending) > 0 {
+		p.line(string(p.pending))
+		p.pending = nil
+	}
+
+	return nil
+}
+
+func (p *gitProgress) line(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+
+	m := gitProgressLine.FindStringSubmatch(line)
+	if m == nil {
+		p.stderr.WriteString(line + "\n")
+		return
+	}
+
+	percent, _ := strconv.Atoi(m[2])
+	if m[1] != p.phase {
+		p.phase = m[1]
+		p.started = p.now()
+	}
+
+	status := fmt.Sprintf("%s %d%% (%s/%s)", strings.ToLower(m[1]), percent, m[3], m[4])
+	if m[5] != "" {
+		status += ", " + m[5]
+	}
+	if left := p.remaining(percent); left > 0 {
+		status += ", ~" + formatElapsed(left) + " left"
+	}
+
+	p.step.setStatus(status)
+}
+
+// remaining estimates the time left in the current phase from its progress
+// so far. Early estimates swing too much to be shown.
+func (p *gitProgress) remaining(percent int) time.Duration {
+	elapsed := p.now().Sub(p.started)
+	if percent < 5 || percent >= 100 || elapsed < time.Second {
+		return 0
+	}
+
+	return (elapsed * time.Duration(100-percent) / time.Duration(percent)).Round(time.Second)
+}
+
+// runGitProgress runs a git command that transfers objects, reporting its
+// progress on s unless raw output was asked for.
+func runGitProgress(s *step, what string, args ...string) (err error) {
+	if noProgress || verbose {
+		return runGit(what, args...)
+	}
+	var stderr bytes.Buffer
+	defer func() {
+		recordAction("git", what, gitInputs(args), gitOutputs(&stderr), err)
+	}()
+
+	if err := checkGitHosts(args); err != nil {
+		return fmt.Errorf("%s failed: %w", what, err)
+	}
+
+	// --progress has to follow the subcommand
+	args = append([]string{args[0], "--progress"}, args[1:]...)
+
+	progress := newGitProgress(s, &stderr)
+	defer s.setStatus("")
+
+	ctx, cancel := gitContext()
+	defer cancel()
+
+	cmd := gitCommand(ctx, args...)
+	cmd.Stderr = progress
+
+	err = cmd.Run()
+	_ = progress.Close()
+	if err != nil {
+		if timeoutErr := gitTimeoutError(ctx, what, err); timeoutErr != err {
+			return timeoutErr
+		}
+		return gitFailure(what, err, stderr.String())
+	}
+
+	return nil
+}
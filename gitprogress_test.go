@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitProgressStatus(t *testing.T) {
+	s := &step{name: "clone clang"}
+	var stderr bytes.Buffer
+	p := newGitProgress(s, &stderr)
+
+	now := time.Unix(0, 0)
+	p.now = func() time.Time { return now }
+
+	_, _ = p.Write([]byte("Cloning into 'clang'...\n"))
+	_, _ = p.Write([]byte("remote: Counting objects: 100% (10/10), done.\n"))
+	_, _ = p.Write([]byte("Receiving objects:   1% (10/1000)\r"))
+	assert.Equal(t, "receiving objects 1% (10/1000)", s.currentStatus())
+
+	// Split writes are joined before parsing
+	now = now.Add(20 * time.Second)
+	_, _ = p.Write([]byte("Receiving objects:  50% (500/1000), 12.00 MiB "))
+	_, _ = p.Write([]byte("| 3.00 MiB/s\r"))
+	assert.Equal(t, "receiving objects 50% (500/1000), 12.00 MiB | 3.00 MiB/s, ~20.0s left", s.currentStatus())
+
+	now = now.Add(time.Second)
+	_, _ = p.Write([]byte("Resolving deltas:  30% (30/100)\r"))
+	assert.Equal(t, "resolving deltas 30% (30/100)", s.currentStatus())
+
+	_, _ = p.Write([]byte("fatal: early EOF"))
+	assert.NoError(t, p.Close())
+
+	assert.Equal(t, "Cloning into 'clang'...\nfatal: early EOF\n", stderr.String())
+}
+
+func TestGitProgressRemaining(t *testing.T) {
+	now := time.Unix(100, 0)
+	p := &gitProgress{started: time.Unix(0, 0), now: func() time.Time { return now }}
+
+	assert.Equal(t, 300*time.Second, p.remaining(25))
+	assert.Zero(t, p.remaining(2))
+	assert.Zero(t, p.remaining(100))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// gitMinVersion is the oldest git bootstrap runs at all, for `stash push`
+// and --no-optional-locks.
+const gitMinVersion = "2.15"
+
+var gitBinFlag string
+
+// gitFeature is a git capability bootstrap uses when it is there and works
+// around when it is not, fallback names the message telling how.
+type gitFeature struct {
+	name     string
+	since    string
+	fallback string
+}
+
+var (
+	gitPartialClone  = gitFeature{name: "clone --filter", since: "2.19", fallback: "git.no_filter"}
+	gitSparseCommand = gitFeature{name: "sparse-checkout", since: "2.25", fallback: "git.no_sparse"}
+	gitNoCone        = gitFeature{name: "sparse-checkout --no-cone", since: "2.35", fallback: "git.no_cone"}
+
+	gitFeatures = []gitFeature{gitPartialClone, gitSparseCommand, gitNoCone}
+)
+
+var gitVersionLine = regexp.MustCompile(`git version (\d+\.\d+(?:\.\d+)?)`)
+
+// Versions by git binary, detected once per run
+var gitVersionCache = map[string]string{}
+
+// gitVersion is the version of the git bootstrap runs, empty when it cannot
+// be told.
+func gitVersion() (string, error) {
+	if version, ok := gitVersionCache[gitBinary()]; ok {
+		return version, nil
+	}
+
+	out, err := gitOutput("--version")
+	if err != nil {
+		return "", err
+	}
+
+	version := ""
+	if m := gitVersionLine.FindStringSubmatch(string(out)); m != nil {
+		version = m[1]
+	}
+	gitVersionCache[gitBinary()] = version
+
+	return version, nil
+}
+
+// gitBinary is the git executable: --git-bin, GIT_BIN or git from PATH.
+func gitBinary() string {
+	if gitBinFlag != "" {
+		return gitBinFlag
+	}
+	if bin := os.Getenv("GIT_BIN"); bin != "" {
+		return bin
+	}
+
+	return "git"
+}
+
+// hasGitFeature is true unless the git version is known to be too old.
+func hasGitFeature(feature gitFeature) bool {
+	version, err := gitVersion()
+	if err != nil || version == "" {
+		return true
+	}
+
+	return compareVersions(version, feature.since) >= 0
+}
+
+// checkGit makes sure git runs and is recent enough, and tells which
+// features it lacks before a clone depends on them.
+func checkGit() error {
+	version, err := gitVersion()
+	if err != nil {
+		return withCode(errCodeGit, withHint(fmt.Errorf("run %s failed: %w", gitBinary(), err), msg("git.bin_hint")))
+	}
+	if version == "" {
+		warn("git.unknown_version", "bin", gitBinary())
+		return nil
+	}
+
+	logVerbose("%s is git %s", gitBinary(), version)
+
+	if compareVersions(version, gitMinVersion) < 0 {
+		return withCode(errCodeGit, withHint(fmt.Errorf("git %s is older than %s", version, gitMinVersion), msg("git.bin_hint")))
+	}
+
+	for _, feature := range gitFeatures {
+		if !hasGitFeature(feature) {
+			warn("git.feature_missing", "version", version, "feature", feature.name, "since", feature.since, "fallback", msg(feature.fallback))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGit installs a git that only reports version and sets it as --git-bin.
+func fakeGit(t *testing.T, version string) {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("needs shell scripts")
+	}
+
+	bin := filepath.Join(t.TempDir(), "git")
+	require.NoError(t, os.WriteFile(bin, []byte("#!/bin/sh\necho 'git version "+version+"'\n"), 0755))
+
+	prev := gitBinFlag
+	gitBinFlag = bin
+	t.Cleanup(func() {
+		gitBinFlag = prev
+		delete(gitVersionCache, bin)
+	})
+}
+
+func TestGitBinary(t *testing.T) {
+	defer func(bin string) { gitBinFlag = bin }(gitBinFlag)
+
+	gitBinFlag = ""
+	t.Setenv("GIT_BIN", "")
+	assert.Equal(t, "git", gitBinary())
+
+	t.Setenv("GIT_BIN", "/opt/git/bin/git")
+	assert.Equal(t, "/opt/git/bin/git", gitBinary())
+
+	gitBinFlag = "/usr/local/bin/git"
+	assert.Equal(t, "/usr/local/bin/git", gitBinary())
+}
+
+func TestCheckGitOldVersion(t *testing.T) {
+	fakeGit(t, "2.11.0")
+
+	err := checkGit()
+	assert.ErrorContains(t, err, "git 2.11.0 is older than 2.15")
+	assert.Equal(t, errCodeGit, errorCodeOf(err))
+	assert.Contains(t, hintOf(err), "--git-bin")
+}
+
+func TestCheckGitMissingFeatures(t *testing.T) {
+	fakeGit(t, "2.20.1 (Apple Git-117)")
+
+	output := captureStdout(t, func() {
+		assert.NoError(t, checkGit())
+	})
+	assert.NotContains(t, output, "clone --filter")
+	assert.Contains(t, output, "git 2.20.1 lacks sparse-checkout (since 2.25), sparse toolchains are checked out in full")
+	assert.Contains(t, output, "lacks sparse-checkout --no-cone")
+
+	assert.True(t, hasGitFeature(gitPartialClone))
+	assert.False(t, hasGitFeature(gitSparseCommand))
+	assert.Equal(t, []string{"sparse-checkout", "set", "prebuilts/*"}, sparseCheckoutArgs([]string{"prebuilts/*"}))
+}
+
+func TestCheckGitCurrentVersion(t *testing.T) {
+	fakeGit(t, "2.43.0.windows.1")
+
+	output := captureStdout(t, func() {
+		assert.NoError(t, checkGit())
+	})
+	assert.Empty(t, output)
+	assert.True(t, hasGitFeature(gitNoCone))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// healthShutdownTimeout bounds how long open health requests may delay the
+// exit of bootstrap.
+const healthShutdownTimeout = 5 * time.Second
+
+var healthAddr string
+
+// healthState is what the health endpoint of a long-running mode reports.
+type healthState struct {
+	Mode    string         `json:"mode"`
+	State   string         `json:"state"`
+	Ready   bool           `json:"ready"`
+	Since   time.Time      `json:"since"`
+	Updated time.Time      `json:"updated"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+var (
+	healthMu sync.Mutex
+	// health is nil unless --health-addr serves it
+	health *healthState
+)
+
+func addHealthFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&healthAddr, "health-addr", "", "serve /healthz and /readyz on this address while running, e.g. 127.0.0.1:9464")
+}
+
+func checkHealthFlags() error {
+	if healthAddr == "" {
+		return nil
+	}
+
+	if _, _, err := net.SplitHostPort(healthAddr); err != nil {
+		return fmt.Errorf("--health-addr %q is not host:port: %w", healthAddr, err)
+	}
+
+	return nil
+}
+
+// serveHealth starts the health endpoint of a long-running mode, the
+// returned stop shuts it down. It does nothing without --health-addr.
+func serveHealth(mode string) (func(), error) {
+	if healthAddr == "" {
+		return func() {}, nil
+	}
+
+	listener, err := net.Listen("tcp", healthAddr)
+	if err != nil {
+		return nil, withCode(errCodeUsage, fmt.Errorf("listen on --health-addr %s failed: %w", healthAddr, err))
+	}
+
+	trackHealth(mode)
+
+	server := &http.Server{Handler: healthHandler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			warn("health.serve", "err", err)
+		}
+	}()
+
+	printMsg("health.listening", "addr", listener.Addr())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), healthShutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+		untrackHealth()
+	}, nil
+}
+
+// trackHealth starts reporting the state of a mode, modes with their own
+// http server mount healthHandler themselves.
+func trackHealth(mode string) {
+	now := time.Now().UTC()
+
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	health = &healthState{Mode: mode, State: "starting", Since: now, Updated: now}
+}
+
+func untrackHealth() {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	health = nil
+}
+
+// setHealth reports the current state, details replace the previous ones.
+func setHealth(state string, ready bool, details map[string]any) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	if health == nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	if health.State != state {
+		health.Since = now
+	}
+	health.State, health.Ready, health.Details, health.Updated = state, ready, details, now
+}
+
+// healthHandler serves liveness on /healthz, bootstrap answering is enough,
+// and readiness on /readyz, which fails while the mode is not doing its job.
+func healthHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	respond := func(w http.ResponseWriter, readiness bool) {
+		healthMu.Lock()
+		if health == nil {
+			healthMu.Unlock()
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		state := *health
+		healthMu.Unlock()
+
+		status := http.StatusOK
+		if readiness && !state.Ready {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(state)
+	}
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, false)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, true)
+	})
+
+	return mux
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getHealth(t *testing.T, url string) (int, healthState) {
+	resp, err := http.Get(url)
+	assert.NoError(t, err)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var state healthState
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&state))
+
+	return resp.StatusCode, state
+}
+
+func TestServeHealth(t *testing.T) {
+	defer func(addr string) {
+		healthAddr = addr
+	}(healthAddr)
+	healthAddr = "127.0.0.1:0"
+
+	var stop func()
+	output := captureStdout(t, func() {
+		var err error
+		stop, err = serveHealth("supervise")
+		assert.NoError(t, err)
+	})
+	defer stop()
+
+	base := regexp.MustCompile(`http://[0-9.:]+`).FindString(output)
+	assert.NotEmpty(t, base)
+
+	status, state := getHealth(t, base+"/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "supervise", state.Mode)
+	assert.Equal(t, "starting", state.State)
+
+	setHealth("running", true, map[string]any{"pid": 42})
+	status, state = getHealth(t, base+"/readyz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "running", state.State)
+	assert.Equal(t, float64(42), state.Details["pid"])
+
+	// Liveness holds while the agent is restarting
+	setHealth("backoff", false, nil)
+	status, _ = getHealth(t, base+"/healthz")
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = getHealth(t, base+"/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+}
+
+func TestHealthStopped(t *testing.T) {
+	server := httptest.NewServer(healthHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/healthz")
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// Without --health-addr states go nowhere
+	setHealth("running", true, nil)
+	assert.Nil(t, health)
+}
+
+func TestCheckHealthFlags(t *testing.T) {
+	defer func(addr string) {
+		healthAddr = addr
+	}(healthAddr)
+
+	healthAddr = ":9464"
+	assert.NoError(t, checkHealthFlags())
+
+	healthAddr = "9464"
+	assert.Error(t, checkHealthFlags())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	installScopeHost      = "host"
+	installScopeWorkspace = "workspace"
+
+	// workspaceInstallDir is where a workspace install lives inside the AOSP
+	// tree. It stays out of out/, which `m clean` removes.
+	workspaceInstallDir = ".distbuild"
+)
+
+var installScope = installScopeHost
+
+func addInstallScopeFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&installScope, "install-scope", installScopeHost,
+		"install the distbuild binaries for the whole host (host, needs --distbuild-path) or inside the AOSP workspace (workspace), so workspaces can pin different versions")
+}
+
+// checkInstallScopeFlags resolves the distbuild path of the scope. It runs
+// before the paths are expanded.
+func checkInstallScopeFlags() error {
+	switch installScope {
+	case installScopeHost:
+		if distbuildPath == "" {
+			return fmt.Errorf(`required flag(s) "distbuild-path" not set`)
+		}
+	case installScopeWorkspace:
+		if aospPath == "" {
+			return fmt.Errorf("--install-scope workspace needs --aosp-path")
+		}
+		if distbuildPath != "" {
+			return fmt.Errorf("--distbuild-path cannot be used with --install-scope workspace, binaries go to %s", filepath.Join(aospPath, workspaceInstallDir))
+		}
+		aosp, err := expandPath(aospPath)
+		if err != nil {
+			return fmt.Errorf("failed to expand path: %w", err)
+		}
+		distbuildPath = filepath.Join(aosp, workspaceInstallDir)
+	default:
+		return fmt.Errorf("invalid --install-scope %q, want %s or %s", installScope, installScopeHost, installScopeWorkspace)
+	}
+
+	return nil
+}
+
+// recordInstallScope marks a workspace install in its manifest, so commands
+// working on it later without --install-scope treat it as one.
+func recordInstallScope() error {
+	if installScope != installScopeWorkspace {
+		return nil
+	}
+
+	lock, err := lockManifest()
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	manifest, err := loadManifest()
+	if err != nil {
+		return err
+	}
+	if manifest.Scope == installScopeWorkspace {
+		return nil
+	}
+
+	manifest.Scope = installScopeWorkspace
+
+	return saveManifest(manifest)
+}
+
+// linkHostBinaries tells whether installed binaries are also linked into
+// /usr/local/bin. Workspace installs are not, or the last workspace
+// provisioned would decide the version for every other.
+func linkHostBinaries() bool {
+	if installScope == installScopeWorkspace {
+		return false
+	}
+
+	manifest, err := loadManifest()
+
+	return err != nil || manifest.Scope != installScopeWorkspace
+}
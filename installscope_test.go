@@ -0,0 +1,57 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckInstallScopeFlags(t *testing.T) {
+	defer func(scope, aosp, path string) {
+		installScope, aospPath, distbuildPath = scope, aosp, path
+	}(installScope, aospPath, distbuildPath)
+
+	installScope, aospPath, distbuildPath = installScopeHost, "/src/aosp", ""
+	assert.ErrorContains(t, checkInstallScopeFlags(), `"distbuild-path" not set`)
+
+	distbuildPath = "/opt/distbuild"
+	assert.NoError(t, checkInstallScopeFlags())
+	assert.Equal(t, "/opt/distbuild", distbuildPath)
+
+	installScope = installScopeWorkspace
+	assert.ErrorContains(t, checkInstallScopeFlags(), "cannot be used with --install-scope workspace")
+
+	distbuildPath = ""
+	assert.NoError(t, checkInstallScopeFlags())
+	assert.Equal(t, filepath.Join("/src/aosp", ".distbuild"), distbuildPath)
+
+	aospPath, distbuildPath = "", ""
+	assert.ErrorContains(t, checkInstallScopeFlags(), "needs --aosp-path")
+
+	installScope = "global"
+	assert.ErrorContains(t, checkInstallScopeFlags(), "invalid --install-scope")
+}
+
+func TestLinkHostBinaries(t *testing.T) {
+	defer func(scope, path string) {
+		installScope, distbuildPath = scope, path
+	}(installScope, distbuildPath)
+
+	distbuildPath = t.TempDir()
+	installScope = installScopeHost
+	assert.True(t, linkHostBinaries())
+
+	installScope = installScopeWorkspace
+	assert.False(t, linkHostBinaries())
+	require.NoError(t, recordInstallScope())
+
+	// Later commands on the install keep it out of /usr/local/bin
+	installScope = installScopeHost
+	assert.False(t, linkHostBinaries())
+
+	manifest, err := loadManifest()
+	require.NoError(t, err)
+	assert.Equal(t, installScopeWorkspace, manifest.Scope)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultSSHPort       = 22
+	defaultRemoteCommand = "bootstrap"
+	sshConnectTimeout    = 10 * time.Second
+)
+
+var (
+	inventoryPath  string
+	inventoryLimit string
+	sshOptions     []string
+)
+
+// sshBinary runs the remote commands, tests point it at a local stand-in.
+var sshBinary = "ssh"
+
+// inventory lists the build hosts fleet commands act on. Host fields left
+// empty fall back to the defaults. Groups name sets of hosts in addition to
+// the groups listed on the hosts themselves. AgentPins pin the agent
+// artifact of a group, e.g. a canary group on the newest agent.
+type inventory struct {
+	Defaults  inventoryHost       `json:"defaults"`
+	Groups    map[string][]string `json:"groups,omitempty"`
+	AgentPins map[string]string   `json:"agent_pins,omitempty"`
+	Hosts     []inventoryHost     `json:"hosts"`
+}
+
+type inventoryHost struct {
+	Name          string `json:"name,omitempty"`
+	Address       string `json:"address"`
+	User          string `json:"user,omitempty"`
+	Port          int    `json:"port,omitempty"`
+	DistbuildPath string `json:"distbuild_path,omitempty"`
+	// Bootstrap is the command that runs bootstrap on the host, ending in
+	// the executable, e.g. "sudo /usr/local/bin/bootstrap".
+	Bootstrap string            `json:"bootstrap,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Groups    []string          `json:"groups,omitempty"`
+	// AgentBin is the agent artifact the host runs instead of --agent-bin
+	// or its AGENT_BIN, it wins over the pins of its groups.
+	AgentBin string `json:"agent_bin,omitempty"`
+	// VerifyCommand and VerifyURL check a host after a fleet upgrade, see
+	// verifyHost.
+	VerifyCommand string `json:"verify_command,omitempty"`
+	VerifyURL     string `json:"verify_url,omitempty"`
+}
+
+func addInventoryFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&inventoryPath, "inventory", "", "inventory file listing the build hosts")
+	cmd.PersistentFlags().StringVar(&inventoryLimit, "limit", "", "only act on hosts matching this expression, e.g. 'rack=a AND arch=arm64'")
+	cmd.PersistentFlags().StringArrayVar(&sshOptions, "ssh-option", nil, "extra ssh -o option, e.g. StrictHostKeyChecking=accept-new (repeatable)")
+	_ = cmd.MarkPersistentFlagRequired("inventory")
+}
+
+func loadInventory(path string) (*inventory, error) {
+	path, err := expandPath(path)
+	if err != nil {
+		return nil, withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read inventory failed: %w", err)
+	}
+
+	var inv inventory
+	if err := json.Unmarshal(data, &inv); err != nil {
+		return nil, withCode(errCodeUsage, fmt.Errorf("parse inventory failed: %w", err))
+	}
+
+	names := map[string]bool{}
+	for i := range inv.Hosts {
+		host := inv.Hosts[i].withDefaults(inv.Defaults)
+		if host.Address == "" {
+			return nil, withCode(errCodeUsage, fmt.Errorf("inventory host %d has no address", i+1))
+		}
+		if names[host.Name] {
+			return nil, withCode(errCodeUsage, fmt.Errorf("inventory host %s is listed twice", host.Name))
+		}
+		names[host.Name] = true
+		inv.Hosts[i] = host
+	}
+
+	for group, members := range inv.Groups {
+		for _, member := range members {
+			if !names[member] {
+				return nil, withCode(errCodeUsage, fmt.Errorf("inventory group %s lists unknown host %s", group, member))
+			}
+		}
+		for i := range inv.Hosts {
+			for _, member := range members {
+				if inv.Hosts[i].Name == member {
+					inv.Hosts[i].Groups = append(inv.Hosts[i].Groups, group)
+				}
+			}
+		}
+	}
+
+	for i := range inv.Hosts {
+		pin, err := inv.agentPin(inv.Hosts[i])
+		if err != nil {
+			return nil, withCode(errCodeUsage, err)
+		}
+		inv.Hosts[i].AgentBin = pin
+	}
+
+	return &inv, nil
+}
+
+// agentPin resolves the agent artifact pinned for a host: its own, the pin
+// of its groups, then the one of the defaults. Groups of a host must not pin
+// different agents.
+func (inv *inventory) agentPin(h inventoryHost) (string, error) {
+	if h.AgentBin != "" {
+		return h.AgentBin, nil
+	}
+
+	pin, pinGroup := "", ""
+	for _, group := range h.Groups {
+		groupPin, ok := inv.AgentPins[group]
+		if !ok || groupPin == pin {
+			continue
+		}
+		if pin != "" {
+			return "", fmt.Errorf("inventory host %s is in groups %s and %s, which pin different agents", h.Name, pinGroup, group)
+		}
+		pin, pinGroup = groupPin, group
+	}
+	if pin != "" {
+		return pin, nil
+	}
+
+	return inv.Defaults.AgentBin, nil
+}
+
+func (h inventoryHost) withDefaults(defaults inventoryHost) inventoryHost {
+	if h.Name == "" {
+		h.Name = h.Address
+	}
+	if h.User == "" {
+		h.User = defaults.User
+	}
+	if h.Port == 0 {
+		h.Port = defaults.Port
+	}
+	if h.Port == 0 {
+		h.Port = defaultSSHPort
+	}
+	if h.DistbuildPath == "" {
+		h.DistbuildPath = defaults.DistbuildPath
+	}
+	if h.Bootstrap == "" {
+		h.Bootstrap = defaults.Bootstrap
+	}
+	if h.VerifyCommand == "" {
+		h.VerifyCommand = defaults.VerifyCommand
+	}
+	if h.VerifyURL == "" {
+		h.VerifyURL = defaults.VerifyURL
+	}
+	if strings.TrimSpace(h.Bootstrap) == "" {
+		h.Bootstrap = defaultRemoteCommand
+	}
+
+	tags := map[string]string{}
+	for key, value := range defaults.Tags {
+		tags[key] = value
+	}
+	for key, value := range h.Tags {
+		tags[key] = value
+	}
+	h.Tags = tags
+	h.Groups = append(append([]string(nil), defaults.Groups...), h.Groups...)
+
+	return h
+}
+
+func (h inventoryHost) destination() string {
+	if h.User == "" {
+		return h.Address
+	}
+
+	return h.User + "@" + h.Address
+}
+
+// sshArgs never prompts, a host that asks for a password or an unknown host
+// key fails instead of hanging the rollout.
+func (h inventoryHost) sshArgs() []string {
+	args := []string{
+		"-p", strconv.Itoa(h.Port),
+		"-o", "BatchMode=yes",
+		"-o", "ConnectTimeout=" + strconv.Itoa(int(sshConnectTimeout.Seconds())),
+	}
+	for _, option := range sshOptions {
+		args = append(args, "-o", option)
+	}
+
+	return append(args, h.destination())
+}
+
+// remoteCommand prepares script to run in the remote shell of the host.
+func remoteCommand(ctx context.Context, h inventoryHost, script string) *exec.Cmd {
+	return exec.CommandContext(ctx, sshBinary, append(append(h.sshArgs(), "--"), script)...)
+}
+
+// quoteCommand joins args into a command line for the remote shell.
+func quoteCommand(args ...string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// remoteError tells a failed connection from a failed command, ssh exits
+// with 255 when the command never ran.
+func remoteError(h inventoryHost, err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 255 {
+		return withCode(errCodeNetwork, fmt.Errorf("ssh to %s failed: %w", h.Name, err))
+	}
+
+	return err
+}
+
+// runRemote runs a command on the host and returns its combined output.
+func runRemote(ctx context.Context, h inventoryHost, args ...string) (string, error) {
+	cmd := remoteCommand(ctx, h, quoteCommand(args...))
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+
+	return output.String(), remoteError(h, err)
+}
+
+// shellQuote quotes s for a POSIX shell unless it only has safe characters.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:@,+%") == "" {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
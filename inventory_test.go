@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeInventory(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	return path
+}
+
+func TestLoadInventory(t *testing.T) {
+	inv, err := loadInventory(writeInventory(t, `{
+		"defaults": {"user": "build", "distbuild_path": "/opt/distbuild"},
+		"hosts": [
+			{"address": "10.0.0.1"},
+			{"name": "arm-01", "address": "10.0.0.2", "user": "root", "port": 2222, "bootstrap": "sudo /usr/local/bin/bootstrap"}
+		]
+	}`))
+	assert.NoError(t, err)
+
+	if assert.Len(t, inv.Hosts, 2) {
+		first, second := inv.Hosts[0], inv.Hosts[1]
+		assert.Equal(t, "10.0.0.1", first.Name)
+		assert.Equal(t, "build@10.0.0.1", first.destination())
+		assert.Equal(t, defaultSSHPort, first.Port)
+		assert.Equal(t, "/opt/distbuild", first.DistbuildPath)
+		assert.Equal(t, defaultRemoteCommand, first.Bootstrap)
+
+		assert.Equal(t, "root@10.0.0.2", second.destination())
+		assert.Equal(t, []string{"-p", "2222", "-o", "BatchMode=yes", "-o", "ConnectTimeout=10", "root@10.0.0.2"}, second.sshArgs())
+	}
+
+	_, err = loadInventory(writeInventory(t, `{"hosts": [{"name": "a", "address": "x"}, {"name": "a", "address": "y"}]}`))
+	assert.Equal(t, errCodeUsage, errorCodeOf(err))
+
+	_, err = loadInventory(writeInventory(t, `{"hosts": [{"name": "a"}]}`))
+	assert.Equal(t, errCodeUsage, errorCodeOf(err))
+
+	_, err = loadInventory(writeInventory(t, `hosts: []`))
+	assert.Equal(t, errCodeUsage, errorCodeOf(err))
+}
+
+func TestInventoryAgentPins(t *testing.T) {
+	inv, err := loadInventory(writeInventory(t, `{
+		"defaults": {"agent_bin": "agent-v1"},
+		"groups": {"canary": ["a"], "rack-b": ["b", "c"]},
+		"agent_pins": {"canary": "agent-v3", "rack-b": "agent-v2"},
+		"hosts": [
+			{"name": "a", "address": "a"},
+			{"name": "b", "address": "b"},
+			{"name": "c", "address": "c", "agent_bin": "agent-dev"},
+			{"name": "d", "address": "d"}
+		]
+	}`))
+	assert.NoError(t, err)
+
+	pins := map[string]string{}
+	for _, h := range inv.Hosts {
+		pins[h.Name] = h.AgentBin
+	}
+	assert.Equal(t, map[string]string{"a": "agent-v3", "b": "agent-v2", "c": "agent-dev", "d": "agent-v1"}, pins)
+
+	_, err = loadInventory(writeInventory(t, `{
+		"groups": {"canary": ["a"], "rack-b": ["a"]},
+		"agent_pins": {"canary": "agent-v3", "rack-b": "agent-v2"},
+		"hosts": [{"name": "a", "address": "a"}]
+	}`))
+	assert.ErrorContains(t, err, "pin different agents")
+	assert.Equal(t, errCodeUsage, errorCodeOf(err))
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, "--distbuild-path", shellQuote("--distbuild-path"))
+	assert.Equal(t, "AGENT_BIN=https://example.com/agent", shellQuote("AGENT_BIN=https://example.com/agent"))
+	assert.Equal(t, "'/opt/my build'", shellQuote("/opt/my build"))
+	assert.Equal(t, `'it'\''s'`, shellQuote("it's"))
+	assert.Equal(t, "''", shellQuote(""))
+	assert.Equal(t, "'$(reboot)'", shellQuote("$(reboot)"))
+}
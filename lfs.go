@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// usesLFS reports whether any .gitattributes in the checkout routes files
+// through the LFS filter.
+func usesLFS(path string) (bool, error) {
+	found := false
+
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || d.Name() != ".gitattributes" {
+			return nil
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(string(content), "filter=lfs") {
+			found = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+
+	return found, err
+}
+
+// fetchLFSObjects replaces the LFS pointer files of a fresh clone with their
+// content and fails if any object could not be fetched, a pointer file left
+// in a toolchain breaks builds in confusing ways.
+func fetchLFSObjects(path, name string) error {
+	lfs, err := usesLFS(path)
+	if err != nil {
+		return fmt.Errorf("detect git lfs in %s failed: %w", name, err)
+	}
+	if !lfs {
+		return nil
+	}
+
+	logVerbose("%s uses git lfs, fetching objects", name)
+
+	if _, err := gitOutput("lfs", "version"); err != nil {
+		return withCode(errCodeGit, fmt.Errorf("%s uses git lfs but git-lfs is not installed", name))
+	}
+
+	// The LFS server may be another host than the remote, .lfsconfig can
+	// point anywhere
+	env, err := gitOutput("-C", path, "lfs", "env")
+	if err != nil {
+		return withCode(errCodeGit, fmt.Errorf("%s git lfs env failed: %w", name, err))
+	}
+	if err := checkGitHosts(parseLFSEndpoints(string(env))); err != nil {
+		return fmt.Errorf("%s git lfs endpoint: %w", name, err)
+	}
+
+	for _, args := range [][]string{{"lfs", "install", "--local"}, {"lfs", "pull"}} {
+		if err := runGit(name+" git "+strings.Join(args, " "), append([]string{"-C", path}, args...)...); err != nil {
+			return err
+		}
+	}
+
+	missing, err := missingLFSObjects(path)
+	if err != nil {
+		return withCode(errCodeGit, fmt.Errorf("%s git lfs ls-files failed: %w", name, err))
+	}
+
+	if len(missing) > 0 {
+		return withCode(errCodeGit, fmt.Errorf("%s has %d git lfs objects that could not be fetched: %s",
+			name, len(missing), strings.Join(missing, ", ")))
+	}
+
+	return nil
+}
+
+func missingLFSObjects(path string) ([]string, error) {
+	output, err := gitOutput("-C", path, "lfs", "ls-files")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseLFSFiles(string(output)), nil
+}
+
+// parseLFSFiles returns the files `git lfs ls-files` lists as pointers,
+// marked with "-" instead of "*" after the object id.
+func parseLFSFiles(output string) []string {
+	var missing []string
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) == 3 && fields[1] == "-" {
+			missing = append(missing, fields[2])
+		}
+	}
+
+	return missing
+}
+
+// parseLFSEndpoints returns the endpoints `git lfs env` prints, like
+// "Endpoint=https://host/repo.git/info/lfs (auth=none)" and its "SSH=" line.
+func parseLFSEndpoints(output string) []string {
+	var endpoints []string
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || !strings.HasPrefix(key, "Endpoint") && key != "SSH" {
+			continue
+		}
+		value, _, _ = strings.Cut(value, " (")
+		if value != "" {
+			endpoints = append(endpoints, value)
+		}
+	}
+
+	return endpoints
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsesLFS(t *testing.T) {
+	dir := t.TempDir()
+
+	found, err := usesLFS(dir)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "lib"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "lib", ".gitattributes"), []byte("*.so filter=lfs diff=lfs merge=lfs -text\n"), 0644))
+
+	found, err = usesLFS(dir)
+	assert.NoError(t, err)
+	assert.True(t, found)
+}
+
+func TestParseLFSFiles(t *testing.T) {
+	output := "3a1b2c * lib/libclang.so\n4d5e6f - lib/libLLVM.so\n7a8b9c - bin/clang with space\n"
+	assert.Equal(t, []string{"lib/libLLVM.so", "bin/clang with space"}, parseLFSFiles(output))
+}
+
+func TestParseLFSEndpoints(t *testing.T) {
+	output := "git-lfs/3.4.1 (GitHub; linux amd64; go 1.21.5)\ngit version 2.43.0\n\n" +
+		"Endpoint=https://lfs.example.com/clang.git/info/lfs (auth=basic)\n" +
+		"  SSH=git@lfs.example.com:clang.git\n" +
+		"Endpoint (mirror)=https://mirror.example.com/clang.git/info/lfs (auth=none)\n" +
+		"LocalWorkingDir=/src/clang\nAccessDownload=basic\n"
+	assert.Equal(t, []string{
+		"https://lfs.example.com/clang.git/info/lfs",
+		"git@lfs.example.com:clang.git",
+		"https://mirror.example.com/clang.git/info/lfs",
+	}, parseLFSEndpoints(output))
+}
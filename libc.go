@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Artifact variants. The default binaries link against glibc, the static
+// ones, configured as <KEY>_STATIC next to each artifact url, run on musl
+// and on glibc releases older than the default binaries need.
+const (
+	variantAuto   = "auto"
+	variantGlibc  = "glibc"
+	variantStatic = "static"
+)
+
+var artifactVariant string
+
+var glibcSymbolVersion = regexp.MustCompile(`GLIBC_[0-9.]+`)
+
+// glibcVersion returns the version of the host glibc, "" on hosts without
+// one. Tests replace it.
+var glibcVersion = func() string {
+	if runtime.GOOS != "linux" || hostLibc() != libcGNU {
+		return ""
+	}
+
+	if out, err := exec.Command("getconf", "GNU_LIBC_VERSION").Output(); err == nil {
+		if _, version, ok := strings.Cut(strings.TrimSpace(string(out)), " "); ok {
+			return version
+		}
+	}
+
+	// "ldd (GNU libc) 2.17" on releases without getconf support
+	if out, err := exec.Command("ldd", "--version").Output(); err == nil {
+		line, _, _ := strings.Cut(string(out), "\n")
+		if fields := strings.Fields(line); len(fields) > 0 {
+			return fields[len(fields)-1]
+		}
+	}
+
+	return ""
+}
+
+func addArtifactVariantFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&artifactVariant, "artifact-variant", "", "binaries to install: auto, glibc, static (default ARTIFACT_VARIANT or auto)")
+}
+
+func checkArtifactVariantFlags() error {
+	switch artifactVariant {
+	case "", variantAuto, variantGlibc, variantStatic:
+		return nil
+	default:
+		return fmt.Errorf("unsupported --artifact-variant %q", artifactVariant)
+	}
+}
+
+// staticReason tells why the host needs the static variants, "" if not.
+func staticReason() string {
+	variant := artifactVariant
+	if variant == "" {
+		variant = os.Getenv("ARTIFACT_VARIANT")
+	}
+
+	switch variant {
+	case variantStatic:
+		return "static variant selected"
+	case variantGlibc:
+		return ""
+	}
+
+	if hostLibc() == libcMusl {
+		return "host uses musl"
+	}
+
+	minimum := os.Getenv("GLIBC_MIN_VERSION")
+	if version := glibcVersion(); minimum != "" && version != "" && compareVersions(version, minimum) < 0 {
+		return fmt.Sprintf("host glibc %s is older than %s", version, minimum)
+	}
+
+	return ""
+}
+
+// artifactURL returns the url configured for an artifact key, the static
+// variant when the host needs it and one is configured.
+func artifactURL(key string) string {
+	if reason := staticReason(); reason != "" {
+		if url := os.Getenv(key + "_STATIC"); url != "" {
+			logVerbose("%s: using %s_STATIC, %s", key, key, reason)
+			return url
+		}
+		logVerbose("%s: %s but %s_STATIC is not set", key, reason, key)
+	}
+
+	return os.Getenv(key)
+}
+
+// smokeTestBinary starts an installed binary once. Binaries that cannot
+// start on this host fail with the reason, other errors are left to the
+// binary, not every one of them knows --version.
+func smokeTestBinary(name, key, path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, "--version")
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+
+	reason := startFailure(err, output.String())
+	if reason == "" {
+		logVerbose("%s --version failed: %v", name, err)
+		return nil
+	}
+
+	return withCode(errCodeVerification, fmt.Errorf("%s does not start on this host: %s, configure %s_STATIC or set ARTIFACT_VARIANT=static", name, reason, key))
+}
+
+// startFailure tells why a binary failed to start, or "" if it started.
+func startFailure(err error, output string) string {
+	if symbol := glibcSymbolVersion.FindString(output); symbol != "" && strings.Contains(output, "not found") {
+		if version := glibcVersion(); version != "" {
+			return fmt.Sprintf("it needs %s, the host has glibc %s", symbol, version)
+		}
+		return fmt.Sprintf("it needs %s", symbol)
+	}
+
+	switch {
+	case errors.Is(err, syscall.ENOEXEC):
+		return "it is built for another platform"
+	case errors.Is(err, os.ErrNotExist):
+		// The binary is there, the loader it names is not
+		return "its dynamic loader is missing, the host uses " + hostLibc()
+	}
+
+	return ""
+}
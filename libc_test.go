@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArtifactURL(t *testing.T) {
+	defer func(variant string, libc, glibc func() string) {
+		artifactVariant, hostLibc, glibcVersion = variant, libc, glibc
+	}(artifactVariant, hostLibc, glibcVersion)
+	artifactVariant = ""
+	hostLibc = func() string { return libcGNU }
+	glibcVersion = func() string { return "2.17" }
+
+	t.Setenv("PROXY_BIN", "https://artifacts/proxy")
+	t.Setenv("PROXY_BIN_STATIC", "https://artifacts/static/proxy")
+	t.Setenv("AGENT_BIN", "https://artifacts/agent")
+	t.Setenv("AGENT_BIN_STATIC", "")
+	t.Setenv("ARTIFACT_VARIANT", variantAuto)
+	t.Setenv("GLIBC_MIN_VERSION", "")
+
+	assert.Equal(t, "https://artifacts/proxy", artifactURL("PROXY_BIN"))
+
+	// CentOS 7 ships glibc 2.17
+	t.Setenv("GLIBC_MIN_VERSION", "2.28")
+	assert.Equal(t, "https://artifacts/static/proxy", artifactURL("PROXY_BIN"))
+	assert.Equal(t, "https://artifacts/agent", artifactURL("AGENT_BIN"))
+
+	artifactVariant = variantGlibc
+	assert.Equal(t, "https://artifacts/proxy", artifactURL("PROXY_BIN"))
+
+	artifactVariant = ""
+	t.Setenv("GLIBC_MIN_VERSION", "")
+	hostLibc = func() string { return libcMusl }
+	assert.Equal(t, "https://artifacts/static/proxy", artifactURL("PROXY_BIN"))
+}
+
+func TestSmokeTestBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs shell scripts")
+	}
+
+	defer func(glibc func() string) {
+		glibcVersion = glibc
+	}(glibcVersion)
+	glibcVersion = func() string { return "2.17" }
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0755))
+		return path
+	}
+
+	assert.NoError(t, smokeTestBinary("proxy", "PROXY_BIN", write("ok", "#!/bin/sh\necho proxy 1.2\n")))
+	assert.NoError(t, smokeTestBinary("proxy", "PROXY_BIN", write("noversion", "#!/bin/sh\necho unknown flag >&2\nexit 2\n")))
+
+	err := smokeTestBinary("proxy", "PROXY_BIN", write("glibc", "#!/bin/sh\necho \"./proxy: /lib64/libc.so.6: version \\`GLIBC_2.28' not found (required by ./proxy)\" >&2\nexit 1\n"))
+	assert.Equal(t, errCodeVerification, errorCodeOf(err))
+	assert.ErrorContains(t, err, "it needs GLIBC_2.28, the host has glibc 2.17, configure PROXY_BIN_STATIC")
+
+	err = smokeTestBinary("proxy", "PROXY_BIN", write("foreign", "\x7fELF garbage"))
+	assert.ErrorContains(t, err, "built for another platform")
+
+	err = smokeTestBinary("proxy", "PROXY_BIN", write("loader", "#!/lib/ld-musl-x86_64.so.1.missing\n"))
+	assert.ErrorContains(t, err, "dynamic loader is missing")
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// hostFilter matches inventory hosts against a --limit expression such as
+// "rack=a AND arch=arm64" or "canary OR (zone=eu* AND NOT arch=arm64)".
+//
+// A term is key=value or key!=value against the host tags, where the value
+// may be a glob and the keys name, address and group are built in, or a bare
+// word matching a group or host name. NOT binds tighter than AND, AND
+// tighter than OR, and adjacent terms are joined with AND.
+type hostFilter func(h inventoryHost) bool
+
+func parseLimit(expr string) (hostFilter, error) {
+	p := &limitParser{tokens: tokenizeLimit(expr)}
+	if len(p.tokens) == 0 {
+		return func(inventoryHost) bool { return true }, nil
+	}
+
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid --limit %q: %w", expr, err)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid --limit %q: unexpected %q", expr, p.tokens[p.pos])
+	}
+
+	return filter, nil
+}
+
+func tokenizeLimit(expr string) []string {
+	var tokens []string
+	var word strings.Builder
+
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+
+	for _, r := range expr {
+		switch {
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		case r == ' ' || r == '\t' || r == '\n':
+			flush()
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+type limitParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *limitParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+
+	return ""
+}
+
+func (p *limitParser) isKeyword(keyword string) bool {
+	return strings.EqualFold(p.peek(), keyword)
+}
+
+func (p *limitParser) parseOr() (hostFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(h inventoryHost) bool { return l(h) || right(h) }
+	}
+
+	return left, nil
+}
+
+func (p *limitParser) parseAnd() (hostFilter, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.pos < len(p.tokens) && !p.isKeyword("OR") && p.peek() != ")" {
+		if p.isKeyword("AND") {
+			p.pos++
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(h inventoryHost) bool { return l(h) && right(h) }
+	}
+
+	return left, nil
+}
+
+func (p *limitParser) parseNot() (hostFilter, error) {
+	if p.isKeyword("NOT") {
+		p.pos++
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(h inventoryHost) bool { return !inner(h) }, nil
+	}
+
+	return p.parseTerm()
+}
+
+func (p *limitParser) parseTerm() (hostFilter, error) {
+	token := p.peek()
+
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end")
+	case token == "(":
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return inner, nil
+	case token == ")" || p.isKeyword("AND") || p.isKeyword("OR"):
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+
+	p.pos++
+
+	if key, pattern, ok := strings.Cut(token, "!="); ok {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+		return func(h inventoryHost) bool { return !h.matches(key, pattern) }, nil
+	}
+
+	if key, pattern, ok := strings.Cut(token, "="); ok {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+		return func(h inventoryHost) bool { return h.matches(key, pattern) }, nil
+	}
+
+	if _, err := path.Match(token, ""); err != nil {
+		return nil, err
+	}
+
+	return func(h inventoryHost) bool {
+		return h.matches("group", token) || h.matches("name", token)
+	}, nil
+}
+
+// matches reports whether the host has a value for key that matches the
+// glob pattern.
+func (h inventoryHost) matches(key, pattern string) bool {
+	var values []string
+
+	switch key {
+	case "name":
+		values = []string{h.Name}
+	case "address":
+		values = []string{h.Address}
+	case "group":
+		values = h.Groups
+	default:
+		if value, ok := h.Tags[key]; ok {
+			values = []string{value}
+		}
+	}
+
+	for _, value := range values {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterHosts keeps the hosts matching the --limit expression, matching no
+// host at all is an error rather than a silent no-op.
+func filterHosts(hosts []inventoryHost, expr string) ([]inventoryHost, error) {
+	if strings.TrimSpace(expr) == "" {
+		return hosts, nil
+	}
+
+	filter, err := parseLimit(expr)
+	if err != nil {
+		return nil, withCode(errCodeUsage, err)
+	}
+
+	var selected []inventoryHost
+	for _, h := range hosts {
+		if filter(h) {
+			selected = append(selected, h)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, withCode(errCodeUsage, fmt.Errorf("--limit %q matched no inventory host", expr))
+	}
+
+	return selected, nil
+}
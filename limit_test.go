@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterHosts(t *testing.T) {
+	hosts := []inventoryHost{
+		{Name: "a1", Address: "10.0.0.1", Tags: map[string]string{"rack": "a", "arch": "amd64", "zone": "eu-west"}, Groups: []string{"canary"}},
+		{Name: "a2", Address: "10.0.0.2", Tags: map[string]string{"rack": "a", "arch": "arm64", "zone": "eu-north"}},
+		{Name: "b1", Address: "10.0.1.1", Tags: map[string]string{"rack": "b", "arch": "arm64", "zone": "us-east"}},
+		{Name: "b2", Address: "10.0.1.2", Tags: map[string]string{"rack": "b", "arch": "amd64"}},
+	}
+
+	names := func(selected []inventoryHost) []string {
+		var result []string
+		for _, h := range selected {
+			result = append(result, h.Name)
+		}
+		return result
+	}
+
+	for expr, want := range map[string][]string{
+		"":                                  {"a1", "a2", "b1", "b2"},
+		"rack=a AND arch=arm64":             {"a2"},
+		"rack=a and arch=arm64":             {"a2"},
+		"rack=a arch=arm64":                 {"a2"},
+		"rack=a OR arch=arm64":              {"a1", "a2", "b1"},
+		"NOT rack=a":                        {"b1", "b2"},
+		"arch!=arm64":                       {"a1", "b2"},
+		"zone=eu-*":                         {"a1", "a2"},
+		"canary OR (rack=b AND NOT zone=*)": {"a1", "b2"},
+		"group=canary":                      {"a1"},
+		"b1":                                {"b1"},
+		"address=10.0.1.*":                  {"b1", "b2"},
+		"rack=a AND arch=arm64 OR name=b2":  {"a2", "b2"},
+	} {
+		selected, err := filterHosts(hosts, expr)
+		assert.NoError(t, err, expr)
+		assert.Equal(t, want, names(selected), expr)
+	}
+
+	for _, expr := range []string{"rack=a AND", "(rack=a", "rack=a)", "OR rack=a", "rack=[", "NOT"} {
+		_, err := filterHosts(hosts, expr)
+		assert.Equal(t, errCodeUsage, errorCodeOf(err), expr)
+	}
+
+	_, err := filterHosts(hosts, "rack=c")
+	assert.ErrorContains(t, err, "matched no inventory host")
+}
+
+func TestLoadInventoryTags(t *testing.T) {
+	inv, err := loadInventory(writeInventory(t, `{
+		"defaults": {"tags": {"site": "muc", "arch": "amd64"}},
+		"groups": {"canary": ["a"]},
+		"hosts": [
+			{"name": "a", "address": "10.0.0.1"},
+			{"name": "b", "address": "10.0.0.2", "tags": {"arch": "arm64"}, "groups": ["gpu"]}
+		]
+	}`))
+	assert.NoError(t, err)
+
+	if assert.Len(t, inv.Hosts, 2) {
+		assert.Equal(t, map[string]string{"site": "muc", "arch": "amd64"}, inv.Hosts[0].Tags)
+		assert.Equal(t, []string{"canary"}, inv.Hosts[0].Groups)
+		assert.Equal(t, map[string]string{"site": "muc", "arch": "arm64"}, inv.Hosts[1].Tags)
+		assert.Equal(t, []string{"gpu"}, inv.Hosts[1].Groups)
+	}
+
+	_, err = loadInventory(writeInventory(t, `{"groups": {"canary": ["c"]}, "hosts": [{"address": "a"}]}`))
+	assert.Equal(t, errCodeUsage, errorCodeOf(err))
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// hostLinkDir is where the distbuild binaries are linked for every user.
+const hostLinkDir = "/usr/local/bin"
+
+// userLinkDir is the bin directory of --user links.
+const userLinkDir = "~/.local/bin"
+
+// shimMarker starts the second line of a generated shim, followed by the
+// binary the shim runs.
+const shimMarker = "# distbuild shim for "
+
+const (
+	linkKindLink = "link"
+	linkKindShim = "shim"
+
+	linkStateOK      = "ok"
+	linkStateMissing = "missing"
+	linkStateStale   = "stale"
+	linkStateForeign = "foreign"
+)
+
+var (
+	linkDir   string
+	linkUser  bool
+	linkShims bool
+)
+
+var linksCmd = &cobra.Command{
+	Use:   "links",
+	Short: "manage the links of the distbuild binaries on the PATH",
+	Long: `Manage the links of the installed distbuild binaries in /usr/local/bin,
+or with --user in ~/.local/bin. With --shim small wrapper scripts are
+installed instead, they set the build environment before running the binary.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return checkLinksFlags()
+	},
+}
+
+var linksInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "link or shim every installed binary and drop stale links",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := installLinks(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+var linksRemoveCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "remove the links and shims of this distbuild path",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := removeLinks(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+var linksStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "show the links of the installed binaries and the ones pointing at removed binaries",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := printLinkStatus(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	linksCmd.PersistentFlags().StringVar(&distbuildPath, "distbuild-path", "", "distbuild binaries path")
+	linksCmd.PersistentFlags().StringVar(&linkDir, "bin-dir", "", "directory of the links (default "+hostLinkDir+")")
+	linksCmd.PersistentFlags().BoolVar(&linkUser, "user", false, "use "+userLinkDir+", no sudo needed")
+	linksInstallCmd.Flags().BoolVar(&linkShims, "shim", false, "install wrapper scripts setting the build environment instead of symlinks")
+
+	_ = linksCmd.MarkPersistentFlagRequired("distbuild-path")
+	linksCmd.MarkFlagsMutuallyExclusive("bin-dir", "user")
+
+	linksCmd.AddCommand(linksInstallCmd)
+	linksCmd.AddCommand(linksRemoveCmd)
+	linksCmd.AddCommand(linksStatusCmd)
+}
+
+func checkLinksFlags() error {
+	if runtime.GOOS == "windows" {
+		return withCode(errCodeUsage, fmt.Errorf("links are not supported on windows, use `bootstrap env --export`"))
+	}
+
+	var err error
+
+	if distbuildPath, err = expandPath(distbuildPath); err != nil {
+		return withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err))
+	}
+
+	switch {
+	case linkUser:
+		linkDir = userLinkDir
+	case linkDir == "":
+		linkDir = hostLinkDir
+	}
+
+	if linkDir, err = expandPath(linkDir); err != nil {
+		return withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err))
+	}
+
+	return nil
+}
+
+type linkStatus struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Kind   string `json:"kind,omitempty"`
+	Target string `json:"target,omitempty"`
+	State  string `json:"state"`
+}
+
+// linkedComponents lists the installed binaries that get a link. The agent
+// is run by its service and has none.
+func linkedComponents() ([]string, error) {
+	manifest, err := loadManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, c := range manifest.sortedComponents() {
+		if c.Name != "agent" {
+			names = append(names, c.Name)
+		}
+	}
+
+	return names, nil
+}
+
+func binaryPath(name string) string {
+	return filepath.Join(distbuildPath, "boong", "bin", name)
+}
+
+// shimTarget returns the binary the shim at path runs, empty when path is
+// no shim.
+func shimTarget(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	scanner := bufio.NewScanner(f)
+	for line := 0; line < 2 && scanner.Scan(); line++ {
+		if target, ok := strings.CutPrefix(scanner.Text(), shimMarker); ok {
+			return target
+		}
+	}
+
+	return ""
+}
+
+// inspectLink tells what is at path and whether it runs one of the
+// binaries of this distbuild path.
+func inspectLink(name, path string) linkStatus {
+	status := linkStatus{Name: name, Path: path, State: linkStateMissing}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return status
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		status.Kind = linkKindLink
+		status.Target, _ = os.Readlink(path)
+	} else if target := shimTarget(path); target != "" {
+		status.Kind, status.Target = linkKindShim, target
+	}
+
+	switch {
+	case !ownedLink(status):
+		status.State = linkStateForeign
+	case !regularFile(status.Target):
+		status.State = linkStateStale
+	default:
+		status.State = linkStateOK
+	}
+
+	return status
+}
+
+// ownedLink tells whether the link or shim runs a binary of this distbuild
+// path.
+func ownedLink(status linkStatus) bool {
+	return status.Kind != "" && filepath.Dir(status.Target) == filepath.Join(distbuildPath, "boong", "bin")
+}
+
+func regularFile(path string) bool {
+	info, err := os.Stat(path)
+
+	return err == nil && info.Mode().IsRegular()
+}
+
+// collectLinkStatus reports the link of every installed binary, plus the
+// links in the directory that point at binaries no longer installed.
+func collectLinkStatus() ([]linkStatus, error) {
+	names, err := linkedComponents()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []linkStatus
+	for _, name := range names {
+		statuses = append(statuses, inspectLink(name, filepath.Join(linkDir, name)))
+	}
+
+	entries, err := os.ReadDir(linkDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if slices.Contains(names, entry.Name()) || entry.IsDir() {
+			continue
+		}
+		status := inspectLink(entry.Name(), filepath.Join(linkDir, entry.Name()))
+		if status.State == linkStateStale {
+			statuses = append(statuses, status)
+		}
+	}
+
+	return statuses, nil
+}
+
+func printLinkStatus() error {
+	statuses, err := collectLinkStatus()
+	if err != nil {
+		return err
+	}
+
+	if outputFormat == outputJSON {
+		data, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, msg("links.header"))
+	for _, s := range statuses {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.Name, s.Path, s.Kind, s.State, s.Target)
+	}
+
+	return w.Flush()
+}
+
+// installLinks links or shims every installed binary. Links and shims of
+// another distbuild path or not made by bootstrap are left alone.
+func installLinks() error {
+	statuses, err := collectLinkStatus()
+	if err != nil {
+		return err
+	}
+
+	env, err := shimEnv()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range statuses {
+		target := binaryPath(s.Name)
+		switch {
+		case s.State == linkStateForeign:
+			warn("links.foreign", "path", s.Path)
+		case s.State == linkStateStale && !regularFile(target):
+			// Left behind by a binary that is no longer installed
+			if err := removeLink(s.Path); err != nil {
+				return err
+			}
+		case linkShims:
+			if err := installShim(s.Path, target, env); err != nil {
+				return err
+			}
+		case s.Kind != linkKindLink || s.State != linkStateOK:
+			if err := installLink(s.Path, target); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// removeLinks removes every link and shim running a binary of this
+// distbuild path.
+func removeLinks() error {
+	statuses, err := collectLinkStatus()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range statuses {
+		if s.State == linkStateOK || s.State == linkStateStale {
+			if err := removeLink(s.Path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// shimEnv is the build environment a shim sets, the one `bootstrap env`
+// prints.
+func shimEnv() ([]buildEnvVar, error) {
+	if !linkShims {
+		return nil, nil
+	}
+
+	if err := loadEnvFiles(envFile, envFiles); err != nil {
+		return nil, fmt.Errorf("load .env failed: %w", err)
+	}
+
+	return buildEnv()
+}
+
+func shimScript(target string, env []buildEnvVar) string {
+	var b strings.Builder
+
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString(shimMarker + target + "\n")
+	for _, v := range env {
+		fmt.Fprintf(&b, "export %s=%s\n", v.Key, shellQuote(v.Value))
+	}
+	fmt.Fprintf(&b, "exec %s \"$@\"\n", shellQuote(target))
+
+	return b.String()
+}
+
+// linkDirWritable tells whether the link directory can be changed without
+// sudo.
+func linkDirWritable() bool {
+	if err := createDirs(linkDir); err != nil {
+		return false
+	}
+
+	f, err := os.CreateTemp(linkDir, ".distbuild-*")
+	if err != nil {
+		return false
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+
+	return true
+}
+
+func installLink(path, target string) error {
+	if linkDirWritable() {
+		tmp := path + ".link"
+		_ = os.Remove(tmp)
+		if err := os.Symlink(target, tmp); err != nil {
+			return fmt.Errorf("create symlink failed: %w", err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			_ = os.Remove(tmp)
+			return fmt.Errorf("create symlink failed: %w", err)
+		}
+	} else if err := runPrivileged(exec.Command("sudo", "ln", "-sfn", target, path)); err != nil {
+		return withCode(errCodePermission, fmt.Errorf("create symlink failed: %v [%s]", err, filepath.Base(path)))
+	}
+
+	noteChange("link " + path)
+	printMsg("links.installed", "kind", linkKindLink, "path", path, "target", target)
+
+	return nil
+}
+
+func installShim(path, target string, env []buildEnvVar) error {
+	script := shimScript(target, env)
+
+	if linkDirWritable() {
+		if unchangedFile(path, []byte(script), policyFileMode(0755)) {
+			return nil
+		}
+		if err := writeFileAtomic(path, []byte(script), 0755); err != nil {
+			return fmt.Errorf("write shim failed: %w", err)
+		}
+	} else {
+		changed, err := installFileWithSudo(script, path)
+		if err != nil {
+			return withCode(errCodePermission, fmt.Errorf("write shim failed: %w", err))
+		}
+		if !changed {
+			return nil
+		}
+		if err := runPrivileged(exec.Command("sudo", "chmod", "0755", path)); err != nil {
+			return withCode(errCodePermission, fmt.Errorf("write shim failed: %w", err))
+		}
+	}
+
+	printMsg("links.installed", "kind", linkKindShim, "path", path, "target", target)
+
+	return nil
+}
+
+func removeLink(path string) error {
+	if linkDirWritable() {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove %s failed: %w", path, err)
+		}
+	} else if err := runPrivileged(exec.Command("sudo", "rm", "-f", path)); err != nil {
+		return withCode(errCodePermission, fmt.Errorf("remove %s failed: %w", path, err))
+	}
+
+	noteChange("remove " + path)
+	printMsg("links.removed", "path", path)
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupLinks(t *testing.T) string {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("links are not supported on windows")
+	}
+
+	distbuildPath, stateDirFlag, linkDir, linkShims = t.TempDir(), t.TempDir(), t.TempDir(), false
+
+	manifest := &installManifest{Components: map[string]*manifestComponent{}}
+	for _, name := range []string{"agent", "distninja", "proxy"} {
+		manifest.Components[name] = &manifestComponent{Name: name, Path: binaryPath(name)}
+	}
+	assert.NoError(t, saveManifest(manifest))
+
+	assert.NoError(t, os.MkdirAll(filepath.Dir(binaryPath("proxy")), 0755))
+	for _, name := range []string{"distninja", "proxy"} {
+		assert.NoError(t, os.WriteFile(binaryPath(name), []byte("#!/bin/sh\n"), 0755))
+	}
+
+	return linkDir
+}
+
+func linkStates(t *testing.T) map[string]string {
+	t.Helper()
+
+	statuses, err := collectLinkStatus()
+	assert.NoError(t, err)
+
+	states := map[string]string{}
+	for _, s := range statuses {
+		states[s.Name] = s.Kind + " " + s.State
+	}
+
+	return states
+}
+
+func TestLinksInstallRemove(t *testing.T) {
+	defer func(path, state, dir string, shims bool) {
+		distbuildPath, stateDirFlag, linkDir, linkShims = path, state, dir, shims
+	}(distbuildPath, stateDirFlag, linkDir, linkShims)
+	dir := setupLinks(t)
+
+	assert.Equal(t, map[string]string{"distninja": " missing", "proxy": " missing"}, linkStates(t))
+
+	// A link left by a binary that is no longer installed
+	assert.NoError(t, os.Symlink(binaryPath("oldtool"), filepath.Join(dir, "oldtool")))
+	assert.Equal(t, "link stale", linkStates(t)["oldtool"])
+
+	captureStdout(t, func() { assert.NoError(t, installLinks()) })
+	assert.Equal(t, map[string]string{"distninja": "link ok", "proxy": "link ok"}, linkStates(t))
+	target, err := os.Readlink(filepath.Join(dir, "proxy"))
+	assert.NoError(t, err)
+	assert.Equal(t, binaryPath("proxy"), target)
+
+	// Binaries of other installs are left alone
+	assert.NoError(t, os.Remove(filepath.Join(dir, "distninja")))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "distninja"), []byte("other"), 0755))
+	captureStdout(t, func() { assert.NoError(t, removeLinks()) })
+	assert.Equal(t, map[string]string{"distninja": " foreign", "proxy": " missing"}, linkStates(t))
+	assert.FileExists(t, filepath.Join(dir, "distninja"))
+}
+
+func TestLinksShim(t *testing.T) {
+	defer func(path, state, dir string, shims bool) {
+		distbuildPath, stateDirFlag, linkDir, linkShims = path, state, dir, shims
+	}(distbuildPath, stateDirFlag, linkDir, linkShims)
+	dir := setupLinks(t)
+
+	captureStdout(t, func() { assert.NoError(t, installLinks()) })
+
+	// Links are replaced by shims running the same binary
+	linkShims = true
+	captureStdout(t, func() { assert.NoError(t, installLinks()) })
+	assert.Equal(t, map[string]string{"distninja": "shim ok", "proxy": "shim ok"}, linkStates(t))
+
+	data, err := os.ReadFile(filepath.Join(dir, "proxy"))
+	assert.NoError(t, err)
+	script := string(data)
+	assert.Contains(t, script, shimMarker+binaryPath("proxy")+"\n")
+	assert.Contains(t, script, "export DISTBUILD_PATH="+shellQuote(distbuildPath)+"\n")
+	assert.Contains(t, script, "export DISTBUILD_PROXY="+shellQuote(binaryPath("proxy"))+"\n")
+	assert.Contains(t, script, "exec "+shellQuote(binaryPath("proxy"))+` "$@"`)
+	assert.Equal(t, binaryPath("proxy"), shimTarget(filepath.Join(dir, "proxy")))
+
+	// Nothing to do on a second run
+	out := captureStdout(t, func() { assert.NoError(t, installLinks()) })
+	assert.Empty(t, out)
+
+	// The binary is gone, its shim is stale and dropped on install
+	assert.NoError(t, os.Remove(binaryPath("proxy")))
+	assert.Equal(t, "shim stale", linkStates(t)["proxy"])
+	captureStdout(t, func() { assert.NoError(t, installLinks()) })
+	assert.NoFileExists(t, filepath.Join(dir, "proxy"))
+}
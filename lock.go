@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// lockPollInterval is how often a waiting bootstrap retries a held lock.
+const lockPollInterval = 200 * time.Millisecond
+
+// fileLock is an advisory lock on a lock file. Entries of the toolchain
+// store and the bin directory are shared by every bootstrap on the host,
+// parallel CI jobs take the lock of an entry before they change it. The
+// kernel drops the lock with the process, so a killed bootstrap never
+// leaves one behind.
+type fileLock struct {
+	f *os.File
+}
+
+// lockFile takes the lock at path, waiting for another holder until ctx
+// ends.
+func lockFile(ctx context.Context, path string) (*fileLock, error) {
+	if err := createDirs(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("create lock directory failed: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, policyFileMode(0644))
+	if err != nil {
+		return nil, fmt.Errorf("open lock %s failed: %w", path, err)
+	}
+
+	for waited := false; ; waited = true {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("lock %s failed: %w", path, err)
+		}
+		if locked {
+			return &fileLock{f: f}, nil
+		}
+
+		if !waited {
+			printMsg("lock.waiting", "path", path)
+		}
+
+		select {
+		case <-ctx.Done():
+			_ = f.Close()
+			return nil, withCode(errCodeTimeout, fmt.Errorf("wait for lock %s failed: %w", path, ctx.Err()))
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+func (l *fileLock) unlock() {
+	_ = unlockFile(l.f)
+	_ = l.f.Close()
+}
+
+// withLock runs fn holding the lock at path.
+func withLock(path string, fn func() error) error {
+	lock, err := lockFile(runCtx, path)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	return fn()
+}
+
+// lockBinaries takes the locks of the named binaries in binDir, always in
+// the same order so that two bootstraps cannot wait on each other, and
+// returns the function releasing them.
+func lockBinaries(binDir string, names ...string) (func(), error) {
+	var locks []*fileLock
+	release := func() {
+		for _, lock := range slices.Backward(locks) {
+			lock.unlock()
+		}
+	}
+
+	for _, name := range slices.Compact(slices.Sorted(slices.Values(names))) {
+		lock, err := lockFile(runCtx, filepath.Join(binDir, "."+name+".lock"))
+		if err != nil {
+			release()
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+
+	return release, nil
+}
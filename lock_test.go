@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store", ".lock")
+
+	lock, err := lockFile(context.Background(), path)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*lockPollInterval)
+	defer cancel()
+	_, err = lockFile(ctx, path)
+	assert.Equal(t, errCodeTimeout, errorCodeOf(err))
+
+	// A waiting bootstrap gets the lock once it is released
+	time.AfterFunc(2*lockPollInterval, lock.unlock)
+	lock, err = lockFile(context.Background(), path)
+	assert.NoError(t, err)
+	lock.unlock()
+}
+
+func TestLockBinaries(t *testing.T) {
+	dir := t.TempDir()
+
+	unlock, err := lockBinaries(dir, "proxy", "distninja", "proxy")
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, ".proxy.lock"))
+	assert.FileExists(t, filepath.Join(dir, ".distninja.lock"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), lockPollInterval)
+	defer cancel()
+	_, err = lockFile(ctx, filepath.Join(dir, ".distninja.lock"))
+	assert.Error(t, err)
+
+	unlock()
+	lock, err := lockFile(context.Background(), filepath.Join(dir, ".distninja.lock"))
+	assert.NoError(t, err)
+	lock.unlock()
+}
+
+func TestInstallToolchainParallel(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	defer func(path string, quiet bool) {
+		distbuildPath, noProgress = path, quiet
+	}(distbuildPath, noProgress)
+	distbuildPath, noProgress = t.TempDir(), true
+
+	src := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "clang"), []byte("clang"), 0644))
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "master"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		assert.NoError(t, exec.Command("git", append([]string{"-C", src}, args...)...).Run())
+	}
+
+	// A staging directory of a killed install is cleaned up
+	store := toolchainStoreDir(distbuildPath, "clang")
+	assert.NoError(t, os.MkdirAll(filepath.Join(store, ".staging-1", ".git"), 0755))
+
+	tc := toolchainSpec{Name: "clang", Branch: "master", Path: "prebuilts/clang"}
+	paths := make([]string, 4)
+	errs := make([]error, 4)
+
+	var wg sync.WaitGroup
+	for i := range paths {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			paths[i], errs[i] = installToolchain("file://"+src, tc)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range paths {
+		assert.NoError(t, errs[i])
+		assert.Equal(t, paths[0], paths[i])
+	}
+
+	entries, err := os.ReadDir(store)
+	assert.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{toolchainLockName, filepath.Base(paths[0])}, names)
+	assert.FileExists(t, filepath.Join(distbuildPath, tc.Path, "clang"))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// denialScanBytes bounds how much of the end of each log is searched for
+// denials, audit logs grow large.
+const denialScanBytes = 1 << 20
+
+// agentProcessNames are the command names the agent runs under, as the
+// kernel records them in denials.
+var agentProcessNames = []string{"distbuild-agent", "agent"}
+
+// Paths of the kernel security module state and the logs denials end up in,
+// tests point them elsewhere.
+var (
+	selinuxEnforcePath  = "/sys/fs/selinux/enforce"
+	apparmorEnabledPath = "/sys/module/apparmor/parameters/enabled"
+	denialLogPaths      = []string{"/var/log/audit/audit.log", "/var/log/kern.log", "/var/log/messages"}
+)
+
+func selinuxEnforcing() bool {
+	data, err := os.ReadFile(selinuxEnforcePath)
+
+	return err == nil && strings.TrimSpace(string(data)) == "1"
+}
+
+func apparmorEnabled() bool {
+	data, err := os.ReadFile(apparmorEnabledPath)
+
+	return err == nil && strings.TrimSpace(string(data)) == "Y"
+}
+
+// relabelAgentFiles restores the default SELinux contexts of the installed
+// agent files. They were moved in from a temp directory and keep its
+// context, which keeps systemd from executing the agent.
+func relabelAgentFiles(paths ...string) error {
+	if !selinuxEnforcing() {
+		return nil
+	}
+
+	if _, err := exec.LookPath("restorecon"); err != nil {
+		warn("lsm.no_restorecon", "paths", strings.Join(paths, " "))
+		return nil
+	}
+
+	cmd := exec.Command("sudo", append([]string{"restorecon", "-v"}, paths...)...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := runPrivileged(cmd); err != nil {
+		return fmt.Errorf("restorecon failed: %w\n%s", err, output.String())
+	}
+
+	return nil
+}
+
+// agentDenials returns the SELinux and AppArmor denials logged for the
+// agent process, most recent last.
+func agentDenials() []string {
+	var denials []string
+
+	for _, path := range denialLogPaths {
+		denials = append(denials, scanDenials(path)...)
+	}
+
+	return denials
+}
+
+func scanDenials(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	if info, err := f.Stat(); err == nil && info.Size() > denialScanBytes {
+		if _, err := f.Seek(-denialScanBytes, io.SeekEnd); err != nil {
+			return nil
+		}
+	}
+
+	var denials []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.Contains(line, "avc:  denied") && !strings.Contains(line, `apparmor="DENIED"`) {
+			continue
+		}
+		for _, name := range agentProcessNames {
+			if strings.Contains(line, `comm="`+name+`"`) {
+				denials = append(denials, line)
+				break
+			}
+		}
+	}
+
+	return denials
+}
+
+// explainAgentFailure points at SELinux or AppArmor when they logged
+// denials for the agent, a blocked agent otherwise looks like a crash.
+func explainAgentFailure(err error) error {
+	if !selinuxEnforcing() && !apparmorEnabled() {
+		return err
+	}
+
+	denials := agentDenials()
+	if len(denials) == 0 {
+		return err
+	}
+
+	module := "SELinux"
+	if strings.Contains(denials[len(denials)-1], "apparmor=") {
+		module = "AppArmor"
+	}
+
+	if len(denials) > 3 {
+		denials = denials[len(denials)-3:]
+	}
+
+	return withCode(errCodePermission, fmt.Errorf("%w, %s is blocking the agent:\n%s", err, module, strings.Join(denials, "\n")))
+}
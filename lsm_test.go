@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplainAgentFailure(t *testing.T) {
+	defer func(enforce, apparmor string, logs []string) {
+		selinuxEnforcePath, apparmorEnabledPath, denialLogPaths = enforce, apparmor, logs
+	}(selinuxEnforcePath, apparmorEnabledPath, denialLogPaths)
+
+	dir := t.TempDir()
+	selinuxEnforcePath = filepath.Join(dir, "enforce")
+	apparmorEnabledPath = filepath.Join(dir, "apparmor")
+	auditLog := filepath.Join(dir, "audit.log")
+	denialLogPaths = []string{auditLog, filepath.Join(dir, "missing.log")}
+
+	require.NoError(t, os.WriteFile(auditLog, []byte(
+		`type=AVC msg=audit(1700000000.1:1): avc:  denied  { name_bind } for  pid=42 comm="sshd" src=2222`+"\n"+
+			`type=AVC msg=audit(1700000000.2:2): avc:  denied  { execute } for  pid=43 comm="distbuild-agent" name="libfoo.so"`+"\n"), 0644))
+
+	failure := errors.New("agent on port 9527 unhealthy: connection refused")
+
+	// Denials of a permissive host do not block anything
+	require.NoError(t, os.WriteFile(selinuxEnforcePath, []byte("0\n"), 0644))
+	assert.Equal(t, failure, explainAgentFailure(failure))
+
+	require.NoError(t, os.WriteFile(selinuxEnforcePath, []byte("1\n"), 0644))
+	err := explainAgentFailure(failure)
+	assert.ErrorIs(t, err, failure)
+	assert.Equal(t, errCodePermission, errorCodeOf(err))
+	assert.Contains(t, err.Error(), "SELinux is blocking the agent")
+	assert.Contains(t, err.Error(), `comm="distbuild-agent"`)
+	assert.NotContains(t, err.Error(), `comm="sshd"`)
+
+	require.NoError(t, os.WriteFile(selinuxEnforcePath, []byte("0\n"), 0644))
+	require.NoError(t, os.WriteFile(apparmorEnabledPath, []byte("Y\n"), 0644))
+	require.NoError(t, os.WriteFile(auditLog, []byte(
+		`audit: type=1400 apparmor="DENIED" operation="open" profile="distbuild" name="/srv/cache" comm="agent"`+"\n"), 0644))
+	assert.Contains(t, explainAgentFailure(failure).Error(), "AppArmor is blocking the agent")
+}
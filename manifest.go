@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+const manifestVersion = 1
+
+// installManifest records every artifact bootstrap placed on the host.
+type installManifest struct {
+	Version    int                           `json:"version"`
+	Components map[string]*manifestComponent `json:"components"`
+	Toolchains map[string]*manifestToolchain `json:"toolchains,omitempty"`
+	// ReleaseSet names the release set the components were installed from,
+	// it is cleared as soon as one of them is replaced outside of it.
+	ReleaseSet string `json:"release_set,omitempty"`
+	// Scope is "workspace" for an install inside an AOSP workspace
+	Scope string `json:"scope,omitempty"`
+}
+
+type manifestToolchain struct {
+	Name        string    `json:"name"`
+	Repo        string    `json:"repo"`
+	Path        string    `json:"path"`
+	Revision    string    `json:"revision"`
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+type manifestComponent struct {
+	Name        string          `json:"name"`
+	Version     string          `json:"version,omitempty"`
+	URL         string          `json:"url"`
+	Path        string          `json:"path"`
+	SHA256      string          `json:"sha256"`
+	InstalledAt time.Time       `json:"installed_at"`
+	SBOMFormat  string          `json:"sbom_format,omitempty"`
+	SBOM        json.RawMessage `json:"sbom,omitempty"`
+	Provenance  json.RawMessage `json:"provenance,omitempty"`
+
+	// Slot is the versioned file Path links to, Previous the component
+	// that was active before it and that rollback switches back to.
+	Slot     string             `json:"slot,omitempty"`
+	Previous *manifestComponent `json:"previous,omitempty"`
+}
+
+const manifestFileName = "manifest.json"
+
+func manifestPath() string {
+	return existingStateFile(distbuildPath, manifestFileName)
+}
+
+func loadManifest() (*installManifest, error) {
+	return loadManifestFrom(manifestPath())
+}
+
+func loadManifestFrom(path string) (*installManifest, error) {
+	manifest := &installManifest{
+		Version:    manifestVersion,
+		Components: map[string]*manifestComponent{},
+		Toolchains: map[string]*manifestToolchain{},
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return manifest, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("parse manifest failed: %w", err)
+	}
+
+	if manifest.Components == nil {
+		manifest.Components = map[string]*manifestComponent{}
+	}
+
+	if manifest.Toolchains == nil {
+		manifest.Toolchains = map[string]*manifestToolchain{}
+	}
+
+	return manifest, nil
+}
+
+// lockManifest serializes the updates of the manifest, bootstraps running
+// in parallel would otherwise drop each other's entries.
+func lockManifest() (*fileLock, error) {
+	return lockFile(runCtx, stateFile(distbuildPath, "manifest.lock"))
+}
+
+func saveManifest(manifest *installManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeStateFile(distbuildPath, manifestFileName, data)
+}
+
+func (m *installManifest) sortedComponents() []*manifestComponent {
+	components := make([]*manifestComponent, 0, len(m.Components))
+	for _, c := range m.Components {
+		components = append(components, c)
+	}
+
+	sort.Slice(components, func(i, j int) bool {
+		return components[i].Name < components[j].Name
+	})
+
+	return components
+}
+
+func (m *installManifest) sortedToolchains() []*manifestToolchain {
+	toolchains := make([]*manifestToolchain, 0, len(m.Toolchains))
+	for _, tc := range m.Toolchains {
+		toolchains = append(toolchains, tc)
+	}
+
+	sort.Slice(toolchains, func(i, j int) bool {
+		return toolchains[i].Name < toolchains[j].Name
+	})
+
+	return toolchains
+}
+
+// recordComponent adds an installed artifact to the manifest together with
+// any SBOM and provenance documents published next to it.
+func recordComponent(name, artifactURL, filePath string) error {
+	sum, err := fileSHA256(filePath)
+	if err != nil {
+		return fmt.Errorf("read installed %s failed: %w", name, err)
+	}
+
+	lock, err := lockManifest()
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	manifest, err := loadManifest()
+	if err != nil {
+		return err
+	}
+
+	// The same binary from the same place keeps its entry, install time,
+	// SBOM and provenance included
+	previous := manifest.Components[name]
+	if previous != nil && previous.SHA256 == sum && previous.URL == artifactURL && previous.Path == filePath {
+		recordAction("install", name, map[string]string{"url": redactURL(artifactURL)},
+			map[string]string{"path": filePath, "sha256": sum, "version": previous.Version}, nil)
+		return nil
+	}
+
+	component := &manifestComponent{
+		Name:        name,
+		URL:         artifactURL,
+		Path:        filePath,
+		SHA256:      sum,
+		Version:     binaryVersion(filePath),
+		InstalledAt: time.Now().UTC(),
+	}
+
+	component.SBOMFormat, component.SBOM = fetchSBOM(artifactURL)
+	component.Provenance = fetchProvenance(artifactURL)
+
+	if previous == nil || previous.SHA256 != component.SHA256 {
+		manifest.ReleaseSet = ""
+	}
+	manifest.Components[name] = component
+	recordAction("install", name, map[string]string{"url": redactURL(artifactURL)},
+		map[string]string{"path": filePath, "sha256": component.SHA256, "version": component.Version}, nil)
+
+	return saveManifest(manifest)
+}
+
+// recordToolchain adds a cloned toolchain and its checked out revision to
+// the manifest.
+func recordToolchain(name, repo, path string) error {
+	revision, err := resolveRevision(path)
+	if err != nil {
+		return withCode(errCodeGit, fmt.Errorf("resolve %s revision failed: %w", name, err))
+	}
+
+	lock, err := lockManifest()
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	manifest, err := loadManifest()
+	if err != nil {
+		return err
+	}
+
+	recordAction("toolchain", name, map[string]string{"repo": repo}, map[string]string{"path": path, "revision": revision}, nil)
+
+	if tc := manifest.Toolchains[name]; tc != nil && tc.Repo == repo && tc.Path == path && tc.Revision == revision {
+		return nil
+	}
+
+	manifest.Toolchains[name] = &manifestToolchain{
+		Name:        name,
+		Repo:        repo,
+		Path:        path,
+		Revision:    revision,
+		InstalledAt: time.Now().UTC(),
+	}
+
+	return saveManifest(manifest)
+}
+
+// binaryVersion asks an installed binary for its version, best effort.
+func binaryVersion(path string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path, "--version").Output()
+	if err != nil {
+		return ""
+	}
+
+	lines := strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)
+
+	return strings.TrimSpace(lines[0])
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordComponent(t *testing.T) {
+	defer func(path string) {
+		distbuildPath = path
+	}(distbuildPath)
+	distbuildPath = t.TempDir()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/proxy.cdx.json":
+			_, _ = w.Write([]byte(`{"bomFormat":"CycloneDX"}`))
+		case "/proxy" + provenanceSuffix:
+			_, _ = w.Write([]byte("{\"_type\":\"a\"}\n{\"_type\":\"b\"}\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	binPath := filepath.Join(distbuildPath, "proxy")
+	assert.NoError(t, os.WriteFile(binPath, []byte("proxy"), 0755))
+	assert.NoError(t, recordComponent("proxy", server.URL+"/proxy", binPath))
+
+	manifest, err := loadManifest()
+	assert.NoError(t, err)
+
+	c := manifest.Components["proxy"]
+	assert.NotNil(t, c)
+	assert.Equal(t, sha256Hex([]byte("proxy")), c.SHA256)
+	assert.Equal(t, sbomFormatCycloneDX, c.SBOMFormat)
+	assert.JSONEq(t, `{"bomFormat":"CycloneDX"}`, string(c.SBOM))
+	assert.JSONEq(t, `[{"_type":"a"},{"_type":"b"}]`, string(c.Provenance))
+}
+
+func TestRecordComponentUnchanged(t *testing.T) {
+	defer func(path string) {
+		distbuildPath = path
+	}(distbuildPath)
+	distbuildPath = t.TempDir()
+
+	binPath := filepath.Join(distbuildPath, "proxy")
+	assert.NoError(t, os.WriteFile(binPath, []byte("proxy"), 0755))
+	assert.NoError(t, recordComponent("proxy", "https://example.com/proxy", binPath))
+
+	manifest, err := loadManifest()
+	assert.NoError(t, err)
+	installedAt := manifest.Components["proxy"].InstalledAt
+
+	// The same binary keeps its entry and the manifest is not rewritten
+	info, err := os.Stat(stateFile(distbuildPath, manifestFileName))
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, recordComponent("proxy", "https://example.com/proxy", binPath))
+
+	manifest, err = loadManifest()
+	assert.NoError(t, err)
+	assert.Equal(t, installedAt, manifest.Components["proxy"].InstalledAt)
+	after, err := os.Stat(stateFile(distbuildPath, manifestFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, info.ModTime(), after.ModTime())
+}
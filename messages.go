@@ -0,0 +1,453 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+)
+
+const defaultLanguage = "en"
+
+// messageLanguage is set by --lang, otherwise the locale environment picks
+// the catalog.
+var messageLanguage language
+
+// messages maps a language to its catalog. Entries are text/template bodies
+// filled from the key/value pairs given to msg, a message missing from a
+// catalog falls back to English.
+var messages = map[string]map[string]string{
+	"en": {
+		"warning": "warning: {{.text}}",
+		"error":   "Error: {{.text}}",
+
+		"agent.started":       "agent started in background (pid {{.pid}}, port {{.port}}), log: {{.log}}",
+		"agent.detached":      "supervisor detached (pid {{.pid}}), log: {{.log}}",
+		"agent.supervising":   "supervising agent, log: {{.log}}",
+		"agent.running":       "agent is running (pid {{.pid}}, port {{.port}})",
+		"agent.pidfile":       "write agent pidfile failed: {{.err}}",
+		"agent.stale_pidfile": "removed stale agent pidfile (pid {{.pid}}): {{.reason}}",
+		"agent.stopped":       "agent stopped",
+		"agent.healthy":       "agent healthy (port {{.port}})",
+		"agent.rotate_log":    "rotate agent log failed: {{.err}}",
+		"agent.event_log":     "open event log failed: {{.err}}",
+		"agent.limits":        "agent resource limits are only supported on windows",
+		"agent.pin_linux":     "agent cpu and numa pinning are only supported on linux",
+		"agent.no_numactl":    "numactl not found, pinning the agent to the cpus of numa node {{.node}} without binding its memory",
+		"agent.port_in_use":   "agent port {{.port}} is already in use, using port {{.free}}",
+		"agent.port_systemd":  "--agent-port does not apply to the systemd service, it listens on AGENT_PORT",
+
+		"upgrade.waiting":  "new agent started (pid {{.pid}}, port {{.port}}), waiting for ready...",
+		"upgrade.stop_old": "stop old agent failed: {{.err}}",
+		"upgrade.manifest": "record agent in manifest failed: {{.err}}",
+		"upgrade.done":     "agent upgraded (pid {{.pid}}, port {{.port}})",
+
+		"run.no_changes":    "no changes, the host is already in the desired state",
+		"run.changes":       "{{.count}} change(s) made",
+		"service.installed": "agent service installed and started successfully!",
+		"service.status":    "check status: sudo systemctl status distbuild.service",
+
+		"step.install_service":       "install agent service",
+		"session.recorded":           "session recorded to {{.path}}",
+		"replay.done":                "replayed {{.path}}, installed binaries and toolchains match the recording",
+		"replay.remote":              "replayed {{.path}} on {{.host}}",
+		"replay.mismatch":            "{{.name}} differs from the recording: recorded {{.recorded}}, installed {{.installed}}",
+		"diagnostics.written":        "diagnostic bundle written to {{.path}}, attach it to your support ticket",
+		"diagnostics.partial":        "diagnostic bundle is incomplete: {{.err}}",
+		"health.listening":           "health endpoint listening on http://{{.addr}}/healthz",
+		"health.serve":               "health endpoint stopped: {{.err}}",
+		"server.listening":           "provisioning server listening on {{.url}}",
+		"server.insecure":            "the server on {{.addr}} serves plain http, bearer tokens cross the network unencrypted; pass --tls-cert and --tls-key",
+		"snapshot.created":           "snapshot {{.name}} written to {{.path}}",
+		"snapshot.restored":          "snapshot {{.name}} restored, its config is in {{.env}}, pass it with --env-file",
+		"snapshot.stashed":           "local changes of {{.path}} stashed, git stash pop brings them back",
+		"snapshot.toolchain_missing": "toolchain {{.name}} {{.revision}} is no longer installed, rerun bootstrap with --enable-toolchains",
+		"step.clone":                 "clone {{.repo}}",
+		"step.download":              "download {{.names}}",
+		"step.download_agent":        "download agent",
+		"step.upload":                "upload bootstrap to {{.host}}",
+		"step.install":               "install {{.packages}}",
+		"step.package":               "build {{.format}} package",
+		"step.repo_sync":             "repo sync {{.repo}}",
+		"step.plugin":                "plugin {{.plugin}}: {{.phase}}",
+
+		"diff.header":        "COMPONENT\tCHANGE\tINSTALLED\tSET",
+		"diff.none":          "installed state matches release set {{.set}}",
+		"diff.version":       "version",
+		"diff.digest":        "digest",
+		"diff.missing":       "missing",
+		"diff.extra":         "extra",
+		"env.unset":          "environment variable {{.name}} not set",
+		"workspace.register": "register workspace failed: {{.err}}",
+		"workspace.required": "workspace path or --all is required",
+		"workspace.failed":   "{{.path}}: update failed: {{.err}}\n{{.output}}",
+		"workspace.updated":  "{{.path}}: updated",
+		"workspace.header":   "PATH\tSTATE\tCOMMIT\tUPDATED",
+		"version.header":     "COMPONENT\tVERSION\tDETAIL",
+		"audit.write":        "write audit log failed: {{.err}}",
+		"aosp.buildspec":     "buildspec.mk updated, original saved as {{.backup}}",
+		"aosp.setup":         "source {{.path}} after lunch to use distbuild in a build shell",
+		"cache.configured":   "{{.mode}} cache configured in buildspec.mk",
+		"checksums.unsigned": "--allow-unsigned-checksums set, the checksum manifest signature is not verified",
+		"config.valid":       "config is valid",
+		"policy.locked":      "HOSTS_ALLOW and HOSTS_DENY are ignored, the host policy is set by {{.path}}",
+		"tls.insecure":       "server certificate verification is disabled",
+		"payload.packed":     "packed {{.path}}",
+		"payload.read":       "read embedded payload failed: {{.err}}",
+		"package.built":      "built {{.path}}",
+		"blocks.repaired":    "repaired {{.blocks}} of {{.total}} blocks of {{.file}} ({{.size}})",
+		"delta.fallback":     "delta update failed, falling back to full download: {{.err}} [{{.file}}]",
+		"delta.embedded":     "download failed, installing the embedded binary: {{.err}} [{{.file}}]",
+		"deploy.running":     "running bootstrap on {{.host}} ({{.platform}})",
+		"timings.header":     "timings (total {{.total}}):",
+		"verify.summary":     "{{.count}} finding(s) in {{.path}}",
+		"lsm.no_restorecon":  "SELinux is enforcing but restorecon is missing, relabel the agent files with:\n  sudo restorecon -v {{.paths}}",
+
+		"completion.installed": "{{.shell}} completion installed: {{.path}}",
+		"completion.profile":   "added completion loading to {{.profile}}",
+		"completion.restart":   "restart your shell or run: {{.command}}",
+
+		"firewall.filtered": "{{.firewall}} filters agent port {{.port}}, open it with --open-firewall or:\n{{.commands}}",
+		"firewall.failed":   "open agent port {{.port}} in {{.firewall}} failed: {{.err}}\n{{.output}}",
+		"firewall.opened":   "opened agent port {{.port}}/tcp in {{.firewall}}",
+
+		"watchdog.installed":    "{{.kind}} watchdog installed, checking the agent every {{.interval}}",
+		"watchdog.restarted":    "{{.err}}, restarted it",
+		"scheduler.record":      "record scheduler config failed: {{.err}}",
+		"notify.failed":         "notify {{.sink}} failed: {{.err}}",
+		"notify.unknown":        "unknown notification sink {{.sink}}, want webhook, syslog or smtp",
+		"plugin.optional":       "optional plugin phase {{.phase}} failed: {{.err}}",
+		"fleet.pinned":          "{{.host}} is pinned to agent {{.agent}}",
+		"fleet.batch":           "batch {{.batch}}/{{.batches}}: {{.hosts}}",
+		"fleet.canary":          "canary batch {{.batch}}/{{.batches}}: {{.hosts}}",
+		"fleet.summary":         "upgraded {{.healthy}} of {{.total}} hosts, {{.failed}} failed",
+		"fleet.rollback":        "✓ rollback {{.host}}",
+		"fleet.rollback_failed": "✗ rollback {{.host}}: {{.err}}",
+
+		"preflight.skipped":     "environment variable SCHEDULER_ENDPOINTS not set, skipping scheduler preflight",
+		"preflight.dns":         "hint: check the scheduler hostname and the resolver configuration of this host",
+		"preflight.tcp":         "hint: the host resolves but {{.address}} is unreachable, check firewall rules between this host and the scheduler",
+		"preflight.tls":         "hint: the port is open but the TLS handshake failed, check the scheduler certificate, --client-cert and whether a proxy intercepts TLS",
+		"git.bin_hint":          "hint: install git 2.15 or newer, or point --git-bin or GIT_BIN at one",
+		"git.unknown_version":   "cannot tell the version of {{.bin}}, assuming it has every feature",
+		"git.feature_missing":   "git {{.version}} lacks {{.feature}} (since {{.since}}), {{.fallback}}",
+		"git.no_filter":         "sparse toolchains are cloned with all blobs",
+		"git.no_sparse":         "sparse toolchains are checked out in full",
+		"git.no_cone":           "wildcard patterns rely on non-cone being the default",
+		"git.auth_https":        "hint: git got no credentials for {{.host}}, configure a git credential helper or ~/.netrc for it",
+		"git.auth_ssh":          "hint: {{.host}} rejected the ssh key, load it into ssh-agent or name it in ~/.ssh/config",
+		"git.host_key":          "hint: the ssh host key of {{.host}} is not known, add it to ~/.ssh/known_hosts",
+		"preflight.auth":        "hint: the scheduler rejected the credentials, check AUTH_USER/AUTH_PASS or the client certificate",
+		"owner.root_only":       "--owner only applies when running as root",
+		"owner.root_handed":     "running as root, created files will be handed to {{.user}}",
+		"owner.root_owned":      "running as root, created files will be owned by root",
+		"owner.sudo_handed":     "running under sudo, created files will be handed to {{.user}}",
+		"prompt.confirm":        "{{.question}} [y/N] ",
+		"prompt.removing":       "removing {{.summary}}",
+		"prompt.more":           "  ... and {{.count}} more",
+		"prompt.remove":         "remove {{.summary}}?",
+		"prompt.remove_any":     "{{.err}}. Remove it anyway?",
+		"links.header":          "NAME\tPATH\tKIND\tSTATE\tTARGET",
+		"links.installed":       "installed {{.kind}} {{.path}} -> {{.target}}",
+		"links.removed":         "removed {{.path}}",
+		"links.foreign":         "{{.path}} is not a link of this distbuild path, leaving it",
+		"migrate.confirm":       "migrate the legacy install in {{.path}} to the current layout?",
+		"migrate.would":         "would {{.step}}",
+		"migrate.step":          "{{.step}}",
+		"migrate.none":          "no legacy layout found in {{.path}}",
+		"migrate.done":          "migrated {{.count}} item(s) of {{.path}}, the manifest records the install",
+		"prune.confirm":         "remove {{.count}} toolchain version(s), {{.size}}?",
+		"prune.would_remove":    "would remove {{.name}} {{.revision}} ({{.size}})",
+		"prune.removed":         "removed {{.name}} {{.revision}} ({{.size}})",
+		"prune.would_total":     "{{.count}} version(s) would be removed, {{.size}} reclaimable",
+		"prune.total":           "{{.count}} version(s) removed, {{.size}} reclaimed",
+		"reconcile.started":     "converging to release set {{.set}} every {{.interval}}",
+		"reconcile.failed":      "reconcile failed: {{.err}}, retrying in {{.interval}}",
+		"reconcile.timer":       "systemd timer installed, applying release set {{.set}} every {{.interval}}",
+		"lock.waiting":          "waiting for another bootstrap to release {{.path}}",
+		"release.applied":       "release set {{.set}} installed",
+		"release.restart_agent": "restart the agent to run the agent of the release set",
+		"remote_exec.conflict":  "the workspace builds with {{.found}}, it competes with distbuild for every compile, --remote-exec-conflict disable turns it off",
+		"remote_exec.disabled":  "{{.system}} ({{.source}}) disabled in buildspec.mk and the setup scripts",
+		"rollback.done":         "rolled {{.name}} back to {{.slot}}, {{.from}} kept for rollback",
+	},
+	"zh-CN": {
+		"warning": "警告：{{.text}}",
+		"error":   "错误：{{.text}}",
+
+		"agent.started":       "agent 已在后台启动（pid {{.pid}}，端口 {{.port}}），日志：{{.log}}",
+		"agent.detached":      "守护进程已转入后台（pid {{.pid}}），日志：{{.log}}",
+		"agent.supervising":   "正在守护 agent，日志：{{.log}}",
+		"agent.running":       "agent 正在运行（pid {{.pid}}，端口 {{.port}}）",
+		"agent.pidfile":       "写入 agent pid 文件失败：{{.err}}",
+		"agent.stale_pidfile": "已删除失效的 agent pid 文件（pid {{.pid}}）：{{.reason}}",
+		"agent.stopped":       "agent 已停止",
+		"agent.healthy":       "agent 运行正常（端口 {{.port}}）",
+		"agent.rotate_log":    "轮转 agent 日志失败：{{.err}}",
+		"agent.event_log":     "打开事件日志失败：{{.err}}",
+		"agent.limits":        "agent 资源限制仅支持 Windows",
+		"agent.pin_linux":     "agent 的 CPU 和 NUMA 绑定仅支持 Linux",
+		"agent.no_numactl":    "未找到 numactl，仅将 agent 绑定到 NUMA 节点 {{.node}} 的 CPU，不绑定内存",
+		"agent.port_in_use":   "agent 端口 {{.port}} 已被占用，改用端口 {{.free}}",
+		"agent.port_systemd":  "--agent-port 不适用于 systemd 服务，服务监听 AGENT_PORT",
+
+		"upgrade.waiting":  "新 agent 已启动（pid {{.pid}}，端口 {{.port}}），等待就绪...",
+		"upgrade.stop_old": "停止旧 agent 失败：{{.err}}",
+		"upgrade.manifest": "在清单中记录 agent 失败：{{.err}}",
+		"upgrade.done":     "agent 已升级（pid {{.pid}}，端口 {{.port}}）",
+
+		"run.no_changes":    "无变更，主机已是期望状态",
+		"run.changes":       "已变更 {{.count}} 项",
+		"service.installed": "agent 服务已成功安装并启动！",
+		"service.status":    "查看状态：sudo systemctl status distbuild.service",
+
+		"step.install_service":       "安装 agent 服务",
+		"session.recorded":           "会话已记录到 {{.path}}",
+		"replay.done":                "已重放 {{.path}}，安装的二进制和工具链与记录一致",
+		"replay.remote":              "已在 {{.host}} 上重放 {{.path}}",
+		"replay.mismatch":            "{{.name}} 与记录不一致：记录为 {{.recorded}}，安装为 {{.installed}}",
+		"diagnostics.written":        "诊断包已写入 {{.path}}，请将其附加到支持工单",
+		"diagnostics.partial":        "诊断包不完整：{{.err}}",
+		"health.listening":           "健康检查端点监听于 http://{{.addr}}/healthz",
+		"health.serve":               "健康检查端点已停止：{{.err}}",
+		"server.listening":           "配置服务监听于 {{.url}}",
+		"server.insecure":            "{{.addr}} 上的服务使用明文 http，bearer 令牌将未加密传输；请传入 --tls-cert 和 --tls-key",
+		"snapshot.created":           "快照 {{.name}} 已写入 {{.path}}",
+		"snapshot.restored":          "快照 {{.name}} 已恢复，其配置位于 {{.env}}，请通过 --env-file 使用",
+		"snapshot.stashed":           "{{.path}} 的本地修改已暂存，执行 git stash pop 可恢复",
+		"snapshot.toolchain_missing": "工具链 {{.name}} {{.revision}} 已不在本机，请使用 --enable-toolchains 重新运行 bootstrap",
+		"step.clone":                 "克隆 {{.repo}}",
+		"step.download":              "下载 {{.names}}",
+		"step.download_agent":        "下载 agent",
+		"step.upload":                "上传 bootstrap 到 {{.host}}",
+		"step.install":               "安装 {{.packages}}",
+		"step.package":               "构建 {{.format}} 软件包",
+		"step.repo_sync":             "repo 同步 {{.repo}}",
+		"step.plugin":                "插件 {{.plugin}}：{{.phase}}",
+
+		"diff.header":        "组件\t变更\t已安装\t发布集",
+		"diff.none":          "已安装状态与发布集 {{.set}} 一致",
+		"diff.version":       "版本不同",
+		"diff.digest":        "摘要不同",
+		"diff.missing":       "缺失",
+		"diff.extra":         "多余",
+		"env.unset":          "环境变量 {{.name}} 未设置",
+		"workspace.register": "注册工作区失败：{{.err}}",
+		"workspace.required": "需要指定工作区路径或 --all",
+		"workspace.failed":   "{{.path}}：更新失败：{{.err}}\n{{.output}}",
+		"workspace.updated":  "{{.path}}：已更新",
+		"workspace.header":   "路径\t状态\t提交\t更新时间",
+		"version.header":     "组件\t版本\t详情",
+		"audit.write":        "写入审计日志失败：{{.err}}",
+		"aosp.buildspec":     "buildspec.mk 已更新，原文件保存为 {{.backup}}",
+		"aosp.setup":         "执行 lunch 后运行 source {{.path}} 即可在当前 shell 中启用 distbuild",
+		"cache.configured":   "已在 buildspec.mk 中配置 {{.mode}} 缓存",
+		"checksums.unsigned": "已设置 --allow-unsigned-checksums，校验和清单的签名未经验证",
+		"config.valid":       "配置有效",
+		"policy.locked":      "已忽略 HOSTS_ALLOW 和 HOSTS_DENY，主机策略由 {{.path}} 设定",
+		"tls.insecure":       "已禁用服务器证书验证",
+		"payload.packed":     "已打包 {{.path}}",
+		"payload.read":       "读取内嵌载荷失败：{{.err}}",
+		"package.built":      "已构建 {{.path}}",
+		"blocks.repaired":    "已修复 {{.file}} 的 {{.blocks}}/{{.total}} 个块（{{.size}}）",
+		"delta.fallback":     "增量更新失败，改为完整下载：{{.err}} [{{.file}}]",
+		"delta.embedded":     "下载失败，改为安装内嵌的二进制文件：{{.err}} [{{.file}}]",
+		"deploy.running":     "正在 {{.host}} 上运行 bootstrap（{{.platform}}）",
+		"timings.header":     "耗时（共 {{.total}}）：",
+		"verify.summary":     "{{.path}} 中发现 {{.count}} 个问题",
+		"lsm.no_restorecon":  "SELinux 处于强制模式但缺少 restorecon，请用以下命令重新标记 agent 文件：\n  sudo restorecon -v {{.paths}}",
+
+		"completion.installed": "{{.shell}} 补全已安装：{{.path}}",
+		"completion.profile":   "已在 {{.profile}} 中加载补全",
+		"completion.restart":   "请重启 shell 或运行：{{.command}}",
+
+		"firewall.filtered": "{{.firewall}} 拦截了 agent 端口 {{.port}}，请使用 --open-firewall 或执行：\n{{.commands}}",
+		"firewall.failed":   "在 {{.firewall}} 中放行 agent 端口 {{.port}} 失败：{{.err}}\n{{.output}}",
+		"firewall.opened":   "已在 {{.firewall}} 中放行 agent 端口 {{.port}}/tcp",
+
+		"watchdog.installed":    "已安装 {{.kind}} 看门狗，每 {{.interval}} 检查一次 agent",
+		"watchdog.restarted":    "{{.err}}，已重启",
+		"scheduler.record":      "保存调度器配置失败：{{.err}}",
+		"notify.failed":         "通过 {{.sink}} 发送通知失败：{{.err}}",
+		"notify.unknown":        "未知的通知渠道 {{.sink}}，可选 webhook、syslog 或 smtp",
+		"plugin.optional":       "可选插件阶段 {{.phase}} 失败：{{.err}}",
+		"fleet.pinned":          "{{.host}} 固定使用 agent {{.agent}}",
+		"fleet.batch":           "批次 {{.batch}}/{{.batches}}：{{.hosts}}",
+		"fleet.canary":          "金丝雀批次 {{.batch}}/{{.batches}}：{{.hosts}}",
+		"fleet.summary":         "已升级 {{.healthy}}/{{.total}} 台主机，{{.failed}} 台失败",
+		"fleet.rollback":        "✓ 回滚 {{.host}}",
+		"fleet.rollback_failed": "✗ 回滚 {{.host}}：{{.err}}",
+
+		"preflight.skipped":     "环境变量 SCHEDULER_ENDPOINTS 未设置，跳过调度器预检",
+		"preflight.dns":         "提示：请检查调度器主机名和本机的 DNS 解析配置",
+		"preflight.tcp":         "提示：主机名可以解析但无法连接 {{.address}}，请检查本机与调度器之间的防火墙规则",
+		"preflight.tls":         "提示：端口可达但 TLS 握手失败，请检查调度器证书、--client-cert 以及是否有代理拦截 TLS",
+		"git.bin_hint":          "提示：请安装 git 2.15 或更新版本，或用 --git-bin 或 GIT_BIN 指定",
+		"git.unknown_version":   "无法识别 {{.bin}} 的版本，假定其支持全部特性",
+		"git.feature_missing":   "git {{.version}} 不支持 {{.feature}}（自 {{.since}} 起），{{.fallback}}",
+		"git.no_filter":         "稀疏工具链将克隆全部 blob",
+		"git.no_sparse":         "稀疏工具链将完整检出",
+		"git.no_cone":           "通配模式依赖默认的非 cone 模式",
+		"git.auth_https":        "提示：git 没有 {{.host}} 的凭据，请为其配置 git credential helper 或 ~/.netrc",
+		"git.auth_ssh":          "提示：{{.host}} 拒绝了 ssh 密钥，请将其加入 ssh-agent 或在 ~/.ssh/config 中指定",
+		"git.host_key":          "提示：{{.host}} 的 ssh 主机密钥未知，请将其加入 ~/.ssh/known_hosts",
+		"preflight.auth":        "提示：调度器拒绝了凭据，请检查 AUTH_USER/AUTH_PASS 或客户端证书",
+		"owner.root_only":       "--owner 仅在以 root 运行时生效",
+		"owner.root_handed":     "正在以 root 运行，创建的文件将移交给 {{.user}}",
+		"owner.root_owned":      "正在以 root 运行，创建的文件将归 root 所有",
+		"owner.sudo_handed":     "正在通过 sudo 运行，创建的文件将移交给 {{.user}}",
+		"prompt.confirm":        "{{.question}} [y/N] ",
+		"prompt.removing":       "正在删除 {{.summary}}",
+		"prompt.more":           "  ……以及另外 {{.count}} 项",
+		"prompt.remove":         "删除 {{.summary}}？",
+		"prompt.remove_any":     "{{.err}}。仍要删除吗？",
+		"links.header":          "名称\t路径\t类型\t状态\t目标",
+		"links.installed":       "已安装{{.kind}} {{.path}} -> {{.target}}",
+		"links.removed":         "已删除 {{.path}}",
+		"links.foreign":         "{{.path}} 不是本 distbuild 路径的链接，保持不变",
+		"migrate.confirm":       "将 {{.path}} 中的旧版安装迁移到当前布局？",
+		"migrate.would":         "将{{.step}}",
+		"migrate.step":          "{{.step}}",
+		"migrate.none":          "{{.path}} 中没有旧版布局",
+		"migrate.done":          "已迁移 {{.path}} 的 {{.count}} 项，清单已记录该安装",
+		"prune.confirm":         "删除 {{.count}} 个工具链版本，共 {{.size}}？",
+		"prune.would_remove":    "将删除 {{.name}} {{.revision}}（{{.size}}）",
+		"prune.removed":         "已删除 {{.name}} {{.revision}}（{{.size}}）",
+		"prune.would_total":     "将删除 {{.count}} 个版本，可回收 {{.size}}",
+		"prune.total":           "已删除 {{.count}} 个版本，回收 {{.size}}",
+		"reconcile.started":     "正在持续收敛到发布集 {{.set}}，每 {{.interval}} 一次",
+		"reconcile.failed":      "收敛失败：{{.err}}，{{.interval}} 后重试",
+		"reconcile.timer":       "已安装 systemd 定时器，每 {{.interval}} 应用发布集 {{.set}}",
+		"lock.waiting":          "等待另一个 bootstrap 释放 {{.path}}",
+		"release.applied":       "已安装发布集 {{.set}}",
+		"release.restart_agent": "请重启 agent 以运行发布集中的 agent",
+		"remote_exec.conflict":  "工作区已启用 {{.found}}，它会与 distbuild 争抢编译任务，可使用 --remote-exec-conflict disable 将其关闭",
+		"remote_exec.disabled":  "已在 buildspec.mk 和 setup 脚本中关闭 {{.system}}（{{.source}}）",
+		"rollback.done":         "{{.name}} 已回滚到 {{.slot}}，{{.from}} 保留以便再次回滚",
+	},
+}
+
+// language is a --lang value, it only accepts languages with a catalog.
+type language string
+
+func (l *language) String() string {
+	return string(*l)
+}
+
+func (l *language) Set(value string) error {
+	tag, ok := matchLanguage(value)
+	if !ok {
+		return fmt.Errorf("want one of %s", strings.Join(supportedLanguages(), ", "))
+	}
+
+	*l = language(tag)
+
+	return nil
+}
+
+func (l *language) Type() string {
+	return "lang"
+}
+
+func addLanguageFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().Var(&messageLanguage, "lang", "language of messages: "+strings.Join(supportedLanguages(), ", ")+" (default from LANG)")
+}
+
+func supportedLanguages() []string {
+	tags := make([]string, 0, len(messages))
+	for tag := range messages {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	return tags
+}
+
+// matchLanguage maps a flag value or a locale such as zh_CN.UTF-8 onto a
+// catalog, trying the full tag before the bare language.
+func matchLanguage(value string) (string, bool) {
+	value, _, _ = strings.Cut(value, ".")
+	value, _, _ = strings.Cut(value, "@")
+	value = strings.ReplaceAll(value, "_", "-")
+
+	base, _, _ := strings.Cut(value, "-")
+	for _, tag := range supportedLanguages() {
+		if strings.EqualFold(tag, value) {
+			return tag, true
+		}
+	}
+	for _, tag := range supportedLanguages() {
+		if tagBase, _, _ := strings.Cut(tag, "-"); strings.EqualFold(tagBase, base) {
+			return tag, true
+		}
+	}
+
+	return "", false
+}
+
+// currentLanguage follows the POSIX precedence of the locale variables, an
+// unsupported locale falls back to English.
+func currentLanguage() string {
+	if messageLanguage != "" {
+		return string(messageLanguage)
+	}
+
+	for _, key := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(key); value != "" {
+			if tag, ok := matchLanguage(value); ok {
+				return tag
+			}
+			break
+		}
+	}
+
+	return defaultLanguage
+}
+
+// msg renders message id in the current language. kv are alternating
+// placeholder names and values.
+func msg(id string, kv ...interface{}) string {
+	data := make(map[string]interface{}, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		data[fmt.Sprint(kv[i])] = kv[i+1]
+	}
+
+	for _, tag := range []string{currentLanguage(), defaultLanguage} {
+		if text, ok := renderMessage(messages[tag][id], data); ok {
+			return text
+		}
+	}
+
+	return id
+}
+
+func renderMessage(body string, data map[string]interface{}) (string, bool) {
+	if body == "" {
+		return "", false
+	}
+
+	tmpl, err := template.New("").Option("missingkey=error").Parse(body)
+	if err != nil {
+		return "", false
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", false
+	}
+
+	return b.String(), true
+}
+
+// printMsg prints message id on its own line.
+func printMsg(id string, kv ...interface{}) {
+	fmt.Println(msg(id, kv...))
+}
+
+// warn prints message id as a warning.
+func warn(id string, kv ...interface{}) {
+	fmt.Println(msg("warning", "text", msg(id, kv...)))
+}
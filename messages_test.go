@@ -0,0 +1,87 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageCatalogs(t *testing.T) {
+	placeholder := regexp.MustCompile(`{{\.(\w+)}}`)
+	fields := func(body string) []string {
+		var names []string
+		for _, m := range placeholder.FindAllStringSubmatch(body, -1) {
+			names = append(names, m[1])
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	for tag, catalog := range messages {
+		assert.Len(t, catalog, len(messages[defaultLanguage]), tag)
+		for id, body := range catalog {
+			english, ok := messages[defaultLanguage][id]
+			assert.True(t, ok, "%s: %s is not in the english catalog", tag, id)
+			assert.Equal(t, fields(english), fields(body), "%s: %s", tag, id)
+
+			data := map[string]interface{}{}
+			for _, name := range fields(body) {
+				data[name] = name
+			}
+			_, ok = renderMessage(body, data)
+			assert.True(t, ok, "%s: %s does not render", tag, id)
+		}
+	}
+}
+
+func TestMatchLanguage(t *testing.T) {
+	for value, want := range map[string]string{
+		"en":          "en",
+		"en_US.UTF-8": "en",
+		"zh_CN.UTF-8": "zh-CN",
+		"zh-cn":       "zh-CN",
+		"zh_TW":       "zh-CN",
+	} {
+		tag, ok := matchLanguage(value)
+		assert.True(t, ok, value)
+		assert.Equal(t, want, tag, value)
+	}
+
+	for _, value := range []string{"C", "POSIX", "de_DE.UTF-8"} {
+		_, ok := matchLanguage(value)
+		assert.False(t, ok, value)
+	}
+
+	var lang language
+	assert.Error(t, lang.Set("fr"))
+	assert.NoError(t, lang.Set("zh_CN"))
+	assert.Equal(t, "zh-CN", lang.String())
+}
+
+func TestMsg(t *testing.T) {
+	defer func(lang language) {
+		messageLanguage = lang
+	}(messageLanguage)
+
+	t.Setenv("LC_ALL", "")
+	t.Setenv("LC_MESSAGES", "")
+	t.Setenv("LANG", "zh_CN.UTF-8")
+	messageLanguage = ""
+	assert.Equal(t, "zh-CN", currentLanguage())
+	assert.Equal(t, "agent 运行正常（端口 9000）", msg("agent.healthy", "port", 9000))
+
+	t.Setenv("LC_ALL", "C")
+	assert.Equal(t, defaultLanguage, currentLanguage())
+
+	messageLanguage = "zh-CN"
+	assert.Equal(t, "警告：环境变量 AGENT_BIN 未设置", msg("warning", "text", msg("env.unset", "name", "AGENT_BIN")))
+
+	// A missing placeholder falls back to english, an unknown id to itself
+	delete(messages["zh-CN"], "agent.stopped")
+	defer func() { messages["zh-CN"]["agent.stopped"] = "agent 已停止" }()
+	assert.Equal(t, "agent stopped", msg("agent.stopped"))
+	assert.Equal(t, "no.such.message", msg("no.such.message"))
+	assert.Equal(t, "agent.healthy", msg("agent.healthy"))
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// downloads collects the network metrics of every download of the run for
+// the JSON result.
+var downloads downloadRecorder
+
+// downloadMetrics splits a download into the phases that point at the
+// resolver, the network, the server or the disk. Phases the transport did
+// not go through, such as DNS on a reused connection, are zero.
+type downloadMetrics struct {
+	Name       string  `json:"name"`
+	URL        string  `json:"url"`
+	Bytes      int64   `json:"bytes"`
+	DNSMS      float64 `json:"dns_ms"`
+	ConnectMS  float64 `json:"connect_ms"`
+	TLSMS      float64 `json:"tls_ms"`
+	TTFBMS     float64 `json:"ttfb_ms"`
+	TransferMS float64 `json:"transfer_ms"`
+	TotalMS    float64 `json:"total_ms"`
+	// Throughput is in bytes per second over the transfer phase.
+	Throughput float64 `json:"throughput_bytes_per_sec"`
+	Reused     bool    `json:"reused_connection"`
+	Error      string  `json:"error,omitempty"`
+}
+
+type downloadRecorder struct {
+	mu      sync.Mutex
+	metrics []downloadMetrics
+}
+
+func (r *downloadRecorder) record(m downloadMetrics) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+func (r *downloadRecorder) all() []downloadMetrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]downloadMetrics(nil), r.metrics...)
+}
+
+// downloadTrace times one download. Chunked downloads open several
+// connections, the first one of each phase is reported.
+type downloadTrace struct {
+	mu    sync.Mutex
+	name  string
+	url   string
+	bytes atomic.Int64
+
+	start, dnsStart, dnsDone, connectStart, connectDone time.Time
+	tlsStart, tlsDone, firstByte                        time.Time
+	reused                                              bool
+}
+
+func newDownloadTrace(name, url string) *downloadTrace {
+	return &downloadTrace{name: name, url: url, start: time.Now()}
+}
+
+func setOnce(mu *sync.Mutex, t *time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if t.IsZero() {
+		*t = time.Now()
+	}
+}
+
+// context attaches the trace to the requests made with the returned context.
+func (t *downloadTrace) context(parent context.Context) context.Context {
+	return httptrace.WithClientTrace(parent, &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { setOnce(&t.mu, &t.dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { setOnce(&t.mu, &t.dnsDone) },
+		ConnectStart:      func(string, string) { setOnce(&t.mu, &t.connectStart) },
+		ConnectDone:       func(string, string, error) { setOnce(&t.mu, &t.connectDone) },
+		TLSHandshakeStart: func() { setOnce(&t.mu, &t.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { setOnce(&t.mu, &t.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if t.firstByte.IsZero() && info.Reused {
+				t.reused = true
+			}
+		},
+		GotFirstResponseByte: func() { setOnce(&t.mu, &t.firstByte) },
+	})
+}
+
+// reader counts the bytes read from r as transferred.
+func (t *downloadTrace) reader(r io.Reader) io.Reader {
+	return &traceReader{r: r, t: t}
+}
+
+type traceReader struct {
+	r io.Reader
+	t *downloadTrace
+}
+
+func (c *traceReader) Read(buf []byte) (int, error) {
+	n, err := c.r.Read(buf)
+	c.t.bytes.Add(int64(n))
+
+	return n, err
+}
+
+func millis(from, to time.Time) float64 {
+	if from.IsZero() || to.IsZero() || to.Before(from) {
+		return 0
+	}
+
+	return float64(to.Sub(from).Microseconds()) / 1000
+}
+
+// finish records the metrics of the download and logs them in verbose mode.
+func (t *downloadTrace) finish(err error) downloadMetrics {
+	end := time.Now()
+
+	t.mu.Lock()
+	m := downloadMetrics{
+		Name:      t.name,
+		URL:       redactURL(t.url),
+		Bytes:     t.bytes.Load(),
+		DNSMS:     millis(t.dnsStart, t.dnsDone),
+		ConnectMS: millis(t.connectStart, t.connectDone),
+		TLSMS:     millis(t.tlsStart, t.tlsDone),
+		TTFBMS:    millis(t.start, t.firstByte),
+		TotalMS:   millis(t.start, end),
+		Reused:    t.reused,
+	}
+	// Sources without HTTP, such as local files, transfer from the start
+	transferStart := t.firstByte
+	if transferStart.IsZero() {
+		transferStart = t.start
+	}
+	t.mu.Unlock()
+
+	m.TransferMS = millis(transferStart, end)
+	if m.TransferMS > 0 {
+		m.Throughput = float64(m.Bytes) / (m.TransferMS / 1000)
+	}
+	if err != nil {
+		m.Error = err.Error()
+	}
+
+	downloads.record(m)
+
+	logVerbose("download %s: dns %.1fms, connect %.1fms, tls %.1fms, ttfb %.1fms, transfer %.1fms, %d bytes at %s/s",
+		m.Name, m.DNSMS, m.ConnectMS, m.TLSMS, m.TTFBMS, m.TransferMS, m.Bytes, formatBytes(int64(m.Throughput)))
+
+	return m
+}
+
+// redactURL drops credentials and query strings, presigned urls carry
+// their tokens there.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	u.User = nil
+	u.RawQuery = ""
+
+	return u.String()
+}
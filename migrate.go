@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var migrateDryRun bool
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "move an install made by an older bootstrap into the current layout",
+	Long: "Detect the layouts of older bootstrap releases in --distbuild-path and\n" +
+		"migrate them in place, without downloading anything:\n\n" +
+		"  - state files kept in the distbuild path move to the state directory\n" +
+		"  - binaries installed without a manifest entry are recorded\n" +
+		"  - binaries installed before slots move into their slot\n" +
+		"  - toolchains checked out in place move into the versioned store\n" +
+		"  - host links to binaries that moved are rewritten\n\n" +
+		"Afterwards the manifest records the install and later runs update it\n" +
+		"like one made by this release.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := migrateInstall(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	migrateCmd.Flags().StringVar(&distbuildPath, "distbuild-path", "", "distbuild binaries path")
+	migrateCmd.Flags().StringVar(&toolchainManifestSource, "toolchain-manifest", "", "toolchain manifest file or url (default embedded)")
+	migrateCmd.Flags().StringVar(&toolchainHostOS, "toolchain-host-os", "", "host os of the installed toolchains: linux, darwin, windows (default current os)")
+	migrateCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "only print what would be migrated")
+
+	_ = migrateCmd.MarkFlagRequired("distbuild-path")
+}
+
+// migrationStep is one change bringing a legacy install into the current
+// layout.
+type migrationStep struct {
+	what  string
+	apply func() error
+}
+
+// legacyStateNames are the state files older releases kept in the
+// distbuild path.
+var legacyStateNames = []string{
+	manifestFileName, agentStateFileName, agentPidFileName, watchdogStateFileName,
+	schedulerConfigFile, "agent.log", "supervisor.log",
+}
+
+func migrateInstall() error {
+	var err error
+
+	if distbuildPath, err = expandPath(distbuildPath); err != nil {
+		return withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err))
+	}
+
+	if err := checkToolchainHostOS(); err != nil {
+		return withCode(errCodeUsage, err)
+	}
+
+	if err := loadEnvFiles(envFile, envFiles); err != nil {
+		return fmt.Errorf("load .env failed: %w", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(distbuildPath, "boong")); err != nil {
+		return withCode(errCodeUsage, fmt.Errorf("no install found in %s: %w", distbuildPath, err))
+	}
+
+	// Detection goes phase by phase, each one sees the layout the previous
+	// one left
+	phases := []func() ([]migrationStep, error){
+		legacyStateSteps, legacyBinarySteps, legacyToolchainSteps, legacyLinkSteps,
+	}
+
+	if migrateDryRun {
+		var count int
+		for _, detect := range phases {
+			steps, err := detect()
+			if err != nil {
+				return err
+			}
+			for _, step := range steps {
+				printMsg("migrate.would", "step", step.what)
+			}
+			count += len(steps)
+		}
+		if count == 0 {
+			printMsg("migrate.none", "path", distbuildPath)
+		}
+		return nil
+	}
+
+	if !assumeYes && isInteractive() {
+		ok, err := confirm(msg("migrate.confirm", "path", distbuildPath))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return withCode(errCodeUsage, fmt.Errorf("migration declined, pass --yes to skip the question"))
+		}
+	}
+
+	var count int
+	for _, detect := range phases {
+		steps, err := detect()
+		if err != nil {
+			return err
+		}
+		for _, step := range steps {
+			if err := step.apply(); err != nil {
+				return fmt.Errorf("%s failed: %w", step.what, err)
+			}
+			printMsg("migrate.step", "step", step.what)
+		}
+		count += len(steps)
+	}
+
+	if count == 0 {
+		printMsg("migrate.none", "path", distbuildPath)
+		return nil
+	}
+
+	printMsg("migrate.done", "count", count, "path", distbuildPath)
+
+	return nil
+}
+
+// legacyStateSteps moves state files out of the distbuild path. A file the
+// state directory already has a newer copy of is dropped.
+func legacyStateSteps() ([]migrationStep, error) {
+	var steps []migrationStep
+
+	for _, name := range legacyStateNames {
+		legacy, current := legacyStateFile(distbuildPath, name), stateFile(distbuildPath, name)
+		if legacy == current {
+			continue
+		}
+		if _, err := os.Stat(legacy); err != nil {
+			continue
+		}
+		steps = append(steps, migrationStep{
+			what: fmt.Sprintf("move %s to %s", legacy, current),
+			apply: func() error {
+				if _, err := os.Stat(current); err == nil {
+					return os.Remove(legacy)
+				}
+				return moveStateFile(legacy, current)
+			},
+		})
+	}
+
+	return steps, nil
+}
+
+func moveStateFile(src, dst string) error {
+	if err := createDirs(filepath.Dir(dst)); err != nil {
+		return err
+	}
+
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	// The state directory may be on another filesystem
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(dst, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// legacyBinarySteps records binaries the manifest does not know and moves
+// the ones installed before slots into their slot.
+func legacyBinarySteps() ([]migrationStep, error) {
+	manifest, err := loadManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	binDir := filepath.Join(distbuildPath, "boong", "bin")
+
+	var steps []migrationStep
+	for _, name := range []string{"agent", "proxy", "distninja"} {
+		link := filepath.Join(binDir, name)
+		if name == "agent" {
+			link = agentBinaryPath()
+		}
+		info, err := os.Lstat(link)
+		if err != nil {
+			continue
+		}
+
+		if manifest.Components[name] == nil {
+			steps = append(steps, migrationStep{
+				what:  fmt.Sprintf("record %s in the manifest", link),
+				apply: func() error { return recordComponent(name, os.Getenv(strings.ToUpper(name)+"_BIN"), link) },
+			})
+		}
+
+		// The agent is replaced in place and has no slots
+		if name != "agent" && info.Mode().IsRegular() {
+			steps = append(steps, migrationStep{
+				what:  fmt.Sprintf("move %s into its slot", link),
+				apply: func() error { return adoptUnslotted(name, link) },
+			})
+		}
+	}
+
+	return steps, nil
+}
+
+// legacyToolchainSteps moves toolchains checked out at their manifest path
+// into the versioned store and links them back, and records toolchains the
+// manifest does not know.
+func legacyToolchainSteps() ([]migrationStep, error) {
+	specs, err := loadToolchainManifest(toolchainManifestSource)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := loadManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []migrationStep
+	for _, tc := range specs.forHost(toolchainHostOS) {
+		link := filepath.Join(distbuildPath, tc.Path)
+		info, err := os.Lstat(link)
+		if err != nil {
+			continue
+		}
+
+		if info.IsDir() {
+			if _, err := os.Stat(filepath.Join(link, ".git")); err != nil {
+				continue
+			}
+			steps = append(steps, migrationStep{
+				what:  fmt.Sprintf("move toolchain %s into %s", link, toolchainStoreDir(distbuildPath, tc.key())),
+				apply: func() error { return migrateToolchain(tc, link) },
+			})
+			continue
+		}
+
+		if manifest.Toolchains[tc.key()] == nil {
+			steps = append(steps, migrationStep{
+				what:  fmt.Sprintf("record toolchain %s in the manifest", link),
+				apply: func() error { return recordMigratedToolchain(tc, link) },
+			})
+		}
+	}
+
+	return steps, nil
+}
+
+func migrateToolchain(tc toolchainSpec, checkout string) error {
+	revision, err := resolveRevision(checkout)
+	if err != nil {
+		return withCode(errCodeGit, fmt.Errorf("resolve %s revision failed: %w", tc.Name, err))
+	}
+
+	store := toolchainStoreDir(distbuildPath, tc.key())
+	version := filepath.Join(store, revision)
+
+	err = withLock(filepath.Join(store, toolchainLockName), func() error {
+		if _, err := os.Stat(version); err == nil {
+			// The store has the revision already, the checkout is a copy
+			return activateToolchain(version, checkout)
+		}
+		if err := os.Rename(checkout, version); err != nil {
+			return fmt.Errorf("move %s failed: %w", checkout, err)
+		}
+		return activateToolchain(version, checkout)
+	})
+	if err != nil {
+		return err
+	}
+
+	return recordMigratedToolchain(tc, checkout)
+}
+
+// recordMigratedToolchain records the toolchain with the origin it was
+// cloned from.
+func recordMigratedToolchain(tc toolchainSpec, link string) error {
+	out, err := gitOutput("-C", link, "remote", "get-url", "origin")
+	if err != nil {
+		return withCode(errCodeGit, fmt.Errorf("read %s origin failed: %w", tc.Name, err))
+	}
+
+	return recordToolchain(tc.key(), strings.TrimSpace(string(out)), link)
+}
+
+// legacyLinkSteps rewrites host links that point into the distbuild path
+// at anything but the binary link, such as a binary moved into its slot.
+func legacyLinkSteps() ([]migrationStep, error) {
+	if !linkHostBinaries() {
+		return nil, nil
+	}
+
+	var steps []migrationStep
+	for _, name := range []string{"proxy", "distninja"} {
+		link := filepath.Join(hostLinkDir, name)
+		target, err := os.Readlink(link)
+		if err != nil || target == binaryPath(name) {
+			continue
+		}
+		if !strings.HasPrefix(target, distbuildPath+string(filepath.Separator)) {
+			continue
+		}
+		if _, err := os.Stat(binaryPath(name)); err != nil {
+			continue
+		}
+		steps = append(steps, migrationStep{
+			what:  fmt.Sprintf("point %s at %s", link, binaryPath(name)),
+			apply: func() error { return createSymlinks(name) },
+		})
+	}
+
+	return steps, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateInstall(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	defer func(path, state, source, hostOS string, dryRun, yes bool) {
+		distbuildPath, stateDirFlag, toolchainManifestSource, toolchainHostOS, migrateDryRun, assumeYes = path, state, source, hostOS, dryRun, yes
+	}(distbuildPath, stateDirFlag, toolchainManifestSource, toolchainHostOS, migrateDryRun, assumeYes)
+	resetChanges()
+	defer resetChanges()
+
+	root := t.TempDir()
+	distbuildPath, stateDirFlag, toolchainHostOS, assumeYes = root, t.TempDir(), "", true
+
+	toolchains := filepath.Join(t.TempDir(), "toolchains.json")
+	assert.NoError(t, os.WriteFile(toolchains, []byte(`{"toolchains":[{"name":"clang","repo":"clang","path":"prebuilts/clang"}]}`), 0644))
+	toolchainManifestSource = toolchains
+
+	// The layout of a release without manifest, slots or toolchain store
+	binDir := filepath.Join(root, "boong", "bin")
+	assert.NoError(t, os.MkdirAll(binDir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(binDir, "proxy"), []byte("proxy"), 0755))
+	assert.NoError(t, os.WriteFile(legacyStateFile(root, agentStateFileName), []byte(`{"port":9470}`), 0644))
+
+	checkout := filepath.Join(root, "prebuilts", "clang")
+	assert.NoError(t, os.MkdirAll(checkout, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(checkout, "clang"), []byte("clang"), 0644))
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "master"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+		{"remote", "add", "origin", "https://git.example.com/clang"},
+	} {
+		assert.NoError(t, exec.Command("git", append([]string{"-C", checkout}, args...)...).Run())
+	}
+	revision, err := resolveRevision(checkout)
+	assert.NoError(t, err)
+
+	migrateDryRun = true
+	out := captureStdout(t, func() { assert.NoError(t, migrateInstall()) })
+	assert.Contains(t, out, msg("migrate.would", "step", "move "+filepath.Join(binDir, "proxy")+" into its slot"))
+	assert.FileExists(t, legacyStateFile(root, agentStateFileName))
+
+	migrateDryRun = false
+	out = captureStdout(t, func() { assert.NoError(t, migrateInstall()) })
+	assert.Contains(t, out, msg("migrate.done", "count", 4, "path", root))
+
+	assert.NoFileExists(t, legacyStateFile(root, agentStateFileName))
+	assert.FileExists(t, stateFile(root, agentStateFileName))
+
+	manifest, err := loadManifest()
+	assert.NoError(t, err)
+	if assert.Contains(t, manifest.Components, "proxy") {
+		slot, err := os.Readlink(filepath.Join(binDir, "proxy"))
+		assert.NoError(t, err)
+		assert.Equal(t, slot, manifest.Components["proxy"].Slot)
+		assert.Equal(t, sha256Hex([]byte("proxy")), manifest.Components["proxy"].SHA256)
+	}
+	if assert.Contains(t, manifest.Toolchains, "clang") {
+		assert.Equal(t, "https://git.example.com/clang", manifest.Toolchains["clang"].Repo)
+		assert.Equal(t, revision, manifest.Toolchains["clang"].Revision)
+	}
+
+	target, err := os.Readlink(checkout)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(toolchainStoreDir(root, "clang"), revision), target)
+	assert.FileExists(t, filepath.Join(checkout, "clang"))
+
+	// A migrated install has nothing left to migrate
+	out = captureStdout(t, func() { assert.NoError(t, migrateInstall()) })
+	assert.Contains(t, out, msg("migrate.none", "path", root))
+}
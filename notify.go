@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	webhookAuto  = "auto"
+	webhookSlack = "slack"
+	webhookTeams = "teams"
+	webhookJSON  = "json"
+)
+
+const webhookTimeout = 10 * time.Second
+
+var (
+	webhookURL    string
+	webhookFormat string
+)
+
+// notification is the outcome of an operation as posted to the webhook.
+type notification struct {
+	Operation  string            `json:"operation"`
+	Status     string            `json:"status"`
+	Host       string            `json:"host"`
+	StartedAt  time.Time         `json:"started_at"`
+	DurationMS int64             `json:"duration_ms"`
+	Versions   map[string]string `json:"versions"`
+	Hosts      []string          `json:"hosts,omitempty"`
+	Failed     []string          `json:"failed,omitempty"`
+	Error      *resultError      `json:"error,omitempty"`
+}
+
+func addWebhookFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&webhookURL, "webhook-url", "", "post the outcome of the operation to this webhook (default WEBHOOK_URL)")
+	cmd.PersistentFlags().StringVar(&webhookFormat, "webhook-format", "", "webhook payload: auto, slack, teams, json (default WEBHOOK_FORMAT, else auto)")
+}
+
+func checkWebhookFlags() error {
+	switch webhookFormat {
+	case "", webhookAuto, webhookSlack, webhookTeams, webhookJSON:
+		return nil
+	}
+
+	return fmt.Errorf("unsupported webhook format %q", webhookFormat)
+}
+
+// newNotification describes an operation that started at start and ended
+// with err.
+func newNotification(operation string, start time.Time, err error) notification {
+	host, _ := os.Hostname()
+
+	n := notification{
+		Operation:  operation,
+		Status:     "ok",
+		Host:       host,
+		StartedAt:  start.UTC(),
+		DurationMS: time.Since(start).Milliseconds(),
+		Versions:   map[string]string{"bootstrap": BuildTime + "-" + CommitID},
+	}
+
+	if distbuildPath != "" {
+		if manifest, err := loadManifest(); err == nil {
+			for _, c := range manifest.sortedComponents() {
+				n.Versions[c.Name] = c.Version
+				if c.Version == "" {
+					n.Versions[c.Name] = "sha256:" + shortHash(c.SHA256)
+				}
+			}
+		}
+	}
+
+	if err != nil {
+		code := errorCodeOf(err)
+		n.Status = "error"
+		n.Error = &resultError{Code: code, Message: err.Error(), ExitCode: exitCodes[code]}
+	}
+
+	return n
+}
+
+// notificationSinks deliver a notification, NOTIFY_SINKS selects them.
+var notificationSinks = map[string]func(notification) error{
+	"webhook": notifyWebhook,
+	"syslog":  notifySyslog,
+	"smtp":    notifySMTP,
+}
+
+// sendNotification hands n to every selected sink. Delivery problems are
+// only warned about, they never change the outcome of the operation.
+func sendNotification(n notification) {
+	for _, name := range selectedSinks() {
+		sink, ok := notificationSinks[name]
+		if !ok {
+			warn("notify.unknown", "sink", name)
+			continue
+		}
+		if err := sink(n); err != nil {
+			warn("notify.failed", "sink", name, "err", err)
+			continue
+		}
+		logVerbose("notified %s", name)
+	}
+}
+
+// selectedSinks are the sinks of NOTIFY_SINKS. Without it the webhook is
+// used once one is configured.
+func selectedSinks() []string {
+	if value := os.Getenv("NOTIFY_SINKS"); value != "" {
+		var sinks []string
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				sinks = append(sinks, name)
+			}
+		}
+		return sinks
+	}
+
+	if webhookTarget() != "" {
+		return []string{"webhook"}
+	}
+
+	return nil
+}
+
+func webhookTarget() string {
+	if webhookURL != "" {
+		return webhookURL
+	}
+
+	return os.Getenv("WEBHOOK_URL")
+}
+
+func notifyWebhook(n notification) error {
+	target := webhookTarget()
+	if target == "" {
+		return fmt.Errorf("WEBHOOK_URL is not set")
+	}
+
+	format := webhookFormat
+	if format == "" {
+		format = os.Getenv("WEBHOOK_FORMAT")
+	}
+
+	if err := postWebhook(target, format, n); err != nil {
+		return fmt.Errorf("post %s failed: %w", redactURL(target), err)
+	}
+
+	return nil
+}
+
+func postWebhook(target, format string, n notification) error {
+	payload, err := webhookPayload(webhookFormatFor(target, format), n)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Transport: httpClient.Transport, Timeout: webhookTimeout}
+	resp, err := client.Post(target, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// webhookFormatFor picks the payload of the auto format from the webhook
+// host.
+func webhookFormatFor(target, format string) string {
+	if format != "" && format != webhookAuto {
+		return format
+	}
+
+	switch {
+	case strings.Contains(target, "hooks.slack.com"):
+		return webhookSlack
+	case strings.Contains(target, ".webhook.office.com"), strings.Contains(target, ".logic.azure.com"):
+		return webhookTeams
+	}
+
+	return webhookJSON
+}
+
+func webhookPayload(format string, n notification) ([]byte, error) {
+	title := notificationTitle(n)
+	facts := notificationFacts(n)
+
+	switch format {
+	case webhookSlack:
+		color := "good"
+		if n.Error != nil {
+			color = "danger"
+		}
+		fields := make([]map[string]interface{}, 0, len(facts))
+		for _, f := range facts {
+			fields = append(fields, map[string]interface{}{"title": f[0], "value": f[1], "short": !strings.Contains(f[1], "\n")})
+		}
+		return json.Marshal(map[string]interface{}{
+			"text":        title,
+			"attachments": []interface{}{map[string]interface{}{"color": color, "fields": fields}},
+		})
+	case webhookTeams:
+		color := "2EB67D"
+		if n.Error != nil {
+			color = "E01E5A"
+		}
+		cardFacts := make([]map[string]string, 0, len(facts))
+		for _, f := range facts {
+			cardFacts = append(cardFacts, map[string]string{"name": f[0], "value": f[1]})
+		}
+		return json.Marshal(map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    title,
+			"title":      title,
+			"themeColor": color,
+			"sections":   []interface{}{map[string]interface{}{"facts": cardFacts}},
+		})
+	case webhookJSON:
+		return json.Marshal(n)
+	}
+
+	return nil, fmt.Errorf("unsupported webhook format %q", format)
+}
+
+func notificationTitle(n notification) string {
+	return fmt.Sprintf("%s on %s: %s", n.Operation, n.Host, n.Status)
+}
+
+// notificationFacts are the name and value pairs the chat formats show.
+func notificationFacts(n notification) [][2]string {
+	facts := [][2]string{
+		{"host", n.Host},
+		{"duration", formatElapsed(time.Duration(n.DurationMS) * time.Millisecond)},
+	}
+
+	versions := make([]string, 0, len(n.Versions))
+	for _, name := range slices.Sorted(maps.Keys(n.Versions)) {
+		versions = append(versions, name+" "+n.Versions[name])
+	}
+	facts = append(facts, [2]string{"versions", strings.Join(versions, "\n")})
+
+	if len(n.Hosts) > 0 {
+		facts = append(facts, [2]string{"hosts", fmt.Sprintf("%d", len(n.Hosts))})
+	}
+	if len(n.Failed) > 0 {
+		facts = append(facts, [2]string{"failed", strings.Join(n.Failed, ", ")})
+	}
+	if n.Error != nil {
+		facts = append(facts, [2]string{"error", fmt.Sprintf("[%s] %s", n.Error.Code, n.Error.Message)})
+	}
+
+	return facts
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifyWebhook(t *testing.T) {
+	defer func(url, format, path string) {
+		webhookURL, webhookFormat, distbuildPath = url, format, path
+	}(webhookURL, webhookFormat, distbuildPath)
+
+	var body []byte
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	distbuildPath = t.TempDir()
+	manifest, err := loadManifest()
+	assert.NoError(t, err)
+	manifest.Components["agent"] = &manifestComponent{Name: "agent", Version: "v1.4.2"}
+	assert.NoError(t, saveManifest(manifest))
+
+	// Without a webhook nothing is posted
+	t.Setenv("WEBHOOK_URL", "")
+	sendNotification(newNotification("bootstrap", time.Now(), nil))
+	assert.Nil(t, body)
+
+	t.Setenv("WEBHOOK_URL", ts.URL)
+	sendNotification(newNotification("bootstrap", time.Now().Add(-time.Minute), withCode(errCodeGit, errors.New("git clone failed"))))
+
+	var n notification
+	assert.NoError(t, json.Unmarshal(body, &n))
+	assert.Equal(t, "bootstrap", n.Operation)
+	assert.Equal(t, "error", n.Status)
+	assert.Equal(t, "v1.4.2", n.Versions["agent"])
+	assert.GreaterOrEqual(t, n.DurationMS, int64(60000))
+	assert.Equal(t, errCodeGit, n.Error.Code)
+	assert.Equal(t, "git clone failed", n.Error.Message)
+
+	webhookURL, webhookFormat = ts.URL, webhookSlack
+	sendNotification(newNotification("fleet upgrade", time.Now(), nil))
+	assert.Contains(t, string(body), `"text":"fleet upgrade on `)
+	assert.Contains(t, string(body), `"color":"good"`)
+
+	status = http.StatusForbidden
+	output := captureStdout(t, func() {
+		sendNotification(newNotification("bootstrap", time.Now(), nil))
+	})
+	assert.Contains(t, output, "warning: notify webhook")
+	assert.Contains(t, output, "403 Forbidden")
+}
+
+func TestWebhookPayload(t *testing.T) {
+	assert.Equal(t, webhookSlack, webhookFormatFor("https://hooks.slack.com/services/T0/B0/x", ""))
+	assert.Equal(t, webhookTeams, webhookFormatFor("https://contoso.webhook.office.com/webhookb2/x", webhookAuto))
+	assert.Equal(t, webhookJSON, webhookFormatFor("https://ops.example.com/hook", ""))
+	assert.Equal(t, webhookJSON, webhookFormatFor("https://hooks.slack.com/services/x", webhookJSON))
+
+	n := notification{
+		Operation: "fleet upgrade",
+		Status:    "error",
+		Host:      "ci-01",
+		Versions:  map[string]string{"bootstrap": "1-abc"},
+		Hosts:     []string{"a", "b"},
+		Failed:    []string{"b"},
+		Error:     &resultError{Code: errCodeNetwork, Message: "rollout halted"},
+	}
+
+	data, err := webhookPayload(webhookTeams, n)
+	assert.NoError(t, err)
+	var card struct {
+		Type       string `json:"@type"`
+		ThemeColor string `json:"themeColor"`
+		Sections   []struct {
+			Facts []struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			} `json:"facts"`
+		} `json:"sections"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &card))
+	assert.Equal(t, "MessageCard", card.Type)
+	assert.Equal(t, "E01E5A", card.ThemeColor)
+	facts := card.Sections[0].Facts
+	assert.Equal(t, "failed", facts[4].Name)
+	assert.Equal(t, "b", facts[4].Value)
+	assert.Equal(t, "[network] rollout halted", facts[5].Value)
+
+	_, err = webhookPayload("xml", n)
+	assert.Error(t, err)
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	packageFormatDeb = "deb"
+	packageFormatRPM = "rpm"
+
+	// packagePrefix holds the packaged binaries, /usr/local/bin links to
+	// them like it links to distbuildPath on bootstrapped hosts.
+	packagePrefix      = "/opt/distbuild/bin"
+	packageServicePath = "/lib/systemd/system/distbuild.service"
+)
+
+var (
+	packageFormat  string
+	packageOutput  string
+	packageName    string
+	packageVersion string
+	packageRelease string
+	packageArch    string
+)
+
+var packageCmd = &cobra.Command{
+	Use:   "package",
+	Short: "build OS packages of the installed components",
+}
+
+var packageBuildCmd = &cobra.Command{
+	Use:   "build",
+	Short: "wrap the installed agent, proxy and distninja into a .deb or .rpm",
+	Long: "Build a .deb or .rpm from the components recorded in the install manifest,\n" +
+		"including the agent service unit, its env file and the /usr/local/bin links.\n" +
+		"Every build carries its own version, so apt and yum can roll back to an\n" +
+		"earlier package.",
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		if distbuildPath, err = expandPath(distbuildPath); err != nil {
+			exitWithError(withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err)))
+		}
+		if err := loadEnvFiles(envFile, envFiles); err != nil {
+			exitWithError(fmt.Errorf("load .env failed: %w", err))
+		}
+		path, err := buildPackage()
+		if err != nil {
+			exitWithError(err)
+		}
+		printMsg("package.built", "path", path)
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	packageCmd.PersistentFlags().StringVar(&distbuildPath, "distbuild-path", "", "distbuild binaries path")
+	_ = packageCmd.MarkPersistentFlagRequired("distbuild-path")
+
+	packageBuildCmd.Flags().StringVar(&packageFormat, "format", packageFormatDeb, "package format (deb, rpm)")
+	packageBuildCmd.Flags().StringVarP(&packageOutput, "output", "o", ".", "directory to write the package to")
+	packageBuildCmd.Flags().StringVar(&packageName, "name", "distbuild", "package name")
+	packageBuildCmd.Flags().StringVar(&packageVersion, "pkg-version", "", "package version (default the installed agent version)")
+	packageBuildCmd.Flags().StringVar(&packageRelease, "release", "1", "package release, bump it to repackage the same version")
+	packageBuildCmd.Flags().StringVar(&packageArch, "arch", runtime.GOARCH, "target architecture (GOARCH naming)")
+	addAgentLabelFlags(packageBuildCmd)
+
+	packageCmd.AddCommand(packageBuildCmd)
+}
+
+// packageFile is one entry of the package tree. Files come from source or
+// content, links point at link.
+type packageFile struct {
+	target   string
+	source   string
+	content  string
+	link     string
+	mode     os.FileMode
+	conffile bool
+}
+
+// packageSpec carries the metadata shared by both formats.
+type packageSpec struct {
+	name    string
+	version string
+	release string
+	arch    string
+	files   []packageFile
+	service bool
+}
+
+func buildPackage() (string, error) {
+	if packageFormat != packageFormatDeb && packageFormat != packageFormatRPM {
+		return "", withCode(errCodeUsage, fmt.Errorf("unsupported package format %q", packageFormat))
+	}
+
+	output, err := expandPath(packageOutput)
+	if err != nil {
+		return "", withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err))
+	}
+
+	manifest, err := loadManifest()
+	if err != nil {
+		return "", err
+	}
+
+	spec, err := newPackageSpec(manifest)
+	if err != nil {
+		return "", err
+	}
+
+	stage, err := os.MkdirTemp("", "distbuild-package-*")
+	if err != nil {
+		return "", fmt.Errorf("create staging directory failed: %w", err)
+	}
+
+	defer func(stage string) {
+		_ = os.RemoveAll(stage)
+	}(stage)
+
+	root := filepath.Join(stage, "root")
+	if err := stagePackage(root, spec.files); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(output, 0755); err != nil {
+		return "", fmt.Errorf("create output directory failed: %w", err)
+	}
+
+	s := beginStep(msg("step.package", "format", packageFormat))
+
+	var path string
+	if packageFormat == packageFormatDeb {
+		path, err = buildDeb(root, output, spec)
+	} else {
+		path, err = buildRPM(stage, root, output, spec)
+	}
+
+	return path, s.end(err)
+}
+
+func newPackageSpec(manifest *installManifest) (*packageSpec, error) {
+	spec := &packageSpec{name: packageName, release: packageRelease, arch: packageArch}
+
+	var err error
+	if spec.version, err = packageVersionOf(manifest); err != nil {
+		return nil, err
+	}
+	if spec.files, err = packageFiles(manifest); err != nil {
+		return nil, err
+	}
+
+	for _, f := range spec.files {
+		if f.target == packageServicePath {
+			spec.service = true
+		}
+	}
+
+	return spec, nil
+}
+
+var invalidVersionChars = regexp.MustCompile(`[^0-9A-Za-z.+~]+`)
+
+// packageVersionOf returns --pkg-version or the agent version in a form both
+// dpkg and rpm accept, which start with a digit and carry no dashes.
+func packageVersionOf(manifest *installManifest) (string, error) {
+	version := packageVersion
+	if version == "" {
+		if agent := manifest.Components["agent"]; agent != nil {
+			version = agent.Version
+		}
+	}
+
+	version = strings.Trim(invalidVersionChars.ReplaceAllString(strings.TrimPrefix(version, "v"), "."), ".")
+	if version == "" || version[0] < '0' || version[0] > '9' {
+		if packageVersion != "" {
+			return "", withCode(errCodeUsage, fmt.Errorf("invalid --pkg-version %q, it must start with a digit", packageVersion))
+		}
+		return "0.0.0", nil
+	}
+
+	return version, nil
+}
+
+// packageFiles lays out the recorded components the way a bootstrapped host
+// has them, agent service and its env file included.
+func packageFiles(manifest *installManifest) ([]packageFile, error) {
+	var files []packageFile
+
+	names := make([]string, 0, len(manifest.Components))
+	for name := range manifest.Components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		source := manifest.Components[name].Path
+		// A deployed agent was moved out of distbuildPath
+		if _, err := os.Stat(source); err != nil && name == "agent" {
+			source = "/usr/local/bin/distbuild-agent"
+		}
+		if _, err := os.Stat(source); err != nil {
+			return nil, fmt.Errorf("installed %s not found: %w", name, err)
+		}
+
+		link := filepath.Join("/usr/local/bin", name)
+		if name == "agent" {
+			link = "/usr/local/bin/distbuild-agent"
+		}
+
+		target := filepath.Join(packagePrefix, name)
+		files = append(files,
+			packageFile{target: target, source: source, mode: 0755},
+			packageFile{target: link, link: target},
+		)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no installed components recorded in %s", manifestPath())
+	}
+
+	if _, ok := manifest.Components["agent"]; ok {
+		agentEnv, err := agentEnvFileContent()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files,
+			packageFile{target: packageServicePath, content: agentServiceFile, mode: 0644},
+			packageFile{target: agentEnvFilePath, content: agentEnv, mode: 0644, conffile: true},
+		)
+	}
+
+	return files, nil
+}
+
+func stagePackage(root string, files []packageFile) error {
+	for _, f := range files {
+		path := filepath.Join(root, f.target)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("create directory failed: %w", err)
+		}
+
+		switch {
+		case f.link != "":
+			if err := os.Symlink(f.link, path); err != nil {
+				return fmt.Errorf("create symlink failed: %w", err)
+			}
+			continue
+		case f.source != "":
+			data, err := os.ReadFile(f.source)
+			if err != nil {
+				return fmt.Errorf("read %s failed: %w", f.source, err)
+			}
+			if err := os.WriteFile(path, data, f.mode); err != nil {
+				return fmt.Errorf("stage %s failed: %w", f.target, err)
+			}
+		default:
+			if err := os.WriteFile(path, []byte(f.content), f.mode); err != nil {
+				return fmt.Errorf("stage %s failed: %w", f.target, err)
+			}
+		}
+
+		// Keep the mode independent of the umask
+		if err := os.Chmod(path, f.mode); err != nil {
+			return fmt.Errorf("chmod failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Maintainer scripts, shared by both formats. The service is only stopped
+// on removal, upgrades restart it.
+const (
+	packagePostInstall = `if command -v systemctl >/dev/null 2>&1; then
+	systemctl daemon-reload || true
+	systemctl enable distbuild.service || true
+	systemctl restart distbuild.service || true
+fi
+`
+	packagePreRemove = `if command -v systemctl >/dev/null 2>&1; then
+	systemctl disable --now distbuild.service || true
+fi
+`
+)
+
+var debArch = map[string]string{"amd64": "amd64", "arm64": "arm64", "386": "i386", "arm": "armhf"}
+
+var rpmArch = map[string]string{"amd64": "x86_64", "arm64": "aarch64", "386": "i686", "arm": "armv7hl"}
+
+func debControl(spec *packageSpec) string {
+	return fmt.Sprintf(`Package: %s
+Version: %s-%s
+Architecture: %s
+Maintainer: distbuild <distbuild@localhost>
+Section: devel
+Priority: optional
+Description: distbuild components
+ Agent, proxy and distninja as installed by bootstrap.
+`, spec.name, spec.version, spec.release, debArch[spec.arch])
+}
+
+func buildDeb(root, output string, spec *packageSpec) (string, error) {
+	if debArch[spec.arch] == "" {
+		return "", withCode(errCodeUsage, fmt.Errorf("unsupported deb architecture %q", spec.arch))
+	}
+	if _, err := exec.LookPath("dpkg-deb"); err != nil {
+		return "", fmt.Errorf("dpkg-deb not found, install dpkg to build deb packages: %w", err)
+	}
+
+	control := map[string]string{"control": debControl(spec)}
+
+	var conffiles []string
+	for _, f := range spec.files {
+		if f.conffile {
+			conffiles = append(conffiles, f.target)
+		}
+	}
+	if len(conffiles) > 0 {
+		control["conffiles"] = strings.Join(conffiles, "\n") + "\n"
+	}
+	if spec.service {
+		control["postinst"] = "#!/bin/sh\nset -e\n" + packagePostInstall
+		control["prerm"] = "#!/bin/sh\nset -e\nif [ \"$1\" = remove ]; then\n" + packagePreRemove + "fi\n"
+	}
+
+	debianDir := filepath.Join(root, "DEBIAN")
+	if err := os.MkdirAll(debianDir, 0755); err != nil {
+		return "", fmt.Errorf("create directory failed: %w", err)
+	}
+	for name, content := range control {
+		mode := os.FileMode(0644)
+		if name == "postinst" || name == "prerm" {
+			mode = 0755
+		}
+		if err := os.WriteFile(filepath.Join(debianDir, name), []byte(content), mode); err != nil {
+			return "", fmt.Errorf("write %s failed: %w", name, err)
+		}
+		if err := os.Chmod(filepath.Join(debianDir, name), mode); err != nil {
+			return "", fmt.Errorf("chmod failed: %w", err)
+		}
+	}
+
+	path := filepath.Join(output, fmt.Sprintf("%s_%s-%s_%s.deb", spec.name, spec.version, spec.release, debArch[spec.arch]))
+
+	cmd := exec.Command("dpkg-deb", "--root-owner-group", "--build", root, path)
+	var stderr bytes.Buffer
+	cmd.Stdout = commandStderr(&stderr)
+	cmd.Stderr = commandStderr(&stderr)
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("dpkg-deb failed: %v\n%s", err, stderr.String())
+	}
+
+	return path, nil
+}
+
+func rpmSpecFile(root string, spec *packageSpec) string {
+	var files strings.Builder
+	for _, f := range spec.files {
+		if f.conffile {
+			files.WriteString("%config(noreplace) ")
+		}
+		files.WriteString(f.target + "\n")
+	}
+
+	var scripts string
+	if spec.service {
+		scripts = "%post\n" + packagePostInstall + "\n" +
+			"%preun\nif [ \"$1\" -eq 0 ]; then\n" + packagePreRemove + "fi\n"
+	}
+
+	return fmt.Sprintf(`Name: %s
+Version: %s
+Release: %s
+Summary: distbuild components
+License: Proprietary
+AutoReqProv: no
+
+%%description
+Agent, proxy and distninja as installed by bootstrap.
+
+%%install
+cp -a %s/. %%{buildroot}/
+
+%%files
+%%defattr(-,root,root,-)
+%s
+%s`, spec.name, spec.version, spec.release, root, files.String(), scripts)
+}
+
+func buildRPM(stage, root, output string, spec *packageSpec) (string, error) {
+	arch := rpmArch[spec.arch]
+	if arch == "" {
+		return "", withCode(errCodeUsage, fmt.Errorf("unsupported rpm architecture %q", spec.arch))
+	}
+	if _, err := exec.LookPath("rpmbuild"); err != nil {
+		return "", fmt.Errorf("rpmbuild not found, install rpm-build to build rpm packages: %w", err)
+	}
+
+	specPath := filepath.Join(stage, spec.name+".spec")
+	if err := os.WriteFile(specPath, []byte(rpmSpecFile(root, spec)), 0644); err != nil {
+		return "", fmt.Errorf("write spec failed: %w", err)
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.%s.rpm", spec.name, spec.version, spec.release, arch)
+
+	cmd := exec.Command("rpmbuild", "-bb",
+		"--target", arch,
+		"--define", "_topdir "+filepath.Join(stage, "rpmbuild"),
+		"--define", "_rpmdir "+output,
+		"--define", "_build_name_fmt "+name,
+		specPath)
+	var stderr bytes.Buffer
+	cmd.Stdout = commandStderr(&stderr)
+	cmd.Stderr = commandStderr(&stderr)
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("rpmbuild failed: %v\n%s", err, stderr.String())
+	}
+
+	return filepath.Join(output, name), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackageVersionOf(t *testing.T) {
+	defer func(version string) {
+		packageVersion = version
+	}(packageVersion)
+
+	manifest := &installManifest{Components: map[string]*manifestComponent{
+		"agent": {Name: "agent", Version: "v1.4.2-rc1"},
+	}}
+
+	packageVersion = ""
+	version, err := packageVersionOf(manifest)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.4.2.rc1", version)
+
+	version, err = packageVersionOf(&installManifest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "0.0.0", version)
+
+	packageVersion = "2024.05+hotfix"
+	version, err = packageVersionOf(manifest)
+	assert.NoError(t, err)
+	assert.Equal(t, "2024.05+hotfix", version)
+
+	packageVersion = "latest"
+	_, err = packageVersionOf(manifest)
+	assert.Equal(t, errCodeUsage, errorCodeOf(err))
+}
+
+func TestBuildPackage(t *testing.T) {
+	defer func(path, format, output, name, version, release, arch string) {
+		distbuildPath = path
+		packageFormat, packageOutput, packageName = format, output, name
+		packageVersion, packageRelease, packageArch = version, release, arch
+	}(distbuildPath, packageFormat, packageOutput, packageName, packageVersion, packageRelease, packageArch)
+
+	distbuildPath = t.TempDir()
+	binDir := filepath.Join(distbuildPath, "boong", "bin")
+	assert.NoError(t, os.MkdirAll(binDir, 0755))
+
+	manifest := &installManifest{Version: manifestVersion, Components: map[string]*manifestComponent{}}
+	for _, name := range []string{"agent", "proxy"} {
+		path := filepath.Join(binDir, name)
+		assert.NoError(t, os.WriteFile(path, []byte(name), 0755))
+		manifest.Components[name] = &manifestComponent{Name: name, Path: path}
+	}
+	assert.NoError(t, saveManifest(manifest))
+
+	packageName, packageVersion, packageRelease, packageArch = "distbuild", "1.2.0", "3", "amd64"
+
+	spec, err := newPackageSpec(manifest)
+	assert.NoError(t, err)
+	assert.True(t, spec.service)
+
+	targets := map[string]packageFile{}
+	for _, f := range spec.files {
+		targets[f.target] = f
+	}
+	assert.Equal(t, "/opt/distbuild/bin/agent", targets["/usr/local/bin/distbuild-agent"].link)
+	assert.Equal(t, "/opt/distbuild/bin/proxy", targets["/usr/local/bin/proxy"].link)
+	assert.True(t, targets[agentEnvFilePath].conffile)
+	assert.Contains(t, targets[packageServicePath].content, "ExecStart=/usr/local/bin/distbuild-agent")
+
+	specFile := rpmSpecFile("/stage/root", spec)
+	assert.Contains(t, specFile, "Version: 1.2.0\nRelease: 3\n")
+	assert.Contains(t, specFile, "%config(noreplace) "+agentEnvFilePath)
+	assert.Contains(t, specFile, "%preun\n")
+
+	packageFormat = "zip"
+	_, err = buildPackage()
+	assert.Equal(t, errCodeUsage, errorCodeOf(err))
+
+	if _, err := exec.LookPath("dpkg-deb"); err != nil {
+		t.Skip("dpkg-deb not installed")
+	}
+
+	packageFormat, packageOutput = packageFormatDeb, t.TempDir()
+	var path string
+	captureStdout(t, func() {
+		path, err = buildPackage()
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "distbuild_1.2.0-3_amd64.deb", filepath.Base(path))
+
+	fields, err := exec.Command("dpkg-deb", "--field", path, "Package", "Version").Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "Package: distbuild\nVersion: 1.2.0-3\n", string(fields))
+
+	contents, err := exec.Command("dpkg-deb", "--contents", path).Output()
+	assert.NoError(t, err)
+	for _, entry := range []string{"./opt/distbuild/bin/agent", "./usr/local/bin/proxy -> /opt/distbuild/bin/proxy", "./lib/systemd/system/distbuild.service"} {
+		assert.True(t, strings.Contains(string(contents), entry), entry)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// expandPath normalizes a path-like input from a flag, env file or
+// manifest: it expands ~, ~user and $VAR or ${VAR} and makes the result
+// absolute. An empty path stays empty.
+func expandPath(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+
+	var missing []string
+	path = os.Expand(path, func(name string) string {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("undefined variable $%s in path", strings.Join(missing, ", $"))
+	}
+
+	path, err := expandTilde(path)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Abs(path)
+}
+
+// expandTilde resolves a leading ~ to the home of the invoking user and
+// ~name to the home of name.
+func expandTilde(path string) (string, error) {
+	if !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+
+	name, rest, _ := strings.Cut(filepath.ToSlash(path[1:]), "/")
+
+	var usr *user.User
+	var err error
+	if name == "" {
+		usr, err = invokingUser()
+	} else {
+		usr, err = user.Lookup(name)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(usr.HomeDir, filepath.FromSlash(rest)), nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandPath(t *testing.T) {
+	t.Setenv("SUDO_USER", "")
+
+	current, err := user.Current()
+	assert.NoError(t, err)
+
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	t.Setenv("DISTBUILD_ROOT", filepath.Join(current.HomeDir, "distbuild"))
+
+	for input, want := range map[string]string{
+		"":                               "",
+		"~":                              current.HomeDir,
+		"~/aosp":                         filepath.Join(current.HomeDir, "aosp"),
+		"~" + current.Username + "/aosp": filepath.Join(current.HomeDir, "aosp"),
+		"$DISTBUILD_ROOT/bin":            filepath.Join(current.HomeDir, "distbuild", "bin"),
+		"${DISTBUILD_ROOT}/bin":          filepath.Join(current.HomeDir, "distbuild", "bin"),
+		"out/aosp":                       filepath.Join(wd, "out", "aosp"),
+		"/opt/a~b":                       filepath.FromSlash("/opt/a~b"),
+	} {
+		got, err := expandPath(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	_, err = expandPath("$DISTBUILD_UNSET_FOR_TEST/bin")
+	assert.ErrorContains(t, err, "DISTBUILD_UNSET_FOR_TEST")
+
+	_, err = expandPath("~no-such-user-for-test/aosp")
+	assert.Error(t, err)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+// A payload is a zip archive appended to the bootstrap executable, followed
+// by its length and payloadMagic. It carries an env file and prebuilt
+// binaries so that hosts without network can be provisioned on first boot.
+const (
+	payloadMagic   = "DBPAYLD1"
+	payloadTrailer = 8 + len(payloadMagic)
+
+	payloadEnvEntry = "env"
+	payloadBinDir   = "bin/"
+
+	// payloadScheme marks artifact urls served from the payload.
+	payloadScheme = "embedded:"
+)
+
+var (
+	packOutput    string
+	packBase      string
+	packEnvFile   string
+	packArtifacts []string
+)
+
+// payloadExecutable locates the running executable, tests swap it out.
+var payloadExecutable = os.Executable
+
+var (
+	payloadOnce   sync.Once
+	payloadLoaded *payload
+)
+
+type payload struct {
+	env      string
+	binaries map[string]*zip.File
+}
+
+var packCmd = &cobra.Command{
+	Use:   "pack",
+	Short: "build a self-contained bootstrap with embedded artifacts",
+	Long: "Append an env file and prebuilt binaries to a bootstrap executable. The packed\n" +
+		"executable provisions hosts without network from its payload, and still prefers\n" +
+		"the configured urls when they are reachable.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := packPayload(packBase, packOutput, packEnvFile, packArtifacts); err != nil {
+			exitWithError(err)
+		}
+		printMsg("payload.packed", "path", packOutput)
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	packCmd.Flags().StringVarP(&packOutput, "output", "o", "", "packed executable to write")
+	packCmd.Flags().StringVar(&packBase, "base", "", "bootstrap executable to pack (default this executable)")
+	packCmd.Flags().StringVar(&packEnvFile, "env", "", "env file to embed, layered over the built-in defaults")
+	packCmd.Flags().StringArrayVar(&packArtifacts, "artifact", nil, "binary to embed as name=path, e.g. agent=./agent (repeatable)")
+
+	_ = packCmd.MarkFlagRequired("output")
+}
+
+// packPayload writes base with a fresh payload to output, a payload already
+// appended to base is replaced.
+func packPayload(base, output, envPath string, artifacts []string) error {
+	if base == "" {
+		exe, err := payloadExecutable()
+		if err != nil {
+			return fmt.Errorf("locate executable failed: %w", err)
+		}
+		base = exe
+	}
+
+	base, err := expandPath(base)
+	if err != nil {
+		return withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err))
+	}
+	output, err = expandPath(output)
+	if err != nil {
+		return withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err))
+	}
+
+	exe, err := os.ReadFile(base)
+	if err != nil {
+		return fmt.Errorf("read executable failed: %w", err)
+	}
+	exe = stripPayload(exe)
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+
+	if envPath != "" {
+		if err := addPayloadFile(zw, payloadEnvEntry, envPath); err != nil {
+			return err
+		}
+	}
+
+	for _, spec := range artifacts {
+		name, file, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || file == "" || strings.ContainsAny(name, `/\`) {
+			return withCode(errCodeUsage, fmt.Errorf("invalid artifact %q, want name=path", spec))
+		}
+		if err := addPayloadFile(zw, payloadBinDir+name, file); err != nil {
+			return err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("write payload failed: %w", err)
+	}
+
+	trailer := make([]byte, payloadTrailer)
+	binary.LittleEndian.PutUint64(trailer, uint64(archive.Len()))
+	copy(trailer[8:], payloadMagic)
+
+	data := append(append(exe, archive.Bytes()...), trailer...)
+
+	return writeFileAtomic(output, data, 0755)
+}
+
+func addPayloadFile(zw *zip.Writer, name, path string) error {
+	path, err := expandPath(path)
+	if err != nil {
+		return withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read %s failed: %w", name, err)
+	}
+
+	w, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("write payload failed: %w", err)
+	}
+
+	_, err = w.Write(data)
+
+	return err
+}
+
+// payloadBounds returns the offset and length of the archive appended to
+// exe, ok is false when there is none.
+func payloadBounds(exe []byte, size int64) (offset, length int64, ok bool) {
+	if len(exe) < payloadTrailer || string(exe[len(exe)-len(payloadMagic):]) != payloadMagic {
+		return 0, 0, false
+	}
+
+	// nolint:gosec
+	length = int64(binary.LittleEndian.Uint64(exe[len(exe)-payloadTrailer:]))
+	offset = size - int64(payloadTrailer) - length
+	if length <= 0 || offset < 0 {
+		return 0, 0, false
+	}
+
+	return offset, length, true
+}
+
+func stripPayload(exe []byte) []byte {
+	if offset, _, ok := payloadBounds(exe, int64(len(exe))); ok {
+		return exe[:offset]
+	}
+
+	return exe
+}
+
+// loadPayload reads the payload of the running executable once, an
+// executable without one has a nil payload.
+func loadPayload() *payload {
+	payloadOnce.Do(func() {
+		exe, err := payloadExecutable()
+		if err != nil {
+			return
+		}
+		p, err := readPayload(exe)
+		if err != nil {
+			warn("payload.read", "err", err)
+			return
+		}
+		payloadLoaded = p
+	})
+
+	return payloadLoaded
+}
+
+func readPayload(exe string) (*payload, error) {
+	f, err := os.Open(exe)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size() < int64(payloadTrailer) {
+		return nil, nil
+	}
+
+	trailer := make([]byte, payloadTrailer)
+	if _, err := f.ReadAt(trailer, info.Size()-int64(payloadTrailer)); err != nil {
+		return nil, err
+	}
+
+	offset, length, ok := payloadBounds(trailer, info.Size())
+	if !ok {
+		return nil, nil
+	}
+
+	// The archive is kept in memory, the executable may be replaced while
+	// the run is in progress
+	archive := make([]byte, length)
+	if _, err := f.ReadAt(archive, offset); err != nil {
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(archive), length)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &payload{binaries: map[string]*zip.File{}}
+	for _, file := range zr.File {
+		switch {
+		case file.Name == payloadEnvEntry:
+			data, err := readPayloadEntry(file)
+			if err != nil {
+				return nil, err
+			}
+			p.env = string(data)
+		case strings.HasPrefix(file.Name, payloadBinDir):
+			p.binaries[strings.TrimPrefix(file.Name, payloadBinDir)] = file
+		}
+	}
+
+	return p, nil
+}
+
+func readPayloadEntry(file *zip.File) ([]byte, error) {
+	r, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(r io.ReadCloser) {
+		_ = r.Close()
+	}(r)
+
+	return io.ReadAll(r)
+}
+
+// payloadEnv returns the env file embedded by pack.
+func payloadEnv() string {
+	if p := loadPayload(); p != nil {
+		return p.env
+	}
+
+	return ""
+}
+
+// payloadURL returns the artifact url of an embedded binary, or "" when the
+// payload does not carry it.
+func payloadURL(name string) string {
+	if p := loadPayload(); p != nil {
+		if _, ok := p.binaries[name]; ok {
+			return payloadScheme + name
+		}
+	}
+
+	return ""
+}
+
+func isPayloadURL(rawURL string) bool {
+	return strings.HasPrefix(rawURL, payloadScheme)
+}
+
+// extractPayloadBinary installs an embedded binary to filePath. The payload
+// is part of the executable, so it is trusted like the executable itself.
+func extractPayloadBinary(rawURL, filePath string) error {
+	name := strings.TrimPrefix(rawURL, payloadScheme)
+
+	p := loadPayload()
+	if p == nil || p.binaries[name] == nil {
+		return fmt.Errorf("no embedded %s binary", name)
+	}
+
+	data, err := readPayloadEntry(p.binaries[name])
+	if err != nil {
+		return fmt.Errorf("read embedded %s failed: %w", name, err)
+	}
+
+	return writeFileAtomic(filePath, data, 0755)
+}
+
+// payloadFallback returns the embedded replacement of an artifact whose
+// download failed on the network, matched by the name of the installed file.
+func payloadFallback(err error, filePath string) (string, bool) {
+	if errorCodeOf(err) != errCodeNetwork {
+		return "", false
+	}
+
+	name := filepath.Base(filePath)
+	name = strings.TrimSuffix(name, path.Ext(name))
+
+	fallback := payloadURL(name)
+
+	return fallback, fallback != ""
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func usePayloadExecutable(t *testing.T, exe string) {
+	t.Helper()
+
+	executable := payloadExecutable
+	t.Cleanup(func() {
+		payloadExecutable = executable
+		payloadOnce, payloadLoaded = sync.Once{}, nil
+	})
+
+	payloadExecutable = func() (string, error) { return exe, nil }
+	payloadOnce, payloadLoaded = sync.Once{}, nil
+}
+
+func TestPackPayload(t *testing.T) {
+	dir := t.TempDir()
+
+	base := filepath.Join(dir, "bootstrap")
+	assert.NoError(t, os.WriteFile(base, []byte("executable"), 0755))
+	env := filepath.Join(dir, "air-gapped.env")
+	assert.NoError(t, os.WriteFile(env, []byte("REPO_HOST=https://mirror.local\n"), 0644))
+	agent := filepath.Join(dir, "agent-build")
+	assert.NoError(t, os.WriteFile(agent, []byte("agent v1"), 0755))
+
+	packed := filepath.Join(dir, "bootstrap-packed")
+	assert.NoError(t, packPayload(base, packed, env, []string{"agent=" + agent}))
+
+	// Packing a packed executable replaces its payload
+	repacked := filepath.Join(dir, "bootstrap-repacked")
+	assert.NoError(t, packPayload(packed, repacked, "", []string{"agent=" + agent}))
+
+	data, err := os.ReadFile(repacked)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("executable"), stripPayload(data))
+
+	assert.Equal(t, errCodeUsage, errorCodeOf(packPayload(base, packed, "", []string{"agent"})))
+	assert.Equal(t, errCodeUsage, errorCodeOf(packPayload(base, packed, "", []string{"../agent=" + agent})))
+
+	usePayloadExecutable(t, packed)
+
+	assert.Equal(t, "REPO_HOST=https://mirror.local\n", payloadEnv())
+	assert.Equal(t, "embedded:agent", payloadURL("agent"))
+	assert.Empty(t, payloadURL("proxy"))
+
+	entries, err := layerEnvFiles("REPO_HOST=https://example.com\nWRAPPER_REPO=wrapper\n", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://mirror.local", entries["REPO_HOST"].Value)
+	assert.Equal(t, "payload", entries["REPO_HOST"].Source)
+	assert.Equal(t, "wrapper", entries["WRAPPER_REPO"].Value)
+
+	target := filepath.Join(dir, "bin", "agent")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(target), 0755))
+	assert.NoError(t, downloadBinary("agent", payloadURL("agent"), target))
+	installed, err := os.ReadFile(target)
+	assert.NoError(t, err)
+	assert.Equal(t, "agent v1", string(installed))
+}
+
+func TestPayloadWithoutTrailer(t *testing.T) {
+	exe := filepath.Join(t.TempDir(), "bootstrap")
+	assert.NoError(t, os.WriteFile(exe, []byte("plain executable"), 0755))
+
+	usePayloadExecutable(t, exe)
+
+	assert.Empty(t, payloadEnv())
+	assert.Empty(t, payloadURL("agent"))
+	assert.Error(t, extractPayloadBinary("embedded:agent", filepath.Join(t.TempDir(), "agent")))
+}
+
+func TestPayloadFallback(t *testing.T) {
+	dir := t.TempDir()
+
+	base := filepath.Join(dir, "bootstrap")
+	assert.NoError(t, os.WriteFile(base, []byte("executable"), 0755))
+	proxy := filepath.Join(dir, "proxy-build")
+	assert.NoError(t, os.WriteFile(proxy, []byte("proxy v1"), 0755))
+
+	packed := filepath.Join(dir, "bootstrap-packed")
+	assert.NoError(t, packPayload(base, packed, "", []string{"proxy=" + proxy}))
+
+	usePayloadExecutable(t, packed)
+
+	target := filepath.Join(dir, "proxy")
+	output := captureStdout(t, func() {
+		assert.NoError(t, downloadBinary("proxy", "http://127.0.0.1:1/proxy", target))
+	})
+	assert.Contains(t, output, "installing the embedded binary")
+
+	installed, err := os.ReadFile(target)
+	assert.NoError(t, err)
+	assert.Equal(t, "proxy v1", string(installed))
+
+	// Only network failures fall back
+	_, ok := payloadFallback(withCode(errCodeVerification, os.ErrInvalid), target)
+	assert.False(t, ok)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Modes of created directories and files. Executables get the execute bits
+// matching the read bits of fileMode.
+var (
+	dirMode  = permissionMode(0755)
+	fileMode = permissionMode(0644)
+)
+
+// fileOwner is user[:group] handed everything the run created when running
+// as root, instead of the sudo caller.
+var fileOwner string
+
+// permissionMode is an octal flag value such as 0750.
+type permissionMode os.FileMode
+
+func (m *permissionMode) String() string {
+	return fmt.Sprintf("%04o", os.FileMode(*m))
+}
+
+func (m *permissionMode) Set(value string) error {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("want an octal mode such as 0755")
+	}
+
+	*m = permissionMode(mode)
+
+	return nil
+}
+
+func (m *permissionMode) Type() string {
+	return "mode"
+}
+
+func addPermissionFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().Var(&dirMode, "dir-mode", "mode of created directories")
+	cmd.PersistentFlags().Var(&fileMode, "file-mode", "mode of created files, executables add the matching execute bits")
+	cmd.Flags().StringVar(&fileOwner, "owner", "", "user[:group] owning created files when running as root (default the sudo caller)")
+}
+
+// policyFileMode maps the mode a create path asks for onto the policy, only
+// whether the file is executable is kept.
+func policyFileMode(perm os.FileMode) os.FileMode {
+	mode := os.FileMode(fileMode)
+	if perm&0111 != 0 {
+		mode |= (mode & 0444) >> 2
+	}
+
+	return mode
+}
+
+// createDirs creates path and its missing parents with the policy mode,
+// applied explicitly so that the umask does not narrow it.
+func createDirs(path string) error {
+	var missing []string
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		missing = append(missing, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(path, os.FileMode(dirMode)); err != nil {
+		return err
+	}
+
+	for _, dir := range missing {
+		if err := os.Chmod(dir, os.FileMode(dirMode)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lookupFileOwner resolves --owner, the group defaults to the primary group
+// of the user.
+func lookupFileOwner(owner string) (*user.User, error) {
+	name, group, _ := strings.Cut(owner, ":")
+
+	usr, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if group != "" {
+		grp, err := user.LookupGroup(group)
+		if err != nil {
+			return nil, err
+		}
+		usr.Gid = grp.Gid
+	}
+
+	return usr, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissionModeFlag(t *testing.T) {
+	var mode permissionMode
+
+	require.NoError(t, mode.Set("0750"))
+	assert.Equal(t, permissionMode(0750), mode)
+	assert.Equal(t, "0750", mode.String())
+
+	assert.Error(t, mode.Set("0999"))
+	assert.Error(t, mode.Set("01777"))
+	assert.Error(t, mode.Set("rwx"))
+}
+
+func TestPolicyFileMode(t *testing.T) {
+	defer func(mode permissionMode) {
+		fileMode = mode
+	}(fileMode)
+
+	fileMode = 0644
+	assert.Equal(t, os.FileMode(0644), policyFileMode(0600))
+	assert.Equal(t, os.FileMode(0755), policyFileMode(0755))
+
+	fileMode = 0640
+	assert.Equal(t, os.FileMode(0640), policyFileMode(0644))
+	assert.Equal(t, os.FileMode(0750), policyFileMode(0755))
+}
+
+func TestCreateDirs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("directory modes are not enforced on windows")
+	}
+
+	defer func(mode permissionMode) {
+		dirMode = mode
+	}(dirMode)
+
+	root := t.TempDir()
+	require.NoError(t, os.Chmod(root, 0700))
+
+	dirMode = 0775
+	path := filepath.Join(root, "a", "b")
+	require.NoError(t, createDirs(path))
+
+	for _, dir := range []string{filepath.Join(root, "a"), path} {
+		info, err := os.Stat(dir)
+		require.NoError(t, err)
+		// The umask does not narrow the policy
+		assert.Equal(t, os.FileMode(0775), info.Mode().Perm(), dir)
+	}
+
+	// Existing directories are left alone
+	info, err := os.Stat(root)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const agentPidFileName = "agent.pid"
+
+var errAgentNotRunning = errors.New("agent is not running")
+
+var agentStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "show whether the background agent is running",
+	Long: "Show whether the background agent is running. With --output json the\n" +
+		"status of the host is printed instead: agent version, uptime, last\n" +
+		"heartbeat, free disk and toolchain revisions, also when the agent is down.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if outputFormat == outputJSON {
+			jsonOutput = os.Stdout
+			os.Stdout = os.Stderr
+		}
+		if err := prepareAgentCommand(); err != nil {
+			exitWithError(err)
+		}
+		if outputFormat == outputJSON {
+			if err := printHostStatus(time.Now()); err != nil {
+				exitWithError(err)
+			}
+			return
+		}
+		if err := agentStatus(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// hostStatus is the state of a build host, `fleet status` collects it from
+// every host of the inventory.
+type hostStatus struct {
+	Bootstrap     string            `json:"bootstrap"`
+	Running       bool              `json:"running"`
+	PID           int               `json:"pid,omitempty"`
+	Port          int               `json:"port,omitempty"`
+	AgentVersion  string            `json:"agent_version,omitempty"`
+	StartedAt     *time.Time        `json:"started_at,omitempty"`
+	UptimeSeconds int64             `json:"uptime_seconds,omitempty"`
+	Healthy       bool              `json:"healthy"`
+	LastHeartbeat *time.Time        `json:"last_heartbeat,omitempty"`
+	DiskFreeBytes uint64            `json:"disk_free_bytes,omitempty"`
+	Toolchains    map[string]string `json:"toolchains,omitempty"`
+}
+
+// nolint:gochecknoinits
+func init() {
+	agentCmd.AddCommand(agentStatusCmd)
+}
+
+func writeAgentPidfile(pid int) error {
+	return writeStateFile(distbuildPath, agentPidFileName, []byte(strconv.Itoa(pid)+"\n"))
+}
+
+// restoreAgentPidfile points the pidfile back at the agent that kept running
+// when its replacement failed to start.
+func restoreAgentPidfile(pid int) {
+	if pid <= 0 {
+		removeAgentPidfile()
+		return
+	}
+
+	if err := writeAgentPidfile(pid); err != nil {
+		warn("agent.pidfile", "err", err)
+	}
+}
+
+func removeAgentPidfile() {
+	_ = os.Remove(existingStateFile(distbuildPath, agentPidFileName))
+}
+
+// readAgentPidfile returns the recorded agent pid, 0 when there is none.
+func readAgentPidfile() (int, error) {
+	data, err := os.ReadFile(existingStateFile(distbuildPath, agentPidFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read agent pidfile failed: %w", err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid agent pidfile %q", strings.TrimSpace(string(data)))
+	}
+
+	return pid, nil
+}
+
+// staleAgentPid tells why pid no longer is the agent: it exited, or the pid
+// was reused by a binary outside the install. It is empty while the agent
+// runs, and when the executable of pid cannot be told the pid is trusted.
+func staleAgentPid(pid int) string {
+	if !processAlive(pid) {
+		return "process is gone"
+	}
+
+	exe, err := processExecutable(pid)
+	if err != nil || isAgentExecutable(exe) {
+		return ""
+	}
+
+	return "pid is used by " + exe
+}
+
+// isAgentExecutable accepts the binaries the agent runs from: the agent,
+// the upgrade staged next to it, the one an upgrade moved aside and the
+// slots the manifest records. proxy and distninja share the bin directory,
+// a pid reused by them is not the agent.
+func isAgentExecutable(exe string) bool {
+	candidates := []string{agentBinaryPath(), upgradeBinaryPath(), previousBinaryPath()}
+	if manifest, err := loadManifest(); err == nil {
+		for c := manifest.Components["agent"]; c != nil; c = c.Previous {
+			if c.Slot != "" {
+				candidates = append(candidates, c.Slot)
+			}
+		}
+	}
+
+	resolvedExe := exe
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		resolvedExe = resolved
+	}
+
+	for _, path := range candidates {
+		if path == exe {
+			return true
+		}
+		if resolved, err := filepath.EvalSymlinks(path); err == nil && resolved == resolvedExe {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runningAgentPid returns the pid of the running agent from the pidfile, 0
+// when none runs. A stale pidfile is removed so it does not mislead later
+// commands.
+func runningAgentPid() int {
+	pid, err := readAgentPidfile()
+	if err != nil {
+		warn("agent.stale_pidfile", "pid", 0, "reason", err)
+		removeAgentPidfile()
+		return 0
+	}
+	if pid == 0 {
+		return 0
+	}
+
+	if reason := staleAgentPid(pid); reason != "" {
+		warn("agent.stale_pidfile", "pid", pid, "reason", reason)
+		removeAgentPidfile()
+		return 0
+	}
+
+	return pid
+}
+
+// collectHostStatus reports the agent and the install. The agent is up
+// since its pidfile was written, its last heartbeat is now when it answers
+// and else the last check the watchdog saw pass.
+func collectHostStatus(now time.Time) (*hostStatus, error) {
+	status := &hostStatus{Bootstrap: BuildTime + "-" + CommitID}
+
+	manifest, err := loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	if agent := manifest.Components["agent"]; agent != nil {
+		status.AgentVersion = versionOr(agent.Version, agent.SHA256)
+	}
+	for name, tc := range manifest.Toolchains {
+		if status.Toolchains == nil {
+			status.Toolchains = map[string]string{}
+		}
+		status.Toolchains[name] = tc.Revision
+	}
+
+	status.DiskFreeBytes = filesystemStat(distbuildPath).FreeBytes
+
+	if last := loadWatchdogState().LastHealthy; !last.IsZero() {
+		status.LastHeartbeat = &last
+	}
+
+	status.PID = runningAgentPid()
+	if status.PID == 0 {
+		return status, nil
+	}
+	status.Running = true
+
+	if info, err := os.Stat(existingStateFile(distbuildPath, agentPidFileName)); err == nil {
+		started := info.ModTime().UTC()
+		status.StartedAt = &started
+		status.UptimeSeconds = int64(now.Sub(started).Seconds())
+	}
+
+	state, err := loadAgentState()
+	if err != nil {
+		return nil, err
+	}
+	status.Port = state.Port
+
+	if checkAgentHealth(&http.Client{Timeout: 5 * time.Second}, state.Port) == nil {
+		status.Healthy = true
+		heartbeat := now.UTC()
+		status.LastHeartbeat = &heartbeat
+	}
+
+	return status, nil
+}
+
+func printHostStatus(now time.Time) error {
+	status, err := collectHostStatus(now)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(jsonOutput, string(data))
+
+	return nil
+}
+
+func agentStatus() error {
+	pid := runningAgentPid()
+	if pid == 0 {
+		return errAgentNotRunning
+	}
+
+	state, err := loadAgentState()
+	if err != nil {
+		return err
+	}
+
+	printMsg("agent.running", "pid", pid, "port", state.Port)
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentPidfile(t *testing.T) {
+	defer func(path string) {
+		distbuildPath = path
+	}(distbuildPath)
+
+	distbuildPath = t.TempDir()
+
+	pid, err := readAgentPidfile()
+	assert.NoError(t, err)
+	assert.Zero(t, pid)
+
+	require.NoError(t, writeAgentPidfile(4242))
+	pid, err = readAgentPidfile()
+	assert.NoError(t, err)
+	assert.Equal(t, 4242, pid)
+
+	removeAgentPidfile()
+	pid, err = readAgentPidfile()
+	assert.NoError(t, err)
+	assert.Zero(t, pid)
+
+	require.NoError(t, writeStateFile(distbuildPath, agentPidFileName, []byte("garbage\n")))
+	_, err = readAgentPidfile()
+	assert.ErrorContains(t, err, "invalid agent pidfile")
+}
+
+func TestStaleAgentPid(t *testing.T) {
+	defer func(path string) {
+		distbuildPath = path
+	}(distbuildPath)
+
+	distbuildPath = t.TempDir()
+
+	// The test binary runs outside the install, as a reused pid would
+	assert.Contains(t, staleAgentPid(os.Getpid()), "pid is used by")
+
+	exited := exec.Command(os.Args[0], "-test.run=^$")
+	require.NoError(t, exited.Run())
+	assert.Equal(t, "process is gone", staleAgentPid(exited.Process.Pid))
+
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("no sleep binary")
+	}
+
+	binDir := filepath.Dir(agentBinaryPath())
+	require.NoError(t, os.MkdirAll(binDir, 0755))
+	data, err := os.ReadFile(sleep)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(agentBinaryPath(), data, 0755))
+
+	agent := exec.Command(agentBinaryPath(), "30")
+	require.NoError(t, agent.Start())
+	defer func() {
+		_ = agent.Process.Kill()
+		_ = agent.Wait()
+	}()
+
+	assert.Empty(t, staleAgentPid(agent.Process.Pid))
+
+	// distninja shares the bin directory, a pid it reused is stale
+	distninja := filepath.Join(binDir, "distninja")
+	require.NoError(t, os.WriteFile(distninja, data, 0755))
+	other := exec.Command(distninja, "30")
+	require.NoError(t, other.Start())
+	defer func() {
+		_ = other.Process.Kill()
+		_ = other.Wait()
+	}()
+
+	assert.Contains(t, staleAgentPid(other.Process.Pid), "pid is used by "+distninja)
+}
+
+func TestRunningAgentPidRemovesStale(t *testing.T) {
+	defer func(path string) {
+		distbuildPath = path
+	}(distbuildPath)
+
+	distbuildPath = t.TempDir()
+
+	exited := exec.Command(os.Args[0], "-test.run=^$")
+	require.NoError(t, exited.Run())
+	require.NoError(t, writeAgentPidfile(exited.Process.Pid))
+
+	output := captureStdout(t, func() {
+		assert.Zero(t, runningAgentPid())
+	})
+	assert.Contains(t, output, "stale agent pidfile (pid "+strconv.Itoa(exited.Process.Pid)+")")
+
+	_, err := os.Stat(stateFile(distbuildPath, agentPidFileName))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.ErrorIs(t, agentStatus(), errAgentNotRunning)
+}
+
+func TestCollectHostStatus(t *testing.T) {
+	defer func(path string) {
+		distbuildPath = path
+	}(distbuildPath)
+	distbuildPath = t.TempDir()
+
+	now := time.Now()
+	require.NoError(t, saveWatchdogState(&watchdogState{LastHealthy: now.Add(-time.Hour).UTC()}))
+
+	status, err := collectHostStatus(now)
+	require.NoError(t, err)
+	assert.False(t, status.Running)
+	assert.False(t, status.Healthy)
+	require.NotNil(t, status.LastHeartbeat)
+	assert.True(t, status.LastHeartbeat.Equal(now.Add(-time.Hour)))
+}
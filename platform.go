@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Features of a run, a platform supports some or all of them.
+const (
+	featureAOSP       = "aosp"
+	featureAgent      = "agent"
+	featureToolchains = "toolchains"
+)
+
+const (
+	libcGNU  = "glibc"
+	libcMusl = "musl"
+)
+
+var forceUnsupported bool
+
+// platformSupport is an entry of the support matrix. Libc only applies to
+// linux.
+type platformSupport struct {
+	OS       string
+	Arch     string
+	Libc     string
+	Features []string
+	Note     string
+}
+
+// supportMatrix lists the platforms artifacts are published for. The AOSP
+// build itself only runs on linux/amd64, other hosts can serve as agents.
+var supportMatrix = []platformSupport{
+	{OS: "linux", Arch: "amd64", Libc: libcGNU, Features: []string{featureAOSP, featureAgent, featureToolchains}},
+	{OS: "linux", Arch: "amd64", Libc: libcMusl, Features: []string{featureAgent, featureToolchains}, Note: "musl hosts run the static variants, AOSP needs glibc"},
+	{OS: "linux", Arch: "arm64", Libc: libcGNU, Features: []string{featureAgent}, Note: "proxy and distninja are only published for amd64"},
+	{OS: "darwin", Arch: "amd64", Features: []string{featureAgent, featureToolchains}, Note: "AOSP does not build on macOS"},
+	{OS: "darwin", Arch: "arm64", Features: []string{featureAgent, featureToolchains}, Note: "AOSP does not build on macOS"},
+	{OS: "windows", Arch: "amd64", Features: []string{featureAgent}, Note: "only the agent runs on Windows"},
+}
+
+// hostLibc tells glibc from musl hosts by the dynamic loader they ship,
+// tests replace it.
+var hostLibc = func() string {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+
+	if matches, _ := filepath.Glob("/lib/ld-musl-*.so.1"); len(matches) > 0 {
+		return libcMusl
+	}
+
+	return libcGNU
+}
+
+func addPlatformFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&forceUnsupported, "force-unsupported", false, "go on when the platform does not support what the run asks for")
+}
+
+func platformName(goos, goarch, libc string) string {
+	if libc == "" {
+		return goos + "/" + goarch
+	}
+
+	return goos + "/" + goarch + " (" + libc + ")"
+}
+
+func lookupPlatform(goos, goarch, libc string) *platformSupport {
+	for i, p := range supportMatrix {
+		if p.OS == goos && p.Arch == goarch && (p.Libc == "" || p.Libc == libc) {
+			return &supportMatrix[i]
+		}
+	}
+
+	return nil
+}
+
+// unsupportedFeatures returns the features the platform lacks and why.
+func unsupportedFeatures(goos, goarch, libc string, features []string) ([]string, string) {
+	p := lookupPlatform(goos, goarch, libc)
+	if p == nil {
+		return features, "no artifacts are published for it"
+	}
+
+	var missing []string
+	for _, f := range features {
+		if !slices.Contains(p.Features, f) {
+			missing = append(missing, f)
+		}
+	}
+
+	return missing, p.Note
+}
+
+// requestedFeatures are the features the flags of a run need.
+func requestedFeatures() []string {
+	var features []string
+
+	if aospPath != "" {
+		features = append(features, featureAOSP)
+	}
+	if deployAgent {
+		features = append(features, featureAgent)
+	}
+	if enableToolchains {
+		features = append(features, featureToolchains)
+	}
+
+	return features
+}
+
+// checkPlatform runs before anything is downloaded, binaries for another
+// platform would only fail once they are started.
+func checkPlatform() error {
+	libc := hostLibc()
+
+	missing, note := unsupportedFeatures(runtime.GOOS, runtime.GOARCH, libc, requestedFeatures())
+	if len(missing) == 0 {
+		return nil
+	}
+
+	err := fmt.Errorf("%s does not support %s: %s", platformName(runtime.GOOS, runtime.GOARCH, libc), strings.Join(missing, ", "), note)
+	if !forceUnsupported {
+		return fmt.Errorf("%w (--force-unsupported goes on anyway)", err)
+	}
+
+	printMsg("warning", "text", err)
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnsupportedFeatures(t *testing.T) {
+	all := []string{featureAOSP, featureAgent, featureToolchains}
+
+	missing, _ := unsupportedFeatures("linux", "amd64", libcGNU, all)
+	assert.Empty(t, missing)
+
+	missing, note := unsupportedFeatures("darwin", "arm64", "", all)
+	assert.Equal(t, []string{featureAOSP}, missing)
+	assert.Equal(t, "AOSP does not build on macOS", note)
+
+	missing, _ = unsupportedFeatures("linux", "amd64", libcMusl, all)
+	assert.Equal(t, []string{featureAOSP}, missing)
+
+	missing, note = unsupportedFeatures("freebsd", "amd64", "", []string{featureAgent})
+	assert.Equal(t, []string{featureAgent}, missing)
+	assert.Equal(t, "no artifacts are published for it", note)
+}
+
+func TestCheckPlatform(t *testing.T) {
+	defer func(aosp string, agent, force bool, libc func() string) {
+		aospPath, deployAgent, forceUnsupported, hostLibc = aosp, agent, force, libc
+	}(aospPath, deployAgent, forceUnsupported, hostLibc)
+	aospPath, deployAgent, forceUnsupported = "/src/aosp", true, false
+
+	// AOSP needs glibc, and linux
+	hostLibc = func() string { return libcMusl }
+	if runtime.GOOS != "linux" {
+		hostLibc = func() string { return "" }
+	}
+
+	err := checkPlatform()
+	assert.ErrorContains(t, err, "--force-unsupported")
+
+	forceUnsupported = true
+	output := captureStdout(t, func() {
+		assert.NoError(t, checkPlatform())
+	})
+	assert.Contains(t, output, "does not support")
+}
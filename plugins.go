@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Plugins are executables in the plugin dir. `<plugin> describe` prints the
+// phases it adds as JSON, `<plugin> run <phase>` runs one with the run
+// context as JSON on stdin. Go plugins are not loaded, they only open when
+// built by the exact toolchain and module versions of bootstrap itself.
+
+// pluginAnchors are the built-in phases plugin phases are ordered against,
+// in run order. Plugins may run after start, before any other phase.
+var pluginAnchors = []string{"start", "setup", "checks", "clone", "download", "aosp", "agent", "toolchains", "workspace"}
+
+const pluginDescribeTimeout = 10 * time.Second
+
+var (
+	pluginDir     string
+	noPlugins     bool
+	pluginPhases  []pluginPhase
+	pluginHistory []string
+)
+
+type pluginDescription struct {
+	Name   string        `json:"name"`
+	Phases []pluginPhase `json:"phases"`
+}
+
+// pluginPhase is a phase a plugin adds. It runs right after the built-in
+// phase After or right before Before, default after workspace. Phases at
+// the same point run by Order, then by name.
+type pluginPhase struct {
+	Name     string `json:"name"`
+	After    string `json:"after,omitempty"`
+	Before   string `json:"before,omitempty"`
+	Order    int    `json:"order,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+
+	plugin string
+	path   string
+	anchor string
+}
+
+// pluginContext is what a plugin phase gets on stdin.
+type pluginContext struct {
+	Phase            string   `json:"phase"`
+	Anchor           string   `json:"anchor"`
+	Completed        []string `json:"completed"`
+	DistbuildPath    string   `json:"distbuild_path"`
+	AOSPPath         string   `json:"aosp_path,omitempty"`
+	StateDir         string   `json:"state_dir"`
+	DeployAgent      bool     `json:"deploy_agent"`
+	EnableToolchains bool     `json:"enable_toolchains"`
+	Verbose          bool     `json:"verbose"`
+	Version          string   `json:"version"`
+}
+
+func addPluginFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&pluginDir, "plugin-dir", "", "directory of provisioning plugin executables (default plugins in the state dir)")
+	cmd.Flags().BoolVar(&noPlugins, "no-plugins", false, "do not run provisioning plugins")
+}
+
+func pluginDirectory() (string, error) {
+	if pluginDir != "" {
+		return expandPath(pluginDir)
+	}
+
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "plugins"), nil
+}
+
+// loadPlugins describes every plugin in the plugin dir. A missing dir means
+// no plugins, a plugin that fails to describe itself fails the run before
+// any phase changed the host.
+func loadPlugins() error {
+	pluginPhases, pluginHistory = nil, nil
+
+	if noPlugins {
+		return nil
+	}
+
+	dir, err := pluginDirectory()
+	if err != nil {
+		return fmt.Errorf("resolve plugin dir failed: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read plugin dir failed: %w", err)
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || !isPluginExecutable(info) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		phases, err := describePlugin(path)
+		if err != nil {
+			return withCode(errCodeUsage, fmt.Errorf("plugin %s: %w", entry.Name(), err))
+		}
+		pluginPhases = append(pluginPhases, phases...)
+	}
+
+	sort.SliceStable(pluginPhases, func(i, j int) bool {
+		a, b := pluginPhases[i], pluginPhases[j]
+		if a.anchor != b.anchor {
+			return slices.Index(pluginAnchors, a.anchor) < slices.Index(pluginAnchors, b.anchor)
+		}
+		if a.Order != b.Order {
+			return a.Order < b.Order
+		}
+		return a.plugin+"/"+a.Name < b.plugin+"/"+b.Name
+	})
+
+	for _, phase := range pluginPhases {
+		logVerbose("plugin phase %s/%s runs after %s", phase.plugin, phase.Name, phase.anchor)
+	}
+
+	return nil
+}
+
+func isPluginExecutable(info os.FileInfo) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(filepath.Ext(info.Name()), ".exe")
+	}
+
+	return info.Mode().Perm()&0111 != 0
+}
+
+func describePlugin(path string) ([]pluginPhase, error) {
+	ctx, cancel := context.WithTimeout(runCtx, pluginDescribeTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, "describe")
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("describe failed: %w\n%s", err, stderr.String())
+	}
+
+	var description pluginDescription
+	if err := json.Unmarshal(output, &description); err != nil {
+		return nil, fmt.Errorf("parse description failed: %w", err)
+	}
+
+	name := description.Name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	phases := description.Phases
+	for i := range phases {
+		anchor, err := pluginAnchor(phases[i])
+		if err != nil {
+			return nil, fmt.Errorf("phase %q: %w", phases[i].Name, err)
+		}
+		phases[i].plugin, phases[i].path, phases[i].anchor = name, path, anchor
+	}
+
+	return phases, nil
+}
+
+// pluginAnchor is the built-in phase a plugin phase runs after.
+func pluginAnchor(phase pluginPhase) (string, error) {
+	switch {
+	case phase.Name == "":
+		return "", fmt.Errorf("phase name is empty")
+	case phase.After != "" && phase.Before != "":
+		return "", fmt.Errorf("after and before are mutually exclusive")
+	case phase.Before != "":
+		i := slices.Index(pluginAnchors, phase.Before)
+		if i < 1 {
+			return "", fmt.Errorf("unknown phase %q to run before, want one of %s", phase.Before, strings.Join(pluginAnchors[1:], ", "))
+		}
+		return pluginAnchors[i-1], nil
+	case phase.After != "":
+		if !slices.Contains(pluginAnchors, phase.After) {
+			return "", fmt.Errorf("unknown phase %q to run after, want one of %s", phase.After, strings.Join(pluginAnchors, ", "))
+		}
+		return phase.After, nil
+	}
+
+	return pluginAnchors[len(pluginAnchors)-1], nil
+}
+
+// runPluginPhases runs the plugin phases ordered after the built-in phase
+// anchor, which just completed.
+func runPluginPhases(anchor string) error {
+	pluginHistory = append(pluginHistory, anchor)
+
+	for _, phase := range pluginPhases {
+		if phase.anchor != anchor {
+			continue
+		}
+
+		err := runPluginPhase(phase)
+		pluginHistory = append(pluginHistory, phase.plugin+"/"+phase.Name)
+		if err == nil {
+			continue
+		}
+		if !phase.Optional {
+			return fmt.Errorf("plugin phase %s/%s failed: %w", phase.plugin, phase.Name, err)
+		}
+		warn("plugin.optional", "phase", phase.plugin+"/"+phase.Name, "err", err)
+	}
+
+	return nil
+}
+
+func runPluginPhase(phase pluginPhase) (err error) {
+	s := beginStep(msg("step.plugin", "plugin", phase.plugin, "phase", phase.Name))
+	defer func() {
+		err = s.end(err)
+	}()
+
+	state, _ := stateDir()
+	input, err := json.Marshal(pluginContext{
+		Phase:            phase.Name,
+		Anchor:           phase.anchor,
+		Completed:        pluginHistory,
+		DistbuildPath:    distbuildPath,
+		AOSPPath:         aospPath,
+		StateDir:         state,
+		DeployAgent:      deployAgent,
+		EnableToolchains: enableToolchains,
+		Verbose:          verbose,
+		Version:          BuildTime + "-" + CommitID,
+	})
+	if err != nil {
+		return err
+	}
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(runCtx, phase.path, "run", phase.Name)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = commandStderr(&output)
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w\n%s", err, output.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writePlugin(t *testing.T, dir, name, description string) {
+	script := "#!/bin/sh\n" +
+		"if [ \"$1\" = describe ]; then echo '" + description + "'; exit 0; fi\n" +
+		"[ \"$2\" = fail ] && { echo broken; exit 1; }\n" +
+		"cat > \"$(dirname \"$0\")/$2.json\"\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(script), 0755))
+}
+
+func TestPluginPhases(t *testing.T) {
+	defer func(dir string, ctx context.Context, path string) {
+		pluginDir, runCtx, distbuildPath = dir, ctx, path
+		pluginPhases, pluginHistory = nil, nil
+	}(pluginDir, runCtx, distbuildPath)
+
+	pluginDir, runCtx, distbuildPath = t.TempDir(), context.Background(), "/opt/distbuild"
+	writePlugin(t, pluginDir, "ldap", `{"name":"ldap","phases":[{"name":"join","before":"agent","order":2},{"name":"motd"}]}`)
+	writePlugin(t, pluginDir, "audit", `{"phases":[{"name":"scan","after":"aosp","order":1},{"name":"fail","after":"clone","optional":true}]}`)
+	assert.NoError(t, os.WriteFile(filepath.Join(pluginDir, "README"), []byte("not a plugin"), 0644))
+
+	assert.NoError(t, loadPlugins())
+
+	var order []string
+	for _, phase := range pluginPhases {
+		order = append(order, phase.anchor+":"+phase.plugin+"/"+phase.Name)
+	}
+	assert.Equal(t, []string{"clone:audit/fail", "aosp:audit/scan", "aosp:ldap/join", "workspace:ldap/motd"}, order)
+
+	output := captureStdout(t, func() {
+		for _, anchor := range pluginAnchors {
+			assert.NoError(t, runPluginPhases(anchor))
+		}
+	})
+	assert.Contains(t, output, "optional plugin phase audit/fail failed")
+
+	var context pluginContext
+	data, err := os.ReadFile(filepath.Join(pluginDir, "join.json"))
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, &context))
+	assert.Equal(t, "join", context.Phase)
+	assert.Equal(t, "aosp", context.Anchor)
+	assert.Equal(t, "/opt/distbuild", context.DistbuildPath)
+	assert.Equal(t, []string{"start", "setup", "checks", "clone", "audit/fail", "download", "aosp", "audit/scan"}, context.Completed)
+	assert.FileExists(t, filepath.Join(pluginDir, "motd.json"))
+
+	writePlugin(t, pluginDir, "audit", `{"phases":[{"name":"fail","after":"clone"}]}`)
+	assert.NoError(t, loadPlugins())
+	captureStdout(t, func() {
+		assert.ErrorContains(t, runPluginPhases("clone"), "plugin phase audit/fail failed")
+	})
+}
+
+func TestPluginAnchor(t *testing.T) {
+	for phase, want := range map[pluginPhase]string{
+		{Name: "a"}:                    "workspace",
+		{Name: "a", After: "start"}:    "start",
+		{Name: "a", Before: "setup"}:   "start",
+		{Name: "a", Before: "clone"}:   "checks",
+		{Name: "a", After: "download"}: "download",
+	} {
+		anchor, err := pluginAnchor(phase)
+		assert.NoError(t, err)
+		assert.Equal(t, want, anchor)
+	}
+
+	for _, phase := range []pluginPhase{
+		{},
+		{Name: "a", Before: "start"},
+		{Name: "a", After: "supervise"},
+		{Name: "a", After: "clone", Before: "agent"},
+	} {
+		_, err := pluginAnchor(phase)
+		assert.Error(t, err)
+	}
+}
+
+func TestLoadPluginsMissingDir(t *testing.T) {
+	defer func(dir string) {
+		pluginDir = dir
+	}(pluginDir)
+
+	pluginDir = filepath.Join(t.TempDir(), "missing")
+	assert.NoError(t, loadPlugins())
+	assert.Empty(t, pluginPhases)
+}
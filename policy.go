@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// hostPolicyPath is the policy file managed by the admins of the host. When
+// it exists it replaces HOSTS_ALLOW and HOSTS_DENY, so a user cannot widen
+// what bootstrap may contact.
+var hostPolicyPath = "/etc/distbuild/hosts.json"
+
+// hostPolicy restricts the hosts downloads and git may contact. Patterns
+// are host names, "*.example.com" matches every subdomain.
+type hostPolicy struct {
+	Allow  []string `json:"allow"`
+	Deny   []string `json:"deny"`
+	source string
+}
+
+var (
+	hostPolicyMu     sync.Mutex
+	hostPolicyLoaded bool
+	activePolicy     *hostPolicy
+)
+
+// loadHostPolicy reads the host policy once per run.
+func loadHostPolicy() (*hostPolicy, error) {
+	hostPolicyMu.Lock()
+	defer hostPolicyMu.Unlock()
+
+	if hostPolicyLoaded {
+		return activePolicy, nil
+	}
+
+	policy, err := resolveHostPolicy(os.Getenv)
+	if err != nil {
+		return nil, err
+	}
+
+	activePolicy, hostPolicyLoaded = policy, true
+
+	return activePolicy, nil
+}
+
+// resolveHostPolicy returns the policy of the admin file, else the one of
+// HOSTS_ALLOW and HOSTS_DENY as lookup returns them.
+func resolveHostPolicy(lookup func(string) string) (*hostPolicy, error) {
+	data, err := os.ReadFile(hostPolicyPath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		policy := &hostPolicy{
+			Allow:  splitList(lookup("HOSTS_ALLOW")),
+			Deny:   splitList(lookup("HOSTS_DENY")),
+			source: "HOSTS_ALLOW/HOSTS_DENY",
+		}
+		if err := policy.validate(); err != nil {
+			return nil, withCode(errCodeUsage, err)
+		}
+		return policy, nil
+	case err != nil:
+		// An unreadable policy must not lift the restrictions
+		return nil, withCode(errCodePermission, fmt.Errorf("read host policy failed: %w", err))
+	}
+
+	policy := &hostPolicy{source: hostPolicyPath}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, withCode(errCodePermission, fmt.Errorf("parse host policy %s failed: %w", hostPolicyPath, err))
+	}
+	if err := policy.validate(); err != nil {
+		return nil, withCode(errCodePermission, fmt.Errorf("invalid host policy %s: %w", hostPolicyPath, err))
+	}
+
+	if lookup("HOSTS_ALLOW") != "" || lookup("HOSTS_DENY") != "" {
+		warn("policy.locked", "path", hostPolicyPath)
+	}
+
+	return policy, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func (p *hostPolicy) validate() error {
+	for _, pattern := range slices.Concat(p.Allow, p.Deny) {
+		host := strings.TrimPrefix(pattern, "*.")
+		if host == "" || strings.ContainsAny(host, "/:@*") {
+			return fmt.Errorf("invalid host pattern %q, expected a host name or *.domain", pattern)
+		}
+	}
+
+	return nil
+}
+
+func matchHost(pattern, host string) bool {
+	pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+	if domain, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+domain)
+	}
+
+	return host == pattern
+}
+
+// checkHost fails when the policy denies host. A denied host stays denied
+// when an allow pattern matches it too. An empty host, a local file, is
+// always allowed.
+func (p *hostPolicy) checkHost(host string) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if p == nil || host == "" {
+		return nil
+	}
+
+	for _, pattern := range p.Deny {
+		if matchHost(pattern, host) {
+			return withCode(errCodePermission, fmt.Errorf("host %s is denied by %s", host, p.source))
+		}
+	}
+
+	if len(p.Allow) > 0 && !slices.ContainsFunc(p.Allow, func(pattern string) bool { return matchHost(pattern, host) }) {
+		return withCode(errCodePermission, fmt.Errorf("host %s is not allowed by %s", host, p.source))
+	}
+
+	return nil
+}
+
+// checkURL checks the host of a URL or of a scp-like git remote. Anything
+// else names no host.
+func (p *hostPolicy) checkURL(raw string) error {
+	if scpLikeRepoHost.MatchString(raw) {
+		host := raw[strings.Index(raw, "@")+1 : strings.Index(raw, ":")]
+		return p.checkHost(host)
+	}
+
+	if !strings.Contains(raw, "://") {
+		return nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil
+	}
+
+	return p.checkHost(u.Hostname())
+}
+
+// checkGitHosts checks the remotes git is given on its command line.
+// Commands on an existing clone, such as a pull, contact the remote it was
+// cloned from, which was checked by the clone.
+func checkGitHosts(args []string) error {
+	policy, err := loadHostPolicy()
+	if err != nil {
+		return err
+	}
+
+	for _, arg := range args {
+		if err := policy.checkURL(arg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func resetHostPolicy() {
+	hostPolicyMu.Lock()
+	defer hostPolicyMu.Unlock()
+
+	activePolicy, hostPolicyLoaded = nil, false
+}
+
+func TestHostPolicyCheck(t *testing.T) {
+	policy := &hostPolicy{Allow: []string{"*.corp.example", "mirror.example.com"}, Deny: []string{"legacy.corp.example"}}
+	assert.NoError(t, policy.validate())
+
+	assert.NoError(t, policy.checkHost("git.corp.example"))
+	assert.NoError(t, policy.checkHost("Mirror.Example.com."))
+	assert.NoError(t, policy.checkHost(""))
+	assert.ErrorContains(t, policy.checkHost("corp.example"), "not allowed")
+	assert.ErrorContains(t, policy.checkHost("legacy.corp.example"), "denied")
+	assert.Equal(t, errCodePermission, errorCodeOf(policy.checkHost("github.com")))
+
+	assert.NoError(t, policy.checkURL("https://git.corp.example:8443/distbuild.git"))
+	assert.NoError(t, policy.checkURL("/srv/mirror/distbuild.git"))
+	assert.NoError(t, policy.checkURL("file:///srv/mirror/distbuild.git"))
+	assert.Error(t, policy.checkURL("git@github.com:distbuild/distbuild.git"))
+	assert.Error(t, policy.checkURL("s3://legacy.corp.example/agent"))
+
+	assert.Error(t, (&hostPolicy{Allow: []string{"https://git.corp.example"}}).validate())
+	assert.Error(t, (&hostPolicy{Deny: []string{"*."}}).validate())
+}
+
+func TestHostPolicyLocked(t *testing.T) {
+	defer func(path string) {
+		hostPolicyPath = path
+	}(hostPolicyPath)
+	defer resetHostPolicy()
+
+	hostPolicyPath = filepath.Join(t.TempDir(), "hosts.json")
+	t.Setenv("HOSTS_ALLOW", "*")
+	t.Setenv("HOSTS_DENY", "")
+
+	resetHostPolicy()
+	_, err := loadHostPolicy()
+	assert.Equal(t, errCodeUsage, errorCodeOf(err))
+
+	// The admin file wins over the environment
+	assert.NoError(t, os.WriteFile(hostPolicyPath, []byte(`{"allow":["mirror.example.com"]}`), 0644))
+	resetHostPolicy()
+	policy, err := loadHostPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mirror.example.com"}, policy.Allow)
+	assert.ErrorContains(t, policy.checkHost("github.com"), hostPolicyPath)
+
+	// A broken admin file fails closed
+	assert.NoError(t, os.WriteFile(hostPolicyPath, []byte(`{"allow":`), 0644))
+	resetHostPolicy()
+	_, err = loadHostPolicy()
+	assert.Equal(t, errCodePermission, errorCodeOf(err))
+}
+
+func TestHostPolicyEnforced(t *testing.T) {
+	defer func(client *http.Client) {
+		httpClient = client
+	}(httpClient)
+	defer resetHostPolicy()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	t.Setenv("HOSTS_ALLOW", "")
+	t.Setenv("HOSTS_DENY", "127.0.0.1")
+	resetHostPolicy()
+
+	assert.NoError(t, setupHTTPClient())
+	_, err := fetchBytes(server.URL)
+	assert.ErrorContains(t, err, "host 127.0.0.1 is denied by HOSTS_ALLOW/HOSTS_DENY")
+	assert.Equal(t, errCodePermission, errorCodeOf(err))
+
+	err = runGit("clone distbuild", "clone", "https://127.0.0.1/distbuild.git", t.TempDir())
+	assert.ErrorContains(t, err, "denied")
+	assert.Equal(t, errCodePermission, errorCodeOf(err))
+
+	// Fleet verification goes through the same client
+	err = verifyHost(context.Background(), inventoryHost{Name: "a", Address: "127.0.0.1", VerifyURL: server.URL})
+	assert.ErrorContains(t, err, "denied")
+
+	t.Setenv("HOSTS_DENY", "")
+	resetHostPolicy()
+	assert.NoError(t, setupHTTPClient())
+	data, err := fetchBytes(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(data))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+const agentPortAuto = "auto"
+
+var agentPortFlag string
+
+func addAgentPortFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&agentPortFlag, "agent-port", "", "port the agent listens on, or auto to pick a free one when it is taken (default AGENT_PORT)")
+}
+
+func checkAgentPortFlag() error {
+	if agentPortFlag == "" || agentPortFlag == agentPortAuto {
+		return nil
+	}
+
+	port, err := strconv.Atoi(agentPortFlag)
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("--agent-port must be a port number or %s", agentPortAuto)
+	}
+
+	return nil
+}
+
+// probePort fails when something already listens on port.
+func probePort(port int) error {
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return err
+	}
+
+	return ln.Close()
+}
+
+func freePort() (int, error) {
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return 0, err
+	}
+
+	defer func(ln net.Listener) {
+		_ = ln.Close()
+	}(ln)
+
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// resolveAgentPort returns the port to start the agent on. Without a check
+// an agent on a taken port starts fine and dies right after, so a taken
+// port is an error unless --agent-port auto allows moving to a free one.
+func resolveAgentPort(recorded int) (int, error) {
+	port := recorded
+	if agentPortFlag != "" && agentPortFlag != agentPortAuto {
+		port, _ = strconv.Atoi(agentPortFlag)
+	}
+
+	err := probePort(port)
+	if err == nil {
+		return port, nil
+	}
+
+	if agentPortFlag != agentPortAuto {
+		return 0, withCode(errCodeUsage, fmt.Errorf("agent port %d is already in use, stop whatever listens on it or pass --agent-port %s: %w",
+			port, agentPortAuto, err))
+	}
+
+	free, err := freePort()
+	if err != nil {
+		return 0, fmt.Errorf("find free port failed: %w", err)
+	}
+
+	warn("agent.port_in_use", "port", port, "free", free)
+
+	return free, nil
+}
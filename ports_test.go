@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveAgentPort(t *testing.T) {
+	defer func(flag string) {
+		agentPortFlag = flag
+	}(agentPortFlag)
+
+	ln, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	defer func(ln net.Listener) {
+		_ = ln.Close()
+	}(ln)
+	taken := ln.Addr().(*net.TCPAddr).Port
+
+	free, err := freePort()
+	require.NoError(t, err)
+
+	agentPortFlag = ""
+	port, err := resolveAgentPort(free)
+	require.NoError(t, err)
+	assert.Equal(t, free, port)
+
+	_, err = resolveAgentPort(taken)
+	require.Error(t, err)
+	assert.Equal(t, errCodeUsage, errorCodeOf(err))
+	assert.Contains(t, err.Error(), "already in use")
+
+	agentPortFlag = strconv.Itoa(taken)
+	_, err = resolveAgentPort(free)
+	assert.Error(t, err)
+
+	agentPortFlag = agentPortAuto
+	captureStdout(t, func() {
+		port, err = resolveAgentPort(taken)
+	})
+	require.NoError(t, err)
+	assert.NotEqual(t, taken, port)
+}
+
+func TestCheckAgentPortFlag(t *testing.T) {
+	defer func(flag string) {
+		agentPortFlag = flag
+	}(agentPortFlag)
+
+	for _, valid := range []string{"", "auto", "9527"} {
+		agentPortFlag = valid
+		assert.NoError(t, checkAgentPortFlag(), valid)
+	}
+
+	for _, invalid := range []string{"0", "70000", "any"} {
+		agentPortFlag = invalid
+		assert.Error(t, checkAgentPortFlag(), invalid)
+	}
+}
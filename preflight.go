@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const preflightTimeout = 10 * time.Second
+
+var (
+	skipPreflight bool
+)
+
+var agentPreflightCmd = &cobra.Command{
+	Use:   "preflight",
+	Short: "check connectivity to the scheduler endpoints",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := prepareAgentCommand(); err != nil {
+			exitWithError(err)
+		}
+		if err := setupHTTPClient(); err != nil {
+			exitWithError(fmt.Errorf("setup http client failed: %w", err))
+		}
+		if err := resolveSecrets(); err != nil {
+			exitWithError(err)
+		}
+		if err := preflightScheduler(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	agentCmd.AddCommand(agentPreflightCmd)
+}
+
+func addPreflightFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&skipPreflight, "skip-preflight", false, "skip the scheduler connectivity check before deploying the agent")
+}
+
+// preflightHop names one step on the way to the scheduler, in the order the
+// steps are attempted.
+type preflightHop string
+
+const (
+	hopDNS  preflightHop = "dns"
+	hopTCP  preflightHop = "tcp"
+	hopTLS  preflightHop = "tls"
+	hopAuth preflightHop = "auth"
+)
+
+type schedulerEndpoint struct {
+	Raw    string
+	Scheme string
+	Host   string
+	Port   string
+}
+
+func (e schedulerEndpoint) address() string {
+	return net.JoinHostPort(e.Host, e.Port)
+}
+
+func (e schedulerEndpoint) usesTLS() bool {
+	return e.Scheme == "https" || e.Scheme == "tls" || e.Scheme == "grpcs"
+}
+
+type preflightResult struct {
+	Endpoint schedulerEndpoint
+	Passed   []preflightHop
+	Failed   preflightHop
+	Err      error
+}
+
+// parseSchedulerEndpoints reads SCHEDULER_ENDPOINTS, a comma separated list
+// of urls (https://host:port, grpcs://host:port) or plain host:port pairs.
+func parseSchedulerEndpoints(value string) ([]schedulerEndpoint, error) {
+	var endpoints []schedulerEndpoint
+
+	for _, raw := range strings.Split(value, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		if !strings.Contains(raw, "://") {
+			host, port, err := net.SplitHostPort(raw)
+			if err != nil {
+				return nil, fmt.Errorf("invalid scheduler endpoint %q: %w", raw, err)
+			}
+			endpoints = append(endpoints, schedulerEndpoint{Raw: raw, Scheme: "tcp", Host: host, Port: port})
+			continue
+		}
+
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scheduler endpoint %q: %w", raw, err)
+		}
+
+		defaults := map[string]string{"http": "80", "https": "443", "grpc": "80", "grpcs": "443"}
+		port := u.Port()
+		if port == "" {
+			port = defaults[u.Scheme]
+		}
+		if u.Hostname() == "" || port == "" {
+			return nil, fmt.Errorf("invalid scheduler endpoint %q: missing host or port", raw)
+		}
+
+		endpoints = append(endpoints, schedulerEndpoint{Raw: raw, Scheme: u.Scheme, Host: u.Hostname(), Port: port})
+	}
+
+	return endpoints, nil
+}
+
+// preflightScheduler walks every configured scheduler endpoint hop by hop and
+// reports the first hop that failed, so firewall problems surface before the
+// agent is deployed rather than when it first tries to register.
+func preflightScheduler() error {
+	value := os.Getenv("SCHEDULER_ENDPOINTS")
+	if value == "" {
+		warn("preflight.skipped")
+		return nil
+	}
+
+	endpoints, err := parseSchedulerEndpoints(value)
+	if err != nil {
+		return withCode(errCodeUsage, err)
+	}
+
+	var errs []error
+
+	for _, endpoint := range endpoints {
+		result := probeSchedulerEndpoint(endpoint)
+		fmt.Println(describePreflight(result))
+		if result.Err != nil {
+			code := errCodeNetwork
+			if result.Failed == hopAuth {
+				code = errCodeAuth
+			}
+			errs = append(errs, withCode(code, fmt.Errorf("%s: %s hop failed: %w", endpoint.Raw, result.Failed, result.Err)))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("scheduler preflight failed: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+func probeSchedulerEndpoint(endpoint schedulerEndpoint) preflightResult {
+	result := preflightResult{Endpoint: endpoint}
+
+	ctx, cancel := context.WithTimeout(runCtx, preflightTimeout)
+	defer cancel()
+
+	if net.ParseIP(endpoint.Host) == nil {
+		if _, err := net.DefaultResolver.LookupHost(ctx, endpoint.Host); err != nil {
+			result.Failed, result.Err = hopDNS, err
+			return result
+		}
+		result.Passed = append(result.Passed, hopDNS)
+	}
+
+	conn, err := dialContext(ctx, "tcp", endpoint.address())
+	if err != nil {
+		result.Failed, result.Err = hopTCP, err
+		return result
+	}
+	defer func(conn net.Conn) {
+		_ = conn.Close()
+	}(conn)
+	result.Passed = append(result.Passed, hopTCP)
+
+	if endpoint.usesTLS() {
+		tlsConn := tls.Client(conn, schedulerTLSConfig(endpoint.Host))
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			result.Failed, result.Err = hopTLS, err
+			return result
+		}
+		result.Passed = append(result.Passed, hopTLS)
+	}
+
+	if err := probeSchedulerAuth(endpoint); err != nil {
+		result.Failed, result.Err = hopAuth, err
+		return result
+	} else if schedulerAuthSupported(endpoint) {
+		result.Passed = append(result.Passed, hopAuth)
+	}
+
+	return result
+}
+
+func schedulerTLSConfig(serverName string) *tls.Config {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if transport, ok := httpClient.Transport.(*headerTransport); ok && transport.TLSClientConfig != nil {
+		config = transport.TLSClientConfig.Clone()
+	}
+	config.ServerName = serverName
+
+	return config
+}
+
+// schedulerAuthSupported reports whether an auth handshake can be attempted,
+// which needs an http endpoint and SCHEDULER_AUTH_PATH.
+func schedulerAuthSupported(endpoint schedulerEndpoint) bool {
+	return (endpoint.Scheme == "http" || endpoint.Scheme == "https") && os.Getenv("SCHEDULER_AUTH_PATH") != ""
+}
+
+func probeSchedulerAuth(endpoint schedulerEndpoint) error {
+	if !schedulerAuthSupported(endpoint) {
+		return nil
+	}
+
+	target := endpoint.Scheme + "://" + endpoint.address() + os.Getenv("SCHEDULER_AUTH_PATH")
+
+	req, err := newGetRequest(runCtx, target)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Transport: httpClient.Transport, Timeout: preflightTimeout, CheckRedirect: checkRedirect}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return newStatusError(resp)
+	}
+
+	return nil
+}
+
+func describePreflight(result preflightResult) string {
+	var b strings.Builder
+
+	b.WriteString("scheduler " + result.Endpoint.Raw + ":")
+	for _, hop := range result.Passed {
+		b.WriteString(" " + string(hop) + " ok")
+	}
+
+	if result.Err == nil {
+		return b.String()
+	}
+
+	b.WriteString(" " + string(result.Failed) + " FAILED\n  " + result.Err.Error())
+
+	switch result.Failed {
+	case hopDNS:
+		b.WriteString("\n  " + msg("preflight.dns"))
+	case hopTCP:
+		b.WriteString("\n  " + msg("preflight.tcp", "address", result.Endpoint.address()))
+	case hopTLS:
+		b.WriteString("\n  " + msg("preflight.tls"))
+	case hopAuth:
+		b.WriteString("\n  " + msg("preflight.auth"))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchedulerEndpoints(t *testing.T) {
+	endpoints, err := parseSchedulerEndpoints("https://sched.example.com, grpc://10.0.0.1:9000,coord:7000")
+	assert.NoError(t, err)
+	assert.Equal(t, []schedulerEndpoint{
+		{Raw: "https://sched.example.com", Scheme: "https", Host: "sched.example.com", Port: "443"},
+		{Raw: "grpc://10.0.0.1:9000", Scheme: "grpc", Host: "10.0.0.1", Port: "9000"},
+		{Raw: "coord:7000", Scheme: "tcp", Host: "coord", Port: "7000"},
+	}, endpoints)
+
+	_, err = parseSchedulerEndpoints("coord")
+	assert.Error(t, err)
+
+	_, err = parseSchedulerEndpoints("ftp://coord")
+	assert.Error(t, err)
+}
+
+func TestProbeSchedulerEndpoint(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	host, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	endpoint := schedulerEndpoint{Raw: ts.URL, Scheme: "http", Host: host, Port: port}
+
+	result := probeSchedulerEndpoint(endpoint)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, []preflightHop{hopTCP}, result.Passed)
+
+	t.Setenv("SCHEDULER_AUTH_PATH", "/auth")
+	result = probeSchedulerEndpoint(endpoint)
+	assert.Equal(t, hopAuth, result.Failed)
+	assert.Contains(t, describePreflight(result), "auth FAILED")
+
+	// A closed listener fails at the tcp hop
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	closedHost, closedPort, _ := net.SplitHostPort(listener.Addr().String())
+	_ = listener.Close()
+
+	result = probeSchedulerEndpoint(schedulerEndpoint{Raw: "closed", Scheme: "tcp", Host: closedHost, Port: closedPort})
+	assert.Equal(t, hopTCP, result.Failed)
+	assert.Contains(t, describePreflight(result), "firewall")
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+var allowRoot bool
+
+// handOverUser gets the trees created as root at the end of the run: the
+// --owner user or the user that ran bootstrap through sudo.
+var handOverUser *user.User
+
+func addPrivilegeFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&allowRoot, "allow-root", false, "allow running as root without sudo and running the agent as root")
+}
+
+func isRoot() bool {
+	return os.Geteuid() == 0
+}
+
+// sudoUser returns the user behind sudo, nil when bootstrap does not run
+// through sudo.
+func sudoUser() (*user.User, error) {
+	if !isRoot() {
+		return nil, nil
+	}
+
+	name := os.Getenv("SUDO_USER")
+	if name == "" || name == "root" {
+		return nil, nil
+	}
+
+	return user.Lookup(name)
+}
+
+// invokingUser is the user ~ refers to: the sudo caller rather than root.
+func invokingUser() (*user.User, error) {
+	if usr, err := sudoUser(); err != nil || usr != nil {
+		return usr, err
+	}
+
+	return user.Current()
+}
+
+// checkPrivileges refuses the combinations that leave root-owned files in
+// a user's workspace or an agent running as root, and points HOME at the
+// invoking user under sudo so per-user state lands in their home.
+func checkPrivileges() error {
+	if !isRoot() {
+		if fileOwner != "" {
+			warn("owner.root_only")
+		}
+		return nil
+	}
+
+	invoker, err := sudoUser()
+	if err != nil {
+		return fmt.Errorf("look up SUDO_USER failed: %w", err)
+	}
+
+	var owner *user.User
+	if fileOwner != "" {
+		if owner, err = lookupFileOwner(fileOwner); err != nil {
+			return withCode(errCodeUsage, fmt.Errorf("look up --owner %s failed: %w", fileOwner, err))
+		}
+	}
+
+	if deployAgent && (superviseAgentOn || !hasSystemd()) && !allowRoot {
+		return withCode(errCodePermission, fmt.Errorf("the agent would run as root, run bootstrap as the build user or pass --allow-root"))
+	}
+
+	if invoker == nil && owner != nil {
+		warn("owner.root_handed", "user", owner.Username)
+		handOverUser = owner
+		return nil
+	}
+
+	if invoker == nil {
+		if owner, ok := pathOwner(aospPath); ok && owner != 0 && !allowRoot {
+			return withCode(errCodePermission, fmt.Errorf("%s belongs to uid %d, running as root would leave root-owned files in it, run as its owner, pass --owner or --allow-root", aospPath, owner))
+		}
+		warn("owner.root_owned")
+		return nil
+	}
+
+	if owner == nil {
+		owner = invoker
+	}
+
+	warn("owner.sudo_handed", "user", owner.Username)
+
+	if err := os.Setenv("HOME", invoker.HomeDir); err != nil {
+		return err
+	}
+
+	handOverUser = owner
+
+	return nil
+}
+
+// handOverPaths lists the trees a run creates.
+func handOverPaths() []string {
+	paths := []string{distbuildPath}
+
+	if aospPath != "" {
+		if _, _, subPath, err := distbuildRepoSource(); err == nil {
+			paths = append(paths, filepath.Join(aospPath, subPath))
+		}
+		paths = append(paths,
+			filepath.Join(aospPath, "buildspec.mk"),
+			filepath.Join(aospPath, "buildspec.mk"+backupSuffix),
+			filepath.Join(aospPath, ".ccache"),
+		)
+	}
+
+	if registry, err := registryPath(); err == nil {
+		paths = append(paths, filepath.Dir(registry))
+	}
+
+	if dir, err := stateDir(); err == nil {
+		paths = append(paths, dir)
+	}
+
+	return paths
+}
+
+// handOverToInvoker chowns what the run created as root back to the sudo
+// caller. Files owned by anybody else are left alone.
+func handOverToInvoker() error {
+	if handOverUser == nil {
+		return nil
+	}
+
+	uid, err := strconv.Atoi(handOverUser.Uid)
+	if err != nil {
+		return fmt.Errorf("invalid uid %q: %w", handOverUser.Uid, err)
+	}
+
+	gid, err := strconv.Atoi(handOverUser.Gid)
+	if err != nil {
+		return fmt.Errorf("invalid gid %q: %w", handOverUser.Gid, err)
+	}
+
+	for _, root := range handOverPaths() {
+		if root == "" {
+			continue
+		}
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if os.IsNotExist(err) && p == root {
+				return filepath.SkipAll
+			}
+			if err != nil {
+				return err
+			}
+			if owner, ok := pathOwner(p); !ok || owner != 0 {
+				return nil
+			}
+			return os.Lchown(p, uid, gid)
+		})
+		if err != nil {
+			return fmt.Errorf("hand %s over to %s failed: %w", root, handOverUser.Username, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSudoUser(t *testing.T) {
+	t.Setenv("SUDO_USER", "root")
+
+	usr, err := sudoUser()
+	assert.NoError(t, err)
+	assert.Nil(t, usr)
+
+	current, err := user.Current()
+	assert.NoError(t, err)
+
+	usr, err = invokingUser()
+	assert.NoError(t, err)
+	assert.Equal(t, current.Uid, usr.Uid)
+}
+
+func TestCheckPrivilegesAsRoot(t *testing.T) {
+	if !isRoot() {
+		t.Skip("requires root")
+	}
+
+	defer func(path string, deploy, allow bool) {
+		aospPath = path
+		deployAgent = deploy
+		allowRoot = allow
+	}(aospPath, deployAgent, allowRoot)
+
+	t.Setenv("SUDO_USER", "")
+	deployAgent = false
+	allowRoot = false
+
+	aospPath = t.TempDir()
+	assert.NoError(t, os.Chown(aospPath, 65534, 65534))
+
+	err := checkPrivileges()
+	assert.Equal(t, errCodePermission, errorCodeOf(err))
+
+	allowRoot = true
+	captureStdout(t, func() {
+		assert.NoError(t, checkPrivileges())
+	})
+}
+
+func TestHandOverToInvoker(t *testing.T) {
+	if !isRoot() {
+		t.Skip("requires root")
+	}
+
+	defer func(distbuild, aosp string, invoker *user.User) {
+		distbuildPath = distbuild
+		aospPath = aosp
+		handOverUser = invoker
+	}(distbuildPath, aospPath, handOverUser)
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	distbuildPath = t.TempDir()
+	aospPath = ""
+	handOverUser = &user.User{Username: "nobody", Uid: "65534", Gid: "65534"}
+
+	bin := filepath.Join(distbuildPath, "boong", "bin")
+	assert.NoError(t, os.MkdirAll(bin, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(bin, "agent"), []byte("agent"), 0755))
+	assert.NoError(t, os.Symlink(filepath.Join(bin, "agent"), filepath.Join(distbuildPath, "agent")))
+
+	// Files of other users keep their owner
+	other := filepath.Join(distbuildPath, "other")
+	assert.NoError(t, os.WriteFile(other, nil, 0644))
+	assert.NoError(t, os.Chown(other, 1234, 1234))
+
+	assert.NoError(t, handOverToInvoker())
+
+	for _, p := range []string{distbuildPath, bin, filepath.Join(bin, "agent"), filepath.Join(distbuildPath, "agent")} {
+		owner, ok := pathOwner(p)
+		assert.True(t, ok)
+		assert.Equal(t, 65534, owner, p)
+	}
+
+	owner, _ := pathOwner(other)
+	assert.Equal(t, 1234, owner)
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+// pathOwner is not meaningful on windows, bootstrap never runs as uid 0.
+func pathOwner(_ string) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// activeTransfers aggregates the bytes of concurrent downloads into a single
+// bar, downloadFile reports into it while it is set.
+var activeTransfers *transferProgress
+
+type artifact struct {
+	name string
+	url  string
+	path string
+}
+
+type transferProgress struct {
+	mu      sync.Mutex
+	bar     *progressbar.ProgressBar
+	total   int64
+	unknown bool
+}
+
+func progressWriter() io.Writer {
+	if noProgress {
+		return io.Discard
+	}
+
+	return os.Stdout
+}
+
+func newTransferProgress(description string) *transferProgress {
+	if noProgress {
+		fmt.Println(description)
+	}
+
+	bar := progressbar.NewOptions64(-1,
+		progressbar.OptionSetWriter(progressWriter()),
+		progressbar.OptionSetDescription(description),
+		progressbar.OptionShowBytes(true),
+		progressbar.OptionShowCount(),
+		progressbar.OptionSetTheme(progressbar.Theme{
+			Saucer:        "=",
+			SaucerHead:    ">",
+			SaucerPadding: " ",
+			BarStart:      "[",
+			BarEnd:        "]",
+		}),
+		// The step line replaces the bar once the transfers end
+		progressbar.OptionClearOnFinish(),
+	)
+
+	return &transferProgress{bar: bar}
+}
+
+// addTotal grows the expected size, an unknown length keeps the bar in
+// spinner mode for the rest of the transfer.
+func (p *transferProgress) addTotal(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if n < 0 {
+		p.unknown = true
+		p.bar.ChangeMax64(-1)
+		return
+	}
+
+	p.total += n
+	if !p.unknown {
+		p.bar.ChangeMax64(p.total)
+	}
+}
+
+func (p *transferProgress) add(n int) {
+	_ = p.bar.Add(n)
+}
+
+func (p *transferProgress) finish() {
+	_ = p.bar.Finish()
+}
+
+type countingReader struct {
+	r io.Reader
+	p *transferProgress
+}
+
+func (c *countingReader) Read(buf []byte) (int, error) {
+	n, err := c.r.Read(buf)
+	if n > 0 {
+		c.p.add(n)
+	}
+
+	return n, err
+}
+
+func trackTransfer(r io.Reader, size int64) io.Reader {
+	if activeTransfers == nil {
+		return r
+	}
+
+	activeTransfers.addTotal(size)
+
+	return &countingReader{r: r, p: activeTransfers}
+}
+
+// downloadArtifacts fetches all artifacts concurrently behind one aggregate
+// progress bar.
+func downloadArtifacts(artifacts []artifact) (err error) {
+	if len(artifacts) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(artifacts))
+	for _, a := range artifacts {
+		names = append(names, a.name)
+	}
+
+	description := msg("step.download", "names", strings.Join(names, ", "))
+	s := beginQuietStep(description)
+	activeTransfers = newTransferProgress(description + "...")
+	defer func() {
+		activeTransfers.finish()
+		activeTransfers = nil
+		_ = s.end(err)
+	}()
+
+	errs := make([]error, len(artifacts))
+
+	var wg sync.WaitGroup
+
+	for i, a := range artifacts {
+		wg.Add(1)
+		go func(i int, a artifact) {
+			defer wg.Done()
+			if err := timed(timingDownload, a.name, func() error { return downloadBinary(a.name, a.url, a.path) }); err != nil {
+				errs[i] = fmt.Errorf("download %s binary failed: %w", a.name, err)
+			}
+		}(i, a)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
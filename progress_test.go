@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadArtifacts(t *testing.T) {
+	defer func(quiet bool) {
+		noProgress = quiet
+	}(noProgress)
+	noProgress = true
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	artifacts := []artifact{
+		{name: "proxy", url: server.URL + "/proxy", path: filepath.Join(dir, "proxy")},
+		{name: "distninja", url: server.URL + "/distninja", path: filepath.Join(dir, "distninja")},
+	}
+
+	assert.NoError(t, downloadArtifacts(artifacts))
+	assert.Nil(t, activeTransfers)
+
+	for _, a := range artifacts {
+		data, err := os.ReadFile(a.path)
+		assert.NoError(t, err)
+		assert.Equal(t, "/"+a.name, string(data))
+	}
+
+	err := downloadArtifacts([]artifact{{name: "proxy", url: server.URL + "/missing", path: filepath.Join(dir, "x")}})
+	assert.ErrorContains(t, err, "download proxy binary failed")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// maxListedChanges bounds the local changes listed before a removal.
+const maxListedChanges = 10
+
+var assumeYes bool
+
+// promptInput and isInteractive are swapped by tests.
+var (
+	promptInput   io.Reader = os.Stdin
+	isInteractive           = stdinIsTerminal
+)
+
+func addPromptFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVarP(&assumeYes, "yes", "y", false, "do not ask before removing existing checkouts and toolchains")
+}
+
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// confirm asks a yes/no question, defaulting to no. Without a terminal to
+// ask on it fails rather than guessing.
+func confirm(question string) (bool, error) {
+	if !isInteractive() {
+		return false, fmt.Errorf("cannot ask for confirmation, stdin is not a terminal")
+	}
+
+	fmt.Print(msg("prompt.confirm", "question", question))
+
+	line, err := bufio.NewReader(promptInput).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// confirmRemoval shows what removing path throws away, its size and the
+// local changes of a checkout, and asks before going on. With --yes or
+// without a terminal it only reports.
+func confirmRemoval(what, path string) error {
+	if _, err := os.Lstat(path); err != nil {
+		return nil
+	}
+
+	changes := localChanges(path)
+	summary := fmt.Sprintf("%s %s (%s", what, path, formatBytes(dirSize(path)))
+	if len(changes) > 0 {
+		summary += fmt.Sprintf(", %d local change(s)", len(changes))
+	}
+	summary += ")"
+
+	if assumeYes || !isInteractive() {
+		if len(changes) > 0 {
+			warn("prompt.removing", "summary", summary)
+		} else {
+			logVerbose("removing %s", summary)
+		}
+		return nil
+	}
+
+	for i, change := range changes {
+		if i == maxListedChanges {
+			printMsg("prompt.more", "count", len(changes)-maxListedChanges)
+			break
+		}
+		fmt.Printf("  %s\n", change)
+	}
+
+	ok, err := confirm(msg("prompt.remove", "summary", summary))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return withCode(errCodeUsage, fmt.Errorf("removal of %s declined, pass --yes to skip the question", path))
+	}
+
+	return nil
+}
+
+// localChanges lists uncommitted files and commits missing upstream in the
+// checkout at path, they are lost with it.
+func localChanges(path string) []string {
+	if _, err := os.Lstat(filepath.Join(path, ".git")); err != nil {
+		return nil
+	}
+
+	var changes []string
+
+	if out, err := gitOutput("-C", path, "status", "--porcelain"); err == nil {
+		for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+			if line != "" {
+				changes = append(changes, line)
+			}
+		}
+	}
+
+	if out, err := gitOutput("-C", path, "log", "--oneline", "@{upstream}..HEAD"); err == nil {
+		for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+			if line != "" {
+				changes = append(changes, "unpushed "+line)
+			}
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfirm(t *testing.T) {
+	defer func(input func() bool) {
+		isInteractive = input
+		promptInput = os.Stdin
+	}(isInteractive)
+
+	isInteractive = func() bool { return false }
+	_, err := confirm("remove?")
+	assert.Error(t, err)
+
+	isInteractive = func() bool { return true }
+	for answer, want := range map[string]bool{"y\n": true, "YES\n": true, "\n": false, "no\n": false, "": false} {
+		promptInput = strings.NewReader(answer)
+		captureStdout(t, func() {
+			ok, err := confirm("remove?")
+			assert.NoError(t, err)
+			assert.Equal(t, want, ok, answer)
+		})
+	}
+}
+
+func TestConfirmRemovalListsLocalChanges(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	defer func(yes bool, input func() bool) {
+		assumeYes = yes
+		isInteractive = input
+		promptInput = os.Stdin
+	}(assumeYes, isInteractive)
+
+	checkout := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(checkout, "BUILD"), []byte("v1"), 0644))
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "master"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		assert.NoError(t, exec.Command("git", append([]string{"-C", checkout}, args...)...).Run())
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(checkout, "BUILD"), []byte("local patch"), 0644))
+
+	assert.Equal(t, []string{" M BUILD"}, localChanges(checkout))
+
+	assumeYes = false
+	isInteractive = func() bool { return true }
+	promptInput = strings.NewReader("n\n")
+
+	output := captureStdout(t, func() {
+		err := confirmRemoval("distbuild checkout", checkout)
+		assert.Equal(t, errCodeUsage, errorCodeOf(err))
+	})
+	assert.Contains(t, output, " M BUILD")
+	assert.Contains(t, output, "1 local change(s)")
+
+	// --yes and non-interactive runs go ahead but still warn
+	assumeYes = true
+	output = captureStdout(t, func() {
+		assert.NoError(t, confirmRemoval("distbuild checkout", checkout))
+	})
+	assert.Contains(t, output, "warning: removing distbuild checkout")
+
+	assert.NoError(t, confirmRemoval("distbuild checkout", filepath.Join(checkout, "missing")))
+}
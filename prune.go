@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	pruneOlderThan string
+	pruneKeep      int
+	pruneDryRun    bool
+)
+
+var toolchainCmd = &cobra.Command{
+	Use:   "toolchain",
+	Short: "manage installed toolchain versions",
+}
+
+var toolchainPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "remove toolchain versions no registered workspace uses",
+	Run: func(cmd *cobra.Command, args []string) {
+		olderThan, err := parseAge(pruneOlderThan)
+		if err != nil {
+			exitWithError(withCode(errCodeUsage, err))
+		}
+		if pruneKeep < 0 {
+			exitWithError(withCode(errCodeUsage, fmt.Errorf("--keep must not be negative")))
+		}
+		if distbuildPath, err = expandPath(distbuildPath); err != nil {
+			exitWithError(fmt.Errorf("failed to expand path: %w", err))
+		}
+		if err := pruneToolchains(olderThan, pruneKeep, pruneDryRun); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	toolchainPruneCmd.Flags().StringVar(&distbuildPath, "distbuild-path", "", "distbuild binaries path (default: every registered one)")
+	toolchainPruneCmd.Flags().StringVar(&pruneOlderThan, "older-than", "", "only remove versions installed longer ago than this (e.g. 30d, 12h)")
+	toolchainPruneCmd.Flags().IntVar(&pruneKeep, "keep", 1, "always keep the N most recently installed versions of each toolchain")
+	toolchainPruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "only print what would be removed")
+
+	toolchainCmd.AddCommand(toolchainPruneCmd)
+}
+
+// parseAge accepts Go durations plus a "d" suffix for days.
+func parseAge(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("invalid age %q", value)
+	}
+
+	return age, nil
+}
+
+type toolchainVersionDir struct {
+	Name     string
+	Revision string
+	Path     string
+	ModTime  time.Time
+}
+
+// listToolchainVersions returns the installed versions of every toolchain
+// under root, newest first per toolchain.
+func listToolchainVersions(root string) (map[string][]toolchainVersionDir, error) {
+	versions := map[string][]toolchainVersionDir{}
+
+	names, err := os.ReadDir(filepath.Join(root, "toolchains"))
+	if os.IsNotExist(err) {
+		return versions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		if !name.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(toolchainStoreDir(root, name.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			// Skip staging directories of installs in progress
+			if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			versions[name.Name()] = append(versions[name.Name()], toolchainVersionDir{
+				Name:     name.Name(),
+				Revision: entry.Name(),
+				Path:     filepath.Join(toolchainStoreDir(root, name.Name()), entry.Name()),
+				ModTime:  info.ModTime(),
+			})
+		}
+		sort.Slice(versions[name.Name()], func(i, j int) bool {
+			return versions[name.Name()][i].ModTime.After(versions[name.Name()][j].ModTime)
+		})
+	}
+
+	return versions, nil
+}
+
+// selectPrunable applies the retention policy: referenced versions, the
+// newest keep versions and versions younger than olderThan survive.
+func selectPrunable(versions map[string][]toolchainVersionDir, referenced map[string]bool, olderThan time.Duration, keep int, now time.Time) []toolchainVersionDir {
+	var prunable []toolchainVersionDir
+
+	names := make([]string, 0, len(versions))
+	for name := range versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for i, version := range versions[name] {
+			switch {
+			case i < keep:
+			case referenced[version.Path]:
+			case olderThan > 0 && now.Sub(version.ModTime) < olderThan:
+			default:
+				prunable = append(prunable, version)
+			}
+		}
+	}
+
+	return prunable
+}
+
+// removeToolchainVersion deletes a version under the lock of its toolchain
+// store entry, unless an install linked it while prune waited for the lock.
+func removeToolchainVersion(version toolchainVersionDir, roots []string) (bool, error) {
+	lock, err := lockFile(runCtx, filepath.Join(filepath.Dir(version.Path), toolchainLockName))
+	if err != nil {
+		return false, err
+	}
+	defer lock.unlock()
+
+	registry, err := loadRegistry()
+	if err != nil {
+		return false, err
+	}
+	if referencedToolchains(registry, roots)[version.Path] {
+		logVerbose("%s is in use again, keeping it", version.Path)
+		return false, nil
+	}
+
+	return true, removeAll(version.Path)
+}
+
+// referencedToolchains collects the versions in use: the ones registered
+// workspaces were provisioned with and the ones currently linked in each
+// distbuild path.
+func referencedToolchains(registry *workspaceRegistry, roots []string) map[string]bool {
+	referenced := map[string]bool{}
+
+	for _, ws := range registry.Workspaces {
+		for name, revision := range ws.Toolchains {
+			referenced[filepath.Join(toolchainStoreDir(ws.DistbuildPath, name), revision)] = true
+		}
+	}
+
+	for _, root := range roots {
+		manifest, err := loadManifestFrom(existingStateFile(root, manifestFileName))
+		if err != nil {
+			continue
+		}
+		for _, tc := range manifest.Toolchains {
+			referenced[tc.Path] = true
+		}
+	}
+
+	return referenced
+}
+
+func pruneToolchains(olderThan time.Duration, keep int, dryRun bool) error {
+	registry, err := loadRegistry()
+	if err != nil {
+		return err
+	}
+
+	var roots []string
+	if distbuildPath != "" {
+		roots = []string{distbuildPath}
+	} else {
+		seen := map[string]bool{}
+		for _, ws := range registry.Workspaces {
+			if ws.DistbuildPath != "" && !seen[ws.DistbuildPath] {
+				seen[ws.DistbuildPath] = true
+				roots = append(roots, ws.DistbuildPath)
+			}
+		}
+	}
+
+	if len(roots) == 0 {
+		return withCode(errCodeUsage, fmt.Errorf("no registered workspaces, pass --distbuild-path"))
+	}
+
+	referenced := referencedToolchains(registry, roots)
+	now := time.Now()
+
+	var prunable []toolchainVersionDir
+	for _, root := range roots {
+		versions, err := listToolchainVersions(root)
+		if err != nil {
+			return fmt.Errorf("list toolchains in %s failed: %w", root, err)
+		}
+		prunable = append(prunable, selectPrunable(versions, referenced, olderThan, keep, now)...)
+	}
+
+	sizes := make([]int64, len(prunable))
+	var total int64
+	for i, version := range prunable {
+		sizes[i] = dirSize(version.Path)
+		total += sizes[i]
+	}
+
+	if !dryRun && len(prunable) > 0 && !assumeYes && isInteractive() {
+		for i, version := range prunable {
+			fmt.Printf("  %s %s (%s)\n", version.Name, shortHash(version.Revision), formatBytes(sizes[i]))
+		}
+		ok, err := confirm(msg("prune.confirm", "count", len(prunable), "size", formatBytes(total)))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return withCode(errCodeUsage, fmt.Errorf("prune declined, pass --yes to skip the question"))
+		}
+	}
+
+	var reclaimed int64
+	removed := 0
+
+	for i, version := range prunable {
+		if dryRun {
+			printMsg("prune.would_remove", "name", version.Name, "revision", shortHash(version.Revision), "size", formatBytes(sizes[i]))
+		} else {
+			removedVersion, err := removeToolchainVersion(version, roots)
+			if err != nil {
+				return fmt.Errorf("remove %s failed: %w", version.Path, err)
+			}
+			if !removedVersion {
+				continue
+			}
+			printMsg("prune.removed", "name", version.Name, "revision", shortHash(version.Revision), "size", formatBytes(sizes[i]))
+		}
+		reclaimed += sizes[i]
+		removed++
+	}
+
+	if dryRun {
+		printMsg("prune.would_total", "count", removed, "size", formatBytes(reclaimed))
+	} else {
+		printMsg("prune.total", "count", removed, "size", formatBytes(reclaimed))
+	}
+
+	return nil
+}
+
+func dirSize(path string) int64 {
+	var size int64
+
+	_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAge(t *testing.T) {
+	age, err := parseAge("30d")
+	assert.NoError(t, err)
+	assert.Equal(t, 30*24*time.Hour, age)
+
+	age, err = parseAge("12h")
+	assert.NoError(t, err)
+	assert.Equal(t, 12*time.Hour, age)
+
+	_, err = parseAge("soon")
+	assert.Error(t, err)
+}
+
+func TestSelectPrunable(t *testing.T) {
+	now := time.Now()
+	versions := map[string][]toolchainVersionDir{
+		"clang": {
+			{Name: "clang", Revision: "c", Path: "/store/clang/c", ModTime: now.Add(-time.Hour)},
+			{Name: "clang", Revision: "b", Path: "/store/clang/b", ModTime: now.Add(-48 * time.Hour)},
+			{Name: "clang", Revision: "a", Path: "/store/clang/a", ModTime: now.Add(-96 * time.Hour)},
+		},
+	}
+
+	pruned := selectPrunable(versions, map[string]bool{}, 0, 1, now)
+	assert.Len(t, pruned, 2)
+
+	pruned = selectPrunable(versions, map[string]bool{"/store/clang/a": true}, 0, 1, now)
+	assert.Equal(t, []toolchainVersionDir{versions["clang"][1]}, pruned)
+
+	pruned = selectPrunable(versions, map[string]bool{}, 72*time.Hour, 0, now)
+	assert.Equal(t, []toolchainVersionDir{versions["clang"][2]}, pruned)
+}
+
+func TestInstallToolchain(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	defer func(path string) {
+		distbuildPath = path
+	}(distbuildPath)
+
+	src := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "clang"), []byte("clang"), 0644))
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "master"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		assert.NoError(t, exec.Command("git", append([]string{"-C", src}, args...)...).Run())
+	}
+	head, _ := resolveRevision(src)
+
+	distbuildPath = t.TempDir()
+	// A checkout from before the versioned store is replaced by the link
+	assert.NoError(t, os.MkdirAll(filepath.Join(distbuildPath, "prebuilts", "clang"), 0755))
+
+	tc := toolchainSpec{Name: "clang", Path: "prebuilts/clang", Branch: "master"}
+	path, err := installToolchain("file://"+src, tc)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(distbuildPath, "toolchains", "clang", head), path)
+
+	target, err := os.Readlink(filepath.Join(distbuildPath, "prebuilts", "clang"))
+	assert.NoError(t, err)
+	assert.Equal(t, path, target)
+	assert.FileExists(t, filepath.Join(distbuildPath, "prebuilts", "clang", "clang"))
+
+	versions, err := listToolchainVersions(distbuildPath)
+	assert.NoError(t, err)
+	assert.Len(t, versions["clang"], 1)
+}
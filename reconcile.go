@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const reconcileUnit = "distbuild-reconcile"
+
+var (
+	reconcileOn       bool
+	reconcileTimer    bool
+	reconcileInterval time.Duration
+)
+
+func addReconcileFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&reconcileOn, "reconcile", false, "keep running and converge the host to the release set every --interval")
+	cmd.Flags().BoolVar(&reconcileTimer, "reconcile-timer", false, "install a systemd timer that applies the release set every --interval instead of running")
+	cmd.Flags().DurationVar(&reconcileInterval, "interval", time.Hour, "time between reconcile passes")
+	addHealthFlag(cmd)
+}
+
+func checkReconcileFlags() error {
+	if reconcileOn && reconcileTimer {
+		return fmt.Errorf("--reconcile and --reconcile-timer are mutually exclusive")
+	}
+
+	if reconcileInterval < time.Minute {
+		return fmt.Errorf("--interval must be at least 1m")
+	}
+
+	if reconcileTimer && runtime.GOOS != "linux" {
+		return fmt.Errorf("--reconcile-timer needs systemd, use --reconcile")
+	}
+
+	if healthAddr != "" && !reconcileOn {
+		return fmt.Errorf("--health-addr needs --reconcile")
+	}
+
+	return checkHealthFlags()
+}
+
+// reviveAgent starts a dead agent or restarts a running one, tests replace
+// it.
+var reviveAgent = func(running bool) error {
+	if running {
+		return restartAgent()
+	}
+
+	return runAgent()
+}
+
+// reconcileLoop converges the host every interval until it is stopped. A
+// failed pass is reported and retried on the next one, the loop itself
+// only ends on a signal.
+func reconcileLoop(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stopHealth, err := serveHealth("reconcile")
+	if err != nil {
+		return err
+	}
+	defer stopHealth()
+
+	printMsg("reconcile.started", "set", releaseSetName, "interval", reconcileInterval)
+
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+
+	for passes := 1; ; passes++ {
+		start := time.Now()
+		changes, err := reconcilePass(ctx)
+		switch {
+		case err != nil:
+			warn("reconcile.failed", "err", err, "interval", reconcileInterval)
+			sendNotification(newNotification("reconcile", start, err))
+			setHealth("failed", false, map[string]any{"passes": passes, "error": err.Error()})
+		case len(changes) > 0:
+			sendNotification(newNotification("reconcile", start, nil))
+			setHealth("converged", true, map[string]any{"passes": passes, "changes": changes})
+		default:
+			setHealth("converged", true, map[string]any{"passes": passes})
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcilePass converges the host to the release set once: drifted or
+// missing artifacts are installed again, deleted links recreated and a dead
+// agent started. It returns what it had to change.
+func reconcilePass(ctx context.Context) ([]string, error) {
+	resetChanges()
+
+	if runTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, runTimeout)
+		defer cancel()
+	}
+	runCtx = ctx
+
+	set, err := prepareReleaseSet()
+	if err != nil {
+		return nil, timeoutError(ctx, err)
+	}
+
+	agentChanged, err := installReleaseSet(set)
+	if err != nil {
+		return nil, timeoutError(ctx, err)
+	}
+
+	if err := reconcileAgent(agentChanged); err != nil {
+		return nil, err
+	}
+
+	changes := recordedChanges()
+	reportChanges(changes)
+
+	return changes, nil
+}
+
+// reconcileAgent keeps a deployed agent running the installed binary. A
+// host the agent was never deployed to is left without one.
+func reconcileAgent(binaryChanged bool) error {
+	if agentManagedBySystemd() {
+		if _, err := installAgentService(); err != nil {
+			return fmt.Errorf("install agent service failed: %w", err)
+		}
+		return nil
+	}
+
+	if _, err := os.Stat(agentStatePath()); err != nil {
+		return nil
+	}
+
+	pid := runningAgentPid()
+	if pid > 0 && !binaryChanged {
+		port, err := runningAgentPort()
+		if err != nil {
+			return err
+		}
+		if checkAgentHealth(&http.Client{Timeout: 5 * time.Second}, port) == nil {
+			return nil
+		}
+	}
+
+	if err := reviveAgent(pid > 0); err != nil {
+		return fmt.Errorf("restart agent failed: %w", err)
+	}
+
+	noteChange("restart agent")
+
+	return nil
+}
+
+// installReconcileTimer makes systemd run `apply` every interval, each run
+// converges the host once.
+func installReconcileTimer() error {
+	args := []string{"apply", "--distbuild-path", distbuildPath, "--set", releaseSetName,
+		"--toolchain-host-os", toolchainHostOS}
+	if releaseSetSource != "" {
+		args = append(args, "--release-sets", releaseSetSource)
+	}
+
+	command, err := selfCommand(args...)
+	if err != nil {
+		return err
+	}
+
+	service, timer := timerUnits("distbuild release set reconcile", command, reconcileInterval)
+
+	changed, err := installTimer(reconcileUnit, service, timer)
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		printMsg("reconcile.timer", "set", releaseSetName, "interval", reconcileInterval)
+	}
+
+	return nil
+}
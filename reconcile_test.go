@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckReconcileFlags(t *testing.T) {
+	defer func(on, timer bool, interval time.Duration, addr string) {
+		reconcileOn, reconcileTimer, reconcileInterval, healthAddr = on, timer, interval, addr
+	}(reconcileOn, reconcileTimer, reconcileInterval, healthAddr)
+
+	reconcileOn, reconcileTimer, reconcileInterval, healthAddr = true, false, time.Hour, ""
+	assert.NoError(t, checkReconcileFlags())
+
+	reconcileTimer = true
+	assert.ErrorContains(t, checkReconcileFlags(), "mutually exclusive")
+
+	reconcileTimer, reconcileInterval = false, time.Second
+	assert.ErrorContains(t, checkReconcileFlags(), "at least 1m")
+
+	reconcileOn, reconcileInterval, healthAddr = false, time.Hour, "127.0.0.1:9464"
+	assert.ErrorContains(t, checkReconcileFlags(), "needs --reconcile")
+}
+
+func TestInstallReleaseSetRepairsDrift(t *testing.T) {
+	defer func(path, scope string, quiet bool) {
+		distbuildPath, installScope, noProgress = path, scope, quiet
+	}(distbuildPath, installScope, noProgress)
+	distbuildPath, installScope, noProgress = t.TempDir(), installScopeWorkspace, true
+	resetChanges()
+	defer resetChanges()
+
+	var mu sync.Mutex
+	fetched := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		if !strings.Contains(name, ".") {
+			if r.Method == http.MethodGet {
+				mu.Lock()
+				fetched[name]++
+				mu.Unlock()
+			}
+			_, _ = w.Write([]byte(name))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	set := testReleaseSet(server.URL)
+	set.Toolchains = nil
+
+	agentChanged, err := installReleaseSet(set)
+	assert.NoError(t, err)
+	assert.True(t, agentChanged)
+	assert.NotEmpty(t, recordedChanges())
+
+	// A converged host downloads and changes nothing
+	resetChanges()
+	agentChanged, err = installReleaseSet(set)
+	assert.NoError(t, err)
+	assert.False(t, agentChanged)
+	assert.Empty(t, recordedChanges())
+	assert.Equal(t, map[string]int{"agent": 1, "proxy": 1, "distninja": 1}, fetched)
+
+	// A deleted link and a drifted agent are installed again
+	binDir := filepath.Join(distbuildPath, "boong", "bin")
+	assert.NoError(t, os.Remove(filepath.Join(binDir, "proxy")))
+	assert.NoError(t, os.WriteFile(agentBinaryPath(), []byte("agent 2"), 0755))
+
+	agentChanged, err = installReleaseSet(set)
+	assert.NoError(t, err)
+	assert.True(t, agentChanged)
+	assert.NoError(t, checkReleaseSetDigest(set, "proxy", filepath.Join(binDir, "proxy")))
+	assert.NoError(t, checkReleaseSetDigest(set, "agent", agentBinaryPath()))
+	assert.Equal(t, 1, fetched["distninja"])
+}
+
+func TestReconcileAgent(t *testing.T) {
+	if agentManagedBySystemd() {
+		t.Skip("the agent of this host is managed by systemd")
+	}
+
+	defer func(path, state string, revive func(bool) error) {
+		distbuildPath, stateDirFlag, reviveAgent = path, state, revive
+	}(distbuildPath, stateDirFlag, reviveAgent)
+	distbuildPath, stateDirFlag = t.TempDir(), t.TempDir()
+	resetChanges()
+	defer resetChanges()
+
+	var revived []bool
+	reviveAgent = func(running bool) error {
+		revived = append(revived, running)
+		return nil
+	}
+
+	// Never deployed, nothing to keep running
+	assert.NoError(t, reconcileAgent(false))
+	assert.Empty(t, revived)
+
+	assert.NoError(t, saveAgentState(&agentState{Port: 9470}))
+	resetChanges()
+
+	assert.NoError(t, reconcileAgent(false))
+	assert.Equal(t, []bool{false}, revived)
+	assert.Equal(t, []string{"restart agent"}, recordedChanges())
+}
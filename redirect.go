@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxRedirects is the redirect limit of net/http.
+const maxRedirects = 10
+
+// presignedParams are the query parameters carrying the signature of a
+// pre-signed URL: S3, GCS, CloudFront and Azure SAS.
+var presignedParams = []string{"X-Amz-Signature", "X-Goog-Signature", "Signature", "sig"}
+
+// credentialHeaders are dropped when a redirect leaves the origin.
+var credentialHeaders = []string{"Authorization", "Cookie"}
+
+// checkRedirect scopes credentials to the origin they were sent to. The
+// artifact server redirects to a CDN, which gets no credentials, while a
+// redirect back to the origin keeps them. net/http alone forwards them to
+// subdomains and other ports and drops them on some same-host redirects.
+// A pre-signed URL authorizes itself and is refused by storage services
+// when credentials come along, so it never gets any.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	origin := via[0]
+
+	switch {
+	case presignedURL(req.URL):
+		dropCredentials(req)
+		logVerbose("following pre-signed redirect to %s", redactURL(req.URL.String()))
+	case sameOrigin(req.URL, origin.URL):
+		for _, name := range credentialHeaders {
+			if value := origin.Header.Get(name); value != "" {
+				req.Header.Set(name, value)
+			}
+		}
+	default:
+		dropCredentials(req)
+		logVerbose("redirected to %s, credentials not forwarded", redactURL(req.URL.String()))
+	}
+
+	return nil
+}
+
+func dropCredentials(req *http.Request) {
+	for _, name := range credentialHeaders {
+		req.Header.Del(name)
+	}
+}
+
+func presignedURL(u *url.URL) bool {
+	query := u.Query()
+	for _, param := range presignedParams {
+		if query.Get(param) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameOrigin compares scheme, host and port, so a downgrade to http is a
+// different origin.
+func sameOrigin(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Hostname(), b.Hostname()) &&
+		originPort(a) == originPort(b)
+}
+
+func originPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+
+	return map[string]string{"http": "80", "https": "443"}[strings.ToLower(u.Scheme)]
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSameOrigin(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		same bool
+	}{
+		{"https://artifacts.example.com/a", "https://ARTIFACTS.example.com:443/b", true},
+		{"http://artifacts.example.com/a", "http://artifacts.example.com:80/b", true},
+		{"https://artifacts.example.com/a", "http://artifacts.example.com/a", false},
+		{"https://artifacts.example.com/a", "https://cdn.artifacts.example.com/a", false},
+		{"https://artifacts.example.com/a", "https://artifacts.example.com:8443/a", false},
+	} {
+		a, _ := url.Parse(tt.a)
+		b, _ := url.Parse(tt.b)
+		assert.Equal(t, tt.same, sameOrigin(a, b), "%s %s", tt.a, tt.b)
+	}
+}
+
+func TestRedirectCredentials(t *testing.T) {
+	defer func(client *http.Client) {
+		httpClient = client
+	}(httpClient)
+	t.Setenv("AUTH_USER", "builder")
+	t.Setenv("AUTH_PASS", "secret")
+	assert.NoError(t, setupHTTPClient())
+
+	var mu sync.Mutex
+	auth := map[string]bool{}
+	record := func(r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, _, ok := r.BasicAuth()
+		auth[r.URL.Path] = ok
+	}
+
+	// Both listen on 127.0.0.1, only the port tells them apart
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		_, _ = w.Write([]byte("cdn"))
+	}))
+	defer cdn.Close()
+
+	var origin *httptest.Server
+	origin = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/artifact", http.StatusFound)
+		case "/cdn":
+			http.Redirect(w, r, cdn.URL+"/blob", http.StatusFound)
+		case "/signed":
+			http.Redirect(w, r, origin.URL+"/presigned?X-Amz-Signature=abc", http.StatusTemporaryRedirect)
+		default:
+			_, _ = w.Write([]byte("origin"))
+		}
+	}))
+	defer origin.Close()
+
+	// Credentials sent to another origin do not follow it to ours
+	back := httptest.NewServer(http.RedirectHandler(origin.URL+"/returned", http.StatusFound))
+	defer back.Close()
+
+	for _, path := range []string{"/moved", "/cdn", "/signed"} {
+		_, err := fetchBytes(origin.URL + path)
+		assert.NoError(t, err)
+	}
+
+	data, err := fetchBytes(back.URL + "/start")
+	assert.NoError(t, err)
+	assert.Equal(t, "origin", string(data))
+
+	assert.Equal(t, map[string]bool{
+		"/moved":     true,
+		"/artifact":  true,
+		"/cdn":       true,
+		"/blob":      false,
+		"/signed":    true,
+		"/presigned": false,
+		"/returned":  false,
+	}, auth)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	gitReference string
+)
+
+func addGitReferenceFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&gitReference, "git-reference", "", "local git mirror to borrow objects from when cloning")
+}
+
+func checkGitReference() error {
+	if gitReference == "" {
+		return nil
+	}
+
+	path, err := expandPath(gitReference)
+	if err != nil {
+		return fmt.Errorf("failed to expand path: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("git reference %s: %w", gitReference, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("git reference %s is not a directory", gitReference)
+	}
+
+	gitReference = path
+
+	return nil
+}
+
+// referenceRepo finds the mirror of repo under --git-reference. Mirrors made
+// by `repo init --mirror` keep every project as <name>.git, a single repo
+// can also be passed directly.
+func referenceRepo(repo string) string {
+	if gitReference == "" {
+		return ""
+	}
+
+	for _, candidate := range []string{
+		filepath.Join(gitReference, filepath.FromSlash(repo)+".git"),
+		filepath.Join(gitReference, filepath.FromSlash(repo)),
+		gitReference,
+	} {
+		if isGitRepo(candidate) {
+			return candidate
+		}
+	}
+
+	return ""
+}
+
+func isGitRepo(path string) bool {
+	if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+		return true
+	}
+
+	// Bare repository
+	_, err := os.Stat(filepath.Join(path, "objects"))
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(path, "HEAD"))
+
+	return err == nil
+}
+
+// referenceArgs returns the clone arguments borrowing objects from the
+// mirror. --dissociate copies what is needed, so the clone keeps working if
+// the mirror is later removed or garbage collected.
+func referenceArgs(repo string) []string {
+	if gitReference == "" {
+		return nil
+	}
+
+	mirror := referenceRepo(repo)
+	if mirror == "" {
+		logVerbose("no mirror of %s under %s, cloning from remote", repo, gitReference)
+		return nil
+	}
+
+	logVerbose("cloning %s with reference %s", repo, mirror)
+
+	return []string{"--reference", mirror, "--dissociate"}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReferenceRepo(t *testing.T) {
+	defer func(ref string) {
+		gitReference = ref
+	}(gitReference)
+
+	mirror := t.TempDir()
+	bare := filepath.Join(mirror, "platform", "prebuilts", "clang.git")
+	assert.NoError(t, os.MkdirAll(filepath.Join(bare, "objects"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(bare, "HEAD"), []byte("ref: refs/heads/master\n"), 0644))
+
+	gitReference = ""
+	assert.Nil(t, referenceArgs("platform/prebuilts/clang"))
+
+	gitReference = mirror
+	assert.Equal(t, bare, referenceRepo("platform/prebuilts/clang"))
+	assert.Equal(t, []string{"--reference", bare, "--dissociate"}, referenceArgs("platform/prebuilts/clang"))
+	assert.Equal(t, "", referenceRepo("platform/prebuilts/gcc"))
+
+	gitReference = filepath.Join(mirror, "missing")
+	assert.Error(t, checkGitReference())
+}
+
+func TestCloneWithReference(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	defer func(ref string) {
+		gitReference = ref
+	}(gitReference)
+
+	src := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "clang"), []byte("clang"), 0644))
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "master"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		assert.NoError(t, exec.Command("git", append([]string{"-C", src}, args...)...).Run())
+	}
+
+	mirror := t.TempDir()
+	assert.NoError(t, exec.Command("git", "clone", "-q", "--mirror", src, filepath.Join(mirror, "clang.git")).Run())
+
+	gitReference = mirror
+	dst := filepath.Join(t.TempDir(), "clang")
+	assert.NoError(t, cloneToolchain("file://"+src, dst, toolchainSpec{Name: "clang", Repo: "clang", Branch: "master"}))
+
+	assert.FileExists(t, filepath.Join(dst, "clang"))
+	assert.NoFileExists(t, filepath.Join(dst, ".git", "objects", "info", "alternates"))
+}
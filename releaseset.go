@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// releaseSetComponents must all be named by a release set, a set that
+// leaves one of them out cannot have been qualified together.
+var releaseSetComponents = []string{"agent", "proxy", "distninja"}
+
+var (
+	releaseSetName   string
+	releaseSetSource string
+)
+
+// releaseSet is a signed manifest of artifact versions qualified together.
+// It is published as <RELEASE_SETS_URL>/<name>.json with a detached
+// signature next to it.
+type releaseSet struct {
+	Name       string                         `json:"name"`
+	Components map[string]releaseSetComponent `json:"components"`
+	Toolchains []toolchainSpec                `json:"toolchains,omitempty"`
+}
+
+type releaseSetComponent struct {
+	URL     string `json:"url"`
+	Version string `json:"version,omitempty"`
+	SHA256  string `json:"sha256"`
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "install exactly the artifacts of a release set",
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		if distbuildPath, err = expandPath(distbuildPath); err != nil {
+			exitWithError(withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err)))
+		}
+		if err := checkToolchainHostOS(); err != nil {
+			exitWithError(withCode(errCodeUsage, err))
+		}
+		if err := checkReconcileFlags(); err != nil {
+			exitWithError(withCode(errCodeUsage, err))
+		}
+		if reconcileOn {
+			if err := reconcileLoop(context.Background()); err != nil {
+				exitWithError(err)
+			}
+			return
+		}
+		if reconcileTimer {
+			if err := installReconcileTimer(); err != nil {
+				exitWithError(err)
+			}
+		}
+		ctx, cancel := runContext()
+		defer cancel()
+		runCtx = ctx
+		if err := applyReleaseSet(); err != nil {
+			exitWithError(timeoutError(ctx, err))
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	applyCmd.Flags().StringVar(&distbuildPath, "distbuild-path", "", "distbuild binaries path")
+	applyCmd.Flags().StringVar(&releaseSetName, "set", "", "name of the release set to install")
+	applyCmd.Flags().StringVar(&releaseSetSource, "release-sets", "", "url or directory the release sets are published under (default RELEASE_SETS_URL)")
+	applyCmd.Flags().StringVar(&toolchainHostOS, "toolchain-host-os", "", "host os of the toolchains to provision: linux, darwin, windows (default current os)")
+	addSchedulerConfigFlags(applyCmd)
+	addTimeoutFlags(applyCmd)
+	addReconcileFlags(applyCmd)
+
+	_ = applyCmd.MarkFlagRequired("distbuild-path")
+	_ = applyCmd.MarkFlagRequired("set")
+}
+
+func releaseSetURL(name string) (string, error) {
+	base := releaseSetSource
+	if base == "" {
+		base = os.Getenv("RELEASE_SETS_URL")
+	}
+	if base == "" {
+		return "", withCode(errCodeUsage, fmt.Errorf("--release-sets or RELEASE_SETS_URL is required"))
+	}
+
+	return strings.TrimSuffix(base, "/") + "/" + name + ".json", nil
+}
+
+// loadReleaseSet fetches a release set and checks its signature. Unlike
+// the checksum manifest a release set is never used unsigned, it is the
+// only record of what was qualified.
+func loadReleaseSet(name string) (*releaseSet, error) {
+	setURL, err := releaseSetURL(name)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPath := os.Getenv("RELEASE_SET_PUBLIC_KEY")
+	if keyPath == "" {
+		return nil, withCode(errCodeVerification, fmt.Errorf("RELEASE_SET_PUBLIC_KEY is required to verify release set %s", name))
+	}
+	if keyPath, err = expandPath(keyPath); err != nil {
+		return nil, fmt.Errorf("failed to expand path: %w", err)
+	}
+
+	keyData, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("read release set public key failed: %w", err)
+	}
+
+	data, err := fetchBytes(setURL)
+	if err != nil {
+		return nil, withCode(errCodeNetwork, fmt.Errorf("fetch release set %s failed: %w", name, err))
+	}
+
+	signature, err := fetchBytes(setURL + checksumSignatureSuffix)
+	if err != nil {
+		return nil, withCode(errCodeVerification, fmt.Errorf("fetch release set %s signature failed: %w", name, err))
+	}
+
+	if err := verifySignature(keyData, data, signature); err != nil {
+		return nil, withCode(errCodeVerification, fmt.Errorf("release set %s signature invalid: %w", name, err))
+	}
+
+	set := &releaseSet{}
+	if err := json.Unmarshal(data, set); err != nil {
+		return nil, withCode(errCodeVerification, fmt.Errorf("parse release set %s failed: %w", name, err))
+	}
+
+	if err := set.validate(name); err != nil {
+		return nil, withCode(errCodeVerification, fmt.Errorf("release set %s: %w", name, err))
+	}
+
+	return set, nil
+}
+
+func (s *releaseSet) validate(name string) error {
+	// A set published under another name would be installed as this one
+	if s.Name != name {
+		return fmt.Errorf("names itself %q", s.Name)
+	}
+
+	for _, component := range releaseSetComponents {
+		c, ok := s.Components[component]
+		if !ok {
+			return fmt.Errorf("does not name %s", component)
+		}
+		if c.URL == "" {
+			return fmt.Errorf("%s has no url", component)
+		}
+		if _, err := hex.DecodeString(c.SHA256); err != nil || len(c.SHA256) != 64 {
+			return fmt.Errorf("%s has invalid sha256 %q", component, c.SHA256)
+		}
+	}
+
+	for name := range s.Components {
+		if !slices.Contains(releaseSetComponents, name) {
+			return fmt.Errorf("unknown component %s", name)
+		}
+	}
+
+	for _, tc := range s.Toolchains {
+		if tc.Name == "" || tc.Repo == "" || tc.Path == "" {
+			return fmt.Errorf("toolchain entries need name, repo and path")
+		}
+		if !fullCommitHash.MatchString(tc.Commit) {
+			return fmt.Errorf("toolchain %s is not pinned to a full commit hash", tc.Name)
+		}
+	}
+
+	return nil
+}
+
+// prepareReleaseSet loads the configuration release sets are fetched with
+// and then the set selected by --set.
+func prepareReleaseSet() (*releaseSet, error) {
+	if err := loadEnvFiles(envFile, envFiles); err != nil {
+		return nil, fmt.Errorf("load .env failed: %w", err)
+	}
+
+	if err := setupHTTPClient(); err != nil {
+		return nil, fmt.Errorf("setup http client failed: %w", err)
+	}
+
+	if err := resolveSecrets(); err != nil {
+		return nil, err
+	}
+
+	if err := pullSchedulerConfig(); err != nil {
+		return nil, fmt.Errorf("pull scheduler config failed: %w", err)
+	}
+
+	return loadReleaseSet(releaseSetName)
+}
+
+// applyReleaseSet installs the set selected by --set.
+func applyReleaseSet() error {
+	set, err := prepareReleaseSet()
+	if err != nil {
+		return err
+	}
+
+	agentChanged, err := installReleaseSet(set)
+	if err != nil {
+		return err
+	}
+
+	printMsg("release.applied", "set", set.Name)
+	if _, err := os.Stat(agentStatePath()); agentChanged && (err == nil || agentManagedBySystemd()) {
+		printMsg("release.restart_agent")
+	}
+
+	return nil
+}
+
+// installReleaseSet downloads every artifact of the set that is not
+// installed already and checks it against the pinned digest before any of
+// them is activated, so a failure leaves the previous installation in place
+// rather than a mix of both. It reports whether the agent binary changed.
+func installReleaseSet(set *releaseSet) (bool, error) {
+	binDir := filepath.Join(distbuildPath, "boong", "bin")
+	if err := createDirs(binDir); err != nil {
+		return false, fmt.Errorf("create bin directory failed: %w", err)
+	}
+
+	unlock, err := lockBinaries(binDir, releaseSetComponents...)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	targets := map[string]string{
+		"agent":     agentBinaryPath(),
+		"proxy":     filepath.Join(binDir, "proxy"),
+		"distninja": filepath.Join(binDir, "distninja"),
+	}
+
+	var artifacts, current []artifact
+	for _, name := range releaseSetComponents {
+		c := set.Components[name]
+		if sum, err := fileSHA256(targets[name]); err == nil && sum == c.SHA256 {
+			current = append(current, artifact{name: name, url: c.URL, path: targets[name]})
+			continue
+		}
+
+		staging := upgradeBinaryPath()
+		if name != "agent" {
+			var err error
+			if staging, err = stageSlot(name, targets[name]); err != nil {
+				return false, fmt.Errorf("stage %s failed: %w", name, err)
+			}
+		}
+		artifacts = append(artifacts, artifact{name: name, url: c.URL, path: staging})
+	}
+
+	removeStaging := func() {
+		for _, a := range artifacts {
+			_ = os.Remove(a.path)
+		}
+	}
+
+	if err := downloadArtifacts(artifacts); err != nil {
+		removeStaging()
+		return false, err
+	}
+
+	for _, a := range artifacts {
+		if err := checkReleaseSetDigest(set, a.name, a.path); err != nil {
+			removeStaging()
+			return false, err
+		}
+	}
+
+	manifest, err := loadManifest()
+	if err != nil {
+		removeStaging()
+		return false, err
+	}
+
+	agentChanged := false
+	for _, a := range artifacts {
+		if err := activateReleaseSetComponent(a, targets[a.name], manifest.Components[a.name]); err != nil {
+			return false, err
+		}
+		agentChanged = agentChanged || a.name == "agent"
+	}
+
+	// Installed components only get their record and host links repaired
+	for _, a := range current {
+		if err := recordComponent(a.name, a.url, a.path); err != nil {
+			return false, fmt.Errorf("record %s failed: %w", a.name, err)
+		}
+		if a.name != "agent" && linkHostBinaries() {
+			if err := createSymlinks(a.name); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if len(set.Toolchains) > 0 {
+		if err := applyReleaseSetToolchains(set); err != nil {
+			return false, err
+		}
+	}
+
+	return agentChanged, recordReleaseSet(set)
+}
+
+func checkReleaseSetDigest(set *releaseSet, name, path string) error {
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+
+	if want := set.Components[name].SHA256; sum != want {
+		return withCode(errCodeVerification, fmt.Errorf("%s of release set %s has sha256 %s, want %s", name, set.Name, sum, want))
+	}
+
+	return nil
+}
+
+func activateReleaseSetComponent(a artifact, target string, before *manifestComponent) error {
+	if a.name == "agent" {
+		if err := os.Rename(a.path, target); err != nil {
+			return fmt.Errorf("install agent failed: %w", err)
+		}
+		noteChange("install " + target)
+		return recordComponent(a.name, a.url, target)
+	}
+
+	slot, err := installSlot(a.name, a.path, target)
+	if err != nil {
+		return err
+	}
+	if err := recordComponent(a.name, a.url, target); err != nil {
+		return fmt.Errorf("record %s failed: %w", a.name, err)
+	}
+	if err := recordSlot(a.name, slot, before); err != nil {
+		return fmt.Errorf("record %s slot failed: %w", a.name, err)
+	}
+
+	if !linkHostBinaries() {
+		return nil
+	}
+
+	return createSymlinks(a.name)
+}
+
+func applyReleaseSetToolchains(set *releaseSet) error {
+	host, exists := os.LookupEnv("REPO_HOST")
+	if !exists || host == "" {
+		return fmt.Errorf("environment variable REPO_HOST not set")
+	}
+
+	for _, tc := range set.Toolchains {
+		if tc.HostOS != "" && tc.HostOS != toolchainHostOS {
+			continue
+		}
+		repo := host + "/" + tc.Repo
+		path, err := installToolchain(repo, tc)
+		if err != nil {
+			return err
+		}
+		if err := recordToolchain(tc.key(), repo, path); err != nil {
+			return fmt.Errorf("record %s failed: %w", tc.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// recordReleaseSet marks the manifest as holding the set, after checking
+// that every component recorded matches it.
+func recordReleaseSet(set *releaseSet) error {
+	lock, err := lockManifest()
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	manifest, err := loadManifest()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range releaseSetComponents {
+		c := manifest.Components[name]
+		if c == nil || c.SHA256 != set.Components[name].SHA256 {
+			return withCode(errCodeVerification, fmt.Errorf("%s does not match release set %s after install", name, set.Name))
+		}
+	}
+
+	manifest.ReleaseSet = set.Name
+
+	return saveManifest(manifest)
+}
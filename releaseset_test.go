@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testReleaseSet(url string) *releaseSet {
+	return &releaseSet{
+		Name: "2024.06",
+		Components: map[string]releaseSetComponent{
+			"agent":     {URL: url + "/agent", SHA256: sha256Hex([]byte("agent"))},
+			"proxy":     {URL: url + "/proxy", SHA256: sha256Hex([]byte("proxy"))},
+			"distninja": {URL: url + "/distninja", SHA256: sha256Hex([]byte("distninja"))},
+		},
+		Toolchains: []toolchainSpec{{Name: "clang", Repo: "toolchains/clang", Path: "prebuilts/clang", Commit: sha256Hex(nil)[:40]}},
+	}
+}
+
+func TestLoadReleaseSet(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	assert.NoError(t, err)
+
+	keyPath := filepath.Join(t.TempDir(), "sets.pub")
+	assert.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644))
+
+	var data, signed []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sets/2024.06.json":
+			_, _ = w.Write(data)
+		case "/sets/2024.06.json.sig":
+			_, _ = w.Write(ed25519.Sign(priv, signed))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	publish := func(set *releaseSet) {
+		data, err = json.Marshal(set)
+		assert.NoError(t, err)
+		signed = data
+	}
+
+	t.Setenv("RELEASE_SETS_URL", server.URL+"/sets/")
+
+	publish(testReleaseSet(server.URL))
+
+	// Sets are never used unsigned
+	t.Setenv("RELEASE_SET_PUBLIC_KEY", "")
+	_, err = loadReleaseSet("2024.06")
+	assert.Equal(t, errCodeVerification, errorCodeOf(err))
+
+	t.Setenv("RELEASE_SET_PUBLIC_KEY", keyPath)
+	set, err := loadReleaseSet("2024.06")
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/proxy", set.Components["proxy"].URL)
+
+	signed = []byte("tampered")
+	_, err = loadReleaseSet("2024.06")
+	assert.Equal(t, errCodeVerification, errorCodeOf(err))
+	assert.Contains(t, err.Error(), "signature invalid")
+
+	for _, tc := range []struct {
+		name   string
+		modify func(*releaseSet)
+		err    string
+	}{
+		{"renamed", func(s *releaseSet) { s.Name = "2024.05" }, `names itself "2024.05"`},
+		{"partial", func(s *releaseSet) { delete(s.Components, "distninja") }, "does not name distninja"},
+		{"unpinned", func(s *releaseSet) { s.Components["agent"] = releaseSetComponent{URL: server.URL + "/agent"} }, "agent has invalid sha256"},
+		{"unknown", func(s *releaseSet) { s.Components["helper"] = s.Components["agent"] }, "unknown component helper"},
+		{"branch", func(s *releaseSet) { s.Toolchains[0].Commit = "" }, "clang is not pinned"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			set := testReleaseSet(server.URL)
+			tc.modify(set)
+			publish(set)
+
+			_, err := loadReleaseSet("2024.06")
+			assert.Equal(t, errCodeVerification, errorCodeOf(err))
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestRecordReleaseSet(t *testing.T) {
+	defer func(path string) {
+		distbuildPath = path
+	}(distbuildPath)
+	distbuildPath = t.TempDir()
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	set := testReleaseSet(server.URL)
+
+	for _, name := range releaseSetComponents {
+		path := filepath.Join(distbuildPath, name)
+		assert.NoError(t, os.WriteFile(path, []byte(name), 0755))
+		assert.NoError(t, checkReleaseSetDigest(set, name, path))
+		assert.NoError(t, recordComponent(name, server.URL+"/"+name, path))
+	}
+
+	assert.NoError(t, recordReleaseSet(set))
+	manifest, err := loadManifest()
+	assert.NoError(t, err)
+	assert.Equal(t, "2024.06", manifest.ReleaseSet)
+
+	// Replacing one component outside the set leaves a mix of versions
+	proxy := filepath.Join(distbuildPath, "proxy")
+	assert.NoError(t, os.WriteFile(proxy, []byte("proxy 2"), 0755))
+	assert.Equal(t, errCodeVerification, errorCodeOf(checkReleaseSetDigest(set, "proxy", proxy)))
+	assert.NoError(t, recordComponent("proxy", server.URL+"/proxy", proxy))
+
+	manifest, err = loadManifest()
+	assert.NoError(t, err)
+	assert.Empty(t, manifest.ReleaseSet)
+	assert.Equal(t, errCodeVerification, errorCodeOf(recordReleaseSet(set)))
+}
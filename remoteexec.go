@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// What to do when the workspace already builds with goma or RBE, two remote
+// execution wrappers would both try to dispatch every compile.
+const (
+	remoteExecWarn    = "warn"
+	remoteExecRefuse  = "refuse"
+	remoteExecDisable = "disable"
+)
+
+const remoteExecBlock = "distbuild remote exec"
+
+var remoteExecPolicy string
+
+// remoteExecSystems maps the switches soong_ui reads to their system.
+var remoteExecSystems = map[string]string{
+	"USE_GOMA": "goma",
+	"USE_RBE":  "RBE",
+}
+
+// remoteExecConflicts are found by checkRemoteExec, disable mode turns
+// them off once the workspace is integrated.
+var remoteExecConflicts []remoteExecFinding
+
+type remoteExecFinding struct {
+	System  string
+	Setting string
+	Source  string
+}
+
+var buildspecSwitch = regexp.MustCompile(`^\s*(?:export\s+)?(USE_GOMA|USE_RBE)\s*[:?]?=\s*(\S*)`)
+
+func addRemoteExecFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&remoteExecPolicy, "remote-exec-conflict", remoteExecWarn, "when goma or RBE is enabled in the workspace: warn, refuse, or disable them")
+}
+
+func checkRemoteExecFlags() error {
+	switch remoteExecPolicy {
+	case remoteExecWarn, remoteExecRefuse, remoteExecDisable:
+		return nil
+	default:
+		return fmt.Errorf("unsupported --remote-exec-conflict %q", remoteExecPolicy)
+	}
+}
+
+// isEnvTrue matches the values soong_ui accepts as enabled.
+func isEnvTrue(value string) bool {
+	switch strings.ToLower(value) {
+	case "1", "y", "yes", "on", "true":
+		return true
+	default:
+		return false
+	}
+}
+
+// detectRemoteExec finds goma and RBE switched on in the environment or in
+// buildspec.mk, ignoring the block bootstrap manages.
+func detectRemoteExec(buildspec string) []remoteExecFinding {
+	var findings []remoteExecFinding
+
+	for _, key := range []string{"USE_GOMA", "USE_RBE"} {
+		if value := os.Getenv(key); isEnvTrue(value) {
+			findings = append(findings, remoteExecFinding{remoteExecSystems[key], key + "=" + value, "environment"})
+		}
+	}
+
+	f, err := os.Open(buildspec)
+	if err != nil {
+		return findings
+	}
+
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	begin, end := managedBlockMarkers(remoteExecBlock)
+	managed := false
+
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		switch {
+		case line == begin:
+			managed = true
+		case line == end:
+			managed = false
+		case !managed:
+			if m := buildspecSwitch.FindStringSubmatch(line); m != nil && isEnvTrue(m[2]) {
+				findings = append(findings, remoteExecFinding{remoteExecSystems[m[1]], strings.TrimSpace(line), fmt.Sprintf("%s:%d", buildspec, lineNum)})
+			}
+		}
+	}
+
+	return findings
+}
+
+// checkRemoteExec runs before anything is installed, so that refuse does
+// not leave a half provisioned workspace.
+func checkRemoteExec() error {
+	remoteExecConflicts = detectRemoteExec(filepath.Join(aospPath, "buildspec.mk"))
+	if len(remoteExecConflicts) == 0 {
+		return nil
+	}
+
+	var found []string
+	for _, f := range remoteExecConflicts {
+		found = append(found, fmt.Sprintf("%s (%s in %s)", f.System, f.Setting, f.Source))
+	}
+
+	switch remoteExecPolicy {
+	case remoteExecRefuse:
+		return withCode(errCodeUsage, fmt.Errorf("workspace builds with %s, disable it or pass --remote-exec-conflict disable", strings.Join(found, ", ")))
+	case remoteExecWarn:
+		warn("remote_exec.conflict", "found", strings.Join(found, ", "))
+	}
+
+	return nil
+}
+
+// disableRemoteExec switches the detected systems off in buildspec.mk. The
+// environment switches are unset by the setup scripts.
+func disableRemoteExec() error {
+	if remoteExecPolicy != remoteExecDisable || len(remoteExecConflicts) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, key := range remoteExecSwitches() {
+		lines = append(lines, "export "+key+" := false")
+	}
+
+	if _, err := applyManagedBlock(filepath.Join(aospPath, "buildspec.mk"), remoteExecBlock, lines); err != nil {
+		return fmt.Errorf("update buildspec.mk failed: %w", err)
+	}
+
+	for _, f := range remoteExecConflicts {
+		printMsg("remote_exec.disabled", "system", f.System, "source", f.Source)
+	}
+
+	return nil
+}
+
+// remoteExecSwitches are the switches disable mode turns off.
+func remoteExecSwitches() []string {
+	if remoteExecPolicy != remoteExecDisable {
+		return nil
+	}
+
+	var keys []string
+	for _, key := range []string{"USE_GOMA", "USE_RBE"} {
+		for _, f := range remoteExecConflicts {
+			if f.System == remoteExecSystems[key] {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectRemoteExec(t *testing.T) {
+	buildspec := filepath.Join(t.TempDir(), "buildspec.mk")
+	assert.NoError(t, os.WriteFile(buildspec, []byte("TARGET_PRODUCT := aosp_arm64\n"+
+		"export USE_RBE := true\n"+
+		"USE_GOMA ?= false\n"+
+		"# BEGIN distbuild remote exec (managed by bootstrap)\n"+
+		"export USE_RBE := false\n"+
+		"# END distbuild remote exec\n"), 0644))
+
+	t.Setenv("USE_GOMA", "1")
+	t.Setenv("USE_RBE", "")
+
+	assert.Equal(t, []remoteExecFinding{
+		{System: "goma", Setting: "USE_GOMA=1", Source: "environment"},
+		{System: "RBE", Setting: "export USE_RBE := true", Source: buildspec + ":2"},
+	}, detectRemoteExec(buildspec))
+
+	t.Setenv("USE_GOMA", "false")
+	assert.Len(t, detectRemoteExec(filepath.Join(t.TempDir(), "buildspec.mk")), 0)
+}
+
+func TestRemoteExecPolicies(t *testing.T) {
+	defer func(aosp, policy string) {
+		aospPath, remoteExecPolicy, remoteExecConflicts = aosp, policy, nil
+	}(aospPath, remoteExecPolicy)
+	aospPath = t.TempDir()
+
+	buildspec := filepath.Join(aospPath, "buildspec.mk")
+	assert.NoError(t, os.WriteFile(buildspec, []byte("USE_RBE := true\n"), 0644))
+	t.Setenv("USE_GOMA", "")
+	t.Setenv("USE_RBE", "")
+
+	remoteExecPolicy = remoteExecRefuse
+	assert.Equal(t, errCodeUsage, errorCodeOf(checkRemoteExec()))
+
+	remoteExecPolicy = remoteExecWarn
+	output := captureStdout(t, func() {
+		assert.NoError(t, checkRemoteExec())
+		assert.NoError(t, disableRemoteExec())
+	})
+	assert.Contains(t, output, "RBE (USE_RBE := true in "+buildspec+":1)")
+	assert.Empty(t, remoteExecSwitches())
+
+	remoteExecPolicy = remoteExecDisable
+	captureStdout(t, func() {
+		assert.NoError(t, checkRemoteExec())
+		assert.NoError(t, disableRemoteExec())
+		assert.NoError(t, disableRemoteExec())
+	})
+
+	data, err := os.ReadFile(buildspec)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "export USE_RBE := false\n"))
+	assert.Contains(t, setupScriptSh(nil, "/bin", "/hooks"), "unset USE_RBE\n")
+
+	// The block bootstrap wrote is not reported again
+	assert.Len(t, detectRemoteExec(buildspec), 1)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+const localManifestName = "distbuild.xml"
+
+var (
+	useRepoTool  bool
+	repoRevision string
+)
+
+type repoLocalManifest struct {
+	XMLName  xml.Name      `xml:"manifest"`
+	Remotes  []repoRemote  `xml:"remote"`
+	Projects []repoProject `xml:"project"`
+}
+
+type repoRemote struct {
+	Name  string `xml:"name,attr"`
+	Fetch string `xml:"fetch,attr"`
+}
+
+type repoProject struct {
+	Name     string `xml:"name,attr"`
+	Path     string `xml:"path,attr"`
+	Remote   string `xml:"remote,attr"`
+	Revision string `xml:"revision,attr"`
+}
+
+func addRepoFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&useRepoTool, "use-repo", false, "track the distbuild checkout in the AOSP repo manifest")
+	cmd.Flags().StringVar(&repoRevision, "repo-revision", "master", "distbuild revision for the repo manifest, repos with a ref in the layout keep it")
+	cmd.Flags().StringVar(&repoLayoutSource, "repo-layout", "", "repo layout file or url listing the repos to install and their paths (default embedded)")
+}
+
+func localManifestPath() string {
+	return filepath.Join(aospPath, ".repo", "local_manifests", localManifestName)
+}
+
+// syncDistbuildRepo adds distbuild to the workspace local manifests and lets
+// repo check it out, so it survives later `repo sync` and `repo status`.
+func syncDistbuildRepo() error {
+	if _, err := os.Stat(filepath.Join(aospPath, ".repo")); err != nil {
+		return fmt.Errorf("%s is not a repo workspace: %w", aospPath, err)
+	}
+
+	if _, err := exec.LookPath("repo"); err != nil {
+		return fmt.Errorf("repo tool not found: %w", err)
+	}
+
+	host, layout, repos, err := distbuildRepos()
+	if err != nil {
+		return err
+	}
+
+	// repo fetches from the remote of the manifest, not through runGit
+	if err := checkGitHosts([]string{host}); err != nil {
+		return fmt.Errorf("repo sync failed: %w", err)
+	}
+
+	content, err := localManifest(host, repos, repoRevision)
+	if err != nil {
+		return fmt.Errorf("generate local manifest failed: %w", err)
+	}
+
+	// A plain clone left by an earlier run would make repo refuse the project
+	targetPath := filepath.Join(aospPath, filepath.FromSlash(layout.Root))
+	if _, err := os.Stat(localManifestPath()); os.IsNotExist(err) {
+		if err := confirmRemoval("distbuild checkout", targetPath); err != nil {
+			return err
+		}
+		if err := removeAll(targetPath); err != nil {
+			return fmt.Errorf("failed to remove existing distbuild directory: %w", err)
+		}
+	}
+
+	if err := createDirs(filepath.Dir(localManifestPath())); err != nil {
+		return fmt.Errorf("create local manifests directory failed: %w", err)
+	}
+
+	if err := writeFileAtomic(localManifestPath(), content, 0644); err != nil {
+		return fmt.Errorf("write local manifest failed: %w", err)
+	}
+
+	s := beginStep(msg("step.repo_sync", "repo", repos[0].Repo))
+
+	paths := make([]string, 0, len(repos))
+	for _, r := range repos {
+		paths = append(paths, r.Path)
+	}
+
+	// repo sync leaves up to date projects alone, their heads tell whether
+	// it changed any
+	before := repoHeads(repos)
+
+	cmd := exec.CommandContext(runCtx, "repo", append([]string{"sync", "-c"}, paths...)...)
+	cmd.Dir = aospPath
+	var stderr bytes.Buffer
+	cmd.Stderr = commandStderr(&stderr)
+
+	if err := cmd.Run(); err != nil {
+		return s.end(withCode(errCodeGit, fmt.Errorf("repo sync failed: %v\n%s", err, stderr.String())))
+	}
+
+	after := repoHeads(repos)
+	for _, r := range repos {
+		if before[r.Path] == "" || before[r.Path] != after[r.Path] {
+			noteChange("sync " + r.Repo)
+		}
+	}
+
+	return s.end(nil)
+}
+
+func repoHeads(repos []layoutRepo) map[string]string {
+	heads := map[string]string{}
+	for _, r := range repos {
+		if head, err := resolveRevision(filepath.Join(aospPath, r.Path)); err == nil {
+			heads[r.Path] = head
+		}
+	}
+
+	return heads
+}
+
+func localManifest(host string, repos []layoutRepo, revision string) ([]byte, error) {
+	manifest := repoLocalManifest{
+		Remotes: []repoRemote{
+			{Name: "distbuild", Fetch: host},
+		},
+	}
+
+	for _, r := range repos {
+		ref := r.Ref
+		if ref == "" {
+			ref = revision
+		}
+		manifest.Projects = append(manifest.Projects, repoProject{Name: r.Repo, Path: filepath.ToSlash(r.Path), Remote: "distbuild", Revision: ref})
+	}
+
+	data, err := xml.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
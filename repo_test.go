@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalManifest(t *testing.T) {
+	data, err := localManifest("https://host", []layoutRepo{{Name: "distbuild", Repo: "distbuild/boong", Path: "build/distbuild"}}, "master")
+	assert.NoError(t, err)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<manifest>
+  <remote name="distbuild" fetch="https://host"></remote>
+  <project name="distbuild/boong" path="build/distbuild" remote="distbuild" revision="master"></project>
+</manifest>
+`, string(data))
+}
+
+func TestLocalManifestRepoRefs(t *testing.T) {
+	data, err := localManifest("https://host", []layoutRepo{
+		{Name: "distbuild", Repo: "distbuild/boong", Path: "build/distbuild"},
+		{Name: "wrapper", Repo: "distbuild/wrapper", Path: "build/distbuild/boong/wrapper", Ref: "v2"},
+	}, "master")
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `<project name="distbuild/boong" path="build/distbuild" remote="distbuild" revision="master"></project>`)
+	assert.Contains(t, string(data), `<project name="distbuild/wrapper" path="build/distbuild/boong/wrapper" remote="distbuild" revision="v2"></project>`)
+}
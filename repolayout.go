@@ -0,0 +1,192 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed repos.json
+var embeddedRepoLayout []byte
+
+var (
+	repoLayoutSource string
+
+	// Layouts by source, a run resolves the repos many times
+	repoLayoutCache = map[string]*repoLayout{}
+)
+
+// repoLayout lists the repos installed into the AOSP workspace, in clone
+// order. Root is the tree bootstrap owns and clears before installing.
+type repoLayout struct {
+	Root  string     `json:"root"`
+	Repos []repoSpec `json:"repos"`
+}
+
+// repoSpec is one repo of the layout. The repo name is fixed or read from
+// RepoEnv, a repo without a name is not installed. A fallback is installed
+// only when the repo it stands in for is not.
+type repoSpec struct {
+	Name        string `json:"name"`
+	Repo        string `json:"repo,omitempty"`
+	RepoEnv     string `json:"repo_env,omitempty"`
+	Path        string `json:"path"`
+	Ref         string `json:"ref,omitempty"`
+	FallbackFor string `json:"fallback_for,omitempty"`
+}
+
+// layoutRepo is a repo of the layout resolved against the environment.
+type layoutRepo struct {
+	Name string
+	Repo string
+	Path string
+	Ref  string
+}
+
+func loadRepoLayout(source string) (*repoLayout, error) {
+	data := embeddedRepoLayout
+
+	switch {
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		fetched, err := fetchBytes(source)
+		if err != nil {
+			return nil, fmt.Errorf("fetch repo layout failed: %w", err)
+		}
+		data = fetched
+	case source != "":
+		path, err := expandPath(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand path: %w", err)
+		}
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("read repo layout failed: %w", err)
+		}
+	}
+
+	layout := &repoLayout{}
+	if err := json.Unmarshal(data, layout); err != nil {
+		return nil, fmt.Errorf("parse repo layout failed: %w", err)
+	}
+
+	if err := layout.validate(); err != nil {
+		return nil, withCode(errCodeUsage, fmt.Errorf("repo layout: %w", err))
+	}
+
+	return layout, nil
+}
+
+func (l *repoLayout) validate() error {
+	if !isWorkspacePath(l.Root) {
+		return fmt.Errorf("root %q is not a path inside the workspace", l.Root)
+	}
+	if len(l.Repos) == 0 {
+		return fmt.Errorf("lists no repos")
+	}
+
+	seen := map[string]bool{}
+	for _, spec := range l.Repos {
+		if spec.Name == "" || seen[spec.Name] {
+			return fmt.Errorf("repo names must be set and unique, got %q", spec.Name)
+		}
+		if spec.Repo == "" && spec.RepoEnv == "" {
+			return fmt.Errorf("repo %s needs repo or repo_env", spec.Name)
+		}
+		if !isWorkspacePath(spec.Path) || !isWithin(filepath.FromSlash(spec.Path), filepath.FromSlash(l.Root)) {
+			return fmt.Errorf("repo %s path %q is not inside root %s", spec.Name, spec.Path, l.Root)
+		}
+		// Fallbacks name an earlier repo so the clone order stays simple
+		if spec.FallbackFor != "" && !seen[spec.FallbackFor] {
+			return fmt.Errorf("repo %s falls back for unknown repo %s", spec.Name, spec.FallbackFor)
+		}
+		seen[spec.Name] = true
+	}
+
+	return nil
+}
+
+// isWorkspacePath accepts relative paths that stay inside the workspace.
+func isWorkspacePath(path string) bool {
+	clean := filepath.Clean(filepath.FromSlash(path))
+
+	return path != "" && !filepath.IsAbs(clean) && clean != "." && clean != ".." &&
+		!strings.HasPrefix(clean, ".."+string(filepath.Separator))
+}
+
+func isWithin(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolve picks the repos to install from the environment.
+func (l *repoLayout) resolve() ([]layoutRepo, error) {
+	var repos []layoutRepo
+	installed := map[string]bool{}
+
+	for _, spec := range l.Repos {
+		if spec.FallbackFor != "" && installed[spec.FallbackFor] {
+			continue
+		}
+
+		repo := spec.Repo
+		if repo == "" {
+			repo = os.Getenv(spec.RepoEnv)
+		}
+		if repo == "" {
+			continue
+		}
+
+		installed[spec.Name] = true
+		repos = append(repos, layoutRepo{Name: spec.Name, Repo: repo, Path: filepath.FromSlash(spec.Path), Ref: spec.Ref})
+	}
+
+	if len(repos) == 0 {
+		var keys []string
+		for _, spec := range l.Repos {
+			if spec.RepoEnv != "" {
+				keys = append(keys, spec.RepoEnv)
+			}
+		}
+		return nil, fmt.Errorf("environment variable %s not set", strings.Join(keys, " or "))
+	}
+
+	return repos, nil
+}
+
+// distbuildRepos returns the repo host and the repos to install.
+func distbuildRepos() (string, *repoLayout, []layoutRepo, error) {
+	host, exists := os.LookupEnv("REPO_HOST")
+	if !exists || host == "" {
+		return "", nil, nil, fmt.Errorf("environment variable REPO_HOST not set")
+	}
+
+	layout, ok := repoLayoutCache[repoLayoutSource]
+	if !ok {
+		var err error
+		if layout, err = loadRepoLayout(repoLayoutSource); err != nil {
+			return "", nil, nil, err
+		}
+		repoLayoutCache[repoLayoutSource] = layout
+	}
+
+	repos, err := layout.resolve()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return host, layout, repos, nil
+}
+
+// distbuildRepoSource returns the first repo installed and its path relative
+// to the AOSP workspace, the checkout the build uses.
+func distbuildRepoSource() (host, repo, subPath string, err error) {
+	host, _, repos, err := distbuildRepos()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return host, repos[0].Repo, repos[0].Path, nil
+}
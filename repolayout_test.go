@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultRepoLayout(t *testing.T) {
+	layout, err := loadRepoLayout("")
+	require.NoError(t, err)
+
+	t.Setenv("DISTBUILD_REPO", "distbuild/boong")
+	t.Setenv("WRAPPER_REPO", "distbuild/wrapper")
+	repos, err := layout.resolve()
+	require.NoError(t, err)
+	assert.Equal(t, []layoutRepo{{Name: "distbuild", Repo: "distbuild/boong", Path: filepath.Join("build", "distbuild")}}, repos)
+
+	t.Setenv("DISTBUILD_REPO", "")
+	repos, err = layout.resolve()
+	require.NoError(t, err)
+	assert.Equal(t, []layoutRepo{{Name: "wrapper", Repo: "distbuild/wrapper", Path: filepath.Join("build", "distbuild", "boong", "wrapper")}}, repos)
+
+	t.Setenv("WRAPPER_REPO", "")
+	_, err = layout.resolve()
+	assert.EqualError(t, err, "environment variable DISTBUILD_REPO or WRAPPER_REPO not set")
+}
+
+func TestRepoLayoutBothRepos(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repos.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+  "root": "build/distbuild",
+  "repos": [
+    {"name": "distbuild", "repo_env": "DISTBUILD_REPO", "path": "build/distbuild", "ref": "release"},
+    {"name": "wrapper", "repo": "distbuild/wrapper", "path": "build/distbuild/boong/wrapper"}
+  ]
+}`), 0644))
+
+	layout, err := loadRepoLayout(path)
+	require.NoError(t, err)
+
+	t.Setenv("DISTBUILD_REPO", "distbuild/boong")
+	repos, err := layout.resolve()
+	require.NoError(t, err)
+	assert.Equal(t, []layoutRepo{
+		{Name: "distbuild", Repo: "distbuild/boong", Path: filepath.Join("build", "distbuild"), Ref: "release"},
+		{Name: "wrapper", Repo: "distbuild/wrapper", Path: filepath.Join("build", "distbuild", "boong", "wrapper")},
+	}, repos)
+}
+
+func TestRepoLayoutValidate(t *testing.T) {
+	repo := func(name, path, fallback string) repoSpec {
+		return repoSpec{Name: name, RepoEnv: "DISTBUILD_REPO", Path: path, FallbackFor: fallback}
+	}
+
+	for name, tc := range map[string]struct {
+		layout repoLayout
+		err    string
+	}{
+		"no repos":       {repoLayout{Root: "build/distbuild"}, "lists no repos"},
+		"absolute root":  {repoLayout{Root: "/build", Repos: []repoSpec{repo("a", "build", "")}}, "not a path inside the workspace"},
+		"escaping path":  {repoLayout{Root: "build", Repos: []repoSpec{repo("a", "../x", "")}}, "is not inside root"},
+		"outside root":   {repoLayout{Root: "build/distbuild", Repos: []repoSpec{repo("a", "build/other", "")}}, "is not inside root"},
+		"duplicate name": {repoLayout{Root: "build", Repos: []repoSpec{repo("a", "build/a", ""), repo("a", "build/b", "")}}, "unique"},
+		"unknown target": {repoLayout{Root: "build", Repos: []repoSpec{repo("a", "build/a", "b")}}, "unknown repo b"},
+		"no repo source": {repoLayout{Root: "build", Repos: []repoSpec{{Name: "a", Path: "build/a"}}}, "needs repo or repo_env"},
+		"repo at root":   {repoLayout{Root: "build", Repos: []repoSpec{repo("a", "build", "")}}, ""},
+	} {
+		err := tc.layout.validate()
+		if tc.err == "" {
+			assert.NoError(t, err, name)
+		} else {
+			assert.ErrorContains(t, err, tc.err, name)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var forceRemove bool
+
+var errUnsafeRemove = errors.New("refusing to remove")
+
+func addSafetyFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVar(&forceRemove, "force", false, "remove paths the safety checks refuse, after confirmation")
+}
+
+// managedRoots lists the trees bootstrap deletes inside: the paths of this
+// run, the state dir and every registered workspace.
+func managedRoots() []string {
+	roots := []string{distbuildPath, aospPath}
+
+	if dir, err := stateDir(); err == nil {
+		roots = append(roots, dir)
+	}
+
+	if registry, err := loadRegistry(); err == nil {
+		for _, ws := range registry.Workspaces {
+			roots = append(roots, ws.Path, ws.DistbuildPath)
+		}
+	}
+
+	return roots
+}
+
+// checkRemovable refuses to recursively delete a path that is a filesystem
+// root or the home directory, lies outside the managed roots, or is a
+// directory without the .git of the checkouts bootstrap creates.
+func checkRemovable(path string) error {
+	target, err := resolvePath(path)
+	if err != nil {
+		return err
+	}
+
+	if isProtectedPath(target) {
+		return fmt.Errorf("%w %s: protected path", errUnsafeRemove, path)
+	}
+
+	inside := false
+	for _, root := range managedRoots() {
+		if root == "" {
+			continue
+		}
+		resolved, err := resolvePath(root)
+		if err != nil || isProtectedPath(resolved) {
+			continue
+		}
+		if isStrictlyInside(target, resolved) {
+			inside = true
+			break
+		}
+	}
+	if !inside {
+		return fmt.Errorf("%w %s: outside the distbuild, aosp and state paths", errUnsafeRemove, path)
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil || !info.IsDir() {
+		return nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 || containsCheckout(path) {
+		return nil
+	}
+
+	return fmt.Errorf("%w %s: not a checkout created by bootstrap (no .git)", errUnsafeRemove, path)
+}
+
+// removeMarkers are the .git of the checkouts bootstrap creates, relative
+// to the directory removed: a checkout itself, or the distbuild root when
+// only the wrapper is installed in it. Repos nested deeper, like the ones
+// of an AOSP tree, do not make their parent removable.
+var removeMarkers = []string{".git", filepath.Join("boong", "wrapper", ".git")}
+
+// containsCheckout reports whether path holds one of the remove markers.
+func containsCheckout(path string) bool {
+	for _, marker := range removeMarkers {
+		if _, err := os.Lstat(filepath.Join(path, marker)); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// guardRemove runs the safety checks, --force overrides them only after
+// the user confirms.
+func guardRemove(path string) error {
+	err := checkRemovable(path)
+	if err == nil {
+		return nil
+	}
+
+	if !forceRemove {
+		return withCode(errCodeUsage, fmt.Errorf("%w, pass --force to remove it anyway", err))
+	}
+
+	ok, promptErr := confirm(msg("prompt.remove_any", "err", err))
+	if promptErr != nil {
+		return withCode(errCodeUsage, fmt.Errorf("%w: %v", err, promptErr))
+	}
+	if !ok {
+		return withCode(errCodeUsage, err)
+	}
+
+	return nil
+}
+
+// resolvePath makes path absolute and resolves the symlinks of its
+// existing part, so a link cannot smuggle a target out of a root.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	existing, rest := abs, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return abs, nil
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+}
+
+func isProtectedPath(path string) bool {
+	path = filepath.Clean(path)
+
+	if path == filepath.VolumeName(path)+string(filepath.Separator) || path == filepath.VolumeName(path) {
+		return true
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		if resolved, err := resolvePath(home); err == nil && resolved == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isStrictlyInside(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
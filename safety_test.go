@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckRemovable(t *testing.T) {
+	defer func(aosp, distbuild string) {
+		aospPath, distbuildPath = aosp, distbuild
+	}(aospPath, distbuildPath)
+
+	aospPath, distbuildPath = t.TempDir(), t.TempDir()
+
+	checkout := filepath.Join(aospPath, "build", "distbuild")
+	assert.NoError(t, os.MkdirAll(filepath.Join(checkout, ".git"), 0755))
+	assert.NoError(t, checkRemovable(checkout))
+
+	wrapper := filepath.Join(aospPath, "build", "wrapper")
+	assert.NoError(t, os.MkdirAll(filepath.Join(wrapper, "boong", "wrapper", ".git"), 0755))
+	assert.NoError(t, checkRemovable(wrapper))
+
+	// Empty leftovers and single files carry no marker
+	empty := filepath.Join(distbuildPath, "toolchains", "clang-linux", ".staging-1")
+	assert.NoError(t, os.MkdirAll(empty, 0755))
+	assert.NoError(t, checkRemovable(empty))
+
+	sources := filepath.Join(aospPath, "frameworks")
+	assert.NoError(t, os.MkdirAll(filepath.Join(sources, "base"), 0755))
+	assert.ErrorIs(t, checkRemovable(sources), errUnsafeRemove)
+
+	// A repo nested in an unrelated tree does not mark the tree
+	assert.NoError(t, os.MkdirAll(filepath.Join(aospPath, "build", "make", ".git"), 0755))
+	assert.ErrorIs(t, checkRemovable(filepath.Join(aospPath, "build")), errUnsafeRemove)
+	assert.NoError(t, os.MkdirAll(filepath.Join(sources, "base", "core", ".git"), 0755))
+	assert.ErrorIs(t, checkRemovable(sources), errUnsafeRemove)
+
+	for _, path := range []string{"/", aospPath, t.TempDir(), filepath.Join(aospPath, "..")} {
+		assert.ErrorIs(t, checkRemovable(path), errUnsafeRemove, path)
+	}
+
+	// A symlink inside a root must not reach outside of it
+	outside := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(outside, "tree", ".git"), 0755))
+	assert.NoError(t, os.Symlink(outside, filepath.Join(aospPath, "link")))
+	assert.ErrorIs(t, checkRemovable(filepath.Join(aospPath, "link", "tree")), errUnsafeRemove)
+
+	// --aosp-path / must not make everything removable
+	aospPath = "/"
+	assert.ErrorIs(t, checkRemovable(filepath.Join(outside, "tree")), errUnsafeRemove)
+}
+
+func TestGuardRemoveForce(t *testing.T) {
+	defer func(force bool, input func() bool) {
+		forceRemove = force
+		isInteractive = input
+		promptInput = os.Stdin
+	}(forceRemove, isInteractive)
+
+	path := t.TempDir()
+
+	forceRemove = false
+	err := guardRemove(path)
+	assert.Equal(t, errCodeUsage, errorCodeOf(err))
+	assert.Contains(t, err.Error(), "--force")
+
+	forceRemove = true
+	isInteractive = func() bool { return false }
+	assert.Error(t, guardRemove(path))
+
+	isInteractive = func() bool { return true }
+	captureStdout(t, func() {
+		promptInput = strings.NewReader("n\n")
+		assert.Error(t, guardRemove(path))
+
+		promptInput = strings.NewReader("yes\n")
+		assert.NoError(t, guardRemove(path))
+	})
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	sbomFormatSPDX      = "spdx"
+	sbomFormatCycloneDX = "cyclonedx"
+)
+
+var (
+	sbomFormat string
+	sbomOutput string
+)
+
+// Documents are looked up next to each artifact using these suffixes.
+var sbomSuffixes = []struct {
+	format string
+	suffix string
+}{
+	{format: sbomFormatSPDX, suffix: ".spdx.json"},
+	{format: sbomFormatCycloneDX, suffix: ".cdx.json"},
+}
+
+const provenanceSuffix = ".intoto.jsonl"
+
+var sbomCmd = &cobra.Command{
+	Use:   "sbom",
+	Short: "inspect SBOM and provenance of installed artifacts",
+}
+
+var sbomExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export recorded SBOM and provenance documents",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := exportSBOM(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	sbomCmd.PersistentFlags().StringVar(&distbuildPath, "distbuild-path", "", "distbuild binaries path")
+	_ = sbomCmd.MarkPersistentFlagRequired("distbuild-path")
+
+	sbomExportCmd.Flags().StringVar(&sbomFormat, "format", "", "only export SBOMs of this format (spdx, cyclonedx)")
+	sbomExportCmd.Flags().StringVar(&sbomOutput, "output-file", "", "write to this file instead of stdout")
+
+	sbomCmd.AddCommand(sbomExportCmd)
+}
+
+type sbomExport struct {
+	Components []sbomExportEntry `json:"components"`
+}
+
+type sbomExportEntry struct {
+	Name       string          `json:"name"`
+	URL        string          `json:"url"`
+	SHA256     string          `json:"sha256"`
+	SBOMFormat string          `json:"sbom_format,omitempty"`
+	SBOM       json.RawMessage `json:"sbom,omitempty"`
+	Provenance json.RawMessage `json:"provenance,omitempty"`
+}
+
+func exportSBOM() error {
+	if sbomFormat != "" && sbomFormat != sbomFormatSPDX && sbomFormat != sbomFormatCycloneDX {
+		return withCode(errCodeUsage, fmt.Errorf("unsupported sbom format %q", sbomFormat))
+	}
+
+	var err error
+
+	distbuildPath, err = expandPath(distbuildPath)
+	if err != nil {
+		return withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err))
+	}
+
+	manifest, err := loadManifest()
+	if err != nil {
+		return fmt.Errorf("load manifest failed: %w", err)
+	}
+
+	export := sbomExport{Components: []sbomExportEntry{}}
+
+	for _, c := range manifest.sortedComponents() {
+		entry := sbomExportEntry{
+			Name:       c.Name,
+			URL:        c.URL,
+			SHA256:     c.SHA256,
+			Provenance: c.Provenance,
+		}
+		if sbomFormat == "" || sbomFormat == c.SBOMFormat {
+			entry.SBOMFormat = c.SBOMFormat
+			entry.SBOM = c.SBOM
+		}
+		export.Components = append(export.Components, entry)
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if sbomOutput == "" {
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if err := os.WriteFile(sbomOutput, append(data, '\n'), policyFileMode(0644)); err != nil {
+		return withCode(errCodeDisk, fmt.Errorf("write sbom export failed: %w", err))
+	}
+
+	return nil
+}
+
+func fetchSBOM(artifactURL string) (string, json.RawMessage) {
+	for _, s := range sbomSuffixes {
+		data, err := fetchBytes(artifactURL + s.suffix)
+		if err != nil || !json.Valid(data) {
+			continue
+		}
+		return s.format, data
+	}
+
+	return "", nil
+}
+
+// fetchProvenance returns the in-toto statements of a SLSA provenance
+// bundle as a JSON array.
+func fetchProvenance(artifactURL string) json.RawMessage {
+	data, err := fetchBytes(artifactURL + provenanceSuffix)
+	if err != nil {
+		return nil
+	}
+
+	var statements []json.RawMessage
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			return nil
+		}
+		statements = append(statements, append(json.RawMessage(nil), line...))
+	}
+
+	if len(statements) == 0 {
+		return nil
+	}
+
+	out, err := json.Marshal(statements)
+	if err != nil {
+		return nil
+	}
+
+	return out
+}
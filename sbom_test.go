@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportSBOM(t *testing.T) {
+	defer func(path, state, format, output string) {
+		distbuildPath, stateDirFlag, sbomFormat, sbomOutput = path, state, format, output
+	}(distbuildPath, stateDirFlag, sbomFormat, sbomOutput)
+
+	// The root --output selects the format, export must not shadow it
+	assert.Nil(t, sbomExportCmd.Flags().Lookup("output"))
+	assert.NotNil(t, sbomExportCmd.Flags().Lookup("output-file"))
+
+	distbuildPath, stateDirFlag = t.TempDir(), t.TempDir()
+	assert.NoError(t, saveManifest(&installManifest{Components: map[string]*manifestComponent{
+		"proxy": {Name: "proxy", SHA256: "abc", SBOMFormat: sbomFormatSPDX, SBOM: json.RawMessage(`{"spdxVersion":"SPDX-2.3"}`)},
+	}}))
+
+	sbomFormat, sbomOutput = sbomFormatCycloneDX, filepath.Join(t.TempDir(), "sbom.json")
+	assert.NoError(t, exportSBOM())
+	data, err := os.ReadFile(sbomOutput)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"components":[{"name":"proxy","url":"","sha256":"abc"}]}`, string(data))
+
+	sbomFormat = "json"
+	assert.Equal(t, errCodeUsage, errorCodeOf(exportSBOM()))
+}
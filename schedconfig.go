@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	schedulerConfigFile     = "scheduler-config.json"
+	schedulerToolchainsFile = "scheduler-toolchains.json"
+	schedulerCompatFile     = "scheduler-compat.json"
+)
+
+var schedulerConfigURL string
+
+// envFileKeys are the variables the env files set, pulled settings replace
+// them but never a variable of the process environment.
+var envFileKeys = map[string]bool{}
+
+// schedulerConfig is served by the control plane. Env carries the artifact
+// urls and versions under their env file keys, the manifests are either
+// inline documents or urls to fetch them from.
+type schedulerConfig struct {
+	Revision          string            `json:"revision"`
+	Env               map[string]string `json:"env"`
+	ToolchainManifest json.RawMessage   `json:"toolchain_manifest,omitempty"`
+	CompatMatrix      json.RawMessage   `json:"compat_matrix,omitempty"`
+}
+
+func addSchedulerConfigFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&schedulerConfigURL, "scheduler-config", "", "pull artifact urls, versions and manifests from this control plane url (default SCHEDULER_CONFIG_URL)")
+}
+
+// pullSchedulerConfig applies the configuration of the control plane over
+// the env files when one is configured. It runs once credentials are
+// resolved, secret references it brings in are resolved after it.
+func pullSchedulerConfig() error {
+	source := schedulerConfigURL
+	if source == "" {
+		source = os.Getenv("SCHEDULER_CONFIG_URL")
+	}
+	if source == "" {
+		return nil
+	}
+
+	data, err := fetchBytes(source)
+	if err != nil {
+		return withCode(errCodeNetwork, fmt.Errorf("fetch %s failed: %w", redactURL(source), err))
+	}
+
+	config := &schedulerConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("parse scheduler config failed: %w", err)
+	}
+
+	if err := applySchedulerConfig(config); err != nil {
+		return err
+	}
+
+	if distbuildPath != "" {
+		if err := writeStateFile(distbuildPath, schedulerConfigFile, data); err != nil {
+			warn("scheduler.record", "err", err)
+		}
+	}
+
+	logVerbose("scheduler config %s: %d settings", config.Revision, len(config.Env))
+
+	return resolveSecrets()
+}
+
+func applySchedulerConfig(config *schedulerConfig) error {
+	keys := make([]string, 0, len(config.Env))
+	for key := range config.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if _, set := os.LookupEnv(key); set && !envFileKeys[key] {
+			logVerbose("%s is set in the environment, ignoring the scheduler value", key)
+			continue
+		}
+		if err := os.Setenv(key, config.Env[key]); err != nil {
+			return err
+		}
+	}
+
+	var err error
+
+	if toolchainManifestSource == "" {
+		if toolchainManifestSource, err = schedulerDocument(config.ToolchainManifest, schedulerToolchainsFile); err != nil {
+			return fmt.Errorf("toolchain manifest: %w", err)
+		}
+	}
+
+	if compatMatrixSource == "" {
+		if compatMatrixSource, err = schedulerDocument(config.CompatMatrix, schedulerCompatFile); err != nil {
+			return fmt.Errorf("compat matrix: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// schedulerDocument turns a manifest of the scheduler config into a source
+// for its loader: a url is used as is, an inline document is kept as a
+// state file.
+func schedulerDocument(raw json.RawMessage, name string) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+
+	var source string
+	if err := json.Unmarshal(raw, &source); err == nil {
+		return source, nil
+	}
+
+	if distbuildPath == "" {
+		return "", fmt.Errorf("inline documents need --distbuild-path")
+	}
+
+	if err := writeStateFile(distbuildPath, name, raw); err != nil {
+		return "", err
+	}
+
+	return stateFile(distbuildPath, name), nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPullSchedulerConfig(t *testing.T) {
+	defer func(url, toolchains, compat, path string, ctx context.Context) {
+		schedulerConfigURL, toolchainManifestSource, compatMatrixSource, distbuildPath, runCtx = url, toolchains, compat, path, ctx
+		envFileKeys = map[string]bool{}
+	}(schedulerConfigURL, toolchainManifestSource, compatMatrixSource, distbuildPath, runCtx)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		assert.Equal(t, "builder:secret", user+":"+pass)
+		_, _ = w.Write([]byte(`{
+			"revision": "42",
+			"env": {"AGENT_BIN": "https://artifacts/agent-v2", "PROXY_BIN": "https://artifacts/proxy-v2"},
+			"toolchain_manifest": {"toolchains": [{"name": "clang", "repo": "toolchains/clang", "path": "clang"}]},
+			"compat_matrix": "https://artifacts/compat.json"
+		}`))
+	}))
+	defer ts.Close()
+
+	runCtx, distbuildPath = context.Background(), t.TempDir()
+	schedulerConfigURL, toolchainManifestSource, compatMatrixSource = "", "", ""
+	t.Setenv("AUTH_USER", "builder")
+	t.Setenv("AUTH_PASS", "secret")
+	t.Setenv("SCHEDULER_CONFIG_URL", "")
+
+	// Without an endpoint nothing is pulled
+	assert.NoError(t, pullSchedulerConfig())
+	assert.Empty(t, toolchainManifestSource)
+
+	// AGENT_BIN comes from an env file, PROXY_BIN from the caller
+	t.Setenv("AGENT_BIN", "https://artifacts/agent-v1")
+	t.Setenv("PROXY_BIN", "/opt/proxy")
+	envFileKeys = map[string]bool{"AGENT_BIN": true}
+	t.Setenv("SCHEDULER_CONFIG_URL", ts.URL)
+
+	assert.NoError(t, pullSchedulerConfig())
+	assert.Equal(t, "https://artifacts/agent-v2", os.Getenv("AGENT_BIN"))
+	assert.Equal(t, "/opt/proxy", os.Getenv("PROXY_BIN"))
+	assert.Equal(t, "https://artifacts/compat.json", compatMatrixSource)
+	assert.Equal(t, stateFile(distbuildPath, schedulerToolchainsFile), toolchainManifestSource)
+	assert.FileExists(t, stateFile(distbuildPath, schedulerConfigFile))
+
+	manifest, err := loadToolchainManifest(toolchainManifestSource)
+	require.NoError(t, err)
+	assert.Equal(t, "clang", manifest.Toolchains[0].Name)
+
+	// An explicit --toolchain-manifest wins
+	toolchainManifestSource = "/etc/toolchains.json"
+	assert.NoError(t, pullSchedulerConfig())
+	assert.Equal(t, "/etc/toolchains.json", toolchainManifestSource)
+
+	schedulerConfigURL = ts.URL + "/missing"
+	ts.Config.Handler = http.NotFoundHandler()
+	err = pullSchedulerConfig()
+	assert.Error(t, err)
+	assert.Equal(t, errCodeNetwork, errorCodeOf(err))
+}
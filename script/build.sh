@@ -3,6 +3,11 @@
 buildTime=$(date +%FT%T%z)
 commitID=`git rev-parse --short=7 HEAD`
 ldflags="-s -w -X main.BuildTime=$buildTime -X main.CommitID=$commitID"
+
+# Site defaults, see defaults.go
+[ -n "$BOOTSTRAP_REPO_HOST" ] && ldflags="$ldflags -X main.DefaultRepoHost=$BOOTSTRAP_REPO_HOST"
+[ -n "$BOOTSTRAP_CHANNEL" ] && ldflags="$ldflags -X main.DefaultChannel=$BOOTSTRAP_CHANNEL"
+[ -n "$BOOTSTRAP_ARTIFACT_BASE_URL" ] && ldflags="$ldflags -X main.DefaultArtifactBaseURL=$BOOTSTRAP_ARTIFACT_BASE_URL"
 target="bootstrap"
 
 go env -w GOPROXY=https://goproxy.cn,direct
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Config values with these schemes name a secret that is resolved at run
+// time, so credentials never have to be written to env files:
+//
+//	vault://secret/distbuild#password   Vault KV v1 or v2, VAULT_ADDR and VAULT_TOKEN
+//	awssm://distbuild/auth#password     AWS Secrets Manager, through the aws cli
+//	gcpsm://project/distbuild-auth      GCP Secret Manager, through gcloud
+//
+// The fragment selects a field of a JSON secret.
+const (
+	secretSchemeVault = "vault"
+	secretSchemeAWS   = "awssm"
+	secretSchemeGCP   = "gcpsm"
+)
+
+// secretCommand runs a secret manager cli and returns its stdout, tests
+// replace it.
+var secretCommand = func(name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(runCtx, name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w\n%s", name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return output, nil
+}
+
+func isSecretRef(value string) bool {
+	scheme, _, ok := strings.Cut(value, "://")
+
+	return ok && (scheme == secretSchemeVault || scheme == secretSchemeAWS || scheme == secretSchemeGCP)
+}
+
+// resolveSecrets replaces every environment value that references a secret
+// with the secret itself, for this process only.
+func resolveSecrets() error {
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		if !isSecretRef(value) {
+			continue
+		}
+
+		secret, err := resolveSecretValue(value)
+		if err != nil {
+			return fmt.Errorf("resolve %s failed: %w", key, err)
+		}
+
+		if err := os.Setenv(key, secret); err != nil {
+			return err
+		}
+
+		logVerbose("%s resolved from %s", key, redactURL(value))
+	}
+
+	return nil
+}
+
+// resolveSecretValue returns value itself unless it references a secret.
+func resolveSecretValue(value string) (string, error) {
+	if !isSecretRef(value) {
+		return value, nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", withCode(errCodeUsage, fmt.Errorf("invalid secret reference: %w", err))
+	}
+
+	name := strings.Trim(u.Host+u.Path, "/")
+	if name == "" {
+		return "", withCode(errCodeUsage, fmt.Errorf("secret reference %s names no secret", redactURL(value)))
+	}
+
+	var secret []byte
+	switch u.Scheme {
+	case secretSchemeVault:
+		return readVaultSecret(name, u.Fragment)
+	case secretSchemeAWS:
+		secret, err = secretCommand("aws", "secretsmanager", "get-secret-value",
+			"--secret-id", name, "--query", "SecretString", "--output", "text")
+	case secretSchemeGCP:
+		project, secretName, ok := strings.Cut(name, "/")
+		if !ok {
+			return "", withCode(errCodeUsage, fmt.Errorf("gcpsm reference must be gcpsm://project/secret"))
+		}
+		secret, err = secretCommand("gcloud", "secrets", "versions", "access", "latest",
+			"--secret="+secretName, "--project="+project)
+	}
+	if err != nil {
+		return "", withCode(errCodeAuth, err)
+	}
+
+	secret = bytes.TrimRight(secret, "\r\n")
+	if u.Fragment == "" {
+		return string(secret), nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(secret, &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object, cannot select %s", name, u.Fragment)
+	}
+
+	return secretField(fields, name, u.Fragment)
+}
+
+func secretField(fields map[string]interface{}, name, field string) (string, error) {
+	value, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no field %s", name, field)
+	}
+
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+
+	return fmt.Sprint(value), nil
+}
+
+func vaultToken() (string, error) {
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	// Where `vault login` keeps the token
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+	if err != nil {
+		return "", fmt.Errorf("VAULT_TOKEN not set and no ~/.vault-token")
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// readVaultSecret reads a KV v2 secret, the data path is derived from the
+// mount, and falls back to KV v1.
+func readVaultSecret(path, field string) (string, error) {
+	addr := strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return "", withCode(errCodeUsage, fmt.Errorf("environment variable VAULT_ADDR not set"))
+	}
+
+	if field == "" {
+		return "", withCode(errCodeUsage, fmt.Errorf("vault reference needs a #field"))
+	}
+
+	token, err := vaultToken()
+	if err != nil {
+		return "", withCode(errCodeAuth, err)
+	}
+
+	mount, rest, _ := strings.Cut(path, "/")
+
+	var v2 struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	found, err := getVault(addr+"/v1/"+mount+"/data/"+rest, token, &v2)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return secretField(v2.Data.Data, path, field)
+	}
+
+	var v1 struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if found, err = getVault(addr+"/v1/"+path, token, &v1); err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("vault secret %s not found", path)
+	}
+
+	return secretField(v1.Data, path, field)
+}
+
+// getVault decodes a Vault response into out, found is false for secrets
+// that do not exist at that path.
+func getVault(target, token string, out interface{}) (bool, error) {
+	req, err := http.NewRequestWithContext(runCtx, "GET", target, nil)
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("vault request failed: %w", err)
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized:
+		return false, withCode(errCodeAuth, fmt.Errorf("vault denied access: %s", resp.Status))
+	case resp.StatusCode != http.StatusOK:
+		return false, fmt.Errorf("vault request failed: %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return false, fmt.Errorf("parse vault response failed: %w", err)
+	}
+
+	return true, nil
+}
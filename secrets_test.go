@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveVaultSecret(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/distbuild":
+			_, _ = w.Write([]byte(`{"data":{"data":{"password":"kv2-pass"}}}`))
+		case "/v1/legacy/distbuild":
+			_, _ = w.Write([]byte(`{"data":{"password":"kv1-pass"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "token")
+
+	secret, err := resolveSecretValue("vault://secret/distbuild#password")
+	require.NoError(t, err)
+	assert.Equal(t, "kv2-pass", secret)
+
+	secret, err = resolveSecretValue("vault://legacy/distbuild#password")
+	require.NoError(t, err)
+	assert.Equal(t, "kv1-pass", secret)
+
+	_, err = resolveSecretValue("vault://secret/distbuild#user")
+	assert.ErrorContains(t, err, "has no field user")
+
+	_, err = resolveSecretValue("vault://secret/missing#password")
+	assert.ErrorContains(t, err, "not found")
+
+	t.Setenv("VAULT_TOKEN", "wrong")
+	_, err = resolveSecretValue("vault://secret/distbuild#password")
+	assert.Equal(t, errCodeAuth, errorCodeOf(err))
+}
+
+func TestResolveCLISecrets(t *testing.T) {
+	defer func(command func(string, ...string) ([]byte, error)) {
+		secretCommand = command
+	}(secretCommand)
+
+	var calls []string
+	secretCommand = func(name string, args ...string) ([]byte, error) {
+		calls = append(calls, name+" "+strings.Join(args, " "))
+		switch name {
+		case "aws":
+			return []byte(`{"user":"ci","password":"aws-pass"}` + "\n"), nil
+		case "gcloud":
+			return []byte("gcp-pass\n"), nil
+		}
+		return nil, errors.New("unexpected command")
+	}
+
+	t.Setenv("AUTH_USER", "awssm://distbuild/auth#user")
+	t.Setenv("AUTH_PASS", "gcpsm://build-project/distbuild-pass")
+	t.Setenv("REPO_HOST", "https://git.example.com")
+
+	require.NoError(t, resolveSecrets())
+	assert.Equal(t, "ci", os.Getenv("AUTH_USER"))
+	assert.Equal(t, "gcp-pass", os.Getenv("AUTH_PASS"))
+	assert.Equal(t, "https://git.example.com", os.Getenv("REPO_HOST"))
+
+	assert.ElementsMatch(t, []string{
+		"aws secretsmanager get-secret-value --secret-id distbuild/auth --query SecretString --output text",
+		"gcloud secrets versions access latest --secret=distbuild-pass --project=build-project",
+	}, calls)
+
+	_, err := resolveSecretValue("gcpsm://distbuild-pass")
+	assert.Equal(t, errCodeUsage, errorCodeOf(err))
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultServerAddr = "127.0.0.1:9470"
+
+	// serverJobHistory is how many finished jobs the server remembers
+	serverJobHistory = 50
+	// serverJobOutput caps the output kept per job
+	serverJobOutput = 64 << 10
+
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+var (
+	serverAddr    string
+	serverTLSCert string
+	serverTLSKey  string
+)
+
+var serverCmd = &cobra.Command{
+	Use:   "server",
+	Short: "serve install, upgrade and status of this host over an authenticated REST API",
+	Long: "Serve the provisioning of this host over HTTP for a portal to trigger it\n" +
+		"remotely. Requests need 'Authorization: Bearer <SERVER_TOKEN>'.\n\n" +
+		"  GET  /v1/status     status of the agent and the install\n" +
+		"  POST /v1/install    run bootstrap, body {\"aosp_path\", \"deploy_agent\",\n" +
+		"                      \"enable_toolchains\", \"enable_cache\", \"integrate_aosp\"}\n" +
+		"  POST /v1/upgrade    run 'agent upgrade'\n" +
+		"  GET  /v1/jobs/{id}  state, output and result of an install or upgrade\n\n" +
+		"Installs and upgrades run one at a time as bootstrap subprocesses with the\n" +
+		"env files and --distbuild-path of the server. /healthz and /readyz need no\n" +
+		"token.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := prepareAgentCommand(); err != nil {
+			exitWithError(err)
+		}
+		if err := resolveSecrets(); err != nil {
+			exitWithError(err)
+		}
+		if err := runServer(context.Background()); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	serverCmd.Flags().StringVar(&distbuildPath, "distbuild-path", "", "distbuild binaries path")
+	serverCmd.Flags().StringVar(&serverAddr, "listen", defaultServerAddr, "address to serve the API on")
+	serverCmd.Flags().StringVar(&serverTLSCert, "tls-cert", "", "serve https with this certificate")
+	serverCmd.Flags().StringVar(&serverTLSKey, "tls-key", "", "key of --tls-cert")
+	serverCmd.MarkFlagsRequiredTogether("tls-cert", "tls-key")
+	_ = serverCmd.MarkFlagRequired("distbuild-path")
+}
+
+// installRequest are the bootstrap flags a portal may set, everything else
+// is up to the server's env files.
+type installRequest struct {
+	AOSPPath         string `json:"aosp_path,omitempty"`
+	DeployAgent      bool   `json:"deploy_agent,omitempty"`
+	EnableToolchains bool   `json:"enable_toolchains,omitempty"`
+	EnableCache      bool   `json:"enable_cache,omitempty"`
+	IntegrateAOSP    bool   `json:"integrate_aosp,omitempty"`
+}
+
+func (r installRequest) args() ([]string, error) {
+	if r.AOSPPath != "" && r.DeployAgent {
+		return nil, errors.New("aosp_path and deploy_agent are exclusive")
+	}
+	if r.AOSPPath == "" && !r.DeployAgent {
+		return nil, errors.New("one of aosp_path or deploy_agent is required")
+	}
+
+	var args []string
+	if r.AOSPPath != "" {
+		args = append(args, "--aosp-path", r.AOSPPath)
+	}
+	for _, flag := range []struct {
+		name string
+		set  bool
+	}{
+		{"--deploy-agent", r.DeployAgent},
+		{"--enable-toolchains", r.EnableToolchains},
+		{"--enable-cache", r.EnableCache},
+		{"--integrate-aosp", r.IntegrateAOSP},
+	} {
+		if flag.set {
+			args = append(args, flag.name)
+		}
+	}
+
+	return args, nil
+}
+
+// serverJob is an install or upgrade the server ran or runs.
+type serverJob struct {
+	ID         string          `json:"id"`
+	Operation  string          `json:"operation"`
+	Args       []string        `json:"args"`
+	State      string          `json:"state"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	ExitCode   int             `json:"exit_code"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Output     string          `json:"output,omitempty"`
+}
+
+type provisioningServer struct {
+	ctx   context.Context
+	token string
+
+	mu     sync.Mutex
+	jobs   map[string]*serverJob
+	order  []string
+	nextID int
+	// active is the job that runs, provisioning one host twice at the same
+	// time would trip over its own files
+	active *serverJob
+	wg     sync.WaitGroup
+}
+
+// runServer serves until bootstrap is interrupted, running jobs are
+// stopped with it.
+func runServer(ctx context.Context) error {
+	token := os.Getenv("SERVER_TOKEN")
+	if token == "" {
+		return withCode(errCodeUsage, fmt.Errorf("SERVER_TOKEN is not set, the server does not run without authentication"))
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listener, err := net.Listen("tcp", serverAddr)
+	if err != nil {
+		return withCode(errCodeUsage, fmt.Errorf("listen on %s failed: %w", serverAddr, err))
+	}
+
+	srv := newProvisioningServer(ctx, token)
+	httpServer := &http.Server{Handler: srv.handler(), ReadHeaderTimeout: 10 * time.Second}
+
+	trackHealth("server")
+	defer untrackHealth()
+	setHealth("idle", true, nil)
+
+	scheme := "http"
+	if serverTLSCert != "" {
+		scheme = "https"
+	} else if host, _, _ := net.SplitHostPort(serverAddr); !isLoopbackHost(host) {
+		warn("server.insecure", "addr", serverAddr)
+	}
+	printMsg("server.listening", "url", scheme+"://"+listener.Addr().String())
+
+	served := make(chan error, 1)
+	go func() {
+		if serverTLSCert != "" {
+			served <- httpServer.ServeTLS(listener, serverTLSCert, serverTLSKey)
+		} else {
+			served <- httpServer.Serve(listener)
+		}
+	}()
+
+	select {
+	case err = <-served:
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), healthShutdownTimeout)
+		defer cancel()
+		err = httpServer.Shutdown(shutdownCtx)
+	}
+	srv.wg.Wait()
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("server failed: %w", err)
+	}
+
+	return nil
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+func newProvisioningServer(ctx context.Context, token string) *provisioningServer {
+	return &provisioningServer{ctx: ctx, token: token, jobs: map[string]*serverJob{}}
+}
+
+func (s *provisioningServer) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	health := healthHandler()
+	mux.Handle("GET /healthz", health)
+	mux.Handle("GET /readyz", health)
+
+	mux.HandleFunc("GET /v1/status", s.authenticated(s.status))
+	mux.HandleFunc("POST /v1/install", s.authenticated(s.install))
+	mux.HandleFunc("POST /v1/upgrade", s.authenticated(s.upgrade))
+	mux.HandleFunc("GET /v1/jobs/{id}", s.authenticated(s.job))
+
+	return mux
+}
+
+func (s *provisioningServer) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *provisioningServer) status(w http.ResponseWriter, r *http.Request) {
+	status, err := collectHostStatus(time.Now())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *provisioningServer) install(w http.ResponseWriter, r *http.Request) {
+	var req installRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	args, err := req.args()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.start(w, r, "install", args)
+}
+
+func (s *provisioningServer) upgrade(w http.ResponseWriter, r *http.Request) {
+	s.start(w, r, "upgrade", []string{"agent", "upgrade"})
+}
+
+func (s *provisioningServer) job(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	job, ok := s.jobs[r.PathValue("id")]
+	var snapshot serverJob
+	if ok {
+		snapshot = *job
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no such job")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// start runs a job unless another one runs, the client polls the job it
+// gets back.
+func (s *provisioningServer) start(w http.ResponseWriter, r *http.Request, operation string, args []string) {
+	s.mu.Lock()
+	if s.active != nil {
+		id := s.active.ID
+		s.mu.Unlock()
+		writeJSONError(w, http.StatusConflict, "job "+id+" is still running")
+		return
+	}
+
+	s.nextID++
+	job := &serverJob{ID: strconv.Itoa(s.nextID), Operation: operation, Args: args, State: jobRunning, StartedAt: time.Now().UTC()}
+	s.jobs[job.ID] = job
+	s.order = append(s.order, job.ID)
+	if len(s.order) > serverJobHistory {
+		delete(s.jobs, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.active = job
+	snapshot := *job
+	s.mu.Unlock()
+
+	setHealth("busy", true, map[string]any{"job": job.ID, "operation": operation})
+	recordAudit("server "+operation, r.RemoteAddr, nil)
+
+	s.wg.Add(1)
+	go s.run(job)
+
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+// serverCommand runs a job, tests replace it.
+var serverCommand = func(ctx context.Context, args []string) *exec.Cmd {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+
+	return exec.CommandContext(ctx, exe, args...)
+}
+
+// run executes a job as a bootstrap subprocess with JSON output: stdout is
+// its result, stderr what it printed along the way. The subprocess keeps
+// the globals of the CLI out of the long-running server.
+func (s *provisioningServer) run(job *serverJob) {
+	defer s.wg.Done()
+
+	args := append([]string(nil), job.Args...)
+	args = append(args, "--distbuild-path", distbuildPath, "--output", outputJSON)
+	for _, file := range envFiles {
+		args = append(args, "--env-file", file)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := serverCommand(s.ctx, args)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+
+	finished := time.Now().UTC()
+
+	s.mu.Lock()
+	job.FinishedAt = &finished
+	job.State = jobSucceeded
+	if err != nil {
+		job.State = jobFailed
+		job.ExitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			job.ExitCode = exitErr.ExitCode()
+		}
+	}
+	// Subcommands without JSON results print to stdout
+	if result := bytes.TrimSpace(stdout.Bytes()); json.Valid(result) {
+		job.Result = result
+	} else {
+		stderr.Write(stdout.Bytes())
+	}
+	job.Output = string(tailBytes(stderr.Bytes(), serverJobOutput))
+	s.active = nil
+	s.mu.Unlock()
+
+	recordAudit("server "+job.Operation+" job "+job.ID, distbuildPath, err)
+	setHealth("idle", true, map[string]any{"last_job": job.ID, "last_state": job.State})
+}
+
+// tailBytes keeps the last n bytes, the end of a log has the error.
+func tailBytes(data []byte, n int) []byte {
+	if len(data) <= n {
+		return data
+	}
+
+	return data[len(data)-n:]
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serverRequest(t *testing.T, method, url, token, body string) (*http.Response, map[string]any) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var decoded map[string]any
+	_ = json.NewDecoder(resp.Body).Decode(&decoded)
+
+	return resp, decoded
+}
+
+func TestProvisioningServer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs shell scripts")
+	}
+
+	defer func(path, state string, files []string) {
+		distbuildPath, stateDirFlag, envFiles = path, state, files
+	}(distbuildPath, stateDirFlag, envFiles)
+	distbuildPath, stateDirFlag, envFiles = t.TempDir(), t.TempDir(), []string{"/etc/distbuild/site.env"}
+
+	command := serverCommand
+	defer func() {
+		serverCommand = command
+	}()
+
+	// The stand-in bootstrap echoes its arguments as the result once the
+	// test lets it finish
+	release := make(chan struct{})
+	serverCommand = func(ctx context.Context, args []string) *exec.Cmd {
+		<-release
+		script := `echo "progress" >&2; printf '{"status":"ok","args":"%s"}\n' "$*"`
+		if args[0] == "agent" {
+			script = `echo "agent upgrade failed"; exit 3`
+		}
+		return exec.CommandContext(ctx, "sh", append([]string{"-c", script, "bootstrap"}, args...)...)
+	}
+
+	srv := newProvisioningServer(context.Background(), "s3cret")
+	ts := httptest.NewServer(srv.handler())
+	defer ts.Close()
+
+	resp, _ := serverRequest(t, http.MethodGet, ts.URL+"/v1/status", "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, _ = serverRequest(t, http.MethodGet, ts.URL+"/v1/status", "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, status := serverRequest(t, http.MethodGet, ts.URL+"/v1/status", "s3cret", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, false, status["running"])
+
+	resp, body := serverRequest(t, http.MethodPost, ts.URL+"/v1/install", "s3cret", `{"aosp_path": "/src", "deploy_agent": true}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, body["error"], "exclusive")
+
+	resp, job := serverRequest(t, http.MethodPost, ts.URL+"/v1/install", "s3cret", `{"aosp_path": "/src", "enable_toolchains": true}`)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "/v1/jobs/1", resp.Header.Get("Location"))
+	assert.Equal(t, jobRunning, job["state"])
+
+	// One job at a time
+	resp, _ = serverRequest(t, http.MethodPost, ts.URL+"/v1/upgrade", "s3cret", "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	close(release)
+	srv.wg.Wait()
+
+	resp, job = serverRequest(t, http.MethodGet, ts.URL+"/v1/jobs/1", "s3cret", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, jobSucceeded, job["state"])
+	assert.Equal(t, "progress\n", job["output"])
+	result, _ := job["result"].(map[string]any)
+	assert.Equal(t, "--aosp-path /src --enable-toolchains --distbuild-path "+distbuildPath+" --output json --env-file /etc/distbuild/site.env", result["args"])
+
+	resp, _ = serverRequest(t, http.MethodPost, ts.URL+"/v1/upgrade", "s3cret", "")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	srv.wg.Wait()
+
+	_, job = serverRequest(t, http.MethodGet, ts.URL+"/v1/jobs/2", "s3cret", "")
+	assert.Equal(t, jobFailed, job["state"])
+	assert.Equal(t, float64(3), job["exit_code"])
+	assert.Equal(t, "agent upgrade failed\n", job["output"])
+
+	resp, _ = serverRequest(t, http.MethodGet, ts.URL+"/v1/jobs/9", "s3cret", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestRunServerNeedsToken(t *testing.T) {
+	t.Setenv("SERVER_TOKEN", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := runServer(ctx)
+	assert.Equal(t, errCodeUsage, errorCodeOf(err))
+}
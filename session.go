@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const sessionVersion = 1
+
+var (
+	recordPath   string
+	replayTarget string
+
+	// recording collects the actions of the run while --record is set
+	recording *sessionRecorder
+)
+
+// session is a recorded bootstrap run: how it was invoked, the settings it
+// ran with, what it did and what it installed.
+type session struct {
+	Version       int                         `json:"version"`
+	RecordedAt    time.Time                   `json:"recorded_at"`
+	Bootstrap     string                      `json:"bootstrap"`
+	Platform      string                      `json:"platform"`
+	Hostname      string                      `json:"hostname,omitempty"`
+	Args          []string                    `json:"args"`
+	DistbuildPath string                      `json:"distbuild_path"`
+	Config        map[string]string           `json:"config"`
+	Actions       []sessionAction             `json:"actions"`
+	Components    map[string]sessionComponent `json:"components,omitempty"`
+	Toolchains    map[string]sessionToolchain `json:"toolchains,omitempty"`
+	Error         *resultError                `json:"error,omitempty"`
+}
+
+// sessionAction is a step of the run, or a command or install within one
+// together with what went in and came out.
+type sessionAction struct {
+	Kind       string            `json:"kind"`
+	Name       string            `json:"name"`
+	Time       time.Time         `json:"time"`
+	DurationMS int64             `json:"duration_ms,omitempty"`
+	Inputs     map[string]string `json:"inputs,omitempty"`
+	Outputs    map[string]string `json:"outputs,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+type sessionComponent struct {
+	URL     string `json:"url"`
+	SHA256  string `json:"sha256"`
+	Version string `json:"version,omitempty"`
+}
+
+type sessionToolchain struct {
+	Repo     string `json:"repo"`
+	Revision string `json:"revision"`
+}
+
+type sessionRecorder struct {
+	mu      sync.Mutex
+	args    []string
+	actions []sessionAction
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay session.json",
+	Short: "repeat a recorded bootstrap run here or on another host",
+	Long: "Run bootstrap again with the flags and settings of a session recorded with\n" +
+		"--record. Secrets are not recorded, pass them with --env-file. A local replay\n" +
+		"checks that it installed the recorded binaries and toolchain revisions.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := checkTimeout(); err != nil {
+			exitWithError(withCode(errCodeUsage, err))
+		}
+		ctx, cancel := runContext()
+		defer cancel()
+		if err := replaySession(ctx, args[0]); err != nil {
+			exitWithError(timeoutError(ctx, err))
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	replayCmd.Flags().StringVar(&replayTarget, "target", "", "inventory host name or ssh address to replay on (default this host)")
+	replayCmd.Flags().StringVar(&inventoryPath, "inventory", "", "inventory file to look --target up in")
+	replayCmd.Flags().StringArrayVar(&sshOptions, "ssh-option", nil, "extra ssh -o option for --target (repeatable)")
+	replayCmd.Flags().StringArrayVar(&deployBinaries, "binary", nil, "bootstrap executable for other platforms as os/arch=path (repeatable)")
+	addTimeoutFlags(replayCmd)
+}
+
+func addRecordFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&recordPath, "record", "", "record the flags, settings, actions and installed versions of this run to a session file for `bootstrap replay`")
+}
+
+// startRecording begins a session of the current invocation.
+func startRecording() {
+	if recordPath == "" && !collectDiagnostics {
+		return
+	}
+
+	recording = &sessionRecorder{args: sessionArgs(os.Args[1:])}
+}
+
+// sessionArgs drops the flags a replay must not repeat: --record itself and
+// env files, whose settings the session carries.
+func sessionArgs(args []string) []string {
+	kept := make([]string, 0, len(args))
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--record" || arg == "--env-file":
+			i++
+		case strings.HasPrefix(arg, "--record=") || strings.HasPrefix(arg, "--env-file="):
+		default:
+			kept = append(kept, arg)
+		}
+	}
+
+	return kept
+}
+
+// recordAction adds an action to the session, it does nothing unless the
+// run is recorded.
+func recordAction(kind, name string, inputs, outputs map[string]string, err error) {
+	if recording == nil {
+		return
+	}
+
+	action := sessionAction{Kind: kind, Name: name, Time: time.Now().UTC(), Inputs: inputs, Outputs: outputs}
+	if err != nil {
+		action.Error = err.Error()
+	}
+
+	recording.mu.Lock()
+	defer recording.mu.Unlock()
+
+	recording.actions = append(recording.actions, action)
+}
+
+// gitInputs records the arguments of a git command without credentials in
+// its urls.
+func gitInputs(args []string) map[string]string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = arg
+		if strings.Contains(arg, "://") {
+			redacted[i] = redactURL(arg)
+		}
+	}
+
+	return map[string]string{"args": strings.Join(redacted, " ")}
+}
+
+// runConfig is the layered configuration as the run saw it, the process
+// environment overrides the env files.
+func runConfig() (map[string]envEntry, error) {
+	entries, err := layerEnvFiles(envFile, envFiles)
+	if err != nil {
+		return nil, fmt.Errorf("load .env failed: %w", err)
+	}
+
+	for key := range entries {
+		if value, ok := os.LookupEnv(key); ok {
+			entries[key] = envEntry{Key: key, Value: value, Source: "environment"}
+		}
+	}
+
+	return entries, nil
+}
+
+// gitOutputs keeps what git printed besides progress, the reason a clone
+// failed is in there.
+func gitOutputs(stderr *bytes.Buffer) map[string]string {
+	if stderr.Len() == 0 {
+		return nil
+	}
+
+	return map[string]string{"stderr": strings.TrimSpace(stderr.String())}
+}
+
+// runConfig the recorded session. Failed runs are recorded too,
+// they are the ones that end up in bug reports.
+func writeSession(start time.Time, runErr error) error {
+	if recording == nil || recordPath == "" {
+		return nil
+	}
+
+	sess, err := recordedSession(start, runErr)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(sess, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path, err := expandPath(recordPath)
+	if err != nil {
+		return fmt.Errorf("failed to expand path: %w", err)
+	}
+	// Settings may be sensitive even without credentials
+	if err := writeFileAtomic(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("write session failed: %w", err)
+	}
+
+	printMsg("session.recorded", "path", path)
+
+	return nil
+}
+
+func recordedSession(start time.Time, runErr error) (*session, error) {
+	sess := &session{
+		Version:       sessionVersion,
+		RecordedAt:    start.UTC(),
+		Bootstrap:     BuildTime + "-" + CommitID,
+		Platform:      runtime.GOOS + "/" + runtime.GOARCH,
+		Args:          recording.args,
+		DistbuildPath: distbuildPath,
+		Components:    map[string]sessionComponent{},
+		Toolchains:    map[string]sessionToolchain{},
+	}
+	sess.Hostname, _ = os.Hostname()
+
+	entries, err := runConfig()
+	if err != nil {
+		return nil, err
+	}
+	sess.Config = shareableConfig(entries)
+
+	for _, entry := range timings.report(start).Entries {
+		sess.Actions = append(sess.Actions, sessionAction{Kind: entry.Kind, Name: entry.Name, Time: entry.Start.UTC(), DurationMS: entry.DurationMS, Error: entry.Error})
+	}
+	recording.mu.Lock()
+	sess.Actions = append(sess.Actions, recording.actions...)
+	recording.mu.Unlock()
+	sort.SliceStable(sess.Actions, func(i, j int) bool {
+		return sess.Actions[i].Time.Before(sess.Actions[j].Time)
+	})
+
+	if distbuildPath != "" {
+		manifest, err := loadManifest()
+		if err != nil {
+			return nil, err
+		}
+		for name, c := range manifest.Components {
+			sess.Components[name] = sessionComponent{URL: redactURL(c.URL), SHA256: c.SHA256, Version: c.Version}
+		}
+		for name, tc := range manifest.Toolchains {
+			sess.Toolchains[name] = sessionToolchain{Repo: tc.Repo, Revision: tc.Revision}
+		}
+	}
+
+	if runErr != nil {
+		code := errorCodeOf(runErr)
+		sess.Error = &resultError{Code: code, Message: runErr.Error(), Hint: hintOf(runErr), ExitCode: exitCodes[code]}
+	}
+
+	return sess, nil
+}
+
+func loadSession(path string) (*session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, withCode(errCodeUsage, fmt.Errorf("read session failed: %w", err))
+	}
+
+	sess := &session{}
+	if err := json.Unmarshal(data, sess); err != nil {
+		return nil, fmt.Errorf("parse session %s failed: %w", path, err)
+	}
+	if sess.Version != sessionVersion {
+		return nil, fmt.Errorf("session %s has unsupported version %d", path, sess.Version)
+	}
+
+	return sess, nil
+}
+
+// replaySession runs bootstrap with the recorded flags, the recorded
+// settings go first so --env-file can add the secrets.
+func replaySession(ctx context.Context, path string) error {
+	sess, err := loadSession(path)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "bootstrap-replay-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	configPath := filepath.Join(dir, "session.env")
+	if err := os.WriteFile(configPath, []byte(snapshotEnv(sess.Config)), 0600); err != nil {
+		return fmt.Errorf("write session config failed: %w", err)
+	}
+	files := append([]string{configPath}, envFiles...)
+
+	if replayTarget != "" {
+		return replayRemote(ctx, sess, path, files)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locate bootstrap executable failed: %w", err)
+	}
+
+	args := make([]string, 0, len(files)*2+len(sess.Args))
+	for _, file := range files {
+		args = append(args, "--env-file", file)
+	}
+
+	cmd := exec.CommandContext(ctx, exe, append(args, sess.Args...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("replayed bootstrap failed: %w", err)
+	}
+
+	distbuildPath = sess.DistbuildPath
+	if err := checkReplay(sess); err != nil {
+		return err
+	}
+
+	printMsg("replay.done", "path", path)
+
+	return nil
+}
+
+// replayRemote runs the session on --target like `deploy push` does. The
+// host keeps its own manifest, compare it with `bootstrap diff` there.
+func replayRemote(ctx context.Context, sess *session, path string, files []string) error {
+	binaries, err := parseDeployBinaries(deployBinaries)
+	if err != nil {
+		return withCode(errCodeUsage, err)
+	}
+
+	host := inventoryHost{Name: replayTarget, Address: replayTarget}
+	if inventoryPath != "" {
+		inv, err := loadInventory(inventoryPath)
+		if err != nil {
+			return err
+		}
+		hosts, err := selectHosts(inv.Hosts, []string{replayTarget})
+		if err != nil {
+			return withCode(errCodeUsage, err)
+		}
+		host = hosts[0]
+	}
+
+	envFiles = files
+	if err := pushBootstrap(ctx, host, binaries, sess.Args); err != nil {
+		return fmt.Errorf("replay on %s failed: %w", host.Name, err)
+	}
+
+	printMsg("replay.remote", "path", path, "host", host.Name)
+
+	return nil
+}
+
+// checkReplay compares what the replay installed with the recording.
+func checkReplay(sess *session) error {
+	manifest, err := loadManifest()
+	if err != nil {
+		return err
+	}
+
+	mismatches := 0
+	for name, want := range sess.Components {
+		got := manifest.Components[name]
+		if got == nil || got.SHA256 != want.SHA256 {
+			mismatches++
+			installed := "-"
+			if got != nil {
+				installed = versionOr(got.Version, got.SHA256)
+			}
+			warn("replay.mismatch", "name", name, "recorded", versionOr(want.Version, want.SHA256), "installed", installed)
+		}
+	}
+	for name, want := range sess.Toolchains {
+		got := manifest.Toolchains[name]
+		if got == nil || got.Revision != want.Revision {
+			mismatches++
+			installed := "-"
+			if got != nil {
+				installed = got.Revision
+			}
+			warn("replay.mismatch", "name", name, "recorded", want.Revision, "installed", installed)
+		}
+	}
+
+	if mismatches > 0 {
+		return withCode(errCodeVerification, fmt.Errorf("replay installed %d component(s) or toolchain(s) other than recorded", mismatches))
+	}
+
+	return nil
+}
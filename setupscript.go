@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// setupScripts are written to build/distbuild for the shells that source
+// AOSP's envsetup.sh.
+var setupScripts = []string{"setup.sh", "setup.zsh"}
+
+// writeSetupScripts generates the scripts that activate distbuild in a
+// build shell. They are sourced after lunch, which rewrites PATH, and are
+// generated from the manifest so they follow every install.
+func writeSetupScripts() error {
+	vars, err := buildEnv()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := loadManifest()
+	if err != nil {
+		return err
+	}
+
+	_, _, subPath, err := distbuildRepoSource()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(aospPath, "build", "distbuild")
+	binDir := filepath.Join(distbuildPath, "boong", "bin")
+	hooksDir := filepath.Join(aospPath, subPath, "hooks")
+
+	header := setupScriptHeader(manifest)
+	scripts := map[string]string{
+		"setup.sh":  header + setupScriptSh(vars, binDir, hooksDir),
+		"setup.zsh": header + setupScriptZsh(vars, binDir, hooksDir),
+	}
+
+	for _, name := range setupScripts {
+		if err := writeFileAtomic(filepath.Join(dir, name), []byte(scripts[name]), 0644); err != nil {
+			return fmt.Errorf("write %s failed: %w", name, err)
+		}
+	}
+
+	// The scripts are not part of the checkout, keep them out of its status
+	if err := excludeFromCheckout(dir, setupScripts); err != nil {
+		logVerbose("exclude setup scripts failed: %v", err)
+	}
+
+	printMsg("aosp.setup", "path", filepath.Join(dir, setupScripts[0]))
+
+	return nil
+}
+
+func setupScriptHeader(manifest *installManifest) string {
+	var b strings.Builder
+
+	b.WriteString("# Generated by bootstrap, rerun bootstrap instead of editing it.\n")
+	b.WriteString("# Source it after lunch to use distbuild in this shell.\n#\n")
+	for _, c := range manifest.sortedComponents() {
+		fmt.Fprintf(&b, "# %s %s sha256:%s\n", c.Name, versionOr(c.Version, c.SHA256), shortHash(c.SHA256))
+	}
+	b.WriteString("\n")
+	b.WriteString("if [ -z \"${TARGET_PRODUCT:-}\" ]; then\n")
+	b.WriteString("\techo \"distbuild: no lunch target selected, lunch resets PATH, source this again after lunch\" >&2\n")
+	b.WriteString("fi\n\n")
+
+	return b.String()
+}
+
+func setupScriptSh(vars []buildEnvVar, binDir, hooksDir string) string {
+	var b strings.Builder
+
+	for _, v := range vars {
+		fmt.Fprintf(&b, "export %s=%s\n", v.Key, shellQuote(v.Value))
+	}
+	if keys := remoteExecSwitches(); len(keys) > 0 {
+		fmt.Fprintf(&b, "unset %s\n", strings.Join(keys, " "))
+	}
+
+	fmt.Fprintf(&b, "\ncase \":$PATH:\" in\n*:%s:*) ;;\n*) export PATH=%s:\"$PATH\" ;;\nesac\n", shellQuote(binDir), shellQuote(binDir))
+	fmt.Fprintf(&b, "\nfor hook in %s/*.sh; do\n\t[ -r \"$hook\" ] && . \"$hook\"\ndone\nunset hook\n", shellQuote(hooksDir))
+
+	return b.String()
+}
+
+func setupScriptZsh(vars []buildEnvVar, binDir, hooksDir string) string {
+	var b strings.Builder
+
+	for _, v := range vars {
+		fmt.Fprintf(&b, "export %s=%s\n", v.Key, shellQuote(v.Value))
+	}
+	if keys := remoteExecSwitches(); len(keys) > 0 {
+		fmt.Fprintf(&b, "unset %s\n", strings.Join(keys, " "))
+	}
+
+	fmt.Fprintf(&b, "\ntypeset -U path\npath=(%s $path)\n", shellQuote(binDir))
+	fmt.Fprintf(&b, "\nfor hook in %s/*.sh(N); do\n\tsource \"$hook\"\ndone\nunset hook\n", shellQuote(hooksDir))
+
+	return b.String()
+}
+
+// excludeFromCheckout adds names to the local excludes of the checkout at
+// dir, if dir is one.
+func excludeFromCheckout(dir string, names []string) error {
+	gitDir := filepath.Join(dir, ".git")
+	if info, err := os.Stat(gitDir); err != nil || !info.IsDir() {
+		return nil
+	}
+
+	excludePath := filepath.Join(gitDir, "info", "exclude")
+	data, err := os.ReadFile(excludePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	content := string(data)
+	for _, name := range names {
+		if !strings.Contains("\n"+content, "\n/"+name+"\n") {
+			if content != "" && !strings.HasSuffix(content, "\n") {
+				content += "\n"
+			}
+			content += "/" + name + "\n"
+		}
+	}
+
+	if content == string(data) {
+		return nil
+	}
+
+	if err := createDirs(filepath.Dir(excludePath)); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(excludePath, []byte(content), 0644); err != nil {
+		return err
+	}
+
+	noteChange("write " + excludePath)
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteSetupScripts(t *testing.T) {
+	defer func(distbuild, aosp string, cache bool) {
+		distbuildPath, aospPath, enableCache = distbuild, aosp, cache
+	}(distbuildPath, aospPath, enableCache)
+	distbuildPath, aospPath, enableCache = t.TempDir(), t.TempDir(), false
+
+	t.Setenv("REPO_HOST", "https://git.example.com")
+	t.Setenv("DISTBUILD_REPO", "distbuild")
+
+	checkout := filepath.Join(aospPath, "build", "distbuild")
+	assert.NoError(t, exec.Command("git", "init", "-q", checkout).Run())
+	assert.NoError(t, os.MkdirAll(filepath.Join(checkout, "hooks"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(checkout, "hooks", "wrap.sh"), []byte("export DISTBUILD_HOOKED=1\n"), 0644))
+
+	binDir := filepath.Join(distbuildPath, "boong", "bin")
+	assert.NoError(t, os.MkdirAll(binDir, 0755))
+	ninja := filepath.Join(binDir, "distninja")
+	assert.NoError(t, os.WriteFile(ninja, []byte("distninja"), 0755))
+	assert.NoError(t, recordComponent("distninja", "https://artifacts.invalid/distninja", ninja))
+
+	output := captureStdout(t, func() {
+		assert.NoError(t, writeSetupScripts())
+		assert.NoError(t, writeSetupScripts())
+	})
+	assert.Contains(t, output, filepath.Join(checkout, "setup.sh"))
+
+	script, err := os.ReadFile(filepath.Join(checkout, "setup.sh"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(script), "# distninja "+shortHash(sha256Hex([]byte("distninja"))))
+
+	zsh, err := os.ReadFile(filepath.Join(checkout, "setup.zsh"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(zsh), "path=("+binDir+" $path)")
+
+	// Sourcing twice keeps one PATH entry and runs the hooks
+	cmd := exec.Command("sh", "-c", `. ./setup.sh; . ./setup.sh; echo "$NINJA|$DISTBUILD_HOOKED|$PATH"`)
+	cmd.Dir = checkout
+	cmd.Env = append(os.Environ(), "TARGET_PRODUCT=aosp_arm64", "PATH=/usr/bin:/bin")
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, ninja+"|1|"+binDir+":/usr/bin:/bin", strings.TrimSpace(string(out)))
+
+	status, err := exec.Command("git", "-C", checkout, "status", "--porcelain").Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "?? hooks/\n", string(status))
+
+	exclude, err := os.ReadFile(filepath.Join(checkout, ".git", "info", "exclude"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(exclude), "/setup.sh\n"))
+}
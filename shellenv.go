@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	shellPOSIX      = "sh"
+	shellFish       = "fish"
+	shellPowerShell = "powershell"
+)
+
+var (
+	exportEnv bool
+	envShell  string
+)
+
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "print the environment that wires distbuild into an AOSP build shell",
+	Long: `Print the environment that wires the installed distbuild components into
+an AOSP build. With --export the output can be evaluated by the shell:
+
+  eval "$(bootstrap env --export --distbuild-path ~/distbuild --aosp-path ~/aosp)"`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := printBuildEnv(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	envCmd.Flags().StringVar(&distbuildPath, "distbuild-path", "", "distbuild binaries path")
+	envCmd.Flags().StringVar(&aospPath, "aosp-path", "", "aosp workspace the build shell is for")
+	envCmd.Flags().BoolVar(&enableCache, "enable-cache", false, "include the compiler cache settings")
+	envCmd.Flags().BoolVar(&exportEnv, "export", false, "print shell commands for eval instead of KEY=value lines")
+	envCmd.Flags().StringVar(&envShell, "shell", "", "shell syntax of --export: sh, fish, powershell (default from $SHELL)")
+
+	_ = envCmd.MarkFlagRequired("distbuild-path")
+}
+
+type buildEnvVar struct {
+	Key   string
+	Value string
+}
+
+func printBuildEnv() error {
+	var err error
+
+	if distbuildPath, err = expandPath(distbuildPath); err != nil {
+		return withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err))
+	}
+	if aospPath, err = expandPath(aospPath); err != nil {
+		return withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err))
+	}
+
+	shell := envShell
+	if shell == "" {
+		shell = defaultShell()
+	}
+	if shell != shellPOSIX && shell != shellFish && shell != shellPowerShell {
+		return withCode(errCodeUsage, fmt.Errorf("unsupported shell %q", shell))
+	}
+
+	if err := loadEnvFiles(envFile, envFiles); err != nil {
+		return fmt.Errorf("load .env failed: %w", err)
+	}
+
+	vars, err := buildEnv()
+	if err != nil {
+		return err
+	}
+
+	binDir := filepath.Join(distbuildPath, "boong", "bin")
+	if exportEnv {
+		fmt.Print(exportScript(shell, vars, binDir))
+		return nil
+	}
+
+	for _, v := range vars {
+		fmt.Printf("%s=%s\n", v.Key, v.Value)
+	}
+
+	return nil
+}
+
+// buildEnv lists the variables the AOSP build reads, for the components
+// the manifest records as installed. They match what --integrate-aosp
+// and --enable-cache write to buildspec.mk.
+func buildEnv() ([]buildEnvVar, error) {
+	manifest, err := loadManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	vars := []buildEnvVar{{"DISTBUILD_PATH", distbuildPath}}
+
+	if c := manifest.Components["distninja"]; c != nil {
+		vars = append(vars, buildEnvVar{"NINJA", c.Path})
+	}
+	if c := manifest.Components["proxy"]; c != nil {
+		vars = append(vars, buildEnvVar{"DISTBUILD_PROXY", c.Path})
+	}
+
+	if aospPath != "" {
+		_, _, subPath, err := distbuildRepoSource()
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, buildEnvVar{"DISTBUILD_CHECKOUT", filepath.Join(aospPath, subPath)})
+	}
+
+	if !enableCache {
+		return vars, nil
+	}
+
+	switch mode := os.Getenv("CACHE_MODE"); mode {
+	case "", cacheModeCcache:
+		ccache, cacheDir, err := ccacheSettings()
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, buildEnvVar{"USE_CCACHE", "1"}, buildEnvVar{"CCACHE_EXEC", ccache}, buildEnvVar{"CCACHE_DIR", cacheDir})
+	case cacheModeRemote:
+		endpoint := os.Getenv("REMOTE_CACHE_ENDPOINT")
+		if endpoint == "" {
+			return nil, fmt.Errorf("environment variable REMOTE_CACHE_ENDPOINT not set")
+		}
+		vars = append(vars, buildEnvVar{"DISTBUILD_CACHE_ENDPOINT", endpoint})
+	default:
+		return nil, fmt.Errorf("unsupported CACHE_MODE %q", mode)
+	}
+
+	return vars, nil
+}
+
+func defaultShell() string {
+	if runtime.GOOS == "windows" {
+		return shellPowerShell
+	}
+
+	switch filepath.Base(os.Getenv("SHELL")) {
+	case "fish":
+		return shellFish
+	case "pwsh", "powershell":
+		return shellPowerShell
+	default:
+		return shellPOSIX
+	}
+}
+
+// exportScript renders vars in the syntax of shell and puts binDir first
+// on the PATH.
+func exportScript(shell string, vars []buildEnvVar, binDir string) string {
+	var b strings.Builder
+
+	for _, v := range vars {
+		switch shell {
+		case shellFish:
+			fmt.Fprintf(&b, "set -gx %s %s;\n", v.Key, fishQuote(v.Value))
+		case shellPowerShell:
+			fmt.Fprintf(&b, "$env:%s = %s\n", v.Key, powerShellQuote(v.Value))
+		default:
+			fmt.Fprintf(&b, "export %s=%s\n", v.Key, shellQuote(v.Value))
+		}
+	}
+
+	switch shell {
+	case shellFish:
+		fmt.Fprintf(&b, "set -gx PATH %s $PATH;\n", fishQuote(binDir))
+	case shellPowerShell:
+		fmt.Fprintf(&b, "$env:PATH = %s + [IO.Path]::PathSeparator + $env:PATH\n", powerShellQuote(binDir))
+	default:
+		fmt.Fprintf(&b, "export PATH=%s:\"$PATH\"\n", shellQuote(binDir))
+	}
+
+	return b.String()
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func powerShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
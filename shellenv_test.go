@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildEnv(t *testing.T) {
+	defer func(distbuild, aosp string, cache bool) {
+		distbuildPath, aospPath, enableCache = distbuild, aosp, cache
+	}(distbuildPath, aospPath, enableCache)
+	distbuildPath, aospPath, enableCache = t.TempDir(), "/src/aosp", false
+
+	t.Setenv("REPO_HOST", "https://git.example.com")
+	t.Setenv("DISTBUILD_REPO", "distbuild")
+
+	proxy := filepath.Join(distbuildPath, "proxy")
+	assert.NoError(t, os.WriteFile(proxy, []byte("proxy"), 0755))
+	assert.NoError(t, recordComponent("proxy", "https://artifacts.invalid/proxy", proxy))
+
+	vars, err := buildEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, []buildEnvVar{
+		{"DISTBUILD_PATH", distbuildPath},
+		{"DISTBUILD_PROXY", proxy},
+		{"DISTBUILD_CHECKOUT", "/src/aosp/build/distbuild"},
+	}, vars)
+
+	enableCache = true
+	t.Setenv("CACHE_MODE", cacheModeRemote)
+	t.Setenv("REMOTE_CACHE_ENDPOINT", "https://cache.example.com")
+
+	vars, err = buildEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, buildEnvVar{"DISTBUILD_CACHE_ENDPOINT", "https://cache.example.com"}, vars[len(vars)-1])
+}
+
+func TestExportScript(t *testing.T) {
+	vars := []buildEnvVar{{"NINJA", "/opt/distbuild/bin/distninja"}, {"DISTBUILD_PATH", "/home/o'neil/distbuild"}}
+
+	assert.Equal(t, "export NINJA=/opt/distbuild/bin/distninja\n"+
+		"export DISTBUILD_PATH='/home/o'\\''neil/distbuild'\n"+
+		"export PATH=/opt/bin:\"$PATH\"\n", exportScript(shellPOSIX, vars, "/opt/bin"))
+
+	assert.Equal(t, "set -gx NINJA '/opt/distbuild/bin/distninja';\n"+
+		"set -gx DISTBUILD_PATH '/home/o\\'neil/distbuild';\n"+
+		"set -gx PATH '/opt/bin' $PATH;\n", exportScript(shellFish, vars, "/opt/bin"))
+
+	assert.Equal(t, "$env:NINJA = '/opt/distbuild/bin/distninja'\n"+
+		"$env:DISTBUILD_PATH = '/home/o''neil/distbuild'\n"+
+		"$env:PATH = '/opt/bin' + [IO.Path]::PathSeparator + $env:PATH\n", exportScript(shellPowerShell, vars, "/opt/bin"))
+
+	// The POSIX output survives eval
+	out, err := exec.Command("sh", "-c", exportScript(shellPOSIX, vars, "/opt/bin")+`printf '%s|%s' "$DISTBUILD_PATH" "${PATH%%:*}"`).Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "/home/o'neil/distbuild|/opt/bin", strings.TrimSpace(string(out)))
+}
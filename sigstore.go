@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+const (
+	sigstoreBundleSuffix      = ".sigstore.json"
+	sigstoreAttestationSuffix = ".intoto.sigstore.json"
+)
+
+// cosignBinary verifies the bundles, tests point it at a stand-in.
+var cosignBinary = "cosign"
+
+// sigstorePolicy lists per artifact how its cosign signature, and
+// optionally an in-toto attestation, must verify. Artifacts are named like
+// the installed binaries: agent, distninja, proxy.
+type sigstorePolicy struct {
+	Artifacts map[string]sigstoreArtifact `json:"artifacts"`
+}
+
+// sigstoreArtifact is verified with a public key, or keyless against the
+// certificate identity and OIDC issuer of the signer. Bundle locations are
+// relative to the artifact url. Signatures cover the installed binary, that
+// is after decompression.
+type sigstoreArtifact struct {
+	Key               string `json:"key,omitempty"`
+	Identity          string `json:"identity,omitempty"`
+	Issuer            string `json:"issuer,omitempty"`
+	Bundle            string `json:"bundle,omitempty"`
+	Attestation       string `json:"attestation,omitempty"`
+	AttestationBundle string `json:"attestation_bundle,omitempty"`
+}
+
+var (
+	sigstoreOnce   sync.Once
+	sigstoreLoaded *sigstorePolicy
+	sigstoreErr    error
+)
+
+// loadSigstorePolicy reads SIGSTORE_POLICY once per run, without it no
+// artifact is verified with sigstore.
+func loadSigstorePolicy() (*sigstorePolicy, error) {
+	sigstoreOnce.Do(func() {
+		sigstoreLoaded, sigstoreErr = readSigstorePolicy(os.Getenv("SIGSTORE_POLICY"))
+	})
+
+	return sigstoreLoaded, sigstoreErr
+}
+
+func readSigstorePolicy(path string) (*sigstorePolicy, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	path, err := expandPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand path: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read sigstore policy failed: %w", err)
+	}
+
+	var policy sigstorePolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, withCode(errCodeUsage, fmt.Errorf("parse sigstore policy failed: %w", err))
+	}
+
+	for name, artifact := range policy.Artifacts {
+		keyless := artifact.Identity != "" || artifact.Issuer != ""
+		switch {
+		case artifact.Key != "" && keyless:
+			return nil, withCode(errCodeUsage, fmt.Errorf("sigstore policy of %s sets both key and identity", name))
+		case artifact.Key == "" && (artifact.Identity == "" || artifact.Issuer == ""):
+			return nil, withCode(errCodeUsage, fmt.Errorf("sigstore policy of %s needs a key, or an identity and issuer", name))
+		}
+	}
+
+	return &policy, nil
+}
+
+// identityArgs selects key based or keyless verification.
+func (a sigstoreArtifact) identityArgs() ([]string, error) {
+	if a.Key == "" {
+		return []string{"--certificate-identity", a.Identity, "--certificate-oidc-issuer", a.Issuer}, nil
+	}
+
+	key, err := expandPath(a.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand path: %w", err)
+	}
+
+	return []string{"--key", key}, nil
+}
+
+// verifySigstore checks the binary of component name at filePath against
+// the cosign bundles published next to its artifact, when the policy lists
+// the component.
+func verifySigstore(name, artifactURL, filePath string) error {
+	policy, err := loadSigstorePolicy()
+	if err != nil || policy == nil {
+		return err
+	}
+
+	artifact, ok := policy.Artifacts[name]
+	if !ok {
+		return nil
+	}
+
+	// Embedded binaries were verified when they were packed
+	if isPayloadURL(artifactURL) {
+		logVerbose("%s is embedded, skipping sigstore verification", name)
+		return nil
+	}
+
+	identity, err := artifact.identityArgs()
+	if err != nil {
+		return err
+	}
+
+	bundle := artifact.Bundle
+	if bundle == "" {
+		bundle = artifactURL + sigstoreBundleSuffix
+	}
+
+	if err := runCosign(artifactURL, bundle, filePath, "verify-blob", identity); err != nil {
+		return withCode(errCodeVerification, fmt.Errorf("cosign signature of %s invalid: %w", name, err))
+	}
+
+	logVerbose("%s cosign signature verified", name)
+
+	if artifact.Attestation == "" {
+		return nil
+	}
+
+	bundle = artifact.AttestationBundle
+	if bundle == "" {
+		bundle = artifactURL + sigstoreAttestationSuffix
+	}
+
+	args := append([]string{"--type", artifact.Attestation}, identity...)
+	if err := runCosign(artifactURL, bundle, filePath, "verify-blob-attestation", args); err != nil {
+		return withCode(errCodeVerification, fmt.Errorf("%s attestation of %s invalid: %w", artifact.Attestation, name, err))
+	}
+
+	logVerbose("%s %s attestation verified", name, artifact.Attestation)
+
+	return nil
+}
+
+// runCosign fetches a bundle and runs a cosign verify subcommand on the
+// installed file with it.
+func runCosign(artifactURL, bundleRef, filePath, subcommand string, args []string) error {
+	bundleURL, err := resolveURL(artifactURL, bundleRef)
+	if err != nil {
+		return fmt.Errorf("resolve bundle url failed: %w", err)
+	}
+
+	data, err := fetchBytes(bundleURL)
+	if err != nil {
+		return fmt.Errorf("download bundle failed: %w", err)
+	}
+
+	bundle, err := os.CreateTemp("", "distbuild-*"+sigstoreBundleSuffix)
+	if err != nil {
+		return err
+	}
+
+	defer func(name string) {
+		_ = os.Remove(name)
+	}(bundle.Name())
+
+	if _, err := bundle.Write(data); err != nil {
+		_ = bundle.Close()
+		return err
+	}
+
+	if err := bundle.Close(); err != nil {
+		return err
+	}
+
+	cmdArgs := append([]string{subcommand, "--bundle", bundle.Name()}, args...)
+	cmd := exec.CommandContext(runCtx, cosignBinary, append(cmdArgs, filePath)...)
+	var output bytes.Buffer
+	cmd.Stdout = commandStderr(&output)
+	cmd.Stderr = cmd.Stdout
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v\n%s", err, strings.TrimSpace(output.String()))
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resetSigstorePolicy(t *testing.T, policy string) {
+	path := ""
+	if policy != "" {
+		path = filepath.Join(t.TempDir(), "sigstore.json")
+		require.NoError(t, os.WriteFile(path, []byte(policy), 0644))
+	}
+	t.Setenv("SIGSTORE_POLICY", path)
+
+	sigstoreOnce = sync.Once{}
+	t.Cleanup(func() {
+		sigstoreOnce = sync.Once{}
+	})
+}
+
+func TestReadSigstorePolicy(t *testing.T) {
+	for _, invalid := range []string{
+		`{"artifacts":{"agent":{}}}`,
+		`{"artifacts":{"agent":{"identity":"ci@example.com"}}}`,
+		`{"artifacts":{"agent":{"key":"cosign.pub","identity":"ci@example.com","issuer":"https://issuer"}}}`,
+	} {
+		resetSigstorePolicy(t, invalid)
+		_, err := loadSigstorePolicy()
+		assert.Error(t, err, invalid)
+		assert.Equal(t, errCodeUsage, errorCodeOf(err))
+	}
+
+	resetSigstorePolicy(t, "")
+	policy, err := loadSigstorePolicy()
+	assert.NoError(t, err)
+	assert.Nil(t, policy)
+}
+
+func TestVerifySigstore(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as cosign")
+	}
+
+	dir := t.TempDir()
+	argsPath := filepath.Join(dir, "args")
+
+	binary := cosignBinary
+	defer func() {
+		cosignBinary = binary
+	}()
+	cosignBinary = filepath.Join(dir, "cosign")
+	require.NoError(t, os.WriteFile(cosignBinary, []byte("#!/bin/sh\necho \"$@\" >> "+argsPath+"\ngrep -q valid \"$3\"\n"), 0755))
+
+	bundle := "valid"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/agent" + sigstoreBundleSuffix, "/agent" + sigstoreAttestationSuffix:
+			_, _ = w.Write([]byte(bundle))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	resetSigstorePolicy(t, `{"artifacts":{"agent":{"identity":"ci@example.com","issuer":"https://issuer","attestation":"slsaprovenance"}}}`)
+
+	agent := filepath.Join(dir, "agent")
+	require.NoError(t, os.WriteFile(agent, []byte("agent"), 0755))
+
+	require.NoError(t, verifySigstore("agent", server.URL+"/agent", agent))
+
+	data, err := os.ReadFile(argsPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], "verify-blob --bundle "))
+	assert.True(t, strings.HasSuffix(lines[0], "--certificate-identity ci@example.com --certificate-oidc-issuer https://issuer "+agent))
+	assert.Contains(t, lines[1], "verify-blob-attestation --bundle ")
+	assert.Contains(t, lines[1], "--type slsaprovenance --certificate-identity ci@example.com")
+
+	// Artifacts the policy does not list are not verified
+	proxy := filepath.Join(dir, "proxy")
+	require.NoError(t, os.WriteFile(proxy, []byte("proxy"), 0755))
+	assert.NoError(t, verifySigstore("proxy", server.URL+"/proxy", proxy))
+
+	bundle = "forged"
+	err = verifySigstore("agent", server.URL+"/agent", agent)
+	require.Error(t, err)
+	assert.Equal(t, errCodeVerification, errorCodeOf(err))
+}
+
+func TestVerifySigstoreStaged(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as cosign")
+	}
+	resetChanges()
+	defer resetChanges()
+	defer resetChecksums()
+
+	dir := t.TempDir()
+
+	binary := cosignBinary
+	defer func() {
+		cosignBinary = binary
+	}()
+	cosignBinary = filepath.Join(dir, "cosign")
+	require.NoError(t, os.WriteFile(cosignBinary, []byte("#!/bin/sh\ngrep -q valid \"$3\"\n"), 0755))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/agent" {
+			_, _ = w.Write([]byte("agent v2"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	resetSigstorePolicy(t, `{"artifacts":{"agent":{"identity":"ci@example.com","issuer":"https://issuer"}}}`)
+
+	// The download is staged under another name, the policy of the agent
+	// still applies and the missing bundle fails it
+	path := filepath.Join(dir, "agent")
+	require.NoError(t, os.WriteFile(path, []byte("agent v1"), 0755))
+
+	changed, err := installAgentBinary(server.URL+"/agent", path)
+	require.Error(t, err)
+	assert.False(t, changed)
+	assert.Contains(t, err.Error(), "cosign signature of agent invalid")
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "agent v1", string(data))
+	assert.NoFileExists(t, stagingPath(path))
+}
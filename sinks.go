@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+const syslogTag = "distbuild-bootstrap"
+
+// sendMail delivers an email, tests replace it.
+var sendMail = smtp.SendMail
+
+// syslogTarget splits SYSLOG_ADDR into the network and address to dial, an
+// empty value is the local syslog daemon and a bare address uses udp.
+func syslogTarget(value string) (string, string, error) {
+	if value == "" {
+		return "", "", nil
+	}
+
+	network, addr, ok := strings.Cut(value, "://")
+	if !ok {
+		return "udp", value, nil
+	}
+
+	switch network {
+	case "udp", "tcp", "unix", "unixgram":
+		return network, addr, nil
+	}
+
+	return "", "", fmt.Errorf("unsupported syslog network %q", network)
+}
+
+// notifySMTP mails the run summary to SMTP_TO through SMTP_ADDR, with
+// SMTP_USER and SMTP_PASS when the server wants authentication. STARTTLS is
+// used whenever the server offers it.
+func notifySMTP(n notification) error {
+	addr, from := os.Getenv("SMTP_ADDR"), os.Getenv("SMTP_FROM")
+
+	var to []string
+	for _, rcpt := range strings.Split(os.Getenv("SMTP_TO"), ",") {
+		if rcpt = strings.TrimSpace(rcpt); rcpt != "" {
+			to = append(to, rcpt)
+		}
+	}
+
+	if addr == "" || from == "" || len(to) == 0 {
+		return fmt.Errorf("SMTP_ADDR, SMTP_FROM and SMTP_TO are required")
+	}
+
+	var auth smtp.Auth
+	if user := os.Getenv("SMTP_USER"); user != "" {
+		host, _, _ := strings.Cut(addr, ":")
+		auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASS"), host)
+	}
+
+	return sendMail(addr, auth, from, to, notificationMail(n, from, to))
+}
+
+func notificationMail(n notification, from string, to []string) []byte {
+	var b strings.Builder
+
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	b.WriteString("Subject: [distbuild] " + notificationTitle(n) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	for _, f := range notificationFacts(n) {
+		b.WriteString(f[0] + ":")
+		for _, line := range strings.Split(f[1], "\n") {
+			b.WriteString("\r\n    " + line)
+		}
+		b.WriteString("\r\n")
+	}
+
+	return []byte(b.String())
+}
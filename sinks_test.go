@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/smtp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectedSinks(t *testing.T) {
+	defer func(url string) {
+		webhookURL = url
+	}(webhookURL)
+
+	webhookURL = ""
+	t.Setenv("WEBHOOK_URL", "")
+	t.Setenv("NOTIFY_SINKS", "")
+	assert.Empty(t, selectedSinks())
+
+	t.Setenv("WEBHOOK_URL", "https://ops.example.com/hook")
+	assert.Equal(t, []string{"webhook"}, selectedSinks())
+
+	t.Setenv("NOTIFY_SINKS", "syslog, smtp,")
+	assert.Equal(t, []string{"syslog", "smtp"}, selectedSinks())
+
+	t.Setenv("NOTIFY_SINKS", "pager")
+	output := captureStdout(t, func() {
+		sendNotification(notification{Operation: "bootstrap"})
+	})
+	assert.Contains(t, output, "warning: unknown notification sink pager")
+}
+
+func TestSyslogTarget(t *testing.T) {
+	network, addr, err := syslogTarget("")
+	assert.NoError(t, err)
+	assert.Equal(t, "", network+addr)
+
+	network, addr, err = syslogTarget("logs.example.com:514")
+	assert.NoError(t, err)
+	assert.Equal(t, "udp", network)
+	assert.Equal(t, "logs.example.com:514", addr)
+
+	network, _, err = syslogTarget("tcp://logs.example.com:601")
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp", network)
+
+	_, _, err = syslogTarget("http://logs.example.com")
+	assert.Error(t, err)
+}
+
+func TestNotifySyslog(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("syslog is not available on windows")
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func(conn net.PacketConn) {
+		_ = conn.Close()
+	}(conn)
+
+	t.Setenv("SYSLOG_ADDR", "udp://"+conn.LocalAddr().String())
+	assert.NoError(t, notifySyslog(notification{Operation: "bootstrap", Status: "error", Error: &resultError{Code: errCodeDisk}}))
+
+	buf := make([]byte, 4096)
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(t, err)
+
+	// daemon.err is priority 27
+	line := string(buf[:n])
+	assert.True(t, strings.HasPrefix(line, "<27>"), line)
+	assert.Contains(t, line, syslogTag)
+
+	var got notification
+	assert.NoError(t, json.Unmarshal([]byte(line[strings.Index(line, "{"):]), &got))
+	assert.Equal(t, errCodeDisk, got.Error.Code)
+}
+
+func TestNotifySMTP(t *testing.T) {
+	defer func(send func(string, smtp.Auth, string, []string, []byte) error) {
+		sendMail = send
+	}(sendMail)
+
+	var gotAddr string
+	var gotTo []string
+	var gotAuth smtp.Auth
+	var gotMsg []byte
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		gotAddr, gotAuth, gotTo, gotMsg = addr, a, to, msg
+		return nil
+	}
+
+	t.Setenv("SMTP_ADDR", "")
+	assert.ErrorContains(t, notifySMTP(notification{}), "SMTP_ADDR, SMTP_FROM and SMTP_TO are required")
+
+	t.Setenv("SMTP_ADDR", "mail.example.com:587")
+	t.Setenv("SMTP_FROM", "bootstrap@example.com")
+	t.Setenv("SMTP_TO", "ops@example.com, build@example.com")
+	t.Setenv("SMTP_USER", "")
+	n := notification{
+		Operation: "bootstrap",
+		Status:    "error",
+		Host:      "ci-01",
+		Versions:  map[string]string{"agent": "v1", "bootstrap": "1-abc"},
+		Error:     &resultError{Code: errCodeGit, Message: "git clone failed"},
+	}
+	assert.NoError(t, notifySMTP(n))
+	assert.Equal(t, "mail.example.com:587", gotAddr)
+	assert.Nil(t, gotAuth)
+	assert.Equal(t, []string{"ops@example.com", "build@example.com"}, gotTo)
+	assert.Contains(t, string(gotMsg), "Subject: [distbuild] bootstrap on ci-01: error\r\n")
+	assert.Contains(t, string(gotMsg), "versions:\r\n    agent v1\r\n    bootstrap 1-abc\r\n")
+	assert.Contains(t, string(gotMsg), "error:\r\n    [git] git clone failed\r\n")
+
+	t.Setenv("SMTP_USER", "bootstrap")
+	sendMail = func(string, smtp.Auth, string, []string, []byte) error {
+		return errors.New("535 authentication failed")
+	}
+	assert.ErrorContains(t, notifySMTP(n), "535")
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Slotted binaries live in versioned slots next to their link, bin/proxy
+// points at bin/proxy-<version>. An upgrade installs a new slot and flips
+// the link, the slot it replaced is kept for rollback.
+
+var rollbackCmd = &cobra.Command{
+	Use:       "rollback <component>",
+	Short:     "switch a component back to its previous slot",
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"proxy", "distninja"},
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		if distbuildPath, err = expandPath(distbuildPath); err != nil {
+			exitWithError(withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err)))
+		}
+		if err := rollbackComponent(args[0]); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	rollbackCmd.Flags().StringVar(&distbuildPath, "distbuild-path", "", "distbuild binaries path")
+	_ = rollbackCmd.MarkFlagRequired("distbuild-path")
+}
+
+const stagingSuffix = ".staging"
+
+func stagingPath(link string) string {
+	return filepath.Join(filepath.Dir(link), "."+filepath.Base(link)+stagingSuffix)
+}
+
+// stageSlot prepares the download of a slotted binary. A binary installed
+// before slots is moved into its slot first, then the active binary seeds
+// the staging file so that delta updates can patch it.
+func stageSlot(name, link string) (string, error) {
+	if err := adoptUnslotted(name, link); err != nil {
+		return "", err
+	}
+
+	staging := stagingPath(link)
+	_ = os.Remove(staging)
+
+	data, err := os.ReadFile(link)
+	if errors.Is(err, fs.ErrNotExist) {
+		return staging, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("read active %s failed: %w", name, err)
+	}
+
+	return staging, writeFileAtomic(staging, data, 0755)
+}
+
+func adoptUnslotted(name, link string) error {
+	info, err := os.Lstat(link)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+
+	slot, err := slotName(name, link)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(link, filepath.Join(filepath.Dir(link), slot)); err != nil {
+		return fmt.Errorf("move %s into its slot failed: %w", name, err)
+	}
+
+	if err := activateSlot(link, slot); err != nil {
+		return err
+	}
+
+	noteChange("install " + slot)
+
+	lock, err := lockManifest()
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	manifest, err := loadManifest()
+	if err != nil {
+		return err
+	}
+	if c := manifest.Components[name]; c != nil {
+		c.Slot = slot
+		return saveManifest(manifest)
+	}
+
+	return nil
+}
+
+// slotName names the slot of the binary at path after its version, or its
+// checksum when it has none. A different binary claiming the same version
+// gets the checksum appended.
+func slotName(name, path string) (string, error) {
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return "", err
+	}
+
+	version := sum[:12]
+	if fields := strings.Fields(binaryVersion(path)); len(fields) > 0 {
+		version = strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("._+-", r) {
+				return r
+			}
+			return '_'
+		}, fields[len(fields)-1])
+	}
+
+	slot := name + "-" + version
+	existing, err := fileSHA256(filepath.Join(filepath.Dir(path), slot))
+	if err == nil && existing != sum {
+		slot += "-" + sum[:8]
+	}
+
+	return slot, nil
+}
+
+// activateSlot points link at slot, by renaming a new link over the old one
+// so that the binary is never missing.
+func activateSlot(link, slot string) error {
+	tmp := link + ".link"
+	_ = os.Remove(tmp)
+
+	if err := os.Symlink(slot, tmp); err != nil {
+		return fmt.Errorf("link %s failed: %w", slot, err)
+	}
+
+	if err := os.Rename(tmp, link); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("activate %s failed: %w", slot, err)
+	}
+
+	return nil
+}
+
+// installSlot moves a downloaded binary into its slot and activates it. A
+// download that matches the active binary leaves everything as it is.
+func installSlot(name, staging, link string) (string, error) {
+	sum, err := fileSHA256(staging)
+	if err != nil {
+		return "", err
+	}
+
+	if active, err := fileSHA256(link); err == nil && active == sum {
+		if slot, err := os.Readlink(link); err == nil {
+			_ = os.Remove(staging)
+			return slot, nil
+		}
+	}
+
+	slot, err := slotName(name, staging)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(staging, filepath.Join(filepath.Dir(link), slot)); err != nil {
+		return "", fmt.Errorf("install %s slot failed: %w", name, err)
+	}
+
+	if err := activateSlot(link, slot); err != nil {
+		return "", err
+	}
+
+	noteChange("install " + slot)
+
+	return slot, nil
+}
+
+// recordSlot keeps the component that was active before as the rollback
+// target, unless the install did not change the slot.
+func recordSlot(name, slot string, before *manifestComponent) error {
+	lock, err := lockManifest()
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	manifest, err := loadManifest()
+	if err != nil {
+		return err
+	}
+
+	c := manifest.Components[name]
+	if c == nil {
+		return nil
+	}
+
+	c.Slot = slot
+	switch {
+	case before == nil || before.Slot == "":
+	case before.Slot == slot:
+		c.Previous = before.Previous
+	default:
+		before.Previous = nil
+		c.Previous = before
+	}
+
+	if err := saveManifest(manifest); err != nil {
+		return err
+	}
+
+	keep := []string{slot}
+	if c.Previous != nil {
+		keep = append(keep, c.Previous.Slot)
+	}
+
+	return pruneSlots(name, filepath.Dir(c.Path), keep)
+}
+
+// pruneSlots removes the slots of name other than keep.
+func pruneSlots(name, binDir string, keep []string) error {
+	entries, err := os.ReadDir(binDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		slot := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(slot, name+"-") || slices.Contains(keep, slot) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(binDir, slot)); err != nil {
+			return fmt.Errorf("remove slot %s failed: %w", slot, err)
+		}
+		logVerbose("removed slot %s", slot)
+	}
+
+	return nil
+}
+
+// rollbackComponent activates the previous slot of a component. The slot
+// it leaves becomes the rollback target, so a second rollback undoes it.
+func rollbackComponent(name string) error {
+	manifest, err := loadManifest()
+	if err != nil {
+		return err
+	}
+
+	current := manifest.Components[name]
+	if current == nil {
+		return withCode(errCodeUsage, fmt.Errorf("%s is not installed in %s", name, distbuildPath))
+	}
+
+	previous := current.Previous
+	if previous == nil || previous.Slot == "" {
+		return withCode(errCodeUsage, fmt.Errorf("%s has no previous slot to roll back to", name))
+	}
+
+	slotPath := filepath.Join(filepath.Dir(current.Path), previous.Slot)
+	sum, err := fileSHA256(slotPath)
+	if err != nil {
+		return withCode(errCodeDisk, fmt.Errorf("previous %s slot is missing: %w", name, err))
+	}
+	if sum != previous.SHA256 {
+		return withCode(errCodeVerification, fmt.Errorf("previous %s slot %s was modified: sha256 %s, recorded %s", name, previous.Slot, sum, previous.SHA256))
+	}
+
+	if err := activateSlot(current.Path, previous.Slot); err != nil {
+		return err
+	}
+
+	current.Previous = nil
+	previous.Previous = current
+	manifest.Components[name] = previous
+
+	if err := saveManifest(manifest); err != nil {
+		return err
+	}
+
+	printMsg("rollback.done", "name", name, "slot", previous.Slot, "from", current.Slot)
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func installTestSlot(t *testing.T, url, link, content string) string {
+	staging, err := stageSlot("proxy", link)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(staging, []byte(content), 0755))
+
+	manifest, err := loadManifest()
+	assert.NoError(t, err)
+
+	slot, err := installSlot("proxy", staging, link)
+	assert.NoError(t, err)
+	assert.NoError(t, recordComponent("proxy", url, link))
+	assert.NoError(t, recordSlot("proxy", slot, manifest.Components["proxy"]))
+
+	return slot
+}
+
+func TestSlots(t *testing.T) {
+	defer func(path string) {
+		distbuildPath = path
+	}(distbuildPath)
+	distbuildPath = t.TempDir()
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	binDir := filepath.Join(distbuildPath, "boong", "bin")
+	link := filepath.Join(binDir, "proxy")
+	assert.NoError(t, os.MkdirAll(binDir, 0755))
+
+	// A binary installed before slots is adopted into one
+	assert.NoError(t, os.WriteFile(link, []byte("v1"), 0755))
+	assert.NoError(t, recordComponent("proxy", server.URL+"/proxy", link))
+
+	v2 := installTestSlot(t, server.URL+"/proxy", link, "v2")
+	target, err := os.Readlink(link)
+	assert.NoError(t, err)
+	assert.Equal(t, v2, target)
+
+	manifest, err := loadManifest()
+	assert.NoError(t, err)
+	c := manifest.Components["proxy"]
+	assert.Equal(t, v2, c.Slot)
+	assert.NotNil(t, c.Previous)
+	v1 := c.Previous.Slot
+	assert.Equal(t, sha256Hex([]byte("v1")), c.Previous.SHA256)
+
+	// Reinstalling the same binary keeps the rollback target
+	assert.Equal(t, v2, installTestSlot(t, server.URL+"/proxy", link, "v2"))
+	manifest, err = loadManifest()
+	assert.NoError(t, err)
+	assert.Equal(t, v1, manifest.Components["proxy"].Previous.Slot)
+	assert.NoFileExists(t, stagingPath(link))
+
+	// Only the active and previous slots are kept
+	v3 := installTestSlot(t, server.URL+"/proxy", link, "v3")
+	assert.NoFileExists(t, filepath.Join(binDir, v1))
+	assert.FileExists(t, filepath.Join(binDir, v2))
+
+	assert.NoError(t, rollbackComponent("proxy"))
+	data, err := os.ReadFile(link)
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", string(data))
+
+	manifest, err = loadManifest()
+	assert.NoError(t, err)
+	assert.Equal(t, v2, manifest.Components["proxy"].Slot)
+	assert.Equal(t, v3, manifest.Components["proxy"].Previous.Slot)
+
+	// Rolling back again undoes the rollback
+	assert.NoError(t, rollbackComponent("proxy"))
+	data, err = os.ReadFile(link)
+	assert.NoError(t, err)
+	assert.Equal(t, "v3", string(data))
+
+	// A modified slot is refused
+	assert.NoError(t, os.WriteFile(filepath.Join(binDir, v2), []byte("tampered"), 0755))
+	assert.Equal(t, errCodeVerification, errorCodeOf(rollbackComponent("proxy")))
+}
+
+func TestRollbackWithoutPrevious(t *testing.T) {
+	defer func(path string) {
+		distbuildPath = path
+	}(distbuildPath)
+	distbuildPath = t.TempDir()
+
+	assert.Equal(t, errCodeUsage, errorCodeOf(rollbackComponent("distninja")))
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	snapshotVersion = 1
+	snapshotDir     = "snapshots"
+)
+
+var snapshotOutput string
+
+// snapshot captures a working setup: the distbuild checkout commit, the
+// installed binaries and toolchains and the layered env file settings.
+type snapshot struct {
+	Version       int                           `json:"version"`
+	Name          string                        `json:"name"`
+	CreatedAt     time.Time                     `json:"created_at"`
+	DistbuildPath string                        `json:"distbuild_path"`
+	Checkout      string                        `json:"checkout,omitempty"`
+	Commit        string                        `json:"commit,omitempty"`
+	Components    map[string]*manifestComponent `json:"components"`
+	Toolchains    []snapshotToolchain           `json:"toolchains,omitempty"`
+	Config        map[string]string             `json:"config"`
+}
+
+// snapshotToolchain is an installed toolchain version and the link that
+// pointed at it.
+type snapshotToolchain struct {
+	Name     string `json:"name"`
+	Revision string `json:"revision"`
+	Path     string `json:"path"`
+	Link     string `json:"link,omitempty"`
+}
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "capture and restore a working setup",
+}
+
+var snapshotCreateCmd = &cobra.Command{
+	Use:   "create [name]",
+	Short: "capture the checkout, binaries, toolchains and config",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		if distbuildPath, err = expandPath(distbuildPath); err != nil {
+			exitWithError(withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err)))
+		}
+		if aospPath, err = expandPath(aospPath); err != nil {
+			exitWithError(withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err)))
+		}
+		name := time.Now().Format("20060102-150405")
+		if len(args) > 0 {
+			name = args[0]
+		}
+		if err := createSnapshot(name); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+var snapshotRestoreCmd = &cobra.Command{
+	Use:   "restore name|file",
+	Short: "switch back to a captured setup",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		if distbuildPath, err = expandPath(distbuildPath); err != nil {
+			exitWithError(withCode(errCodeUsage, fmt.Errorf("failed to expand path: %w", err)))
+		}
+		ctx, cancel := runContext()
+		defer cancel()
+		runCtx = ctx
+		if err := restoreSnapshot(args[0]); err != nil {
+			exitWithError(timeoutError(ctx, err))
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	snapshotCreateCmd.Flags().StringVar(&distbuildPath, "distbuild-path", "", "distbuild binaries path")
+	snapshotCreateCmd.Flags().StringVar(&aospPath, "aosp-path", "", "aosp workspace whose distbuild checkout is captured")
+	snapshotCreateCmd.Flags().StringVar(&snapshotOutput, "output-file", "", "write the snapshot to this file (default: the state dir)")
+	_ = snapshotCreateCmd.MarkFlagRequired("distbuild-path")
+
+	snapshotRestoreCmd.Flags().StringVar(&distbuildPath, "distbuild-path", "", "distbuild binaries path")
+	addTimeoutFlags(snapshotRestoreCmd)
+	_ = snapshotRestoreCmd.MarkFlagRequired("distbuild-path")
+
+	snapshotCmd.AddCommand(snapshotCreateCmd)
+	snapshotCmd.AddCommand(snapshotRestoreCmd)
+}
+
+// isSecretKey tells the settings a snapshot must not store, secret
+// references are kept since they hold no credential.
+func isSecretKey(key string) bool {
+	for _, suffix := range []string{"_PASS", "_PASSWORD", "_TOKEN", "_SECRET"} {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shareableConfig is the layered configuration without its secrets.
+func shareableConfig(entries map[string]envEntry) map[string]string {
+	config := map[string]string{}
+	for key, entry := range entries {
+		if !isSecretKey(key) || isSecretRef(entry.Value) {
+			config[key] = entry.Value
+		}
+	}
+
+	return config
+}
+
+func createSnapshot(name string) error {
+	manifest, err := loadManifest()
+	if err != nil {
+		return err
+	}
+
+	entries, err := layerEnvFiles(envFile, envFiles)
+	if err != nil {
+		return fmt.Errorf("load .env failed: %w", err)
+	}
+
+	snap := &snapshot{
+		Version:       snapshotVersion,
+		Name:          name,
+		CreatedAt:     time.Now().UTC(),
+		DistbuildPath: distbuildPath,
+		Components:    map[string]*manifestComponent{},
+		Config:        shareableConfig(entries),
+	}
+
+	for name, c := range manifest.Components {
+		// The documents can be fetched again, the snapshot only pins digests
+		captured := *c
+		captured.SBOMFormat, captured.SBOM, captured.Provenance, captured.Previous = "", nil, nil, nil
+		snap.Components[name] = &captured
+	}
+
+	links := toolchainLinks(distbuildPath)
+	for key, tc := range manifest.Toolchains {
+		snap.Toolchains = append(snap.Toolchains, snapshotToolchain{Name: key, Revision: tc.Revision, Path: tc.Path, Link: links[tc.Path]})
+	}
+	sort.Slice(snap.Toolchains, func(i, j int) bool {
+		return snap.Toolchains[i].Name < snap.Toolchains[j].Name
+	})
+
+	if aospPath != "" {
+		if err := loadEnvFiles(envFile, envFiles); err != nil {
+			return fmt.Errorf("load .env failed: %w", err)
+		}
+		_, _, subPath, err := distbuildRepoSource()
+		if err != nil {
+			return err
+		}
+		snap.Checkout = filepath.Join(aospPath, subPath)
+		if snap.Commit, err = resolveRevision(snap.Checkout); err != nil {
+			return withCode(errCodeGit, fmt.Errorf("resolve %s revision failed: %w", snap.Checkout, err))
+		}
+	}
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := snapshotOutput
+	if path == "" {
+		path = stateFile(distbuildPath, filepath.Join(snapshotDir, name+".json"))
+	}
+	if err := createDirs(filepath.Dir(path)); err != nil {
+		return err
+	}
+	// Config settings may be sensitive even without credentials
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("write snapshot failed: %w", err)
+	}
+
+	printMsg("snapshot.created", "name", name, "path", path)
+
+	return nil
+}
+
+// toolchainLinks maps the toolchain versions linked from the distbuild
+// path to their links.
+func toolchainLinks(root string) map[string]string {
+	links := map[string]string{}
+	store := filepath.Join(root, "toolchains")
+
+	_ = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && (p == store || d.Name() == ".git") {
+			return filepath.SkipDir
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		if target, err := os.Readlink(p); err == nil && strings.HasPrefix(target, store+string(filepath.Separator)) {
+			links[target] = p
+		}
+		return nil
+	})
+
+	return links
+}
+
+func loadSnapshot(nameOrPath string) (*snapshot, error) {
+	path := nameOrPath
+	if _, err := os.Stat(path); err != nil {
+		path = stateFile(distbuildPath, filepath.Join(snapshotDir, nameOrPath+".json"))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, withCode(errCodeUsage, fmt.Errorf("read snapshot %s failed: %w", nameOrPath, err))
+	}
+
+	snap := &snapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, fmt.Errorf("parse snapshot %s failed: %w", nameOrPath, err)
+	}
+	if snap.Version != snapshotVersion {
+		return nil, fmt.Errorf("snapshot %s has unsupported version %d", nameOrPath, snap.Version)
+	}
+	if snap.DistbuildPath != distbuildPath {
+		return nil, withCode(errCodeUsage, fmt.Errorf("snapshot %s was taken of %s", nameOrPath, snap.DistbuildPath))
+	}
+
+	return snap, nil
+}
+
+// restoreSnapshot puts the captured binaries, toolchains and checkout back.
+// Binaries still present in a slot are switched to, others are downloaded
+// and must match their captured digest.
+func restoreSnapshot(nameOrPath string) error {
+	snap, err := loadSnapshot(nameOrPath)
+	if err != nil {
+		return err
+	}
+
+	if err := loadEnvFiles(envFile, envFiles); err != nil {
+		return fmt.Errorf("load .env failed: %w", err)
+	}
+
+	if err := setupHTTPClient(); err != nil {
+		return fmt.Errorf("setup http client failed: %w", err)
+	}
+
+	if err := resolveSecrets(); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(snap.Components))
+	for name := range snap.Components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := restoreComponent(snap.Components[name]); err != nil {
+			return fmt.Errorf("restore %s failed: %w", name, err)
+		}
+	}
+
+	for _, tc := range snap.Toolchains {
+		if err := restoreToolchain(tc); err != nil {
+			return fmt.Errorf("restore %s failed: %w", tc.Name, err)
+		}
+	}
+
+	if snap.Commit != "" {
+		if err := restoreCheckout(snap); err != nil {
+			return err
+		}
+	}
+
+	envPath := stateFile(distbuildPath, filepath.Join(snapshotDir, snap.Name+".env"))
+	if err := writeFileAtomic(envPath, []byte(snapshotEnv(snap.Config)), 0600); err != nil {
+		return fmt.Errorf("write snapshot config failed: %w", err)
+	}
+
+	printMsg("snapshot.restored", "name", snap.Name, "env", envPath)
+
+	return nil
+}
+
+func snapshotEnv(config map[string]string) string {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s = %s\n", key, config[key])
+	}
+
+	return b.String()
+}
+
+func restoreComponent(c *manifestComponent) error {
+	manifest, err := loadManifest()
+	if err != nil {
+		return err
+	}
+
+	current := manifest.Components[c.Name]
+	if current != nil && current.SHA256 == c.SHA256 {
+		return nil
+	}
+
+	if c.Slot != "" {
+		slotPath := filepath.Join(filepath.Dir(c.Path), c.Slot)
+		if sum, err := fileSHA256(slotPath); err == nil && sum == c.SHA256 {
+			if err := activateSlot(c.Path, c.Slot); err != nil {
+				return err
+			}
+			restored := *c
+			if current != nil {
+				current.Previous = nil
+				restored.Previous = current
+			}
+			manifest.Components[c.Name] = &restored
+			return saveManifest(manifest)
+		}
+	}
+
+	staging := upgradeBinaryPath()
+	if c.Slot != "" {
+		if staging, err = stageSlot(c.Name, c.Path); err != nil {
+			return err
+		}
+	}
+
+	if err := downloadBinary(c.Name, c.URL, staging); err != nil {
+		_ = os.Remove(staging)
+		return err
+	}
+
+	if sum, err := fileSHA256(staging); err != nil || sum != c.SHA256 {
+		_ = os.Remove(staging)
+		return withCode(errCodeVerification, fmt.Errorf("%s no longer serves the captured %s (sha256 %s)", redactURL(c.URL), c.Name, c.SHA256))
+	}
+
+	if c.Slot == "" {
+		if err := os.Rename(staging, c.Path); err != nil {
+			return err
+		}
+		return recordComponent(c.Name, c.URL, c.Path)
+	}
+
+	slot, err := installSlot(c.Name, staging, c.Path)
+	if err != nil {
+		return err
+	}
+	if err := recordComponent(c.Name, c.URL, c.Path); err != nil {
+		return err
+	}
+
+	return recordSlot(c.Name, slot, current)
+}
+
+// restoreToolchain links a toolchain version that is still in the store,
+// versions pruned since the snapshot have to be installed again.
+func restoreToolchain(tc snapshotToolchain) error {
+	manifest, err := loadManifest()
+	if err != nil {
+		return err
+	}
+
+	current := manifest.Toolchains[tc.Name]
+	if current != nil && current.Revision == tc.Revision {
+		return nil
+	}
+
+	if _, err := os.Stat(tc.Path); err != nil || tc.Link == "" {
+		warn("snapshot.toolchain_missing", "name", tc.Name, "revision", shortHash(tc.Revision))
+		return nil
+	}
+
+	if err := activateToolchain(tc.Path, tc.Link); err != nil {
+		return err
+	}
+
+	repo := ""
+	if current != nil {
+		repo = current.Repo
+	}
+	manifest.Toolchains[tc.Name] = &manifestToolchain{Name: tc.Name, Repo: repo, Path: tc.Path, Revision: tc.Revision, InstalledAt: time.Now().UTC()}
+
+	return saveManifest(manifest)
+}
+
+// restoreCheckout checks the captured commit out. Local changes are
+// stashed rather than thrown away, they may be the experiment worth keeping.
+func restoreCheckout(snap *snapshot) error {
+	checkout := snap.Checkout
+
+	if revision, err := resolveRevision(checkout); err == nil && revision == snap.Commit && len(localChanges(checkout)) == 0 {
+		return nil
+	}
+
+	if len(localChanges(checkout)) > 0 {
+		if err := runGit("stash", "-C", checkout, "stash", "push", "-q", "--include-untracked", "-m", "bootstrap snapshot restore "+snap.Name); err != nil {
+			return err
+		}
+		printMsg("snapshot.stashed", "path", checkout)
+	}
+
+	if _, err := gitOutput("-C", checkout, "cat-file", "-e", snap.Commit+"^{commit}"); err != nil {
+		if err := runGit("fetch", "-C", checkout, "fetch", "-q", "origin", snap.Commit); err != nil {
+			return err
+		}
+	}
+
+	return runGit("checkout", "-C", checkout, "checkout", "-q", "--detach", snap.Commit)
+}
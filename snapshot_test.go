@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	defer func(distbuild, aosp string) {
+		distbuildPath, aospPath = distbuild, aosp
+	}(distbuildPath, aospPath)
+	distbuildPath, aospPath = t.TempDir(), t.TempDir()
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	t.Setenv("REPO_HOST", "https://git.example.com")
+	t.Setenv("DISTBUILD_REPO", "distbuild")
+	t.Setenv("AUTH_PASS", "hunter2")
+
+	checkout := filepath.Join(aospPath, "build", "distbuild")
+	assert.NoError(t, os.MkdirAll(checkout, 0755))
+	git := func(args ...string) string {
+		out, err := exec.Command("git", append([]string{"-C", checkout, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...).Output()
+		assert.NoError(t, err)
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q", "-b", "master")
+	git("commit", "-q", "--allow-empty", "-m", "working")
+	working := git("rev-parse", "HEAD")
+
+	binDir := filepath.Join(distbuildPath, "boong", "bin")
+	link := filepath.Join(binDir, "proxy")
+	assert.NoError(t, os.MkdirAll(binDir, 0755))
+	installTestSlot(t, server.URL+"/proxy", link, "v1")
+
+	output := captureStdout(t, func() {
+		assert.NoError(t, createSnapshot("good"))
+	})
+	assert.Contains(t, output, "snapshot good written to")
+
+	data, err := os.ReadFile(stateFile(distbuildPath, filepath.Join(snapshotDir, "good.json")))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+
+	// The experiment: a new proxy, a new commit and uncommitted changes
+	installTestSlot(t, server.URL+"/proxy", link, "v2")
+	git("commit", "-q", "--allow-empty", "-m", "experiment")
+	assert.NoError(t, os.WriteFile(filepath.Join(checkout, "BUILD"), []byte("patch"), 0644))
+
+	output = captureStdout(t, func() {
+		assert.NoError(t, restoreSnapshot("good"))
+	})
+	assert.Contains(t, output, "stashed")
+
+	data, err = os.ReadFile(link)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(data))
+	assert.Equal(t, working, git("rev-parse", "HEAD"))
+	assert.NoFileExists(t, filepath.Join(checkout, "BUILD"))
+	assert.Equal(t, "1", git("rev-list", "--walk-reflogs", "--count", "refs/stash"))
+
+	manifest, err := loadManifest()
+	assert.NoError(t, err)
+	assert.Equal(t, sha256Hex([]byte("v1")), manifest.Components["proxy"].SHA256)
+	assert.Equal(t, sha256Hex([]byte("v2")), manifest.Components["proxy"].Previous.SHA256)
+
+	env, err := os.ReadFile(stateFile(distbuildPath, filepath.Join(snapshotDir, "good.env")))
+	assert.NoError(t, err)
+	assert.Contains(t, string(env), "CHECKSUMS_URL = \n")
+	assert.NotContains(t, string(env), "AUTH_PASS")
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/spf13/cobra"
+)
+
+const systemStateDir = "/var/lib/distbuild/bootstrap"
+
+var stateDirFlag string
+
+func addStateDirFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&stateDirFlag, "state-dir", "",
+		"host-level state directory for manifests, pidfiles and logs (default "+systemStateDir+" as root, else the XDG state home)")
+}
+
+// stateDir is where bootstrap keeps its own state, independent of the
+// distbuild paths it installs into.
+func stateDir() (string, error) {
+	if stateDirFlag != "" {
+		return expandPath(stateDirFlag)
+	}
+
+	if runtime.GOOS == "windows" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "distbuild", "bootstrap", "state"), nil
+	}
+
+	// Root without sudo is a system install, sudo runs act for the caller
+	if invoker, _ := sudoUser(); isRoot() && invoker == nil {
+		return systemStateDir, nil
+	}
+
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "distbuild", "bootstrap"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".local", "state", "distbuild", "bootstrap"), nil
+}
+
+// installStateDir holds the state of the install at root. Installs are
+// keyed by a hash of their path, so one host can carry several.
+func installStateDir(root string) string {
+	base, err := stateDir()
+	if err != nil {
+		// Without a usable home, keep the state next to the install
+		return filepath.Join(root, "boong")
+	}
+
+	sum := sha256.Sum256([]byte(filepath.Clean(root)))
+
+	return filepath.Join(base, "installs", hex.EncodeToString(sum[:])[:16])
+}
+
+// stateFile is where a state file of the install at root is written.
+func stateFile(root, name string) string {
+	return filepath.Join(installStateDir(root), name)
+}
+
+// existingStateFile is where a state file of the install at root is read
+// from. Releases before the state dir kept it in the distbuild path, it is
+// read from there until the next write moves it.
+func existingStateFile(root, name string) string {
+	path := stateFile(root, name)
+
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		legacy := legacyStateFile(root, name)
+		if _, err := os.Stat(legacy); err == nil {
+			return legacy
+		}
+	}
+
+	return path
+}
+
+func legacyStateFile(root, name string) string {
+	return filepath.Join(root, "boong", name)
+}
+
+// writeStateFile replaces a state file of the install at root and drops
+// its legacy copy.
+func writeStateFile(root, name string, data []byte) error {
+	path := stateFile(root, name)
+
+	if err := createDirs(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return err
+	}
+
+	if legacy := legacyStateFile(root, name); legacy != path {
+		_ = os.Remove(legacy)
+	}
+
+	// Tells which install an opaque state directory belongs to
+	marker := filepath.Join(filepath.Dir(path), "distbuild-path")
+	if current, err := os.ReadFile(marker); err != nil || string(current) != root+"\n" {
+		_ = os.WriteFile(marker, []byte(root+"\n"), policyFileMode(0644))
+	}
+
+	return nil
+}
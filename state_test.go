@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMain keeps the state of every test install out of the host state dir.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "bootstrap-state-*")
+	if err != nil {
+		panic(err)
+	}
+	stateDirFlag = dir
+	messageLanguage = defaultLanguage
+
+	code := m.Run()
+
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestStateDirDefaults(t *testing.T) {
+	defer func(dir string) {
+		stateDirFlag = dir
+	}(stateDirFlag)
+	stateDirFlag = ""
+
+	t.Setenv("SUDO_USER", "")
+	t.Setenv("XDG_STATE_HOME", "/xdg/state")
+	t.Setenv("HOME", "/home/builder")
+
+	dir, err := stateDir()
+	assert.NoError(t, err)
+	if isRoot() {
+		assert.Equal(t, systemStateDir, dir)
+	} else {
+		assert.Equal(t, filepath.Join("/xdg/state", "distbuild", "bootstrap"), dir)
+	}
+
+	stateDirFlag = "~/state"
+	dir, err = stateDir()
+	assert.NoError(t, err)
+	assert.True(t, filepath.IsAbs(dir))
+}
+
+func TestStateFileMigratesLegacy(t *testing.T) {
+	root := t.TempDir()
+
+	legacy := filepath.Join(root, "boong", manifestFileName)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(legacy), 0755))
+	assert.NoError(t, os.WriteFile(legacy, []byte("{}"), 0644))
+
+	assert.Equal(t, legacy, existingStateFile(root, manifestFileName))
+
+	assert.NoError(t, writeStateFile(root, manifestFileName, []byte(`{"version":1}`)))
+	assert.NoFileExists(t, legacy)
+
+	path := existingStateFile(root, manifestFileName)
+	assert.Equal(t, stateFile(root, manifestFileName), path)
+	assert.NotEqual(t, installStateDir(root), installStateDir(t.TempDir()))
+
+	marker, err := os.ReadFile(filepath.Join(filepath.Dir(path), "distbuild-path"))
+	assert.NoError(t, err)
+	assert.Equal(t, root+"\n", string(marker))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	stepOK     = "✓"
+	stepFailed = "✗"
+)
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// step is one phase of the run. It is shown as a single line that spins
+// while the phase runs and resolves to a check or cross mark with the
+// elapsed time once it ends.
+type step struct {
+	name    string
+	started time.Time
+	out     io.Writer
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	status string
+}
+
+// beginStep starts a phase. The spinner is only drawn on an interactive
+// run, with --no-progress or --verbose the name is printed once so raw log
+// output can follow it.
+func beginStep(name string) *step {
+	s := &step{name: name, started: time.Now(), out: os.Stdout}
+
+	if noProgress || verbose {
+		_, _ = fmt.Fprintf(s.out, "%s...\n", name)
+		return s
+	}
+
+	s.done = make(chan struct{})
+	s.wg.Add(1)
+	go s.spin()
+
+	return s
+}
+
+// beginQuietStep starts a phase that draws its own progress, like the
+// aggregate download bar, and only needs the resolved line.
+func beginQuietStep(name string) *step {
+	return &step{name: name, started: time.Now(), out: os.Stdout}
+}
+
+func (s *step) spin() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for frame := 0; ; frame++ {
+		line := s.name
+		if status := s.currentStatus(); status != "" {
+			line += " " + status
+		}
+		_, _ = fmt.Fprintf(s.out, "\r\033[K%s %s %s", spinnerFrames[frame%len(spinnerFrames)], line, formatElapsed(time.Since(s.started)))
+		select {
+		case <-s.done:
+			_, _ = fmt.Fprint(s.out, "\r\033[K")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// setStatus shows status after the step name while the spinner runs.
+func (s *step) setStatus(status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status = status
+}
+
+func (s *step) currentStatus() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.status
+}
+
+// end resolves the step line from the outcome of the phase and returns err
+// unchanged, so callers can `return s.end(err)`.
+func (s *step) end(err error) error {
+	if s.done != nil {
+		close(s.done)
+		s.wg.Wait()
+		s.done = nil
+	}
+
+	mark := stepOK
+	if err != nil {
+		mark = stepFailed
+	}
+
+	_, _ = fmt.Fprintf(s.out, "%s %s (%s)\n", mark, s.name, formatElapsed(time.Since(s.started)))
+	timings.record(timingPhase, s.name, s.started, err)
+
+	return err
+}
+
+// runStep runs fn as a step.
+func runStep(name string, fn func() error) error {
+	return beginStep(name).end(fn())
+}
+
+func formatElapsed(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	}
+
+	return d.Round(time.Second).String()
+}
+
+// commandStderr collects the stderr of a subprocess for error messages and
+// also streams it in verbose mode.
+func commandStderr(buf *bytes.Buffer) io.Writer {
+	if verbose {
+		return io.MultiWriter(buf, os.Stderr)
+	}
+
+	return buf
+}
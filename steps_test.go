@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	assert.NoError(t, err)
+
+	defer func(stdout *os.File) {
+		os.Stdout = stdout
+	}(os.Stdout)
+	os.Stdout = f
+
+	fn()
+
+	_ = f.Close()
+	content, err := os.ReadFile(f.Name())
+	assert.NoError(t, err)
+
+	return string(content)
+}
+
+func TestStepResolvesToMark(t *testing.T) {
+	defer func(quiet bool) {
+		noProgress = quiet
+	}(noProgress)
+	noProgress = true
+
+	output := captureStdout(t, func() {
+		assert.NoError(t, runStep("clone clang", func() error { return nil }))
+	})
+	assert.Regexp(t, `^clone clang\.\.\.\n✓ clone clang \(\d+\.\ds\)\n$`, output)
+
+	failure := errors.New("boom")
+	output = captureStdout(t, func() {
+		assert.Equal(t, failure, runStep("repo sync", func() error { return failure }))
+	})
+	assert.Regexp(t, `✗ repo sync \(\d+\.\ds\)\n$`, output)
+}
+
+func TestStepSpinnerClearsLine(t *testing.T) {
+	defer func(quiet, v bool) {
+		noProgress = quiet
+		verbose = v
+	}(noProgress, verbose)
+	noProgress = false
+	verbose = false
+
+	output := captureStdout(t, func() {
+		s := beginStep("download agent")
+		time.Sleep(150 * time.Millisecond)
+		_ = s.end(nil)
+	})
+	assert.Contains(t, output, "download agent")
+	assert.Regexp(t, "\r\033\\[K✓ download agent \\(\\d+\\.\\ds\\)\n$", output)
+}
+
+func TestFormatElapsed(t *testing.T) {
+	assert.Equal(t, "1.2s", formatElapsed(1234*time.Millisecond))
+	assert.Equal(t, "2m5s", formatElapsed(125*time.Second))
+}
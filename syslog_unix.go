@@ -0,0 +1,40 @@
+//go:build !windows
+
+package main
+
+import (
+	"encoding/json"
+	"log/syslog"
+	"os"
+)
+
+// notifySyslog logs the run summary as JSON to SYSLOG_ADDR, failures at
+// error priority.
+func notifySyslog(n notification) error {
+	network, addr, err := syslogTarget(os.Getenv("SYSLOG_ADDR"))
+	if err != nil {
+		return err
+	}
+
+	priority := syslog.LOG_DAEMON | syslog.LOG_INFO
+	if n.Error != nil {
+		priority = syslog.LOG_DAEMON | syslog.LOG_ERR
+	}
+
+	w, err := syslog.Dial(network, addr, priority, syslogTag)
+	if err != nil {
+		return err
+	}
+	defer func(w *syslog.Writer) {
+		_ = w.Close()
+	}(w)
+
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+)
+
+func notifySyslog(_ notification) error {
+	return errors.New("syslog is not available on windows, use the webhook or smtp sink")
+}
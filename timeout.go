@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var runTimeout time.Duration
+
+// runCtx carries the deadline of the run to the downloads and subprocesses
+// that have no context parameter of their own.
+var runCtx = context.Background()
+
+func addTimeoutFlags(cmd *cobra.Command) {
+	cmd.Flags().DurationVar(&runTimeout, "timeout", 0, "abort the whole run after this long, e.g. 30m (0 disables)")
+	cmd.Flags().DurationVar(&gitTimeout, "git-timeout", 30*time.Minute, "abort a single git clone, fetch or other git command after this long (0 disables)")
+}
+
+func checkTimeout() error {
+	if runTimeout < 0 {
+		return fmt.Errorf("--timeout must not be negative")
+	}
+
+	if gitTimeout < 0 {
+		return fmt.Errorf("--git-timeout must not be negative")
+	}
+
+	return nil
+}
+
+// runContext returns the context bounding the whole run.
+func runContext() (context.Context, context.CancelFunc) {
+	if runTimeout == 0 {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithTimeout(context.Background(), runTimeout)
+}
+
+// timeoutError tags the failure of a run that ran out of time. Killed
+// subprocesses report their signal rather than the deadline, so the context
+// decides, not err.
+func timeoutError(ctx context.Context, err error) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+
+	return withCode(errCodeTimeout, fmt.Errorf("run exceeded --timeout %s: %w", runTimeout, err))
+}
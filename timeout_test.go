@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeoutError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+
+	err := timeoutError(ctx, errors.New("signal: killed"))
+	assert.Equal(t, errCodeTimeout, errorCodeOf(err))
+	assert.Equal(t, 9, exitCodeOf(err))
+
+	assert.Nil(t, timeoutError(ctx, nil))
+
+	live, stop := context.WithCancel(context.Background())
+	defer stop()
+	assert.Equal(t, errCodeGit, errorCodeOf(timeoutError(live, withCode(errCodeGit, errors.New("exit 128")))))
+}
+
+func TestDownloadAbortsOnDeadline(t *testing.T) {
+	defer func(ctx context.Context, quiet bool) {
+		runCtx = ctx
+		noProgress = quiet
+	}(runCtx, noProgress)
+	noProgress = true
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	runCtx = ctx
+
+	path := filepath.Join(t.TempDir(), "agent")
+	err := downloadFile(server.URL+"/agent", path)
+	assert.Error(t, err)
+	assert.Equal(t, errCodeTimeout, errorCodeOf(timeoutError(ctx, err)))
+
+	_, statErr := os.Stat(path)
+	assert.True(t, os.IsNotExist(statErr), "partial download left behind")
+}
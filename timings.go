@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	timingPhase    = "phase"
+	timingDownload = "download"
+	timingExtract  = "extract"
+	timingLink     = "link"
+)
+
+var profileTimings string
+
+// timings collects the wall time of every phase and artifact of the run,
+// downloads record into it concurrently.
+var timings timingRecorder
+
+type timingEntry struct {
+	Kind       string    `json:"kind"`
+	Name       string    `json:"name"`
+	Start      time.Time `json:"start"`
+	DurationMS int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type timingReport struct {
+	Start      time.Time     `json:"start"`
+	DurationMS int64         `json:"duration_ms"`
+	Entries    []timingEntry `json:"entries"`
+}
+
+type timingRecorder struct {
+	mu      sync.Mutex
+	entries []timingEntry
+}
+
+func addTimingFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&profileTimings, "profile-timings", "", "print a per-phase and per-artifact timing breakdown and write it as JSON to this file")
+}
+
+func (r *timingRecorder) record(kind, name string, start time.Time, err error) {
+	entry := timingEntry{
+		Kind:       kind,
+		Name:       name,
+		Start:      start,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries = append(r.entries, entry)
+}
+
+func (r *timingRecorder) report(start time.Time) timingReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := append([]timingEntry(nil), r.entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Start.Before(entries[j].Start)
+	})
+
+	return timingReport{Start: start, DurationMS: time.Since(start).Milliseconds(), Entries: entries}
+}
+
+// writeTimingReport prints the breakdown, slowest first, and writes the
+// JSON report. It also runs for failed runs, they are the slow ones.
+func writeTimingReport(start time.Time) error {
+	if profileTimings == "" {
+		return nil
+	}
+
+	report := timings.report(start)
+
+	slowest := append([]timingEntry(nil), report.Entries...)
+	sort.SliceStable(slowest, func(i, j int) bool {
+		return slowest[i].DurationMS > slowest[j].DurationMS
+	})
+
+	fmt.Println()
+	printMsg("timings.header", "total", formatElapsed(time.Duration(report.DurationMS)*time.Millisecond))
+	for _, entry := range slowest {
+		mark := ""
+		if entry.Error != "" {
+			mark = " " + stepFailed
+		}
+		fmt.Printf("  %8s  %-8s  %s%s\n", formatElapsed(time.Duration(entry.DurationMS)*time.Millisecond), entry.Kind, entry.Name, mark)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path, err := expandPath(profileTimings)
+	if err != nil {
+		return fmt.Errorf("failed to expand path: %w", err)
+	}
+
+	if err := writeFileAtomic(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("write timing report failed: %w", err)
+	}
+
+	return nil
+}
+
+// timed runs fn and records its wall time.
+func timed(kind, name string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	timings.record(kind, name, start, err)
+
+	return err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteTimingReport(t *testing.T) {
+	defer func(path string) {
+		profileTimings = path
+		timings = timingRecorder{}
+	}(profileTimings)
+	timings = timingRecorder{}
+	profileTimings = filepath.Join(t.TempDir(), "timings.json")
+
+	start := time.Now().Add(-3 * time.Second)
+	timings.record(timingPhase, "clone clang", start.Add(time.Second), nil)
+	timings.record(timingDownload, "distninja", start, errors.New("status code 503"))
+
+	captureStdout(t, func() {
+		assert.NoError(t, writeTimingReport(start))
+	})
+
+	data, err := os.ReadFile(profileTimings)
+	assert.NoError(t, err)
+
+	var report timingReport
+	assert.NoError(t, json.Unmarshal(data, &report))
+	assert.GreaterOrEqual(t, report.DurationMS, int64(3000))
+	if assert.Len(t, report.Entries, 2) {
+		// Entries are in start order
+		assert.Equal(t, "distninja", report.Entries[0].Name)
+		assert.Equal(t, "status code 503", report.Entries[0].Error)
+		assert.Equal(t, timingPhase, report.Entries[1].Kind)
+	}
+}
+
+func TestStepRecordsTiming(t *testing.T) {
+	defer func(quiet bool) {
+		noProgress = quiet
+		timings = timingRecorder{}
+	}(noProgress)
+	noProgress = true
+	timings = timingRecorder{}
+
+	captureStdout(t, func() {
+		_ = timed(timingExtract, "agent", func() error { return nil })
+		_ = runStep("download agent", func() error { return nil })
+	})
+
+	report := timings.report(time.Now())
+	if assert.Len(t, report.Entries, 2) {
+		assert.Equal(t, timingExtract, report.Entries[0].Kind)
+		assert.Equal(t, "download agent", report.Entries[1].Name)
+	}
+}
+
+func TestNoTimingReportByDefault(t *testing.T) {
+	defer func(path string) {
+		profileTimings = path
+	}(profileTimings)
+	profileTimings = ""
+
+	assert.Empty(t, captureStdout(t, func() {
+		assert.NoError(t, writeTimingReport(time.Now()))
+	}))
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+var fullCommitHash = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+//go:embed toolchains.json
+var embeddedToolchainManifest []byte
+
+var (
+	toolchainManifestSource string
+	toolchainHostOS         string
+)
+
+// toolchainHostOSes are the host platforms prebuilts are published for.
+var toolchainHostOSes = []string{"linux", "darwin", "windows"}
+
+type toolchainManifest struct {
+	Toolchains []toolchainSpec `json:"toolchains"`
+}
+
+// toolchainSpec describes one prebuilt repo. Repo is relative to REPO_HOST
+// and Path to --distbuild-path. Sparse lists the directories (or, with
+// wildcards, gitignore-style patterns) to materialize, all when empty.
+// Commit pins the checkout to an expected full commit hash. HostOS limits
+// the entry to one host platform, empty means every host.
+type toolchainSpec struct {
+	Name   string   `json:"name"`
+	HostOS string   `json:"host_os,omitempty"`
+	Repo   string   `json:"repo"`
+	Path   string   `json:"path"`
+	Branch string   `json:"branch,omitempty"`
+	Commit string   `json:"commit,omitempty"`
+	Sparse []string `json:"sparse,omitempty"`
+}
+
+// key identifies the toolchain in the store and the install manifest, so
+// variants of several hosts can be provisioned into one shared path.
+func (tc toolchainSpec) key() string {
+	if tc.HostOS == "" {
+		return tc.Name
+	}
+
+	return tc.Name + "-" + tc.HostOS
+}
+
+func checkToolchainHostOS() error {
+	if toolchainHostOS == "" {
+		toolchainHostOS = runtime.GOOS
+	}
+
+	if !slices.Contains(toolchainHostOSes, toolchainHostOS) {
+		return fmt.Errorf("unsupported toolchain host os %q, expected one of %s", toolchainHostOS, strings.Join(toolchainHostOSes, ", "))
+	}
+
+	return nil
+}
+
+// toolchainHostDir is the directory name AOSP uses for host prebuilts.
+func toolchainHostDir(hostOS string) string {
+	if hostOS == "" {
+		hostOS = runtime.GOOS
+	}
+
+	return hostOS + "-x86"
+}
+
+// forHost keeps the entries that apply to hostOS.
+func (m *toolchainManifest) forHost(hostOS string) []toolchainSpec {
+	var specs []toolchainSpec
+
+	for _, tc := range m.Toolchains {
+		if tc.HostOS == "" || tc.HostOS == hostOS {
+			specs = append(specs, tc)
+		}
+	}
+
+	return specs
+}
+
+func loadToolchainManifest(source string) (*toolchainManifest, error) {
+	data := embeddedToolchainManifest
+
+	switch {
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		fetched, err := fetchBytes(source)
+		if err != nil {
+			return nil, fmt.Errorf("fetch toolchain manifest failed: %w", err)
+		}
+		data = fetched
+	case source != "":
+		path, err := expandPath(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand path: %w", err)
+		}
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("read toolchain manifest failed: %w", err)
+		}
+	}
+
+	manifest := &toolchainManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("parse toolchain manifest failed: %w", err)
+	}
+
+	for i := range manifest.Toolchains {
+		tc := &manifest.Toolchains[i]
+		if tc.Name == "" || tc.Repo == "" || tc.Path == "" {
+			return nil, fmt.Errorf("toolchain manifest entry %d needs name, repo and path", i)
+		}
+		if tc.Branch == "" {
+			tc.Branch = "master"
+		}
+		if tc.HostOS != "" && !slices.Contains(toolchainHostOSes, tc.HostOS) {
+			return nil, fmt.Errorf("toolchain %s: unsupported host_os %q", tc.Name, tc.HostOS)
+		}
+		if tc.Commit != "" && !fullCommitHash.MatchString(tc.Commit) {
+			return nil, fmt.Errorf("toolchain %s: commit must be a full hex hash, got %q", tc.Name, tc.Commit)
+		}
+	}
+
+	return manifest, nil
+}
+
+// sparseCheckoutArgs returns the `git sparse-checkout set` arguments. Plain
+// directories use cone mode, which is much faster on large repos, any
+// wildcard switches to full pattern matching.
+func sparseCheckoutArgs(patterns []string) []string {
+	args := []string{"sparse-checkout", "set"}
+
+	for _, pattern := range patterns {
+		// Before --no-cone existed non-cone was the default
+		if strings.ContainsAny(pattern, "*?[!") && hasGitFeature(gitNoCone) {
+			args = append(args, "--no-cone")
+			break
+		}
+	}
+
+	return append(args, patterns...)
+}
+
+func resolveRevision(path string) (string, error) {
+	out, err := gitOutput("-C", path, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// verifyToolchainRevision checks a pinned toolchain out at its commit and
+// fails if the mirror cannot produce it. The commit hash covers the whole
+// tree, so a matching HEAD means the binaries are the expected ones.
+func verifyToolchainRevision(path string, tc toolchainSpec) error {
+	if tc.Commit == "" {
+		return nil
+	}
+
+	revision, err := resolveRevision(path)
+	if err != nil {
+		return withCode(errCodeGit, fmt.Errorf("resolve %s revision failed: %w", tc.Name, err))
+	}
+
+	if revision != tc.Commit {
+		logVerbose("%s %s is at %s, fetching pinned commit %s", tc.Name, tc.Branch, revision, tc.Commit)
+		if err := runGit(tc.Name+" fetch", "-C", path, "fetch", "--depth", "1", "origin", tc.Commit); err == nil {
+			_ = runGit(tc.Name+" checkout", "-C", path, "checkout", "-q", "--detach", tc.Commit)
+		}
+		if revision, err = resolveRevision(path); err != nil {
+			return withCode(errCodeGit, fmt.Errorf("resolve %s revision failed: %w", tc.Name, err))
+		}
+	}
+
+	if revision != tc.Commit {
+		return withCode(errCodeVerification, fmt.Errorf("%s revision mismatch: expected %s, got %s", tc.Name, tc.Commit, revision))
+	}
+
+	logVerbose("%s revision %s verified", tc.Name, revision)
+
+	return nil
+}
+
+// toolchainLockName is the lock file of a toolchain in its store directory.
+const toolchainLockName = ".lock"
+
+// toolchainStoreDir holds every installed version of a toolchain as
+// <revision> directories. The manifest path of the toolchain is a symlink
+// to the version in use, so workspaces pinned to different revisions can
+// share one --distbuild-path.
+func toolchainStoreDir(root, name string) string {
+	return filepath.Join(root, "toolchains", name)
+}
+
+// installToolchain clones tc into the versioned store, reusing a version
+// that is already present, and points the toolchain path at it. The store
+// entry of the toolchain is locked meanwhile, a parallel bootstrap waits
+// and then finds the version installed.
+func installToolchain(repo string, tc toolchainSpec) (path string, err error) {
+	store := toolchainStoreDir(distbuildPath, tc.key())
+
+	err = withLock(filepath.Join(store, toolchainLockName), func() error {
+		path, err = installToolchainVersion(repo, tc, store)
+		return err
+	})
+
+	return path, err
+}
+
+func installToolchainVersion(repo string, tc toolchainSpec, store string) (string, error) {
+	link := filepath.Join(distbuildPath, tc.Path)
+
+	revisions := []string{tc.Commit}
+	if tc.Commit == "" {
+		revisions = remoteRevisions(repo, "refs/heads/"+tc.Branch)
+	}
+
+	for _, revision := range revisions {
+		versionDir := filepath.Join(store, revision)
+		if _, err := os.Stat(versionDir); revision != "" && err == nil {
+			logVerbose("%s %s already installed", tc.Name, revision)
+			return versionDir, activateToolchain(versionDir, link)
+		}
+	}
+
+	if err := createDirs(store); err != nil {
+		return "", fmt.Errorf("create toolchain store failed: %w", err)
+	}
+
+	// Under the lock any staging directory is left over from a killed run
+	if stale, err := filepath.Glob(filepath.Join(store, ".staging-*")); err == nil {
+		for _, dir := range stale {
+			_ = removeAll(dir)
+		}
+	}
+
+	staging := filepath.Join(store, fmt.Sprintf(".staging-%d", os.Getpid()))
+	if err := cloneToolchain(repo, staging, tc); err != nil {
+		_ = removeAll(staging)
+		return "", err
+	}
+
+	revision, err := resolveRevision(staging)
+	if err != nil {
+		_ = removeAll(staging)
+		return "", withCode(errCodeGit, fmt.Errorf("resolve %s revision failed: %w", tc.Name, err))
+	}
+
+	versionDir := filepath.Join(store, revision)
+	if _, err := os.Stat(versionDir); err == nil {
+		// Same revision as an installed version, keep the existing copy
+		_ = removeAll(staging)
+	} else if err := os.Rename(staging, versionDir); err != nil {
+		_ = removeAll(staging)
+		return "", fmt.Errorf("install %s failed: %w", tc.Name, err)
+	}
+
+	return versionDir, activateToolchain(versionDir, link)
+}
+
+// activateToolchain replaces link, a symlink or a checkout from before the
+// versioned store, with a symlink to versionDir. A symlink is replaced by
+// renaming the new one over it, so the toolchain path never goes missing
+// for a build that runs meanwhile.
+func activateToolchain(versionDir, link string) (err error) {
+	defer func(start time.Time) { timings.record(timingLink, link, start, err) }(time.Now())
+
+	if current, err := os.Readlink(link); err == nil && current == versionDir {
+		return nil
+	}
+
+	if err := createDirs(filepath.Dir(link)); err != nil {
+		return fmt.Errorf("create directory failed: %w", err)
+	}
+
+	tmp := link + ".link"
+	_ = os.Remove(tmp)
+
+	if err := os.Symlink(versionDir, tmp); err != nil {
+		return fmt.Errorf("link toolchain failed: %w", err)
+	}
+
+	replace := func() error { return os.Rename(tmp, link) }
+
+	if info, err := os.Lstat(link); err == nil {
+		if info.Mode()&os.ModeSymlink != 0 {
+			err = audited("replace-link", link+" -> "+versionDir, func() error {
+				// Windows does not rename over a directory link
+				if err := replace(); err == nil || runtime.GOOS != "windows" {
+					return err
+				}
+				_ = os.Remove(link)
+				return replace()
+			})
+		} else if err = confirmRemoval("toolchain directory", link); err == nil {
+			if err = removeAll(link); err == nil {
+				err = replace()
+			}
+		}
+		if err != nil {
+			_ = os.Remove(tmp)
+			return fmt.Errorf("replace %s failed: %w", link, err)
+		}
+	} else if err := replace(); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("link toolchain failed: %w", err)
+	}
+
+	// The modification time tells prune when a version was last installed
+	now := time.Now()
+	_ = os.Chtimes(versionDir, now, now)
+
+	noteChange("link " + link)
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadToolchainManifest(t *testing.T) {
+	manifest, err := loadToolchainManifest("")
+	assert.NoError(t, err)
+	assert.Equal(t, "clang", manifest.Toolchains[0].Name)
+
+	path := filepath.Join(t.TempDir(), "toolchains.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"toolchains":[{"name":"clang","repo":"r","path":"p","sparse":["clang-r510928"]}]}`), 0644))
+
+	manifest, err = loadToolchainManifest(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "master", manifest.Toolchains[0].Branch)
+	assert.Equal(t, []string{"clang-r510928"}, manifest.Toolchains[0].Sparse)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"toolchains":[{"name":"clang"}]}`), 0644))
+	_, err = loadToolchainManifest(path)
+	assert.Error(t, err)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"toolchains":[{"name":"clang","repo":"r","path":"p","commit":"abc123"}]}`), 0644))
+	_, err = loadToolchainManifest(path)
+	assert.Error(t, err)
+}
+
+func TestToolchainsForHost(t *testing.T) {
+	defer func(hostOS string) {
+		toolchainHostOS = hostOS
+	}(toolchainHostOS)
+
+	manifest, err := loadToolchainManifest("")
+	assert.NoError(t, err)
+
+	var keys []string
+	for _, tc := range manifest.forHost("darwin") {
+		keys = append(keys, tc.key())
+	}
+	assert.Equal(t, []string{"clang-darwin", "gcc-darwin", "rust-darwin", "jdk17-darwin", "jdk21-darwin"}, keys)
+	assert.Len(t, manifest.forHost("windows"), 2)
+
+	toolchainHostOS = "windows"
+	assert.NoError(t, checkToolchainHostOS())
+	assert.Equal(t, "windows-x86", toolchainHostDir(toolchainHostOS))
+
+	toolchainHostOS = "plan9"
+	assert.Error(t, checkToolchainHostOS())
+}
+
+func TestSparseCheckoutArgs(t *testing.T) {
+	assert.Equal(t, []string{"sparse-checkout", "set", "clang-r510928", "soong"}, sparseCheckoutArgs([]string{"clang-r510928", "soong"}))
+	assert.Equal(t, []string{"sparse-checkout", "set", "--no-cone", "/clang-r5*/"}, sparseCheckoutArgs([]string{"/clang-r5*/"}))
+}
+
+func TestCloneToolchainSparse(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	src := t.TempDir()
+	for _, dir := range []string{"clang-r1", "clang-r2"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(src, dir), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(src, dir, "clang"), []byte(dir), 0644))
+	}
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "master"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		assert.NoError(t, exec.Command("git", append([]string{"-C", src}, args...)...).Run())
+	}
+
+	dst := filepath.Join(t.TempDir(), "clang")
+	tc := toolchainSpec{Name: "clang", Branch: "master", Sparse: []string{"clang-r2"}}
+	assert.NoError(t, cloneToolchain("file://"+src, dst, tc))
+
+	assert.FileExists(t, filepath.Join(dst, "clang-r2", "clang"))
+	assert.NoDirExists(t, filepath.Join(dst, "clang-r1"))
+}
+
+func TestVerifyToolchainRevision(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	src := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "clang"), []byte("clang"), 0644))
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "master"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		assert.NoError(t, exec.Command("git", append([]string{"-C", src}, args...)...).Run())
+	}
+
+	head, err := resolveRevision(src)
+	assert.NoError(t, err)
+
+	// Removal is only allowed inside the distbuild path
+	defer func(path string) {
+		distbuildPath = path
+	}(distbuildPath)
+	distbuildPath = t.TempDir()
+
+	dst := filepath.Join(distbuildPath, "clang")
+	assert.NoError(t, cloneToolchain("file://"+src, dst, toolchainSpec{Name: "clang", Branch: "master", Commit: head}))
+	assert.FileExists(t, filepath.Join(dst, "clang"))
+
+	err = cloneToolchain("file://"+src, dst, toolchainSpec{Name: "clang", Branch: "master", Commit: strings.Repeat("0", 40)})
+	assert.Equal(t, errCodeVerification, errorCodeOf(err))
+	assert.NoDirExists(t, dst)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"sync/atomic"
+
+	"distbuild/boong/bootstrap/download"
+	"github.com/spf13/cobra"
+)
+
+// requestIDAuto makes bootstrap generate the request id of the run.
+const requestIDAuto = "auto"
+
+var (
+	userAgentFlag string
+	requestIDFlag string
+)
+
+func addRequestHeaderFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&userAgentFlag, "user-agent", "",
+		"User-Agent of every request (default USER_AGENT, else bootstrap version and platform)")
+	cmd.PersistentFlags().StringVar(&requestIDFlag, "request-id", "",
+		"send X-Request-ID <id>-<n> with every request, "+requestIDAuto+" generates the id (default REQUEST_ID)")
+}
+
+// defaultUserAgent names the bootstrap build and the host platform, e.g.
+// distbuild-bootstrap/20240501-1a2b3c4 (linux; amd64; go1.23.1).
+func defaultUserAgent() string {
+	version := BuildTime + "-" + CommitID
+	if BuildTime == "" && CommitID == "" {
+		version = "dev"
+	}
+
+	return fmt.Sprintf("distbuild-bootstrap/%s (%s; %s; %s)", version, runtime.GOOS, runtime.GOARCH, runtime.Version())
+}
+
+func resolveUserAgent() string {
+	if userAgentFlag != "" {
+		return userAgentFlag
+	}
+	if value := os.Getenv("USER_AGENT"); value != "" {
+		return value
+	}
+
+	return defaultUserAgent()
+}
+
+// resolveRequestID returns the id the requests of the run are traced
+// under, empty when tracing is off.
+func resolveRequestID() (string, error) {
+	id := requestIDFlag
+	if id == "" {
+		id = os.Getenv("REQUEST_ID")
+	}
+	if id != requestIDAuto {
+		return id, nil
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate request id failed: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// headerTransport adds the User-Agent and, when tracing, a X-Request-ID to
+// every request, so the artifact server logs can attribute the traffic and
+// a failure can be found in them. Headers set by the caller are kept. As
+// every request and redirect of the run passes it, it also refuses the
+// hosts the host policy denies.
+type headerTransport struct {
+	*http.Transport
+	userAgent string
+	requestID string
+	policy    *hostPolicy
+	requests  atomic.Uint64
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.policy.checkHost(req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	if t.requestID != "" && req.Header.Get(download.RequestIDHeader) == "" {
+		req.Header.Set(download.RequestIDHeader, fmt.Sprintf("%s-%d", t.requestID, t.requests.Add(1)))
+	}
+
+	return t.Transport.RoundTrip(req)
+}
+
+func newHeaderTransport(transport *http.Transport) (*headerTransport, error) {
+	requestID, err := resolveRequestID()
+	if err != nil {
+		return nil, err
+	}
+	if requestID != "" {
+		logVerbose("tracing requests as %s", requestID)
+	}
+
+	policy, err := loadHostPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	return &headerTransport{Transport: transport, userAgent: resolveUserAgent(), requestID: requestID, policy: policy}, nil
+}
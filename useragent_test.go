@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultUserAgent(t *testing.T) {
+	defer func(buildTime, commitID string) {
+		BuildTime, CommitID = buildTime, commitID
+	}(BuildTime, CommitID)
+
+	BuildTime, CommitID = "", ""
+	assert.True(t, strings.HasPrefix(defaultUserAgent(), "distbuild-bootstrap/dev ("))
+
+	BuildTime, CommitID = "20240501", "1a2b3c4"
+	assert.True(t, strings.HasPrefix(defaultUserAgent(), "distbuild-bootstrap/20240501-1a2b3c4 ("))
+}
+
+func TestResolveRequestID(t *testing.T) {
+	defer func(id string) {
+		requestIDFlag = id
+	}(requestIDFlag)
+
+	requestIDFlag = ""
+	t.Setenv("REQUEST_ID", "")
+	id, err := resolveRequestID()
+	assert.NoError(t, err)
+	assert.Empty(t, id)
+
+	t.Setenv("REQUEST_ID", "ci-42")
+	id, err = resolveRequestID()
+	assert.NoError(t, err)
+	assert.Equal(t, "ci-42", id)
+
+	requestIDFlag = requestIDAuto
+	id, err = resolveRequestID()
+	assert.NoError(t, err)
+	assert.Len(t, id, 16)
+}
+
+func TestRequestHeaders(t *testing.T) {
+	defer func(agent, id string, client *http.Client) {
+		userAgentFlag, requestIDFlag, httpClient = agent, id, client
+	}(userAgentFlag, requestIDFlag, httpClient)
+
+	var mu sync.Mutex
+	var agents, ids []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents = append(agents, r.UserAgent())
+		ids = append(ids, r.Header.Get("X-Request-ID"))
+		mu.Unlock()
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	userAgentFlag, requestIDFlag = "ci-runner/1.0", "run-1"
+	assert.NoError(t, setupHTTPClient())
+
+	_, err := fetchBytes(server.URL + "/ok")
+	assert.NoError(t, err)
+
+	// A failure names the request id to look up server side
+	_, err = fetchBytes(server.URL + "/missing")
+	var statusErr *httpStatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, "run-1-2", statusErr.RequestID)
+	assert.ErrorContains(t, err, "request id run-1-2")
+
+	assert.Equal(t, []string{"ci-runner/1.0", "ci-runner/1.0"}, agents)
+	assert.Equal(t, []string{"run-1-1", "run-1-2"}, ids)
+
+	// Without tracing no request id is sent
+	agents, ids = nil, nil
+	userAgentFlag, requestIDFlag = "", ""
+	t.Setenv("REQUEST_ID", "")
+	assert.NoError(t, setupHTTPClient())
+	_, err = fetchBytes(server.URL + "/ok")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{defaultUserAgent()}, agents)
+	assert.Equal(t, []string{""}, ids)
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	reportText  = "text"
+	reportJSON  = "json"
+	reportSARIF = "sarif"
+)
+
+// driftSeverity ranks a verification finding. The exit code of verify is
+// that of the most severe finding, so cron jobs can alert on it.
+type driftSeverity string
+
+const (
+	driftWarning  driftSeverity = "warning"
+	driftError    driftSeverity = "error"
+	driftCritical driftSeverity = "critical"
+)
+
+var driftExitCodes = map[driftSeverity]int{
+	driftWarning:  10,
+	driftError:    11,
+	driftCritical: 12,
+}
+
+var (
+	verifyDeep       bool
+	verifyReport     string
+	verifyReportFile string
+)
+
+// Installed service files, tests point them elsewhere.
+var (
+	verifyServicePath = "/etc/systemd/system/distbuild.service"
+	verifyAgentTarget = "/usr/local/bin/distbuild-agent"
+)
+
+// serviceActive asks systemd whether the agent service runs.
+var serviceActive = func() bool {
+	return exec.Command("systemctl", "is-active", "--quiet", "distbuild.service").Run() == nil
+}
+
+// verifyRule describes a check, ids are stable for the compliance scanner.
+type verifyRule struct {
+	ID          string
+	Name        string
+	Description string
+}
+
+var verifyRules = []verifyRule{
+	{"DB001", "component-missing", "An installed component recorded in the manifest is missing"},
+	{"DB002", "component-modified", "An installed component no longer matches its recorded sha256"},
+	{"DB003", "component-not-executable", "An installed component lost its execute permission"},
+	{"DB004", "toolchain-missing", "A recorded toolchain checkout is missing"},
+	{"DB005", "toolchain-revision", "A toolchain checkout is not at its recorded revision"},
+	{"DB006", "toolchain-modified", "A toolchain checkout has local modifications"},
+	{"DB007", "dangling-symlink", "A symlink in the distbuild path points nowhere"},
+	{"DB008", "service-file-drift", "The installed agent service unit differs from the one bootstrap installs"},
+	{"DB009", "service-inactive", "The agent service is installed but not running"},
+}
+
+type verifyFinding struct {
+	Rule     string        `json:"rule"`
+	Severity driftSeverity `json:"severity"`
+	Path     string        `json:"path"`
+	Message  string        `json:"message"`
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "check an install for drift without changing anything",
+	Long: "Check the components and toolchains recorded in the install manifest. With\n" +
+		"--deep every artifact is re-hashed and symlinks and the agent service are\n" +
+		"audited too. Nothing is written except the report. Exits 0 without findings,\n" +
+		"10, 11 or 12 when the worst finding is a warning, an error or critical.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := checkVerifyFlags(); err != nil {
+			exitWithError(withCode(errCodeUsage, err))
+		}
+		findings, err := verifyInstall()
+		if err != nil {
+			exitWithError(err)
+		}
+		if err := writeVerifyReport(findings); err != nil {
+			exitWithError(err)
+		}
+		if code := verifyExitCode(findings); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	verifyCmd.Flags().StringVar(&distbuildPath, "distbuild-path", "", "distbuild binaries path")
+	_ = verifyCmd.MarkFlagRequired("distbuild-path")
+
+	verifyCmd.Flags().BoolVar(&verifyDeep, "deep", false, "re-hash every artifact and audit symlinks and the agent service")
+	verifyCmd.Flags().StringVar(&verifyReport, "report", reportText, "report format (text, json, sarif)")
+	verifyCmd.Flags().StringVar(&verifyReportFile, "report-file", "", "write the report to a file instead of stdout")
+}
+
+func checkVerifyFlags() error {
+	switch verifyReport {
+	case reportText, reportJSON, reportSARIF:
+	default:
+		return fmt.Errorf("unsupported report format %q", verifyReport)
+	}
+
+	var err error
+	distbuildPath, err = expandPath(distbuildPath)
+	if err != nil {
+		return fmt.Errorf("failed to expand path: %w", err)
+	}
+
+	return nil
+}
+
+func verifyInstall() ([]verifyFinding, error) {
+	manifest, err := loadManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []verifyFinding
+
+	for _, c := range manifest.sortedComponents() {
+		findings = append(findings, verifyComponent(c)...)
+	}
+
+	for _, tc := range manifest.sortedToolchains() {
+		findings = append(findings, verifyToolchain(tc)...)
+	}
+
+	if verifyDeep {
+		findings = append(findings, verifySymlinks(distbuildPath)...)
+		findings = append(findings, verifyService()...)
+	}
+
+	return findings, nil
+}
+
+// componentPath finds an installed component, a systemd install moved the
+// agent out of the distbuild path.
+func componentPath(c *manifestComponent) string {
+	if _, err := os.Stat(c.Path); err != nil && c.Name == "agent" {
+		if _, err := os.Stat(verifyAgentTarget); err == nil {
+			return verifyAgentTarget
+		}
+	}
+
+	return c.Path
+}
+
+func verifyComponent(c *manifestComponent) []verifyFinding {
+	path := componentPath(c)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return []verifyFinding{{"DB001", driftError, path, fmt.Sprintf("%s is missing: %v", c.Name, err)}}
+	}
+
+	var findings []verifyFinding
+
+	if info.Mode().Perm()&0111 == 0 {
+		findings = append(findings, verifyFinding{"DB003", driftWarning, path, fmt.Sprintf("%s is not executable (%s)", c.Name, info.Mode().Perm())})
+	}
+
+	if verifyDeep && c.SHA256 != "" {
+		sum, err := fileSHA256(path)
+		switch {
+		case err != nil:
+			findings = append(findings, verifyFinding{"DB001", driftError, path, fmt.Sprintf("%s is unreadable: %v", c.Name, err)})
+		case sum != c.SHA256:
+			findings = append(findings, verifyFinding{"DB002", driftCritical, path, fmt.Sprintf("%s sha256 is %s, recorded %s", c.Name, sum, c.SHA256)})
+		}
+	}
+
+	return findings
+}
+
+func verifyToolchain(tc *manifestToolchain) []verifyFinding {
+	if _, err := os.Stat(tc.Path); err != nil {
+		return []verifyFinding{{"DB004", driftError, tc.Path, fmt.Sprintf("toolchain %s is missing: %v", tc.Name, err)}}
+	}
+
+	if !verifyDeep {
+		return nil
+	}
+
+	revision, err := resolveRevision(tc.Path)
+	if err != nil {
+		return []verifyFinding{{"DB005", driftError, tc.Path, fmt.Sprintf("toolchain %s revision unreadable: %v", tc.Name, err)}}
+	}
+
+	if revision != tc.Revision {
+		return []verifyFinding{{"DB005", driftCritical, tc.Path, fmt.Sprintf("toolchain %s is at %s, recorded %s", tc.Name, revision, tc.Revision)}}
+	}
+
+	// Without optional locks git status does not refresh the index
+	out, err := gitOutput("--no-optional-locks", "-C", tc.Path, "status", "--porcelain")
+	if err == nil && len(strings.TrimSpace(string(out))) > 0 {
+		changed := len(strings.Split(strings.TrimSpace(string(out)), "\n"))
+		return []verifyFinding{{"DB006", driftWarning, tc.Path, fmt.Sprintf("toolchain %s has %d modified or untracked paths", tc.Name, changed)}}
+	}
+
+	return nil
+}
+
+func verifySymlinks(root string) []verifyFinding {
+	var findings []verifyFinding
+
+	_ = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		if _, err := os.Stat(p); errors.Is(err, fs.ErrNotExist) {
+			target, _ := os.Readlink(p)
+			findings = append(findings, verifyFinding{"DB007", driftWarning, p, fmt.Sprintf("symlink points to missing %s", target)})
+		}
+		return nil
+	})
+
+	return findings
+}
+
+func verifyService() []verifyFinding {
+	data, err := os.ReadFile(verifyServicePath)
+	if err != nil {
+		return nil
+	}
+
+	var findings []verifyFinding
+
+	if string(data) != agentServiceFile {
+		findings = append(findings, verifyFinding{"DB008", driftWarning, verifyServicePath, "service unit was modified after install"})
+	}
+
+	if !serviceActive() {
+		findings = append(findings, verifyFinding{"DB009", driftError, verifyServicePath, "distbuild.service is not active"})
+	}
+
+	return findings
+}
+
+func verifyExitCode(findings []verifyFinding) int {
+	code := 0
+	for _, f := range findings {
+		code = max(code, driftExitCodes[f.Severity])
+	}
+
+	return code
+}
+
+func writeVerifyReport(findings []verifyFinding) error {
+	out := io.Writer(os.Stdout)
+
+	if verifyReportFile != "" {
+		path, err := expandPath(verifyReportFile)
+		if err != nil {
+			return fmt.Errorf("failed to expand path: %w", err)
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("create report failed: %w", err)
+		}
+		defer func(f *os.File) {
+			_ = f.Close()
+		}(f)
+		out = f
+	}
+
+	switch verifyReport {
+	case reportJSON:
+		return writeJSONReport(out, findings)
+	case reportSARIF:
+		return writeSARIFReport(out, findings)
+	}
+
+	for _, f := range findings {
+		_, _ = fmt.Fprintf(out, "%-8s %s %s: %s\n", f.Severity, f.Rule, f.Path, f.Message)
+	}
+	_, err := fmt.Fprintln(out, msg("verify.summary", "count", len(findings), "path", distbuildPath))
+
+	return err
+}
+
+func writeJSONReport(out io.Writer, findings []verifyFinding) error {
+	summary := map[driftSeverity]int{}
+	for _, f := range findings {
+		summary[f.Severity]++
+	}
+
+	status := "clean"
+	if len(findings) > 0 {
+		status = "drift"
+	}
+
+	if findings == nil {
+		findings = []verifyFinding{}
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(struct {
+		DistbuildPath string                `json:"distbuild_path"`
+		Deep          bool                  `json:"deep"`
+		Status        string                `json:"status"`
+		Summary       map[driftSeverity]int `json:"summary"`
+		Findings      []verifyFinding       `json:"findings"`
+	}{distbuildPath, verifyDeep, status, summary, findings})
+}
+
+// writeSARIFReport writes a SARIF 2.1.0 log, critical findings are errors
+// carrying their severity as a property.
+func writeSARIFReport(out io.Writer, findings []verifyFinding) error {
+	type message struct {
+		Text string `json:"text"`
+	}
+	type rule struct {
+		ID               string  `json:"id"`
+		Name             string  `json:"name"`
+		ShortDescription message `json:"shortDescription"`
+	}
+	type location struct {
+		PhysicalLocation struct {
+			ArtifactLocation struct {
+				URI string `json:"uri"`
+			} `json:"artifactLocation"`
+		} `json:"physicalLocation"`
+	}
+	type result struct {
+		RuleID     string            `json:"ruleId"`
+		Level      string            `json:"level"`
+		Message    message           `json:"message"`
+		Locations  []location        `json:"locations"`
+		Properties map[string]string `json:"properties"`
+	}
+
+	rules := make([]rule, 0, len(verifyRules))
+	for _, r := range verifyRules {
+		rules = append(rules, rule{ID: r.ID, Name: r.Name, ShortDescription: message{r.Description}})
+	}
+
+	results := make([]result, 0, len(findings))
+	for _, f := range findings {
+		level := "error"
+		if f.Severity == driftWarning {
+			level = "warning"
+		}
+		var loc location
+		loc.PhysicalLocation.ArtifactLocation.URI = "file://" + filepath.ToSlash(f.Path)
+		results = append(results, result{
+			RuleID:     f.Rule,
+			Level:      level,
+			Message:    message{f.Message},
+			Locations:  []location{loc},
+			Properties: map[string]string{"severity": string(f.Severity)},
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].RuleID < results[j].RuleID
+	})
+
+	log := map[string]interface{}{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []interface{}{
+			map[string]interface{}{
+				"tool": map[string]interface{}{
+					"driver": map[string]interface{}{
+						"name":    "distbuild-bootstrap",
+						"version": rootCmd.Version,
+						"rules":   rules,
+					},
+				},
+				"results": results,
+			},
+		},
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(log)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyInstall(t *testing.T) {
+	defer func(path string, deep bool, service string, active func() bool) {
+		distbuildPath, verifyDeep, verifyServicePath, serviceActive = path, deep, service, active
+	}(distbuildPath, verifyDeep, verifyServicePath, serviceActive)
+
+	distbuildPath, verifyDeep = t.TempDir(), true
+	verifyServicePath = filepath.Join(t.TempDir(), "distbuild.service")
+	serviceActive = func() bool { return false }
+
+	proxy := filepath.Join(distbuildPath, "proxy")
+	assert.NoError(t, os.WriteFile(proxy, []byte("proxy"), 0755))
+	sum, err := fileSHA256(proxy)
+	assert.NoError(t, err)
+
+	manifest, err := loadManifest()
+	assert.NoError(t, err)
+	manifest.Components["proxy"] = &manifestComponent{Name: "proxy", Path: proxy, SHA256: sum}
+	manifest.Components["server"] = &manifestComponent{Name: "server", Path: filepath.Join(distbuildPath, "server")}
+	assert.NoError(t, saveManifest(manifest))
+	assert.NoError(t, os.Symlink(filepath.Join(distbuildPath, "gone"), filepath.Join(distbuildPath, "link")))
+
+	findings, err := verifyInstall()
+	assert.NoError(t, err)
+	assert.Len(t, findings, 2)
+	assert.Equal(t, "DB001", findings[0].Rule)
+	assert.Equal(t, "DB007", findings[1].Rule)
+	assert.Equal(t, 11, verifyExitCode(findings))
+
+	assert.NoError(t, os.WriteFile(proxy, []byte("tampered"), 0755))
+	assert.NoError(t, os.Chmod(proxy, 0644))
+	assert.NoError(t, os.WriteFile(verifyServicePath, []byte(agentServiceFile+"# edited\n"), 0644))
+
+	findings, err = verifyInstall()
+	assert.NoError(t, err)
+	var rules []string
+	for _, f := range findings {
+		rules = append(rules, f.Rule)
+	}
+	assert.Equal(t, []string{"DB003", "DB002", "DB001", "DB007", "DB008", "DB009"}, rules)
+	assert.Equal(t, 12, verifyExitCode(findings))
+
+	verifyDeep = false
+	findings, err = verifyInstall()
+	assert.NoError(t, err)
+	assert.Len(t, findings, 2)
+	assert.Equal(t, "DB003", findings[0].Rule)
+	assert.Equal(t, 0, verifyExitCode(nil))
+}
+
+func TestWriteSARIFReport(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, writeSARIFReport(&buf, []verifyFinding{
+		{"DB003", driftWarning, "/opt/distbuild/proxy", "not executable"},
+		{"DB002", driftCritical, "/opt/distbuild/agent", "modified"},
+	}))
+
+	var log struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Results []struct {
+				RuleID     string            `json:"ruleId"`
+				Level      string            `json:"level"`
+				Properties map[string]string `json:"properties"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &log))
+	assert.Equal(t, "2.1.0", log.Version)
+	assert.Len(t, log.Runs[0].Results, 2)
+	assert.Equal(t, "DB002", log.Runs[0].Results[0].RuleID)
+	assert.Equal(t, "error", log.Runs[0].Results[0].Level)
+	assert.Equal(t, "critical", log.Runs[0].Results[0].Properties["severity"])
+	assert.Equal(t, "warning", log.Runs[0].Results[1].Level)
+}
+
+func TestWriteJSONReport(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, writeJSONReport(&buf, nil))
+	assert.Contains(t, buf.String(), `"status": "clean"`)
+	assert.Contains(t, buf.String(), `"findings": []`)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var showAllVersions bool
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "print version information",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := printVersions(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	versionCmd.Flags().BoolVar(&showAllVersions, "all", false, "include installed components and toolchains")
+	versionCmd.Flags().StringVar(&distbuildPath, "distbuild-path", "", "distbuild binaries path")
+}
+
+type versionInfo struct {
+	Bootstrap  bootstrapVersion   `json:"bootstrap"`
+	ReleaseSet string             `json:"release_set,omitempty"`
+	Components []componentVersion `json:"components,omitempty"`
+	Toolchains []toolchainVersion `json:"toolchains,omitempty"`
+}
+
+type bootstrapVersion struct {
+	BuildTime string `json:"build_time"`
+	CommitID  string `json:"commit_id"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+	Channel   string `json:"channel,omitempty"`
+}
+
+type componentVersion struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	SHA256  string `json:"sha256"`
+}
+
+type toolchainVersion struct {
+	Name     string `json:"name"`
+	Revision string `json:"revision"`
+}
+
+func collectVersions() (*versionInfo, error) {
+	info := &versionInfo{
+		Bootstrap: bootstrapVersion{
+			BuildTime: BuildTime,
+			CommitID:  CommitID,
+			GoVersion: runtime.Version(),
+			Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+			Channel:   DefaultChannel,
+		},
+	}
+
+	if !showAllVersions {
+		return info, nil
+	}
+
+	if distbuildPath == "" {
+		return nil, withCode(errCodeUsage, fmt.Errorf("--distbuild-path is required with --all"))
+	}
+
+	var err error
+
+	distbuildPath, err = expandPath(distbuildPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand path: %w", err)
+	}
+
+	manifest, err := loadManifest()
+	if err != nil {
+		return nil, fmt.Errorf("load manifest failed: %w", err)
+	}
+
+	info.ReleaseSet = manifest.ReleaseSet
+
+	for _, c := range manifest.sortedComponents() {
+		info.Components = append(info.Components, componentVersion{Name: c.Name, Version: c.Version, SHA256: c.SHA256})
+	}
+
+	for _, tc := range manifest.sortedToolchains() {
+		info.Toolchains = append(info.Toolchains, toolchainVersion{Name: tc.Name, Revision: tc.Revision})
+	}
+
+	return info, nil
+}
+
+func printVersions() error {
+	info, err := collectVersions()
+	if err != nil {
+		return err
+	}
+
+	if outputFormat == outputJSON {
+		data, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, msg("version.header"))
+	_, _ = fmt.Fprintf(w, "bootstrap\t%s-%s\t%s %s\n", info.Bootstrap.BuildTime, info.Bootstrap.CommitID,
+		info.Bootstrap.GoVersion, info.Bootstrap.Platform)
+	if info.Bootstrap.Channel != "" {
+		_, _ = fmt.Fprintf(w, "channel\t%s\t\n", info.Bootstrap.Channel)
+	}
+	if info.ReleaseSet != "" {
+		_, _ = fmt.Fprintf(w, "release set\t%s\t\n", info.ReleaseSet)
+	}
+
+	for _, c := range info.Components {
+		version := c.Version
+		if version == "" {
+			version = "unknown"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\tsha256:%s\n", c.Name, version, shortHash(c.SHA256))
+	}
+
+	for _, tc := range info.Toolchains {
+		_, _ = fmt.Fprintf(w, "%s\t%s\ttoolchain\n", tc.Name, shortHash(tc.Revision))
+	}
+
+	return w.Flush()
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+
+	return hash
+}
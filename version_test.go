@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectVersions(t *testing.T) {
+	defer func(all bool, path string) {
+		showAllVersions, distbuildPath = all, path
+	}(showAllVersions, distbuildPath)
+
+	showAllVersions, distbuildPath = true, t.TempDir()
+
+	manifest, err := loadManifest()
+	assert.NoError(t, err)
+	manifest.Components["proxy"] = &manifestComponent{Name: "proxy", Version: "proxy 1.2.0", SHA256: "abc"}
+	manifest.Toolchains["clang"] = &manifestToolchain{Name: "clang", Revision: "deadbeef"}
+	assert.NoError(t, saveManifest(manifest))
+
+	info, err := collectVersions()
+	assert.NoError(t, err)
+	assert.Equal(t, []componentVersion{{Name: "proxy", Version: "proxy 1.2.0", SHA256: "abc"}}, info.Components)
+	assert.Equal(t, []toolchainVersion{{Name: "clang", Revision: "deadbeef"}}, info.Toolchains)
+
+	distbuildPath = ""
+	_, err = collectVersions()
+	assert.Error(t, err)
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	watchdogAuto      = "auto"
+	watchdogTimer     = "timer"
+	watchdogCron      = "cron"
+	watchdogSupervise = "supervise"
+)
+
+const (
+	watchdogStateFileName = "watchdog.json"
+	watchdogUnit          = "distbuild-watchdog"
+	watchdogCronPath      = "/etc/cron.d/distbuild-watchdog"
+	watchdogCronMarker    = "# distbuild-watchdog"
+	// watchdogStartGrace is how long a supervised agent may take to answer
+	// its health endpoint after a start
+	watchdogStartGrace = time.Minute
+)
+
+var (
+	watchdogMode       string
+	watchdogInterval   time.Duration
+	watchdogBackoff    time.Duration
+	watchdogMaxBackoff time.Duration
+)
+
+var agentWatchdogCmd = &cobra.Command{
+	Use:   "watchdog",
+	Short: "check the agent health once and restart it when unhealthy",
+	Long: "Check the health endpoint of the agent and restart an unhealthy agent,\n" +
+		"through systemd when it manages the agent. Meant to run from a timer or\n" +
+		"cron: consecutive restarts back off from --watchdog-backoff up to\n" +
+		"--watchdog-max-backoff, every unhealthy check is sent to the notification\n" +
+		"sinks.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := prepareAgentCommand(); err != nil {
+			exitWithError(err)
+		}
+		if err := runWatchdog(time.Now()); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	addWatchdogBackoffFlags(agentWatchdogCmd)
+
+	agentCmd.AddCommand(agentWatchdogCmd)
+}
+
+func addWatchdogFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&watchdogMode, "watchdog", "", "install an agent health watchdog: auto, timer, cron, supervise")
+	cmd.Flags().DurationVar(&watchdogInterval, "watchdog-interval", time.Minute, "time between agent health checks of the watchdog")
+	addWatchdogBackoffFlags(cmd)
+}
+
+func addWatchdogBackoffFlags(cmd *cobra.Command) {
+	cmd.Flags().DurationVar(&watchdogBackoff, "watchdog-backoff", time.Minute, "wait at least this long before the watchdog restarts the agent again")
+	cmd.Flags().DurationVar(&watchdogMaxBackoff, "watchdog-max-backoff", 30*time.Minute, "upper bound of the doubling watchdog restart backoff")
+}
+
+func checkWatchdogFlags() error {
+	switch watchdogMode {
+	case "", watchdogAuto, watchdogTimer, watchdogCron:
+	case watchdogSupervise:
+		if !superviseAgentOn {
+			return fmt.Errorf("--watchdog supervise needs --supervise")
+		}
+	default:
+		return fmt.Errorf("unsupported watchdog %q", watchdogMode)
+	}
+
+	if watchdogInterval < time.Second {
+		return fmt.Errorf("--watchdog-interval must be at least 1s")
+	}
+
+	if watchdogBackoff <= 0 || watchdogMaxBackoff < watchdogBackoff {
+		return fmt.Errorf("--watchdog-backoff must be positive and at most --watchdog-max-backoff")
+	}
+
+	return nil
+}
+
+// watchdogState carries the restart backoff from one watchdog run to the
+// next.
+type watchdogState struct {
+	Failures    int           `json:"failures"`
+	Restarts    int           `json:"restarts"`
+	LastRestart time.Time     `json:"last_restart,omitempty"`
+	Backoff     time.Duration `json:"backoff,omitempty"`
+	// LastHealthy is the last check the agent passed
+	LastHealthy time.Time `json:"last_healthy,omitempty"`
+}
+
+func loadWatchdogState() *watchdogState {
+	state := &watchdogState{}
+
+	data, err := os.ReadFile(existingStateFile(distbuildPath, watchdogStateFileName))
+	if err == nil {
+		_ = json.Unmarshal(data, state)
+	}
+
+	return state
+}
+
+func saveWatchdogState(state *watchdogState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeStateFile(distbuildPath, watchdogStateFileName, data)
+}
+
+func agentManagedBySystemd() bool {
+	if !hasSystemd() {
+		return false
+	}
+
+	_, err := os.Stat("/usr/local/bin/distbuild-agent")
+
+	return err == nil
+}
+
+// restartAgent restarts an unhealthy agent, tests replace it.
+var restartAgent = func() error {
+	if agentManagedBySystemd() {
+		var output bytes.Buffer
+		cmd := exec.Command("sudo", "systemctl", "restart", "distbuild.service")
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		if err := runPrivileged(cmd); err != nil {
+			return fmt.Errorf("%w\n%s", err, output.String())
+		}
+		return nil
+	}
+
+	if err := stopAgent(); err != nil {
+		return err
+	}
+
+	return runAgent()
+}
+
+// runWatchdog checks the agent once. An unhealthy agent is restarted unless
+// the backoff of the previous restart still runs, and reported either way.
+func runWatchdog(now time.Time) error {
+	state := loadWatchdogState()
+
+	port, err := runningAgentPort()
+	if err != nil {
+		return err
+	}
+
+	healthErr := checkAgentHealth(&http.Client{Timeout: 5 * time.Second}, port)
+	if healthErr == nil {
+		if state.Failures > 0 {
+			logVerbose("agent recovered after %d failed check(s)", state.Failures)
+		}
+		printMsg("agent.healthy", "port", port)
+		return saveWatchdogState(&watchdogState{LastHealthy: now.UTC()})
+	}
+
+	state.Failures++
+	healthErr = explainAgentFailure(fmt.Errorf("agent on port %d unhealthy: %w", port, healthErr))
+
+	if next := state.LastRestart.Add(state.Backoff); now.Before(next) {
+		err := fmt.Errorf("%w, next restart in %s", healthErr, next.Sub(now).Round(time.Second))
+		sendNotification(newNotification("agent watchdog", now, err))
+		if saveErr := saveWatchdogState(state); saveErr != nil {
+			return saveErr
+		}
+		return err
+	}
+
+	restartErr := restartAgent()
+
+	state.Restarts++
+	state.LastRestart = now
+	state.Backoff = watchdogBackoff
+	for i := 1; i < state.Restarts && state.Backoff < watchdogMaxBackoff; i++ {
+		state.Backoff *= 2
+	}
+	state.Backoff = min(state.Backoff, watchdogMaxBackoff)
+	if err := saveWatchdogState(state); err != nil {
+		return err
+	}
+
+	if restartErr != nil {
+		err := fmt.Errorf("%w, restart failed: %v", healthErr, restartErr)
+		sendNotification(newNotification("agent watchdog", now, err))
+		return err
+	}
+
+	sendNotification(newNotification("agent watchdog", now, fmt.Errorf("%w, restarted", healthErr)))
+	warn("watchdog.restarted", "err", healthErr)
+
+	return nil
+}
+
+// supervisedWatchdog reports whether the supervisor runs the watchdog.
+func supervisedWatchdog() bool {
+	return superviseAgentOn && (watchdogMode == watchdogSupervise || watchdogMode == watchdogAuto)
+}
+
+// watchAgentHealth is the watchdog of the supervisor. It probes the agent
+// every interval and terminates it once unhealthy, the supervisor then
+// restarts it with its own backoff.
+func watchAgentHealth(ctx context.Context, logFile io.Writer, proc *os.Process, port int, started time.Time) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := checkAgentHealth(client, port)
+		if err == nil || time.Since(started) < watchdogStartGrace {
+			continue
+		}
+
+		err = fmt.Errorf("agent on port %d unhealthy: %w", port, err)
+		logAgentEvent(logFile, agentEventError, "%v, terminating it", err)
+		sendNotification(newNotification("agent watchdog", time.Now(), err))
+		_ = terminateAgentProcess(proc, port)
+
+		return
+	}
+}
+
+// installWatchdog installs the watchdog chosen by --watchdog. auto picks
+// the supervisor when it runs, else a systemd timer, else cron.
+func installWatchdog() error {
+	mode := watchdogMode
+
+	if mode == watchdogAuto {
+		switch {
+		case superviseAgentOn:
+			mode = watchdogSupervise
+		case hasSystemd():
+			mode = watchdogTimer
+		default:
+			mode = watchdogCron
+		}
+	}
+
+	switch mode {
+	case "", watchdogSupervise:
+		return nil
+	case watchdogTimer, watchdogCron:
+		if runtime.GOOS == "windows" {
+			return withCode(errCodeUsage, fmt.Errorf("the %s watchdog is not available on windows, use --supervise --watchdog supervise", mode))
+		}
+	}
+
+	command, err := watchdogCommand()
+	if err != nil {
+		return err
+	}
+
+	if mode == watchdogTimer {
+		return installWatchdogTimer(command)
+	}
+
+	return installWatchdogCron(command)
+}
+
+// watchdogCommand is the `agent watchdog` invocation of this install, the
+// current executable has to stay where it is.
+func watchdogCommand() ([]string, error) {
+	return selfCommand("agent", "watchdog",
+		"--distbuild-path", distbuildPath,
+		"--watchdog-backoff", watchdogBackoff.String(),
+		"--watchdog-max-backoff", watchdogMaxBackoff.String(),
+	)
+}
+
+// selfCommand invokes the current executable with args for a timer or cron
+// to run later, carrying over the state directory and env files.
+func selfCommand(args ...string) ([]string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("locate bootstrap executable failed: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return nil, fmt.Errorf("locate bootstrap executable failed: %w", err)
+	}
+
+	command := append([]string{exe}, args...)
+
+	if stateDirFlag != "" {
+		dir, err := expandPath(stateDirFlag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand path: %w", err)
+		}
+		command = append(command, "--state-dir", dir)
+	}
+
+	for _, path := range envFiles {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand path: %w", err)
+		}
+		command = append(command, "--env-file", path)
+	}
+
+	return command, nil
+}
+
+func watchdogUnits(command []string) (string, string) {
+	return timerUnits("distbuild agent watchdog", command, watchdogInterval)
+}
+
+// timerUnits are a oneshot service running command and the timer starting
+// it every interval.
+func timerUnits(description string, command []string, interval time.Duration) (string, string) {
+	service := "[Unit]\n" +
+		"Description=" + description + "\n" +
+		"After=network-online.target\n\n" +
+		"[Service]\n" +
+		"Type=oneshot\n" +
+		"ExecStart=" + quoteCommand(command...) + "\n"
+
+	timer := "[Unit]\n" +
+		"Description=" + description + "\n\n" +
+		"[Timer]\n" +
+		"OnBootSec=" + interval.String() + "\n" +
+		"OnUnitActiveSec=" + interval.String() + "\n" +
+		"AccuracySec=1s\n\n" +
+		"[Install]\n" +
+		"WantedBy=timers.target\n"
+
+	return service, timer
+}
+
+func installWatchdogTimer(command []string) error {
+	service, timer := watchdogUnits(command)
+
+	changed, err := installTimer(watchdogUnit, service, timer)
+	if err != nil || !changed {
+		return err
+	}
+
+	printMsg("watchdog.installed", "kind", "systemd timer", "interval", watchdogInterval)
+
+	return nil
+}
+
+// installTimer installs and enables the service and timer of unit and
+// reports whether anything changed. Unchanged units of an active timer are
+// left alone.
+func installTimer(unit, service, timer string) (bool, error) {
+	serviceChanged, err := installFileWithSudo(service, "/etc/systemd/system/"+unit+".service")
+	if err != nil {
+		return false, err
+	}
+
+	timerChanged, err := installFileWithSudo(timer, "/etc/systemd/system/"+unit+".timer")
+	if err != nil {
+		return false, err
+	}
+
+	if !serviceChanged && !timerChanged && exec.Command("systemctl", "is-active", "--quiet", unit+".timer").Run() == nil {
+		return false, nil
+	}
+
+	for _, args := range [][]string{
+		{"systemctl", "daemon-reload"},
+		{"systemctl", "enable", "--now", unit + ".timer"},
+	} {
+		var output bytes.Buffer
+		cmd := exec.Command("sudo", args...)
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		if err := runPrivileged(cmd); err != nil {
+			return false, fmt.Errorf("command failed [%s]: %w\n%s", strings.Join(cmd.Args, " "), err, output.String())
+		}
+	}
+
+	noteChange("enable " + unit + ".timer")
+
+	return true, nil
+}
+
+// watchdogCronLine runs the watchdog every interval, rounded up to whole
+// minutes. A % ends the command in crontab and has to be escaped.
+func watchdogCronLine(command []string, user string) string {
+	minutes := int((watchdogInterval + time.Minute - 1) / time.Minute)
+
+	schedule := "* * * * *"
+	if minutes > 1 {
+		schedule = fmt.Sprintf("*/%d * * * *", min(minutes, 59))
+	}
+	if user != "" {
+		schedule += " " + user
+	}
+
+	line := quoteCommand(command...) + " >/dev/null 2>&1"
+
+	return schedule + " " + strings.ReplaceAll(line, "%", `\%`) + " " + watchdogCronMarker + "\n"
+}
+
+// installWatchdogCron adds a system cron entry as root, else an entry in
+// the crontab of the current user that replaces the previous one.
+func installWatchdogCron(command []string) error {
+	if isRoot() {
+		line := []byte(watchdogCronLine(command, "root"))
+		if unchangedFile(watchdogCronPath, line, policyFileMode(0644)) {
+			return nil
+		}
+		if err := writeFileAtomic(watchdogCronPath, line, 0644); err != nil {
+			return fmt.Errorf("write %s failed: %w", watchdogCronPath, err)
+		}
+		printMsg("watchdog.installed", "kind", "cron", "interval", watchdogInterval)
+		return nil
+	}
+
+	current, err := exec.Command("crontab", "-l").Output()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("read crontab failed: %w", err)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(current), "\n") {
+		if line != "" && !strings.HasSuffix(line, watchdogCronMarker) {
+			lines = append(lines, line)
+		}
+	}
+
+	crontab := strings.Join(lines, "\n")
+	if crontab != "" {
+		crontab += "\n"
+	}
+	crontab += watchdogCronLine(command, "")
+
+	if crontab == string(current) {
+		return nil
+	}
+
+	var output bytes.Buffer
+	cmd := exec.Command("crontab", "-")
+	cmd.Stdin = strings.NewReader(crontab)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("install crontab failed: %w\n%s", err, output.String())
+	}
+
+	noteChange("install crontab")
+	printMsg("watchdog.installed", "kind", "cron", "interval", watchdogInterval)
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunWatchdog(t *testing.T) {
+	defer func(path string, restart func() error, backoff, maxBackoff time.Duration) {
+		distbuildPath, restartAgent, watchdogBackoff, watchdogMaxBackoff = path, restart, backoff, maxBackoff
+	}(distbuildPath, restartAgent, watchdogBackoff, watchdogMaxBackoff)
+
+	healthy := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	_, portStr, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	distbuildPath, watchdogBackoff, watchdogMaxBackoff = t.TempDir(), time.Minute, 3*time.Minute
+	t.Setenv("NOTIFY_SINKS", "")
+	t.Setenv("WEBHOOK_URL", "")
+	assert.NoError(t, saveAgentState(&agentState{Port: port}))
+
+	restarts := 0
+	restartAgent = func() error {
+		restarts++
+		return nil
+	}
+
+	now := time.Now()
+	captureStdout(t, func() {
+		assert.NoError(t, runWatchdog(now))
+	})
+	assert.Equal(t, 1, restarts)
+	assert.Equal(t, time.Minute, loadWatchdogState().Backoff)
+
+	// Within the backoff the agent is only reported
+	captureStdout(t, func() {
+		assert.ErrorContains(t, runWatchdog(now.Add(30*time.Second)), "next restart in 30s")
+	})
+	assert.Equal(t, 1, restarts)
+
+	// Consecutive restarts double the backoff up to the maximum
+	for i, want := range []time.Duration{2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		now = now.Add(5 * time.Minute)
+		captureStdout(t, func() {
+			assert.NoError(t, runWatchdog(now))
+		})
+		assert.Equal(t, i+2, restarts)
+		assert.Equal(t, want, loadWatchdogState().Backoff)
+	}
+
+	healthy = true
+	output := captureStdout(t, func() {
+		assert.NoError(t, runWatchdog(now))
+	})
+	assert.Contains(t, output, "agent healthy")
+	assert.Equal(t, watchdogState{LastHealthy: now.UTC().Round(0)}, *loadWatchdogState())
+}
+
+func TestWatchdogInstallFiles(t *testing.T) {
+	defer func(interval time.Duration) {
+		watchdogInterval = interval
+	}(watchdogInterval)
+
+	command := []string{"/opt/bootstrap", "agent", "watchdog", "--distbuild-path", "/opt/distbuild dir", "--state-dir", "/var/lib/50%"}
+
+	watchdogInterval = 90 * time.Second
+	assert.Equal(t,
+		"*/2 * * * * root /opt/bootstrap agent watchdog --distbuild-path '/opt/distbuild dir' --state-dir /var/lib/50\\% >/dev/null 2>&1 # distbuild-watchdog\n",
+		watchdogCronLine(command, "root"))
+
+	watchdogInterval = 30 * time.Second
+	assert.Equal(t, "* * * * * /opt/bootstrap", watchdogCronLine(command, "")[:24])
+
+	service, timer := watchdogUnits(command)
+	assert.Contains(t, service, "Type=oneshot\nExecStart=/opt/bootstrap agent watchdog --distbuild-path '/opt/distbuild dir'")
+	assert.Contains(t, timer, "OnUnitActiveSec=30s\n")
+}
+
+func TestCheckWatchdogFlags(t *testing.T) {
+	defer func(mode string, supervise bool, interval, backoff, maxBackoff time.Duration) {
+		watchdogMode, superviseAgentOn, watchdogInterval, watchdogBackoff, watchdogMaxBackoff = mode, supervise, interval, backoff, maxBackoff
+	}(watchdogMode, superviseAgentOn, watchdogInterval, watchdogBackoff, watchdogMaxBackoff)
+
+	watchdogMode, superviseAgentOn, watchdogInterval, watchdogBackoff, watchdogMaxBackoff = watchdogTimer, false, time.Minute, time.Minute, time.Hour
+	assert.NoError(t, checkWatchdogFlags())
+
+	watchdogMode = watchdogSupervise
+	assert.ErrorContains(t, checkWatchdogFlags(), "needs --supervise")
+	superviseAgentOn = true
+	assert.NoError(t, checkWatchdogFlags())
+	assert.True(t, supervisedWatchdog())
+
+	watchdogMode = "launchd"
+	assert.Error(t, checkWatchdogFlags())
+
+	watchdogMode, watchdogMaxBackoff = watchdogCron, time.Second
+	assert.Error(t, checkWatchdogFlags())
+}
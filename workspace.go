@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	updateAllWorkspaces bool
+	purgeWorkspace      bool
+)
+
+type workspaceRegistry struct {
+	Workspaces []workspaceEntry `json:"workspaces"`
+}
+
+type workspaceEntry struct {
+	Path          string            `json:"path"`
+	DistbuildPath string            `json:"distbuild_path"`
+	Checkout      string            `json:"checkout"`
+	Toolchains    map[string]string `json:"toolchains,omitempty"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
+
+var workspaceCmd = &cobra.Command{
+	Use:   "workspace",
+	Short: "manage provisioned AOSP workspaces",
+}
+
+var workspaceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list provisioned workspaces",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := listWorkspaces(); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, msg("error", "text", err.Error()))
+			os.Exit(1)
+		}
+	},
+}
+
+var workspaceUpdateCmd = &cobra.Command{
+	Use:   "update [path...]",
+	Short: "update the distbuild checkout of workspaces",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 && !updateAllWorkspaces {
+			_, _ = fmt.Fprintln(os.Stderr, msg("error", "text", msg("workspace.required")))
+			os.Exit(1)
+		}
+		if err := updateWorkspaces(args); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, msg("error", "text", err.Error()))
+			os.Exit(1)
+		}
+	},
+}
+
+var workspaceRemoveCmd = &cobra.Command{
+	Use:   "remove path...",
+	Short: "forget workspaces",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := removeWorkspaces(args); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, msg("error", "text", err.Error()))
+			os.Exit(1)
+		}
+	},
+}
+
+// nolint:gochecknoinits
+func init() {
+	workspaceUpdateCmd.Flags().BoolVar(&updateAllWorkspaces, "all", false, "update every registered workspace")
+	workspaceRemoveCmd.Flags().BoolVar(&purgeWorkspace, "purge", false, "also delete the distbuild checkout")
+
+	workspaceCmd.AddCommand(workspaceListCmd)
+	workspaceCmd.AddCommand(workspaceUpdateCmd)
+	workspaceCmd.AddCommand(workspaceRemoveCmd)
+}
+
+func registryPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "distbuild", "workspaces.json"), nil
+}
+
+func loadRegistry() (*workspaceRegistry, error) {
+	path, err := registryPath()
+	if err != nil {
+		return nil, err
+	}
+
+	registry := &workspaceRegistry{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return registry, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, registry); err != nil {
+		return nil, fmt.Errorf("parse workspace registry failed: %w", err)
+	}
+
+	return registry, nil
+}
+
+func saveRegistry(registry *workspaceRegistry) error {
+	path, err := registryPath()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(registry.Workspaces, func(i, j int) bool {
+		return registry.Workspaces[i].Path < registry.Workspaces[j].Path
+	})
+
+	data, err := json.MarshalIndent(registry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := createDirs(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data, 0644)
+}
+
+func (r *workspaceRegistry) find(path string) int {
+	for i, w := range r.Workspaces {
+		if w.Path == path {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// registerWorkspace records the provisioned workspace in the user registry.
+func registerWorkspace() error {
+	_, _, subPath, err := distbuildRepoSource()
+	if err != nil {
+		return err
+	}
+
+	path, err := filepath.Abs(aospPath)
+	if err != nil {
+		return err
+	}
+
+	registry, err := loadRegistry()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := loadManifest()
+	if err != nil {
+		return err
+	}
+
+	entry := workspaceEntry{
+		Path:          path,
+		DistbuildPath: distbuildPath,
+		Checkout:      filepath.Join(path, subPath),
+		UpdatedAt:     time.Now().UTC(),
+	}
+
+	// Remember the toolchain versions the workspace uses, prune keeps them
+	if len(manifest.Toolchains) > 0 {
+		entry.Toolchains = map[string]string{}
+		for name, tc := range manifest.Toolchains {
+			entry.Toolchains[name] = tc.Revision
+		}
+	}
+
+	if i := registry.find(path); i >= 0 {
+		// An unchanged workspace keeps its registration as it is
+		entry.UpdatedAt = registry.Workspaces[i].UpdatedAt
+		if reflect.DeepEqual(registry.Workspaces[i], entry) {
+			return nil
+		}
+		entry.UpdatedAt = time.Now().UTC()
+		registry.Workspaces[i] = entry
+	} else {
+		registry.Workspaces = append(registry.Workspaces, entry)
+	}
+
+	return saveRegistry(registry)
+}
+
+func workspaceState(w workspaceEntry) (string, string) {
+	if _, err := os.Stat(w.Path); err != nil {
+		return "missing", ""
+	}
+
+	out, err := gitOutput("-C", w.Checkout, "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return "no checkout", ""
+	}
+
+	commit := strings.TrimSpace(string(out))
+
+	out, err = gitOutput("-C", w.Checkout, "status", "--porcelain")
+	if err == nil && len(strings.TrimSpace(string(out))) > 0 {
+		return "modified", commit
+	}
+
+	return "ok", commit
+}
+
+func listWorkspaces() error {
+	registry, err := loadRegistry()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, msg("workspace.header"))
+
+	for _, ws := range registry.Workspaces {
+		state, commit := workspaceState(ws)
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", ws.Path, state, commit, ws.UpdatedAt.Local().Format(time.DateTime))
+	}
+
+	return w.Flush()
+}
+
+func selectWorkspaces(registry *workspaceRegistry, paths []string) ([]int, error) {
+	var selected []int
+
+	if len(paths) == 0 {
+		for i := range registry.Workspaces {
+			selected = append(selected, i)
+		}
+		return selected, nil
+	}
+
+	for _, path := range paths {
+		path, err := expandPath(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand path: %w", err)
+		}
+		i := registry.find(path)
+		if i < 0 {
+			return nil, fmt.Errorf("workspace %s is not registered", path)
+		}
+		selected = append(selected, i)
+	}
+
+	return selected, nil
+}
+
+func updateWorkspaces(paths []string) error {
+	registry, err := loadRegistry()
+	if err != nil {
+		return err
+	}
+
+	selected, err := selectWorkspaces(registry, paths)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+
+	for _, i := range selected {
+		ws := &registry.Workspaces[i]
+		ctx, cancel := gitContext()
+		output, err := gitCommand(ctx, "-C", ws.Checkout, "pull", "--ff-only").CombinedOutput()
+		err = gitTimeoutError(ctx, "git pull", err)
+		cancel()
+		if err != nil {
+			fmt.Print(msg("workspace.failed", "path", ws.Path, "err", err, "output", string(output)))
+			failed = append(failed, ws.Path)
+			continue
+		}
+		ws.UpdatedAt = time.Now().UTC()
+		printMsg("workspace.updated", "path", ws.Path)
+	}
+
+	if err := saveRegistry(registry); err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d workspace(s) failed to update", len(failed))
+	}
+
+	return nil
+}
+
+func removeWorkspaces(paths []string) error {
+	registry, err := loadRegistry()
+	if err != nil {
+		return err
+	}
+
+	selected, err := selectWorkspaces(registry, paths)
+	if err != nil {
+		return err
+	}
+
+	remove := map[int]bool{}
+	for _, i := range selected {
+		remove[i] = true
+		if purgeWorkspace {
+			if err := confirmRemoval("workspace checkout", registry.Workspaces[i].Checkout); err != nil {
+				return err
+			}
+			if err := removeAll(registry.Workspaces[i].Checkout); err != nil {
+				return fmt.Errorf("remove checkout failed: %w", err)
+			}
+		}
+	}
+
+	kept := registry.Workspaces[:0]
+	for i, ws := range registry.Workspaces {
+		if !remove[i] {
+			kept = append(kept, ws)
+		}
+	}
+
+	registry.Workspaces = kept
+
+	return saveRegistry(registry)
+}
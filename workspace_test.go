@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkspaceRegistry(t *testing.T) {
+	defer func(aosp, distbuild string) {
+		aospPath, distbuildPath = aosp, distbuild
+	}(aospPath, distbuildPath)
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("REPO_HOST", "https://host")
+	t.Setenv("DISTBUILD_REPO", "distbuild")
+
+	aospPath, distbuildPath = t.TempDir(), t.TempDir()
+	assert.NoError(t, registerWorkspace())
+	assert.NoError(t, registerWorkspace())
+
+	registry, err := loadRegistry()
+	assert.NoError(t, err)
+	assert.Len(t, registry.Workspaces, 1)
+	assert.Equal(t, aospPath, registry.Workspaces[0].Path)
+
+	assert.Error(t, removeWorkspaces([]string{"/not/registered"}))
+	assert.NoError(t, removeWorkspaces([]string{aospPath}))
+
+	registry, err = loadRegistry()
+	assert.NoError(t, err)
+	assert.Empty(t, registry.Workspaces)
+}